@@ -0,0 +1,168 @@
+package kono
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/kono/internal/accesslog"
+)
+
+// Access log outputs besides stdout.
+const (
+	accessLogOutputFile   = "file"
+	accessLogOutputSyslog = "syslog"
+)
+
+// redactedQueryValue replaces query values in access log paths.
+const redactedQueryValue = "REDACTED"
+
+// accessRecord collects what the access log reports about a request while the
+// router handles it. Upstreams are added concurrently by the scatter.
+type accessRecord struct {
+	mu        sync.Mutex
+	flow      string
+	requestID string
+	upstreams []accesslog.Upstream
+}
+
+func (rec *accessRecord) setFlow(flow, requestID string) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.flow, rec.requestID = flow, requestID
+}
+
+func (rec *accessRecord) addUpstream(name string, status int, duration time.Duration, err *upstreamError) {
+	u := accesslog.Upstream{Name: name, Status: status, Duration: duration}
+	if err != nil {
+		u.Error = err.Error()
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.upstreams = append(rec.upstreams, u)
+}
+
+// recordUpstream adds an upstream call to the access record of ctx, if any.
+func recordUpstream(ctx context.Context, name string, resp *upstreamResponse) {
+	if rec := accessRecordFromContext(ctx); rec != nil {
+		rec.addUpstream(name, resp.status, resp.latency, resp.err)
+	}
+}
+
+// logAccess writes the access log entry of a request the router finished.
+func (r *Router) logAccess(req *http.Request, aw *accessWriter, body *countingBody, rec *accessRecord, start time.Time) {
+	rec.mu.Lock()
+	entry := accesslog.Entry{
+		Time:      start,
+		Method:    req.Method,
+		Path:      redactQuery(req.URL),
+		Proto:     req.Proto,
+		Flow:      rec.flow,
+		Status:    aw.status,
+		Duration:  time.Since(start),
+		Upstreams: rec.upstreams,
+		ClientIP:  extractClientIP(req, r.trustedProxies),
+		RequestID: rec.requestID,
+		BytesOut:  aw.bytes,
+		UserAgent: req.UserAgent(),
+		Referer:   req.Referer(),
+	}
+	rec.mu.Unlock()
+
+	if body != nil {
+		entry.BytesIn = body.n
+	}
+
+	if entry.RequestID == "" {
+		entry.RequestID = aw.Header().Get("X-Request-ID")
+	}
+
+	if entry.Status == 0 {
+		entry.Status = http.StatusOK
+	}
+
+	if err := r.accessLog.Log(entry); err != nil {
+		r.log.Error("access log write failed", zap.Error(err))
+	}
+}
+
+// redactQuery returns the request URI with every query value replaced, since
+// queries often carry tokens or personal data. Parameter names are kept.
+func redactQuery(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	if u.RawQuery == "" {
+		return path
+	}
+
+	params := strings.Split(u.RawQuery, "&")
+	for i, param := range params {
+		if name, _, ok := strings.Cut(param, "="); ok {
+			params[i] = name + "=" + redactedQueryValue
+		}
+	}
+
+	return path + "?" + strings.Join(params, "&")
+}
+
+// accessWriter records the status and body size of the response.
+type accessWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (aw *accessWriter) WriteHeader(code int) {
+	if aw.status == 0 {
+		aw.status = code
+	}
+
+	aw.ResponseWriter.WriteHeader(code)
+}
+
+func (aw *accessWriter) Write(b []byte) (int, error) {
+	if aw.status == 0 {
+		aw.status = http.StatusOK
+	}
+
+	n, err := aw.ResponseWriter.Write(b)
+	aw.bytes += int64(n)
+
+	return n, err
+}
+
+func (aw *accessWriter) Flush() {
+	if f, ok := aw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection for deadlines.
+func (aw *accessWriter) Unwrap() http.ResponseWriter {
+	return aw.ResponseWriter
+}
+
+// countingBody counts the request body bytes read.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+
+	return n, err
+}
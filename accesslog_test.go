@@ -0,0 +1,283 @@
+package kono
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/kono/internal/accesslog"
+)
+
+var _ = Describe("access log", func() {
+	var (
+		router *Router
+		out    *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(`{"id":1}`))
+		}))
+		DeferCleanup(backend.Close)
+
+		bundle, err := NewRouter(context.Background(), RoutingConfigSet{
+			Service: ServiceConfig{Name: "kono-test"},
+			Routing: RoutingConfig{
+				Flows: []FlowConfig{{
+					Path:              "/items",
+					Method:            http.MethodPost,
+					ParallelUpstreams: 1,
+					Aggregation:       &AggregationConfig{Strategy: "array"},
+					Upstreams: []UpstreamConfig{{
+						Name:    "items",
+						Hosts:   AddrList{backend.URL},
+						Path:    "/items",
+						Method:  http.MethodPost,
+						Timeout: time.Second,
+					}},
+				}},
+			},
+			AccessLog: AccessLogConfig{Enabled: true},
+		}, zap.NewNop())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(bundle.Router.accessLog).NotTo(BeNil())
+
+		router = bundle.Router
+		out = &bytes.Buffer{}
+	})
+
+	serve := func(format, tmpl string, req *http.Request) *httptest.ResponseRecorder {
+		l, err := accesslog.New(format, tmpl, out)
+		Expect(err).NotTo(HaveOccurred())
+		router.accessLog = l
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	It("writes a JSON entry per request", func() {
+		req := httptest.NewRequest(http.MethodPost, "/items?page=2&token=s3cret&debug", strings.NewReader("hello"))
+		req.Header.Set("User-Agent", "test-agent")
+		req.RemoteAddr = "203.0.113.9:4000"
+
+		rec := serve(accesslog.FormatJSON, "", req)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+
+		var entry map[string]any
+		Expect(json.Unmarshal(out.Bytes(), &entry)).To(Succeed())
+
+		Expect(entry).To(HaveKeyWithValue("method", "POST"))
+		Expect(entry).To(HaveKeyWithValue("path", "/items?page=REDACTED&token=REDACTED&debug"))
+		Expect(entry).To(HaveKeyWithValue("flow", "/items"))
+		Expect(entry).To(HaveKeyWithValue("status", BeNumerically("==", 200)))
+		Expect(entry).To(HaveKeyWithValue("client_ip", "203.0.113.9"))
+		Expect(entry).To(HaveKeyWithValue("request_id", rec.Header().Get("X-Request-ID")))
+		Expect(entry).To(HaveKeyWithValue("bytes_in", BeNumerically("==", 5)))
+		Expect(entry).To(HaveKeyWithValue("bytes_out", BeNumerically("==", rec.Body.Len())))
+		Expect(entry).To(HaveKeyWithValue("user_agent", "test-agent"))
+		Expect(entry["upstreams"]).To(ConsistOf(SatisfyAll(
+			HaveKeyWithValue("name", "items"),
+			HaveKeyWithValue("status", BeNumerically("==", 200)),
+		)))
+	})
+
+	It("logs requests that match no flow", func() {
+		serve(accesslog.FormatJSON, "", httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+		var entry map[string]any
+		Expect(json.Unmarshal(out.Bytes(), &entry)).To(Succeed())
+		Expect(entry).To(HaveKeyWithValue("status", BeNumerically("==", 404)))
+		Expect(entry).NotTo(HaveKey("flow"))
+	})
+
+	It("writes the Apache combined format", func() {
+		req := httptest.NewRequest(http.MethodPost, "/items", nil)
+		req.Header.Set("Referer", "https://example.com/")
+		req.RemoteAddr = "203.0.113.9:4000"
+
+		rec := serve(accesslog.FormatCombined, "", req)
+
+		Expect(out.String()).To(MatchRegexp(
+			`^203\.0\.113\.9 - - \[[^\]]+\] "POST /items HTTP/1\.1" 200 %d "https://example\.com/" "-"\n$`, rec.Body.Len()))
+	})
+
+	It("writes a custom template", func() {
+		serve(accesslog.FormatTemplate, `{{.Method}} {{.Flow}} {{.Status}}{{range .Upstreams}} {{.Name}}={{.Status}}{{end}}`,
+			httptest.NewRequest(http.MethodPost, "/items", nil))
+
+		Expect(out.String()).To(Equal("POST /items 200 items=200\n"))
+	})
+
+	Describe("reload", func() {
+		entry := accesslog.Entry{Method: http.MethodGet, Path: "/items", Proto: "HTTP/1.1", Status: http.StatusOK}
+
+		It("hands the log over to the router replacing it", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "access.log")
+			build := func(format string, proxies []string, prev *Router) (*Router, error) {
+				bundle, err := NewRouter(context.Background(), RoutingConfigSet{
+					Service: ServiceConfig{Name: "kono-test"},
+					Routing: RoutingConfig{TrustedProxies: proxies},
+					AccessLog: AccessLogConfig{
+						Enabled: true,
+						Format:  format,
+						Output:  accessLogOutputFile,
+						File:    &AccessLogFileConfig{Path: path},
+					},
+					Previous: prev,
+				}, zap.NewNop())
+
+				return bundle.Router, err
+			}
+
+			old, err := build(accesslog.FormatJSON, nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			// A failed reload leaves the log with the router it came from.
+			_, err = build(accesslog.FormatJSON, []string{"not-a-cidr"}, old)
+			Expect(err).To(HaveOccurred())
+			Expect(old.accessLog.Log(entry)).To(Succeed())
+
+			next, err := build(accesslog.FormatJSON, nil, old)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(next.accessLog).To(BeIdenticalTo(old.accessLog))
+
+			Expect(old.Shutdown(context.Background())).To(Succeed())
+			Expect(next.accessLog.Log(entry)).To(Succeed())
+
+			changed, err := build(accesslog.FormatCombined, nil, next)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changed.accessLog).NotTo(BeIdenticalTo(next.accessLog))
+
+			Expect(next.Shutdown(context.Background())).To(Succeed())
+			Expect(next.accessLog.Log(entry)).NotTo(Succeed())
+
+			Expect(changed.accessLog.Log(entry)).To(Succeed())
+			Expect(changed.Shutdown(context.Background())).To(Succeed())
+
+			data, err := os.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(strings.Count(string(data), "\n")).To(Equal(3))
+		})
+	})
+
+	Describe("outputs", func() {
+		entry := accesslog.Entry{Method: http.MethodGet, Path: "/items", Proto: "HTTP/1.1", Status: http.StatusOK}
+
+		It("rotates the file by size and keeps max_backups files", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "logs", "access.log")
+
+			w, err := accesslog.OpenFile(accesslog.FileOptions{Path: path, MaxSize: 300, MaxBackups: 2})
+			Expect(err).NotTo(HaveOccurred())
+
+			l, err := accesslog.New(accesslog.FormatJSON, "", w)
+			Expect(err).NotTo(HaveOccurred())
+
+			for range 20 {
+				Expect(l.Log(entry)).To(Succeed())
+				time.Sleep(2 * time.Millisecond) // Distinct backup names.
+			}
+
+			Expect(l.Close()).To(Succeed())
+			Expect(l.Log(entry)).To(MatchError(os.ErrClosed))
+
+			backups, err := filepath.Glob(path + ".*")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(backups).To(HaveLen(2))
+
+			for _, name := range append(backups, path) {
+				info, statErr := os.Stat(name)
+				Expect(statErr).NotTo(HaveOccurred())
+				Expect(info.Size()).To(BeNumerically("<=", 300))
+			}
+		})
+
+		It("rotates the file by age", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "access.log")
+
+			w, err := accesslog.OpenFile(accesslog.FileOptions{Path: path, MaxAge: 20 * time.Millisecond})
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(w.Close)
+
+			l, err := accesslog.New(accesslog.FormatCombined, "", w)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(l.Log(entry)).To(Succeed())
+			time.Sleep(30 * time.Millisecond)
+			Expect(l.Log(entry)).To(Succeed())
+
+			backups, _ := filepath.Glob(path + ".*")
+			Expect(backups).To(HaveLen(1))
+		})
+
+		It("keeps writing when a rotation fails", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "access.log")
+
+			w, err := accesslog.OpenFile(accesslog.FileOptions{Path: path, MaxSize: 10})
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(w.Close)
+
+			_, err = w.Write([]byte("first\n"))
+			Expect(err).NotTo(HaveOccurred())
+
+			// The rename of the next rotation fails: there is no file to move.
+			Expect(os.Remove(path)).To(Succeed())
+
+			_, err = w.Write([]byte("second\n"))
+			Expect(err).To(MatchError(ContainSubstring("rotate access log file")))
+			Expect(os.ReadFile(path)).To(Equal([]byte("second\n")))
+
+			_, err = w.Write([]byte("third\n"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("sends entries to syslog", func() {
+			if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+				Skip("syslog is not supported on " + runtime.GOOS)
+			}
+
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(conn.Close)
+
+			l, err := initAccessLog(AccessLogConfig{
+				Enabled: true,
+				Format:  accesslog.FormatCombined,
+				Output:  accessLogOutputSyslog,
+				Syslog: &AccessLogSyslogConfig{
+					Network:  "udp",
+					Address:  conn.LocalAddr().String(),
+					Facility: "local3",
+					Tag:      "kono",
+				},
+			}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(l.Close)
+
+			Expect(l.Log(entry)).To(Succeed())
+
+			buf := make([]byte, 1024)
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, _, err := conn.ReadFrom(buf)
+			Expect(err).NotTo(HaveOccurred())
+
+			// local3 (19) * 8 + info (6).
+			Expect(string(buf[:n])).To(HavePrefix("<158>"))
+			Expect(string(buf[:n])).To(ContainSubstring(`kono[`))
+			Expect(string(buf[:n])).To(ContainSubstring(`"GET /items HTTP/1.1" 200 -`))
+		})
+	})
+})
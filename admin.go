@@ -0,0 +1,221 @@
+package kono
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// NewAdminHandler serves the runtime admin API of the router. When token is set,
+// requests must carry it as a bearer token.
+//
+//	GET  /upstreams/{name}/groups                 host groups and their weights
+//	PUT  /upstreams/{name}/groups                 set weights: {"weights":{"blue":0,"green":100}}
+//	POST /upstreams/{name}/groups/{group}/activate send all traffic to one group
+//	POST /cache/purge                             drop cached responses: {"flow":"GET /users/{id}","pattern":"GET /users/4*","tag":"users"}
+//	GET  /config                                  effective routing configuration as YAML, secrets digested
+//
+// Upstream operations apply to every upstream with the given name. Purge filters
+// are combined; at least one is required.
+func NewAdminHandler(r *Router, token string, log *zap.Logger) http.Handler {
+	a := &admin{router: r, log: log}
+
+	mux := chi.NewRouter()
+	mux.Use(adminAuth(token))
+
+	mux.Get("/upstreams/{name}/groups", a.groups)
+	mux.Put("/upstreams/{name}/groups", a.setWeights)
+	mux.Post("/upstreams/{name}/groups/{group}/activate", a.activate)
+	mux.Post("/cache/purge", a.purgeCache)
+	mux.Get("/config", a.config)
+
+	return mux
+}
+
+type admin struct {
+	router *Router
+	log    *zap.Logger
+
+	// mu serializes weight changes, so concurrent ones cannot leave the upstreams
+	// of one name with weights from different requests.
+	mu sync.Mutex
+}
+
+type adminGroup struct {
+	Name   string   `json:"name"`
+	Weight int      `json:"weight"`
+	Hosts  []string `json:"hosts"`
+}
+
+func adminAuth(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if token == "" {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			got, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				writeAdminError(w, http.StatusUnauthorized, "invalid admin token")
+				return
+			}
+
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// splitUpstreams returns the upstreams called name that split their traffic
+// between host groups.
+func (a *admin) splitUpstreams(name string) []*httpUpstream {
+	var found []*httpUpstream
+
+	for i := range a.router.flows {
+		for _, up := range a.router.flows[i].upstreams {
+			if hu, ok := up.(*httpUpstream); ok && hu.name() == name && hu.split.Load() != nil {
+				found = append(found, hu)
+			}
+		}
+	}
+
+	return found
+}
+
+func (a *admin) groups(w http.ResponseWriter, req *http.Request) {
+	ups := a.splitUpstreams(chi.URLParam(req, "name"))
+	if len(ups) == 0 {
+		writeAdminError(w, http.StatusNotFound, "no upstream with host groups by that name")
+		return
+	}
+
+	split := ups[0].split.Load()
+
+	groups := make([]adminGroup, 0, len(split.groups))
+	for _, g := range split.groups {
+		groups = append(groups, adminGroup{Name: g.name, Weight: g.weight, Hosts: g.set.hosts})
+	}
+
+	writeAdminJSON(w, http.StatusOK, map[string]any{"groups": groups})
+}
+
+func (a *admin) setWeights(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Weights map[string]int `json:"weights"`
+	}
+
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeAdminError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+		return
+	}
+
+	a.reweight(w, req, body.Weights)
+}
+
+func (a *admin) activate(w http.ResponseWriter, req *http.Request) {
+	a.reweight(w, req, map[string]int{chi.URLParam(req, "group"): 100})
+}
+
+// reweight applies weights to all upstreams of the request's name. Groups missing
+// from weights get no traffic. Nothing changes unless every upstream accepts them.
+func (a *admin) reweight(w http.ResponseWriter, req *http.Request, weights map[string]int) {
+	name := chi.URLParam(req, "name")
+
+	ups := a.splitUpstreams(name)
+	if len(ups) == 0 {
+		writeAdminError(w, http.StatusNotFound, "no upstream with host groups by that name")
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	next := make([]*hostSplit, len(ups))
+
+	for i, up := range ups {
+		split, err := up.split.Load().withWeights(weights)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		next[i] = split
+	}
+
+	for i, up := range ups {
+		up.split.Store(next[i])
+	}
+
+	a.log.Info("upstream group weights changed", zap.String("upstream", name), zap.Any("weights", weights))
+
+	a.groups(w, req)
+}
+
+func (a *admin) purgeCache(w http.ResponseWriter, req *http.Request) {
+	var p cachePurge
+
+	if err := json.NewDecoder(req.Body).Decode(&p); err != nil {
+		writeAdminError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+		return
+	}
+
+	if p == (cachePurge{}) {
+		writeAdminError(w, http.StatusBadRequest, "one of flow, pattern or tag is required")
+		return
+	}
+
+	purged := 0
+
+	for i := range a.router.flows {
+		cache := a.router.flows[i].cache
+		if cache == nil {
+			continue
+		}
+
+		n, err := cache.purge(req.Context(), p)
+		purged += n
+
+		if err != nil {
+			a.log.Error("cache purge failed", zap.String("flow", cache.flow), zap.Error(err))
+			writeAdminError(w, http.StatusBadGateway, "cache purge failed: "+err.Error())
+
+			return
+		}
+	}
+
+	a.log.Info("cache purged", zap.Any("filter", p), zap.Int("purged", purged))
+
+	writeAdminJSON(w, http.StatusOK, map[string]int{"purged": purged})
+}
+
+func (a *admin) config(w http.ResponseWriter, _ *http.Request) {
+	snapshot, err := RoutingSnapshot(a.router.routing)
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	data, err := yaml.Marshal(snapshot)
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	_, _ = w.Write(data)
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(mustMarshal(v))
+}
+
+func writeAdminError(w http.ResponseWriter, status int, msg string) {
+	writeAdminJSON(w, status, map[string]string{"error": msg})
+}
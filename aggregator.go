@@ -6,6 +6,8 @@ import (
 	"slices"
 
 	"go.uber.org/zap"
+
+	"github.com/starwalkn/kono/sdk"
 )
 
 type aggregatedResponse struct {
@@ -34,12 +36,15 @@ var (
 // aggregate combines multiple upstream responses based on the flow's strategy.
 // A single response is returned as-is. Multiple responses are aggregated either
 // by merging JSON objects ("merge"), creating a JSON array ("array"),
-// or namespacing each upstream under its name ("namespace").
+// flattening JSON arrays into one ("concat"),
+// namespacing each upstream under its name ("namespace"), or keeping the response
+// that won the race ("first"). The "custom" strategy
+// delegates to the flow's sdk.Aggregator, even for a single response.
 // Upstream errors respect bestEffort: partial results may be returned
 // if allowed, otherwise a single error response is returned.
 func (a *defaultAggregator) aggregate(upstreams []upstream, responses []upstreamResponse, agg aggregation, log *zap.Logger) aggregatedResponse {
-	if len(responses) == 1 {
-		return a.rawResponse(responses[0])
+	if len(responses) == 1 && agg.strategy != strategyCustom {
+		return a.rawResponse(responses[0], agg)
 	}
 
 	switch agg.strategy {
@@ -47,22 +52,28 @@ func (a *defaultAggregator) aggregate(upstreams []upstream, responses []upstream
 		return a.merged(responses, agg, log)
 	case strategyArray:
 		return a.arrayed(responses, agg, log)
+	case strategyConcat:
+		return a.concatenated(responses, agg, log)
 	case strategyNamespace:
 		return a.namespaced(upstreams, responses, agg, log)
+	case strategyFirst:
+		return a.first(responses, agg)
+	case strategyCustom:
+		return a.customized(upstreams, responses, agg, log)
 	default:
 		log.Error("unknown aggregation strategy", zap.String("strategy", agg.strategy.String()))
 		return aggregatedResponse{}
 	}
 }
 
-func (a *defaultAggregator) rawResponse(resp upstreamResponse) aggregatedResponse {
+func (a *defaultAggregator) rawResponse(resp upstreamResponse, agg aggregation) aggregatedResponse {
 	if resp.err != nil {
 		return aggregatedResponse{errors: []ClientError{a.mapUpstreamError(resp.err)}}
 	}
 
 	return aggregatedResponse{
 		data:    resp.body,
-		headers: mergeSuccessfulHeaders([]upstreamResponse{resp}),
+		headers: agg.headers.merge([]upstreamResponse{resp}),
 	}
 }
 
@@ -90,7 +101,7 @@ func (a *defaultAggregator) merged(responses []upstreamResponse, agg aggregation
 
 	return aggregatedResponse{
 		data:    data,
-		headers: mergeSuccessfulHeaders(responses),
+		headers: agg.headers.merge(responses),
 		errors:  dedupeErrors(aggErrors),
 		partial: len(aggErrors) > 0 && hasSuccessful,
 	}
@@ -124,9 +135,8 @@ func (a *defaultAggregator) collectFields(
 			continue
 		}
 
-		hasSuccessful = true
-
 		if resp.body == nil {
+			hasSuccessful = true
 			continue
 		}
 
@@ -148,6 +158,8 @@ func (a *defaultAggregator) collectFields(
 				return nil, nil, false, r
 			}
 		}
+
+		hasSuccessful = true
 	}
 
 	return fields, aggErrors, hasSuccessful, nil
@@ -218,7 +230,68 @@ func (a *defaultAggregator) arrayed(responses []upstreamResponse, agg aggregatio
 
 	return aggregatedResponse{
 		data:    data,
-		headers: mergeSuccessfulHeaders(responses),
+		headers: agg.headers.merge(responses),
+		errors:  dedupeErrors(aggErrors),
+		partial: len(aggErrors) > 0 && hasSuccessful,
+	}
+}
+
+// concatenated flattens the JSON arrays returned by the upstreams into a single array,
+// keeping upstream order. A body that is not an array is treated as malformed.
+func (a *defaultAggregator) concatenated(responses []upstreamResponse, agg aggregation, log *zap.Logger) aggregatedResponse {
+	var (
+		arr           = make([]json.RawMessage, 0)
+		aggErrors     []ClientError
+		hasSuccessful bool
+	)
+
+	for _, resp := range responses {
+		if resp.err != nil {
+			log.Warn("upstream has errors",
+				zap.Bool("best_effort", agg.bestEffort),
+				zap.String("upstream_error", resp.err.Unwrap().Error()),
+				zap.String("client_error", a.mapUpstreamError(resp.err).String()),
+			)
+
+			if !agg.bestEffort {
+				return aggregatedResponse{errors: dedupeErrors(a.collectErrors(responses))}
+			}
+
+			aggErrors = append(aggErrors, a.mapUpstreamError(resp.err))
+
+			continue
+		}
+
+		if resp.body == nil {
+			hasSuccessful = true
+			continue
+		}
+
+		var items []json.RawMessage
+		if err := json.Unmarshal(resp.body, &items); err != nil {
+			log.Error("cannot unmarshal upstream response as array", zap.Error(err))
+
+			if !agg.bestEffort {
+				return respUpstreamMalformedError
+			}
+
+			aggErrors = append(aggErrors, ClientErrUpstreamMalformed)
+
+			continue
+		}
+
+		arr = append(arr, items...)
+		hasSuccessful = true
+	}
+
+	data, err := json.Marshal(arr)
+	if err != nil {
+		return respUpstreamMalformedError
+	}
+
+	return aggregatedResponse{
+		data:    data,
+		headers: agg.headers.merge(responses),
 		errors:  dedupeErrors(aggErrors),
 		partial: len(aggErrors) > 0 && hasSuccessful,
 	}
@@ -261,40 +334,86 @@ func (a *defaultAggregator) namespaced(upstreams []upstream, responses []upstrea
 
 	return aggregatedResponse{
 		data:    data,
-		headers: mergeSuccessfulHeaders(responses),
+		headers: agg.headers.merge(responses),
 		errors:  dedupeErrors(aggErrors),
 		partial: len(aggErrors) > 0 && hasSuccessful,
 	}
 }
 
-// collectErrors collects mapped ClientErrors from all failed responses.
-// Used when bestEffort=false to return all upstream errors at once.
-func (a *defaultAggregator) collectErrors(responses []upstreamResponse) []ClientError {
-	errs := make([]ClientError, 0, len(responses))
-
-	for _, r := range responses {
-		if r.err != nil {
-			errs = append(errs, a.mapUpstreamError(r.err))
+// first returns the winning response of a race scatter. Every other upstream was
+// canceled, so errors are only reported when no upstream succeeded.
+func (a *defaultAggregator) first(responses []upstreamResponse, agg aggregation) aggregatedResponse {
+	for _, resp := range responses {
+		if resp.err == nil {
+			return a.rawResponse(resp, agg)
 		}
 	}
 
-	return errs
+	return aggregatedResponse{errors: dedupeErrors(a.collectErrors(responses))}
 }
 
-func mergeSuccessfulHeaders(responses []upstreamResponse) http.Header {
-	merged := make(http.Header)
+// customized hands the responses to the flow's sdk.Aggregator. Error handling stays
+// with the gateway: without bestEffort any failure short-circuits before the
+// aggregator runs, otherwise failed upstreams are passed with Err set and
+// reported in the response errors.
+func (a *defaultAggregator) customized(upstreams []upstream, responses []upstreamResponse, agg aggregation, log *zap.Logger) aggregatedResponse {
+	if agg.custom == nil {
+		log.Error("custom aggregation strategy without aggregator")
+		return respInternalError
+	}
+
+	var (
+		aggErrors     []ClientError
+		hasSuccessful bool
+	)
+
+	in := sdkUpstreamResponses(upstreams, responses)
 
 	for _, resp := range responses {
 		if resp.err != nil {
+			if !agg.bestEffort {
+				return aggregatedResponse{errors: dedupeErrors(a.collectErrors(responses))}
+			}
+
+			aggErrors = append(aggErrors, a.mapUpstreamError(resp.err))
+
 			continue
 		}
 
-		for k, v := range resp.headers {
-			merged[k] = v
+		hasSuccessful = true
+	}
+
+	out, err := agg.custom.Aggregate(in, sdk.Aggregation{BestEffort: agg.bestEffort})
+	if err != nil {
+		log.Error("custom aggregator failed", zap.String("aggregator", agg.custom.Name()), zap.Error(err))
+		return respInternalError
+	}
+
+	headers := agg.headers.filter(out.Header)
+	if headers == nil {
+		headers = agg.headers.merge(responses)
+	}
+
+	return aggregatedResponse{
+		data:    out.Data,
+		headers: headers,
+		errors:  dedupeErrors(aggErrors),
+		partial: len(aggErrors) > 0 && hasSuccessful,
+	}
+}
+
+// collectErrors collects mapped ClientErrors from all failed responses.
+// Used when bestEffort=false to return all upstream errors at once.
+func (a *defaultAggregator) collectErrors(responses []upstreamResponse) []ClientError {
+	errs := make([]ClientError, 0, len(responses))
+
+	for _, r := range responses {
+		if r.err != nil {
+			errs = append(errs, a.mapUpstreamError(r.err))
 		}
 	}
 
-	return merged
+	return errs
 }
 
 func (a *defaultAggregator) mapUpstreamError(err *upstreamError) ClientError {
@@ -305,10 +424,14 @@ func (a *defaultAggregator) mapUpstreamError(err *upstreamError) ClientError {
 	switch err.kind {
 	case upstreamTimeout, upstreamConnection, upstreamCircuitOpen:
 		return ClientErrUpstreamUnavailable
+	case upstreamOverloaded:
+		return ClientErrUpstreamOverloaded
 	case upstreamBadStatus:
 		return ClientErrUpstreamError
 	case upstreamBodyTooLarge:
 		return ClientErrUpstreamBodyTooLarge
+	case upstreamMalformed:
+		return ClientErrUpstreamMalformed
 	case upstreamCanceled:
 		return ClientErrAborted
 	case upstreamReadError, upstreamInternal:
@@ -329,3 +452,28 @@ func dedupeErrors(errs []ClientError) []ClientError {
 
 	return out
 }
+
+// sdkUpstreamResponses converts scatter results into the form handed to custom
+// aggregators and response plugins. responses[i] belongs to upstreams[i].
+func sdkUpstreamResponses(upstreams []upstream, responses []upstreamResponse) []sdk.UpstreamResponse {
+	out := make([]sdk.UpstreamResponse, len(responses))
+
+	for i, resp := range responses {
+		out[i] = sdk.UpstreamResponse{
+			Status:  resp.status,
+			Header:  resp.headers,
+			Body:    resp.body,
+			Latency: resp.latency,
+		}
+
+		if i < len(upstreams) {
+			out[i].Name = upstreams[i].name()
+		}
+
+		if resp.err != nil {
+			out[i].Err = resp.err
+		}
+	}
+
+	return out
+}
@@ -2,6 +2,7 @@ package kono
 
 import (
 	"errors"
+	"net/http"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -56,6 +57,59 @@ var _ = Describe("Aggregator", func() {
 		})
 	})
 
+	Describe("response headers", func() {
+		withHeaders := func(body string, kv ...string) upstreamResponse {
+			resp := okResponse(body)
+			resp.headers = http.Header{}
+
+			for i := 0; i < len(kv); i += 2 {
+				resp.headers.Add(kv[i], kv[i+1])
+			}
+
+			return resp
+		}
+
+		responses := func() []upstreamResponse {
+			return []upstreamResponse{
+				withHeaders(`{"a":1}`, "Cache-Control", "max-age=60", "X-Internal-Node", "n1", "Set-Cookie", "a=1"),
+				errResponse(upstreamTimeout),
+				withHeaders(`{"b":2}`, "Cache-Control", "no-store", "Set-Cookie", "b=2", "X-Trace", "t"),
+			}
+		}
+
+		aggregateWith := func(cfg *ResponseHeadersConfig) http.Header {
+			policy, err := compileHeaderPolicy(cfg)
+			Expect(err).NotTo(HaveOccurred())
+
+			return agg.aggregate(nil, responses(), aggregation{strategy: strategyArray, bestEffort: true, headers: policy}, zap.NewNop()).headers
+		}
+
+		It("lets the last successful upstream win by default", func() {
+			headers := aggregateWith(nil)
+
+			Expect(headers.Get("Cache-Control")).To(Equal("no-store"))
+			Expect(headers.Values("Set-Cookie")).To(Equal([]string{"b=2"}))
+			Expect(headers.Get("X-Internal-Node")).To(Equal("n1"))
+		})
+
+		It("keeps the first upstream's values or appends them all", func() {
+			Expect(aggregateWith(&ResponseHeadersConfig{OnConflict: "first"}).Get("Cache-Control")).To(Equal("max-age=60"))
+			Expect(aggregateWith(&ResponseHeadersConfig{OnConflict: "append"}).Values("Set-Cookie")).To(Equal([]string{"a=1", "b=2"}))
+		})
+
+		It("applies allow and deny lists with prefix patterns", func() {
+			headers := aggregateWith(&ResponseHeadersConfig{
+				Allow: []string{"cache-control", "x-*"},
+				Deny:  []string{"X-Internal-*"},
+			})
+
+			Expect(headers).To(HaveKey("Cache-Control"))
+			Expect(headers).To(HaveKey("X-Trace"))
+			Expect(headers).NotTo(HaveKey("X-Internal-Node"))
+			Expect(headers).NotTo(HaveKey("Set-Cookie"))
+		})
+	})
+
 	Describe("merge strategy", func() {
 		var responses []upstreamResponse
 
@@ -145,6 +199,56 @@ var _ = Describe("Aggregator", func() {
 		})
 	})
 
+	Describe("concat strategy", func() {
+		It("flattens upstream arrays into one", func() {
+			result := agg.aggregate(
+				nil,
+				[]upstreamResponse{okResponse(`[1,2]`), okResponse(`[]`), okResponse(`[{"x":3}]`)},
+				aggregation{strategy: strategyConcat},
+				zap.NewNop(),
+			)
+
+			Expect(result.errors).To(BeEmpty())
+			jsonEqual(`[1,2,{"x":3}]`, result.data)
+		})
+
+		It("returns malformed error for a non-array body", func() {
+			result := agg.aggregate(
+				nil,
+				[]upstreamResponse{okResponse(`[1]`), okResponse(`{"a":1}`)},
+				aggregation{strategy: strategyConcat},
+				zap.NewNop(),
+			)
+
+			Expect(result.errors).To(ConsistOf(ClientErrUpstreamMalformed))
+		})
+
+		It("skips non-array bodies when best effort", func() {
+			result := agg.aggregate(
+				nil,
+				[]upstreamResponse{okResponse(`[1]`), okResponse(`{"a":1}`)},
+				aggregation{strategy: strategyConcat, bestEffort: true},
+				zap.NewNop(),
+			)
+
+			Expect(result.partial).To(BeTrue())
+			Expect(result.errors).To(ConsistOf(ClientErrUpstreamMalformed))
+			jsonEqual(`[1]`, result.data)
+		})
+
+		It("is not partial when no body was accepted", func() {
+			result := agg.aggregate(
+				nil,
+				[]upstreamResponse{okResponse(`{"a":1}`), errResponse(upstreamTimeout)},
+				aggregation{strategy: strategyConcat, bestEffort: true},
+				zap.NewNop(),
+			)
+
+			Expect(result.partial).To(BeFalse())
+			Expect(result.errors).To(HaveLen(2))
+		})
+	})
+
 	Describe("namespace strategy", func() {
 		It("groups responses by upstream name", func() {
 			result := agg.aggregate(
@@ -182,6 +286,94 @@ var _ = Describe("Aggregator", func() {
 		})
 	})
 
+	Describe("first strategy", func() {
+		It("returns the winning response as-is", func() {
+			result := agg.aggregate(
+				nil,
+				[]upstreamResponse{errResponse(upstreamCanceled), okResponse(`{"region":"eu"}`)},
+				aggregation{strategy: strategyFirst},
+				zap.NewNop(),
+			)
+
+			Expect(result.errors).To(BeEmpty())
+			Expect(result.partial).To(BeFalse())
+			jsonEqual(`{"region":"eu"}`, result.data)
+		})
+
+		It("reports all errors when no upstream succeeded", func() {
+			result := agg.aggregate(
+				nil,
+				[]upstreamResponse{errResponse(upstreamTimeout), errResponse(upstreamBadStatus)},
+				aggregation{strategy: strategyFirst},
+				zap.NewNop(),
+			)
+
+			Expect(result.data).To(BeNil())
+			Expect(result.errors).To(ConsistOf(ClientErrUpstreamUnavailable, ClientErrUpstreamError))
+		})
+	})
+
+	Describe("custom strategy", func() {
+		var custom *mockAggregator
+
+		BeforeEach(func() {
+			custom = &mockAggregator{}
+		})
+
+		It("delegates a single response to the custom aggregator", func() {
+			result := agg.aggregate(
+				mockUpstreams("users"),
+				[]upstreamResponse{okResponse(`{"id":1}`)},
+				aggregation{strategy: strategyCustom, custom: custom},
+				zap.NewNop(),
+			)
+
+			Expect(result.errors).To(BeEmpty())
+			jsonEqual(`["users"]`, result.data)
+			Expect(custom.received).To(HaveLen(1))
+			Expect(string(custom.received[0].Body)).To(Equal(`{"id":1}`))
+		})
+
+		It("short-circuits on failure without best effort", func() {
+			result := agg.aggregate(
+				mockUpstreams("users", "orders"),
+				[]upstreamResponse{okResponse(`{"id":1}`), errResponse(upstreamTimeout)},
+				aggregation{strategy: strategyCustom, custom: custom},
+				zap.NewNop(),
+			)
+
+			Expect(result.errors).To(ConsistOf(ClientErrUpstreamUnavailable))
+			Expect(custom.received).To(BeNil())
+		})
+
+		It("passes failed upstreams with Err set when best effort", func() {
+			result := agg.aggregate(
+				mockUpstreams("users", "orders"),
+				[]upstreamResponse{okResponse(`{"id":1}`), errResponse(upstreamTimeout)},
+				aggregation{strategy: strategyCustom, custom: custom, bestEffort: true},
+				zap.NewNop(),
+			)
+
+			Expect(result.partial).To(BeTrue())
+			Expect(result.errors).To(ConsistOf(ClientErrUpstreamUnavailable))
+			jsonEqual(`["users"]`, result.data)
+			Expect(custom.received[1].Err).To(HaveOccurred())
+		})
+
+		It("returns internal error when the custom aggregator fails", func() {
+			custom.err = errors.New("boom")
+
+			result := agg.aggregate(
+				mockUpstreams("users"),
+				[]upstreamResponse{okResponse(`{"id":1}`)},
+				aggregation{strategy: strategyCustom, custom: custom},
+				zap.NewNop(),
+			)
+
+			Expect(result.errors).To(ConsistOf(ClientErrInternal))
+		})
+	})
+
 	DescribeTable("mapUpstreamError",
 		func(kind upstreamErrorKind, want ClientError) {
 			got := agg.mapUpstreamError(&upstreamError{
@@ -192,6 +384,7 @@ var _ = Describe("Aggregator", func() {
 		},
 		Entry("timeout → upstream unavailable", upstreamTimeout, ClientErrUpstreamUnavailable),
 		Entry("connection → upstream unavailable", upstreamConnection, ClientErrUpstreamUnavailable),
+		Entry("overloaded → upstream overloaded", upstreamOverloaded, ClientErrUpstreamOverloaded),
 		Entry("bad status → upstream error", upstreamBadStatus, ClientErrUpstreamError),
 		Entry("body too large → upstream body too large", upstreamBodyTooLarge, ClientErrUpstreamBodyTooLarge),
 		Entry("internal → internal", upstreamInternal, ClientErrInternal),
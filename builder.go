@@ -2,24 +2,37 @@ package kono
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net"
 	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"github.com/jmespath/go-jmespath"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.uber.org/zap"
 	"golang.org/x/sync/semaphore"
 
+	"github.com/starwalkn/kono/internal/accesslog"
 	"github.com/starwalkn/kono/internal/circuitbreaker"
 	"github.com/starwalkn/kono/internal/metric"
 	"github.com/starwalkn/kono/internal/otelcommon"
+	"github.com/starwalkn/kono/internal/quota"
 	"github.com/starwalkn/kono/internal/ratelimit"
 	"github.com/starwalkn/kono/internal/tracing"
+	"github.com/starwalkn/kono/sdk"
 )
 
 type RoutingConfigSet struct {
@@ -28,6 +41,12 @@ type RoutingConfigSet struct {
 	ServiceVersion string // injected via ldflags
 	Metrics        MetricsConfig
 	Tracing        TracingConfig
+	AccessLog      AccessLogConfig
+	// Previous is the router being replaced by a reload. Its quota counters, rate
+	// limit buckets and access log carry over to the new router while their
+	// settings stay the same, so that a reload neither resets nor loses them and
+	// the log file or syslog connection is not reopened.
+	Previous *Router
 }
 
 type RouterBundle struct {
@@ -37,6 +56,11 @@ type RouterBundle struct {
 	PromRegistry   *prometheus.Registry // nil unless metrics.exporter == "prometheus"
 }
 
+// NewRouter builds the router and observability providers for cfgSet.
+// Applications embedding the gateway register their components with
+// RegisterPlugin, RegisterMiddleware and RegisterAggregator first, and take
+// cfgSet.Routing from LoadConfig or ParseConfig, which apply defaults and
+// validate it. The Router is an http.Handler; Shutdown releases it.
 func NewRouter(ctx context.Context, cfgSet RoutingConfigSet, log *zap.Logger) (RouterBundle, error) {
 	tracing.InstallPropagator() // Sets the propagator even if tracing is disabled
 
@@ -63,20 +87,64 @@ func NewRouter(ctx context.Context, cfgSet RoutingConfigSet, log *zap.Logger) (R
 	routing := cfgSet.Routing
 
 	router := initMinimalRouter(len(routing.Flows), metrics, log)
-	router.rateLimiter, err = initRateLimiter(routing.RateLimiter)
+	router.routing = routing
+	router.rateLimiter, err = initRateLimiter(routing.RateLimiter, cfgSet.Previous)
 	if err != nil {
 		return RouterBundle{}, fmt.Errorf("init rate limiter: %w", err)
 	}
 
+	built := false
+	defer func() {
+		if !built {
+			discardRouter(ctx, router, cfgSet.Previous)
+		}
+	}()
+
+	router.rateLimitKey = routing.RateLimiter.Key
+
+	if router.rateLimiter != nil {
+		if err = metrics.ObserveRateLimitBuckets(router.rateLimiter.Len); err != nil {
+			return RouterBundle{}, fmt.Errorf("observe rate limiter: %w", err)
+		}
+	}
+
+	router.quota, err = initQuota(routing.Quota, cfgSet.Previous, log)
+	if err != nil {
+		return RouterBundle{}, fmt.Errorf("init quota: %w", err)
+	}
+	router.quotaKey = routing.Quota.Key
+
+	if shed := routing.LoadShedding; shed.MaxInFlight > 0 {
+		router.inFlight = semaphore.NewWeighted(int64(shed.MaxInFlight))
+		router.retryAfter = strconv.Itoa(int(math.Ceil(shed.RetryAfter.Seconds())))
+		router.queueTimeout = shed.QueueTimeout
+		router.maxQueued = int64(shed.MaxQueued)
+	}
+
 	trustedProxies, err := parseTrustedProxies(cfgSet.Routing.TrustedProxies)
 	if err != nil {
 		return RouterBundle{}, fmt.Errorf("parse trusted proxies: %w", err)
 	}
+	router.trustedProxies = trustedProxies
+
+	router.accessLog, err = initAccessLog(cfgSet.AccessLog, cfgSet.Previous)
+	if err != nil {
+		return RouterBundle{}, fmt.Errorf("init access log: %w", err)
+	}
+	router.accessLogConfig = cfgSet.AccessLog
 
 	for _, fcfg := range routing.Flows {
+		if fcfg.Compression == nil {
+			fcfg.Compression = &routing.Compression
+		}
+
+		if fcfg.TracePropagation == nil {
+			fcfg.TracePropagation = &routing.TracePropagation
+		}
+
 		compiledFlow, compileErr := compileFlow(fcfg, trustedProxies, metrics, log)
 		if compileErr != nil {
-			return RouterBundle{}, fmt.Errorf("compile flow %q: %w", fcfg.Path, compileErr)
+			return RouterBundle{}, fmt.Errorf("compile flow %q: %w", fcfg.RoutePattern(), compileErr)
 		}
 
 		router.flows = append(router.flows, compiledFlow)
@@ -84,6 +152,18 @@ func NewRouter(ctx context.Context, cfgSet RoutingConfigSet, log *zap.Logger) (R
 
 	router.registerFlows()
 
+	if routing.GraphQL.Enabled {
+		if err = router.registerGraphQL(routing.GraphQL); err != nil {
+			return RouterBundle{}, fmt.Errorf("init graphql: %w", err)
+		}
+	}
+
+	if cfgSet.Previous != nil {
+		cfgSet.Previous.successor.Store(router)
+	}
+
+	built = true
+
 	return RouterBundle{
 		Router:         router,
 		MeterProvider:  meterProvider,
@@ -92,14 +172,49 @@ func NewRouter(ctx context.Context, cfgSet RoutingConfigSet, log *zap.Logger) (R
 	}, nil
 }
 
+// discardRouter releases a router whose build failed. The quota, rate limiter
+// and access log it took over from prev stay running for prev.
+func discardRouter(ctx context.Context, r, prev *Router) {
+	if prev != nil {
+		if r.quota == prev.quota {
+			r.quota = nil
+		}
+
+		if r.rateLimiter == prev.rateLimiter {
+			r.rateLimiter = nil
+		}
+
+		if r.accessLog == prev.accessLog {
+			r.accessLog = nil
+		}
+	}
+
+	_ = r.Shutdown(ctx)
+}
+
 func (r *Router) registerFlows() {
-	r.chiRouter.NotFound(func(w http.ResponseWriter, req *http.Request) {
+	notFound := r.regexFallback(func(w http.ResponseWriter, req *http.Request) {
 		r.metrics.IncFailedRequestsTotal(metric.FailReasonNoMatchedFlow)
 		r.log.Error("no flow matched", zap.String("request_uri", req.URL.RequestURI()))
 
 		http.NotFound(w, req)
 	})
 
+	methodNotAllowed := r.regexFallback(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	})
+
+	r.chiRouter.NotFound(notFound)
+	r.chiRouter.MethodNotAllowed(methodNotAllowed)
+
+	// Flows sharing a method and path template are registered as one chi route
+	// that picks the first flow whose SNI and header matchers pass.
+	var (
+		keys      []string
+		groups    = make(map[string][]route)
+		corsPaths []string
+	)
+
 	for i := range r.flows {
 		f := &r.flows[i]
 
@@ -108,14 +223,78 @@ func (r *Router) registerFlows() {
 			middlewares = append(middlewares, m.Handler)
 		}
 
-		r.chiRouter.With(middlewares...).Method(
-			f.method,
-			f.path,
-			r.newFlowHandler(f),
-		)
+		handler := chi.Chain(middlewares...).Handler(r.newFlowHandler(f))
+
+		// CORS wraps the middlewares so that their rejections stay readable.
+		if f.cors != nil {
+			handler = f.cors.handler(handler)
+		}
+
+		if f.timeout > 0 {
+			handler = withFlowTimeout(f.timeout, handler)
+		}
+
+		rt := route{flow: f, handler: handler}
+
+		if f.pathRegex != nil {
+			r.regexRoutes = append(r.regexRoutes, rt)
+			continue
+		}
+
+		if f.cors != nil && !slices.Contains(corsPaths, f.path) {
+			corsPaths = append(corsPaths, f.path)
+		}
+
+		key := f.method + " " + f.path
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+
+		groups[key] = append(groups[key], rt)
+	}
+
+	// The gateway answers preflights itself; an OPTIONS flow on the same path only
+	// sees requests that are not preflights.
+	for _, key := range keys {
+		routes := groups[key]
+		method, path := routes[0].flow.method, routes[0].flow.path
+
+		var handler http.Handler = selectRoute(routes, notFound)
+
+		if method == http.MethodOptions && slices.Contains(corsPaths, path) {
+			handler = r.preflightHandler(path, handler)
+			corsPaths = slices.DeleteFunc(corsPaths, func(p string) bool { return p == path })
+		}
+
+		r.chiRouter.Method(method, path, handler)
+	}
+
+	for _, path := range corsPaths {
+		r.chiRouter.Method(http.MethodOptions, path, r.preflightHandler(path, methodNotAllowed))
 	}
 }
 
+// registerGraphQL mounts the GraphQL endpoint for GET and POST. Field resolvers
+// dispatch to flows as subrequests, so admission, rate limits, quotas and flow
+// middlewares apply to each field.
+func (r *Router) registerGraphQL(cfg GraphQLConfig) error {
+	for _, f := range r.flows {
+		if f.path == cfg.Path && (f.method == http.MethodGet || f.method == http.MethodPost) {
+			return fmt.Errorf("graphql path %q conflicts with flow %s %s", cfg.Path, f.method, f.path)
+		}
+	}
+
+	endpoint, err := newGraphQLEndpoint(cfg, http.HandlerFunc(r.serveSubrequest), r.log.Named("graphql"))
+	if err != nil {
+		return err
+	}
+
+	r.chiRouter.Method(http.MethodGet, cfg.Path, endpoint)
+	r.chiRouter.Method(http.MethodPost, cfg.Path, endpoint)
+
+	return nil
+}
+
 func initMinimalRouter(routesCount int, metrics *metric.Metrics, log *zap.Logger) *Router {
 	return &Router{
 		chiRouter: chi.NewMux(),
@@ -157,6 +336,48 @@ func initMetrics(ctx context.Context, cfg MetricsConfig, res *resource.Resource)
 	}
 }
 
+func initAccessLog(cfg AccessLogConfig, prev *Router) (*accesslog.Logger, error) {
+	if !cfg.Enabled {
+		return nil, nil //nolint:nilnil // disabled access log is represented by nil
+	}
+
+	if prev != nil && prev.accessLog != nil && reflect.DeepEqual(prev.accessLogConfig, cfg) {
+		return prev.accessLog, nil
+	}
+
+	var (
+		w   io.Writer = struct{ io.Writer }{os.Stdout} // Hides Close: Shutdown must not close stdout.
+		err error
+	)
+
+	switch cfg.Output {
+	case accessLogOutputFile:
+		w, err = accesslog.OpenFile(accesslog.FileOptions{
+			Path:       cfg.File.Path,
+			MaxSize:    int64(cfg.File.MaxSize) << 20, //nolint:mnd // megabytes.
+			MaxAge:     cfg.File.MaxAge,
+			MaxBackups: cfg.File.MaxBackups,
+		})
+	case accessLogOutputSyslog:
+		w, err = accesslog.DialSyslog(cfg.Syslog.Network, cfg.Syslog.Address, cfg.Syslog.Facility, cfg.Syslog.Tag)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := accesslog.New(cfg.Format, cfg.Template, w)
+	if err != nil {
+		if c, ok := w.(io.Closer); ok {
+			_ = c.Close()
+		}
+
+		return nil, err
+	}
+
+	return l, nil
+}
+
 func initTracing(ctx context.Context, cfg TracingConfig, res *resource.Resource) (otelcommon.Provider, error) {
 	if !cfg.Enabled {
 		return otelcommon.NewNopProvider(), nil
@@ -182,19 +403,53 @@ func initTracing(ctx context.Context, cfg TracingConfig, res *resource.Resource)
 	}
 }
 
-func initRateLimiter(cfg RateLimiterConfig) (*ratelimit.RateLimit, error) {
+func initRateLimiter(cfg RateLimiterConfig, prev *Router) (*ratelimit.RateLimit, error) {
 	if !cfg.Enabled {
 		return nil, nil //nolint:nilnil // it is ok for optional modules
 	}
 
-	rl := ratelimit.New(cfg.Config)
-	if err := rl.Start(); err != nil {
+	if prev != nil && prev.rateLimiter != nil && reflect.DeepEqual(prev.routing.RateLimiter.Config, cfg.Config) {
+		return prev.rateLimiter, nil
+	}
+
+	rl, err := ratelimit.New(cfg.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = rl.Start(); err != nil {
 		return nil, fmt.Errorf("start rate limiter: %w", err)
 	}
 
 	return rl, nil
 }
 
+func initQuota(cfg QuotaConfig, prev *Router, log *zap.Logger) (*quota.Quota, error) {
+	if !cfg.Enabled {
+		return nil, nil //nolint:nilnil // it is ok for optional modules
+	}
+
+	// The key only decides which counter a request takes from.
+	if prev != nil && prev.quota != nil {
+		was := prev.routing.Quota
+		if was.Limit == cfg.Limit && was.Period == cfg.Period && was.StatePath == cfg.StatePath &&
+			was.FlushInterval == cfg.FlushInterval && was.MaxEntries == cfg.MaxEntries {
+			return prev.quota, nil
+		}
+	}
+
+	q, err := quota.New(cfg.Limit, cfg.MaxEntries, cfg.Period, cfg.StatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = q.Start(cfg.FlushInterval, log.Named("quota")); err != nil {
+		return nil, fmt.Errorf("start quota: %w", err)
+	}
+
+	return q, nil
+}
+
 func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
 	result := make([]*net.IPNet, 0, len(proxies))
 
@@ -211,51 +466,126 @@ func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
 }
 
 func compileFlow(cfg FlowConfig, trustedProxies []*net.IPNet, metrics *metric.Metrics, log *zap.Logger) (flow, error) {
-	upstreams := initUpstreams(cfg.Upstreams, trustedProxies, metrics, log)
+	upstreams, err := initUpstreams(cfg.Upstreams, trustedProxies, metrics, log)
+	if err != nil {
+		return flow{}, fmt.Errorf("init upstreams: %w", err)
+	}
 
 	if cfg.Passthrough && len(upstreams) != 1 {
 		return flow{}, fmt.Errorf(
 			"passthrough flow '%s' must have exactly one upstream, got %d",
-			cfg.Path, len(upstreams),
+			cfg.RoutePattern(), len(upstreams),
 		)
 	}
 
 	var aggregationParams aggregation
 
 	if !cfg.Passthrough {
-		var err error
-
-		aggregationParams, err = initAggregation(*cfg.Aggregation, upstreams)
+		aggregationParams, err = initAggregation(*cfg.Aggregation, upstreams, log)
 		if err != nil {
 			return flow{}, fmt.Errorf("init aggregation: %w", err)
 		}
+
+		if cfg.Sequential && aggregationParams.strategy == strategyFirst {
+			return flow{}, fmt.Errorf("sequential flow '%s' cannot use the first aggregation strategy", cfg.RoutePattern())
+		}
+
+		aggregationParams.headers, err = compileHeaderPolicy(cfg.ResponseHeaders)
+		if err != nil {
+			return flow{}, fmt.Errorf("compile response headers: %w", err)
+		}
 	}
 
-	plugins, err := initPlugins(cfg.Plugins, log)
+	plugins, err := initPlugins(cfg.OrderedPlugins(), pluginDeps{trustedProxies: trustedProxies, metrics: metrics}, log)
 	if err != nil {
 		return flow{}, fmt.Errorf("init plugins: %w", err)
 	}
 
+	if len(plugins) > 0 {
+		log.Info("plugin order",
+			zap.String("flow", cfg.Method+" "+cfg.RoutePattern()),
+			zap.Strings("request", pluginNames(plugins, sdk.PluginTypeRequest)),
+			zap.Strings("response", pluginNames(plugins, sdk.PluginTypeResponse)),
+		)
+	}
+
 	middlewares, err := initMiddlewares(cfg.Middlewares, log)
 	if err != nil {
 		return flow{}, fmt.Errorf("init middlewares: %w", err)
 	}
 
+	var pathRegex *regexp.Regexp
+
+	if cfg.PathRegex != "" {
+		pathRegex, err = compileFlowRegex(cfg.PathRegex)
+		if err != nil {
+			return flow{}, fmt.Errorf("compile path_regex: %w", err)
+		}
+	}
+
+	headerMatchers, err := compileHeaderMatchers(cfg.Headers)
+	if err != nil {
+		return flow{}, fmt.Errorf("compile header matchers: %w", err)
+	}
+
+	serverNames, err := compileServerNames(cfg.SNI)
+	if err != nil {
+		return flow{}, fmt.Errorf("compile sni: %w", err)
+	}
+
+	neg, err := compileNegotiation(cfg.Negotiation)
+	if err != nil {
+		return flow{}, fmt.Errorf("compile negotiation: %w", err)
+	}
+
+	comp, err := compileCompression(cfg.Compression)
+	if err != nil {
+		return flow{}, fmt.Errorf("compile compression: %w", err)
+	}
+
+	propagator, err := compileTracePropagation(cfg.TracePropagation)
+	if err != nil {
+		return flow{}, fmt.Errorf("compile trace propagation: %w", err)
+	}
+
+	cache, err := newResponseCache(cfg.Cache, cfg.Method+" "+cfg.RoutePattern(), log.Named("cache"))
+	if err != nil {
+		return flow{}, fmt.Errorf("init cache: %w", err)
+	}
+
+	cors, err := compileCORS(cfg.CORS)
+	if err != nil {
+		return flow{}, fmt.Errorf("compile cors: %w", err)
+	}
+
 	return flow{
-		path:              cfg.Path,
+		path:              cfg.RoutePattern(),
 		method:            cfg.Method,
+		pathRegex:         pathRegex,
+		headerMatchers:    headerMatchers,
+		serverNames:       serverNames,
+		listeners:         cfg.Listeners,
 		aggregation:       aggregationParams,
+		negotiation:       neg,
+		compression:       comp,
+		propagator:        propagator,
+		cache:             cache,
+		coalescer:         newCoalescer(cfg.Coalesce),
+		cors:              cors,
 		parallelUpstreams: cfg.ParallelUpstreams,
 		upstreams:         upstreams,
 		plugins:           plugins,
 		middlewares:       middlewares,
 		passthrough:       cfg.Passthrough,
+		flushInterval:     cfg.FlushInterval,
+		timeout:           cfg.Timeout,
+		sequential:        cfg.Sequential,
 
 		sem: semaphore.NewWeighted(cfg.ParallelUpstreams),
 	}, nil
 }
 
-func initAggregation(cfg AggregationConfig, upstreams []upstream) (aggregation, error) {
+func initAggregation(cfg AggregationConfig, upstreams []upstream, log *zap.Logger) (aggregation, error) {
 	strategy, err := compileStrategy(cfg.Strategy)
 	if err != nil {
 		return aggregation{}, err
@@ -268,6 +598,19 @@ func initAggregation(cfg AggregationConfig, upstreams []upstream) (aggregation,
 		preferredUpstream: -1,                      // default, value used only for merge strategy
 	}
 
+	if strategy == strategyCustom {
+		if cfg.Custom == nil {
+			return aggregation{}, errors.New("no aggregator specified for custom strategy")
+		}
+
+		agg.custom, err = initAggregator(*cfg.Custom, log)
+		if err != nil {
+			return aggregation{}, err
+		}
+
+		return agg, nil
+	}
+
 	if strategy != strategyMerge {
 		return agg, nil
 	}
@@ -309,10 +652,16 @@ func compileStrategy(s string) (aggregationStrategy, error) {
 	switch s {
 	case "array":
 		return strategyArray, nil
+	case "concat":
+		return strategyConcat, nil
 	case "merge":
 		return strategyMerge, nil
 	case "namespace":
 		return strategyNamespace, nil
+	case "first":
+		return strategyFirst, nil
+	case "custom":
+		return strategyCustom, nil
 	default:
 		return 0, fmt.Errorf("unknown aggregation strategy: %q", s)
 	}
@@ -333,60 +682,210 @@ func compileConflictPolicy(p string) (conflictPolicy, error) {
 	}
 }
 
-func initUpstreams(cfgs []UpstreamConfig, trustedProxies []*net.IPNet, metrics *metric.Metrics, log *zap.Logger) []upstream {
+func initUpstreams(cfgs []UpstreamConfig, trustedProxies []*net.IPNet, metrics *metric.Metrics, log *zap.Logger) ([]upstream, error) {
 	upstreams := make([]upstream, 0, len(cfgs))
 
 	for _, cfg := range cfgs {
-		upstreams = append(upstreams, buildUpstream(cfg, trustedProxies, metrics, log))
+		u, err := buildUpstream(cfg, trustedProxies, metrics, log)
+		if err != nil {
+			return nil, fmt.Errorf("build upstream %q: %w", cfg.Name, err)
+		}
+
+		upstreams = append(upstreams, u)
 	}
 
-	return upstreams
+	return upstreams, nil
 }
 
-func buildUpstream(cfg UpstreamConfig, trustedProxies []*net.IPNet, metrics *metric.Metrics, log *zap.Logger) upstream {
-	return &httpUpstream{
-		cfg:            buildUpstreamConfig(cfg, trustedProxies),
+func buildUpstream(cfg UpstreamConfig, trustedProxies []*net.IPNet, metrics *metric.Metrics, log *zap.Logger) (upstream, error) {
+	ucfg, err := buildUpstreamConfig(cfg, trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Type == upstreamTypeMock {
+		return buildMockUpstream(cfg, ucfg)
+	}
+
+	tlsConfig, err := buildUpstreamTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("build tls config: %w", err)
+	}
+
+	if cfg.TLS.InsecureSkipVerify {
+		log.Warn("upstream TLS certificate verification is disabled", zap.String("upstream", cfg.Name))
+	}
+
+	if cfg.Type == upstreamTypeGRPC {
+		if cfg.Discovery != nil || len(cfg.Groups) > 0 {
+			return nil, errors.New("discovery and host groups are not supported for grpc upstreams")
+		}
+
+		return buildGRPCUpstream(cfg, ucfg.selector, tlsConfig, log)
+	}
+
+	u := &httpUpstream{
+		cfg:            ucfg,
 		state:          buildUpstreamState(cfg.Hosts),
 		circuitBreaker: buildCircuitBreaker(cfg.Policy.CircuitBreakerConfig),
+		limiter:        buildConcurrencyLimiter(cfg),
+		outliers:       buildOutlierDetector(cfg.Policy.OutlierDetection, len(cfg.Hosts)),
 		metrics:        metrics,
 		log:            log,
-		client:         buildUpstreamHTTPClient(cfg),
-		streamClient:   buildUpstreamStreamClient(cfg),
+		client:         buildUpstreamHTTPClient(cfg, tlsConfig),
+		streamClient:   buildUpstreamStreamClient(cfg, tlsConfig),
+	}
+
+	if len(cfg.Groups) > 0 {
+		var key hashKey
+		if cfg.SplitKey != nil {
+			key = hashKey{source: hashKeySource(cfg.SplitKey.Source), name: cfg.SplitKey.Name}
+		}
+
+		split, err := newHostSplit(cfg.Groups, key, u.newHostSet)
+		if err != nil {
+			return nil, err
+		}
+
+		u.split.Store(split)
 	}
+
+	if cfg.Discovery != nil {
+		u.discovery, err = newHostDiscovery(*cfg.Discovery, log.With(zap.String("upstream", ucfg.name)))
+		if err != nil {
+			return nil, err
+		}
+
+		if err = u.discovery.start(context.Background(), u.setHosts); err != nil {
+			return nil, fmt.Errorf("discover hosts: %w", err)
+		}
+	}
+
+	return u, nil
 }
 
-func buildUpstreamConfig(cfg UpstreamConfig, trustedProxies []*net.IPNet) upstreamConfig {
+// buildUpstreamTLSConfig returns the client TLS settings for an upstream.
+func buildUpstreamTLSConfig(cfg UpstreamTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify, //nolint:gosec // explicit opt-in, logged at startup
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca file %q contains no PEM certificates", cfg.CAFile)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func buildUpstreamConfig(cfg UpstreamConfig, trustedProxies []*net.IPNet) (upstreamConfig, error) {
 	name := cfg.Name
 	if name == "" {
-		name = makeUpstreamName(cfg.Method, cfg.Hosts)
+		hosts := cfg.Hosts
+		for _, g := range cfg.Groups {
+			hosts = append(hosts, g.Hosts...)
+		}
+
+		if d := cfg.Discovery; d != nil {
+			hosts = []string{d.Name}
+			if d.Type == discoveryTypeKubernetes {
+				hosts = []string{d.Service}
+			}
+		}
+
+		name = makeUpstreamName(cfg.Method, hosts)
 	}
 
-	return upstreamConfig{
-		id:             uuid.NewString(),
-		name:           name,
-		hosts:          cfg.Hosts,
-		path:           cfg.Path,
-		method:         cfg.Method,
-		timeout:        cfg.Timeout,
-		forwardHeaders: cfg.ForwardHeaders,
-		forwardQueries: cfg.ForwardQueries,
-		forwardParams:  cfg.ForwardParams,
-		trustedProxies: trustedProxies,
-		lbMode:         lbMode(cfg.Policy.LoadBalancingConfig.Mode),
-		policy:         buildUpstreamPolicy(cfg.Policy),
+	var selector *jmespath.JMESPath
+
+	if cfg.Select != "" {
+		var err error
+
+		selector, err = jmespath.Compile(cfg.Select)
+		if err != nil {
+			return upstreamConfig{}, fmt.Errorf("compile select expression: %w", err)
+		}
+	}
+
+	var (
+		key  hashKey
+		ring *hashRing
+	)
+
+	if lbMode(cfg.Policy.LoadBalancingConfig.Mode) == lbModeConsistentHash {
+		hk := cfg.Policy.LoadBalancingConfig.HashKey
+		if hk == nil {
+			return upstreamConfig{}, errors.New("consistent_hash balancing requires a hash_key")
+		}
+
+		key = hashKey{source: hashKeySource(hk.Source), name: hk.Name}
+		ring = newHashRing(cfg.Hosts)
+	}
+
+	format := formatJSON
+	if cfg.ResponseFormat != "" {
+		var err error
+
+		format, err = compileResponseFormat(cfg.ResponseFormat)
+		if err != nil {
+			return upstreamConfig{}, err
+		}
 	}
+
+	return upstreamConfig{
+		id:               uuid.NewString(),
+		name:             name,
+		hosts:            cfg.Hosts,
+		path:             cfg.Path,
+		method:           cfg.Method,
+		timeout:          cfg.Timeout,
+		forwardHeaders:   cfg.ForwardHeaders,
+		forwardQueries:   cfg.ForwardQueries,
+		forwardParams:    cfg.ForwardParams,
+		trustedProxies:   trustedProxies,
+		lbMode:           lbMode(cfg.Policy.LoadBalancingConfig.Mode),
+		policy:           buildUpstreamPolicy(cfg.Policy),
+		hostCooldown:     cfg.Policy.LoadBalancingConfig.FailureCooldown,
+		outlierDetection: cfg.Policy.OutlierDetection,
+		hashKey:          key,
+		ring:             ring,
+		responseFormat:   format,
+		selector:         selector,
+	}, nil
 }
 
 func buildUpstreamState(hosts []string) upstreamState {
 	return upstreamState{
 		currentHostIdx:    0,
 		activeConnections: make([]int64, len(hosts)),
+		unhealthyUntil:    make([]int64, len(hosts)),
+		ejectedUntil:      make([]int64, len(hosts)),
 	}
 }
 
 func buildUpstreamPolicy(cfg PolicyConfig) upstreamPolicy {
-	headerBlacklist := make(map[string]struct{}, len(cfg.HeaderBlacklist))
-	for _, h := range cfg.HeaderBlacklist {
+	headerBlacklist := make(map[string]struct{}, len(cfg.HeaderDeny))
+	for _, h := range cfg.HeaderDeny {
 		headerBlacklist[h] = struct{}{}
 	}
 
@@ -400,6 +899,7 @@ func buildUpstreamPolicy(cfg PolicyConfig) upstreamPolicy {
 			retryOnStatuses: cfg.RetryConfig.RetryOnStatuses,
 			backoffDelay:    cfg.RetryConfig.BackoffDelay,
 		},
+		hedgeDelay: cfg.Hedging.Delay,
 	}
 }
 
@@ -408,12 +908,30 @@ func buildCircuitBreaker(cfg CircuitBreakerConfig) *circuitbreaker.CircuitBreake
 		return nil
 	}
 
-	return circuitbreaker.New(cfg.MaxFailures, cfg.ResetTimeout)
+	return circuitbreaker.NewWithOptions(circuitbreaker.Options{
+		MaxFailures:    cfg.MaxFailures,
+		ResetTimeout:   cfg.ResetTimeout,
+		Window:         cfg.Window,
+		ErrorRate:      cfg.ErrorRate,
+		MinRequests:    cfg.MinRequests,
+		HalfOpenTrials: cfg.HalfOpenTrials,
+	})
+}
+
+// buildOutlierDetector returns nil when detection is disabled or pointless because
+// the upstream has a single host.
+func buildOutlierDetector(cfg OutlierDetectionConfig, hosts int) *outlierDetector {
+	if !cfg.Enabled || hosts < 2 {
+		return nil
+	}
+
+	return newOutlierDetector(cfg, hosts)
 }
 
-func buildUpstreamHTTPClient(cfg UpstreamConfig) *http.Client {
+func buildUpstreamHTTPClient(cfg UpstreamConfig, tlsConfig *tls.Config) *http.Client {
 	return &http.Client{
 		Transport: &http.Transport{
+			TLSClientConfig:     tlsConfig,
 			MaxConnsPerHost:     0,
 			MaxIdleConns:        cfg.Transport.MaxIdleConns,
 			MaxIdleConnsPerHost: cfg.Transport.MaxIdleConnsPerHost,
@@ -424,9 +942,10 @@ func buildUpstreamHTTPClient(cfg UpstreamConfig) *http.Client {
 	}
 }
 
-func buildUpstreamStreamClient(cfg UpstreamConfig) *http.Client {
+func buildUpstreamStreamClient(cfg UpstreamConfig, tlsConfig *tls.Config) *http.Client {
 	return &http.Client{
 		Transport: &http.Transport{
+			TLSClientConfig:     tlsConfig,
 			MaxIdleConns:        cfg.Transport.MaxIdleConns,
 			MaxIdleConnsPerHost: cfg.Transport.MaxIdleConnsPerHost,
 			IdleConnTimeout:     cfg.Transport.IdleConnTimeout,
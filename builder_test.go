@@ -2,12 +2,29 @@ package kono
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"go.uber.org/zap"
+
+	"github.com/starwalkn/kono/sdk"
 )
 
 var _ = Describe("builder", func() {
@@ -57,7 +74,7 @@ var _ = Describe("builder", func() {
 				Config:  nil,
 			}
 
-			rl, err := initRateLimiter(cfg)
+			rl, err := initRateLimiter(cfg, nil)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(rl).To(BeNil())
 		})
@@ -71,10 +88,459 @@ var _ = Describe("builder", func() {
 				},
 			}
 
-			rl, err := initRateLimiter(cfg)
+			rl, err := initRateLimiter(cfg, nil)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(rl).NotTo(BeNil())
 		})
+
+		DescribeTable("enforces the limit with each algorithm",
+			func(algorithm string) {
+				rl, err := initRateLimiter(RateLimiterConfig{
+					Enabled: true,
+					Config: map[string]interface{}{
+						"algorithm": algorithm,
+						"window":    "1m",
+						"limit":     2,
+					},
+				}, nil)
+				Expect(err).NotTo(HaveOccurred())
+				defer rl.Stop()
+
+				Expect(rl.Allow("a")).To(BeTrue())
+				Expect(rl.Allow("a")).To(BeTrue())
+				Expect(rl.Allow("a")).To(BeFalse())
+				Expect(rl.Allow("b")).To(BeTrue())
+			},
+			Entry("fixed window", "fixed_window"),
+			Entry("sliding window", "sliding_window"),
+			Entry("token bucket", "token_bucket"),
+		)
+
+		It("lets a token bucket refill between requests", func() {
+			rl, err := initRateLimiter(RateLimiterConfig{
+				Enabled: true,
+				Config: map[string]interface{}{
+					"algorithm": "token_bucket",
+					"window":    "100ms",
+					"limit":     1,
+				},
+			}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer rl.Stop()
+
+			Expect(rl.Allow("a")).To(BeTrue())
+			Expect(rl.Allow("a")).To(BeFalse())
+			Eventually(func() bool { return rl.Allow("a") }).Should(BeTrue())
+		})
+
+		It("forgets the least recently seen key beyond max_entries", func() {
+			rl, err := initRateLimiter(RateLimiterConfig{
+				Enabled: true,
+				Config: map[string]interface{}{
+					"window":      "1m",
+					"limit":       1,
+					"max_entries": 2,
+				},
+			}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer rl.Stop()
+
+			Expect(rl.Allow("a")).To(BeTrue())
+			Expect(rl.Allow("b")).To(BeTrue())
+			Expect(rl.Allow("a")).To(BeFalse()) // "a" is now the most recent key.
+			Expect(rl.Allow("c")).To(BeTrue())  // Evicts "b".
+			Expect(rl.Len()).To(Equal(2))
+
+			Expect(rl.Allow("a")).To(BeFalse())
+			Expect(rl.Allow("b")).To(BeTrue())
+		})
+
+		It("reuses the limiter of the replaced router while its config is unchanged", func() {
+			cfg := RateLimiterConfig{Enabled: true, Config: map[string]interface{}{"window": "1m", "limit": 1}}
+
+			rl, err := initRateLimiter(cfg, nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer rl.Stop()
+
+			prev := &Router{routing: RoutingConfig{RateLimiter: cfg}, rateLimiter: rl}
+
+			same, err := initRateLimiter(cfg, prev)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(same).To(BeIdenticalTo(rl))
+
+			changed, err := initRateLimiter(RateLimiterConfig{Enabled: true, Config: map[string]interface{}{"window": "1m", "limit": 2}}, prev)
+			Expect(err).NotTo(HaveOccurred())
+			defer changed.Stop()
+			Expect(changed).NotTo(BeIdenticalTo(rl))
+		})
+
+		It("rejects an unknown algorithm", func() {
+			_, err := initRateLimiter(RateLimiterConfig{
+				Enabled: true,
+				Config:  map[string]interface{}{"algorithm": "leaky"},
+			}, nil)
+			Expect(err).To(MatchError(ContainSubstring("leaky")))
+		})
+	})
+
+	Describe("initQuota", func() {
+		It("returns nil if disabled", func() {
+			q, err := initQuota(QuotaConfig{}, nil, zap.NewNop())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(q).To(BeNil())
+		})
+
+		It("keeps counters across restarts through the state file", func() {
+			cfg := QuotaConfig{
+				Enabled:       true,
+				Limit:         2,
+				Period:        "month",
+				StatePath:     filepath.Join(GinkgoT().TempDir(), "quota.json"),
+				FlushInterval: time.Hour,
+			}
+
+			q, err := initQuota(cfg, nil, zap.NewNop())
+			Expect(err).NotTo(HaveOccurred())
+
+			remaining, reset, ok := q.Take("key-1", time.Now())
+			Expect(ok).To(BeTrue())
+			Expect(remaining).To(Equal(int64(1)))
+			Expect(reset.After(time.Now())).To(BeTrue())
+			Expect(q.Stop()).To(Succeed())
+
+			q, err = initQuota(cfg, nil, zap.NewNop())
+			Expect(err).NotTo(HaveOccurred())
+			defer q.Stop()
+
+			_, _, ok = q.Take("key-1", time.Now())
+			Expect(ok).To(BeTrue())
+			_, _, ok = q.Take("key-1", time.Now())
+			Expect(ok).To(BeFalse())
+
+			_, _, ok = q.Take("key-1", time.Now().AddDate(0, 1, 0))
+			Expect(ok).To(BeTrue())
+		})
+
+		It("counts new consumers beyond max_entries on a shared overflow counter", func() {
+			q, err := initQuota(QuotaConfig{Enabled: true, Limit: 2, Period: "day", MaxEntries: 2, FlushInterval: time.Hour}, nil, zap.NewNop())
+			Expect(err).NotTo(HaveOccurred())
+			defer q.Stop()
+
+			now := time.Now()
+
+			_, _, ok := q.Take("key-1", now)
+			Expect(ok).To(BeTrue())
+			_, _, ok = q.Take("key-2", now)
+			Expect(ok).To(BeTrue())
+
+			remaining, _, ok := q.Take("key-3", now)
+			Expect(ok).To(BeTrue())
+			Expect(remaining).To(Equal(int64(1)))
+
+			_, _, ok = q.Take("key-4", now)
+			Expect(ok).To(BeTrue())
+			_, _, ok = q.Take("key-3", now)
+			Expect(ok).To(BeFalse())
+
+			remaining, _, ok = q.Take("key-1", now)
+			Expect(ok).To(BeTrue())
+			Expect(remaining).To(BeZero())
+
+			_, _, ok = q.Take("key-3", now.AddDate(0, 0, 1))
+			Expect(ok).To(BeTrue())
+		})
+
+		It("loads no more than max_entries counters", func() {
+			statePath := filepath.Join(GinkgoT().TempDir(), "quota.json")
+			cfg := QuotaConfig{Enabled: true, Limit: 5, Period: "day", MaxEntries: 3, StatePath: statePath, FlushInterval: time.Hour}
+
+			q, err := initQuota(cfg, nil, zap.NewNop())
+			Expect(err).NotTo(HaveOccurred())
+
+			for i := range 3 {
+				_, _, _ = q.Take(fmt.Sprintf("key-%d", i), time.Now())
+			}
+			Expect(q.Stop()).To(Succeed())
+
+			cfg.MaxEntries = 2
+			q, err = initQuota(cfg, nil, zap.NewNop())
+			Expect(err).NotTo(HaveOccurred())
+			defer q.Stop()
+
+			// Two counters were kept, so one key now takes from the overflow counter.
+			var fresh int
+			for i := range 3 {
+				if remaining, _, _ := q.Take(fmt.Sprintf("key-%d", i), time.Now()); remaining == 4 {
+					fresh++
+				}
+			}
+			Expect(fresh).To(Equal(1))
+		})
+
+		It("hands the counters over to the router replacing it", func() {
+			statePath := filepath.Join(GinkgoT().TempDir(), "quota.json")
+			build := func(limit int, prev *Router) *Router {
+				bundle, err := NewRouter(context.Background(), RoutingConfigSet{
+					Service: ServiceConfig{Name: "kono-test"},
+					Routing: RoutingConfig{Quota: QuotaConfig{
+						Enabled:       true,
+						Limit:         limit,
+						Period:        "day",
+						StatePath:     statePath,
+						FlushInterval: time.Hour,
+					}},
+					Previous: prev,
+				}, zap.NewNop())
+				Expect(err).NotTo(HaveOccurred())
+
+				return bundle.Router
+			}
+
+			old := build(3, nil)
+			_, _, _ = old.quota.Take("key-1", time.Now())
+
+			next := build(3, old)
+			Expect(next.quota).To(BeIdenticalTo(old.quota))
+
+			_, _, _ = next.quota.Take("key-1", time.Now())
+			Expect(old.Shutdown(context.Background())).To(Succeed())
+
+			remaining, _, ok := next.quota.Take("key-1", time.Now())
+			Expect(ok).To(BeTrue())
+			Expect(remaining).To(BeZero())
+			Expect(next.Shutdown(context.Background())).To(Succeed())
+
+			changed := build(5, next)
+			defer changed.Close()
+			Expect(changed.quota).NotTo(BeIdenticalTo(next.quota))
+
+			remaining, _, _ = changed.quota.Take("key-1", time.Now())
+			Expect(remaining).To(Equal(int64(1)))
+		})
+
+		It("leaves the counters with the previous router when a reload fails", func() {
+			statePath := filepath.Join(GinkgoT().TempDir(), "quota.json")
+			routing := RoutingConfig{Quota: QuotaConfig{
+				Enabled:       true,
+				Limit:         3,
+				Period:        "day",
+				StatePath:     statePath,
+				FlushInterval: time.Hour,
+			}}
+
+			bundle, err := NewRouter(context.Background(), RoutingConfigSet{
+				Service: ServiceConfig{Name: "kono-test"},
+				Routing: routing,
+			}, zap.NewNop())
+			Expect(err).NotTo(HaveOccurred())
+
+			old := bundle.Router
+
+			routing.TrustedProxies = []string{"not-a-cidr"}
+			_, err = NewRouter(context.Background(), RoutingConfigSet{
+				Service:  ServiceConfig{Name: "kono-test"},
+				Routing:  routing,
+				Previous: old,
+			}, zap.NewNop())
+			Expect(err).To(HaveOccurred())
+
+			// A quota stopped by the failed build would not flush this count.
+			_, _, _ = old.quota.Take("key-1", time.Now())
+			Expect(old.Shutdown(context.Background())).To(Succeed())
+			Expect(os.ReadFile(statePath)).To(ContainSubstring(`"key-1"`))
+		})
+	})
+
+	Describe("initPlugins", func() {
+		It("keeps repeated plugins in execution order", func() {
+			grpcPlugin := func(name, address string, order int) PluginConfig {
+				return PluginConfig{
+					Name:   name,
+					Source: sourceGRPC,
+					Order:  order,
+					GRPC:   &ExtProcConfig{Address: address, Insecure: true, Timeout: time.Second},
+				}
+			}
+
+			cfg := FlowConfig{Plugins: []PluginConfig{
+				grpcPlugin("enrich", "127.0.0.1:1", 0),
+				grpcPlugin("guard", "127.0.0.1:2", -10),
+				grpcPlugin("enrich", "127.0.0.1:3", 0),
+			}}
+
+			plugins, err := initPlugins(cfg.OrderedPlugins(), pluginDeps{}, zap.NewNop())
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(pluginNames(plugins, sdk.PluginTypeRequest)).To(Equal([]string{"guard", "enrich", "enrich"}))
+			Expect(plugins[1].Info().Description).To(HaveSuffix("127.0.0.1:1"))
+			Expect(plugins[2].Info().Description).To(HaveSuffix("127.0.0.1:3"))
+
+			for _, p := range plugins {
+				Expect(p.(sdk.Closer).Close()).To(Succeed())
+			}
+		})
+
+		It("creates a new instance of a registered plugin per config", func() {
+			RegisterPlugin("builder-test", func() sdk.Plugin { return &mockPlugin{name: "builder-test"} })
+
+			plugins, err := initPlugins([]PluginConfig{
+				{Name: "builder-test", Source: sourceRegistry},
+				{Name: "builder-test", Source: sourceRegistry},
+			}, pluginDeps{}, zap.NewNop())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(plugins).To(HaveLen(2))
+			Expect(plugins[0]).NotTo(BeIdenticalTo(plugins[1]))
+
+			_, err = initPlugins([]PluginConfig{{Name: "missing", Source: sourceRegistry}}, pluginDeps{}, zap.NewNop())
+			Expect(err).To(MatchError(ContainSubstring(`plugin "missing" is not registered`)))
+		})
+
+		It("validates the config before Init", func() {
+			p := &validatingPlugin{mockPlugin: mockPlugin{name: "strict"}}
+			RegisterPlugin("strict", func() sdk.Plugin { return p })
+
+			_, err := initPlugins([]PluginConfig{{
+				Name:   "strict",
+				Source: sourceRegistry,
+				Config: map[string]interface{}{"hedaer": "X-Id"},
+			}}, pluginDeps{}, zap.NewNop())
+			Expect(err).To(MatchError(ContainSubstring(`invalid config for plugin strict: unknown key "hedaer"`)))
+			Expect(p.initialized).To(BeFalse())
+
+			_, err = initPlugins([]PluginConfig{{
+				Name:   "strict",
+				Source: sourceRegistry,
+				Config: map[string]interface{}{"header": "X-Id"},
+			}}, pluginDeps{}, zap.NewNop())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p.initialized).To(BeTrue())
+		})
+	})
+
+	Describe("initMiddlewares", func() {
+		It("links the builtin auth middleware without a shared object", func() {
+			mws, err := initMiddlewares([]MiddlewareConfig{{
+				Name:   "auth",
+				Source: sourceBuiltin,
+				Config: map[string]interface{}{
+					"issuer":      "issuer",
+					"audience":    "aud",
+					"alg":         "HS256",
+					"hmac_secret": "c2VjcmV0",
+				},
+			}}, zap.NewNop())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mws).To(HaveLen(1))
+			Expect(mws[0].Name()).To(Equal("auth"))
+			Expect(mws[0].(sdk.Closer).Close()).To(Succeed())
+		})
+
+		It("reports config errors of the linked middleware", func() {
+			_, err := initMiddlewares([]MiddlewareConfig{{
+				Name:   "auth",
+				Source: sourceBuiltin,
+				Config: map[string]interface{}{"issuer": "issuer"},
+			}}, zap.NewNop())
+			Expect(err).To(MatchError(ContainSubstring("missing audience")))
+		})
+
+		It("creates a registered middleware", func() {
+			RegisterMiddleware("builder-test", func() sdk.Middleware { return &mockMiddleware{} })
+
+			mws, err := initMiddlewares([]MiddlewareConfig{{Name: "builder-test", Source: sourceRegistry}}, zap.NewNop())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mws).To(HaveLen(1))
+			Expect(mws[0].Name()).To(Equal("mockmw"))
+		})
+
+		It("exposes the config schemas of linked middlewares", func() {
+			schemas := ConfigSchemas()["middleware"]
+
+			Expect(schemas).To(HaveKey("auth"))
+			Expect(schemas["auth"]).To(HaveKeyWithValue("required", []string{"issuer", "audience", "alg"}))
+			Expect(schemas).To(HaveKey("introspection"))
+		})
+
+		It("collects schemas from factories that register components", func() {
+			reg := newRegistry[sdk.Aggregator]()
+			reg.register("outer", func() sdk.Aggregator {
+				reg.register("inner", func() sdk.Aggregator { return &mockAggregator{} })
+				return &mockAggregator{}
+			})
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				reg.schemas()
+			}()
+
+			Eventually(done).Should(BeClosed())
+			_, ok := reg.lookup("inner")
+			Expect(ok).To(BeTrue())
+		})
+	})
+
+	Describe("verifyObject", func() {
+		var (
+			path string
+			data = []byte("shared object")
+		)
+
+		BeforeEach(func() {
+			path = filepath.Join(GinkgoT().TempDir(), "plugin.so")
+			Expect(os.WriteFile(path, data, 0o600)).To(Succeed())
+		})
+
+		It("checks the pinned digest", func() {
+			sum := sha256.Sum256(data)
+
+			_, err := verifyObject(path, VerifyConfig{SHA256: hex.EncodeToString(sum[:])})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = verifyObject(path, VerifyConfig{SHA256: strings.Repeat("0", 64)})
+			Expect(err).To(MatchError(ContainSubstring("sha256 mismatch")))
+		})
+
+		It("returns a private copy of the verified bytes", func() {
+			sum := sha256.Sum256(data)
+			verify := VerifyConfig{SHA256: hex.EncodeToString(sum[:])}
+
+			copied, err := verifyObject(path, verify)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(copied).NotTo(Equal(path))
+
+			info, err := os.Stat(filepath.Dir(copied))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0o700)))
+
+			// Swapping the file after the check does not change what is opened.
+			Expect(os.WriteFile(path, []byte("swapped"), 0o600)).To(Succeed())
+			Expect(os.ReadFile(copied)).To(Equal(data))
+
+			Expect(os.WriteFile(path, data, 0o600)).To(Succeed())
+			Expect(verifyObject(path, verify)).To(Equal(copied))
+		})
+
+		It("checks the detached signature", func() {
+			pub, priv, err := ed25519.GenerateKey(nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			verify := VerifyConfig{PublicKey: base64.StdEncoding.EncodeToString(pub)}
+
+			_, err = verifyObject(path, verify)
+			Expect(err).To(MatchError(ContainSubstring("read signature")))
+
+			sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data))
+			Expect(os.WriteFile(path+sigSuffix, []byte(sig+"\n"), 0o600)).To(Succeed())
+
+			_, err = verifyObject(path, verify)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(os.WriteFile(path, []byte("tampered"), 0o600)).To(Succeed())
+
+			_, err = verifyObject(path, verify)
+			Expect(err).To(MatchError(ContainSubstring("signature does not match")))
+		})
 	})
 
 	Describe("parseTrustedProxies", func() {
@@ -190,7 +656,7 @@ var _ = Describe("builder", func() {
 				OnConflict: nil,
 			}
 
-			agg, err := initAggregation(cfg, nil)
+			agg, err := initAggregation(cfg, nil, zap.NewNop())
 			Expect(err).To(HaveOccurred())
 			Expect(agg).To(BeZero())
 			Expect(err).To(MatchError(ContainSubstring("unknown aggregation strategy")))
@@ -205,7 +671,7 @@ var _ = Describe("builder", func() {
 				},
 			}
 
-			agg, err := initAggregation(cfg, nil)
+			agg, err := initAggregation(cfg, nil, zap.NewNop())
 			Expect(err).To(HaveOccurred())
 			Expect(agg).To(BeZero())
 			Expect(err).To(MatchError(ContainSubstring("unknown aggregation conflict policy")))
@@ -218,7 +684,7 @@ var _ = Describe("builder", func() {
 				OnConflict: nil,
 			}
 
-			agg, err := initAggregation(cfg, nil)
+			agg, err := initAggregation(cfg, nil, zap.NewNop())
 			Expect(err).NotTo(HaveOccurred())
 			Expect(agg.strategy.String()).To(Equal(cfg.Strategy))
 			Expect(agg.conflictPolicy).To(Equal(conflictPolicyOverwrite))
@@ -236,12 +702,41 @@ var _ = Describe("builder", func() {
 				},
 			}
 
-			agg, err := initAggregation(cfg, []upstream{up})
+			agg, err := initAggregation(cfg, []upstream{up}, zap.NewNop())
 			Expect(err).To(HaveOccurred())
 			Expect(agg).To(BeZero())
 			Expect(err).To(MatchError(ContainSubstring("preferred upstream for on_conflict policy does not exist")))
 		})
 
+		It("with custom strategy initializes a registered aggregator", func() {
+			custom := &mockAggregator{}
+			RegisterAggregator("builder-test", func() sdk.Aggregator { return custom })
+
+			cfg := AggregationConfig{
+				Strategy: strategyCustom.String(),
+				Custom: &AggregatorConfig{
+					Name:   "builder-test",
+					Source: sourceRegistry,
+					Config: map[string]interface{}{"key": "value"},
+				},
+			}
+
+			agg, err := initAggregation(cfg, nil, zap.NewNop())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(agg.custom).To(BeIdenticalTo(custom))
+			Expect(custom.cfg).To(HaveKeyWithValue("key", "value"))
+		})
+
+		It("with custom strategy fails on unregistered aggregator", func() {
+			cfg := AggregationConfig{
+				Strategy: strategyCustom.String(),
+				Custom:   &AggregatorConfig{Name: "missing", Source: sourceRegistry},
+			}
+
+			_, err := initAggregation(cfg, nil, zap.NewNop())
+			Expect(err).To(MatchError(ContainSubstring(`aggregator "missing" is not registered`)))
+		})
+
 		It("succeeds with valid config", func() {
 			up := newTestUpstream("test-service:7001")
 
@@ -253,7 +748,7 @@ var _ = Describe("builder", func() {
 				},
 			}
 
-			agg, err := initAggregation(cfg, []upstream{up})
+			agg, err := initAggregation(cfg, []upstream{up}, zap.NewNop())
 			Expect(err).NotTo(HaveOccurred())
 			Expect(agg.strategy.String()).To(Equal(cfg.Strategy))
 			Expect(agg.conflictPolicy).To(Equal(conflictPolicyFirst))
@@ -270,11 +765,165 @@ var _ = Describe("builder", func() {
 					Timeout: 5 * time.Second,
 				}
 
-				u := buildUpstream(cfg, nil, nil, zap.NewNop())
+				u, err := buildUpstream(cfg, nil, nil, zap.NewNop())
 
+				Expect(err).NotTo(HaveOccurred())
 				Expect(u).NotTo(BeNil())
 				Expect(u.name()).To(Equal("get-test-service:7001-test-service:7002"))
 			})
+
+			It("rejects an invalid select expression", func() {
+				cfg := testUpstreamConfig("7001")
+				cfg.Select = "data.items[*"
+
+				_, err := buildUpstream(cfg, nil, nil, zap.NewNop())
+				Expect(err).To(MatchError(ContainSubstring("compile select expression")))
+			})
+		})
+
+		Describe("buildUpstreamTLSConfig", func() {
+			var (
+				server *httptest.Server
+				caFile string
+			)
+
+			BeforeEach(func() {
+				server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					_, _ = w.Write([]byte(`{"ok":true}`))
+				}))
+				DeferCleanup(server.Close)
+
+				caFile = filepath.Join(GinkgoT().TempDir(), "ca.pem")
+				ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+				Expect(os.WriteFile(caFile, ca, 0o600)).To(Succeed())
+			})
+
+			call := func(tlsCfg UpstreamTLSConfig) *upstreamResponse {
+				cfg := UpstreamConfig{
+					Name:    "secure",
+					Hosts:   AddrList{server.URL},
+					Method:  http.MethodGet,
+					Timeout: time.Second,
+					TLS:     tlsCfg,
+				}
+
+				u, err := buildUpstream(cfg, nil, testMetrics, zap.NewNop())
+				Expect(err).NotTo(HaveOccurred())
+
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+				return u.call(context.Background(), req, nil)
+			}
+
+			It("verifies the upstream against a custom CA bundle", func() {
+				Expect(call(UpstreamTLSConfig{}).err).To(HaveOccurred())
+				Expect(call(UpstreamTLSConfig{CAFile: caFile}).err).To(BeNil())
+			})
+
+			It("verifies the overridden server name", func() {
+				Expect(call(UpstreamTLSConfig{CAFile: caFile, ServerName: "example.com"}).err).To(BeNil())
+				Expect(call(UpstreamTLSConfig{CAFile: caFile, ServerName: "other.test"}).err).To(HaveOccurred())
+			})
+
+			It("skips verification only when asked to", func() {
+				Expect(call(UpstreamTLSConfig{InsecureSkipVerify: true}).err).To(BeNil())
+			})
+
+			It("rejects a CA file without certificates", func() {
+				empty := filepath.Join(GinkgoT().TempDir(), "empty.pem")
+				Expect(os.WriteFile(empty, []byte("nothing"), 0o600)).To(Succeed())
+
+				_, err := buildUpstreamTLSConfig(UpstreamTLSConfig{CAFile: empty})
+				Expect(err).To(MatchError(ContainSubstring("contains no PEM certificates")))
+			})
+
+			It("presents the client certificate for mTLS", func() {
+				dir := GinkgoT().TempDir()
+				clientCAs, certFile, keyFile := issueClientCert(dir)
+
+				server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					_, _ = w.Write([]byte(`{"ok":true}`))
+				}))
+				server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+				server.StartTLS()
+				DeferCleanup(server.Close)
+
+				ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+				Expect(os.WriteFile(caFile, ca, 0o600)).To(Succeed())
+
+				Expect(call(UpstreamTLSConfig{CAFile: caFile}).err).To(HaveOccurred())
+				Expect(call(UpstreamTLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}).err).To(BeNil())
+			})
 		})
 	})
 })
+
+type validatingPlugin struct {
+	mockPlugin
+
+	initialized bool
+}
+
+func (p *validatingPlugin) ValidateConfig(cfg map[string]interface{}) error {
+	for key := range cfg {
+		if key != "header" {
+			return fmt.Errorf("unknown key %q", key)
+		}
+	}
+
+	return nil
+}
+
+func (p *validatingPlugin) Init(map[string]interface{}) error {
+	p.initialized = true
+	return nil
+}
+
+// issueClientCert writes a client certificate and its key to dir, signed by a
+// fresh CA, and returns the pool a server verifies it against.
+func issueClientCert(dir string) (*x509.CertPool, string, string) {
+	caPub, caKey, err := ed25519.GenerateKey(rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kono test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caPub, caKey)
+	Expect(err).NotTo(HaveOccurred())
+
+	caCert, err := x509.ParseCertificate(caDER)
+	Expect(err).NotTo(HaveOccurred())
+
+	clientPub, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "kono"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTemplate, caCert, clientPub, caKey)
+	Expect(err).NotTo(HaveOccurred())
+
+	key, err := x509.MarshalPKCS8PrivateKey(clientKey)
+	Expect(err).NotTo(HaveOccurred())
+
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	Expect(os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientDER}), 0o600)).To(Succeed())
+	Expect(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600)).To(Succeed())
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	return pool, certFile, keyFile
+}
@@ -1,25 +1,25 @@
 package main
 
 import (
+	"encoding/base64"
 	"net/http"
 	"net/http/httptest"
-	"slices"
 	"testing"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/starwalkn/kono/sdk"
 )
 
-func makeHMACToken(t *testing.T, secret []byte, issuer, audience string, exp time.Time) string {
-	claims := jwt.MapClaims{
-		"iss": issuer,
-		"aud": audience,
-		"exp": exp.Unix(),
-	}
+var testSecret = []byte("secret")
+
+func makeHMACToken(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
-	signed, err := token.SignedString(secret)
+	signed, err := token.SignedString(testSecret)
 	if err != nil {
 		t.Fatalf("failed to sign token: %v", err)
 	}
@@ -27,25 +27,54 @@ func makeHMACToken(t *testing.T, secret []byte, issuer, audience string, exp tim
 	return signed
 }
 
-func TestAuthMiddleware_NoAuthHeader(t *testing.T) {
-	m := &Middleware{
-		issuer:   "test-issuer",
-		audience: "test-aud",
-		resolver: &hmacResolver{HMACSecret: []byte("secret")},
-		jwtConfig: jwtConfig{
-			alg:        "HS256",
-			hmacSecret: []byte("secret"),
-		},
+func validClaims(exp time.Time) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss": "test-issuer",
+		"aud": "test-aud",
+		"exp": exp.Unix(),
 	}
+}
 
-	handler := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
-		w.Write([]byte("ok"))
-	}))
+func newTestMiddleware(t *testing.T, extra map[string]interface{}) sdk.Middleware {
+	t.Helper()
+
+	config := map[string]interface{}{
+		"issuer":      "test-issuer",
+		"audience":    "test-aud",
+		"alg":         "HS256",
+		"hmac_secret": base64.StdEncoding.EncodeToString(testSecret),
+	}
+
+	for k, v := range extra {
+		config[k] = v
+	}
+
+	m := NewMiddleware()
+	if err := m.Init(config); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+
+	return m
+}
+
+func serve(m sdk.Middleware, token string, next http.HandlerFunc) *httptest.ResponseRecorder {
+	if next == nil {
+		next = func(w http.ResponseWriter, _ *http.Request) { w.Write([]byte("ok")) }
+	}
 
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
 	rec := httptest.NewRecorder()
+	m.Handler(next).ServeHTTP(rec, req)
 
-	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAuthMiddleware_NoAuthHeader(t *testing.T) {
+	rec := serve(newTestMiddleware(t, nil), "", nil)
 
 	if rec.Code != http.StatusUnauthorized {
 		t.Fatalf("expected 401, got %d", rec.Code)
@@ -53,54 +82,41 @@ func TestAuthMiddleware_NoAuthHeader(t *testing.T) {
 }
 
 func TestAuthMiddleware_InvalidToken(t *testing.T) {
-	m := &Middleware{
-		issuer:   "test-issuer",
-		audience: "test-aud",
-		resolver: &hmacResolver{HMACSecret: []byte("secret")},
-		jwtConfig: jwtConfig{
-			alg:        "HS256",
-			hmacSecret: []byte("secret"),
-		},
-	}
+	rec := serve(newTestMiddleware(t, nil), "invalid-token", nil)
 
-	handler := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
-		w.Write([]byte("ok"))
-	}))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rec.Code)
+	}
+}
 
-	req := httptest.NewRequest(http.MethodGet, "/", nil)
-	req.Header.Set("Authorization", "Bearer invalid-token")
-	rec := httptest.NewRecorder()
+func TestAuthMiddleware_ExpiredToken(t *testing.T) {
+	token := makeHMACToken(t, validClaims(time.Now().Add(-time.Hour)))
 
-	handler.ServeHTTP(rec, req)
+	rec := serve(newTestMiddleware(t, nil), token, nil)
 
 	if rec.Code != http.StatusUnauthorized {
 		t.Fatalf("expected 401, got %d", rec.Code)
 	}
 }
 
-func TestAuthMiddleware_ExpiredToken(t *testing.T) {
-	secret := []byte("secret")
-	token := makeHMACToken(t, secret, "test-issuer", "test-aud", time.Now().Add(-time.Hour))
-
-	m := &Middleware{
-		issuer:   "test-issuer",
-		audience: "test-aud",
-		resolver: &hmacResolver{HMACSecret: secret},
-		jwtConfig: jwtConfig{
-			alg:        "HS256",
-			hmacSecret: secret,
-		},
+func TestAuthMiddleware_Leeway(t *testing.T) {
+	token := makeHMACToken(t, validClaims(time.Now().Add(-30*time.Second)))
+
+	if rec := serve(newTestMiddleware(t, nil), token, nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with default leeway, got %d", rec.Code)
 	}
 
-	handler := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
-		w.Write([]byte("ok"))
-	}))
+	m := newTestMiddleware(t, map[string]interface{}{"leeway": "1m"})
+	if rec := serve(m, token, nil); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 within leeway, got %d", rec.Code)
+	}
+}
 
-	req := httptest.NewRequest(http.MethodGet, "/", nil)
-	req.Header.Set("Authorization", "Bearer "+token)
-	rec := httptest.NewRecorder()
+func TestAuthMiddleware_WrongAudience(t *testing.T) {
+	claims := validClaims(time.Now().Add(time.Hour))
+	claims["aud"] = "other"
 
-	handler.ServeHTTP(rec, req)
+	rec := serve(newTestMiddleware(t, nil), makeHMACToken(t, claims), nil)
 
 	if rec.Code != http.StatusUnauthorized {
 		t.Fatalf("expected 401, got %d", rec.Code)
@@ -108,47 +124,83 @@ func TestAuthMiddleware_ExpiredToken(t *testing.T) {
 }
 
 func TestAuthMiddleware_ValidToken(t *testing.T) {
-	secret := []byte("secret")
-	token := makeHMACToken(t, secret, "test-issuer", "test-aud", time.Now().Add(time.Hour))
-
-	m := &Middleware{
-		issuer:   "test-issuer",
-		audience: "test-aud",
-		resolver: &hmacResolver{HMACSecret: secret},
-		jwtConfig: jwtConfig{
-			alg:        "HS256",
-			hmacSecret: secret,
-		},
+	token := makeHMACToken(t, validClaims(time.Now().Add(time.Hour)))
+
+	var gotClaims map[string]any
+
+	rec := serve(newTestMiddleware(t, nil), token, func(w http.ResponseWriter, r *http.Request) {
+		gotClaims = sdk.ClaimsFromContext(r.Context())
+		w.Write([]byte("ok"))
+	})
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
 	}
 
-	var gotClaims *jwt.MapClaims
-	handler := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		claims := r.Context().Value(ctxKeyClaims{}).(*jwt.MapClaims)
-		gotClaims = claims
+	if gotClaims == nil {
+		t.Fatal("expected claims in context")
+	}
+
+	if iss := gotClaims["iss"]; iss != "test-issuer" {
+		t.Fatalf("unexpected issuer: %v", iss)
+	}
+}
+
+func TestAuthMiddleware_ClaimsToHeaders(t *testing.T) {
+	claims := validClaims(time.Now().Add(time.Hour))
+	claims["sub"] = "user-1"
+	claims["roles"] = []string{"admin", "dev"}
+
+	m := newTestMiddleware(t, map[string]interface{}{
+		"claims_to_headers": map[string]interface{}{
+			"sub":    "X-User-ID",
+			"roles":  "x-user-roles",
+			"tenant": "X-Tenant",
+		},
+	})
+
+	var got http.Header
 
+	next := func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
 		w.Write([]byte("ok"))
-	}))
+	}
 
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
-	req.Header.Set("Authorization", "Bearer "+token)
-	rec := httptest.NewRecorder()
+	req.Header.Set("Authorization", "Bearer "+makeHMACToken(t, claims))
+	req.Header.Set("X-Tenant", "spoofed")
 
-	handler.ServeHTTP(rec, req)
+	rec := httptest.NewRecorder()
+	m.Handler(http.HandlerFunc(next)).ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusOK {
 		t.Fatalf("expected 200, got %d", rec.Code)
 	}
 
-	if gotClaims == nil {
-		t.Fatal("expected claims in context")
+	if v := got.Get("X-User-ID"); v != "user-1" {
+		t.Fatalf("unexpected X-User-ID: %q", v)
+	}
+
+	if v := got.Get("X-User-Roles"); v != "admin,dev" {
+		t.Fatalf("unexpected X-User-Roles: %q", v)
 	}
 
-	if iss, _ := gotClaims.GetIssuer(); iss != "test-issuer" {
-		t.Fatalf("unexpected issuer: %s", iss)
+	if v := got.Get("X-Tenant"); v != "" {
+		t.Fatalf("client-supplied X-Tenant must be dropped, got %q", v)
 	}
+}
 
-	aud, _ := gotClaims.GetAudience()
-	if !slices.Contains(aud, "test-aud") {
-		t.Fatalf("audience missing: %v", aud)
+func TestAuthMiddleware_InvalidClaimsToHeaders(t *testing.T) {
+	m := NewMiddleware()
+
+	err := m.Init(map[string]interface{}{
+		"issuer":            "test-issuer",
+		"audience":          "test-aud",
+		"alg":               "HS256",
+		"hmac_secret":       base64.StdEncoding.EncodeToString(testSecret),
+		"claims_to_headers": []interface{}{"sub"},
+	})
+	if err == nil {
+		t.Fatal("expected an error for a non-map claims_to_headers")
 	}
 }
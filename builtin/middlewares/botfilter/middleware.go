@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/starwalkn/kono/internal/ratelimit"
+	"github.com/starwalkn/kono/sdk"
+)
+
+const (
+	meterName        = "github.com/starwalkn/kono/middlewares/botfilter"
+	defaultTagHeader = "X-Bot-Class"
+
+	// classHuman is reported for user agents no rule matches, classEmpty for
+	// requests without one.
+	classHuman = "human"
+	classEmpty = "empty"
+)
+
+// Actions taken for a class with the "actions" config key.
+const (
+	actionAllow    = "allow"
+	actionTag      = "tag"
+	actionThrottle = "throttle"
+	actionBlock    = "block"
+)
+
+// knownBots classifies well-known crawlers and HTTP clients. They are matched
+// after the configured rules, in order, so a class of its own or an earlier
+// rule can take any of them over.
+var knownBots = []rule{
+	{class: "search_engine", pattern: regexp.MustCompile(`(?i)googlebot|bingbot|duckduckbot|yandexbot|baiduspider|applebot|slurp`)},
+	{class: "ai_crawler", pattern: regexp.MustCompile(`(?i)gptbot|chatgpt-user|claudebot|anthropic-ai|ccbot|perplexitybot|bytespider|google-extended`)},
+	{class: "tool", pattern: regexp.MustCompile(`(?i)^(curl|wget|python-requests|python-urllib|go-http-client|okhttp|java|libwww-perl|httpie)/`)},
+	// "bot" must stand alone or carry a version, as in "AhrefsBot/7.0", so device
+	// names such as "Cubot" do not match.
+	{class: "bot", pattern: regexp.MustCompile(`(?i)\bbot\b|bot/|crawler|spider|scraper|scrapy|headlesschrome`)},
+}
+
+type rule struct {
+	class   string
+	pattern *regexp.Regexp
+}
+
+// Middleware classifies requests by User-Agent and tags, throttles or blocks
+// them per class. Classes without an action are let through untouched.
+type Middleware struct {
+	rules     []rule
+	actions   map[string]string
+	tagHeader string
+	throttle  *ratelimit.RateLimit
+
+	requests metric.Int64Counter
+}
+
+func NewMiddleware() sdk.Middleware {
+	return &Middleware{}
+}
+
+func (m *Middleware) Name() string {
+	return "botfilter"
+}
+
+func (m *Middleware) Init(cfg map[string]interface{}) error {
+	rules, err := parseRules(cfg["rules"])
+	if err != nil {
+		return err
+	}
+
+	m.rules = rules
+
+	if known, ok := cfg["known_bots"].(bool); !ok || known {
+		m.rules = append(m.rules, knownBots...)
+	}
+
+	m.actions, err = parseActions(cfg["actions"])
+	if err != nil {
+		return err
+	}
+
+	m.tagHeader = defaultTagHeader
+	if header, ok := cfg["tag_header"].(string); ok && header != "" {
+		m.tagHeader = http.CanonicalHeaderKey(header)
+	}
+
+	throttleCfg, _ := cfg["throttle"].(map[string]interface{})
+
+	throttle, err := ratelimit.New(throttleCfg)
+	if err != nil {
+		return fmt.Errorf("throttle: %w", err)
+	}
+
+	// The limiter only runs when a class is throttled.
+	if slices.Contains(slices.Collect(maps.Values(m.actions)), actionThrottle) {
+		if err = throttle.Start(); err != nil {
+			return fmt.Errorf("throttle: %w", err)
+		}
+
+		m.throttle = throttle
+	}
+
+	counter, err := otel.Meter(meterName).Int64Counter(
+		"kono.botfilter.requests",
+		metric.WithDescription("Requests seen by the bot filter, by class and action."),
+	)
+	if err != nil {
+		return fmt.Errorf("create requests counter: %w", err)
+	}
+
+	m.requests = counter
+
+	return nil
+}
+
+func (m *Middleware) ConfigSchema() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"known_bots": map[string]any{"type": "boolean", "description": "Classify well-known crawlers and clients (default true)."},
+			"rules": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type":     "object",
+					"required": []string{"class", "patterns"},
+					"properties": map[string]any{
+						"class":    map[string]any{"type": "string", "minLength": 1},
+						"patterns": map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+					},
+				},
+			},
+			"actions": map[string]any{
+				"type":                 "object",
+				"additionalProperties": map[string]any{"enum": []string{actionAllow, actionTag, actionThrottle, actionBlock}},
+			},
+			"tag_header": map[string]any{"type": "string"},
+			"throttle": map[string]any{
+				"type":        "object",
+				"description": "Rate limit for throttled classes, per client IP and class.",
+				"properties": map[string]any{
+					"limit":     map[string]any{"type": "integer", "minimum": 1},
+					"window":    map[string]any{"type": "string"},
+					"algorithm": map[string]any{"enum": []string{ratelimit.AlgorithmFixedWindow, ratelimit.AlgorithmSlidingWindow, ratelimit.AlgorithmTokenBucket}},
+					"burst":     map[string]any{"type": "integer", "minimum": 1},
+				},
+			},
+		},
+	}
+}
+
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Clients must not classify themselves.
+		r.Header.Del(m.tagHeader)
+
+		class := m.classify(r.UserAgent())
+		action := m.actions[class]
+
+		if action == "" {
+			action = actionAllow
+		}
+
+		m.count(r.Context(), class, action)
+
+		switch action {
+		case actionBlock:
+			writeError(w, "FORBIDDEN", http.StatusForbidden)
+			return
+		case actionThrottle:
+			if !m.throttle.Allow(class + "|" + clientIP(r)) {
+				writeError(w, "RATE_LIMIT_EXCEEDED", http.StatusTooManyRequests)
+				return
+			}
+		case actionTag:
+			r.Header.Set(m.tagHeader, class)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *Middleware) Close() error {
+	if m.throttle == nil {
+		return nil
+	}
+
+	return m.throttle.Stop()
+}
+
+func (m *Middleware) classify(userAgent string) string {
+	if userAgent == "" {
+		return classEmpty
+	}
+
+	for _, r := range m.rules {
+		if r.pattern.MatchString(userAgent) {
+			return r.class
+		}
+	}
+
+	return classHuman
+}
+
+func (m *Middleware) count(ctx context.Context, class, action string) {
+	m.requests.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("class", class),
+		attribute.String("action", action),
+	))
+}
+
+// parseRules compiles the configured rules. The patterns of a rule are joined
+// into one case-insensitive expression.
+func parseRules(raw any) ([]rule, error) {
+	if raw == nil {
+		return nil, nil
+	}
+
+	entries, ok := raw.([]interface{})
+	if !ok {
+		return nil, errors.New("rules must be an array")
+	}
+
+	rules := make([]rule, 0, len(entries))
+
+	for i, v := range entries {
+		entry, isMap := v.(map[string]interface{})
+		if !isMap {
+			return nil, fmt.Errorf("rules[%d] must be an object", i)
+		}
+
+		class, _ := entry["class"].(string)
+		if class == "" {
+			return nil, fmt.Errorf("rules[%d]: missing class", i)
+		}
+
+		patterns, _ := entry["patterns"].([]interface{})
+		if len(patterns) == 0 {
+			return nil, fmt.Errorf("rules[%d]: missing patterns", i)
+		}
+
+		exprs := make([]string, 0, len(patterns))
+
+		for _, p := range patterns {
+			expr, isString := p.(string)
+			if !isString || expr == "" {
+				return nil, fmt.Errorf("rules[%d]: patterns must be non-empty strings", i)
+			}
+
+			if _, err := regexp.Compile(expr); err != nil {
+				return nil, fmt.Errorf("rules[%d]: invalid pattern %q: %w", i, expr, err)
+			}
+
+			exprs = append(exprs, "(?:"+expr+")")
+		}
+
+		rules = append(rules, rule{class: class, pattern: regexp.MustCompile("(?i)" + strings.Join(exprs, "|"))})
+	}
+
+	return rules, nil
+}
+
+func parseActions(raw any) (map[string]string, error) {
+	actions := make(map[string]string)
+
+	if raw == nil {
+		return actions, nil
+	}
+
+	entries, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("actions must be a map of class to action")
+	}
+
+	for class, v := range entries {
+		action, _ := v.(string)
+
+		switch action {
+		case actionAllow, actionTag, actionThrottle, actionBlock:
+			actions[class] = action
+		default:
+			return nil, fmt.Errorf("actions: unknown action %q for class %q", v, class)
+		}
+	}
+
+	return actions, nil
+}
+
+// clientIP returns the client IP resolved by the gateway, or the peer address.
+func clientIP(r *http.Request) string {
+	if ip := sdk.ClientIPFromContext(r.Context()); ip != "" {
+		return ip
+	}
+
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+
+	return r.RemoteAddr
+}
+
+func writeError(w http.ResponseWriter, code string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(`{"errors":["` + code + `"]}`))
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func newBotMiddleware(t *testing.T, cfg map[string]interface{}) *Middleware {
+	t.Helper()
+
+	m := &Middleware{}
+	if err := m.Init(cfg); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+
+	t.Cleanup(func() { _ = m.Close() })
+
+	return m
+}
+
+// serve sends a request with the given User-Agent and returns the response code
+// and the tag header the next handler saw.
+func serve(m *Middleware, userAgent string) (int, string) {
+	var tag string
+
+	handler := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tag = r.Header.Get(m.tagHeader)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("X-Bot-Class", "human")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	return rec.Code, tag
+}
+
+func TestBotFilter_Classify(t *testing.T) {
+	m := newBotMiddleware(t, map[string]interface{}{
+		"rules": []interface{}{
+			map[string]interface{}{"class": "monitor", "patterns": []interface{}{"UptimeRobot", "Pingdom"}},
+		},
+	})
+
+	for userAgent, want := range map[string]string{
+		"":                                classEmpty,
+		"Mozilla/5.0 (X11; Linux x86_64)": classHuman,
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)": "search_engine",
+		"Mozilla/5.0 (compatible; GPTBot/1.0)":                                     "ai_crawler",
+		"curl/8.5.0":                                                               "tool",
+		"SomeCrawler/1.0":                                                          "bot",
+		"Mozilla/5.0 (compatible; AhrefsBot/7.0; +http://ahrefs.com/robot/)":       "bot",
+		"Mozilla/5.0 (Linux; Android 10; Cubot X30) AppleWebKit/537.36":            classHuman,
+		"Mozilla/5.0 (compatible; UptimeRobot/2.0)":                                "monitor",
+		"pingdom.com_bot_version_1.4":                                              "monitor",
+	} {
+		if got := m.classify(userAgent); got != want {
+			t.Errorf("classify(%q) = %q, want %q", userAgent, got, want)
+		}
+	}
+}
+
+func TestBotFilter_KnownBotsDisabled(t *testing.T) {
+	m := newBotMiddleware(t, map[string]interface{}{"known_bots": false})
+
+	if got := m.classify("curl/8.5.0"); got != classHuman {
+		t.Errorf("expected %q without the known list, got %q", classHuman, got)
+	}
+}
+
+func TestBotFilter_Actions(t *testing.T) {
+	m := newBotMiddleware(t, map[string]interface{}{
+		"actions": map[string]interface{}{
+			"search_engine": "tag",
+			"ai_crawler":    "block",
+			"tool":          "throttle",
+		},
+		"throttle": map[string]interface{}{"limit": 1, "window": "1m"},
+	})
+
+	if code, tag := serve(m, "Googlebot/2.1"); code != http.StatusOK || tag != "search_engine" {
+		t.Errorf("tag: got %d %q", code, tag)
+	}
+
+	if code, _ := serve(m, "GPTBot/1.0"); code != http.StatusForbidden {
+		t.Errorf("block: expected 403, got %d", code)
+	}
+
+	if code, _ := serve(m, "curl/8.5.0"); code != http.StatusOK {
+		t.Errorf("throttle: expected the first request through, got %d", code)
+	}
+
+	if code, _ := serve(m, "curl/8.5.0"); code != http.StatusTooManyRequests {
+		t.Errorf("throttle: expected 429, got %d", code)
+	}
+
+	if code, tag := serve(m, "Mozilla/5.0"); code != http.StatusOK || tag != "" {
+		t.Errorf("allow: got %d, client-supplied tag %q", code, tag)
+	}
+}
+
+func TestBotFilter_Metrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	prev := otel.GetMeterProvider()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	t.Cleanup(func() { otel.SetMeterProvider(prev) })
+
+	m := newBotMiddleware(t, map[string]interface{}{
+		"actions": map[string]interface{}{"tool": "block"},
+	})
+
+	serve(m, "curl/8.5.0")
+	serve(m, "curl/8.5.0")
+	serve(m, "Mozilla/5.0")
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+
+	counts := make(map[string]int64)
+
+	for _, sm := range rm.ScopeMetrics {
+		for _, metric := range sm.Metrics {
+			sum, ok := metric.Data.(metricdata.Sum[int64])
+			if !ok || metric.Name != "kono.botfilter.requests" {
+				continue
+			}
+
+			for _, dp := range sum.DataPoints {
+				class, _ := dp.Attributes.Value(attribute.Key("class"))
+				action, _ := dp.Attributes.Value(attribute.Key("action"))
+				counts[class.AsString()+"/"+action.AsString()] = dp.Value
+			}
+		}
+	}
+
+	if counts["tool/block"] != 2 || counts["human/allow"] != 1 {
+		t.Errorf("unexpected counts %v", counts)
+	}
+}
+
+func TestBotFilter_ThrottleOnlyWhenUsed(t *testing.T) {
+	m := newBotMiddleware(t, map[string]interface{}{
+		"actions":  map[string]interface{}{"tool": "block"},
+		"throttle": map[string]interface{}{"limit": 1, "window": "1m"},
+	})
+
+	if m.throttle != nil {
+		t.Error("expected no throttle limiter without a throttled class")
+	}
+
+	if code, _ := serve(m, "Mozilla/5.0"); code != http.StatusOK {
+		t.Errorf("expected 200, got %d", code)
+	}
+}
+
+func TestBotFilter_InitErrors(t *testing.T) {
+	for name, cfg := range map[string]map[string]interface{}{
+		"unknown action":    {"actions": map[string]interface{}{"bot": "drop"}},
+		"invalid pattern":   {"rules": []interface{}{map[string]interface{}{"class": "x", "patterns": []interface{}{"("}}}},
+		"missing class":     {"rules": []interface{}{map[string]interface{}{"patterns": []interface{}{"x"}}}},
+		"unknown algorithm": {"throttle": map[string]interface{}{"algorithm": "leaky"}},
+	} {
+		if err := (&Middleware{}).Init(cfg); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"github.com/starwalkn/kono/internal/geoip"
+	"github.com/starwalkn/kono/sdk"
+)
+
+// NewMiddleware exposes the built-in GeoIP middleware as a plugin. The gateway
+// links the same implementation in-process for source "builtin".
+func NewMiddleware() sdk.Middleware {
+	return geoip.New()
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/starwalkn/kono/sdk"
+)
+
+// writeTestDB writes an IPv6 MaxMind DB with 24-bit records that maps the single
+// network 81.2.69.0/24 to a Berlin city record.
+func writeTestDB(t *testing.T) string {
+	t.Helper()
+
+	data := encodeMap(
+		"country", encodeMap("iso_code", encodeString("DE")),
+		"subdivisions", encodeArray(encodeMap("iso_code", encodeString("BE"))),
+		"city", encodeMap("names", encodeMap("en", encodeString("Berlin"))),
+	)
+
+	// IPv4 networks live under ::/96 in an IPv6 tree.
+	network := netip.AddrFrom16([16]byte{12: 81, 13: 2, 14: 69})
+	const prefixLen = 120
+
+	nodeCount := uint32(prefixLen)
+	ip := network.As16()
+
+	var tree []byte
+
+	for i := range prefixLen {
+		next := uint32(i + 1)
+		if i == prefixLen-1 {
+			next = nodeCount + 16 // Data section offset 0.
+		}
+
+		records := [2]uint32{nodeCount, nodeCount}
+		records[ip[i/8]>>(7-i%8)&1] = next
+
+		for _, rec := range records {
+			tree = append(tree, byte(rec>>16), byte(rec>>8), byte(rec))
+		}
+	}
+
+	meta := encodeMap(
+		"node_count", encodeUint32(nodeCount),
+		"record_size", encodeUint16(24),
+		"ip_version", encodeUint16(6),
+	)
+
+	buf := append(tree, make([]byte, 16)...)
+	buf = append(buf, data...)
+	buf = append(buf, "\xAB\xCD\xEFMaxMind.com"...)
+	buf = append(buf, meta...)
+
+	path := filepath.Join(t.TempDir(), "city.mmdb")
+	if err := os.WriteFile(path, buf, 0o600); err != nil {
+		t.Fatalf("write database: %v", err)
+	}
+
+	return path
+}
+
+func encodeString(s string) []byte {
+	return append([]byte{2<<5 | byte(len(s))}, s...)
+}
+
+func encodeUint16(v uint16) []byte {
+	return binary.BigEndian.AppendUint16([]byte{5<<5 | 2}, v)
+}
+
+func encodeUint32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32([]byte{6<<5 | 4}, v)
+}
+
+func encodeMap(pairs ...any) []byte {
+	b := []byte{7<<5 | byte(len(pairs)/2)}
+
+	for i := 0; i < len(pairs); i += 2 {
+		b = append(b, encodeString(pairs[i].(string))...)
+		b = append(b, pairs[i+1].([]byte)...)
+	}
+
+	return b
+}
+
+func encodeArray(items ...[]byte) []byte {
+	b := []byte{byte(len(items)), 11 - 7} // Extended type 11.
+
+	for _, item := range items {
+		b = append(b, item...)
+	}
+
+	return b
+}
+
+func newTestMiddleware(t *testing.T, config map[string]interface{}) sdk.Middleware {
+	t.Helper()
+
+	m := NewMiddleware()
+
+	config["database"] = writeTestDB(t)
+	if err := m.Init(config); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+
+	return m
+}
+
+func serve(m sdk.Middleware, req *http.Request) (*http.Request, bool) {
+	var (
+		seen  *http.Request
+		found bool
+	)
+
+	m.Handler(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		seen = r
+		_, found = sdk.GeoFromContext(r.Context())
+	})).ServeHTTP(httptest.NewRecorder(), req)
+
+	return seen, found
+}
+
+func TestMiddleware_KnownAddress(t *testing.T) {
+	m := newTestMiddleware(t, map[string]interface{}{})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = req.WithContext(sdk.WithClientIP(req.Context(), "81.2.69.142"))
+
+	seen, found := serve(m, req)
+	if !found {
+		t.Fatal("expected location in context")
+	}
+
+	geo, _ := sdk.GeoFromContext(seen.Context())
+	if geo != (sdk.Geo{Country: "DE", Region: "BE", City: "Berlin"}) {
+		t.Errorf("unexpected location %+v", geo)
+	}
+
+	for header, want := range map[string]string{"X-Geo-Country": "DE", "X-Geo-Region": "BE", "X-Geo-City": "Berlin"} {
+		if got := seen.Header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestMiddleware_UnknownAddressClearsHeaders(t *testing.T) {
+	m := newTestMiddleware(t, map[string]interface{}{})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.1.2.3:4567"
+	req.Header.Set("X-Geo-Country", "US")
+
+	seen, found := serve(m, req)
+	if found {
+		t.Error("expected no location for an address missing from the database")
+	}
+
+	if got := seen.Header.Get("X-Geo-Country"); got != "" {
+		t.Errorf("client-supplied header was forwarded: %q", got)
+	}
+}
+
+func TestMiddleware_FallsBackToRemoteAddr(t *testing.T) {
+	m := newTestMiddleware(t, map[string]interface{}{})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "81.2.69.1:4567"
+
+	if _, found := serve(m, req); !found {
+		t.Error("expected location resolved from the peer address")
+	}
+}
+
+func TestMiddleware_CustomHeaders(t *testing.T) {
+	m := newTestMiddleware(t, map[string]interface{}{
+		"headers": map[string]interface{}{"country": "cf-ipcountry", "city": ""},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "81.2.69.1:4567"
+
+	seen, _ := serve(m, req)
+
+	if got := seen.Header.Get("Cf-Ipcountry"); got != "DE" {
+		t.Errorf("Cf-Ipcountry = %q, want DE", got)
+	}
+
+	if got := seen.Header.Get("X-Geo-City"); got != "" {
+		t.Errorf("disabled header was set: %q", got)
+	}
+
+	if got := seen.Header.Get("X-Geo-Region"); got != "BE" {
+		t.Errorf("X-Geo-Region = %q, want BE", got)
+	}
+}
+
+func TestMiddleware_InitErrors(t *testing.T) {
+	bad := filepath.Join(t.TempDir(), "bad.mmdb")
+	if err := os.WriteFile(bad, []byte("not a database"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, config := range map[string]map[string]interface{}{
+		"missing database": {},
+		"missing file":     {"database": filepath.Join(t.TempDir(), "none.mmdb")},
+		"invalid file":     {"database": bad},
+		"unknown field":    {"database": writeTestDB(t), "headers": map[string]interface{}{"asn": "X-Asn"}},
+	} {
+		if err := NewMiddleware().Init(config); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
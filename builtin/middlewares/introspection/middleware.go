@@ -0,0 +1,13 @@
+package main
+
+import (
+	"github.com/starwalkn/kono/internal/introspect"
+	"github.com/starwalkn/kono/sdk"
+)
+
+// NewMiddleware exposes the built-in token introspection middleware as a
+// plugin. The gateway links the same implementation in-process for source
+// "builtin".
+func NewMiddleware() sdk.Middleware {
+	return introspect.New()
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/starwalkn/kono/sdk"
+)
+
+// newIntrospectionServer answers active for the token "good", inactive for anything
+// else, and counts the calls it receives.
+func newIntrospectionServer(t *testing.T, calls *atomic.Int32) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+
+		if user, pass, ok := r.BasicAuth(); !ok || user != "gateway" || pass != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		resp := map[string]any{"active": false}
+
+		switch r.PostForm.Get("token") {
+		case "good":
+			resp = map[string]any{"active": true, "sub": "user-1", "scope": "read"}
+		case "expired":
+			resp = map[string]any{"active": true, "sub": "user-1", "exp": time.Now().Add(-time.Minute).Unix()}
+		}
+
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func newTestMiddleware(t *testing.T, endpoint string) sdk.Middleware {
+	t.Helper()
+
+	m := NewMiddleware()
+
+	err := m.Init(map[string]interface{}{
+		"endpoint":      endpoint,
+		"client_id":     "gateway",
+		"client_secret": "s3cret",
+		"cache_ttl":     "1m",
+	})
+	if err != nil {
+		t.Fatalf("init: %v", err)
+	}
+
+	return m
+}
+
+func serve(m sdk.Middleware, token string) (*httptest.ResponseRecorder, map[string]any) {
+	var claims map[string]any
+
+	handler := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims = sdk.ClaimsFromContext(r.Context())
+		w.Write([]byte("ok"))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	return rec, claims
+}
+
+func TestIntrospection_MissingToken(t *testing.T) {
+	var calls atomic.Int32
+
+	rec, _ := serve(newTestMiddleware(t, newIntrospectionServer(t, &calls).URL), "")
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rec.Code)
+	}
+
+	if calls.Load() != 0 {
+		t.Fatalf("expected no introspection call, got %d", calls.Load())
+	}
+}
+
+func TestIntrospection_ActiveToken(t *testing.T) {
+	var calls atomic.Int32
+
+	rec, claims := serve(newTestMiddleware(t, newIntrospectionServer(t, &calls).URL), "good")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	if claims["sub"] != "user-1" {
+		t.Fatalf("expected introspection claims in context, got %v", claims)
+	}
+}
+
+func TestIntrospection_InactiveToken(t *testing.T) {
+	var calls atomic.Int32
+
+	for _, token := range []string{"revoked", "expired"} {
+		rec, _ := serve(newTestMiddleware(t, newIntrospectionServer(t, &calls).URL), token)
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected 401, got %d", token, rec.Code)
+		}
+	}
+}
+
+func TestIntrospection_CachesResults(t *testing.T) {
+	var calls atomic.Int32
+
+	m := newTestMiddleware(t, newIntrospectionServer(t, &calls).URL)
+
+	for range 3 {
+		serve(m, "good")
+		serve(m, "revoked")
+	}
+
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("expected one call per distinct token, got %d", got)
+	}
+}
+
+func TestIntrospection_EndpointFailure(t *testing.T) {
+	var calls atomic.Int32
+
+	m := NewMiddleware()
+	if err := m.Init(map[string]interface{}{"endpoint": newIntrospectionServer(t, &calls).URL}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+
+	// Without client credentials the endpoint rejects the call.
+	for range 2 {
+		if rec, _ := serve(m, "good"); rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("expected 503, got %d", rec.Code)
+		}
+	}
+
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("failed calls must not be cached, got %d calls", got)
+	}
+}
+
+func TestIntrospection_InvalidConfig(t *testing.T) {
+	if err := NewMiddleware().Init(map[string]interface{}{}); err == nil {
+		t.Fatal("expected an error without endpoint")
+	}
+
+	err := NewMiddleware().Init(map[string]interface{}{"endpoint": "http://idp", "timeout": "soon"})
+	if err == nil {
+		t.Fatal("expected an error for an invalid timeout")
+	}
+}
+
+func TestIntrospection_CacheEvictsOneEntry(t *testing.T) {
+	var calls atomic.Int32
+
+	m := NewMiddleware()
+
+	err := m.Init(map[string]interface{}{
+		"endpoint":          newIntrospectionServer(t, &calls).URL,
+		"client_id":         "gateway",
+		"client_secret":     "s3cret",
+		"cache_ttl":         "1m",
+		"cache_max_entries": float64(2),
+	})
+	if err != nil {
+		t.Fatalf("init: %v", err)
+	}
+
+	serve(m, "good")
+	serve(m, "revoked")
+	serve(m, "other")
+
+	// The cache holds two tokens: the first one cached was evicted, the second kept.
+	calls.Store(0)
+	serve(m, "revoked")
+
+	if got := calls.Load(); got != 0 {
+		t.Fatalf("expected the newer entry to stay cached, got %d calls", got)
+	}
+
+	serve(m, "good")
+
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("expected the oldest entry to be evicted, got %d calls", got)
+	}
+}
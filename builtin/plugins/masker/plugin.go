@@ -2,20 +2,42 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 
 	"github.com/starwalkn/kono/sdk"
 )
 
-const maskValue = "***"
+const (
+	defaultMask = "***"
+	meterName   = "github.com/starwalkn/kono/plugins/masker"
+)
 
+// Plugin redacts sensitive data in JSON response bodies. A field name without
+// dots is masked at any depth; a dotted path ("card.number") is matched from the
+// document root, with arrays traversed transparently. Patterns mask the matching
+// parts of any string value.
 type Plugin struct {
-	fields map[string]struct{}
+	fields   map[string]struct{}
+	paths    map[string]struct{} // dotted paths from the root.
+	patterns []*regexp.Regexp
+	mask     string
+
+	redactions metric.Int64Counter
 }
 
+// redactions counts the values masked during one Execute, per rule.
+type redactions map[string]int64
+
 func NewPlugin() sdk.Plugin {
 	return &Plugin{}
 }
@@ -23,8 +45,8 @@ func NewPlugin() sdk.Plugin {
 func (p *Plugin) Info() sdk.PluginInfo {
 	return sdk.PluginInfo{
 		Name:        "masker",
-		Description: "Masks sensitive fields in JSON response body.",
-		Version:     "v1",
+		Description: "Masks sensitive fields and values matching patterns in JSON response body.",
+		Version:     "v2",
 		Author:      "starwalkn",
 	}
 }
@@ -35,18 +57,71 @@ func (p *Plugin) Type() sdk.PluginType {
 
 func (p *Plugin) Init(cfg map[string]interface{}) error {
 	p.fields = make(map[string]struct{})
+	p.paths = make(map[string]struct{})
+	p.mask = defaultMask
 
-	raw, ok := cfg["fields"].([]interface{})
-	if !ok {
-		return errors.New("fields must be an array")
+	rawFields, hasFields := cfg["fields"]
+	rawPatterns, hasPatterns := cfg["patterns"]
+
+	if !hasFields && !hasPatterns {
+		return errors.New("fields or patterns must be set")
 	}
 
-	for _, v := range raw {
-		if field, vok := v.(string); vok {
-			p.fields[field] = struct{}{}
+	if hasFields {
+		fields, ok := rawFields.([]interface{})
+		if !ok {
+			return errors.New("fields must be an array")
 		}
+
+		for _, v := range fields {
+			field, vok := v.(string)
+			if !vok || field == "" {
+				continue
+			}
+
+			if strings.Contains(field, ".") {
+				p.paths[field] = struct{}{}
+			} else {
+				p.fields[field] = struct{}{}
+			}
+		}
+	}
+
+	if hasPatterns {
+		patterns, ok := rawPatterns.([]interface{})
+		if !ok {
+			return errors.New("patterns must be an array")
+		}
+
+		for _, v := range patterns {
+			expr, vok := v.(string)
+			if !vok {
+				return errors.New("patterns must be strings")
+			}
+
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", expr, err)
+			}
+
+			p.patterns = append(p.patterns, re)
+		}
+	}
+
+	if mask, ok := cfg["mask"].(string); ok {
+		p.mask = mask
+	}
+
+	counter, err := otel.Meter(meterName).Int64Counter(
+		"kono.masker.redactions",
+		metric.WithDescription("Values redacted from response bodies, by rule."),
+	)
+	if err != nil {
+		return fmt.Errorf("create redactions counter: %w", err)
 	}
 
+	p.redactions = counter
+
 	return nil
 }
 
@@ -55,7 +130,7 @@ func (p *Plugin) Execute(ctx sdk.Context) error {
 		return nil
 	}
 
-	if len(p.fields) == 0 {
+	if len(p.fields) == 0 && len(p.paths) == 0 && len(p.patterns) == 0 {
 		return nil
 	}
 
@@ -75,7 +150,13 @@ func (p *Plugin) Execute(ctx sdk.Context) error {
 		return nil //nolint:nilerr // it is ok for plugins
 	}
 
-	masked := p.maskKeys(raw)
+	counts := make(redactions)
+	masked := p.maskKeys(raw, "", counts)
+
+	if len(counts) == 0 {
+		ctx.Response().Body = io.NopCloser(buf)
+		return nil
+	}
 
 	newBody, err := json.Marshal(masked)
 	if err != nil {
@@ -83,25 +164,50 @@ func (p *Plugin) Execute(ctx sdk.Context) error {
 	}
 
 	ctx.Response().Body = io.NopCloser(bytes.NewReader(newBody))
+	ctx.Response().ContentLength = int64(len(newBody))
+
+	reqCtx := context.Background()
+	if ctx.Request() != nil {
+		reqCtx = ctx.Request().Context()
+	}
+
+	p.record(reqCtx, counts)
 
 	return nil
 }
 
-func (p *Plugin) maskKeys(v interface{}) interface{} {
+// maskKeys redacts v in place. path is the dotted location of v in the document,
+// without array indexes.
+func (p *Plugin) maskKeys(v interface{}, path string, counts redactions) interface{} {
 	switch val := v.(type) {
 	case map[string]interface{}:
 		for k, child := range val {
-			if _, shouldMask := p.fields[k]; shouldMask {
-				val[k] = maskValue
+			childPath := k
+			if path != "" {
+				childPath = path + "." + k
+			}
+
+			if rule, shouldMask := p.fieldRule(k, childPath); shouldMask {
+				val[k] = p.mask
+				counts[rule]++
 			} else {
-				val[k] = p.maskKeys(child)
+				val[k] = p.maskKeys(child, childPath, counts)
 			}
 		}
 
 		return val
 	case []interface{}:
 		for i, item := range val {
-			val[i] = p.maskKeys(item)
+			val[i] = p.maskKeys(item, path, counts)
+		}
+
+		return val
+	case string:
+		for _, re := range p.patterns {
+			if n := len(re.FindAllStringIndex(val, -1)); n > 0 {
+				val = re.ReplaceAllLiteralString(val, p.mask)
+				counts[re.String()] += int64(n)
+			}
 		}
 
 		return val
@@ -109,3 +215,25 @@ func (p *Plugin) maskKeys(v interface{}) interface{} {
 		return val
 	}
 }
+
+func (p *Plugin) fieldRule(key, path string) (string, bool) {
+	if _, ok := p.paths[path]; ok {
+		return path, true
+	}
+
+	if _, ok := p.fields[key]; ok {
+		return key, true
+	}
+
+	return "", false
+}
+
+func (p *Plugin) record(ctx context.Context, counts redactions) {
+	if p.redactions == nil {
+		return
+	}
+
+	for rule, n := range counts {
+		p.redactions.Add(ctx, n, metric.WithAttributes(attribute.String("rule", rule)))
+	}
+}
@@ -6,13 +6,9 @@ import (
 )
 
 func Test_maskKeys(t *testing.T) {
-	p := &Plugin{
-		fields: map[string]struct{}{
-			"password":    {},
-			"card_number": {},
-			"cvv":         {},
-		},
-	}
+	p := newTestPlugin(t, map[string]interface{}{
+		"fields": []interface{}{"password", "card_number", "cvv"},
+	})
 
 	tests := []struct {
 		name     string
@@ -74,10 +70,73 @@ func Test_maskKeys(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := p.maskKeys(tt.input)
+			got := p.maskKeys(tt.input, "", redactions{})
 			if !reflect.DeepEqual(got, tt.expected) {
 				t.Errorf("maskKeys() = %v; want %v", got, tt.expected)
 			}
 		})
 	}
 }
+
+func newTestPlugin(t *testing.T, cfg map[string]interface{}) *Plugin {
+	t.Helper()
+
+	p := &Plugin{}
+	if err := p.Init(cfg); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+
+	return p
+}
+
+func Test_maskKeys_pathsAndPatterns(t *testing.T) {
+	p := newTestPlugin(t, map[string]interface{}{
+		"fields":   []interface{}{"card.number", "ssn"},
+		"patterns": []interface{}{`\b\d{3}-\d{2}-\d{4}\b`},
+		"mask":     "[redacted]",
+	})
+
+	input := map[string]interface{}{
+		"card":   map[string]interface{}{"number": "4111", "brand": "visa"},
+		"number": "42",
+		"orders": []interface{}{
+			map[string]interface{}{"ssn": "123-45-6789"},
+		},
+		"note": "call 123-45-6789 or 987-65-4321",
+	}
+
+	counts := redactions{}
+	got := p.maskKeys(input, "", counts)
+
+	expected := map[string]interface{}{
+		"card":   map[string]interface{}{"number": "[redacted]", "brand": "visa"},
+		"number": "42",
+		"orders": []interface{}{
+			map[string]interface{}{"ssn": "[redacted]"},
+		},
+		"note": "call [redacted] or [redacted]",
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("maskKeys() = %v; want %v", got, expected)
+	}
+
+	wantCounts := redactions{"card.number": 1, "ssn": 1, `\b\d{3}-\d{2}-\d{4}\b`: 2}
+	if !reflect.DeepEqual(counts, wantCounts) {
+		t.Fatalf("counts = %v; want %v", counts, wantCounts)
+	}
+}
+
+func TestInit_invalidConfig(t *testing.T) {
+	cases := map[string]map[string]interface{}{
+		"empty":       {},
+		"bad fields":  {"fields": "password"},
+		"bad pattern": {"patterns": []interface{}{"("}},
+	}
+
+	for name, cfg := range cases {
+		if err := (&Plugin{}).Init(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jmespath/go-jmespath"
+
+	"github.com/starwalkn/kono/sdk"
+)
+
+// Plugin reshapes the aggregated response data with a JMESPath expression, e.g.
+// "{id: user.id, name: user.full_name}" to rename and drop fields or
+// "items[?active].name" to filter and flatten. It runs in the aggregate phase,
+// so the response envelope and content negotiation are unaffected.
+type Plugin struct {
+	expression *jmespath.JMESPath
+}
+
+func NewPlugin() sdk.Plugin {
+	return &Plugin{}
+}
+
+func (p *Plugin) Info() sdk.PluginInfo {
+	return sdk.PluginInfo{
+		Name:        "transform",
+		Description: "Reshapes the aggregated JSON response data with a JMESPath expression.",
+		Version:     "v1",
+		Author:      "starwalkn",
+	}
+}
+
+func (p *Plugin) Type() sdk.PluginType {
+	return sdk.PluginTypeAggregate
+}
+
+func (p *Plugin) Init(cfg map[string]interface{}) error {
+	expr, ok := cfg["expression"].(string)
+	if !ok || expr == "" {
+		return errors.New("expression must be a non-empty string")
+	}
+
+	compiled, err := jmespath.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("invalid expression: %w", err)
+	}
+
+	p.expression = compiled
+
+	return nil
+}
+
+func (p *Plugin) ConfigSchema() map[string]any {
+	return map[string]any{
+		"type":     "object",
+		"required": []string{"expression"},
+		"properties": map[string]any{
+			"expression": map[string]any{"type": "string", "minLength": 1, "description": "JMESPath expression."},
+		},
+	}
+}
+
+func (p *Plugin) Execute(ctx sdk.Context) error {
+	actx, ok := ctx.(sdk.AggregateContext)
+	if !ok {
+		return errors.New("transform: must run as an aggregate plugin")
+	}
+
+	agg := actx.Aggregated()
+	if agg == nil || len(agg.Data) == 0 {
+		return nil
+	}
+
+	var data interface{}
+	if err := json.Unmarshal(agg.Data, &data); err != nil {
+		return fmt.Errorf("transform: data is not JSON: %w", err)
+	}
+
+	result, err := p.expression.Search(data)
+	if err != nil {
+		return fmt.Errorf("transform: apply expression: %w", err)
+	}
+
+	out, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("transform: cannot marshal JSON: %w", err)
+	}
+
+	agg.Data = out
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/starwalkn/kono/sdk"
+)
+
+type aggregateContext struct {
+	sdk.Context
+
+	aggregated *sdk.AggregatedResponse
+}
+
+func (c aggregateContext) Aggregated() *sdk.AggregatedResponse { return c.aggregated }
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		data       string
+		expected   string
+	}{
+		{
+			name:       "renames and drops fields",
+			expression: "{id: user.id, name: user.full_name}",
+			data:       `{"user":{"id":7,"full_name":"Alex","password":"secret"}}`,
+			expected:   `{"id":7,"name":"Alex"}`,
+		},
+		{
+			name:       "filters and flattens arrays",
+			expression: "items[?active].name",
+			data:       `{"items":[{"name":"a","active":true},{"name":"b","active":false}]}`,
+			expected:   `["a"]`,
+		},
+		{
+			name:       "missing fields become null",
+			expression: "missing",
+			data:       `{"id":1}`,
+			expected:   `null`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Plugin{}
+			if err := p.Init(map[string]interface{}{"expression": tt.expression}); err != nil {
+				t.Fatalf("init: %v", err)
+			}
+
+			agg := &sdk.AggregatedResponse{Data: []byte(tt.data), Header: http.Header{}}
+			if err := p.Execute(aggregateContext{aggregated: agg}); err != nil {
+				t.Fatalf("execute: %v", err)
+			}
+
+			if string(agg.Data) != tt.expected {
+				t.Errorf("got %s, want %s", agg.Data, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExecuteKeepsEmptyData(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init(map[string]interface{}{"expression": "id"}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+
+	agg := &sdk.AggregatedResponse{}
+	if err := p.Execute(aggregateContext{aggregated: agg}); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	if agg.Data != nil {
+		t.Errorf("got %s, want nil", agg.Data)
+	}
+}
+
+func TestInit(t *testing.T) {
+	for _, cfg := range []map[string]interface{}{{}, {"expression": ""}, {"expression": "items[?"}} {
+		if err := (&Plugin{}).Init(cfg); err == nil {
+			t.Errorf("Init(%v) succeeded, want error", cfg)
+		}
+	}
+}
@@ -0,0 +1,554 @@
+package kono
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/kono/internal/redis"
+	"github.com/starwalkn/kono/sdk"
+)
+
+const (
+	cacheBackendMemory = "memory"
+	cacheBackendRedis  = "redis"
+)
+
+// responseCache keeps aggregated flow responses for a TTL, keyed by method, path,
+// query and the configured Vary headers, in a memory or shared store.
+type responseCache struct {
+	flow         string   // "METHOD pattern" of the owning flow.
+	tags         []string // Tags given to every entry of the flow.
+	ttl          time.Duration
+	staleWhile   time.Duration // stale-while-revalidate window after ttl.
+	staleIfError time.Duration // stale-if-error window after ttl.
+	maxEntrySize int
+	vary         []string
+	keyParts     []CacheKeyConfig
+	credentials  []string // coalescedHeaders the key does not cover.
+	store        cacheStore
+	log          *zap.Logger
+
+	refreshing sync.Map // Keys being revalidated in the background.
+}
+
+// cacheStore holds cached responses. Stores may lose entries at any time; a
+// failing lookup is treated as a miss.
+type cacheStore interface {
+	get(ctx context.Context, key string) (*cachedResponse, error)
+	set(ctx context.Context, entry *cachedResponse, ttl time.Duration) error
+	// purge removes the entries match selects and returns how many there were.
+	purge(ctx context.Context, match func(*cachedResponse) bool) (int, error)
+}
+
+type cachedResponse struct {
+	Key     string      `json:"key"     msgpack:"key"`
+	Flow    string      `json:"flow"    msgpack:"flow"`
+	Tags    []string    `json:"tags"    msgpack:"tags"`
+	Status  int         `json:"status"  msgpack:"status"`
+	Header  http.Header `json:"header"  msgpack:"header"`
+	Body    []byte      `json:"body"    msgpack:"body"`
+	Expires time.Time   `json:"expires" msgpack:"expires"`
+	// Retain is when the entry stops being usable even as a stale copy.
+	Retain time.Time `json:"retain" msgpack:"retain"`
+}
+
+// response rebuilds the cached response for a new request, which keeps its own
+// request ID and fingerprint.
+func (e *cachedResponse) response(requestID, fingerprint string) *http.Response {
+	header := e.Header.Clone()
+	header.Set("X-Request-ID", requestID)
+	header.Set("X-Request-Fingerprint", fingerprint)
+
+	return &http.Response{
+		StatusCode:    e.Status,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+	}
+}
+
+// cacheTagHeader lists extra tags for a response, separated by commas or spaces.
+const cacheTagHeader = "Cache-Tag"
+
+// newResponseCache returns nil when the flow has no cache configured.
+func newResponseCache(cfg *CacheConfig, flow string, log *zap.Logger) (*responseCache, error) {
+	if cfg == nil {
+		return nil, nil //nolint:nilnil // it is ok for optional modules
+	}
+
+	vary := make([]string, len(cfg.Vary))
+	for i, h := range cfg.Vary {
+		vary[i] = http.CanonicalHeaderKey(h)
+	}
+
+	c := &responseCache{
+		flow:         flow,
+		tags:         cfg.Tags,
+		ttl:          cfg.TTL,
+		staleWhile:   cfg.StaleWhileRevalidate,
+		staleIfError: cfg.StaleIfError,
+		maxEntrySize: cfg.MaxEntrySize,
+		vary:         vary,
+		keyParts:     cfg.Key,
+		log:          log,
+	}
+
+	for _, h := range coalescedHeaders {
+		if !c.keyCovers(h) {
+			c.credentials = append(c.credentials, h)
+		}
+	}
+
+	switch cfg.Backend {
+	case "", cacheBackendMemory:
+		c.store = newMemoryStore(cfg.MaxEntries)
+	case cacheBackendRedis:
+		if cfg.Redis == nil {
+			return nil, fmt.Errorf("%s cache backend requires a redis config", cacheBackendRedis)
+		}
+
+		c.store = newRedisStore(*cfg.Redis, flow)
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", cfg.Backend)
+	}
+
+	return c, nil
+}
+
+// keyCovers reports whether the cache key tells apart requests differing in
+// header h. Claims are read from the Authorization credentials, so keying on one
+// covers it.
+func (c *responseCache) keyCovers(h string) bool {
+	if slices.Contains(c.vary, h) {
+		return true
+	}
+
+	return slices.ContainsFunc(c.keyParts, func(part CacheKeyConfig) bool {
+		return (part.Source == "header" && http.CanonicalHeaderKey(part.Name) == h) ||
+			(part.Source == "claim" && h == "Authorization")
+	})
+}
+
+// cacheable reports whether req may be answered from or stored in the cache.
+// Requests carrying credentials the key does not cover, or lacking a claim it is
+// keyed on, bypass it, so that one caller is never served a response fetched
+// for another.
+func (c *responseCache) cacheable(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	if strings.Contains(req.Header.Get("Cache-Control"), "no-cache") {
+		return false
+	}
+
+	// Without the claim the key would be shared by every caller lacking it.
+	if slices.ContainsFunc(c.keyParts, func(part CacheKeyConfig) bool {
+		return part.Source == "claim" && sdk.ClaimsFromContext(req.Context())[part.Name] == nil
+	}) {
+		return false
+	}
+
+	return !slices.ContainsFunc(c.credentials, func(h string) bool { return req.Header.Get(h) != "" })
+}
+
+// storable reports whether resp may be shared with other callers: responses
+// setting cookies or marked no-store or private are not.
+func storable(resp *http.Response) bool {
+	if len(resp.Header.Values("Set-Cookie")) > 0 {
+		return false
+	}
+
+	for _, v := range resp.Header.Values("Cache-Control") {
+		for directive := range strings.SplitSeq(v, ",") {
+			name, _, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if strings.EqualFold(name, "no-store") || strings.EqualFold(name, "private") {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (c *responseCache) key(req *http.Request) string {
+	var sb strings.Builder
+
+	sb.WriteString(req.Method)
+	sb.WriteByte(' ')
+	sb.WriteString(req.URL.Path)
+
+	if len(c.keyParts) > 0 {
+		c.writeKeyParts(&sb, req)
+	} else {
+		writeSortedQuery(&sb, req.URL.Query())
+	}
+
+	for _, h := range c.vary {
+		sb.WriteString("\n" + h + ":" + escapeKeyValues(req.Header.Values(h)))
+	}
+
+	return sb.String()
+}
+
+// writeKeyParts writes the configured parts of the key after the path, which
+// every key starts with. Values are escaped, so no value can pass for the
+// separators and forge the key of another request.
+func (c *responseCache) writeKeyParts(sb *strings.Builder, req *http.Request) {
+	for _, part := range c.keyParts {
+		if part.Source == "path" {
+			continue
+		}
+
+		sb.WriteString("\n" + part.Source + ":" + url.QueryEscape(part.Name) + "=")
+
+		switch part.Source {
+		case "query":
+			sb.WriteString(escapeKeyValues(req.URL.Query()[part.Name]))
+		case "header":
+			sb.WriteString(escapeKeyValues(req.Header.Values(part.Name)))
+		case "claim":
+			if claim, ok := sdk.ClaimsFromContext(req.Context())[part.Name]; ok && claim != nil {
+				sb.WriteString(url.QueryEscape(fmt.Sprint(claim)))
+			}
+		}
+	}
+}
+
+func writeSortedQuery(sb *strings.Builder, query url.Values) {
+	keys := make([]string, 0, len(query))
+
+	for k := range query {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		for _, v := range query[k] {
+			sb.WriteString("&" + url.QueryEscape(k) + "=" + url.QueryEscape(v))
+		}
+	}
+}
+
+func escapeKeyValues(values []string) string {
+	escaped := make([]string, len(values))
+	for i, v := range values {
+		escaped[i] = url.QueryEscape(v)
+	}
+
+	return strings.Join(escaped, ",")
+}
+
+// get returns the entry for key while it is fresh.
+func (c *responseCache) get(ctx context.Context, key string, now time.Time) (*cachedResponse, bool) {
+	entry, fresh := c.lookup(ctx, key, now)
+	if !fresh {
+		return nil, false
+	}
+
+	return entry, true
+}
+
+// lookup returns the entry for key, fresh or not, as long as it is retained.
+func (c *responseCache) lookup(ctx context.Context, key string, now time.Time) (entry *cachedResponse, fresh bool) {
+	entry, err := c.store.get(ctx, key)
+	if err != nil {
+		c.log.Warn("cache lookup failed", zap.Error(err))
+		return nil, false
+	}
+
+	if entry == nil || entry.Key != key || entry.Flow != c.flow || now.After(entry.Retain) {
+		return nil, false
+	}
+
+	return entry, !now.After(entry.Expires)
+}
+
+// revalidatable reports whether an expired entry may still be served while a
+// fresh copy is fetched.
+func (c *responseCache) revalidatable(entry *cachedResponse, now time.Time) bool {
+	return c.staleWhile > 0 && !now.After(entry.Expires.Add(c.staleWhile))
+}
+
+// usableOnError reports whether an expired entry may replace a failed response.
+func (c *responseCache) usableOnError(entry *cachedResponse, now time.Time) bool {
+	return c.staleIfError > 0 && !now.After(entry.Expires.Add(c.staleIfError))
+}
+
+// startRefresh claims the background revalidation of key; it returns false when
+// one is already running. The caller calls finishRefresh when done.
+func (c *responseCache) startRefresh(key string) bool {
+	_, running := c.refreshing.LoadOrStore(key, struct{}{})
+	return !running
+}
+
+func (c *responseCache) finishRefresh(key string) {
+	c.refreshing.Delete(key)
+}
+
+// put stores a successful response; anything but 200, responses that are not
+// storable, and bodies beyond the max entry size, are left uncached.
+func (c *responseCache) put(ctx context.Context, key string, resp *http.Response, body []byte, now time.Time) {
+	if resp.StatusCode != http.StatusOK || !storable(resp) || (c.maxEntrySize > 0 && len(body) > c.maxEntrySize) {
+		return
+	}
+
+	retain := c.ttl + max(c.staleWhile, c.staleIfError)
+
+	tags := slices.Clone(c.tags)
+	for _, v := range resp.Header.Values(cacheTagHeader) {
+		tags = append(tags, strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' })...)
+	}
+
+	entry := &cachedResponse{
+		Key:     key,
+		Flow:    c.flow,
+		Tags:    tags,
+		Status:  resp.StatusCode,
+		Header:  resp.Header.Clone(),
+		Body:    body,
+		Expires: now.Add(c.ttl),
+		Retain:  now.Add(retain),
+	}
+
+	if err := c.store.set(ctx, entry, retain); err != nil {
+		c.log.Warn("cache store failed", zap.Error(err))
+	}
+}
+
+// cachePurge selects entries to drop; empty fields match everything. Pattern is
+// matched against the whole cache key, with "*" standing for any run of characters.
+type cachePurge struct {
+	Flow    string `json:"flow"`
+	Pattern string `json:"pattern"`
+	Tag     string `json:"tag"`
+}
+
+// purge removes the entries of this flow that p selects.
+func (c *responseCache) purge(ctx context.Context, p cachePurge) (int, error) {
+	if p.Flow != "" && p.Flow != c.flow {
+		return 0, nil
+	}
+
+	var pattern *regexp.Regexp
+	if p.Pattern != "" {
+		pattern = regexp.MustCompile("^" + strings.ReplaceAll(regexp.QuoteMeta(p.Pattern), `\*`, ".*") + "$")
+	}
+
+	return c.store.purge(ctx, func(e *cachedResponse) bool {
+		return e.Flow == c.flow &&
+			(pattern == nil || pattern.MatchString(e.Key)) &&
+			(p.Tag == "" || slices.Contains(e.Tags, p.Tag))
+	})
+}
+
+// Close releases the connections of a shared store.
+func (c *responseCache) Close() error {
+	if closer, ok := c.store.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// memoryStore keeps entries in process, evicting the least recently used one
+// once maxEntries is reached.
+type memoryStore struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	recent  *list.List // Of *cachedResponse, most recently used first.
+}
+
+func newMemoryStore(maxEntries int) *memoryStore {
+	return &memoryStore{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		recent:     list.New(),
+	}
+}
+
+func (s *memoryStore) get(_ context.Context, key string) (*cachedResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.entries[key]
+	if !ok {
+		return nil, nil //nolint:nilnil // a miss is not an error
+	}
+
+	entry := el.Value.(*cachedResponse)
+	if time.Now().After(entry.Retain) {
+		s.recent.Remove(el)
+		delete(s.entries, key)
+
+		return nil, nil //nolint:nilnil // a miss is not an error
+	}
+
+	s.recent.MoveToFront(el)
+
+	return entry, nil
+}
+
+func (s *memoryStore) purge(_ context.Context, match func(*cachedResponse) bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := 0
+
+	for key, el := range s.entries {
+		if match(el.Value.(*cachedResponse)) {
+			s.recent.Remove(el)
+			delete(s.entries, key)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+func (s *memoryStore) set(_ context.Context, entry *cachedResponse, _ time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[entry.Key]; ok {
+		el.Value = entry
+		s.recent.MoveToFront(el)
+
+		return nil
+	}
+
+	if s.recent.Len() >= s.maxEntries {
+		oldest := s.recent.Back()
+		s.recent.Remove(oldest)
+		delete(s.entries, oldest.Value.(*cachedResponse).Key)
+	}
+
+	s.entries[entry.Key] = s.recent.PushFront(entry)
+
+	return nil
+}
+
+// redisStore shares entries between gateway instances through Redis, which
+// expires them on its own. Each flow keeps its entries under its own prefix,
+// since flows may share a method and path.
+type redisStore struct {
+	client    *redis.Client
+	keyPrefix string
+	marshal   func(any) ([]byte, error)
+	unmarshal func([]byte, any) error
+}
+
+func newRedisStore(cfg RedisCacheConfig, flow string) *redisStore {
+	s := &redisStore{
+		client: redis.New(redis.Options{
+			Address:  cfg.Address,
+			Password: cfg.Password,
+			DB:       cfg.DB,
+			Timeout:  cfg.Timeout,
+		}),
+		keyPrefix: cfg.KeyPrefix + flow + ":",
+		marshal:   json.Marshal,
+		unmarshal: json.Unmarshal,
+	}
+
+	if cfg.Serialization == formatMsgpack.String() {
+		s.marshal, s.unmarshal = msgpack.Marshal, msgpack.Unmarshal
+	}
+
+	return s
+}
+
+func (s *redisStore) get(ctx context.Context, key string) (*cachedResponse, error) {
+	raw, found, err := s.client.Get(ctx, s.keyPrefix+key)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	var entry cachedResponse
+	if err = s.unmarshal(raw, &entry); err != nil {
+		return nil, fmt.Errorf("decode cached response: %w", err)
+	}
+
+	return &entry, nil
+}
+
+func (s *redisStore) set(ctx context.Context, entry *cachedResponse, ttl time.Duration) error {
+	raw, err := s.marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encode cached response: %w", err)
+	}
+
+	return s.client.Set(ctx, s.keyPrefix+entry.Key, raw, ttl)
+}
+
+// purge walks the keys under the prefix with SCAN, so it does not block the
+// server, and deletes the matching entries.
+func (s *redisStore) purge(ctx context.Context, match func(*cachedResponse) bool) (int, error) {
+	purged := 0
+	cursor := "0"
+
+	for {
+		next, keys, err := s.client.Scan(ctx, cursor, redisGlobEscaper.Replace(s.keyPrefix)+"*")
+		if err != nil {
+			return purged, err
+		}
+
+		var doomed []string
+
+		for _, key := range keys {
+			entry, getErr := s.get(ctx, strings.TrimPrefix(key, s.keyPrefix))
+			if getErr == nil && entry != nil && match(entry) {
+				doomed = append(doomed, key)
+			}
+		}
+
+		if len(doomed) > 0 {
+			n, delErr := s.client.Del(ctx, doomed...)
+			if delErr != nil {
+				return purged, delErr
+			}
+
+			purged += int(n)
+		}
+
+		if next == "0" {
+			return purged, nil
+		}
+
+		cursor = next
+	}
+}
+
+// redisGlobEscaper quotes the characters SCAN MATCH patterns treat specially,
+// which route patterns may contain.
+var redisGlobEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+func (s *redisStore) Close() error {
+	return s.client.Close()
+}
+
+// discardResponseWriter swallows what the pipeline writes during background
+// revalidation, when no client is waiting.
+type discardResponseWriter struct{}
+
+func (discardResponseWriter) Header() http.Header         { return http.Header{} }
+func (discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (discardResponseWriter) WriteHeader(int)             {}
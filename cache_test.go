@@ -0,0 +1,489 @@
+package kono
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/kono/sdk"
+)
+
+func newTestCache(cfg *CacheConfig) *responseCache {
+	cache, err := newResponseCache(cfg, "GET /test", zap.NewNop())
+	Expect(err).NotTo(HaveOccurred())
+
+	return cache
+}
+
+var _ = Describe("responseCache", func() {
+	ctx := context.Background()
+
+	var (
+		cache *responseCache
+		now   time.Time
+	)
+
+	ok := func() *http.Response {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"application/json"}}}
+	}
+
+	BeforeEach(func() {
+		cache = newTestCache(&CacheConfig{TTL: time.Minute, MaxEntries: 2, Vary: []string{"x-tenant"}})
+		now = time.Now()
+	})
+
+	It("keys requests independently of query parameter order", func() {
+		a := httptest.NewRequest(http.MethodGet, "/items?b=2&a=1", nil)
+		b := httptest.NewRequest(http.MethodGet, "/items?a=1&b=2", nil)
+
+		Expect(cache.key(a)).To(Equal(cache.key(b)))
+	})
+
+	It("keys requests on the vary headers", func() {
+		a := httptest.NewRequest(http.MethodGet, "/items", nil)
+		a.Header.Set("X-Tenant", "acme")
+		b := httptest.NewRequest(http.MethodGet, "/items", nil)
+		b.Header.Set("X-Tenant", "globex")
+
+		Expect(cache.key(a)).NotTo(Equal(cache.key(b)))
+	})
+
+	It("keys requests on the configured parts only", func() {
+		cache = newTestCache(&CacheConfig{TTL: time.Minute, MaxEntries: 2, Key: []CacheKeyConfig{
+			{Source: "path"},
+			{Source: "query", Name: "lang"},
+			{Source: "claim", Name: "sub"},
+		}})
+
+		req := func(target, sub string) *http.Request {
+			r := httptest.NewRequest(http.MethodGet, target, nil)
+			return r.WithContext(sdk.WithClaims(r.Context(), map[string]any{"sub": sub}))
+		}
+
+		Expect(cache.key(req("/items?lang=en&utm=a", "alice"))).To(Equal(cache.key(req("/items?utm=b&lang=en", "alice"))))
+		Expect(cache.key(req("/items?lang=en", "alice"))).NotTo(Equal(cache.key(req("/items?lang=en", "bob"))))
+		Expect(cache.key(req("/items?lang=en", "alice"))).NotTo(Equal(cache.key(req("/items?lang=de", "alice"))))
+		Expect(cache.key(req("/items", "alice"))).NotTo(Equal(cache.key(req("/other", "alice"))))
+	})
+
+	It("keys requests on the path even when the parts leave it out", func() {
+		cache = newTestCache(&CacheConfig{TTL: time.Minute, MaxEntries: 2, Key: []CacheKeyConfig{
+			{Source: "query", Name: "lang"},
+		}})
+
+		Expect(cache.key(httptest.NewRequest(http.MethodGet, "/users/1?lang=en", nil))).
+			NotTo(Equal(cache.key(httptest.NewRequest(http.MethodGet, "/users/2?lang=en", nil))))
+	})
+
+	It("expires entries after the TTL", func() {
+		cache.put(ctx, "k", ok(), []byte(`{}`), now)
+
+		_, found := cache.get(ctx, "k", now.Add(30*time.Second))
+		Expect(found).To(BeTrue())
+
+		_, found = cache.get(ctx, "k", now.Add(2*time.Minute))
+		Expect(found).To(BeFalse())
+	})
+
+	It("evicts the least recently used entry", func() {
+		cache.put(ctx, "a", ok(), []byte(`1`), now)
+		cache.put(ctx, "b", ok(), []byte(`2`), now)
+		_, _ = cache.get(ctx, "a", now)
+		cache.put(ctx, "c", ok(), []byte(`3`), now)
+
+		_, found := cache.get(ctx, "b", now)
+		Expect(found).To(BeFalse())
+		_, found = cache.get(ctx, "a", now)
+		Expect(found).To(BeTrue())
+	})
+
+	It("ignores entries stored for another flow", func() {
+		Expect(cache.store.set(ctx, &cachedResponse{
+			Key:     "k",
+			Flow:    "GET /other",
+			Status:  http.StatusOK,
+			Expires: now.Add(time.Minute),
+			Retain:  now.Add(time.Minute),
+		}, time.Minute)).To(Succeed())
+
+		_, found := cache.get(ctx, "k", now)
+		Expect(found).To(BeFalse())
+	})
+
+	It("does not store unsuccessful responses", func() {
+		cache.put(ctx, "k", &http.Response{StatusCode: http.StatusPartialContent, Header: http.Header{}}, nil, now)
+
+		_, found := cache.get(ctx, "k", now)
+		Expect(found).To(BeFalse())
+	})
+
+	It("only serves GET and HEAD requests that allow caching", func() {
+		get := httptest.NewRequest(http.MethodGet, "/items", nil)
+		Expect(cache.cacheable(get)).To(BeTrue())
+
+		get.Header.Set("Cache-Control", "no-cache")
+		Expect(cache.cacheable(get)).To(BeFalse())
+
+		Expect(cache.cacheable(httptest.NewRequest(http.MethodPost, "/items", nil))).To(BeFalse())
+	})
+
+	It("bypasses requests with credentials the key does not cover", func() {
+		withCredentials := func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/items", nil)
+			r.Header.Set("Authorization", "Bearer alice")
+			r.Header.Set("Cookie", "session=alice")
+
+			return r
+		}
+
+		Expect(cache.cacheable(withCredentials())).To(BeFalse())
+
+		cache = newTestCache(&CacheConfig{TTL: time.Minute, MaxEntries: 2, Vary: []string{"cookie"}, Key: []CacheKeyConfig{
+			{Source: "path"},
+			{Source: "claim", Name: "sub"},
+		}})
+		Expect(cache.cacheable(withCredentials())).To(BeFalse())
+
+		authenticated := withCredentials()
+		authenticated = authenticated.WithContext(sdk.WithClaims(authenticated.Context(), map[string]any{"sub": "alice"}))
+		Expect(cache.cacheable(authenticated)).To(BeTrue())
+	})
+
+	It("escapes key values so they cannot forge another key", func() {
+		forged := httptest.NewRequest(http.MethodGet, "/items?a=1%26b%3D2", nil)
+		Expect(cache.key(forged)).NotTo(Equal(cache.key(httptest.NewRequest(http.MethodGet, "/items?a=1&b=2", nil))))
+
+		cache = newTestCache(&CacheConfig{TTL: time.Minute, MaxEntries: 2, Key: []CacheKeyConfig{
+			{Source: "path"},
+			{Source: "header", Name: "X-Tenant"},
+		}})
+
+		forged = httptest.NewRequest(http.MethodGet, "/items", nil)
+		forged.Header.Add("X-Tenant", "acme,beta")
+
+		split := httptest.NewRequest(http.MethodGet, "/items", nil)
+		split.Header.Add("X-Tenant", "acme")
+		split.Header.Add("X-Tenant", "beta")
+		Expect(cache.key(forged)).NotTo(Equal(cache.key(split)))
+	})
+
+	It("does not store responses meant for a single caller", func() {
+		for _, header := range []http.Header{
+			{"Set-Cookie": {"session=alice"}},
+			{"Cache-Control": {"no-store"}},
+			{"Cache-Control": {"max-age=60, private"}},
+		} {
+			cache.put(ctx, "k", &http.Response{StatusCode: http.StatusOK, Header: header}, []byte(`{}`), now)
+
+			_, found := cache.get(ctx, "k", now)
+			Expect(found).To(BeFalse(), "%v", header)
+		}
+
+		cache.put(ctx, "k", &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Cache-Control": {"public"}}}, []byte(`{}`), now)
+
+		_, found := cache.get(ctx, "k", now)
+		Expect(found).To(BeTrue())
+	})
+})
+
+// fakeRedis serves GET and SET from a map over RESP, enough for the cache backend.
+func fakeRedis() (addr string, stop func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+
+	var (
+		mu   sync.Mutex
+		data = make(map[string]string)
+	)
+
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+
+		for {
+			var n int
+			if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+				return
+			}
+
+			args := make([]string, n)
+			for i := range args {
+				var size int
+				if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+					return
+				}
+
+				buf := make([]byte, size+2)
+				if _, err := io.ReadFull(r, buf); err != nil {
+					return
+				}
+
+				args[i] = string(buf[:size])
+			}
+
+			mu.Lock()
+			switch strings.ToUpper(args[0]) {
+			case "SET":
+				data[args[1]] = args[2]
+				_, _ = conn.Write([]byte("+OK\r\n"))
+			case "GET":
+				if v, ok := data[args[1]]; ok {
+					_, _ = fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+				} else {
+					_, _ = conn.Write([]byte("$-1\r\n"))
+				}
+			case "DEL":
+				deleted := 0
+				for _, k := range args[1:] {
+					if _, ok := data[k]; ok {
+						delete(data, k)
+						deleted++
+					}
+				}
+				_, _ = fmt.Fprintf(conn, ":%d\r\n", deleted)
+			case "SCAN": // Everything in one page: SCAN 0 MATCH prefix* ...
+				var keys []string
+				for k := range data {
+					if strings.HasPrefix(k, strings.TrimSuffix(args[3], "*")) {
+						keys = append(keys, k)
+					}
+				}
+				_, _ = fmt.Fprintf(conn, "*2\r\n$1\r\n0\r\n*%d\r\n", len(keys))
+				for _, k := range keys {
+					_, _ = fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(k), k)
+				}
+			default:
+				_, _ = conn.Write([]byte("-ERR unknown command\r\n"))
+			}
+			mu.Unlock()
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go serve(conn)
+		}
+	}()
+
+	return ln.Addr().String(), func() { _ = ln.Close() }
+}
+
+var _ = Describe("redis cache backend", func() {
+	DescribeTable("shares entries between caches",
+		func(serialization string) {
+			addr, stop := fakeRedis()
+			defer stop()
+
+			cfg := &CacheConfig{
+				TTL:     time.Minute,
+				Backend: cacheBackendRedis,
+				Redis: &RedisCacheConfig{
+					Address:       addr,
+					KeyPrefix:     "kono:cache:",
+					Serialization: serialization,
+					Timeout:       time.Second,
+				},
+			}
+
+			writer, reader := newTestCache(cfg), newTestCache(cfg)
+			defer writer.Close()
+			defer reader.Close()
+
+			ctx := context.Background()
+			resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"application/json"}}}
+			writer.put(ctx, "GET /items", resp, []byte(`{"a":1}`), time.Now())
+
+			entry, found := reader.get(ctx, "GET /items", time.Now())
+			Expect(found).To(BeTrue())
+			Expect(entry.Body).To(MatchJSON(`{"a":1}`))
+			Expect(entry.Header.Get("Content-Type")).To(Equal("application/json"))
+
+			_, found = reader.get(ctx, "GET /other", time.Now())
+			Expect(found).To(BeFalse())
+		},
+		Entry("json", "json"),
+		Entry("msgpack", "msgpack"),
+	)
+
+	It("keeps the entries of each flow apart", func() {
+		addr, stop := fakeRedis()
+		defer stop()
+
+		cfg := &CacheConfig{
+			TTL:     time.Minute,
+			Backend: cacheBackendRedis,
+			Redis:   &RedisCacheConfig{Address: addr, KeyPrefix: "kono:cache:", Serialization: "json", Timeout: time.Second},
+		}
+
+		writer := newTestCache(cfg)
+		defer writer.Close()
+
+		other, err := newResponseCache(cfg, "GET /{name}", zap.NewNop())
+		Expect(err).NotTo(HaveOccurred())
+		defer other.Close()
+
+		ctx := context.Background()
+		writer.put(ctx, "GET /test", &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}, []byte(`1`), time.Now())
+
+		_, found := other.get(ctx, "GET /test", time.Now())
+		Expect(found).To(BeFalse())
+
+		_, found = writer.get(ctx, "GET /test", time.Now())
+		Expect(found).To(BeTrue())
+	})
+
+	It("purges matching entries from the server", func() {
+		addr, stop := fakeRedis()
+		defer stop()
+
+		cache := newTestCache(&CacheConfig{
+			TTL:     time.Minute,
+			Backend: cacheBackendRedis,
+			Tags:    []string{"items"},
+			Redis:   &RedisCacheConfig{Address: addr, KeyPrefix: "kono:cache:", Serialization: "json", Timeout: time.Second},
+		})
+		defer cache.Close()
+
+		ctx := context.Background()
+		resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+		cache.put(ctx, "GET /items/1", resp, []byte(`1`), time.Now())
+		cache.put(ctx, "GET /items/2", resp, []byte(`2`), time.Now())
+
+		n, err := cache.purge(ctx, cachePurge{Pattern: "GET /items/1"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(1))
+
+		_, found := cache.get(ctx, "GET /items/1", time.Now())
+		Expect(found).To(BeFalse())
+		_, found = cache.get(ctx, "GET /items/2", time.Now())
+		Expect(found).To(BeTrue())
+	})
+
+	It("treats an unreachable server as a miss", func() {
+		addr, stop := fakeRedis()
+		stop()
+
+		cache := newTestCache(&CacheConfig{
+			TTL:     time.Minute,
+			Backend: cacheBackendRedis,
+			Redis:   &RedisCacheConfig{Address: addr, Timeout: 50 * time.Millisecond},
+		})
+
+		_, found := cache.get(context.Background(), "GET /items", time.Now())
+		Expect(found).To(BeFalse())
+	})
+
+	It("skips bodies over the max entry size", func() {
+		cache := newTestCache(&CacheConfig{TTL: time.Minute, MaxEntries: 10, MaxEntrySize: 4})
+		ctx := context.Background()
+
+		cache.put(ctx, "k", &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}, []byte(`"long"`), time.Now())
+
+		_, found := cache.get(ctx, "k", time.Now())
+		Expect(found).To(BeFalse())
+	})
+})
+
+var _ = Describe("Router response caching", func() {
+	It("serves a stale entry while revalidating it in the background", func() {
+		scatter := &mockScatter{results: []upstreamResponse{{status: http.StatusOK, body: []byte(`"v1"`)}}}
+		cache := newTestCache(&CacheConfig{TTL: time.Minute, MaxEntries: 10, StaleWhileRevalidate: time.Minute})
+
+		r := newTestRouter([]flow{{
+			path:        "/test/swr",
+			method:      http.MethodGet,
+			aggregation: aggregation{strategy: strategyArray},
+			cache:       cache,
+		}}, scatter, &defaultAggregator{})
+
+		req := httptest.NewRequest(http.MethodGet, "/test/swr", nil)
+		key := cache.key(req)
+		cache.put(context.Background(), key, &http.Response{StatusCode: http.StatusOK, Header: http.Header{}},
+			[]byte(`{"data":"v0"}`), time.Now().Add(-90*time.Second))
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		Expect(rec.Header().Get("X-Cache")).To(Equal("STALE"))
+		Expect(rec.Body.String()).To(ContainSubstring("v0"))
+
+		Eventually(func() bool {
+			_, fresh := cache.get(context.Background(), key, time.Now())
+			return fresh
+		}).Should(BeTrue())
+
+		entry, _ := cache.get(context.Background(), key, time.Now())
+		Expect(string(entry.Body)).To(ContainSubstring("v1"))
+	})
+
+	It("serves a stale entry when the upstreams fail", func() {
+		scatter := &mockScatter{results: []upstreamResponse{errResponse(upstreamConnection)}}
+		cache := newTestCache(&CacheConfig{TTL: time.Minute, MaxEntries: 10, StaleIfError: time.Hour})
+
+		r := newTestRouter([]flow{{
+			path:        "/test/sie",
+			method:      http.MethodGet,
+			upstreams:   mockUpstreams("a"),
+			aggregation: aggregation{strategy: strategyArray},
+			cache:       cache,
+		}}, scatter, &defaultAggregator{})
+
+		req := httptest.NewRequest(http.MethodGet, "/test/sie", nil)
+		cache.put(context.Background(), cache.key(req), &http.Response{StatusCode: http.StatusOK, Header: http.Header{}},
+			[]byte(`{"data":"last-good"}`), time.Now().Add(-10*time.Minute))
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("X-Cache")).To(Equal("STALE"))
+		Expect(rec.Body.String()).To(ContainSubstring("last-good"))
+	})
+
+	It("answers repeated requests without dispatching to upstreams", func() {
+		scatter := &mockScatter{results: []upstreamResponse{{status: http.StatusOK, body: []byte(`"OK"`)}}}
+
+		r := newTestRouter([]flow{{
+			path:        "/test/cached",
+			method:      http.MethodGet,
+			aggregation: aggregation{strategy: strategyArray},
+			cache:       newTestCache(&CacheConfig{TTL: time.Minute, MaxEntries: 10}),
+		}}, scatter, &defaultAggregator{})
+
+		serve := func() *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test/cached", nil))
+
+			return rec
+		}
+
+		first := serve()
+		Expect(first.Code).To(Equal(http.StatusOK))
+		Expect(first.Header().Get("X-Cache")).To(Equal("MISS"))
+
+		second := serve()
+		Expect(second.Code).To(Equal(http.StatusOK))
+		Expect(second.Header().Get("X-Cache")).To(Equal("HIT"))
+		Expect(second.Body.String()).To(Equal(first.Body.String()))
+		Expect(second.Header().Get("X-Request-ID")).NotTo(Equal(first.Header().Get("X-Request-ID")))
+
+		Expect(scatter.calls).To(Equal(1))
+	})
+})
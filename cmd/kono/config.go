@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+
+	"github.com/spf13/cobra"
+
+	"github.com/starwalkn/kono"
+	"github.com/starwalkn/kono/internal/schema"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the configuration format",
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+
+	var (
+		output string
+		pkg    string
+	)
+
+	cueCmd := &cobra.Command{
+		Use:   "cue",
+		Short: "Export the configuration schema as CUE definitions",
+		Long: "Export the configuration schema as CUE definitions, so configs can be " +
+			"validated and templated with CUE before kono loads them:\n\n" +
+			"  kono config cue -o kono.cue\n" +
+			"  cue vet kono.yaml kono.cue -d '#Config'",
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return writeOutput(output, schema.CUE(configSchema(), pkg))
+		},
+	}
+
+	cueCmd.Flags().StringVarP(&output, "output", "o", "", "Output file path (default: stdout)")
+	cueCmd.Flags().StringVar(&pkg, "package", "kono", "CUE package name")
+
+	configCmd.AddCommand(cueCmd)
+
+	var (
+		schemaOutput string
+		schemaID     string
+	)
+
+	schemaCmd := &cobra.Command{
+		Use:   "schema",
+		Short: "Emit a JSON Schema for the configuration",
+		Long: "Emit a JSON Schema (draft 2020-12) for the configuration, including the " +
+			"config of built-in middlewares and registered plugins and aggregators that describe " +
+			"it, for editors and CI:\n\n" +
+			"  kono config schema -o kono.schema.json",
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			components := kono.ConfigSchemas()
+
+			data, err := schema.JSONSchema(configSchema(), schemaID, schema.Components{
+				"MiddlewareConfig": {Source: "builtin", Schemas: components["middleware"]},
+				"PluginConfig":     {Source: "registry", Schemas: components["plugin"]},
+				"AggregatorConfig": {Source: "registry", Schemas: components["aggregator"]},
+			})
+			if err != nil {
+				return fmt.Errorf("render schema: %w", err)
+			}
+
+			return writeOutput(schemaOutput, append(data, '\n'))
+		},
+	}
+
+	schemaCmd.Flags().StringVarP(&schemaOutput, "output", "o", "", "Output file path (default: stdout)")
+	schemaCmd.Flags().StringVar(&schemaID, "id", "", "Value of the schema's $id")
+
+	configCmd.AddCommand(schemaCmd)
+
+	var write bool
+
+	migrateCmd := &cobra.Command{
+		Use:   "migrate [file...]",
+		Short: "Rewrite v1 configuration files to the current schema",
+		Long: "Rewrite v1 configuration files to the current schema, keeping comments and " +
+			"${...} references. Without arguments the file from --config or KONO_CONFIG " +
+			"is migrated. Included files without a schema key are migrated as fragments; " +
+			"pass them explicitly. The result is printed unless --write is set.",
+		RunE: func(_ *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				args = []string{configPath()}
+			}
+
+			for _, path := range args {
+				if err := migrateFile(path, write); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	}
+
+	migrateCmd.Flags().BoolVarP(&write, "write", "w", false, "Rewrite the files in place")
+
+	configCmd.AddCommand(migrateCmd)
+}
+
+func migrateFile(path string, write bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	out, migrated, err := kono.MigrateConfig(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	if !write {
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+
+	if !migrated {
+		fmt.Fprintf(os.Stderr, "%s: already current\n", path)
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if err = os.WriteFile(path, out, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: migrated\n", path)
+
+	return nil
+}
+
+// configSchema describes kono.Config. AddrList decodes from a string or a list,
+// and the top-level include entry is consumed before decoding. v1 documents are
+// migrated on load, so schema: v1 and the keys it renamed are accepted too.
+func configSchema() *schema.Schema {
+	s := schema.Reflect(reflect.TypeFor[kono.Config](), schema.Overrides{
+		reflect.TypeFor[kono.AddrList](): {Kind: schema.KindStringOrList},
+	})
+
+	root := s.Definitions[s.Root.Name]
+	root.Fields = append(root.Fields, schema.Field{Name: "include", Type: &schema.Type{Kind: schema.KindStringOrList}})
+
+	for i, f := range root.Fields {
+		if f.Name == "schema" {
+			version := *f.Type
+			version.Enum = append(slices.Clone(version.Enum), "v1")
+			root.Fields[i].Type = &version
+		}
+	}
+
+	for def, renames := range kono.LegacyConfigKeys() {
+		for from, to := range renames {
+			s.Alias(def, from, to)
+		}
+	}
+
+	return s
+}
+
+func writeOutput(path string, data []byte) error {
+	if path == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+
+	if err := os.WriteFile(path, data, 0o644); err != nil { //nolint:gosec // Generated schema, not a secret.
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/starwalkn/kono"
+)
+
+const adminFetchTimeout = 10 * time.Second
+
+type diffFlags struct {
+	admin string
+	token string
+}
+
+func init() {
+	flags := &diffFlags{}
+
+	diffCmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Show what applying the local config would change on a running gateway",
+		Long: "Fetch the effective routing configuration from a running gateway's admin " +
+			"API and compare it with the local configuration, listing the flows and " +
+			"upstreams that would be added, removed or changed. Exits with status 1 " +
+			"when there are changes. Redacted values are digested with a key of the " +
+			"running process, so changes to secrets are not listed.\n\n" +
+			"The admin address and token default to server.admin of the local config.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			changes, err := runDiff(cmd.Context(), *flags)
+			if err != nil {
+				return err
+			}
+
+			if len(changes) == 0 {
+				fmt.Println("no changes")
+				return nil
+			}
+
+			for _, line := range changes {
+				fmt.Println(line)
+			}
+
+			os.Exit(1)
+
+			return nil
+		},
+	}
+
+	diffCmd.Flags().StringVar(&flags.admin, "admin", "", "Admin API base URL (default: http://<server.admin.address>)")
+	diffCmd.Flags().StringVar(&flags.token, "admin-token", "", "Admin API token (default: server.admin.token)")
+
+	configCmd.AddCommand(diffCmd)
+}
+
+func runDiff(ctx context.Context, f diffFlags) ([]string, error) {
+	cfg, _, _, err := loadConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	local, err := kono.RoutingSnapshot(cfg.Gateway.Routing)
+	if err != nil {
+		return nil, err
+	}
+
+	admin := f.admin
+	if admin == "" {
+		admin = "http://" + cfg.Gateway.Server.Admin.Address
+	}
+
+	token := f.token
+	if token == "" {
+		token = cfg.Gateway.Server.Admin.Token
+	}
+
+	remote, err := fetchRemoteRouting(ctx, admin, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffRouting(remote, local), nil
+}
+
+func fetchRemoteRouting(ctx context.Context, admin, token string) (map[string]any, error) {
+	ctx, cancel := context.WithTimeout(ctx, adminFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(admin, "/")+"/config", nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch running config: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("fetch running config: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch running config: admin API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var remote map[string]any
+	if err = yaml.Unmarshal(body, &remote); err != nil {
+		return nil, fmt.Errorf("decode running config: %w", err)
+	}
+
+	return remote, nil
+}
+
+// diffRouting lists the changes from the running routing config to the local
+// one. Flows are matched by method and path, their upstreams by name.
+func diffRouting(running, local map[string]any) []string {
+	var out []string
+
+	for _, key := range sortedKeys(running, local) {
+		if key == "flows" || key == "groups" {
+			continue
+		}
+
+		for _, c := range diffValues(key, running[key], local[key]) {
+			out = append(out, "~ routing "+c)
+		}
+	}
+
+	before := indexBy(running["flows"], flowKey)
+	after := indexBy(local["flows"], flowKey)
+
+	for _, key := range sortedKeys(before, after) {
+		old, hadOld := before[key].(map[string]any)
+		cur, hasCur := after[key].(map[string]any)
+
+		switch {
+		case !hadOld:
+			out = append(out, "+ flow "+key)
+		case !hasCur:
+			out = append(out, "- flow "+key)
+		default:
+			if changes := diffFlow(old, cur); len(changes) > 0 {
+				out = append(out, "~ flow "+key)
+				out = append(out, changes...)
+			}
+		}
+	}
+
+	return out
+}
+
+func diffFlow(old, cur map[string]any) []string {
+	var out []string
+
+	for _, key := range sortedKeys(old, cur) {
+		if key == "upstreams" {
+			continue
+		}
+
+		for _, c := range diffValues(key, old[key], cur[key]) {
+			out = append(out, "    ~ "+c)
+		}
+	}
+
+	before := indexBy(old["upstreams"], upstreamKey)
+	after := indexBy(cur["upstreams"], upstreamKey)
+
+	for _, name := range sortedKeys(before, after) {
+		o, hadOld := before[name]
+		c, hasCur := after[name]
+
+		switch {
+		case !hadOld:
+			out = append(out, "    + upstream "+name)
+		case !hasCur:
+			out = append(out, "    - upstream "+name)
+		default:
+			for _, change := range diffValues("", o, c) {
+				out = append(out, "    ~ upstream "+name+": "+change)
+			}
+		}
+	}
+
+	return out
+}
+
+// diffValues descends into mappings and reports changed leaves as
+// "path: old -> new". Lists are compared as a whole.
+func diffValues(path string, old, cur any) []string {
+	om, oldIsMap := old.(map[string]any)
+	cm, curIsMap := cur.(map[string]any)
+
+	if oldIsMap && curIsMap {
+		var out []string
+
+		for _, key := range sortedKeys(om, cm) {
+			sub := key
+			if path != "" {
+				sub = path + "." + key
+			}
+
+			out = append(out, diffValues(sub, om[key], cm[key])...)
+		}
+
+		return out
+	}
+
+	if reflect.DeepEqual(maskDigests(old), maskDigests(cur)) {
+		return nil
+	}
+
+	return []string{fmt.Sprintf("%s: %s -> %s", path, compact(old), compact(cur))}
+}
+
+// snapshotDigest matches the digests RoutingSnapshot puts in place of redacted
+// values. They are keyed per process, so the local and running ones never match.
+var snapshotDigest = regexp.MustCompile(`hmac:[0-9a-f]{16}`)
+
+func maskDigests(v any) any {
+	switch value := v.(type) {
+	case string:
+		return snapshotDigest.ReplaceAllString(value, "hmac")
+	case []any:
+		out := make([]any, len(value))
+		for i, item := range value {
+			out[i] = maskDigests(item)
+		}
+
+		return out
+	case map[string]any:
+		out := make(map[string]any, len(value))
+		for k, item := range value {
+			out[k] = maskDigests(item)
+		}
+
+		return out
+	default:
+		return v
+	}
+}
+
+// compact renders a value on one line, using YAML flow style for collections.
+func compact(v any) string {
+	var n yaml.Node
+	if err := n.Encode(v); err != nil {
+		return fmt.Sprint(v)
+	}
+
+	setFlowStyle(&n)
+
+	out, err := yaml.Marshal(&n)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+func setFlowStyle(n *yaml.Node) {
+	if n.Kind == yaml.MappingNode || n.Kind == yaml.SequenceNode {
+		n.Style = yaml.FlowStyle
+	}
+
+	for _, c := range n.Content {
+		setFlowStyle(c)
+	}
+}
+
+func indexBy(list any, key func(map[string]any) string) map[string]any {
+	items, _ := list.([]any)
+	out := make(map[string]any, len(items))
+
+	for _, item := range items {
+		if m, ok := item.(map[string]any); ok {
+			out[key(m)] = m
+		}
+	}
+
+	return out
+}
+
+func flowKey(f map[string]any) string {
+	path, _ := f["path"].(string)
+	if path == "" {
+		path, _ = f["path_regex"].(string)
+	}
+
+	method, _ := f["method"].(string)
+
+	return method + " " + path
+}
+
+func upstreamKey(u map[string]any) string {
+	name, _ := u["name"].(string)
+	return name
+}
+
+func sortedKeys[V any](maps ...map[string]V) []string {
+	var keys []string
+
+	for _, m := range maps {
+		for k := range m {
+			if !slices.Contains(keys, k) {
+				keys = append(keys, k)
+			}
+		}
+	}
+
+	slices.Sort(keys)
+
+	return keys
+}
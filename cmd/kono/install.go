@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/starwalkn/kono"
+)
+
+const (
+	pluginInstallTimeout = 5 * time.Minute
+	maxPluginSize        = 512 << 20
+
+	// pluginFileMode leaves installed objects readable by an unprivileged gateway.
+	pluginFileMode = 0o644
+
+	// sigSuffix names the detached signature the gateway reads for verify.public_key.
+	sigSuffix = ".sig"
+)
+
+type pluginInstallFlags struct {
+	registry       string
+	kind           string
+	gatewayVersion string
+	sha256         string
+	publicKey      string
+	dir            string
+	// allowHTTP permits a plain http registry, for local testing.
+	allowHTTP bool
+}
+
+func init() {
+	flags := &pluginInstallFlags{}
+
+	installCmd := &cobra.Command{
+		Use:   "install <name>",
+		Short: "Download a prebuilt plugin or middleware from a registry",
+		Long: "Download a prebuilt plugin or middleware into the directory of source " +
+			"\"builtin\". Go plugins only load into the gateway build they were compiled " +
+			"against, so the artifact is looked up by gateway version and platform:\n\n" +
+			"  <registry>/<name>/<version>/<GOOS>-<GOARCH>/<name>.so\n\n" +
+			"The registry must be https. The file is checked against --sha256 and/or against " +
+			"<name>.so.sig, which must be signed by --public-key; one of them is required. " +
+			"The signature is installed next to the file, so verify.public_key can check it " +
+			"again at load time.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithTimeout(cmd.Context(), pluginInstallTimeout)
+			defer cancel()
+
+			return runPluginInstall(ctx, args[0], *flags)
+		},
+	}
+
+	installCmd.Flags().StringVar(&flags.registry, "registry", os.Getenv("KONO_PLUGIN_REGISTRY"),
+		"Base URL of the plugin registry (env KONO_PLUGIN_REGISTRY)")
+	installCmd.Flags().StringVar(&flags.kind, "kind", "plugin", "Artifact kind: plugin, middleware")
+	installCmd.Flags().StringVar(&flags.gatewayVersion, "gateway-version", version,
+		"Gateway version to match (default: the version of this binary)")
+	installCmd.Flags().StringVar(&flags.sha256, "sha256", "", "Expected hex SHA-256 of the artifact")
+	installCmd.Flags().StringVar(&flags.publicKey, "public-key", os.Getenv("KONO_PLUGIN_PUBLIC_KEY"),
+		"Base64 Ed25519 key the artifact signature must verify against (env KONO_PLUGIN_PUBLIC_KEY)")
+	installCmd.Flags().BoolVar(&flags.allowHTTP, "allow-insecure-http", false, "Allow a plain http registry")
+	installCmd.Flags().StringVar(&flags.dir, "dir", "", "Install directory (default: the builtin path of --kind)")
+
+	pluginCmd.AddCommand(installCmd)
+}
+
+func runPluginInstall(ctx context.Context, name string, f pluginInstallFlags) error {
+	if f.registry == "" {
+		return errors.New("no registry: pass --registry or set KONO_PLUGIN_REGISTRY")
+	}
+
+	if f.gatewayVersion == "" {
+		return errors.New("gateway version unknown (built without ldflags): pass --gateway-version")
+	}
+
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return fmt.Errorf("invalid plugin name %q", name)
+	}
+
+	dir := f.dir
+	if dir == "" {
+		switch f.kind {
+		case "plugin":
+			dir = kono.BuiltinPluginsPath
+		case "middleware":
+			dir = kono.BuiltinMiddlewaresPath
+		default:
+			return fmt.Errorf("unknown kind: %q (must be plugin, middleware)", f.kind)
+		}
+	}
+
+	if f.sha256 == "" && f.publicKey == "" {
+		return errors.New("nothing to verify the artifact against: pass --sha256 or --public-key")
+	}
+
+	registry, err := url.Parse(f.registry)
+	if err != nil {
+		return fmt.Errorf("invalid registry: %w", err)
+	}
+
+	if registry.Scheme != "https" && (registry.Scheme != "http" || !f.allowHTTP) {
+		return fmt.Errorf("registry must be https, got %q", f.registry)
+	}
+
+	artifact, err := url.JoinPath(f.registry, name, f.gatewayVersion, runtime.GOOS+"-"+runtime.GOARCH, name+".so")
+	if err != nil {
+		return fmt.Errorf("build artifact URL: %w", err)
+	}
+
+	data, err := fetch(ctx, artifact)
+	if err != nil {
+		return fmt.Errorf("fetch artifact: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	got := hex.EncodeToString(sum[:])
+
+	if f.sha256 != "" && got != strings.ToLower(f.sha256) {
+		return fmt.Errorf("checksum mismatch for %s: got %s, want %s", artifact, got, f.sha256)
+	}
+
+	sig, err := fetch(ctx, artifact+sigSuffix)
+	if err != nil && !errors.Is(err, errNotFound) {
+		return fmt.Errorf("fetch signature: %w", err)
+	}
+
+	if f.publicKey != "" {
+		if err = verifySignature(f.publicKey, data, sig); err != nil {
+			return fmt.Errorf("verify %s: %w", artifact, err)
+		}
+	}
+
+	if err = os.MkdirAll(dir, 0o755); err != nil { //nolint:gosec // plugin directories are read by the gateway user.
+		return fmt.Errorf("create install dir: %w", err)
+	}
+
+	dest := filepath.Join(dir, name+".so")
+
+	// Without a published signature, one left by an earlier install would no
+	// longer match the file.
+	if err = installFiles(dest, data, sig); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(os.Stdout, "installed %s (sha256 %s)\n", dest, got)
+
+	return nil
+}
+
+// verifySignature checks sig, raw or base64-encoded, against the base64 Ed25519
+// publicKey.
+func verifySignature(publicKey string, data, sig []byte) error {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("--public-key must be a base64 Ed25519 public key")
+	}
+
+	if sig == nil {
+		return errors.New("the registry publishes no signature")
+	}
+
+	if len(sig) != ed25519.SignatureSize {
+		decoded, decodeErr := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+		if decodeErr != nil || len(decoded) != ed25519.SignatureSize {
+			return errors.New("signature is not a raw or base64 Ed25519 signature")
+		}
+
+		sig = decoded
+	}
+
+	if !ed25519.Verify(key, data, sig) {
+		return errors.New("signature does not match --public-key")
+	}
+
+	return nil
+}
+
+var errNotFound = errors.New("not found")
+
+// installClient fetches artifacts; tests replace it to trust their server.
+var installClient = http.DefaultClient
+
+func fetch(ctx context.Context, location string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := installClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("%s: %w", location, errNotFound)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%s: unexpected status %d", location, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxPluginSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > maxPluginSize {
+		return nil, fmt.Errorf("%s: larger than %d bytes", location, maxPluginSize)
+	}
+
+	return data, nil
+}
+
+// installFiles replaces dest and its signature. Both are written to temporary
+// files first, so a failed write changes neither, and each is renamed into place
+// so a running gateway never opens a partial file. A nil sig removes dest's
+// signature.
+func installFiles(dest string, data, sig []byte) error {
+	tmpObject, err := writeTemp(dest, data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpObject)
+
+	if sig == nil {
+		if err = os.Remove(dest + sigSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove stale signature: %w", err)
+		}
+	} else {
+		tmpSig, sigErr := writeTemp(dest+sigSuffix, sig)
+		if sigErr != nil {
+			return sigErr
+		}
+		defer os.Remove(tmpSig)
+
+		if err = os.Rename(tmpSig, dest+sigSuffix); err != nil {
+			return fmt.Errorf("install %s: %w", dest+sigSuffix, err)
+		}
+	}
+
+	if err = os.Rename(tmpObject, dest); err != nil {
+		return fmt.Errorf("install %s: %w", dest, err)
+	}
+
+	return nil
+}
+
+// writeTemp writes data to a temporary file next to path and returns its name.
+func writeTemp(path string, data []byte) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return "", fmt.Errorf("create temp file: %w", err)
+	}
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Chmod(pluginFileMode)
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", fmt.Errorf("write %s: %w", path, err)
+	}
+
+	return tmp.Name(), nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// pluginRegistry serves files by their path below the artifact directory of
+// plugin "auth" for gateway version v1.0.0.
+func pluginRegistry(t *testing.T, files map[string][]byte) *httptest.Server {
+	t.Helper()
+
+	prefix := "/auth/v1.0.0/" + runtime.GOOS + "-" + runtime.GOARCH + "/"
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[strings.TrimPrefix(r.URL.Path, prefix)]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		_, _ = w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+
+	prev := installClient
+	installClient = srv.Client()
+	t.Cleanup(func() { installClient = prev })
+
+	return srv
+}
+
+func TestPluginInstall_RequiresHTTPS(t *testing.T) {
+	err := runPluginInstall(context.Background(), "auth", pluginInstallFlags{
+		registry:       "http://registry.example",
+		gatewayVersion: "v1.0.0",
+		sha256:         strings.Repeat("0", 64),
+		dir:            t.TempDir(),
+	})
+	if err == nil || !strings.Contains(err.Error(), "must be https") {
+		t.Fatalf("expected a plain http registry to be refused, got %v", err)
+	}
+}
+
+func TestPluginInstall_RequiresPinnedTrust(t *testing.T) {
+	srv := pluginRegistry(t, map[string][]byte{"auth.so": []byte("object"), "auth.so.sha256": []byte("ignored")})
+
+	err := runPluginInstall(context.Background(), "auth", pluginInstallFlags{
+		registry:       srv.URL,
+		gatewayVersion: "v1.0.0",
+		dir:            t.TempDir(),
+	})
+	if err == nil || !strings.Contains(err.Error(), "--sha256 or --public-key") {
+		t.Fatalf("expected a checksum from the registry alone to be refused, got %v", err)
+	}
+}
+
+func TestPluginInstall_ChecksSHA256(t *testing.T) {
+	data := []byte("object")
+	srv := pluginRegistry(t, map[string][]byte{"auth.so": data})
+	dir := t.TempDir()
+
+	sum := sha256.Sum256(data)
+	flags := pluginInstallFlags{registry: srv.URL, gatewayVersion: "v1.0.0", sha256: strings.Repeat("0", 64), dir: dir}
+
+	if err := runPluginInstall(context.Background(), "auth", flags); err == nil {
+		t.Fatal("expected a checksum mismatch")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "auth.so")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected nothing to be installed, got %v", err)
+	}
+
+	// A signature from an earlier install no longer matches.
+	if err := os.WriteFile(filepath.Join(dir, "auth.so.sig"), []byte("stale"), 0o600); err != nil {
+		t.Fatalf("write stale signature: %v", err)
+	}
+
+	flags.sha256 = hex.EncodeToString(sum[:])
+	if err := runPluginInstall(context.Background(), "auth", flags); err != nil {
+		t.Fatalf("install: %v", err)
+	}
+
+	if got, err := os.ReadFile(filepath.Join(dir, "auth.so")); err != nil || string(got) != "object" {
+		t.Fatalf("expected the object to be installed, got %q: %v", got, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "auth.so.sig")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the stale signature to be removed, got %v", err)
+	}
+}
+
+func TestPluginInstall_ChecksSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	data := []byte("object")
+	sig := []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data)))
+
+	install := func(files map[string][]byte, key ed25519.PublicKey) (string, error) {
+		dir := t.TempDir()
+		srv := pluginRegistry(t, files)
+
+		return dir, runPluginInstall(context.Background(), "auth", pluginInstallFlags{
+			registry:       srv.URL,
+			gatewayVersion: "v1.0.0",
+			publicKey:      base64.StdEncoding.EncodeToString(key),
+			dir:            dir,
+		})
+	}
+
+	if _, err = install(map[string][]byte{"auth.so": data}, pub); err == nil || !strings.Contains(err.Error(), "no signature") {
+		t.Fatalf("expected a missing signature to be refused, got %v", err)
+	}
+
+	other, _, _ := ed25519.GenerateKey(nil)
+	if _, err = install(map[string][]byte{"auth.so": data, "auth.so.sig": sig}, other); err == nil {
+		t.Fatal("expected a signature by another key to be refused")
+	}
+
+	dir, err := install(map[string][]byte{"auth.so": data, "auth.so.sig": sig}, pub)
+	if err != nil {
+		t.Fatalf("install: %v", err)
+	}
+
+	if got, readErr := os.ReadFile(filepath.Join(dir, "auth.so.sig")); readErr != nil || string(got) != string(sig) {
+		t.Fatalf("expected the signature to be installed, got %q: %v", got, readErr)
+	}
+}
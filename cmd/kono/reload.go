@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/kono"
+	"github.com/starwalkn/kono/internal/server"
+)
+
+const watchRetryDelay = 5 * time.Second
+
+// configWatcher reloads the router when a remote configuration publishes a new
+// version or a provider secret used by the configuration changes.
+type configWatcher struct {
+	src     kono.ConfigSource
+	version string
+	cfg     kono.Config
+	srv     *server.Server
+	log     *zap.Logger
+}
+
+// configUpdate is a new document version pushed by a watchable source.
+type configUpdate struct {
+	data    []byte
+	version string
+}
+
+func (w *configWatcher) run(ctx context.Context) {
+	updates := make(chan configUpdate)
+
+	ws, watchable := w.src.(kono.WatchableSource)
+	if watchable {
+		go watchSource(ctx, ws, w.version, updates, w.log)
+	}
+
+	poll := newTicker(w.cfg.Source.PollInterval, w.src.Remote() && !watchable)
+	defer poll.Stop()
+
+	secrets := newTicker(w.cfg.Secrets.RefreshInterval, true)
+	defer secrets.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case u := <-updates:
+			w.apply(ctx, u.data, u.version, "config changed")
+		case <-poll.C:
+			w.poll(ctx)
+		case <-secrets.C:
+			w.refreshSecrets(ctx)
+		}
+	}
+}
+
+// watchSource forwards every new version of a KV-backed configuration. Failed
+// watches are retried after watchRetryDelay.
+func watchSource(ctx context.Context, src kono.WatchableSource, version string, updates chan<- configUpdate, log *zap.Logger) {
+	for ctx.Err() == nil {
+		data, next, err := src.Watch(ctx, version)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			log.Error("watch config", zap.Error(err))
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(watchRetryDelay):
+			}
+
+			continue
+		}
+
+		version = next
+
+		if data == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case updates <- configUpdate{data: data, version: next}:
+		}
+	}
+}
+
+// poll applies a new version of a remote configuration. Unchanged versions are
+// answered from ETags or content digests without parsing.
+func (w *configWatcher) poll(ctx context.Context) {
+	data, version, err := w.src.Fetch(ctx, w.version)
+	if err != nil {
+		w.log.Error("poll config", zap.Error(err))
+		return
+	}
+
+	if data == nil {
+		return
+	}
+
+	w.apply(ctx, data, version, "config changed")
+}
+
+// refreshSecrets reloads when a resolved secret changes. The document is fetched
+// again so the new values are interpolated.
+func (w *configWatcher) refreshSecrets(ctx context.Context) {
+	changed, err := w.cfg.SecretsChanged(ctx)
+	if err != nil {
+		w.log.Error("refresh secrets", zap.Error(err))
+		return
+	}
+
+	if !changed {
+		return
+	}
+
+	data, version, err := w.src.Fetch(ctx, "")
+	if err != nil {
+		w.log.Error("reload config after secret rotation", zap.Error(err))
+		return
+	}
+
+	w.apply(ctx, data, version, "secrets rotated")
+}
+
+// apply keeps serving the previous configuration when the new one is invalid.
+func (w *configWatcher) apply(ctx context.Context, data []byte, version, reason string) {
+	next, err := kono.ParseConfig(data)
+	if err != nil {
+		w.log.Error("reload config", zap.String("reason", reason), zap.Error(err))
+		return
+	}
+
+	if err = w.srv.Reload(ctx, next.Gateway); err != nil {
+		w.log.Error("reload router", zap.String("reason", reason), zap.Error(err))
+		return
+	}
+
+	w.log.Info(reason, zap.String("version", version))
+
+	w.cfg = next
+	w.version = version
+}
+
+// newTicker returns a ticker that never fires when the interval is not positive
+// or the feature is off.
+func newTicker(interval time.Duration, enabled bool) *time.Ticker {
+	if interval <= 0 || !enabled {
+		t := time.NewTicker(time.Hour)
+		t.Stop()
+
+		return t
+	}
+
+	return time.NewTicker(interval)
+}
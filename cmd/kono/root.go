@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"os"
 
 	"github.com/spf13/cobra"
+
+	"github.com/starwalkn/kono"
 )
 
 const fallbackConfigPath = "/etc/kono/config.yaml"
@@ -29,6 +32,35 @@ func init() {
 		&cfgPath,
 		"config",
 		"",
-		"Path to configuration file (env KONO_CONFIG)",
+		"Path or http(s)://, s3://, consul:// or etcd:// URL of the configuration (env KONO_CONFIG)",
 	)
 }
+
+// configPath resolves the configuration location from the flag, KONO_CONFIG or
+// the fallback path.
+func configPath() string {
+	if cfgPath == "" {
+		cfgPath = os.Getenv("KONO_CONFIG")
+	}
+	if cfgPath == "" {
+		cfgPath = fallbackConfigPath
+	}
+
+	return cfgPath
+}
+
+// loadConfig loads the configuration from configPath, returning the source for
+// later polling.
+func loadConfig(ctx context.Context) (kono.Config, kono.ConfigSource, string, error) {
+	src, err := kono.NewConfigSource(configPath())
+	if err != nil {
+		return kono.Config{}, nil, "", err
+	}
+
+	cfg, version, err := kono.LoadConfigFrom(ctx, src)
+	if err != nil {
+		return kono.Config{}, nil, "", err
+	}
+
+	return cfg, src, version, nil
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/pprof"
-	"os"
 	"os/signal"
 	"syscall"
 	"time"
@@ -20,7 +19,6 @@ import (
 )
 
 const (
-	shutdownTimeout  = 10 * time.Second
 	bootstrapTimeout = 30 * time.Second
 
 	pprofReadTimeout  = 10 * time.Second
@@ -46,23 +44,16 @@ func init() {
 }
 
 func runServe() error {
-	if cfgPath == "" {
-		cfgPath = os.Getenv("KONO_CONFIG")
-	}
-	if cfgPath == "" {
-		cfgPath = fallbackConfigPath
-	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	cfg, err := kono.LoadConfig(cfgPath)
+	cfg, src, cfgVersion, err := loadConfig(ctx)
 	if err != nil {
 		return err
 	}
 
 	log := logger.New(cfg.Debug)
 
-	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer stop()
-
 	bootstrapCtx, cancelBootstrap := context.WithTimeout(ctx, bootstrapTimeout)
 
 	srv, err := server.New(bootstrapCtx, cfg.Gateway, version, log)
@@ -71,6 +62,10 @@ func runServe() error {
 		return fmt.Errorf("server init: %w", err)
 	}
 
+	if err = srv.Listen(); err != nil {
+		return fmt.Errorf("server listen: %w", err)
+	}
+
 	serverErrCh := make(chan error, 1)
 	go func() {
 		if err = srv.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -83,14 +78,23 @@ func runServe() error {
 		serverErrCh <- nil
 	}()
 
+	if err = server.NotifyReady(); err != nil {
+		log.Error("notify upgrading process", zap.Error(err))
+	}
+
 	log.Info("server started")
 
+	go handleUpgrades(ctx, srv, log, stop)
+
 	stopPprof := startPprofServer(cfg.Gateway.Server.Pprof, log)
 
+	w := &configWatcher{src: src, version: cfgVersion, cfg: cfg, srv: srv, log: log}
+	go w.run(ctx)
+
 	<-ctx.Done()
 	log.Info("shutdown signal received")
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), srv.ShutdownTimeout())
 	defer cancel()
 
 	if err = srv.Stop(shutdownCtx); err != nil {
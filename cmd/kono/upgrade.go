@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/kono/internal/server"
+)
+
+// upgradeTimeout bounds how long a new binary may take to bootstrap and bind
+// the handed-over sockets before the upgrade is abandoned.
+const upgradeTimeout = 2 * bootstrapTimeout
+
+// handleUpgrades replaces the running process with the binary now installed at
+// its path when an upgrade signal (SIGUSR2) arrives. The new process takes over
+// the listening sockets; this one then drains through stop as on SIGTERM. A
+// failed upgrade leaves this process serving.
+func handleUpgrades(ctx context.Context, srv *server.Server, log *zap.Logger, stop context.CancelFunc) {
+	if len(upgradeSignals) == 0 {
+		return
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, upgradeSignals...)
+	defer signal.Stop(sigCh)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigCh:
+		}
+
+		log.Info("upgrade requested")
+
+		upgradeCtx, cancel := context.WithTimeout(ctx, upgradeTimeout)
+		err := srv.Upgrade(upgradeCtx)
+		cancel()
+
+		if err != nil {
+			log.Error("upgrade failed, still serving", zap.Error(err))
+			continue
+		}
+
+		log.Info("new process is listening, draining this one", zap.Duration("grace", srv.ShutdownTimeout()))
+		stop()
+
+		return
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// Binary upgrades rely on inheriting sockets, which is only wired up on unix.
+var upgradeSignals []os.Signal
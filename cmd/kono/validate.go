@@ -1,47 +1,60 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 
-	"github.com/starwalkn/kono"
+	"github.com/starwalkn/kono/internal/logger"
+	"github.com/starwalkn/kono/internal/server"
 )
 
+// dryRunTimeout bounds building the router, which may fetch JWKS keys, discovery
+// endpoints and the like.
+const dryRunTimeout = 30 * time.Second
+
+var dryRun bool
+
 var validateCmd = &cobra.Command{
-	Use:          "validate",
-	Short:        "Validates configuration file",
+	Use:   "validate",
+	Short: "Validates configuration file",
+	Long: "Validates the configuration file. With --dry-run the gateway is also built " +
+		"the way serve builds it (plugins and middlewares loaded, flows compiled, TLS " +
+		"certificates read) and torn down again without binding any port.",
 	Args:         cobra.NoArgs,
 	SilenceUsage: true,
 	Run: func(_ *cobra.Command, _ []string) {
 		err := runValidate()
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err.Error())
-			return
+			os.Exit(1)
 		}
 
 		fmt.Println("OK")
-		os.Exit(1)
 	},
 }
 
 func init() {
+	validateCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Build the router and listeners without serving")
+
 	rootCmd.AddCommand(validateCmd)
 }
 
 func runValidate() error {
-	if cfgPath == "" {
-		cfgPath = os.Getenv("KONO_CONFIG")
-	}
-	if cfgPath == "" {
-		cfgPath = fallbackConfigPath
-	}
-
-	_, err := kono.LoadConfig(cfgPath)
+	cfg, _, _, err := loadConfig(context.Background())
 	if err != nil {
 		return err
 	}
 
-	return nil
+	if !dryRun {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dryRunTimeout)
+	defer cancel()
+
+	return server.DryRun(ctx, cfg.Gateway, version, logger.New(cfg.Debug))
 }
@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/charmbracelet/lipgloss"
@@ -16,14 +15,7 @@ var vizCmd = &cobra.Command{
 	Short: "Visualize gateway flows",
 	Args:  cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, _ []string) error {
-		if cfgPath == "" {
-			cfgPath = os.Getenv("KONO_CONFIG")
-		}
-		if cfgPath == "" {
-			cfgPath = fallbackConfigPath
-		}
-
-		cfg, err := kono.LoadConfig(cfgPath)
+		cfg, _, _, err := loadConfig(cmd.Context())
 		if err != nil {
 			return err
 		}
@@ -144,7 +136,7 @@ func (v *viz) renderHeader(routing kono.RoutingConfig) {
 
 func (v *viz) renderFlow(f kono.FlowConfig) {
 	method := v.methodBadge(f.Method)
-	path := "  " + method + "  " + stylePath.Render(f.Path)
+	path := "  " + method + "  " + stylePath.Render(f.RoutePattern())
 
 	if f.Passthrough {
 		path += "  " + stylePassthrough.Render("⇢ passthrough")
@@ -156,7 +148,11 @@ func (v *viz) renderFlow(f kono.FlowConfig) {
 
 	indent := "  "
 
-	if names := collectNames(f.Plugins, func(p kono.PluginConfig) string { return p.Name }); names != "" {
+	if names := collectNames(f.Headers, headerMatcherLabel); names != "" {
+		fmt.Println(indent + styleLabel.Render("headers") + styleNames.Render(names))
+	}
+
+	if names := pluginChain(f.OrderedPlugins()); names != "" {
 		fmt.Println(indent + styleLabel.Render("plugins") + styleNames.Render(names))
 	}
 
@@ -164,7 +160,7 @@ func (v *viz) renderFlow(f kono.FlowConfig) {
 		fmt.Println(indent + styleLabel.Render("middlewares") + styleNames.Render(names))
 	}
 
-	if len(f.Plugins) > 0 || len(f.Middlewares) > 0 {
+	if len(f.Headers) > 0 || len(f.Plugins) > 0 || len(f.Middlewares) > 0 {
 		fmt.Println(styleTree.Render("  │"))
 	}
 
@@ -187,8 +183,33 @@ func (v *viz) renderUpstream(u kono.UpstreamConfig, last bool) {
 
 	fmt.Println(styleTree.Render(connector) + name + "  " + meta)
 
-	for i, host := range u.Hosts {
-		hostLast := i == len(u.Hosts)-1
+	hosts := []string(u.Hosts)
+	labels := make([]string, len(hosts))
+
+	if u.Discovery != nil {
+		target := u.Discovery.Name
+		if u.Discovery.Service != "" {
+			target = u.Discovery.Service
+		}
+
+		hosts = []string{u.Discovery.Type + "://" + target}
+		labels = []string{""}
+	}
+
+	if u.Type == "mock" {
+		hosts = []string{"mock://" + u.Name}
+		labels = []string{""}
+	}
+
+	for _, g := range u.Groups {
+		for _, host := range g.Hosts {
+			hosts = append(hosts, host)
+			labels = append(labels, fmt.Sprintf("%s %d%%", g.Name, g.Weight))
+		}
+	}
+
+	for i, host := range hosts {
+		hostLast := i == len(hosts)-1
 		tree := hostIndent + styleTree.Render("├ ")
 
 		if hostLast {
@@ -206,6 +227,10 @@ func (v *viz) renderUpstream(u kono.UpstreamConfig, last bool) {
 			line = tree + v.methodBadge(u.Method) + " " + styleHost.Render(hostStr)
 		}
 
+		if labels[i] != "" {
+			line += "  " + styleMeta.Render(labels[i])
+		}
+
 		fmt.Println(line)
 	}
 }
@@ -225,6 +250,10 @@ func (v *viz) methodBadge(method string) string {
 func (v *viz) strategyLabel(f kono.FlowConfig) string {
 	s := f.Aggregation.Strategy
 
+	if f.Sequential {
+		s += "  sequential"
+	}
+
 	if f.Aggregation.BestEffort {
 		s += "  best-effort"
 	}
@@ -260,6 +289,18 @@ func (v *viz) upstreamMeta(u kono.UpstreamConfig) string {
 	return base
 }
 
+// headerMatcherLabel renders a header matcher as name=value, name^=prefix or name~=regex.
+func headerMatcherLabel(h kono.HeaderMatcherConfig) string {
+	switch {
+	case h.Prefix != "":
+		return h.Name + "^=" + h.Prefix
+	case h.Regex != "":
+		return h.Name + "~=" + h.Regex
+	default:
+		return h.Name + "=" + h.Exact
+	}
+}
+
 // collectNames builds a " · "-joined string of names from a slice using nameFunc.
 func collectNames[T any](items []T, nameFunc func(T) string) string {
 	if len(items) == 0 {
@@ -274,6 +315,20 @@ func collectNames[T any](items []T, nameFunc func(T) string) string {
 	return strings.Join(names, styleFaint.Render(" · "))
 }
 
+// pluginChain lists plugins in execution order, with their order when set.
+func pluginChain(plugins []kono.PluginConfig) string {
+	names := make([]string, len(plugins))
+
+	for i, p := range plugins {
+		names[i] = p.Name
+		if p.Order != 0 {
+			names[i] += styleFaint.Render(fmt.Sprintf(" (%d)", p.Order))
+		}
+	}
+
+	return strings.Join(names, styleFaint.Render(" → "))
+}
+
 // leftPad pads s with trailing spaces to ensure column alignment up to width.
 func leftPad(s string, width int) string {
 	if len(s) >= width {
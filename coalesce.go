@@ -0,0 +1,86 @@
+package kono
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// coalescedHeaders carry caller credentials. They always take part in the
+// coalescing key, so that callers never receive responses fetched with someone
+// else's credentials.
+var coalescedHeaders = []string{"Authorization", "Cookie", "X-Api-Key", "Api-Key", "X-Auth-Token"}
+
+// coalescer collapses identical concurrent GET and HEAD requests of a flow into a
+// single upstream fan-out whose responses every caller receives.
+type coalescer struct {
+	group singleflight.Group
+	vary  []string
+}
+
+// newCoalescer returns nil when the flow does not coalesce requests.
+func newCoalescer(cfg *CoalesceConfig) *coalescer {
+	if cfg == nil {
+		return nil
+	}
+
+	vary := slices.Clone(coalescedHeaders)
+	for _, h := range cfg.Vary {
+		vary = append(vary, http.CanonicalHeaderKey(h))
+	}
+
+	return &coalescer{vary: vary}
+}
+
+func (c *coalescer) key(req *http.Request) string {
+	var sb strings.Builder
+
+	sb.WriteString(req.Method)
+	sb.WriteByte(' ')
+	sb.WriteString(req.URL.Path)
+	writeSortedQuery(&sb, req.URL.Query())
+
+	for _, h := range c.vary {
+		sb.WriteString("\n" + h + ":" + escapeKeyValues(req.Header.Values(h)))
+	}
+
+	return sb.String()
+}
+
+// dispatch fans out to the flow upstreams, sharing the call with identical requests
+// already in flight. The shared call is detached from the caller that started it,
+// so one client going away does not fail the others.
+func (r *Router) dispatch(f *flow, req *http.Request) []upstreamResponse {
+	if f.coalescer == nil || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+		return r.scatter.scatter(f, req)
+	}
+
+	v, _, _ := f.coalescer.group.Do(f.coalescer.key(req), func() (any, error) {
+		return r.scatter.scatter(f, req.WithContext(context.WithoutCancel(req.Context()))), nil
+	})
+
+	return cloneUpstreamResponses(v.([]upstreamResponse))
+}
+
+// cloneUpstreamResponses copies shared responses, which aggregation may modify.
+func cloneUpstreamResponses(responses []upstreamResponse) []upstreamResponse {
+	if responses == nil {
+		return nil
+	}
+
+	cloned := make([]upstreamResponse, len(responses))
+
+	for i, resp := range responses {
+		cloned[i] = upstreamResponse{
+			status:  resp.status,
+			headers: resp.headers.Clone(),
+			body:    slices.Clone(resp.body),
+			err:     resp.err,
+		}
+	}
+
+	return cloned
+}
@@ -0,0 +1,91 @@
+package kono
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// blockingScatter holds every fan-out until release is closed.
+type blockingScatter struct {
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (s *blockingScatter) scatter(_ *flow, _ *http.Request) []upstreamResponse {
+	s.calls.Add(1)
+	<-s.release
+
+	return []upstreamResponse{{status: http.StatusOK, headers: http.Header{}, body: []byte(`"OK"`)}}
+}
+
+var _ = Describe("coalescer", func() {
+	It("keys on credentials and vary headers", func() {
+		c := newCoalescer(&CoalesceConfig{Vary: []string{"x-tenant"}})
+
+		req := func(auth, tenant string) *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/items?b=2&a=1", nil)
+			r.Header.Set("Authorization", auth)
+			r.Header.Set("X-Tenant", tenant)
+
+			return r
+		}
+
+		Expect(c.key(req("a", "t1"))).To(Equal(c.key(req("a", "t1"))))
+		Expect(c.key(req("a", "t1"))).NotTo(Equal(c.key(req("b", "t1"))))
+		Expect(c.key(req("a", "t1"))).NotTo(Equal(c.key(req("a", "t2"))))
+
+		withAPIKey := func(key string) *http.Request {
+			r := req("a", "t1")
+			r.Header.Set("X-Api-Key", key)
+
+			return r
+		}
+
+		Expect(c.key(withAPIKey("k1"))).NotTo(Equal(c.key(withAPIKey("k2"))))
+	})
+
+	It("shares one fan-out between identical concurrent requests", func() {
+		scatter := &blockingScatter{release: make(chan struct{})}
+
+		r := newTestRouter([]flow{{
+			path:        "/test/coalesce",
+			method:      http.MethodGet,
+			aggregation: aggregation{strategy: strategyArray},
+			coalescer:   newCoalescer(&CoalesceConfig{}),
+		}}, scatter, &defaultAggregator{})
+
+		const clients = 5
+
+		var wg sync.WaitGroup
+		codes := make(chan int, clients)
+
+		for range clients {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test/coalesce", nil))
+				codes <- rec.Code
+			}()
+		}
+
+		Eventually(scatter.calls.Load).Should(Equal(int32(1)))
+		Consistently(scatter.calls.Load, "50ms").Should(Equal(int32(1)))
+
+		close(scatter.release)
+		wg.Wait()
+		close(codes)
+
+		for code := range codes {
+			Expect(code).To(Equal(http.StatusOK))
+		}
+
+		Expect(scatter.calls.Load()).To(BeNumerically("<", clients))
+	})
+})
@@ -0,0 +1,144 @@
+package kono
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+)
+
+var (
+	defaultCompressionEncodings    = []string{encodingBrotli, encodingGzip}
+	defaultCompressionContentTypes = []string{"application/json", "application/xml", "text/*"}
+)
+
+// compression compresses buffered client responses. A nil *compression disables it.
+type compression struct {
+	encodings    []string // server preference order.
+	minSize      int
+	contentTypes []string // exact media types or "type/*" prefixes.
+}
+
+func compileCompression(cfg *CompressionConfig) (*compression, error) {
+	if cfg == nil || !cfg.Enabled {
+		return nil, nil //nolint:nilnil // disabled compression is represented by nil
+	}
+
+	c := &compression{
+		encodings:    cfg.Encodings,
+		minSize:      cfg.MinSize,
+		contentTypes: cfg.ContentTypes,
+	}
+
+	if len(c.encodings) == 0 {
+		c.encodings = defaultCompressionEncodings
+	}
+
+	if len(c.contentTypes) == 0 {
+		c.contentTypes = defaultCompressionContentTypes
+	}
+
+	for _, enc := range c.encodings {
+		if enc != encodingBrotli && enc != encodingGzip {
+			return nil, fmt.Errorf("unsupported compression encoding %q", enc)
+		}
+	}
+
+	return c, nil
+}
+
+// chooseEncoding returns the first configured encoding the client accepts with a
+// non-zero quality, or "" when none is acceptable.
+func (c *compression) chooseEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	accepted := make(map[string]float64)
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+
+			q = parsed
+		}
+
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	for _, enc := range c.encodings {
+		q, ok := accepted[enc]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+
+		if ok && q > 0 {
+			return enc
+		}
+	}
+
+	return ""
+}
+
+// compressible reports whether a body of the given size and content type qualifies.
+func (c *compression) compressible(contentType string, size int) bool {
+	if size < c.minSize {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return slices.ContainsFunc(c.contentTypes, func(pattern string) bool {
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			return strings.HasPrefix(mediaType, prefix+"/")
+		}
+
+		return mediaType == pattern
+	})
+}
+
+func compressBody(body []byte, encoding string) ([]byte, error) {
+	var (
+		buf bytes.Buffer
+		w   io.WriteCloser
+	)
+
+	switch encoding {
+	case encodingGzip:
+		w = gzip.NewWriter(&buf)
+	case encodingBrotli:
+		w = brotli.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unsupported compression encoding %q", encoding)
+	}
+
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
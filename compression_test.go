@@ -0,0 +1,73 @@
+package kono
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"github.com/andybalholm/brotli"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("compression", func() {
+	Describe("compileCompression", func() {
+		It("returns nil when disabled", func() {
+			c, err := compileCompression(&CompressionConfig{Enabled: false})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c).To(BeNil())
+		})
+
+		It("applies default encodings and content types", func() {
+			c, err := compileCompression(&CompressionConfig{Enabled: true, MinSize: 10})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.encodings).To(Equal([]string{encodingBrotli, encodingGzip}))
+			Expect(c.contentTypes).To(ContainElement("text/*"))
+		})
+	})
+
+	DescribeTable("chooseEncoding",
+		func(acceptEncoding, want string) {
+			c := &compression{encodings: []string{encodingBrotli, encodingGzip}}
+			Expect(c.chooseEncoding(acceptEncoding)).To(Equal(want))
+		},
+		Entry("no header", "", ""),
+		Entry("server preference wins", "gzip, br", encodingBrotli),
+		Entry("only gzip accepted", "gzip, deflate", encodingGzip),
+		Entry("zero quality excludes", "br;q=0, gzip", encodingGzip),
+		Entry("wildcard", "*", encodingBrotli),
+		Entry("nothing acceptable", "deflate", ""),
+	)
+
+	DescribeTable("compressible",
+		func(contentType string, size int, want bool) {
+			c := &compression{minSize: 100, contentTypes: []string{"application/json", "text/*"}}
+			Expect(c.compressible(contentType, size)).To(Equal(want))
+		},
+		Entry("json above min size", "application/json; charset=utf-8", 200, true),
+		Entry("text wildcard", "text/plain", 200, true),
+		Entry("below min size", "application/json", 50, false),
+		Entry("filtered content type", "image/png", 200, false),
+	)
+
+	DescribeTable("compressBody round-trips",
+		func(encoding string, reader func(io.Reader) io.Reader) {
+			body := bytes.Repeat([]byte(`{"k":"v"}`), 100)
+
+			out, err := compressBody(body, encoding)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(out)).To(BeNumerically("<", len(body)))
+
+			got, err := io.ReadAll(reader(bytes.NewReader(out)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal(body))
+		},
+		Entry("gzip", encodingGzip, func(r io.Reader) io.Reader {
+			gr, err := gzip.NewReader(r)
+			Expect(err).NotTo(HaveOccurred())
+
+			return gr
+		}),
+		Entry("brotli", encodingBrotli, func(r io.Reader) io.Reader { return brotli.NewReader(r) }),
+	)
+})
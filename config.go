@@ -1,12 +1,16 @@
 package kono
 
 import (
+	"cmp"
+	"context"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"reflect"
 	"regexp"
 	"runtime"
+	"slices"
 	"strings"
 	"time"
 
@@ -20,9 +24,35 @@ import (
 const parallelismMultiplier = 2
 
 type Config struct {
-	Schema  string        `yaml:"schema" validate:"required,oneof=v1"`
+	// Schema is the config format version. v1 documents are migrated to the
+	// current one when loaded; see MigrateConfig.
+	Schema  string        `yaml:"schema" validate:"required,oneof=v2"`
 	Debug   bool          `yaml:"debug"`
 	Gateway GatewayConfig `yaml:"gateway" validate:"required"`
+
+	// Secrets controls how provider references are kept up to date.
+	Secrets SecretsConfig `yaml:"secrets"`
+
+	// Source controls polling of a remote configuration location.
+	Source SourceConfig `yaml:"source"`
+
+	secrets secretDigests
+}
+
+// SourceConfig sets how often a remote configuration (an http(s):// or s3://
+// KONO_CONFIG) is checked for changes. A new version is applied through the same
+// router reload as secret rotation. Local files are not polled, and Consul and
+// etcd keys are watched instead.
+type SourceConfig struct {
+	PollInterval time.Duration `yaml:"poll_interval" default:"30s" validate:"min=0"`
+}
+
+// SecretsConfig sets how often ${<scheme>:<ref>} references (Vault, files) are
+// resolved again. When a value changes the router is rebuilt with the new one;
+// listener settings such as server TLS certificates still need a restart. Zero
+// disables rotation.
+type SecretsConfig struct {
+	RefreshInterval time.Duration `yaml:"refresh_interval" validate:"min=0"`
 }
 
 type GatewayConfig struct {
@@ -36,11 +66,63 @@ type ServiceConfig struct {
 }
 
 type ServerConfig struct {
-	Port    int           `yaml:"port"    validate:"required,min=1,max=65535"`
+	Port int `yaml:"port" validate:"required_without=Socket,omitempty,min=1,max=65535"`
+	// Socket serves the main listener on a unix domain socket path instead of Port.
+	Socket string `yaml:"socket"`
+
 	Timeout time.Duration `yaml:"timeout" default:"5s"`
+	// ReadHeaderTimeout bounds reading request headers and IdleTimeout how long a
+	// keep-alive connection waits for the next request. Zero uses Timeout.
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" validate:"min=0"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"        validate:"min=0"`
+
 	Pprof   PprofConfig   `yaml:"pprof"`
 	Metrics MetricsConfig `yaml:"metrics"`
 	Tracing TracingConfig `yaml:"tracing"`
+	// AccessLog writes a line per request, apart from the application log.
+	AccessLog AccessLogConfig  `yaml:"access_log"`
+	GRPC      GRPCServerConfig `yaml:"grpc"`
+	TLS       TLSConfig        `yaml:"tls"`
+	Admin     AdminConfig      `yaml:"admin"`
+	Shutdown  ShutdownConfig   `yaml:"shutdown"`
+
+	// Listeners are served next to the main listener on Port. Flows bind to them
+	// by name; flows that name no listener are served on the main one only.
+	Listeners []ListenerConfig `yaml:"listeners" validate:"omitempty,dive"`
+}
+
+// DefaultListener names the main listener on ServerConfig.Port or Socket.
+const DefaultListener = "default"
+
+type ListenerConfig struct {
+	Name string `yaml:"name" validate:"required"`
+	// Address is "host:port"; bind to 127.0.0.1 to keep a listener local.
+	Address string `yaml:"address" validate:"required_without=Socket,excluded_with=Socket,omitempty,hostname_port"`
+	// Socket is a unix domain socket path, for sidecars sharing the host.
+	Socket string    `yaml:"socket"`
+	TLS    TLSConfig `yaml:"tls"`
+}
+
+// TLSConfig terminates TLS on the HTTP listener. With several certificates the
+// one matching the client's SNI hostname is served; flows can be bound to
+// hostnames with FlowConfig.SNI.
+type TLSConfig struct {
+	Enabled      bool                   `yaml:"enabled"`
+	MinVersion   string                 `yaml:"min_version"  default:"1.2" validate:"oneof=1.2 1.3"`
+	Certificates []TLSCertificateConfig `yaml:"certificates" validate:"required_if=Enabled true,omitempty,dive"`
+}
+
+type TLSCertificateConfig struct {
+	CertFile string `yaml:"cert_file" validate:"required"`
+	KeyFile  string `yaml:"key_file"  validate:"required"`
+}
+
+// GRPCServerConfig enables a gRPC listener that transcodes unary calls into the
+// configured HTTP flows using the google.api.http annotations of DescriptorSet.
+type GRPCServerConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	Port          int    `yaml:"port"           validate:"required_if=Enabled true,omitempty,min=1,max=65535"`
+	DescriptorSet string `yaml:"descriptor_set" validate:"required_if=Enabled true"`
 }
 
 type PprofConfig struct {
@@ -48,6 +130,24 @@ type PprofConfig struct {
 	Port    int  `yaml:"port" validate:"required_if=Enabled true,omitempty,min=1,max=65535"`
 }
 
+// ShutdownConfig controls connection draining on SIGINT and SIGTERM. For
+// DrainDelay the listeners keep serving while /__health answers 503, so load
+// balancers stop sending traffic; in-flight requests then get up to Grace to
+// finish before their connections are closed.
+type ShutdownConfig struct {
+	Grace      time.Duration `yaml:"grace"       default:"10s" validate:"min=0"`
+	DrainDelay time.Duration `yaml:"drain_delay" validate:"min=0"`
+}
+
+// AdminConfig serves the runtime admin API on its own listener, local by default.
+// When Token is set, admin requests must send it as a bearer token; it is required
+// unless Address is a loopback address.
+type AdminConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address" default:"127.0.0.1:9901" validate:"hostname_port"`
+	Token   string `yaml:"token"`
+}
+
 type MetricsConfig struct {
 	Enabled  bool       `yaml:"enabled"`
 	Exporter string     `yaml:"exporter" validate:"required_if=Enabled true,omitempty,oneof=otlp prometheus"`
@@ -61,6 +161,43 @@ type TracingConfig struct {
 	OTLP          OTLPConfig `yaml:"otlp"`
 }
 
+// AccessLogConfig selects the format of the access log: "json" (one object per
+// request), "combined" (the Apache combined log format) or "template", a Go
+// text/template over the entry with the fields Time, Method, Path, Proto, Flow,
+// Status, Duration, Upstreams (Name, Status, Duration, Error), ClientIP,
+// RequestID, BytesIn, BytesOut, UserAgent and Referer.
+// Query values in Path are redacted.
+type AccessLogConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Format   string `yaml:"format"   default:"json" validate:"omitempty,oneof=json combined template"`
+	Template string `yaml:"template" validate:"required_if=Format template"`
+
+	// Output is "stdout" (the default), "file" or "syslog".
+	Output string                 `yaml:"output" default:"stdout" validate:"omitempty,oneof=stdout file syslog"`
+	File   *AccessLogFileConfig   `yaml:"file"   validate:"required_if=Output file,omitempty"`
+	Syslog *AccessLogSyslogConfig `yaml:"syslog" validate:"required_if=Output syslog,omitempty"`
+}
+
+// AccessLogFileConfig appends the access log to Path. The file is rotated once a
+// write would take it past MaxSize megabytes or once it has been written for
+// MaxAge, renamed to <path>.<time>; zero disables either limit. MaxBackups
+// rotated files are kept, the oldest removed first; zero keeps them all.
+type AccessLogFileConfig struct {
+	Path       string        `yaml:"path"        validate:"required"`
+	MaxSize    int           `yaml:"max_size"    default:"100" validate:"min=0"`
+	MaxAge     time.Duration `yaml:"max_age"     validate:"min=0"`
+	MaxBackups int           `yaml:"max_backups" default:"7" validate:"min=0"`
+}
+
+// AccessLogSyslogConfig sends each entry to a syslog daemon as an informational
+// message. An empty Network uses the local daemon.
+type AccessLogSyslogConfig struct {
+	Network  string `yaml:"network"  validate:"omitempty,oneof=udp tcp unix unixgram"`
+	Address  string `yaml:"address"  validate:"required_with=Network"`
+	Facility string `yaml:"facility" default:"local0" validate:"oneof=user daemon local0 local1 local2 local3 local4 local5 local6 local7"`
+	Tag      string `yaml:"tag"      default:"kono"`
+}
+
 type OTLPConfig struct {
 	Endpoint string        `yaml:"endpoint"`
 	Insecure bool          `yaml:"insecure"`
@@ -68,34 +205,372 @@ type OTLPConfig struct {
 }
 
 type RoutingConfig struct {
-	RateLimiter    RateLimiterConfig `yaml:"rate_limiter" validate:"omitempty"`
-	TrustedProxies []string          `yaml:"trusted_proxies"`
-	Flows          []FlowConfig      `yaml:"flows" validate:"min=1,dive,required"`
+	RateLimiter RateLimiterConfig `yaml:"rate_limiter" validate:"omitempty"`
+
+	// TrustedProxies are the CIDRs of proxies in front of the gateway. Only their
+	// forwarding headers (X-Forwarded-*, Forwarded, X-Real-IP) are believed.
+	TrustedProxies []string     `yaml:"trusted_proxies"`
+	Flows          []FlowConfig `yaml:"flows" validate:"min=1,dive,required"`
+
+	// LoadShedding caps the requests the gateway serves at once.
+	LoadShedding LoadSheddingConfig `yaml:"load_shedding"`
+
+	// Quota limits the requests each consumer may make per day or month.
+	Quota QuotaConfig `yaml:"quota"`
+
+	// Compression applies to every flow that does not define its own.
+	Compression CompressionConfig `yaml:"compression"`
+
+	// TracePropagation applies to every flow that does not define its own.
+	TracePropagation TracePropagationConfig `yaml:"trace_propagation"`
+
+	// GraphQL exposes the flows as fields of a GraphQL query endpoint.
+	GraphQL GraphQLConfig `yaml:"graphql"`
+
+	// Groups are expanded into Flows by LoadConfig; member flows are appended after
+	// the top-level ones.
+	Groups []FlowGroupConfig `yaml:"groups" validate:"omitempty,dive"`
+
+	// Defaults fill the settings every flow and upstream leaves unset.
+	Defaults RoutingDefaultsConfig `yaml:"defaults"`
+
+	// Middlewares wrap every flow, outside group and flow middlewares. They are
+	// applied in order, the first one outermost.
+	Middlewares []MiddlewareConfig `yaml:"middlewares" validate:"omitempty,dive"`
+
+	// resolved holds the values that came from ${...} references, which
+	// RoutingSnapshot redacts.
+	resolved map[string]struct{}
+}
+
+// RoutingDefaultsConfig is inherited by flows and upstreams after group expansion,
+// so a group policy takes precedence over it.
+type RoutingDefaultsConfig struct {
+	ParallelUpstreams int64 `yaml:"parallel_upstreams" validate:"min=0"`
+	// Aggregation applies to non-passthrough flows without their own.
+	Aggregation *AggregationConfig     `yaml:"aggregation"`
+	Upstream    UpstreamDefaultsConfig `yaml:"upstream"`
+	Plugin      PluginDefaultsConfig   `yaml:"plugin"`
+}
+
+type PluginDefaultsConfig struct {
+	OnError string        `yaml:"on_error" default:"abort" validate:"oneof=continue abort"`
+	Timeout time.Duration `yaml:"timeout"  validate:"min=0"`
+}
+
+type UpstreamDefaultsConfig struct {
+	Timeout        time.Duration `yaml:"timeout" validate:"min=0"`
+	ForwardHeaders []string      `yaml:"forward_headers"`
+	ForwardQueries []string      `yaml:"forward_queries"`
+	// Policy sections are inherited one by one, like group policies.
+	Policy PolicyConfig `yaml:"policy"`
+}
+
+// FlowGroupConfig shares a path prefix, middlewares, plugins and an upstream policy
+// between member flows. Group middlewares and plugins run before the flow's own;
+// policy sections left empty on an upstream are taken from the group.
+type FlowGroupConfig struct {
+	Prefix      string             `yaml:"prefix"      validate:"required,startswith=/"`
+	Middlewares []MiddlewareConfig `yaml:"middlewares" validate:"omitempty,dive"`
+	Plugins     []PluginConfig     `yaml:"plugins"     validate:"omitempty,dive"`
+	Policy      PolicyConfig       `yaml:"policy"`
+	// SNI and Listeners apply to member flows that do not set their own.
+	SNI       []string     `yaml:"sni"`
+	Listeners []string     `yaml:"listeners"`
+	Flows     []FlowConfig `yaml:"flows" validate:"omitempty,dive"`
+}
+
+// CompressionConfig compresses buffered client responses according to Accept-Encoding.
+// Encodings are listed in server preference order and default to [br, gzip];
+// ContentTypes accepts exact media types and "type/*" patterns and defaults to
+// application/json, application/xml and text/*. Passthrough flows are never compressed.
+type CompressionConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	Encodings    []string `yaml:"encodings"     validate:"omitempty,dive,oneof=br gzip"`
+	MinSize      int      `yaml:"min_size"      validate:"min=0" default:"1024"`
+	ContentTypes []string `yaml:"content_types"`
+}
+
+// TracePropagationConfig controls the trace context sent to upstreams, so their
+// traces link up with the client's across the gateway. W3C traceparent and B3
+// headers of the client request are read either way; a request without them gets
+// a new trace context. This holds with tracing disabled too, the upstreams then
+// seeing the client's span as their parent.
+type TracePropagationConfig struct {
+	// Disabled sends no trace headers to the upstreams.
+	Disabled bool `yaml:"disabled"`
+	// Formats are the headers written: "tracecontext" (traceparent, tracestate
+	// and baggage), "b3" (a single b3 header) and "b3multi" (X-B3-* headers).
+	// Empty writes tracecontext.
+	Formats []string `yaml:"formats" validate:"omitempty,dive,oneof=tracecontext b3 b3multi"`
 }
 
 type RateLimiterConfig struct {
 	Enabled bool                   `yaml:"enabled"`
 	Config  map[string]interface{} `yaml:"config" validate:"required"`
+	// Key lists the request attributes a limit bucket is keyed by; several parts
+	// form a composite key. Empty means the client IP.
+	Key []RateLimitKeyConfig `yaml:"key" validate:"omitempty,dive"`
+}
+
+// RateLimitKeyConfig is one part of a rate limit key: the client IP, a request
+// header (e.g. an API key), a query parameter, a claim of the authenticated
+// caller, or the country, region or city resolved by the geoip middleware. A part
+// whose value is absent falls back to the client IP. Claim and geo keys are
+// checked after flow middlewares so that those have already run.
+type RateLimitKeyConfig struct {
+	Source string `yaml:"source" validate:"required,oneof=ip header query claim geo"`
+	Name   string `yaml:"name"   validate:"required_if=Source header,required_if=Source query,required_if=Source claim,required_if=Source geo"`
+}
+
+// CacheConfig stores successful aggregated responses for TTL. Entries are keyed
+// by method, path, query and the values of the Vary request headers; Key replaces
+// the query with the listed parts, so that, for instance, responses are kept per
+// user or tenant and unrelated query params are ignored. The memory
+// backend keeps at most MaxEntries per instance; the redis backend shares entries
+// between instances. Bodies over MaxEntrySize bytes (when set) are not cached.
+// Requests sent with Cache-Control: no-cache bypass the cache.
+//
+// For StaleWhileRevalidate after an entry expires it is still served while a fresh
+// copy is fetched in the background; for StaleIfError it replaces a failed (5xx)
+// response. Both are off when zero.
+type CacheConfig struct {
+	TTL          time.Duration    `yaml:"ttl"            validate:"required,min=1ms"`
+	Backend      string           `yaml:"backend"        default:"memory" validate:"oneof=memory redis"`
+	MaxEntries   int              `yaml:"max_entries"    default:"1000" validate:"min=1"`
+	MaxEntrySize int              `yaml:"max_entry_size" validate:"min=0"`
+	Vary         []string         `yaml:"vary"`
+	Key          []CacheKeyConfig `yaml:"key"            validate:"omitempty,dive"`
+	// Tags label the flow's entries for purging through the admin API, next to
+	// those listed by the response's Cache-Tag header.
+	Tags []string `yaml:"tags"`
+
+	StaleWhileRevalidate time.Duration `yaml:"stale_while_revalidate" validate:"min=0"`
+	StaleIfError         time.Duration `yaml:"stale_if_error"         validate:"min=0"`
+
+	Redis *RedisCacheConfig `yaml:"redis" validate:"required_if=Backend redis"`
+}
+
+// ResponseHeadersConfig filters the upstream headers copied into an aggregated
+// response. With Allow, only the listed headers pass; Deny then removes headers.
+// A name ending in "*" matches a prefix ("X-Internal-*"). When several upstreams
+// set the same header, OnConflict keeps the values of the "last" (default) or
+// "first" successful upstream in configuration order, or "append"s them all.
+type ResponseHeadersConfig struct {
+	Allow      []string `yaml:"allow"       validate:"omitempty,dive,required"`
+	Deny       []string `yaml:"deny"        validate:"omitempty,dive,required"`
+	OnConflict string   `yaml:"on_conflict" validate:"omitempty,oneof=first last append"`
+}
+
+// CORSConfig is the cross-origin policy of a flow. Origins are exact
+// ("https://app.example.com"), a pattern with one "*" ("https://*.example.com"),
+// or "*" for any origin. Without allowed methods the preflight allows the
+// requested one; without allowed headers only CORS-safelisted headers pass, and
+// "*" allows any requested header. MaxAge is rounded down to whole seconds.
+type CORSConfig struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins"   validate:"required,min=1,dive,required"`
+	AllowedMethods   []string      `yaml:"allowed_methods"   validate:"omitempty,dive,required"`
+	AllowedHeaders   []string      `yaml:"allowed_headers"   validate:"omitempty,dive,required"`
+	ExposedHeaders   []string      `yaml:"exposed_headers"   validate:"omitempty,dive,required"`
+	AllowCredentials bool          `yaml:"allow_credentials"`
+	MaxAge           time.Duration `yaml:"max_age"           validate:"min=0"`
+}
+
+// CoalesceConfig decides which requests count as identical: those with the same
+// method, path, query, credential headers (Authorization, Cookie, X-Api-Key,
+// Api-Key and X-Auth-Token) and Vary headers.
+type CoalesceConfig struct {
+	Vary []string `yaml:"vary"`
+}
+
+// CacheKeyConfig is one part of a cache key: the request path, a query param, a
+// request header or a claim of the authenticated caller (e.g. "sub"). Requests
+// without the claim bypass the cache. The path is part of every key, listed or
+// not, so that requests to different params of a flow never share an entry.
+type CacheKeyConfig struct {
+	Source string `yaml:"source" validate:"required,oneof=path query header claim"`
+	Name   string `yaml:"name"   validate:"required_if=Source query,required_if=Source header,required_if=Source claim"`
+}
+
+// RedisCacheConfig points the redis cache backend at a server. Entries are stored
+// under KeyPrefix followed by the flow, encoded as JSON or MessagePack.
+type RedisCacheConfig struct {
+	Address       string        `yaml:"address"       validate:"required,hostname_port"`
+	Password      string        `yaml:"password"`
+	DB            int           `yaml:"db"            validate:"min=0"`
+	KeyPrefix     string        `yaml:"key_prefix"    default:"kono:cache:"`
+	Serialization string        `yaml:"serialization" default:"json" validate:"oneof=json msgpack"`
+	Timeout       time.Duration `yaml:"timeout"       default:"200ms" validate:"min=1ms"`
+}
+
+// QuotaConfig allows Limit requests per calendar Period (UTC) for each consumer,
+// identified by Key as for the rate limiter. With StatePath set, counters are saved
+// there every FlushInterval and on shutdown so they survive restarts. At most
+// MaxEntries consumers are counted per period; further consumers share a single
+// overflow counter with the same Limit until the period ends.
+type QuotaConfig struct {
+	Enabled       bool                 `yaml:"enabled"`
+	Limit         int                  `yaml:"limit"          validate:"required_if=Enabled true,omitempty,min=1"`
+	Period        string               `yaml:"period"         default:"day" validate:"oneof=day month"`
+	Key           []RateLimitKeyConfig `yaml:"key"            validate:"omitempty,dive"`
+	StatePath     string               `yaml:"state_path"`
+	FlushInterval time.Duration        `yaml:"flush_interval" default:"10s" validate:"min=1s"`
+	MaxEntries    int                  `yaml:"max_entries"    default:"100000" validate:"min=1"`
+}
+
+// LoadSheddingConfig rejects requests beyond MaxInFlight with 503 and a Retry-After
+// header. Zero MaxInFlight disables the cap. With QueueTimeout set, a request at
+// capacity waits up to that long for a slot first; MaxQueued bounds how many may
+// wait at once (zero means unbounded). Queueing applies to this gateway-wide cap
+// only: upstream max_concurrent and adaptive_concurrency limits never queue.
+type LoadSheddingConfig struct {
+	MaxInFlight  int           `yaml:"max_in_flight" validate:"min=0"`
+	RetryAfter   time.Duration `yaml:"retry_after"   default:"1s" validate:"min=1s"`
+	QueueTimeout time.Duration `yaml:"queue_timeout" validate:"min=0"`
+	MaxQueued    int           `yaml:"max_queued"    validate:"min=0"`
+}
+
+// GraphQLConfig maps top-level query fields onto flows. Field arguments fill the
+// flow path params of the same name; remaining arguments are sent as query params
+// (GET) or as a JSON body. The flow response data is then trimmed to the selection.
+//
+// Queries nested deeper than MaxDepth selection sets or selecting more than
+// MaxFields fields in all are rejected before any flow is called. Zero lifts the
+// limit.
+type GraphQLConfig struct {
+	Enabled   bool                 `yaml:"enabled"`
+	Path      string               `yaml:"path"       default:"/graphql" validate:"omitempty,startswith=/"`
+	Fields    []GraphQLFieldConfig `yaml:"fields"     validate:"required_if=Enabled true,omitempty,dive"`
+	MaxDepth  int                  `yaml:"max_depth"  default:"10"       validate:"min=0"`
+	MaxFields int                  `yaml:"max_fields" default:"100"      validate:"min=0"`
+}
+
+type GraphQLFieldConfig struct {
+	Name   string `yaml:"name"   validate:"required"`
+	Method string `yaml:"method" default:"GET" validate:"oneof=GET POST PUT PATCH DELETE"`
+	// Path is the request path of the target flow, e.g. "/users/{id}".
+	Path string `yaml:"path" validate:"required,startswith=/"`
 }
 
 type FlowConfig struct {
-	Path        string `yaml:"path"   validate:"required,startswith=/"`
+	// Path is a path template. Segments may capture params ("/users/{id}") and a trailing
+	// "/*" matches any remainder, available as the "*" param ("{*}" in upstream paths).
+	// Precedence: static segments win over params, params over the wildcard, and
+	// path_regex flows are tried last.
+	Path        string `yaml:"path"   validate:"required_without=PathRegex,omitempty,startswith=/"`
 	Method      string `yaml:"method" validate:"required,oneof=GET POST PUT PATCH DELETE HEAD OPTIONS"`
 	Passthrough bool   `yaml:"passthrough"`
 
+	// FlushInterval batches client flushes of a passthrough stream. Zero flushes after
+	// every read from the upstream, which suits small chunked or event responses.
+	FlushInterval time.Duration `yaml:"flush_interval" validate:"excluded_unless=Passthrough true,min=0"`
+
+	// Timeout is the overall deadline of a request to the flow. It replaces
+	// server.timeout as the connection read and write deadline, so long-running
+	// streams can outlive it and quick flows can fail early. Zero keeps server.timeout,
+	// except that passthrough flows then have no deadline.
+	Timeout time.Duration `yaml:"timeout" validate:"min=0"`
+
+	// Sequential calls upstreams one by one in the listed order. Upstream paths may
+	// then reference earlier responses as {upstream.<name>.<dotted.json.path>}.
+	Sequential bool `yaml:"sequential" validate:"excluded_with=Passthrough"`
+
+	// PathRegex matches the whole request path against a regular expression instead of
+	// a path template. Named capture groups are exposed like template path params.
+	// Regex flows are only consulted when no templated flow matches.
+	PathRegex string `yaml:"path_regex" validate:"excluded_with=Path"`
+
+	// Headers restricts the flow to requests whose headers satisfy every matcher.
+	// Flows sharing a method and path are tried in configuration order; the first
+	// one whose matchers all pass handles the request.
+	Headers []HeaderMatcherConfig `yaml:"headers" validate:"omitempty,dive"`
+
+	// SNI restricts the flow to TLS connections whose server name matches one of
+	// the hostnames; "*.example.com" matches a single leading label. Such flows
+	// never match plaintext requests.
+	SNI []string `yaml:"sni" validate:"omitempty,dive,required"`
+
+	// Listeners names the listeners serving the flow ("default" is the main one).
+	// Empty serves the flow on the main listener only.
+	Listeners []string `yaml:"listeners" validate:"omitempty,dive,required"`
+
 	// ParallelUpstreams defaults to 2×NumCPU when unset or zero.
 	ParallelUpstreams int64 `yaml:"parallel_upstreams"`
 
+	// Negotiation selects the response format from the client's Accept header.
+	Negotiation NegotiationConfig `yaml:"negotiation"`
+
+	// Compression overrides the routing-level compression settings for this flow.
+	Compression *CompressionConfig `yaml:"compression"`
+
+	// TracePropagation overrides the routing-level trace propagation settings for
+	// this flow.
+	TracePropagation *TracePropagationConfig `yaml:"trace_propagation"`
+
+	// Cache serves repeated GET and HEAD requests from memory.
+	Cache *CacheConfig `yaml:"cache" validate:"excluded_with=Passthrough"`
+
+	// Coalesce shares one upstream fan-out between identical concurrent GET and
+	// HEAD requests.
+	Coalesce *CoalesceConfig `yaml:"coalesce" validate:"excluded_with=Passthrough"`
+
+	// ResponseHeaders controls which upstream headers reach the client and how
+	// headers set by several upstreams are combined.
+	ResponseHeaders *ResponseHeadersConfig `yaml:"response_headers" validate:"excluded_with=Passthrough"`
+
+	// CORS lets browsers call the flow cross-origin. The gateway answers preflight
+	// OPTIONS requests itself instead of forwarding them to upstreams.
+	CORS *CORSConfig `yaml:"cors"`
+
 	Aggregation *AggregationConfig `yaml:"aggregation"  validate:"required_if=Passthrough false"`
 	Upstreams   []UpstreamConfig   `yaml:"upstreams"    validate:"required,min=1,dive,required"`
 	Plugins     []PluginConfig     `yaml:"plugins"      validate:"omitempty,dive"`
 	Middlewares []MiddlewareConfig `yaml:"middlewares"  validate:"omitempty,dive"`
 }
 
+// NegotiationConfig lists the response formats a flow may answer with.
+// Without formats only JSON is served. Default is used when the Accept header
+// is missing, a wildcard, or names no allowed format.
+type NegotiationConfig struct {
+	Formats []string `yaml:"formats" validate:"omitempty,dive,oneof=json xml msgpack protobuf"`
+	Default string   `yaml:"default" validate:"omitempty,oneof=json xml msgpack protobuf" default:"json"`
+
+	// Protobuf is required when "protobuf" is listed in formats.
+	Protobuf *ProtobufConfig `yaml:"protobuf"`
+}
+
+// ProtobufConfig maps the JSON client response (data, errors, meta) onto a message
+// from a compiled descriptor set, as produced by `protoc --descriptor_set_out`.
+// Fields unknown to the message are dropped.
+type ProtobufConfig struct {
+	DescriptorSet string `yaml:"descriptor_set" validate:"required"`
+	Message       string `yaml:"message"        validate:"required"`
+}
+
+// HeaderMatcherConfig matches a request header by exactly one of exact value, prefix or regex.
+type HeaderMatcherConfig struct {
+	Name   string `yaml:"name"   validate:"required"`
+	Exact  string `yaml:"exact"`
+	Prefix string `yaml:"prefix"`
+	Regex  string `yaml:"regex"`
+}
+
 type AggregationConfig struct {
 	BestEffort bool              `yaml:"best_effort"`
-	Strategy   string            `yaml:"strategy"    validate:"required,oneof=array merge namespace"`
+	Strategy   string            `yaml:"strategy"    validate:"required,oneof=array concat merge namespace first custom"`
 	OnConflict *OnConflictConfig `yaml:"on_conflict" validate:"required_if=Strategy merge"`
+	Custom     *AggregatorConfig `yaml:"custom"      validate:"required_if=Strategy custom"`
+}
+
+// AggregatorConfig selects the sdk.Aggregator used by the "custom" strategy.
+// Source "registry" looks the name up among aggregators added with RegisterAggregator,
+// source "file" loads <path>/<name>.so.
+type AggregatorConfig struct {
+	Name   string                 `yaml:"name"   validate:"required"`
+	Source string                 `yaml:"source" validate:"required,oneof=registry file"`
+	Path   string                 `yaml:"path"   validate:"required_if=Source file,omitempty"`
+	Config map[string]interface{} `yaml:"config"`
+	Verify VerifyConfig           `yaml:"verify"`
 }
 
 type OnConflictConfig struct {
@@ -104,18 +579,91 @@ type OnConflictConfig struct {
 }
 
 type UpstreamConfig struct {
-	Name    string        `yaml:"name" validate:"required"`
-	Hosts   AddrList      `yaml:"hosts" validate:"min=1,dive"`
-	Path    string        `yaml:"path"`
+	Name string `yaml:"name" validate:"required"`
+	// Type is "http" (default), "grpc" or "mock". gRPC upstreams call the unary
+	// method from the grpc block; the JSON request body (plus forward_params)
+	// becomes the input message and the reply is aggregated as JSON. Mock upstreams
+	// answer with the canned response of the mock block and contact no host.
+	Type    string        `yaml:"type" validate:"omitempty,oneof=http grpc mock"`
+	Hosts   AddrList      `yaml:"hosts" validate:"required_without_all=Discovery Groups Mock,excluded_with=Discovery Groups Mock,omitempty,dive"`
+	Path    string        `yaml:"path"` // may use {id}, {*}, {query.page} and {header.X-Tenant}
 	Method  string        `yaml:"method"`
 	Timeout time.Duration `yaml:"timeout" default:"3s"`
 
+	// MaxConcurrent caps the in-flight calls to the upstream. Calls beyond it fail
+	// right away with UPSTREAM_OVERLOADED instead of queueing. Zero is unlimited.
+	MaxConcurrent int `yaml:"max_concurrent" validate:"min=0"`
+	// AdaptiveConcurrency replaces the fixed MaxConcurrent with a limit discovered
+	// from the latency of the upstream.
+	AdaptiveConcurrency *AdaptiveConcurrencyConfig `yaml:"adaptive_concurrency" validate:"excluded_with=MaxConcurrent"`
+
+	// Discovery resolves the hosts at runtime instead of listing them in hosts.
+	Discovery *DiscoveryConfig `yaml:"discovery"`
+
+	// Groups split the traffic between host groups instead of listing hosts, e.g.
+	// 95% to a stable and 5% to a canary group. With SplitKey the request value it
+	// names is hashed onto a group, so a client keeps seeing the same variant.
+	Groups   []HostGroupConfig `yaml:"groups"    validate:"excluded_with=Discovery,omitempty,dive"`
+	SplitKey *HashKeyConfig    `yaml:"split_key" validate:"excluded_without=Groups"`
+
 	ForwardHeaders []string `yaml:"forward_headers"`
 	ForwardQueries []string `yaml:"forward_queries"`
 	ForwardParams  []string `yaml:"forward_params"`
 
-	Policy    PolicyConfig    `yaml:"policy"`
-	Transport TransportConfig `yaml:"transport"`
+	// ResponseFormat is the body format of the upstream, "json" (default) or "xml".
+	// XML is decoded to JSON before select and aggregation: the root element is
+	// unwrapped, repeated elements become arrays, attributes become "@name" keys
+	// and all leaf values are strings.
+	ResponseFormat string `yaml:"response_format" validate:"omitempty,oneof=json xml"`
+
+	// Select is a JMESPath expression applied to the JSON body before aggregation,
+	// e.g. "data.items[*].{id:id,name:name}".
+	Select string `yaml:"select"`
+
+	GRPC *GRPCUpstreamConfig `yaml:"grpc" validate:"required_if=Type grpc"`
+	Mock *MockUpstreamConfig `yaml:"mock" validate:"required_if=Type mock,excluded_unless=Type mock"`
+
+	Policy    PolicyConfig      `yaml:"policy"`
+	Transport TransportConfig   `yaml:"transport"`
+	TLS       UpstreamTLSConfig `yaml:"tls"`
+}
+
+// UpstreamTLSConfig configures TLS towards https (and grpc) upstream hosts.
+// CAFile replaces the system roots; CertFile and KeyFile present a client
+// certificate for mTLS; ServerName overrides the SNI and verified hostname.
+type UpstreamTLSConfig struct {
+	CAFile     string `yaml:"ca_file"`
+	CertFile   string `yaml:"cert_file"   validate:"required_with=KeyFile"`
+	KeyFile    string `yaml:"key_file"    validate:"required_with=CertFile"`
+	ServerName string `yaml:"server_name"`
+	// InsecureSkipVerify disables certificate verification. Meant for testing only;
+	// a warning is logged for every upstream that enables it.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+}
+
+// GRPCUpstreamConfig describes the unary method called by a grpc upstream.
+type GRPCUpstreamConfig struct {
+	DescriptorSet string `yaml:"descriptor_set" validate:"required"`
+	// Method is the fully qualified method name, e.g. "users.v1.UserService/GetUser".
+	Method   string `yaml:"method"   validate:"required"`
+	Insecure bool   `yaml:"insecure"`
+}
+
+// MockUpstreamConfig is the canned response of a mock upstream, meant for running
+// the gateway before the backends exist. Body is sent as JSON, except that a
+// string is sent verbatim; BodyFile reads the body from a file instead. Every call
+// waits Latency plus a random share of Jitter (bounded by the upstream timeout),
+// and ErrorRate of the calls (0 to 1) fail with ErrorStatus as a backend error
+// would.
+type MockUpstreamConfig struct {
+	Status      int               `yaml:"status"       default:"200" validate:"min=100,max=599"`
+	Headers     map[string]string `yaml:"headers"`
+	Body        any               `yaml:"body"         validate:"excluded_with=BodyFile"`
+	BodyFile    string            `yaml:"body_file"`
+	Latency     time.Duration     `yaml:"latency"      validate:"min=0"`
+	Jitter      time.Duration     `yaml:"jitter"       validate:"min=0"`
+	ErrorRate   float64           `yaml:"error_rate"   validate:"min=0,max=1"`
+	ErrorStatus int               `yaml:"error_status" default:"503" validate:"min=500,max=599"`
 }
 
 type TransportConfig struct {
@@ -124,29 +672,150 @@ type TransportConfig struct {
 	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout"      default:"90s"`
 }
 
+// PluginConfig selects a plugin of a flow. Source "builtin" loads
+// /usr/local/lib/kono/plugins/<name>.so, source "file" loads <path>/<name>.so,
+// source "grpc" calls an external processor, sources "lua" and "js" run a script
+// and source "registry" creates a plugin added with RegisterPlugin.
 type PluginConfig struct {
 	Name   string                 `yaml:"name"   validate:"required"`
-	Source string                 `yaml:"source" validate:"required,oneof=builtin file"`
+	Source string                 `yaml:"source" validate:"required,oneof=builtin file grpc js lua registry"`
 	Path   string                 `yaml:"path"   validate:"required_if=Source file"`
 	Config map[string]interface{} `yaml:"config"`
+	Verify VerifyConfig           `yaml:"verify"`
+
+	// Order sorts the flow's plugins: lower values run first, and plugins with the
+	// same order run in configuration order. A plugin may be listed several times
+	// with different configs.
+	Order int `yaml:"order"`
+
+	// OnError is "abort" to fail the request with a 500 when Execute errors, or
+	// "continue" to log the error and run the rest of the flow. Empty takes
+	// routing.defaults.plugin.on_error. An sdk.AbortError ends the flow either way.
+	OnError string `yaml:"on_error" validate:"omitempty,oneof=continue abort"`
+
+	// Timeout bounds one Execute call; an overrun fails like an error. Zero takes
+	// routing.defaults.plugin.timeout, which is unbounded by default.
+	Timeout time.Duration `yaml:"timeout" validate:"min=0"`
+
+	// GRPC runs the plugin in an external processor (source "grpc").
+	GRPC *ExtProcConfig `yaml:"grpc" validate:"required_if=Source grpc,omitempty"`
+	// Lua runs a Lua script in the gateway (source "lua").
+	Lua *ScriptConfig `yaml:"lua" validate:"required_if=Source lua,omitempty"`
+	// JS runs a JavaScript script in the gateway (source "js").
+	JS *ScriptConfig `yaml:"js" validate:"required_if=Source js,omitempty"`
 }
 
+// ScriptConfig runs a script in-process, in Lua 5.1 (source "lua") or in
+// ECMAScript 5.1 with most of ES6 (source "js"). The script defines
+// on_request(req) and/or on_response(resp), each run as a plugin of that phase.
+// They receive a table or object (method, path, query, headers and body of the
+// request; status, headers and body of the response) whose changes are applied,
+// so a rewritten request body is what the upstreams receive. Returning a value
+// with a status, and optionally headers and body, answers the client instead;
+// a status outside 100-599 fails the call. Request bodies over MaxBodySize bytes
+// are not passed to the script and are forwarded unchanged. Up to PoolSize
+// interpreters are kept ready (GOMAXPROCS by default). They are reused across
+// requests, so globals a call sets are seen by later calls: keep per-request
+// state in locals.
+//
+// With Source "file" (the default) Script is the path of the script; with
+// "inline" it is the script itself, for small transformations kept in the
+// configuration. Either way the script is loaded again when the configuration
+// reloads.
+//
+// A call running longer than Timeout is interrupted and fails as a plugin
+// timeout; on_error then decides whether the flow continues without the script
+// (fail-open) or fails (fail-closed). Zero leaves calls unbounded.
+type ScriptConfig struct {
+	Source      string        `yaml:"source"        default:"file" validate:"oneof=file inline"`
+	Script      string        `yaml:"script"        validate:"required"`
+	PoolSize    int           `yaml:"pool_size"     validate:"min=0"`
+	MaxBodySize int64         `yaml:"max_body_size" default:"1048576" validate:"min=0"`
+	Timeout     time.Duration `yaml:"timeout"       default:"1s" validate:"min=0"`
+
+	// Upstreams are called by the script with kono.call(name, opts) for auxiliary
+	// data such as an auth decision or an enrichment record. opts may set method,
+	// query (a string or table), headers and body on a copy of the client request;
+	// the upstream config decides what of it is forwarded, as for flow upstreams.
+	// The call returns a table with status, headers and body, or nil and the error
+	// kind; in JavaScript it returns an object and throws the error kind instead.
+	// Timeouts, retries, circuit breakers and upstream metrics apply.
+	Upstreams []UpstreamConfig `yaml:"upstreams" validate:"omitempty,dive"`
+}
+
+// ExtProcConfig calls an external gRPC service implementing
+// internal/extproc/extproc.proto for each configured phase. The service may
+// rewrite headers and body or answer the client directly.
+type ExtProcConfig struct {
+	Address  string `yaml:"address" validate:"required"`
+	Insecure bool   `yaml:"insecure"`
+	// Phases lists "request" and "response"; empty runs the request phase only.
+	Phases  []string      `yaml:"phases"  validate:"omitempty,dive,oneof=request response"`
+	Timeout time.Duration `yaml:"timeout" default:"200ms" validate:"gt=0"`
+	// SendBody includes the request or response body in the call. Bodies over
+	// MaxBodySize bytes are not sent and are forwarded unchanged.
+	SendBody    bool  `yaml:"send_body"`
+	MaxBodySize int64 `yaml:"max_body_size" default:"1048576" validate:"min=0"`
+	// FailOpen continues the flow unchanged when the processor fails or times
+	// out. Otherwise the request fails.
+	FailOpen bool `yaml:"fail_open"`
+}
+
+// MiddlewareConfig selects a middleware of a flow. Sources are those of
+// PluginConfig except "grpc"; "registry" looks the name up among middlewares
+// added with RegisterMiddleware.
 type MiddlewareConfig struct {
 	Name   string                 `yaml:"name"   validate:"required"`
-	Source string                 `yaml:"source" validate:"required,oneof=builtin file"`
+	Source string                 `yaml:"source" validate:"required,oneof=builtin file registry"`
 	Path   string                 `yaml:"path"   validate:"required_if=Source file,omitempty"`
 	Config map[string]interface{} `yaml:"config"`
+	Verify VerifyConfig           `yaml:"verify"`
+
+	// Override, on a flow or group middleware, replaces the routing middleware
+	// with the same name in its position instead of being ignored.
+	Override bool `yaml:"override"`
+
+	// When limits the middleware to matching requests; Unless skips it for
+	// matching requests. Skipped requests go straight to the next handler.
+	When   *RequestMatchConfig `yaml:"when"   validate:"omitempty"`
+	Unless *RequestMatchConfig `yaml:"unless" validate:"omitempty"`
+}
+
+// RequestMatchConfig selects requests by path, method and headers. Every field
+// that is set must match: the path any of PathPrefixes, the method any of
+// Methods, and every header matcher. A prefix matches whole path segments, so
+// "/public" selects "/public/docs" but not "/publicity", and is compared with
+// the path as sent, without decoding it.
+type RequestMatchConfig struct {
+	PathPrefixes []string              `yaml:"path_prefixes" validate:"omitempty,dive,startswith=/"`
+	Methods      []string              `yaml:"methods"       validate:"omitempty,dive,required"`
+	Headers      []HeaderMatcherConfig `yaml:"headers"       validate:"omitempty,dive"`
+}
+
+// VerifyConfig pins the shared object of a plugin, middleware or aggregator
+// loaded from a .so (sources "builtin" and "file"). The gateway refuses to open a
+// file that fails any of the configured checks, and opens a private copy of the
+// bytes it checked.
+type VerifyConfig struct {
+	// SHA256 is the hex-encoded digest the file must have.
+	SHA256 string `yaml:"sha256" validate:"omitempty,len=64,hexadecimal"`
+	// PublicKey is a base64 Ed25519 public key. <file>.sig must hold its
+	// signature of the file, raw or base64-encoded.
+	PublicKey string `yaml:"public_key" validate:"omitempty,base64"`
 }
 
 type PolicyConfig struct {
-	HeaderBlacklist     []string `yaml:"header_blacklist"`
+	// HeaderDeny lists request headers never forwarded to the upstream.
+	HeaderDeny          []string `yaml:"header_deny"`
 	AllowedStatuses     []int    `yaml:"allowed_statuses"`
 	RequireBody         bool     `yaml:"require_body"`
 	MaxResponseBodySize int64    `yaml:"max_response_body_size"`
 
-	RetryConfig          RetryConfig          `yaml:"retry"`
-	CircuitBreakerConfig CircuitBreakerConfig `yaml:"circuit_breaker"`
-	LoadBalancingConfig  LoadBalancingConfig  `yaml:"load_balancing"`
+	RetryConfig          RetryConfig            `yaml:"retry"`
+	CircuitBreakerConfig CircuitBreakerConfig   `yaml:"circuit_breaker"`
+	LoadBalancingConfig  LoadBalancingConfig    `yaml:"load_balancing"`
+	OutlierDetection     OutlierDetectionConfig `yaml:"outlier_detection"`
+	Hedging              HedgingConfig          `yaml:"hedging"`
 }
 
 type RetryConfig struct {
@@ -155,14 +824,97 @@ type RetryConfig struct {
 	BackoffDelay    time.Duration `yaml:"backoff_delay"`
 }
 
+// HedgingConfig sends a second request, to the next host picked by the balancer,
+// when the first one has not answered within Delay, and uses whichever succeeds
+// first. The other request is cancelled. Only idempotent methods are hedged; a zero
+// Delay disables hedging.
+type HedgingConfig struct {
+	Delay time.Duration `yaml:"delay" validate:"min=0"`
+}
+
+// CircuitBreakerConfig opens the breaker after MaxFailures consecutive failures
+// or, when Window is set, once more than ErrorRate (0..1) of at least MinRequests
+// calls within the last Window failed. After ResetTimeout HalfOpenTrials calls (1
+// by default) are let through; the breaker closes when all of them succeed.
 type CircuitBreakerConfig struct {
-	Enabled      bool          `yaml:"enabled"`
-	MaxFailures  int           `yaml:"max_failures"`
-	ResetTimeout time.Duration `yaml:"reset_timeout"`
+	Enabled        bool          `yaml:"enabled"`
+	MaxFailures    int           `yaml:"max_failures"`
+	ResetTimeout   time.Duration `yaml:"reset_timeout"`
+	Window         time.Duration `yaml:"window"           validate:"min=0"`
+	ErrorRate      float64       `yaml:"error_rate"       validate:"required_with=Window,omitempty,gt=0,lte=1"`
+	MinRequests    int           `yaml:"min_requests"     validate:"min=0"`
+	HalfOpenTrials int           `yaml:"half_open_trials" validate:"min=0"`
 }
 
+// LoadBalancingConfig spreads requests across the upstream hosts. Mode defaults to
+// round_robin; consistent_hash sends requests with the same HashKey to the same
+// host and falls back to round_robin for requests without one. A host that fails
+// with a connection error, timeout or 5xx status is skipped for FailureCooldown
+// (10s by default); when every host is cooling down, the selected one is tried
+// anyway.
 type LoadBalancingConfig struct {
-	Mode string `yaml:"mode"`
+	Mode            string         `yaml:"mode"             validate:"omitempty,oneof=round_robin least_conns consistent_hash"`
+	HashKey         *HashKeyConfig `yaml:"hash_key"         validate:"required_if=Mode consistent_hash"`
+	FailureCooldown time.Duration  `yaml:"failure_cooldown" default:"10s"`
+}
+
+// AdaptiveConcurrencyConfig tunes the AIMD concurrency limiter. The limit starts at
+// InitialLimit and stays within [MinLimit, MaxLimit]; it is multiplied by Backoff
+// when a call takes more than LatencyTolerance times the lowest recent latency or
+// the upstream answers with a timeout, 429 or 503.
+type AdaptiveConcurrencyConfig struct {
+	InitialLimit     int     `yaml:"initial_limit"     default:"20"  validate:"min=1"`
+	MinLimit         int     `yaml:"min_limit"         default:"1"   validate:"min=1"`
+	MaxLimit         int     `yaml:"max_limit"         default:"200" validate:"gtefield=InitialLimit"`
+	LatencyTolerance float64 `yaml:"latency_tolerance" default:"2"   validate:"gt=1"`
+	Backoff          float64 `yaml:"backoff"           default:"0.9" validate:"gt=0,lt=1"`
+}
+
+// HostGroupConfig is a named set of hosts receiving Weight percent of the traffic
+// of its upstream.
+type HostGroupConfig struct {
+	Name   string   `yaml:"name"   validate:"required"`
+	Weight int      `yaml:"weight" validate:"min=0,max=100"`
+	Hosts  AddrList `yaml:"hosts"  validate:"min=1"`
+}
+
+// DiscoveryConfig keeps the hosts of an upstream current. The dns type resolves
+// Name every RefreshInterval: A/AAAA records are combined with Port, SRV records
+// carry their own target and port. The kubernetes type watches the EndpointSlices
+// of Service in Namespace (the pod's own by default) using the in-cluster service
+// account and uses the ready endpoints on the port named PortName, the only port,
+// or Port. Discovered hosts use Scheme (http by default).
+type DiscoveryConfig struct {
+	Type            string        `yaml:"type"             validate:"required,oneof=dns kubernetes"`
+	Name            string        `yaml:"name"             validate:"required_if=Type dns"`
+	Record          string        `yaml:"record"           default:"a" validate:"oneof=a srv"`
+	Service         string        `yaml:"service"          validate:"required_if=Type kubernetes"`
+	Namespace       string        `yaml:"namespace"`
+	PortName        string        `yaml:"port_name"`
+	Port            int           `yaml:"port"             validate:"required_if=Type dns Record a,omitempty,min=1,max=65535"`
+	Scheme          string        `yaml:"scheme"           default:"http" validate:"oneof=http https"`
+	RefreshInterval time.Duration `yaml:"refresh_interval" default:"30s"`
+}
+
+// OutlierDetectionConfig ejects a host for EjectionDuration once, within an
+// Interval of live traffic with at least MinRequests calls, its error rate exceeds
+// MaxErrorRate or its mean latency exceeds MaxLatency. A zero threshold is not
+// checked. At most MaxEjectedPercent of the hosts are ejected at the same time.
+type OutlierDetectionConfig struct {
+	Enabled           bool          `yaml:"enabled"`
+	Interval          time.Duration `yaml:"interval"            default:"10s"`
+	MinRequests       int           `yaml:"min_requests"        default:"10"`
+	MaxErrorRate      float64       `yaml:"max_error_rate"      validate:"gte=0,lte=1"`
+	MaxLatency        time.Duration `yaml:"max_latency"`
+	EjectionDuration  time.Duration `yaml:"ejection_duration"   default:"30s"`
+	MaxEjectedPercent int           `yaml:"max_ejected_percent" default:"50" validate:"gte=0,lte=100"`
+}
+
+// HashKeyConfig selects the request value hashed by consistent_hash balancing:
+// the client IP, or the header or cookie called Name.
+type HashKeyConfig struct {
+	Source string `yaml:"source" validate:"required,oneof=ip header cookie"`
+	Name   string `yaml:"name"   validate:"required_unless=Source ip"`
 }
 
 type AddrList []string
@@ -194,20 +946,66 @@ func (a *AddrList) UnmarshalYAML(value *yaml.Node) error {
 	}
 }
 
-// LoadConfig reads, parses, applies defaults, validates, and returns the config.
+// LoadConfig reads the file or conf.d-style directory at path with its includes,
+// interpolates environment and file references, applies KONO_* overrides and
+// defaults, validates, and returns the config.
 func LoadConfig(path string) (Config, error) {
-	data, err := os.ReadFile(path)
+	data, err := readConfigFiles(path)
 	if err != nil {
 		return Config{}, fmt.Errorf("cannot read configuration file: %w", err)
 	}
 
-	var cfg Config
+	return ParseConfig(data)
+}
+
+// ParseConfig interpolates, decodes, defaults and validates a configuration
+// document.
+func ParseConfig(data []byte) (Config, error) {
+	var (
+		doc yaml.Node
+		cfg Config
+	)
+
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return Config{}, fmt.Errorf("cannot parse configuration file: %w", err)
+	}
+
+	if err := rejectIncludes(&doc); err != nil {
+		return Config{}, err
+	}
+
+	resolveCtx, cancel := context.WithTimeout(context.Background(), secretResolveTimeout)
+	defer cancel()
+
+	in := &interpolator{ctx: resolveCtx, secrets: make(secretDigests), resolved: make(map[string]struct{})}
+	if err := in.node(&doc); err != nil {
+		return Config{}, fmt.Errorf("cannot interpolate configuration: %w", err)
+	}
+
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+
+	if _, err := migrateNode(&doc); err != nil {
+		return Config{}, fmt.Errorf("cannot migrate configuration: %w", err)
+	}
+
+	if err := applyEnvOverrides(&doc, os.Environ()); err != nil {
+		return Config{}, fmt.Errorf("cannot apply environment overrides: %w", err)
+	}
 
-	if err = yaml.Unmarshal(data, &cfg); err != nil {
+	if err := doc.Decode(&cfg); err != nil {
 		return Config{}, fmt.Errorf("cannot parse configuration file: %w", err)
 	}
 
-	if err = defaults.Set(&cfg); err != nil {
+	cfg.secrets = in.secrets
+	cfg.Gateway.Routing.resolved = in.resolved
+
+	expandFlowGroups(&cfg.Gateway.Routing)
+	applyGlobalMiddlewares(&cfg.Gateway.Routing)
+	applyRoutingDefaults(&cfg.Gateway.Routing)
+
+	if err := defaults.Set(&cfg); err != nil {
 		return Config{}, fmt.Errorf("cannot apply configuration defaults: %w", err)
 	}
 
@@ -215,17 +1013,208 @@ func LoadConfig(path string) (Config, error) {
 
 	v := newValidator()
 
-	if err = v.Struct(&cfg); err != nil {
+	if err := v.Struct(&cfg); err != nil {
 		return Config{}, fmt.Errorf("invalid configuration:\n%w", formatValidationError(err))
 	}
 
-	if err = validatePathParams(cfg); err != nil {
+	if err := validatePathParams(cfg); err != nil {
 		return Config{}, fmt.Errorf("invalid path params configuration: %w", err)
 	}
 
+	if err := validateListeners(cfg.Gateway); err != nil {
+		return Config{}, fmt.Errorf("invalid listeners configuration: %w", err)
+	}
+
+	if err := validateAdmin(cfg.Gateway.Server.Admin); err != nil {
+		return Config{}, fmt.Errorf("invalid admin configuration: %w", err)
+	}
+
+	if err := validateVerify(cfg.Gateway.Routing); err != nil {
+		return Config{}, fmt.Errorf("invalid verify configuration: %w", err)
+	}
+
 	return cfg, nil
 }
 
+// expandFlowGroups flattens routing groups into regular flows so that the rest of
+// the pipeline (validation, compilation, viz) only ever deals with flows.
+func expandFlowGroups(routing *RoutingConfig) {
+	for gi := range routing.Groups {
+		g := &routing.Groups[gi]
+		prefix := strings.TrimSuffix(g.Prefix, "/")
+
+		for _, f := range g.Flows {
+			if f.PathRegex != "" {
+				f.PathRegex = regexp.QuoteMeta(prefix) + f.PathRegex
+			} else {
+				f.Path = prefix + f.Path
+			}
+
+			if len(f.SNI) == 0 {
+				f.SNI = g.SNI
+			}
+
+			if len(f.Listeners) == 0 {
+				f.Listeners = g.Listeners
+			}
+
+			f.Middlewares = append(slices.Clone(g.Middlewares), f.Middlewares...)
+			f.Plugins = append(slices.Clone(g.Plugins), f.Plugins...)
+
+			f.Upstreams = slices.Clone(f.Upstreams)
+			for ui := range f.Upstreams {
+				inheritPolicy(&f.Upstreams[ui].Policy, g.Policy)
+			}
+
+			routing.Flows = append(routing.Flows, f)
+		}
+
+		g.Flows = nil
+	}
+}
+
+// applyGlobalMiddlewares puts routing.middlewares in front of the middlewares of
+// every flow, so they wrap the flow's own. A flow middleware with override set
+// takes the place of the global middleware with the same name; otherwise the
+// first middleware of a name wins, as for group middlewares.
+func applyGlobalMiddlewares(routing *RoutingConfig) {
+	if len(routing.Middlewares) == 0 {
+		return
+	}
+
+	for fi := range routing.Flows {
+		f := &routing.Flows[fi]
+
+		merged := slices.Clone(routing.Middlewares)
+		own := make([]MiddlewareConfig, 0, len(f.Middlewares))
+
+		for _, mw := range f.Middlewares {
+			i := slices.IndexFunc(merged, func(g MiddlewareConfig) bool { return g.Name == mw.Name })
+			if mw.Override && i >= 0 {
+				merged[i] = mw
+				continue
+			}
+
+			own = append(own, mw)
+		}
+
+		f.Middlewares = append(merged, own...)
+	}
+}
+
+// applyRoutingDefaults copies routing.defaults into the flows and upstreams,
+// script upstreams included, that leave a setting unset. It runs before the static defaults, so an unset upstream
+// timeout still falls back to 3s when the section has none.
+func applyRoutingDefaults(routing *RoutingConfig) {
+	d := routing.Defaults
+
+	for fi := range routing.Flows {
+		f := &routing.Flows[fi]
+
+		if f.ParallelUpstreams == 0 {
+			f.ParallelUpstreams = d.ParallelUpstreams
+		}
+
+		if f.Aggregation == nil && d.Aggregation != nil && !f.Passthrough {
+			agg := *d.Aggregation
+			f.Aggregation = &agg
+		}
+
+		f.Plugins = slices.Clone(f.Plugins)
+		for pi := range f.Plugins {
+			if f.Plugins[pi].OnError == "" {
+				f.Plugins[pi].OnError = d.Plugin.OnError
+			}
+
+			if f.Plugins[pi].Timeout == 0 {
+				f.Plugins[pi].Timeout = d.Plugin.Timeout
+			}
+
+			// Script upstreams are called like flow upstreams, so they take the
+			// same defaults. The scripts are copied as groups share them.
+			f.Plugins[pi].Lua = scriptWithDefaults(f.Plugins[pi].Lua, d.Upstream)
+			f.Plugins[pi].JS = scriptWithDefaults(f.Plugins[pi].JS, d.Upstream)
+		}
+
+		f.Upstreams = slices.Clone(f.Upstreams)
+		for ui := range f.Upstreams {
+			applyUpstreamDefaults(&f.Upstreams[ui], d.Upstream)
+		}
+	}
+}
+
+func scriptWithDefaults(script *ScriptConfig, d UpstreamDefaultsConfig) *ScriptConfig {
+	if script == nil {
+		return nil
+	}
+
+	sc := *script
+	sc.Upstreams = slices.Clone(sc.Upstreams)
+
+	for ui := range sc.Upstreams {
+		applyUpstreamDefaults(&sc.Upstreams[ui], d)
+	}
+
+	return &sc
+}
+
+func applyUpstreamDefaults(u *UpstreamConfig, d UpstreamDefaultsConfig) {
+	if u.Timeout == 0 {
+		u.Timeout = d.Timeout
+	}
+
+	if u.ForwardHeaders == nil {
+		u.ForwardHeaders = d.ForwardHeaders
+	}
+
+	if u.ForwardQueries == nil {
+		u.ForwardQueries = d.ForwardQueries
+	}
+
+	inheritPolicy(&u.Policy, d.Policy)
+}
+
+// inheritPolicy fills every unset section of dst from src.
+// Booleans cannot be told apart from an explicit false, so require_body is
+// inherited whenever the upstream leaves it off.
+func inheritPolicy(dst *PolicyConfig, src PolicyConfig) {
+	if dst.HeaderDeny == nil {
+		dst.HeaderDeny = src.HeaderDeny
+	}
+
+	if dst.AllowedStatuses == nil {
+		dst.AllowedStatuses = src.AllowedStatuses
+	}
+
+	if !dst.RequireBody {
+		dst.RequireBody = src.RequireBody
+	}
+
+	if dst.MaxResponseBodySize == 0 {
+		dst.MaxResponseBodySize = src.MaxResponseBodySize
+	}
+
+	if reflect.ValueOf(dst.RetryConfig).IsZero() {
+		dst.RetryConfig = src.RetryConfig
+	}
+
+	if reflect.ValueOf(dst.CircuitBreakerConfig).IsZero() {
+		dst.CircuitBreakerConfig = src.CircuitBreakerConfig
+	}
+
+	if reflect.ValueOf(dst.LoadBalancingConfig).IsZero() {
+		dst.LoadBalancingConfig = src.LoadBalancingConfig
+	}
+
+	if !dst.OutlierDetection.Enabled {
+		dst.OutlierDetection = src.OutlierDetection
+	}
+
+	if dst.Hedging.Delay == 0 {
+		dst.Hedging = src.Hedging
+	}
+}
+
 // applyDynamicDefaults sets defaults that cannot be expressed as static tag values
 // because they depend on runtime state (e.g. NumCPU).
 func applyDynamicDefaults(cfg *Config) {
@@ -256,21 +1245,183 @@ var pathParamPattern = regexp.MustCompile(`\{([^}]+)\}`)
 
 func validatePathParams(cfg Config) error {
 	for _, f := range cfg.Gateway.Routing.Flows {
-		flowParams := extractPathParams(f.Path)
+		if err := validateFlowPathTemplate(f.Path); err != nil {
+			return err
+		}
+
+		flowParams, err := flowPathParams(f)
+		if err != nil {
+			return err
+		}
 
-		for _, u := range f.Upstreams {
-			if err := validateUpstreamParams(u, flowParams, f.Path); err != nil {
+		for i, u := range f.Upstreams {
+			if err = validateUpstreamParams(u, flowParams, f.RoutePattern()); err != nil {
 				return err
 			}
+
+			if err = validateUpstreamRefs(u, f, f.Upstreams[:i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateListeners checks that listener names are unique and that flows only
+// reference declared listeners.
+func validateListeners(cfg GatewayConfig) error {
+	declared := map[string]struct{}{DefaultListener: {}}
+
+	for _, l := range cfg.Server.Listeners {
+		if _, dup := declared[l.Name]; dup {
+			return fmt.Errorf("listener %q is declared more than once", l.Name)
+		}
+
+		declared[l.Name] = struct{}{}
+	}
+
+	for _, f := range cfg.Routing.Flows {
+		for _, name := range f.Listeners {
+			if _, ok := declared[name]; !ok {
+				return fmt.Errorf("flow %s %s references unknown listener %q", f.Method, f.RoutePattern(), name)
+			}
 		}
 	}
 
 	return nil
 }
 
+// validateAdmin refuses an admin API without a token on an address that is
+// reachable from other hosts.
+func validateAdmin(cfg AdminConfig) error {
+	if !cfg.Enabled || cfg.Token != "" {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(cfg.Address)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return nil
+	}
+
+	return fmt.Errorf("a token is required to serve the admin API on %s", cfg.Address)
+}
+
+// validateVerify refuses verify on components that are not loaded from a shared
+// object, where it would be ignored.
+func validateVerify(routing RoutingConfig) error {
+	check := func(kind, name, source string, verify VerifyConfig) error {
+		if verify == (VerifyConfig{}) || source == sourceBuiltin || source == sourceFile {
+			return nil
+		}
+
+		return fmt.Errorf("%s %q: verify only applies to shared objects, not source %q", kind, name, source)
+	}
+
+	for _, f := range routing.Flows {
+		for _, p := range f.Plugins {
+			if err := check("plugin", p.Name, p.Source, p.Verify); err != nil {
+				return err
+			}
+		}
+
+		for _, mw := range f.Middlewares {
+			if err := check("middleware", mw.Name, mw.Source, mw.Verify); err != nil {
+				return err
+			}
+		}
+
+		if f.Aggregation != nil && f.Aggregation.Custom != nil {
+			c := f.Aggregation.Custom
+			if err := check("aggregator", c.Name, c.Source, c.Verify); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateFlowPathTemplate rejects flow paths that the router would otherwise refuse
+// to register at startup: duplicated params and a wildcard that is not the last segment.
+func validateFlowPathTemplate(path string) error {
+	if i := strings.Index(path, wildcardSegment); i >= 0 && i != len(path)-len(wildcardSegment) {
+		return fmt.Errorf("flow %q: wildcard '/*' is only allowed as the last path segment", path)
+	}
+
+	seen := make(map[string]struct{})
+
+	for _, match := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+		if _, dup := seen[match[1]]; dup {
+			return fmt.Errorf("flow %q: duplicate path param '{%s}'", path, match[1])
+		}
+
+		seen[match[1]] = struct{}{}
+	}
+
+	return nil
+}
+
+// flowPathParams returns the param names a flow captures: template params for
+// path flows and named capture groups for regex flows.
+func flowPathParams(f FlowConfig) (map[string]struct{}, error) {
+	if f.PathRegex == "" {
+		return extractPathParams(f.Path), nil
+	}
+
+	re, err := compileFlowRegex(f.PathRegex)
+	if err != nil {
+		return nil, fmt.Errorf("flow %q: invalid path_regex: %w", f.PathRegex, err)
+	}
+
+	params := make(map[string]struct{})
+
+	for _, name := range re.SubexpNames() {
+		if name != "" {
+			params[name] = struct{}{}
+		}
+	}
+
+	return params, nil
+}
+
+// compileFlowRegex anchors expr so that it always has to match the full request path.
+func compileFlowRegex(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile(`^(?:` + expr + `)$`)
+}
+
+// RoutePattern returns the path template or, for regex flows, the raw expression.
+// It identifies the flow in logs, metrics and error messages.
+func (f FlowConfig) RoutePattern() string {
+	if f.PathRegex != "" {
+		return f.PathRegex
+	}
+
+	return f.Path
+}
+
+// OrderedPlugins returns the flow's plugins in execution order.
+func (f FlowConfig) OrderedPlugins() []PluginConfig {
+	plugins := slices.Clone(f.Plugins)
+	slices.SortStableFunc(plugins, func(a, b PluginConfig) int { return cmp.Compare(a.Order, b.Order) })
+
+	return plugins
+}
+
+// wildcardSegment marks a prefix flow; the matched remainder is exposed as the "*" param.
+const wildcardSegment = "/*"
+
 func extractPathParams(path string) map[string]struct{} {
 	params := make(map[string]struct{})
 
+	if strings.HasSuffix(path, wildcardSegment) {
+		params["*"] = struct{}{}
+	}
+
 	for _, match := range pathParamPattern.FindAllStringSubmatch(path, -1) {
 		params[match[1]] = struct{}{}
 	}
@@ -280,6 +1431,14 @@ func extractPathParams(path string) map[string]struct{} {
 
 func validateUpstreamParams(u UpstreamConfig, flowParams map[string]struct{}, flowPath string) error {
 	for _, match := range pathParamPattern.FindAllStringSubmatch(u.Path, -1) {
+		if strings.HasPrefix(match[1], upstreamRefPrefix) {
+			continue // validated by validateUpstreamRefs.
+		}
+
+		if strings.HasPrefix(match[1], queryParamPrefix) || strings.HasPrefix(match[1], headerParamPrefix) {
+			continue // taken from the client request, not the flow path.
+		}
+
 		if _, ok := flowParams[match[1]]; !ok {
 			return fmt.Errorf(
 				"upstream %q: path param '{%s}' not declared in flow path %q",
@@ -304,6 +1463,28 @@ func validateUpstreamParams(u UpstreamConfig, flowParams map[string]struct{}, fl
 	return nil
 }
 
+// validateUpstreamRefs ensures {upstream.<name>...} templates are only used in
+// sequential flows and point at an upstream that runs earlier.
+func validateUpstreamRefs(u UpstreamConfig, f FlowConfig, previous []UpstreamConfig) error {
+	for _, match := range pathParamPattern.FindAllStringSubmatch(u.Path, -1) {
+		ref, ok := strings.CutPrefix(match[1], upstreamRefPrefix)
+		if !ok {
+			continue
+		}
+
+		if !f.Sequential {
+			return fmt.Errorf("upstream %q: '{%s}' requires a sequential flow", u.Name, match[1])
+		}
+
+		name, _, _ := strings.Cut(ref, ".")
+		if !slices.ContainsFunc(previous, func(p UpstreamConfig) bool { return p.Name == name }) {
+			return fmt.Errorf("upstream %q: '{%s}' must reference an earlier upstream", u.Name, match[1])
+		}
+	}
+
+	return nil
+}
+
 func formatValidationError(err error) error {
 	var ves validator.ValidationErrors
 	if !errors.As(err, &ves) {
@@ -330,6 +1511,16 @@ func validationMessage(fe validator.FieldError) string {
 		return fmt.Sprintf("must be one of [%s]", fe.Param())
 	case "hosts":
 		return "must be a valid URL"
+	case "required_without":
+		return fmt.Sprintf("field is required when %s is not set", strings.ToLower(fe.Param()))
+	case "required_without_all":
+		return fmt.Sprintf("field is required when none of %s is set", strings.ToLower(fe.Param()))
+	case "excluded_with":
+		return fmt.Sprintf("cannot be used together with %s", strings.ToLower(fe.Param()))
+	case "excluded_without":
+		return fmt.Sprintf("is only allowed together with %s", strings.ToLower(fe.Param()))
+	case "excluded_unless":
+		return fmt.Sprintf("is only allowed when %s", strings.ToLower(fe.Param()))
 	case "required_if":
 		if fe.Field() == "path" {
 			return "is required when source is 'file'"
@@ -0,0 +1,433 @@
+package kono
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
+)
+
+// pinnedObject is a verify setting for the validateVerify entries.
+var pinnedObject = VerifyConfig{SHA256: strings.Repeat("0", 64)}
+
+var _ = Describe("config", func() {
+	Describe("validatePathParams", func() {
+		newConfig := func(flow FlowConfig) Config {
+			return Config{Gateway: GatewayConfig{Routing: RoutingConfig{Flows: []FlowConfig{flow}}}}
+		}
+
+		It("accepts upstream params declared in the flow path", func() {
+			cfg := newConfig(FlowConfig{
+				Path:      "/users/{id}/orders/{orderID}",
+				Upstreams: []UpstreamConfig{{Name: "orders", Path: "/orders/{orderID}", ForwardParams: []string{"id"}}},
+			})
+
+			Expect(validatePathParams(cfg)).To(Succeed())
+		})
+
+		It("rejects duplicate params in the flow path", func() {
+			cfg := newConfig(FlowConfig{Path: "/users/{id}/friends/{id}"})
+
+			Expect(validatePathParams(cfg)).To(MatchError(ContainSubstring("duplicate path param")))
+		})
+
+		It("accepts upstream params declared as named regex groups", func() {
+			cfg := newConfig(FlowConfig{
+				PathRegex: `/users/(?P<id>\d+)`,
+				Upstreams: []UpstreamConfig{{Name: "users", Path: "/users/{id}"}},
+			})
+
+			Expect(validatePathParams(cfg)).To(Succeed())
+		})
+
+		It("rejects an invalid path_regex", func() {
+			cfg := newConfig(FlowConfig{PathRegex: `/users/(`})
+
+			Expect(validatePathParams(cfg)).To(MatchError(ContainSubstring("invalid path_regex")))
+		})
+
+		It("exposes the wildcard remainder to upstream paths", func() {
+			cfg := newConfig(FlowConfig{
+				Path:      "/api/v1/*",
+				Upstreams: []UpstreamConfig{{Name: "legacy", Path: "/v1/{*}"}},
+			})
+
+			Expect(validatePathParams(cfg)).To(Succeed())
+		})
+
+		It("rejects a wildcard that is not the last segment", func() {
+			cfg := newConfig(FlowConfig{Path: "/api/*/users"})
+
+			Expect(validatePathParams(cfg)).To(MatchError(ContainSubstring("only allowed as the last path segment")))
+		})
+
+		It("accepts references to earlier upstreams in sequential flows", func() {
+			cfg := newConfig(FlowConfig{
+				Path:       "/profile",
+				Sequential: true,
+				Upstreams: []UpstreamConfig{
+					{Name: "users"},
+					{Name: "orders", Path: "/orders?user={upstream.users.id}"},
+				},
+			})
+
+			Expect(validatePathParams(cfg)).To(Succeed())
+		})
+
+		It("rejects upstream references outside sequential flows", func() {
+			cfg := newConfig(FlowConfig{
+				Path:      "/profile",
+				Upstreams: []UpstreamConfig{{Name: "users"}, {Name: "orders", Path: "/orders/{upstream.users.id}"}},
+			})
+
+			Expect(validatePathParams(cfg)).To(MatchError(ContainSubstring("requires a sequential flow")))
+		})
+
+		It("rejects references to later upstreams", func() {
+			cfg := newConfig(FlowConfig{
+				Path:       "/profile",
+				Sequential: true,
+				Upstreams:  []UpstreamConfig{{Name: "orders", Path: "/orders/{upstream.users.id}"}, {Name: "users"}},
+			})
+
+			Expect(validatePathParams(cfg)).To(MatchError(ContainSubstring("earlier upstream")))
+		})
+
+		It("accepts query and header references", func() {
+			cfg := newConfig(FlowConfig{
+				Path:      "/users",
+				Upstreams: []UpstreamConfig{{Name: "users", Path: "/v2/{header.X-Tenant}/users?page={query.page}"}},
+			})
+
+			Expect(validatePathParams(cfg)).To(Succeed())
+		})
+
+		It("rejects upstream params missing from the flow path", func() {
+			cfg := newConfig(FlowConfig{
+				Path:      "/users/{id}",
+				Upstreams: []UpstreamConfig{{Name: "orders", Path: "/orders/{orderID}"}},
+			})
+
+			Expect(validatePathParams(cfg)).To(MatchError(ContainSubstring("not declared in flow path")))
+		})
+	})
+
+	DescribeTable("validateAdmin",
+		func(cfg AdminConfig, valid bool) {
+			if valid {
+				Expect(validateAdmin(cfg)).To(Succeed())
+			} else {
+				Expect(validateAdmin(cfg)).To(MatchError(ContainSubstring("a token is required")))
+			}
+		},
+		Entry("disabled", AdminConfig{Address: ":9901"}, true),
+		Entry("loopback without token", AdminConfig{Enabled: true, Address: "127.0.0.1:9901"}, true),
+		Entry("localhost without token", AdminConfig{Enabled: true, Address: "localhost:9901"}, true),
+		Entry("ipv6 loopback without token", AdminConfig{Enabled: true, Address: "[::1]:9901"}, true),
+		Entry("all interfaces without token", AdminConfig{Enabled: true, Address: ":9901"}, false),
+		Entry("public address without token", AdminConfig{Enabled: true, Address: "10.0.0.1:9901"}, false),
+		Entry("public address with token", AdminConfig{Enabled: true, Address: ":9901", Token: "secret"}, true),
+	)
+
+	DescribeTable("validateVerify",
+		func(flow FlowConfig, valid bool) {
+			err := validateVerify(RoutingConfig{Flows: []FlowConfig{flow}})
+			if valid {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(ContainSubstring("verify only applies to shared objects")))
+			}
+		},
+		Entry("file plugin", FlowConfig{Plugins: []PluginConfig{{Name: "p", Source: sourceFile, Verify: pinnedObject}}}, true),
+		Entry("builtin middleware", FlowConfig{Middlewares: []MiddlewareConfig{{Name: "m", Source: sourceBuiltin, Verify: pinnedObject}}}, true),
+		Entry("registry plugin without verify", FlowConfig{Plugins: []PluginConfig{{Name: "p", Source: sourceRegistry}}}, true),
+		Entry("lua plugin", FlowConfig{Plugins: []PluginConfig{{Name: "p", Source: sourceLua, Verify: pinnedObject}}}, false),
+		Entry("js plugin", FlowConfig{Plugins: []PluginConfig{{Name: "p", Source: sourceJS, Verify: pinnedObject}}}, false),
+		Entry("grpc plugin", FlowConfig{Plugins: []PluginConfig{{Name: "p", Source: sourceGRPC, Verify: pinnedObject}}}, false),
+		Entry("registry middleware", FlowConfig{Middlewares: []MiddlewareConfig{{Name: "m", Source: sourceRegistry, Verify: pinnedObject}}}, false),
+		Entry("registry aggregator", FlowConfig{Aggregation: &AggregationConfig{
+			Custom: &AggregatorConfig{Name: "a", Source: sourceRegistry, Verify: pinnedObject},
+		}}, false),
+	)
+
+	Describe("validateListeners", func() {
+		newConfig := func(listeners []ListenerConfig, flow FlowConfig) GatewayConfig {
+			return GatewayConfig{
+				Server:  ServerConfig{Listeners: listeners},
+				Routing: RoutingConfig{Flows: []FlowConfig{flow}},
+			}
+		}
+
+		admin := []ListenerConfig{{Name: "admin", Address: "127.0.0.1:8081"}}
+
+		It("accepts flows bound to declared listeners", func() {
+			cfg := newConfig(admin, FlowConfig{Path: "/stats", Listeners: []string{"admin", DefaultListener}})
+			Expect(validateListeners(cfg)).To(Succeed())
+		})
+
+		It("rejects unknown listeners", func() {
+			cfg := newConfig(admin, FlowConfig{Method: "GET", Path: "/stats", Listeners: []string{"internal"}})
+			Expect(validateListeners(cfg)).To(MatchError(ContainSubstring(`unknown listener "internal"`)))
+		})
+
+		It("rejects duplicate and reserved listener names", func() {
+			cfg := newConfig([]ListenerConfig{{Name: DefaultListener, Address: ":8081"}}, FlowConfig{})
+			Expect(validateListeners(cfg)).To(MatchError(ContainSubstring("declared more than once")))
+		})
+	})
+
+	Describe("expandFlowGroups", func() {
+		It("prefixes member flows and prepends shared middlewares and plugins", func() {
+			routing := RoutingConfig{
+				Flows: []FlowConfig{{Path: "/health"}},
+				Groups: []FlowGroupConfig{{
+					Prefix:      "/api/v1/",
+					Middlewares: []MiddlewareConfig{{Name: "auth"}},
+					Plugins:     []PluginConfig{{Name: "camelify"}},
+					Flows: []FlowConfig{
+						{Path: "/users", Middlewares: []MiddlewareConfig{{Name: "logger"}}},
+						{PathRegex: `/legacy/(?P<id>\d+)`},
+					},
+				}},
+			}
+
+			expandFlowGroups(&routing)
+
+			Expect(routing.Groups[0].Flows).To(BeEmpty())
+			Expect(routing.Flows).To(HaveLen(3))
+			Expect(routing.Flows[1].Path).To(Equal("/api/v1/users"))
+			Expect(routing.Flows[1].Middlewares).To(Equal([]MiddlewareConfig{{Name: "auth"}, {Name: "logger"}}))
+			Expect(routing.Flows[1].Plugins).To(Equal([]PluginConfig{{Name: "camelify"}}))
+			Expect(routing.Flows[2].PathRegex).To(Equal(`/api/v1/legacy/(?P<id>\d+)`))
+		})
+
+		It("inherits only the policy sections an upstream leaves unset", func() {
+			routing := RoutingConfig{
+				Groups: []FlowGroupConfig{{
+					Prefix: "/api",
+					Policy: PolicyConfig{
+						AllowedStatuses: []int{200},
+						RetryConfig:     RetryConfig{MaxRetries: 3},
+					},
+					Flows: []FlowConfig{{
+						Path: "/users",
+						Upstreams: []UpstreamConfig{{
+							Name:   "users",
+							Policy: PolicyConfig{RetryConfig: RetryConfig{MaxRetries: 1}},
+						}},
+					}},
+				}},
+			}
+
+			expandFlowGroups(&routing)
+
+			policy := routing.Flows[0].Upstreams[0].Policy
+			Expect(policy.AllowedStatuses).To(Equal([]int{200}))
+			Expect(policy.RetryConfig.MaxRetries).To(Equal(1))
+		})
+
+		It("applies the group SNI to members without their own", func() {
+			routing := RoutingConfig{
+				Groups: []FlowGroupConfig{{
+					Prefix: "/admin",
+					SNI:    []string{"admin.example.com"},
+					Flows: []FlowConfig{
+						{Path: "/users"},
+						{Path: "/audit", SNI: []string{"audit.example.com"}},
+					},
+				}},
+			}
+
+			expandFlowGroups(&routing)
+
+			Expect(routing.Flows[0].SNI).To(Equal([]string{"admin.example.com"}))
+			Expect(routing.Flows[1].SNI).To(Equal([]string{"audit.example.com"}))
+		})
+	})
+
+	Describe("applyGlobalMiddlewares", func() {
+		It("wraps flow middlewares and lets flows override them by name", func() {
+			routing := RoutingConfig{
+				Middlewares: []MiddlewareConfig{{Name: "logger"}, {Name: "auth", Config: map[string]interface{}{"audience": "api"}}},
+				Flows: []FlowConfig{
+					{Path: "/users", Middlewares: []MiddlewareConfig{{Name: "cache"}}},
+					{Path: "/admin", Middlewares: []MiddlewareConfig{
+						{Name: "cache"},
+						{Name: "auth", Override: true, Config: map[string]interface{}{"audience": "admin"}},
+					}},
+				},
+			}
+
+			applyGlobalMiddlewares(&routing)
+
+			Expect(routing.Flows[0].Middlewares).To(Equal([]MiddlewareConfig{
+				{Name: "logger"},
+				{Name: "auth", Config: map[string]interface{}{"audience": "api"}},
+				{Name: "cache"},
+			}))
+			Expect(routing.Flows[1].Middlewares).To(Equal([]MiddlewareConfig{
+				{Name: "logger"},
+				{Name: "auth", Override: true, Config: map[string]interface{}{"audience": "admin"}},
+				{Name: "cache"},
+			}))
+			Expect(routing.Middlewares[1].Config).To(HaveKeyWithValue("audience", "api"))
+		})
+	})
+
+	Describe("applyRoutingDefaults", func() {
+		It("fills unset flow and upstream settings", func() {
+			routing := RoutingConfig{
+				Defaults: RoutingDefaultsConfig{
+					ParallelUpstreams: 4,
+					Plugin:            PluginDefaultsConfig{OnError: "continue"},
+					Aggregation:       &AggregationConfig{Strategy: "merge"},
+					Upstream: UpstreamDefaultsConfig{
+						Timeout:        time.Second,
+						ForwardHeaders: []string{"X-Request-Id"},
+						Policy: PolicyConfig{
+							RetryConfig:          RetryConfig{MaxRetries: 2},
+							CircuitBreakerConfig: CircuitBreakerConfig{Enabled: true},
+						},
+					},
+				},
+				Flows: []FlowConfig{
+					{
+						Path:    "/users",
+						Plugins: []PluginConfig{{Name: "enrich"}, {Name: "guard", OnError: "abort"}},
+						Upstreams: []UpstreamConfig{{
+							Name:           "users",
+							Timeout:        5 * time.Second,
+							ForwardHeaders: []string{},
+							Policy:         PolicyConfig{RetryConfig: RetryConfig{MaxRetries: 5}},
+						}},
+					},
+					{
+						Path:        "/stream",
+						Passthrough: true,
+						Upstreams:   []UpstreamConfig{{Name: "stream"}},
+					},
+				},
+			}
+
+			applyRoutingDefaults(&routing)
+
+			users := routing.Flows[0]
+			Expect(users.ParallelUpstreams).To(Equal(int64(4)))
+			Expect(users.Aggregation.Strategy).To(Equal("merge"))
+			Expect(users.Upstreams[0].Timeout).To(Equal(5 * time.Second))
+			Expect(users.Upstreams[0].ForwardHeaders).To(BeEmpty())
+			Expect(users.Upstreams[0].Policy.RetryConfig.MaxRetries).To(Equal(5))
+			Expect(users.Upstreams[0].Policy.CircuitBreakerConfig.Enabled).To(BeTrue())
+			Expect(users.Plugins[0].OnError).To(Equal("continue"))
+			Expect(users.Plugins[1].OnError).To(Equal("abort"))
+
+			stream := routing.Flows[1]
+			Expect(stream.Aggregation).To(BeNil())
+			Expect(stream.Upstreams[0].Timeout).To(Equal(time.Second))
+			Expect(stream.Upstreams[0].ForwardHeaders).To(Equal([]string{"X-Request-Id"}))
+		})
+
+		It("fills script upstreams without touching shared scripts", func() {
+			script := &ScriptConfig{Upstreams: []UpstreamConfig{
+				{Name: "auth"},
+				{Name: "profile", Timeout: 5 * time.Second, ForwardHeaders: []string{}},
+			}}
+
+			routing := RoutingConfig{
+				Defaults: RoutingDefaultsConfig{
+					Upstream: UpstreamDefaultsConfig{
+						Timeout:        time.Second,
+						ForwardHeaders: []string{"X-Request-Id"},
+					},
+				},
+				Flows: []FlowConfig{{
+					Path:      "/users",
+					Plugins:   []PluginConfig{{Name: "lua", Source: "lua", Lua: script}, {Name: "js", Source: "js", JS: script}},
+					Upstreams: []UpstreamConfig{{Name: "users"}},
+				}},
+			}
+
+			applyRoutingDefaults(&routing)
+
+			for _, sc := range []*ScriptConfig{routing.Flows[0].Plugins[0].Lua, routing.Flows[0].Plugins[1].JS} {
+				Expect(sc.Upstreams[0].Timeout).To(Equal(time.Second))
+				Expect(sc.Upstreams[0].ForwardHeaders).To(Equal([]string{"X-Request-Id"}))
+				Expect(sc.Upstreams[1].Timeout).To(Equal(5 * time.Second))
+				Expect(sc.Upstreams[1].ForwardHeaders).To(BeEmpty())
+			}
+
+			Expect(script.Upstreams[0].Timeout).To(BeZero())
+		})
+	})
+
+	Describe("interpolateNode", func() {
+		type target struct {
+			Port     int    `yaml:"port"`
+			Secret   string `yaml:"secret"`
+			Password string `yaml:"password"`
+			Quoted   string `yaml:"quoted"`
+			Literal  string `yaml:"literal"`
+			Plain    string `yaml:"plain"`
+		}
+
+		decode := func(src string) (target, error) {
+			var (
+				doc yaml.Node
+				out target
+			)
+
+			Expect(yaml.Unmarshal([]byte(src), &doc)).To(Succeed())
+
+			in := &interpolator{ctx: context.Background()}
+			if err := in.node(&doc); err != nil {
+				return out, err
+			}
+
+			err := doc.Decode(&out)
+
+			return out, err
+		}
+
+		It("resolves environment variables, fallbacks and files", func() {
+			secretFile := filepath.Join(GinkgoT().TempDir(), "secret")
+			Expect(os.WriteFile(secretFile, []byte("s3cret\n"), 0o600)).To(Succeed())
+
+			GinkgoT().Setenv("KONO_TEST_PORT", "8080")
+			GinkgoT().Setenv("KONO_TEST_PASSWORD", "p: [w")
+
+			out, err := decode(`
+port: ${KONO_TEST_PORT}
+secret: ${file:` + secretFile + `}
+password: ${KONO_TEST_PASSWORD}
+quoted: "${KONO_TEST_MISSING:-fallback}-x"
+literal: $${KONO_TEST_PORT}
+plain: no references
+`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out).To(Equal(target{
+				Port:     8080,
+				Secret:   "s3cret",
+				Password: "p: [w",
+				Quoted:   "fallback-x",
+				Literal:  "${KONO_TEST_PORT}",
+				Plain:    "no references",
+			}))
+		})
+
+		It("fails on unset variables and missing files", func() {
+			_, err := decode("secret: ${KONO_TEST_UNSET_VARIABLE}")
+			Expect(err).To(MatchError(ContainSubstring("KONO_TEST_UNSET_VARIABLE")))
+
+			_, err = decode("secret: ${file:/nonexistent/kono-secret}")
+			Expect(err).To(MatchError(ContainSubstring("line 1")))
+
+			_, err = decode("secret: ${UNTERMINATED")
+			Expect(err).To(MatchError(ContainSubstring("unterminated")))
+		})
+	})
+})
@@ -0,0 +1,236 @@
+package kono
+
+import (
+	"context"
+	"crypto/md5" //nolint:gosec // S3 ETags are MD5 digests; used for integrity, not security.
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/starwalkn/kono/internal/s3"
+)
+
+const (
+	configFetchTimeout = 30 * time.Second
+	maxConfigSize      = 16 << 20
+)
+
+// ConfigSource fetches the raw configuration document from where KONO_CONFIG
+// points: a local file, an http(s):// URL, an s3://bucket/key object or a
+// Consul or etcd key.
+type ConfigSource interface {
+	// Fetch returns the document and an opaque version. When the document still
+	// has version prev, data is nil.
+	Fetch(ctx context.Context, prev string) (data []byte, version string, err error)
+
+	// Remote reports whether the document lives outside the local filesystem and
+	// should be polled for changes.
+	Remote() bool
+}
+
+// WatchableSource is implemented by sources that can wait for the document to
+// change instead of being polled.
+type WatchableSource interface {
+	ConfigSource
+
+	// Watch blocks until the document moves past version prev. Data is nil when
+	// the store stopped waiting without a change.
+	Watch(ctx context.Context, prev string) (data []byte, version string, err error)
+}
+
+// NewConfigSource returns the source for location, selected by its scheme.
+// Plain paths are files; consul:// and etcd:// locations are watched.
+func NewConfigSource(location string) (ConfigSource, error) {
+	scheme, _, found := strings.Cut(location, "://")
+	if !found {
+		return fileSource{path: location}, nil
+	}
+
+	switch scheme {
+	case "http", "https":
+		return &httpSource{url: location, client: &http.Client{Timeout: configFetchTimeout}}, nil
+	case "s3":
+		u, err := url.Parse(location)
+		if err != nil {
+			return nil, fmt.Errorf("parse config location: %w", err)
+		}
+
+		key := strings.TrimPrefix(u.Path, "/")
+		if u.Host == "" || key == "" {
+			return nil, fmt.Errorf("config location %q must be s3://<bucket>/<key>", location)
+		}
+
+		return &s3Source{
+			bucket: u.Host,
+			key:    key,
+			opts:   s3.OptionsFromEnv(),
+			client: &http.Client{Timeout: configFetchTimeout},
+		}, nil
+	case "consul", "consul+https", "etcd", "etcd+https":
+		return newKVSource(location)
+	default:
+		return nil, fmt.Errorf("unsupported config location scheme %q", scheme)
+	}
+}
+
+// LoadConfigFrom fetches the configuration from src and parses it. The returned
+// version can be passed to the next Fetch to detect changes.
+func LoadConfigFrom(ctx context.Context, src ConfigSource) (Config, string, error) {
+	data, version, err := src.Fetch(ctx, "")
+	if err != nil {
+		return Config{}, "", fmt.Errorf("cannot read configuration: %w", err)
+	}
+
+	cfg, err := ParseConfig(data)
+	if err != nil {
+		return Config{}, "", err
+	}
+
+	return cfg, version, nil
+}
+
+type fileSource struct {
+	path string
+}
+
+func (s fileSource) Fetch(_ context.Context, prev string) ([]byte, string, error) {
+	data, err := readConfigFiles(s.path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	version := contentVersion(data)
+	if version == prev {
+		return nil, version, nil
+	}
+
+	return data, version, nil
+}
+
+func (fileSource) Remote() bool { return false }
+
+type httpSource struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSource) Fetch(ctx context.Context, prev string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("create request: %w", err)
+	}
+
+	return fetchConfig(s.client, req, prev, false)
+}
+
+func (*httpSource) Remote() bool { return true }
+
+type s3Source struct {
+	bucket string
+	key    string
+	opts   s3.Options
+	client *http.Client
+}
+
+func (s *s3Source) Fetch(ctx context.Context, prev string) ([]byte, string, error) {
+	req, err := s3.NewGetObjectRequest(ctx, s.opts, s.bucket, s.key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return fetchConfig(s.client, req, prev, true)
+}
+
+func (*s3Source) Remote() bool { return true }
+
+// fetchConfig performs a conditional GET and verifies the body against the
+// checksums the server advertises; etagMD5 marks S3, whose ETags are body
+// digests. The version is the ETag when there is one
+// and a digest of the body otherwise.
+func fetchConfig(client *http.Client, req *http.Request, prev string, etagMD5 bool) ([]byte, string, error) {
+	if strings.HasPrefix(prev, `"`) || strings.HasPrefix(prev, "W/") {
+		req.Header.Set("If-None-Match", prev)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, prev, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("fetch %s: unexpected status %d", req.URL.Redacted(), resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxConfigSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("fetch %s: %w", req.URL.Redacted(), err)
+	}
+
+	if len(data) > maxConfigSize {
+		return nil, "", fmt.Errorf("fetch %s: configuration exceeds %d bytes", req.URL.Redacted(), maxConfigSize)
+	}
+
+	if err = verifyChecksums(resp.Header, data, etagMD5); err != nil {
+		return nil, "", fmt.Errorf("fetch %s: %w", req.URL.Redacted(), err)
+	}
+
+	version := resp.Header.Get("ETag")
+	if version == "" {
+		version = contentVersion(data)
+	}
+
+	if version == prev {
+		return nil, version, nil
+	}
+
+	return data, version, nil
+}
+
+// verifyChecksums checks Content-MD5, x-amz-checksum-sha256 and, with etagMD5,
+// the ETag of single-part S3 uploads. Absent headers are not an error.
+func verifyChecksums(h http.Header, data []byte, etagMD5 bool) error {
+	md5Sum := md5.Sum(data) //nolint:gosec // See import.
+	sha256Sum := sha256.Sum256(data)
+
+	if want := h.Get("Content-MD5"); want != "" && want != base64.StdEncoding.EncodeToString(md5Sum[:]) {
+		return errors.New("Content-MD5 mismatch")
+	}
+
+	if want := h.Get("X-Amz-Checksum-Sha256"); want != "" && want != base64.StdEncoding.EncodeToString(sha256Sum[:]) {
+		return errors.New("x-amz-checksum-sha256 mismatch")
+	}
+
+	etag := strings.Trim(h.Get("ETag"), `"`)
+	if etagMD5 && isMD5Hex(etag) && etag != hex.EncodeToString(md5Sum[:]) {
+		return errors.New("ETag does not match the body")
+	}
+
+	return nil
+}
+
+func isMD5Hex(s string) bool {
+	if len(s) != hex.EncodedLen(md5.Size) {
+		return false
+	}
+
+	_, err := hex.DecodeString(s)
+
+	return err == nil
+}
+
+func contentVersion(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
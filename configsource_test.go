@@ -0,0 +1,164 @@
+package kono
+
+import (
+	"context"
+	"crypto/md5" //nolint:gosec // Mirrors S3 ETags.
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("config sources", func() {
+	const doc = "schema: v1\n"
+
+	It("selects the source by scheme", func() {
+		src, err := NewConfigSource("/etc/kono/config.yaml")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(src.Remote()).To(BeFalse())
+
+		src, err = NewConfigSource("https://config.example.com/kono.yaml")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(src.Remote()).To(BeTrue())
+
+		src, err = NewConfigSource("s3://bucket/envs/prod.yaml")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(src).To(BeAssignableToTypeOf(&s3Source{}))
+		Expect(src.(*s3Source).key).To(Equal("envs/prod.yaml"))
+
+		_, err = NewConfigSource("s3://bucket")
+		Expect(err).To(MatchError(ContainSubstring("s3://<bucket>/<key>")))
+
+		_, err = NewConfigSource("ftp://host/kono.yaml")
+		Expect(err).To(MatchError(ContainSubstring(`unsupported config location scheme "ftp"`)))
+	})
+
+	It("reports unchanged files by digest", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "kono.yaml")
+		Expect(os.WriteFile(path, []byte(doc), 0o600)).To(Succeed())
+
+		src, _ := NewConfigSource(path)
+
+		data, version, err := src.Fetch(context.Background(), "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal(doc))
+
+		data, again, err := src.Fetch(context.Background(), version)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(BeNil())
+		Expect(again).To(Equal(version))
+	})
+
+	Describe("http", func() {
+		var (
+			body        string
+			contentMD5  string
+			conditional []string
+		)
+
+		BeforeEach(func() {
+			body, contentMD5, conditional = doc, "", nil
+		})
+
+		newServer := func() *httptest.Server {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				etag := `"` + contentVersion([]byte(body)) + `"`
+
+				conditional = append(conditional, r.Header.Get("If-None-Match"))
+				if r.Header.Get("If-None-Match") == etag {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+
+				w.Header().Set("ETag", etag)
+				if contentMD5 != "" {
+					w.Header().Set("Content-MD5", contentMD5)
+				}
+				_, _ = w.Write([]byte(body))
+			}))
+			DeferCleanup(srv.Close)
+
+			return srv
+		}
+
+		It("polls with the ETag and picks up new versions", func() {
+			srv := newServer()
+			src, _ := NewConfigSource(srv.URL + "/kono.yaml")
+
+			data, version, err := src.Fetch(context.Background(), "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(doc))
+
+			data, again, err := src.Fetch(context.Background(), version)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(data).To(BeNil())
+			Expect(again).To(Equal(version))
+
+			body = "schema: v1\ndebug: true\n"
+
+			data, next, err := src.Fetch(context.Background(), version)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(body))
+			Expect(next).NotTo(Equal(version))
+
+			Expect(conditional).To(Equal([]string{"", version, version}))
+		})
+
+		It("rejects bodies that fail the advertised checksum", func() {
+			contentMD5 = "AAAAAAAAAAAAAAAAAAAAAA=="
+			srv := newServer()
+			src, _ := NewConfigSource(srv.URL)
+
+			_, _, err := src.Fetch(context.Background(), "")
+			Expect(err).To(MatchError(ContainSubstring("Content-MD5 mismatch")))
+		})
+	})
+
+	Describe("s3", func() {
+		It("signs path-style requests and verifies the ETag", func() {
+			var (
+				path string
+				auth string
+				etag string
+			)
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path, auth = r.URL.Path, r.Header.Get("Authorization")
+
+				w.Header().Set("ETag", etag)
+				_, _ = w.Write([]byte(doc))
+			}))
+			DeferCleanup(srv.Close)
+
+			GinkgoT().Setenv("AWS_ENDPOINT_URL_S3", srv.URL)
+			GinkgoT().Setenv("AWS_REGION", "eu-west-1")
+			GinkgoT().Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+			GinkgoT().Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+			sum := md5.Sum([]byte(doc)) //nolint:gosec // Mirrors S3 ETags.
+			etag = `"` + hex.EncodeToString(sum[:]) + `"`
+
+			src, err := NewConfigSource("s3://configs/kono/prod.yaml")
+			Expect(err).NotTo(HaveOccurred())
+
+			data, version, err := src.Fetch(context.Background(), "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(doc))
+			Expect(version).To(Equal(etag))
+
+			Expect(path).To(Equal("/configs/kono/prod.yaml"))
+			Expect(auth).To(HavePrefix("AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"))
+			Expect(auth).To(ContainSubstring("/eu-west-1/s3/aws4_request"))
+
+			etag = `"` + strings.Repeat("0", hex.EncodedLen(md5.Size)) + `"`
+
+			_, _, err = src.Fetch(context.Background(), "")
+			Expect(err).To(MatchError(ContainSubstring("ETag does not match the body")))
+		})
+	})
+})
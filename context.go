@@ -3,6 +3,8 @@ package kono
 import (
 	"net/http"
 
+	"github.com/go-chi/chi/v5"
+
 	"github.com/starwalkn/kono/sdk"
 )
 
@@ -11,6 +13,10 @@ import (
 type konoContext struct {
 	req  *http.Request
 	resp *http.Response
+
+	upstream   string                  // set for PluginTypeUpstream plugins.
+	aggregated *sdk.AggregatedResponse // set for PluginTypeAggregate plugins.
+	upstreams  []sdk.UpstreamResponse  // set once the upstreams have answered.
 }
 
 func newContext(req *http.Request) sdk.Context {
@@ -32,3 +38,33 @@ func (c *konoContext) SetRequest(req *http.Request) {
 func (c *konoContext) SetResponse(resp *http.Response) {
 	c.resp = resp
 }
+
+func (c *konoContext) Upstream() string {
+	return c.upstream
+}
+
+func (c *konoContext) Aggregated() *sdk.AggregatedResponse {
+	return c.aggregated
+}
+
+func (c *konoContext) Upstreams() []sdk.UpstreamResponse {
+	return c.upstreams
+}
+
+func (c *konoContext) PathParam(name string) string {
+	return chi.URLParam(c.req, name)
+}
+
+func (c *konoContext) PathParams() map[string]string {
+	rctx := chi.RouteContext(c.req.Context())
+	if rctx == nil {
+		return map[string]string{}
+	}
+
+	params := make(map[string]string, len(rctx.URLParams.Keys))
+	for i, key := range rctx.URLParams.Keys {
+		params[key] = rctx.URLParams.Values[i]
+	}
+
+	return params
+}
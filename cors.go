@@ -0,0 +1,242 @@
+package kono
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const corsWildcard = "*"
+
+// corsPolicy answers CORS preflights for a flow and decorates its responses
+// with the access-control headers browsers require.
+type corsPolicy struct {
+	allowAll         bool
+	origins          map[string]struct{} // exact, lower-cased origins.
+	patterns         []originPattern     // origins with one "*", e.g. "https://*.example.com".
+	methods          string              // empty echoes the requested method.
+	headers          []string            // canonical names; empty allows safelisted headers only.
+	anyHeader        bool
+	exposedHeaders   string
+	allowCredentials bool
+	maxAge           string // seconds; empty leaves the browser default.
+}
+
+type originPattern struct {
+	prefix string
+	suffix string
+}
+
+func (p originPattern) match(origin string) bool {
+	return len(origin) > len(p.prefix)+len(p.suffix) &&
+		strings.HasPrefix(origin, p.prefix) &&
+		strings.HasSuffix(origin, p.suffix)
+}
+
+func compileCORS(cfg *CORSConfig) (*corsPolicy, error) {
+	if cfg == nil {
+		return nil, nil //nolint:nilnil // disabled CORS is represented by nil
+	}
+
+	if len(cfg.AllowedOrigins) == 0 {
+		return nil, errors.New("allowed_origins must not be empty")
+	}
+
+	p := &corsPolicy{
+		origins:          make(map[string]struct{}),
+		allowCredentials: cfg.AllowCredentials,
+	}
+
+	for _, origin := range cfg.AllowedOrigins {
+		origin = strings.ToLower(origin)
+
+		switch n := strings.Count(origin, corsWildcard); {
+		case origin == corsWildcard:
+			p.allowAll = true
+		case n == 0:
+			p.origins[origin] = struct{}{}
+		case n == 1:
+			prefix, suffix, _ := strings.Cut(origin, corsWildcard)
+			p.patterns = append(p.patterns, originPattern{prefix: prefix, suffix: suffix})
+		default:
+			return nil, fmt.Errorf("origin %q may contain at most one wildcard", origin)
+		}
+	}
+
+	if p.allowAll && p.allowCredentials {
+		return nil, errors.New("allow_credentials cannot be used with the \"*\" origin")
+	}
+
+	methods := make([]string, 0, len(cfg.AllowedMethods))
+	for _, m := range cfg.AllowedMethods {
+		methods = append(methods, strings.ToUpper(m))
+	}
+
+	p.methods = strings.Join(methods, ", ")
+
+	for _, h := range cfg.AllowedHeaders {
+		if h == corsWildcard {
+			p.anyHeader = true
+			continue
+		}
+
+		p.headers = append(p.headers, http.CanonicalHeaderKey(h))
+	}
+
+	p.exposedHeaders = strings.Join(cfg.ExposedHeaders, ", ")
+
+	if cfg.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	}
+
+	return p, nil
+}
+
+func (p *corsPolicy) allowOrigin(origin string) bool {
+	if p.allowAll {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+
+	if _, ok := p.origins[origin]; ok {
+		return true
+	}
+
+	return slices.ContainsFunc(p.patterns, func(pattern originPattern) bool {
+		return pattern.match(origin)
+	})
+}
+
+// setOrigin writes Access-Control-Allow-Origin. Any-origin policies answer "*"
+// and are cacheable across origins; the others echo the origin and vary on it.
+func (p *corsPolicy) setOrigin(h http.Header, origin string) {
+	if p.allowAll {
+		h.Set("Access-Control-Allow-Origin", corsWildcard)
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+	}
+
+	if p.allowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// handler adds CORS headers to responses for allowed origins. Requests from
+// other origins are served without them, leaving the browser to block the read.
+func (p *corsPolicy) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if origin := req.Header.Get("Origin"); origin != "" && p.allowOrigin(origin) {
+			p.setOrigin(w.Header(), origin)
+
+			if p.exposedHeaders != "" {
+				w.Header().Set("Access-Control-Expose-Headers", p.exposedHeaders)
+			}
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// preflight answers a preflight request on behalf of the flow: 204 with the
+// allowed methods and headers, or 403 when the origin is not allowed.
+func (p *corsPolicy) preflight(w http.ResponseWriter, req *http.Request) {
+	origin := req.Header.Get("Origin")
+	h := w.Header()
+
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+
+	if !p.allowOrigin(origin) {
+		h.Add("Vary", "Origin")
+		w.WriteHeader(http.StatusForbidden)
+
+		return
+	}
+
+	p.setOrigin(h, origin)
+
+	methods := p.methods
+	if methods == "" {
+		methods = req.Header.Get("Access-Control-Request-Method")
+	}
+
+	h.Set("Access-Control-Allow-Methods", methods)
+
+	if headers := p.allowedHeaders(req.Header.Get("Access-Control-Request-Headers")); headers != "" {
+		h.Set("Access-Control-Allow-Headers", headers)
+	}
+
+	if p.maxAge != "" {
+		h.Set("Access-Control-Max-Age", p.maxAge)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allowedHeaders echoes the requested headers the policy allows.
+func (p *corsPolicy) allowedHeaders(requested string) string {
+	if p.anyHeader {
+		return requested
+	}
+
+	var allowed []string
+
+	for _, h := range strings.Split(requested, ",") {
+		if h = http.CanonicalHeaderKey(strings.TrimSpace(h)); h != "" && slices.Contains(p.headers, h) {
+			allowed = append(allowed, h)
+		}
+	}
+
+	return strings.Join(allowed, ", ")
+}
+
+func isPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions &&
+		req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// preflightHandler answers preflights for a path template from the CORS policy of
+// the flow serving the requested method; anything else goes to next.
+func (r *Router) preflightHandler(path string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if isPreflight(req) {
+			method := req.Header.Get("Access-Control-Request-Method")
+
+			for i := range r.flows {
+				f := &r.flows[i]
+
+				if f.cors != nil && f.pathRegex == nil && f.path == path && f.method == method {
+					f.cors.preflight(w, req)
+					return
+				}
+			}
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// regexPreflight answers a preflight for the first regex flow serving the
+// requested method on the request path. It reports whether it did.
+func (r *Router) regexPreflight(w http.ResponseWriter, req *http.Request) bool {
+	if !isPreflight(req) {
+		return false
+	}
+
+	method := req.Header.Get("Access-Control-Request-Method")
+
+	for _, rt := range r.regexRoutes {
+		if rt.flow.cors != nil && rt.flow.method == method && rt.flow.pathRegex.MatchString(req.URL.Path) {
+			rt.flow.cors.preflight(w, req)
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,142 @@
+package kono
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("cors", func() {
+	Describe("compileCORS", func() {
+		It("matches exact and wildcard origins case-insensitively", func() {
+			p, err := compileCORS(&CORSConfig{
+				AllowedOrigins: []string{"https://app.example.com", "https://*.example.org"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(p.allowOrigin("https://APP.example.com")).To(BeTrue())
+			Expect(p.allowOrigin("https://eu.example.org")).To(BeTrue())
+			Expect(p.allowOrigin("https://.example.org")).To(BeFalse())
+			Expect(p.allowOrigin("https://evil.com")).To(BeFalse())
+			Expect(p.allowOrigin("https://example.org.evil.com")).To(BeFalse())
+		})
+
+		It("rejects credentials with the any-origin wildcard", func() {
+			_, err := compileCORS(&CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+			Expect(err).To(MatchError(ContainSubstring("allow_credentials")))
+		})
+
+		It("rejects origins with several wildcards", func() {
+			_, err := compileCORS(&CORSConfig{AllowedOrigins: []string{"https://*.*.example.com"}})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("router", func() {
+		var (
+			scatter *mockScatter
+			r       *Router
+		)
+
+		preflight := func(path, origin, method, headers string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodOptions, path, nil)
+			req.Header.Set("Origin", origin)
+			req.Header.Set("Access-Control-Request-Method", method)
+			req.Header.Set("Access-Control-Request-Headers", headers)
+
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			return rec
+		}
+
+		BeforeEach(func() {
+			cors, err := compileCORS(&CORSConfig{
+				AllowedOrigins:   []string{"https://*.example.com"},
+				AllowedMethods:   []string{"get", "post"},
+				AllowedHeaders:   []string{"content-type", "authorization"},
+				ExposedHeaders:   []string{"X-Request-ID"},
+				AllowCredentials: true,
+				MaxAge:           10 * time.Minute,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			scatter = &mockScatter{results: []upstreamResponse{{status: http.StatusOK, headers: http.Header{}, body: []byte(`"OK"`)}}}
+			r = newTestRouter([]flow{
+				{
+					path:        "/test/cors",
+					method:      http.MethodPost,
+					aggregation: aggregation{strategy: strategyArray},
+					cors:        cors,
+				},
+				{
+					pathRegex:   regexp.MustCompile(`^/test/cors-regex/\d+$`),
+					method:      http.MethodGet,
+					aggregation: aggregation{strategy: strategyArray},
+					cors:        cors,
+				},
+			}, scatter, &defaultAggregator{})
+		})
+
+		It("answers preflights without calling upstreams", func() {
+			rec := preflight("/test/cors", "https://app.example.com", http.MethodPost, "Content-Type, X-Debug")
+
+			Expect(rec.Code).To(Equal(http.StatusNoContent))
+			Expect(rec.Header().Get("Access-Control-Allow-Origin")).To(Equal("https://app.example.com"))
+			Expect(rec.Header().Get("Access-Control-Allow-Methods")).To(Equal("GET, POST"))
+			Expect(rec.Header().Get("Access-Control-Allow-Headers")).To(Equal("Content-Type"))
+			Expect(rec.Header().Get("Access-Control-Allow-Credentials")).To(Equal("true"))
+			Expect(rec.Header().Get("Access-Control-Max-Age")).To(Equal("600"))
+			Expect(rec.Header().Values("Vary")).To(ContainElement("Origin"))
+			Expect(scatter.calls).To(BeZero())
+		})
+
+		It("answers preflights for regex flows", func() {
+			rec := preflight("/test/cors-regex/42", "https://app.example.com", http.MethodGet, "")
+
+			Expect(rec.Code).To(Equal(http.StatusNoContent))
+			Expect(scatter.calls).To(BeZero())
+		})
+
+		It("refuses preflights from other origins", func() {
+			rec := preflight("/test/cors", "https://evil.com", http.MethodPost, "")
+
+			Expect(rec.Code).To(Equal(http.StatusForbidden))
+			Expect(rec.Header().Get("Access-Control-Allow-Origin")).To(BeEmpty())
+		})
+
+		It("leaves preflights for methods without a flow unanswered", func() {
+			rec := preflight("/test/cors", "https://app.example.com", http.MethodDelete, "")
+
+			Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+
+		It("adds CORS headers to actual responses", func() {
+			req := httptest.NewRequest(http.MethodPost, "/test/cors", nil)
+			req.Header.Set("Origin", "https://app.example.com")
+
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Header().Get("Access-Control-Allow-Origin")).To(Equal("https://app.example.com"))
+			Expect(rec.Header().Get("Access-Control-Expose-Headers")).To(Equal("X-Request-ID"))
+			Expect(scatter.calls).To(Equal(1))
+		})
+
+		It("serves disallowed origins without CORS headers", func() {
+			req := httptest.NewRequest(http.MethodPost, "/test/cors", nil)
+			req.Header.Set("Origin", "https://evil.com")
+
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Header().Get("Access-Control-Allow-Origin")).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,216 @@
+package kono
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const discoveryTypeDNS = "dns"
+
+// hostResolver returns the current hosts of a discovered upstream.
+type hostResolver interface {
+	resolve(ctx context.Context) ([]string, error)
+}
+
+// hostWatcher is implemented by resolvers that can push changes. watch blocks
+// until the watch ends and calls notify whenever the hosts may have changed.
+type hostWatcher interface {
+	watch(ctx context.Context, notify func()) error
+}
+
+// hostDiscovery refreshes the hosts of an upstream in the background and hands
+// every changed list to apply.
+type hostDiscovery struct {
+	resolver hostResolver
+	interval time.Duration
+	stopCh   chan struct{}
+	log      *zap.Logger
+}
+
+func newHostDiscovery(cfg DiscoveryConfig, log *zap.Logger) (*hostDiscovery, error) {
+	var resolver hostResolver
+
+	switch cfg.Type {
+	case discoveryTypeDNS:
+		resolver = &dnsResolver{
+			name:     cfg.Name,
+			srv:      cfg.Record == "srv",
+			port:     cfg.Port,
+			scheme:   cfg.Scheme,
+			resolver: net.DefaultResolver,
+		}
+	case discoveryTypeKubernetes:
+		var err error
+
+		resolver, err = newInClusterResolver(cfg)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown discovery type %q", cfg.Type)
+	}
+
+	return &hostDiscovery{
+		resolver: resolver,
+		interval: cfg.RefreshInterval,
+		stopCh:   make(chan struct{}),
+		log:      log,
+	}, nil
+}
+
+// start resolves the hosts once, failing when that is not possible, and then
+// keeps refreshing them until stop: every interval and, for resolvers that can
+// watch, on every change. A failed refresh keeps the previous hosts.
+func (d *hostDiscovery) start(ctx context.Context, apply func([]string)) error {
+	hosts, err := d.resolve(ctx)
+	if err != nil {
+		return err
+	}
+
+	apply(hosts)
+
+	refresh := func() {
+		next, err := d.resolve(context.Background())
+		if err != nil {
+			d.log.Warn("upstream host discovery failed", zap.Error(err))
+			return
+		}
+
+		if !slices.Equal(next, hosts) {
+			d.log.Info("upstream hosts changed", zap.Strings("hosts", next))
+
+			hosts = next
+			apply(hosts)
+		}
+	}
+
+	watchCtx, cancel := context.WithCancel(context.Background())
+	changed := make(chan struct{}, 1)
+
+	if w, ok := d.resolver.(hostWatcher); ok {
+		go d.watch(watchCtx, w, changed)
+	}
+
+	go func() {
+		defer cancel()
+
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				refresh()
+			case <-changed:
+				refresh()
+			case <-d.stopCh:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// watch keeps a watch open, re-establishing it after failures, and signals
+// changed for every event.
+func (d *hostDiscovery) watch(ctx context.Context, w hostWatcher, changed chan<- struct{}) {
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+
+	for {
+		err := w.watch(ctx, notify)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			d.log.Warn("upstream host watch failed", zap.Error(err))
+		}
+
+		select {
+		case <-time.After(d.interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *hostDiscovery) resolve(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.interval)
+	defer cancel()
+
+	hosts, err := d.resolver.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(hosts) == 0 {
+		return nil, errors.New("discovery returned no hosts")
+	}
+
+	slices.Sort(hosts)
+
+	return hosts, nil
+}
+
+func (d *hostDiscovery) stop() {
+	select {
+	case <-d.stopCh:
+	default:
+		close(d.stopCh)
+	}
+}
+
+// dnsResolver turns A/AAAA or SRV records into upstream hosts.
+type dnsResolver struct {
+	name     string
+	srv      bool
+	port     int
+	scheme   string
+	resolver *net.Resolver
+}
+
+func (r *dnsResolver) resolve(ctx context.Context) ([]string, error) {
+	if r.srv {
+		_, records, err := r.resolver.LookupSRV(ctx, "", "", r.name)
+		if err != nil {
+			return nil, fmt.Errorf("lookup srv %s: %w", r.name, err)
+		}
+
+		hosts := make([]string, 0, len(records))
+		for _, rec := range records {
+			hosts = append(hosts, r.host(strings.TrimSuffix(rec.Target, "."), int(rec.Port)))
+		}
+
+		return hosts, nil
+	}
+
+	addrs, err := r.resolver.LookupHost(ctx, r.name)
+	if err != nil {
+		return nil, fmt.Errorf("lookup host %s: %w", r.name, err)
+	}
+
+	hosts := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		hosts = append(hosts, r.host(addr, r.port))
+	}
+
+	return hosts, nil
+}
+
+func (r *dnsResolver) host(addr string, port int) string {
+	return r.scheme + "://" + net.JoinHostPort(addr, strconv.Itoa(port))
+}
@@ -0,0 +1,245 @@
+package kono
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	discoveryTypeKubernetes = "kubernetes"
+
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// kubernetesResolver lists the ready endpoints of a Service from its
+// EndpointSlices and watches them to pick up changes as they happen.
+type kubernetesResolver struct {
+	apiServer string
+	// tokenFile is re-read for every request, since the kubelet rotates projected
+	// service account tokens; token is used when it is empty.
+	tokenFile string
+	token     string
+	client    *http.Client
+
+	namespace string
+	service   string
+	portName  string
+	port      int
+	scheme    string
+}
+
+// newInClusterResolver configures the resolver from the pod service account.
+func newInClusterResolver(cfg DiscoveryConfig) (*kubernetesResolver, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("kubernetes discovery requires running in a cluster")
+	}
+
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("read service account ca: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("service account ca contains no certificates")
+	}
+
+	namespace := cfg.Namespace
+	if namespace == "" {
+		ns, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("read service account namespace: %w", err)
+		}
+
+		namespace = strings.TrimSpace(string(ns))
+	}
+
+	return &kubernetesResolver{
+		apiServer: "https://" + net.JoinHostPort(host, port),
+		tokenFile: serviceAccountDir + "/token",
+		client: &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		}},
+		namespace: namespace,
+		service:   cfg.Service,
+		portName:  cfg.PortName,
+		port:      cfg.Port,
+		scheme:    cfg.Scheme,
+	}, nil
+}
+
+type endpointSliceList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []endpointSlice `json:"items"`
+}
+
+type endpointSlice struct {
+	Ports []struct {
+		Name string `json:"name"`
+		Port int    `json:"port"`
+	} `json:"ports"`
+	Endpoints []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			Ready *bool `json:"ready"`
+		} `json:"conditions"`
+	} `json:"endpoints"`
+}
+
+func (r *kubernetesResolver) resolve(ctx context.Context) ([]string, error) {
+	list, err := r.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+
+	for _, slice := range list.Items {
+		port := r.slicePort(slice)
+		if port == 0 {
+			continue
+		}
+
+		for _, ep := range slice.Endpoints {
+			// A missing condition means ready, as in the EndpointSlice API.
+			if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+				continue
+			}
+
+			for _, addr := range ep.Addresses {
+				hosts = append(hosts, r.scheme+"://"+net.JoinHostPort(addr, strconv.Itoa(port)))
+			}
+		}
+	}
+
+	return hosts, nil
+}
+
+// slicePort returns the port named portName, the only port of the slice when no
+// name is configured, or the configured port number.
+func (r *kubernetesResolver) slicePort(slice endpointSlice) int {
+	if r.port != 0 {
+		return r.port
+	}
+
+	for _, p := range slice.Ports {
+		if p.Name == r.portName || (r.portName == "" && len(slice.Ports) == 1) {
+			return p.Port
+		}
+	}
+
+	return 0
+}
+
+func (r *kubernetesResolver) list(ctx context.Context) (*endpointSliceList, error) {
+	resp, err := r.get(ctx, url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var list endpointSliceList
+	if err = json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("decode endpoint slices: %w", err)
+	}
+
+	return &list, nil
+}
+
+// watch blocks until the watch stream ends, calling notify for every change to
+// the Service's EndpointSlices.
+func (r *kubernetesResolver) watch(ctx context.Context, notify func()) error {
+	list, err := r.list(ctx)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.get(ctx, url.Values{
+		"watch":           {"1"},
+		"resourceVersion": {list.Metadata.ResourceVersion},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+
+	for scanner.Scan() {
+		var event struct {
+			Type string `json:"type"`
+		}
+
+		if err = json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return fmt.Errorf("decode watch event: %w", err)
+		}
+
+		if event.Type == "ERROR" {
+			return errors.New("endpoint slice watch expired")
+		}
+
+		notify()
+	}
+
+	return scanner.Err()
+}
+
+func (r *kubernetesResolver) get(ctx context.Context, query url.Values) (*http.Response, error) {
+	query.Set("labelSelector", "kubernetes.io/service-name="+r.service)
+
+	u := fmt.Sprintf("%s/apis/discovery.k8s.io/v1/namespaces/%s/endpointslices?%s",
+		r.apiServer, url.PathEscape(r.namespace), query.Encode())
+
+	token, err := r.bearerToken()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("list endpoint slices: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("list endpoint slices: unexpected status %d", resp.StatusCode)
+	}
+
+	return resp, nil
+}
+
+func (r *kubernetesResolver) bearerToken() (string, error) {
+	if r.tokenFile == "" {
+		return r.token, nil
+	}
+
+	token, err := os.ReadFile(r.tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("read service account token: %w", err)
+	}
+
+	return strings.TrimSpace(string(token)), nil
+}
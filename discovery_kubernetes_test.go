@@ -0,0 +1,135 @@
+package kono
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("kubernetesResolver", func() {
+	var (
+		server  *httptest.Server
+		version atomic.Int32
+		events  chan string
+	)
+
+	slices := func() string {
+		if version.Load() == 0 {
+			return `{"ports":[{"name":"http","port":8080},{"name":"metrics","port":9090}],"endpoints":[
+				{"addresses":["10.0.0.1"],"conditions":{"ready":true}},
+				{"addresses":["10.0.0.2"],"conditions":{"ready":false}},
+				{"addresses":["10.0.0.3"]}]}`
+		}
+
+		return `{"ports":[{"name":"http","port":8080}],"endpoints":[{"addresses":["10.0.0.4"]}]}`
+	}
+
+	BeforeEach(func() {
+		version.Store(0)
+		events = make(chan string)
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+
+			Expect(r.URL.Path).To(Equal("/apis/discovery.k8s.io/v1/namespaces/shop/endpointslices"))
+			Expect(r.URL.Query().Get("labelSelector")).To(Equal("kubernetes.io/service-name=users"))
+			if r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			if r.URL.Query().Get("watch") == "" {
+				fmt.Fprintf(w, `{"metadata":{"resourceVersion":"%d"},"items":[%s]}`, version.Load(), slices())
+				return
+			}
+
+			w.(http.Flusher).Flush()
+
+			for {
+				select {
+				case event := <-events:
+					fmt.Fprintf(w, `{"type":%q,"object":{}}`+"\n", event)
+					w.(http.Flusher).Flush()
+				case <-r.Context().Done():
+					return
+				}
+			}
+		}))
+	})
+
+	AfterEach(func() {
+		server.CloseClientConnections()
+		server.Close()
+	})
+
+	newResolver := func() *kubernetesResolver {
+		return &kubernetesResolver{
+			apiServer: server.URL,
+			token:     "token",
+			client:    server.Client(),
+			namespace: "shop",
+			service:   "users",
+			portName:  "http",
+			scheme:    "http",
+		}
+	}
+
+	It("resolves ready endpoints on the named port", func() {
+		hosts, err := newResolver().resolve(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hosts).To(Equal([]string{"http://10.0.0.1:8080", "http://10.0.0.3:8080"}))
+	})
+
+	It("skips slices without a matching port", func() {
+		r := newResolver()
+		r.portName = ""
+
+		hosts, err := r.resolve(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hosts).To(BeEmpty())
+	})
+
+	It("re-reads a rotated service account token", func() {
+		tokenFile := filepath.Join(GinkgoT().TempDir(), "token")
+		Expect(os.WriteFile(tokenFile, []byte("stale\n"), 0o600)).To(Succeed())
+
+		r := newResolver()
+		r.tokenFile = tokenFile
+
+		_, err := r.resolve(context.Background())
+		Expect(err).To(MatchError(ContainSubstring("unexpected status 401")))
+
+		Expect(os.WriteFile(tokenFile, []byte("token\n"), 0o600)).To(Succeed())
+
+		_, err = r.resolve(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("refreshes the hosts when the watch reports a change", func() {
+		d := &hostDiscovery{
+			resolver: newResolver(),
+			interval: time.Hour,
+			stopCh:   make(chan struct{}),
+			log:      zap.NewNop(),
+		}
+		defer d.stop()
+
+		var current atomic.Value
+		Expect(d.start(context.Background(), func(hosts []string) { current.Store(hosts) })).To(Succeed())
+		Expect(current.Load()).To(Equal([]string{"http://10.0.0.1:8080", "http://10.0.0.3:8080"}))
+
+		version.Store(1)
+		events <- "MODIFIED"
+
+		Eventually(current.Load).Should(Equal([]string{"http://10.0.0.4:8080"}))
+	})
+})
@@ -0,0 +1,125 @@
+package kono
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+type stubResolver struct {
+	mu    sync.Mutex
+	hosts []string
+	err   error
+}
+
+func (r *stubResolver) set(hosts []string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.hosts, r.err = hosts, err
+}
+
+func (r *stubResolver) resolve(context.Context) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.hosts...), r.err
+}
+
+var _ = Describe("hostDiscovery", func() {
+	var (
+		resolver *stubResolver
+		d        *hostDiscovery
+		up       *httpUpstream
+	)
+
+	BeforeEach(func() {
+		resolver = &stubResolver{}
+		d = &hostDiscovery{
+			resolver: resolver,
+			interval: 10 * time.Millisecond,
+			stopCh:   make(chan struct{}),
+			log:      zap.NewNop(),
+		}
+		up = newTestUpstream("", withLBMode(lbModeRoundRobin, 0))
+		up.discovery = d
+	})
+
+	AfterEach(func() {
+		Expect(up.Close()).To(Succeed())
+	})
+
+	It("fails to start when the first resolution fails", func() {
+		resolver.set(nil, errors.New("nxdomain"))
+		Expect(d.start(context.Background(), up.setHosts)).To(MatchError("nxdomain"))
+
+		resolver.set(nil, nil)
+		Expect(d.start(context.Background(), up.setHosts)).To(MatchError(ContainSubstring("no hosts")))
+	})
+
+	It("replaces the hosts when the records change", func() {
+		resolver.set([]string{"http://10.0.0.2:80", "http://10.0.0.1:80"}, nil)
+		Expect(d.start(context.Background(), up.setHosts)).To(Succeed())
+		Expect(up.hostSet().hosts).To(Equal([]string{"http://10.0.0.1:80", "http://10.0.0.2:80"}))
+
+		resolver.set([]string{"http://10.0.0.3:80"}, nil)
+		Eventually(func() []string { return up.hostSet().hosts }).Should(Equal([]string{"http://10.0.0.3:80"}))
+	})
+
+	It("keeps the previous hosts when a refresh fails", func() {
+		resolver.set([]string{"http://10.0.0.1:80"}, nil)
+		Expect(d.start(context.Background(), up.setHosts)).To(Succeed())
+
+		resolver.set(nil, errors.New("timeout"))
+		Consistently(func() []string { return up.hostSet().hosts }, 50*time.Millisecond).
+			Should(Equal([]string{"http://10.0.0.1:80"}))
+	})
+
+	It("sends requests to the discovered hosts", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(`{"ok":true}`))
+		}))
+		defer server.Close()
+
+		up.setHosts([]string{server.URL})
+
+		resp := up.call(context.Background(), httptest.NewRequest(http.MethodGet, "/", nil), nil)
+		Expect(resp.err).To(BeNil())
+		Expect(string(resp.body)).To(Equal(`{"ok":true}`))
+	})
+
+	It("fails calls while no hosts are known", func() {
+		up.discovered.Store(&hostSet{})
+
+		resp := up.call(context.Background(), httptest.NewRequest(http.MethodGet, "/", nil), nil)
+		Expect(resp.err).To(HaveOccurred())
+		Expect(resp.err.kind).To(Equal(upstreamConnection))
+	})
+})
+
+var _ = Describe("dnsResolver", func() {
+	It("joins resolved addresses with the configured port and scheme", func() {
+		r := &dnsResolver{scheme: "https", port: 8443}
+		Expect(r.host("10.0.0.1", r.port)).To(Equal("https://10.0.0.1:8443"))
+		Expect(r.host("::1", r.port)).To(Equal("https://[::1]:8443"))
+	})
+
+	It("resolves localhost", func() {
+		d, err := newHostDiscovery(DiscoveryConfig{
+			Type: discoveryTypeDNS, Name: "localhost", Record: "a", Port: 8080, Scheme: "http",
+			RefreshInterval: time.Second,
+		}, zap.NewNop())
+		Expect(err).NotTo(HaveOccurred())
+
+		hosts, err := d.resolve(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hosts).To(ContainElement(Or(Equal("http://127.0.0.1:8080"), Equal("http://[::1]:8080"))))
+	})
+})
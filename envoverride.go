@@ -0,0 +1,194 @@
+package kono
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const envOverridePrefix = "KONO_"
+
+// envOverrideIgnored are KONO_ variables with a meaning of their own.
+var envOverrideIgnored = []string{"KONO_CONFIG"}
+
+// applyEnvOverrides sets configuration keys from KONO_<PATH> variables. PATH is
+// the upper-cased key path joined with underscores; the gateway section may be
+// left out, so KONO_SERVER_PORT=9090 sets gateway.server.port and
+// KONO_ROUTING_RATE_LIMITER_ENABLED=false sets gateway.routing.rate_limiter.enabled.
+// List elements are addressed by index (KONO_ROUTING_FLOWS_0_PATH), and lists
+// of scalars take comma-separated values. Variables that name no key are left
+// alone, since they may only be meant for ${...} interpolation.
+func applyEnvOverrides(doc *yaml.Node, environ []string) error {
+	slices.Sort(environ)
+
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, envOverridePrefix) || slices.Contains(envOverrideIgnored, name) {
+			continue
+		}
+
+		path, leaf, ok := resolveEnvPath(strings.TrimPrefix(name, envOverridePrefix))
+		if !ok {
+			continue
+		}
+
+		if err := setNode(doc, path, envValueNode(leaf, value)); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// resolveEnvPath maps an upper-cased variable name to a key path in Config,
+// trying the gateway section when the name does not start at the top level.
+func resolveEnvPath(name string) ([]string, reflect.Type, bool) {
+	if path, leaf, ok := resolveTypePath(reflect.TypeFor[Config](), name); ok {
+		return path, leaf, true
+	}
+
+	if path, leaf, ok := resolveTypePath(reflect.TypeFor[GatewayConfig](), name); ok {
+		return append([]string{"gateway"}, path...), leaf, true
+	}
+
+	return nil, nil, false
+}
+
+// resolveTypePath consumes name segment by segment. Keys may contain
+// underscores themselves, so every field whose upper-cased key prefixes the
+// remaining name is tried.
+func resolveTypePath(t reflect.Type, name string) ([]string, reflect.Type, bool) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if name == "" {
+		return nil, t, isEnvScalar(t) || (t.Kind() == reflect.Slice && isEnvScalar(t.Elem()))
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := range t.NumField() {
+			key, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			if key == "" || key == "-" || !t.Field(i).IsExported() {
+				continue
+			}
+
+			upper := strings.ToUpper(key)
+
+			var rest string
+
+			switch {
+			case name == upper:
+			case strings.HasPrefix(name, upper+"_"):
+				rest = name[len(upper)+1:]
+			default:
+				continue
+			}
+
+			if path, leaf, ok := resolveTypePath(t.Field(i).Type, rest); ok {
+				return append([]string{key}, path...), leaf, true
+			}
+		}
+	case reflect.Slice:
+		index, rest, _ := strings.Cut(name, "_")
+		if _, err := strconv.Atoi(index); err != nil {
+			return nil, nil, false
+		}
+
+		if path, leaf, ok := resolveTypePath(t.Elem(), rest); ok {
+			return append([]string{index}, path...), leaf, true
+		}
+	default:
+	}
+
+	return nil, nil, false
+}
+
+func isEnvScalar(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
+
+// envValueNode builds an untagged scalar, so the decoder converts it to the
+// field's type, or a sequence of them for list fields.
+func envValueNode(leaf reflect.Type, value string) *yaml.Node {
+	if leaf.Kind() != reflect.Slice {
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+	}
+
+	seq := &yaml.Node{Kind: yaml.SequenceNode}
+
+	for item := range strings.SplitSeq(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			seq.Content = append(seq.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: item})
+		}
+	}
+
+	return seq
+}
+
+// setNode replaces the value at path, creating missing mappings on the way.
+// List elements must already exist.
+func setNode(node *yaml.Node, path []string, value *yaml.Node) error {
+	if node.Kind == yaml.DocumentNode {
+		node = node.Content[0]
+	}
+
+	for i, seg := range path {
+		last := i == len(path)-1
+
+		if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+			*node = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+
+		switch node.Kind {
+		case yaml.MappingNode:
+			var child *yaml.Node
+
+			for j := 0; j+1 < len(node.Content); j += 2 {
+				if node.Content[j].Value == seg {
+					child = node.Content[j+1]
+					break
+				}
+			}
+
+			if child == nil {
+				child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: seg}, child)
+			}
+
+			if last {
+				*child = *value
+			}
+
+			node = child
+		case yaml.SequenceNode:
+			index, _ := strconv.Atoi(seg)
+			if index >= len(node.Content) {
+				return fmt.Errorf("%s: index %d out of range", strings.Join(path[:i], "."), index)
+			}
+
+			if last {
+				*node.Content[index] = *value
+			}
+
+			node = node.Content[index]
+		default:
+			return fmt.Errorf("%s is not a mapping", strings.Join(path[:i], "."))
+		}
+	}
+
+	return nil
+}
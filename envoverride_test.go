@@ -0,0 +1,73 @@
+package kono
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
+)
+
+var _ = Describe("applyEnvOverrides", func() {
+	decode := func(src string, environ ...string) (Config, error) {
+		var doc yaml.Node
+		Expect(yaml.Unmarshal([]byte(src), &doc)).To(Succeed())
+
+		if err := applyEnvOverrides(&doc, environ); err != nil {
+			return Config{}, err
+		}
+
+		var cfg Config
+		Expect(doc.Decode(&cfg)).To(Succeed())
+
+		return cfg, nil
+	}
+
+	It("overrides and creates keys with the gateway section implied", func() {
+		cfg, err := decode(`
+gateway:
+  server:
+    port: 8080
+  routing:
+    rate_limiter:
+      enabled: true
+`,
+			"KONO_SERVER_PORT=9090",
+			"KONO_ROUTING_RATE_LIMITER_ENABLED=false",
+			"KONO_GATEWAY_SERVER_TIMEOUT=15s",
+			"KONO_DEBUG=true",
+		)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(cfg.Gateway.Server.Port).To(Equal(9090))
+		Expect(cfg.Gateway.Routing.RateLimiter.Enabled).To(BeFalse())
+		Expect(cfg.Gateway.Server.Timeout.String()).To(Equal("15s"))
+		Expect(cfg.Debug).To(BeTrue())
+	})
+
+	It("addresses list elements and splits scalar lists", func() {
+		cfg, err := decode(`
+gateway:
+  routing:
+    flows:
+      - path: /a
+        method: GET
+`,
+			"KONO_ROUTING_FLOWS_0_PATH=/b",
+			"KONO_ROUTING_TRUSTED_PROXIES=10.0.0.0/8, 192.168.0.0/16",
+		)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(cfg.Gateway.Routing.Flows[0].Path).To(Equal("/b"))
+		Expect(cfg.Gateway.Routing.TrustedProxies).To(Equal([]string{"10.0.0.0/8", "192.168.0.0/16"}))
+	})
+
+	It("rejects indexes past the end of a list", func() {
+		_, err := decode("gateway: {routing: {flows: []}}", "KONO_ROUTING_FLOWS_3_PATH=/b")
+		Expect(err).To(MatchError(ContainSubstring("index 3 out of range")))
+	})
+
+	It("ignores variables that name no key", func() {
+		cfg, err := decode("debug: false", "KONO_CONFIG=/etc/kono.yaml", "KONO_API_TOKEN=x", "KONO_SERVER=1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.Debug).To(BeFalse())
+	})
+})
@@ -0,0 +1,206 @@
+package kono
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/starwalkn/kono/internal/extproc"
+	"github.com/starwalkn/kono/sdk"
+)
+
+// extProcClient is the connection to an external processor, shared by the
+// plugins created for each of its phases.
+type extProcClient struct {
+	address     string
+	conn        *grpc.ClientConn
+	timeout     time.Duration
+	sendBody    bool
+	maxBodySize int64
+	failOpen    bool
+
+	closeOnce sync.Once
+	closeErr  error
+
+	log *zap.Logger
+}
+
+// extProcPlugin runs one phase of a flow through an external processor.
+type extProcPlugin struct {
+	name   string
+	phase  sdk.PluginType
+	client *extProcClient
+}
+
+func newExtProcPlugins(cfg PluginConfig, log *zap.Logger) ([]sdk.Plugin, error) {
+	pc := cfg.GRPC
+
+	creds := credentials.NewTLS(nil)
+	if pc.Insecure {
+		creds = insecure.NewCredentials()
+	}
+
+	conn, err := grpc.NewClient(pc.Address,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(extproc.Codec{})),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create grpc client for %q: %w", pc.Address, err)
+	}
+
+	client := &extProcClient{
+		address:     pc.Address,
+		conn:        conn,
+		timeout:     pc.Timeout,
+		sendBody:    pc.SendBody,
+		maxBodySize: pc.MaxBodySize,
+		failOpen:    pc.FailOpen,
+		log:         log.With(zap.String("plugin", cfg.Name)),
+	}
+
+	phases := pc.Phases
+	if len(phases) == 0 {
+		phases = []string{sdk.PluginTypeRequest.String()}
+	}
+
+	plugins := make([]sdk.Plugin, 0, len(phases))
+
+	for _, phase := range phases {
+		pt := sdk.PluginTypeRequest
+		if phase == sdk.PluginTypeResponse.String() {
+			pt = sdk.PluginTypeResponse
+		}
+
+		plugins = append(plugins, &extProcPlugin{name: cfg.Name, phase: pt, client: client})
+	}
+
+	return plugins, nil
+}
+
+func (p *extProcPlugin) Info() sdk.PluginInfo {
+	return sdk.PluginInfo{
+		Name:        p.name,
+		Description: "external processor at " + p.client.address,
+	}
+}
+
+// Init does nothing: external processors are configured through PluginConfig.GRPC.
+func (p *extProcPlugin) Init(map[string]interface{}) error { return nil }
+
+func (p *extProcPlugin) Type() sdk.PluginType { return p.phase }
+
+func (p *extProcPlugin) Close() error {
+	c := p.client
+	c.closeOnce.Do(func() { c.closeErr = c.conn.Close() })
+
+	return c.closeErr
+}
+
+func (p *extProcPlugin) Execute(kctx sdk.Context) error {
+	req := kctx.Request()
+
+	msg := &extproc.ProcessingRequest{
+		Method:    req.Method,
+		Path:      req.URL.RequestURI(),
+		Flow:      routeFromContext(req.Context()),
+		RequestID: requestIDFromContext(req.Context()),
+	}
+
+	var (
+		header http.Header
+		body   *io.ReadCloser
+	)
+
+	if p.phase == sdk.PluginTypeResponse {
+		resp := kctx.Response()
+		header, body = resp.Header, &resp.Body
+		msg.Phase, msg.Status = extproc.PhaseResponse, int32(resp.StatusCode) //nolint:gosec // HTTP status codes fit.
+	} else {
+		header, body = req.Header, &req.Body
+		msg.Phase = extproc.PhaseRequest
+	}
+
+	msg.Headers = extProcHeaders(header)
+
+	if p.client.sendBody && *body != nil && *body != http.NoBody {
+		data, err := io.ReadAll(io.LimitReader(*body, p.client.maxBodySize+1))
+		if err != nil {
+			return fmt.Errorf("read body: %w", err)
+		}
+
+		if int64(len(data)) > p.client.maxBodySize {
+			p.client.log.Debug("body exceeds max_body_size, not sent to the processor", zap.Int64("max_body_size", p.client.maxBodySize))
+			*body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(data), *body), *body}
+		} else {
+			_ = (*body).Close()
+			*body = io.NopCloser(bytes.NewReader(data))
+			msg.Body = data
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), p.client.timeout)
+	defer cancel()
+
+	var reply extproc.ProcessingResponse
+	if err := p.client.conn.Invoke(ctx, extproc.FullMethod, msg, &reply); err != nil {
+		if p.client.failOpen {
+			p.client.log.Warn("external processor failed, continuing", zap.Error(err))
+			return nil
+		}
+
+		return fmt.Errorf("external processor %s: %w", p.client.address, err)
+	}
+
+	if ir := reply.ImmediateResponse; ir != nil {
+		abort := &sdk.AbortError{Status: int(ir.Status), Header: make(http.Header), Body: ir.Body}
+		for _, h := range ir.Headers {
+			abort.Header.Add(h.Name, h.Value)
+		}
+
+		return abort
+	}
+
+	for _, name := range reply.RemoveHeaders {
+		header.Del(name)
+	}
+
+	for _, h := range reply.SetHeaders {
+		header.Set(h.Name, h.Value)
+	}
+
+	if reply.ReplaceBody {
+		*body = io.NopCloser(bytes.NewReader(reply.Body))
+
+		if p.phase == sdk.PluginTypeResponse {
+			kctx.Response().ContentLength = int64(len(reply.Body))
+		} else {
+			req.ContentLength = int64(len(reply.Body))
+		}
+	}
+
+	return nil
+}
+
+func extProcHeaders(h http.Header) []extproc.Header {
+	headers := make([]extproc.Header, 0, len(h))
+
+	for name, values := range h {
+		for _, v := range values {
+			headers = append(headers, extproc.Header{Name: name, Value: v})
+		}
+	}
+
+	return headers
+}
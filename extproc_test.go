@@ -0,0 +1,199 @@
+package kono
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+
+	"github.com/starwalkn/kono/internal/extproc"
+	"github.com/starwalkn/kono/sdk"
+)
+
+var _ = Describe("extProcPlugin", func() {
+	var (
+		addr     string
+		server   *grpc.Server
+		received extproc.ProcessingRequest
+	)
+
+	BeforeEach(func() {
+		// Rejects requests carrying X-Block, uppercases bodies and tags everything else.
+		server = grpc.NewServer(
+			grpc.ForceServerCodec(extproc.Codec{}),
+			grpc.UnknownServiceHandler(func(_ any, stream grpc.ServerStream) error {
+				if err := stream.RecvMsg(&received); err != nil {
+					return err
+				}
+
+				reply := &extproc.ProcessingResponse{
+					SetHeaders:    []extproc.Header{{Name: "X-Processed", Value: "yes"}},
+					RemoveHeaders: []string{"X-Internal"},
+				}
+
+				for _, h := range received.Headers {
+					if h.Name == "X-Block" {
+						reply = &extproc.ProcessingResponse{ImmediateResponse: &extproc.ImmediateResponse{
+							Status:  http.StatusForbidden,
+							Headers: []extproc.Header{{Name: "X-Reason", Value: "blocked"}},
+							Body:    []byte("denied"),
+						}}
+					}
+				}
+
+				if len(received.Body) > 0 {
+					reply.ReplaceBody, reply.Body = true, bytes.ToUpper(received.Body)
+				}
+
+				return stream.SendMsg(reply)
+			}),
+		)
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		addr = lis.Addr().String()
+
+		go func() { _ = server.Serve(lis) }()
+	})
+
+	AfterEach(func() {
+		server.Stop()
+	})
+
+	newPlugins := func(cfg ExtProcConfig) []sdk.Plugin {
+		cfg.Insecure = true
+		if cfg.Timeout == 0 {
+			cfg.Timeout = time.Second
+		}
+
+		if cfg.MaxBodySize == 0 {
+			cfg.MaxBodySize = 1 << 10
+		}
+
+		plugins, err := newExtProcPlugins(PluginConfig{Name: "proc", Source: sourceGRPC, GRPC: &cfg}, zap.NewNop())
+		Expect(err).NotTo(HaveOccurred())
+
+		DeferCleanup(func() { _ = plugins[0].(sdk.Closer).Close() })
+
+		return plugins
+	}
+
+	It("creates a plugin per phase", func() {
+		plugins := newPlugins(ExtProcConfig{Address: addr, Phases: []string{"request", "response"}})
+
+		Expect(plugins).To(HaveLen(2))
+		Expect(plugins[0].Type()).To(Equal(sdk.PluginTypeRequest))
+		Expect(plugins[1].Type()).To(Equal(sdk.PluginTypeResponse))
+	})
+
+	It("applies header and body mutations to the request", func() {
+		p := newPlugins(ExtProcConfig{Address: addr, SendBody: true})[0]
+
+		req := httptest.NewRequest(http.MethodPost, "/items?a=1", bytes.NewReader([]byte("hello")))
+		req.Header.Set("X-Internal", "secret")
+		kctx := newContext(req)
+
+		Expect(p.Execute(kctx)).To(Succeed())
+
+		Expect(received.Phase).To(Equal(extproc.PhaseRequest))
+		Expect(received.Path).To(Equal("/items?a=1"))
+		Expect(req.Header.Get("X-Processed")).To(Equal("yes"))
+		Expect(req.Header.Get("X-Internal")).To(BeEmpty())
+
+		body, _ := io.ReadAll(req.Body)
+		Expect(string(body)).To(Equal("HELLO"))
+		Expect(req.ContentLength).To(Equal(int64(5)))
+	})
+
+	It("forwards bodies over max_body_size unchanged", func() {
+		p := newPlugins(ExtProcConfig{Address: addr, SendBody: true, MaxBodySize: 4})[0]
+
+		req := httptest.NewRequest(http.MethodPost, "/items", bytes.NewReader([]byte("hello")))
+		Expect(p.Execute(newContext(req))).To(Succeed())
+
+		Expect(received.Body).To(BeEmpty())
+		Expect(req.Header.Get("X-Processed")).To(Equal("yes"))
+
+		body, _ := io.ReadAll(req.Body)
+		Expect(string(body)).To(Equal("hello"))
+	})
+
+	It("applies mutations to the response", func() {
+		p := newPlugins(ExtProcConfig{Address: addr, Phases: []string{"response"}})[0]
+
+		kctx := newContext(httptest.NewRequest(http.MethodGet, "/items", nil))
+		kctx.SetResponse(&http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(nil))})
+
+		Expect(p.Execute(kctx)).To(Succeed())
+
+		Expect(received.Phase).To(Equal(extproc.PhaseResponse))
+		Expect(received.Status).To(Equal(int32(http.StatusOK)))
+		Expect(kctx.Response().Header.Get("X-Processed")).To(Equal("yes"))
+	})
+
+	It("aborts with the immediate response", func() {
+		p := newPlugins(ExtProcConfig{Address: addr})[0]
+
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set("X-Block", "1")
+
+		var abort *sdk.AbortError
+		Expect(errors.As(p.Execute(newContext(req)), &abort)).To(BeTrue())
+		Expect(abort.Status).To(Equal(http.StatusForbidden))
+		Expect(abort.Header.Get("X-Reason")).To(Equal("blocked"))
+		Expect(string(abort.Body)).To(Equal("denied"))
+	})
+
+	It("follows the failure policy when the processor is unavailable", func() {
+		server.Stop()
+
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+
+		closed := newPlugins(ExtProcConfig{Address: addr, Timeout: 100 * time.Millisecond})[0]
+		Expect(closed.Execute(newContext(req))).To(HaveOccurred())
+
+		open := newPlugins(ExtProcConfig{Address: addr, Timeout: 100 * time.Millisecond, FailOpen: true})[0]
+		Expect(open.Execute(newContext(req))).To(Succeed())
+	})
+
+	It("answers the client with an abort from a plugin", func() {
+		serve := func(status int) *httptest.ResponseRecorder {
+			r := newTestRouter([]flow{{
+				path:        "/test/abort",
+				method:      http.MethodGet,
+				aggregation: aggregation{strategy: strategyArray},
+				plugins:     []sdk.Plugin{&abortPlugin{status: status}},
+			}}, &mockScatter{}, &defaultAggregator{})
+
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test/abort", nil))
+
+			return rec
+		}
+
+		rec := serve(http.StatusTeapot)
+		Expect(rec.Code).To(Equal(http.StatusTeapot))
+		Expect(rec.Body.String()).To(Equal("short and stout"))
+
+		Expect(serve(1000).Code).To(Equal(http.StatusBadGateway))
+		Expect(serve(-1).Code).To(Equal(http.StatusBadGateway))
+	})
+})
+
+type abortPlugin struct {
+	mockPlugin
+
+	status int
+}
+
+func (p abortPlugin) Execute(sdk.Context) error {
+	return &sdk.AbortError{Status: p.status, Body: []byte("short and stout")}
+}
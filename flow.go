@@ -1,6 +1,10 @@
 package kono
 
 import (
+	"regexp"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
 	"golang.org/x/sync/semaphore"
 
 	"github.com/starwalkn/kono/sdk"
@@ -9,17 +13,35 @@ import (
 type flow struct {
 	path              string
 	method            string
+	pathRegex         *regexp.Regexp // non-nil for flows matched by path_regex instead of a template.
+	headerMatchers    []headerMatcher
+	serverNames       []string // lower-cased SNI hostnames; empty matches any connection.
+	listeners         []string // listener names serving the flow; empty means DefaultListener.
 	aggregation       aggregation
+	negotiation       negotiation
+	compression       *compression                  // nil disables response compression.
+	cache             *responseCache                // nil disables response caching.
+	coalescer         *coalescer                    // nil disables request coalescing.
+	cors              *corsPolicy                   // nil leaves CORS, preflights included, to upstreams.
+	propagator        propagation.TextMapPropagator // writes trace headers on upstream requests.
 	parallelUpstreams int64
 	upstreams         []upstream
 
 	plugins     []sdk.Plugin
 	middlewares []sdk.Middleware
 
+	// sequential calls upstreams in order instead of fanning out, letting each
+	// upstream path reference the responses of the previous ones.
+	sequential bool
+
 	// passthrough enables unbuffered streaming proxy mode.
 	// When true: only one upstream is allowed, aggregation is skipped,
 	// and the response body is piped directly to the client (SSE-safe).
 	passthrough bool
+	// flushInterval coalesces passthrough flushes; zero flushes after every read.
+	flushInterval time.Duration
+	// timeout overrides the server read and write deadlines; zero keeps them.
+	timeout time.Duration
 
 	sem *semaphore.Weighted
 }
@@ -29,6 +51,8 @@ type aggregation struct {
 	strategy          aggregationStrategy
 	conflictPolicy    conflictPolicy // Conflict policy be set only for 'merge' aggregation strategy.
 	preferredUpstream int            // Preferred upstream used only for 'prefer' conflict policy.
+	custom            sdk.Aggregator // Aggregator used only for 'custom' strategy.
+	headers           headerPolicy   // Filters and merges upstream response headers.
 }
 
 type aggregationStrategy uint8
@@ -36,17 +60,26 @@ type aggregationStrategy uint8
 const (
 	strategyMerge aggregationStrategy = iota
 	strategyArray
+	strategyConcat
 	strategyNamespace
+	strategyFirst
+	strategyCustom
 )
 
 func (s aggregationStrategy) String() string {
 	switch s {
 	case strategyArray:
 		return "array"
+	case strategyConcat:
+		return "concat"
 	case strategyMerge:
 		return "merge"
 	case strategyNamespace:
 		return "namespace"
+	case strategyFirst:
+		return "first"
+	case strategyCustom:
+		return "custom"
 	default:
 		return "unknown"
 	}
@@ -0,0 +1,846 @@
+package kono
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// graphqlPathParamPattern matches {param} placeholders in GraphQL field paths.
+var graphqlPathParamPattern = regexp.MustCompile(`\{([a-zA-Z_][a-zA-Z0-9_]*)\}`)
+
+// graphqlEndpoint resolves query fields by dispatching internal requests to flows.
+type graphqlEndpoint struct {
+	fields    map[string]GraphQLFieldConfig
+	handler   http.Handler // serves the flows; see Router.serveSubrequest.
+	maxDepth  int
+	maxFields int
+	log       *zap.Logger
+}
+
+type graphqlRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type graphqlError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+type graphqlResponse struct {
+	Data   *orderedObject `json:"data,omitempty"`
+	Errors []graphqlError `json:"errors,omitempty"`
+}
+
+func newGraphQLEndpoint(cfg GraphQLConfig, handler http.Handler, log *zap.Logger) (*graphqlEndpoint, error) {
+	e := &graphqlEndpoint{
+		fields:    make(map[string]GraphQLFieldConfig, len(cfg.Fields)),
+		handler:   handler,
+		maxDepth:  cfg.MaxDepth,
+		maxFields: cfg.MaxFields,
+		log:       log,
+	}
+
+	for _, field := range cfg.Fields {
+		if !isGraphQLName(field.Name) {
+			return nil, fmt.Errorf("invalid graphql field name %q", field.Name)
+		}
+
+		if _, dup := e.fields[field.Name]; dup {
+			return nil, fmt.Errorf("duplicate graphql field %q", field.Name)
+		}
+
+		e.fields[field.Name] = field
+	}
+
+	return e, nil
+}
+
+func (e *graphqlEndpoint) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var greq graphqlRequest
+
+	switch req.Method {
+	case http.MethodGet:
+		greq.Query = req.URL.Query().Get("query")
+
+		if vars := req.URL.Query().Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &greq.Variables); err != nil {
+				writeGraphQLResponse(w, http.StatusBadRequest, graphqlResponse{Errors: []graphqlError{{Message: "variables must be a JSON object"}}})
+				return
+			}
+		}
+	default:
+		dec := json.NewDecoder(req.Body)
+		dec.UseNumber()
+
+		if err := dec.Decode(&greq); err != nil {
+			writeGraphQLResponse(w, http.StatusBadRequest, graphqlResponse{Errors: []graphqlError{{Message: "request body must be a JSON object"}}})
+			return
+		}
+	}
+
+	selection, err := parseGraphQLQuery(greq.Query, greq.Variables, e.maxDepth, e.maxFields)
+	if err != nil {
+		writeGraphQLResponse(w, http.StatusBadRequest, graphqlResponse{Errors: []graphqlError{{Message: err.Error()}}})
+		return
+	}
+
+	writeGraphQLResponse(w, http.StatusOK, e.execute(req, selection))
+}
+
+// execute resolves top-level fields concurrently; each one is an independent flow call.
+func (e *graphqlEndpoint) execute(req *http.Request, selection []gqlField) graphqlResponse {
+	type result struct {
+		value interface{}
+		err   *graphqlError
+	}
+
+	results := make([]result, len(selection))
+
+	var wg sync.WaitGroup
+
+	for i, field := range selection {
+		if field.name == "__typename" {
+			results[i].value = "Query"
+			continue
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			value, err := e.resolve(req, field)
+			if err != nil {
+				e.log.Debug("graphql field failed", zap.String("field", field.name), zap.Error(err))
+				results[i].err = &graphqlError{Message: err.Error(), Path: []interface{}{field.key()}}
+				return
+			}
+
+			results[i].value = selectGraphQLFields(value, field.selection)
+		}()
+	}
+
+	wg.Wait()
+
+	resp := graphqlResponse{Data: &orderedObject{}}
+
+	for i, field := range selection {
+		resp.Data.set(field.key(), results[i].value)
+
+		if results[i].err != nil {
+			resp.Errors = append(resp.Errors, *results[i].err)
+		}
+	}
+
+	return resp
+}
+
+func (e *graphqlEndpoint) resolve(original *http.Request, field gqlField) (interface{}, error) {
+	cfg, ok := e.fields[field.name]
+	if !ok {
+		return nil, fmt.Errorf("cannot query field %q on type \"Query\"", field.name)
+	}
+
+	args := make(map[string]interface{}, len(field.args))
+	for k, v := range field.args {
+		args[k] = v
+	}
+
+	var missing []string
+
+	path := graphqlPathParamPattern.ReplaceAllStringFunc(cfg.Path, func(m string) string {
+		name := m[1 : len(m)-1]
+
+		v, set := args[name]
+		if !set || v == nil {
+			missing = append(missing, name)
+			return ""
+		}
+
+		delete(args, name)
+
+		return url.PathEscape(fmt.Sprint(v))
+	})
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required arguments: %s", strings.Join(missing, ", "))
+	}
+
+	var body []byte
+
+	if cfg.Method == http.MethodGet {
+		query := url.Values{}
+		for k, v := range args {
+			query.Set(k, fmt.Sprint(v))
+		}
+
+		if len(query) > 0 {
+			path += "?" + query.Encode()
+		}
+	} else if len(args) > 0 {
+		var err error
+		if body, err = json.Marshal(args); err != nil {
+			return nil, fmt.Errorf("encode arguments: %w", err)
+		}
+	}
+
+	// Drop the route context of the GraphQL endpoint so the flow mux routes afresh.
+	ctx := context.WithValue(original.Context(), chi.RouteCtxKey, nil)
+
+	req, err := http.NewRequestWithContext(ctx, cfg.Method, path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+
+	req.Header = original.Header.Clone()
+	req.Header.Del("Content-Length")
+	req.Header.Del("Accept-Encoding")
+	req.Header.Set("Accept", "application/json")
+
+	if len(body) > 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	req.RemoteAddr = original.RemoteAddr
+
+	rec := newBufferedResponseWriter()
+	e.handler.ServeHTTP(rec, req)
+
+	var envelope ClientResponse
+
+	dec := json.NewDecoder(&rec.body)
+	dec.UseNumber()
+
+	if err = dec.Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("flow %s %s returned status %d", cfg.Method, cfg.Path, rec.status)
+	}
+
+	if rec.status >= http.StatusBadRequest || (len(envelope.Errors) > 0 && envelope.Data == nil) {
+		msg := http.StatusText(rec.status)
+		if len(envelope.Errors) > 0 {
+			errs := make([]string, len(envelope.Errors))
+			for i, ce := range envelope.Errors {
+				errs[i] = ce.String()
+			}
+
+			msg = strings.Join(errs, ", ")
+		}
+
+		return nil, errors.New(msg)
+	}
+
+	if len(envelope.Data) == 0 {
+		return nil, nil
+	}
+
+	var data interface{}
+
+	dec = json.NewDecoder(bytes.NewReader(envelope.Data))
+	dec.UseNumber()
+
+	if err = dec.Decode(&data); err != nil {
+		return nil, fmt.Errorf("decode flow data: %w", err)
+	}
+
+	return data, nil
+}
+
+func writeGraphQLResponse(w http.ResponseWriter, status int, resp graphqlResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(mustMarshal(resp))
+}
+
+// selectGraphQLFields trims a decoded JSON value to the selection set. Objects keep
+// the selected keys in selection order (renamed by aliases, null when absent) and
+// lists are trimmed element-wise. Values without a selection are returned as is.
+func selectGraphQLFields(v interface{}, selection []gqlField) interface{} {
+	if len(selection) == 0 {
+		return v
+	}
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		obj := &orderedObject{}
+
+		for _, field := range selection {
+			if field.name == "__typename" {
+				obj.set(field.key(), "Object")
+				continue
+			}
+
+			obj.set(field.key(), selectGraphQLFields(t[field.name], field.selection))
+		}
+
+		return obj
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, item := range t {
+			out[i] = selectGraphQLFields(item, selection)
+		}
+
+		return out
+	default:
+		return v
+	}
+}
+
+// orderedObject is a JSON object that keeps insertion order, as GraphQL responses
+// must follow the order of the selection set.
+type orderedObject struct {
+	keys   []string
+	values []interface{}
+}
+
+func (o *orderedObject) set(key string, v interface{}) {
+	o.keys = append(o.keys, key)
+	o.values = append(o.values, v)
+}
+
+func (o *orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteByte('{')
+
+	for i, k := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+
+		val, err := json.Marshal(o.values[i])
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// ── Query parsing ─────────────────────────────────────────────────────────────
+
+// gqlField is a parsed field selection with its arguments already resolved
+// against the request variables.
+type gqlField struct {
+	alias     string
+	name      string
+	args      map[string]interface{}
+	selection []gqlField
+}
+
+// key is the response key of the field: its alias, or its name.
+func (f gqlField) key() string {
+	if f.alias != "" {
+		return f.alias
+	}
+
+	return f.name
+}
+
+// gqlParser implements the subset of the GraphQL query language needed to select
+// data from flows: a single query operation with fields, aliases, arguments and
+// variables. Fragments, directives and mutations are rejected.
+type gqlParser struct {
+	src  string
+	pos  int
+	vars map[string]interface{}
+
+	// maxDepth and maxFields bound the selection sets and fields of the query;
+	// zero lifts the bound.
+	maxDepth, maxFields int
+	depth, fields       int
+}
+
+func parseGraphQLQuery(query string, vars map[string]interface{}, maxDepth, maxFields int) ([]gqlField, error) {
+	p := &gqlParser{src: query, vars: vars, maxDepth: maxDepth, maxFields: maxFields}
+
+	p.skipIgnored()
+
+	if p.peek() != '{' {
+		keyword := p.name()
+
+		switch keyword {
+		case "query":
+		case "mutation", "subscription":
+			return nil, fmt.Errorf("%s operations are not supported", keyword)
+		default:
+			return nil, p.errorf("expected a query operation")
+		}
+
+		p.skipIgnored()
+
+		if isGraphQLNameStart(p.peek()) {
+			p.name()
+			p.skipIgnored()
+		}
+
+		if p.peek() == '(' {
+			if err := p.skipVariableDefinitions(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	selection, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipIgnored()
+
+	if p.pos < len(p.src) {
+		return nil, p.errorf("only a single operation is supported")
+	}
+
+	return selection, nil
+}
+
+func (p *gqlParser) selectionSet() ([]gqlField, error) {
+	if err := p.expect('{'); err != nil {
+		return nil, err
+	}
+
+	p.depth++
+	defer func() { p.depth-- }()
+
+	if p.maxDepth > 0 && p.depth > p.maxDepth {
+		return nil, fmt.Errorf("query exceeds the maximum depth of %d", p.maxDepth)
+	}
+
+	var fields []gqlField
+
+	for {
+		p.skipIgnored()
+
+		switch {
+		case p.peek() == '}':
+			p.pos++
+
+			if len(fields) == 0 {
+				return nil, p.errorf("selection set must not be empty")
+			}
+
+			return fields, nil
+		case strings.HasPrefix(p.src[p.pos:], "..."):
+			return nil, p.errorf("fragments are not supported")
+		case p.peek() == '@':
+			return nil, p.errorf("directives are not supported")
+		}
+
+		field, err := p.field()
+		if err != nil {
+			return nil, err
+		}
+
+		fields = append(fields, field)
+	}
+}
+
+func (p *gqlParser) field() (gqlField, error) {
+	name := p.name()
+	if name == "" {
+		return gqlField{}, p.errorf("expected a field name")
+	}
+
+	p.fields++
+	if p.maxFields > 0 && p.fields > p.maxFields {
+		return gqlField{}, fmt.Errorf("query exceeds the maximum of %d fields", p.maxFields)
+	}
+
+	field := gqlField{name: name}
+
+	p.skipIgnored()
+
+	if p.peek() == ':' {
+		p.pos++
+		p.skipIgnored()
+
+		field.alias, field.name = name, p.name()
+		if field.name == "" {
+			return gqlField{}, p.errorf("expected a field name after alias %q", name)
+		}
+
+		p.skipIgnored()
+	}
+
+	if p.peek() == '(' {
+		args, err := p.arguments()
+		if err != nil {
+			return gqlField{}, err
+		}
+
+		field.args = args
+
+		p.skipIgnored()
+	}
+
+	if p.peek() == '@' {
+		return gqlField{}, p.errorf("directives are not supported")
+	}
+
+	if p.peek() == '{' {
+		selection, err := p.selectionSet()
+		if err != nil {
+			return gqlField{}, err
+		}
+
+		field.selection = selection
+	}
+
+	return field, nil
+}
+
+func (p *gqlParser) arguments() (map[string]interface{}, error) {
+	p.pos++ // '('
+
+	args := make(map[string]interface{})
+
+	for {
+		p.skipIgnored()
+
+		if p.peek() == ')' {
+			p.pos++
+			return args, nil
+		}
+
+		name := p.name()
+		if name == "" {
+			return nil, p.errorf("expected an argument name")
+		}
+
+		p.skipIgnored()
+
+		if err := p.expect(':'); err != nil {
+			return nil, err
+		}
+
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+
+		args[name] = value
+	}
+}
+
+func (p *gqlParser) value() (interface{}, error) {
+	p.skipIgnored()
+
+	c := p.peek()
+
+	switch {
+	case c == '$':
+		p.pos++
+
+		name := p.name()
+		if name == "" {
+			return nil, p.errorf("expected a variable name")
+		}
+
+		return p.vars[name], nil
+	case c == '"':
+		return p.stringValue()
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.numberValue()
+	case c == '[':
+		p.pos++
+
+		list := []interface{}{}
+
+		for {
+			p.skipIgnored()
+
+			if p.peek() == ']' {
+				p.pos++
+				return list, nil
+			}
+
+			item, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+
+			list = append(list, item)
+		}
+	case c == '{':
+		p.pos++
+
+		obj := make(map[string]interface{})
+
+		for {
+			p.skipIgnored()
+
+			if p.peek() == '}' {
+				p.pos++
+				return obj, nil
+			}
+
+			key := p.name()
+			if key == "" {
+				return nil, p.errorf("expected an object field name")
+			}
+
+			p.skipIgnored()
+
+			if err := p.expect(':'); err != nil {
+				return nil, err
+			}
+
+			item, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+
+			obj[key] = item
+		}
+	case isGraphQLNameStart(c):
+		switch name := p.name(); name {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		default:
+			return name, nil // enum values are passed through by name.
+		}
+	default:
+		return nil, p.errorf("unexpected character %q", c)
+	}
+}
+
+// stringValue reads a quoted string, decoding the escape sequences of the
+// GraphQL spec, which are not those of Go.
+func (p *gqlParser) stringValue() (string, error) {
+	p.pos++ // '"'
+
+	var sb strings.Builder
+
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; c {
+		case '"':
+			p.pos++
+			return sb.String(), nil
+		case '\\':
+			p.pos++
+
+			if err := p.escapeSequence(&sb); err != nil {
+				return "", err
+			}
+		case '\n', '\r':
+			return "", p.errorf("unterminated string")
+		default:
+			sb.WriteByte(c)
+			p.pos++
+		}
+	}
+
+	return "", p.errorf("unterminated string")
+}
+
+// gqlEscapes maps the characters of single-character escape sequences to what
+// they stand for.
+var gqlEscapes = map[byte]byte{'"': '"', '\\': '\\', '/': '/', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t'}
+
+// escapeSequence decodes the escape sequence after a backslash into sb. A
+// leading surrogate must be followed by the escape of a trailing one.
+func (p *gqlParser) escapeSequence(sb *strings.Builder) error {
+	c := p.peek()
+
+	if r, ok := gqlEscapes[c]; ok {
+		sb.WriteByte(r)
+		p.pos++
+
+		return nil
+	}
+
+	if c != 'u' {
+		return p.errorf("invalid escape sequence")
+	}
+
+	p.pos++
+
+	r, err := p.unicodeEscape()
+	if err != nil {
+		return err
+	}
+
+	if utf16.IsSurrogate(r) {
+		if r >= 0xdc00 || !strings.HasPrefix(p.src[p.pos:], `\u`) {
+			return p.errorf("invalid unicode surrogate")
+		}
+
+		p.pos += 2
+
+		low, err := p.unicodeEscape()
+		if err != nil {
+			return err
+		}
+
+		if r = utf16.DecodeRune(r, low); r == unicode.ReplacementChar {
+			return p.errorf("invalid unicode surrogate")
+		}
+	}
+
+	sb.WriteRune(r)
+
+	return nil
+}
+
+// unicodeEscape reads the code point of a \uXXXX or \u{X...} escape.
+func (p *gqlParser) unicodeEscape() (rune, error) {
+	digits, bits := "", 16
+
+	if p.peek() == '{' {
+		end := strings.IndexByte(p.src[p.pos:], '}')
+		if end < 0 {
+			return 0, p.errorf("invalid unicode escape")
+		}
+
+		digits, bits = p.src[p.pos+1:p.pos+end], 32
+		p.pos += end + 1
+	} else if p.pos+4 <= len(p.src) {
+		digits = p.src[p.pos : p.pos+4]
+		p.pos += 4
+	}
+
+	v, err := strconv.ParseUint(digits, 16, bits)
+	if err != nil || v > unicode.MaxRune {
+		return 0, p.errorf("invalid unicode escape")
+	}
+
+	return rune(v), nil
+}
+
+func (p *gqlParser) numberValue() (json.Number, error) {
+	start := p.pos
+
+	if p.peek() == '-' {
+		p.pos++
+	}
+
+	for p.pos < len(p.src) && strings.IndexByte("0123456789.eE+-", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+
+	n := json.Number(p.src[start:p.pos])
+	if _, err := n.Float64(); err != nil {
+		return "", p.errorf("invalid number %q", string(n))
+	}
+
+	return n, nil
+}
+
+// skipVariableDefinitions skips "($id: ID!, $n: Int = 1)"; variable values are
+// taken from the request as sent, so definitions only need to be well formed.
+func (p *gqlParser) skipVariableDefinitions() error {
+	depth := 0
+
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+
+			if depth == 0 {
+				p.pos++
+				p.skipIgnored()
+
+				return nil
+			}
+		}
+
+		p.pos++
+	}
+
+	return p.errorf("unterminated variable definitions")
+}
+
+func (p *gqlParser) name() string {
+	start := p.pos
+
+	if p.pos < len(p.src) && isGraphQLNameStart(p.src[p.pos]) {
+		p.pos++
+
+		for p.pos < len(p.src) && (isGraphQLNameStart(p.src[p.pos]) || unicode.IsDigit(rune(p.src[p.pos]))) {
+			p.pos++
+		}
+	}
+
+	return p.src[start:p.pos]
+}
+
+func (p *gqlParser) expect(c byte) error {
+	p.skipIgnored()
+
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+
+	p.pos++
+
+	return nil
+}
+
+func (p *gqlParser) peek() byte {
+	if p.pos >= len(p.src) {
+		return 0
+	}
+
+	return p.src[p.pos]
+}
+
+// skipIgnored skips whitespace, commas and comments, which GraphQL treats as insignificant.
+func (p *gqlParser) skipIgnored() {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *gqlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("syntax error at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func isGraphQLNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isGraphQLName(s string) bool {
+	p := &gqlParser{src: s}
+	return s != "" && p.name() == s
+}
@@ -0,0 +1,223 @@
+package kono
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+	"golang.org/x/sync/semaphore"
+)
+
+var _ = Describe("parseGraphQLQuery", func() {
+	It("parses aliases, arguments, variables and nested selections", func() {
+		fields, err := parseGraphQLQuery(`
+			query GetUser($id: ID!) {
+				me: user(id: $id, active: true, role: ADMIN) { id name # trailing comment
+					friends { name } }
+				orders(limit: 10, tags: ["a", "b"]) { total }
+			}`, map[string]interface{}{"id": "42"}, 0, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fields).To(HaveLen(2))
+
+		user := fields[0]
+		Expect(user.key()).To(Equal("me"))
+		Expect(user.name).To(Equal("user"))
+		Expect(user.args).To(Equal(map[string]interface{}{"id": "42", "active": true, "role": "ADMIN"}))
+		Expect(user.selection).To(HaveLen(3))
+		Expect(user.selection[2].selection[0].name).To(Equal("name"))
+
+		orders := fields[1]
+		Expect(orders.args["limit"]).To(Equal(json.Number("10")))
+		Expect(orders.args["tags"]).To(Equal([]interface{}{"a", "b"}))
+	})
+
+	It("accepts the shorthand query form", func() {
+		fields, err := parseGraphQLQuery(`{ user(name: "a\"b") { id } }`, nil, 0, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fields[0].args["name"]).To(Equal(`a"b`))
+	})
+
+	DescribeTable("rejects unsupported or malformed queries",
+		func(query, message string) {
+			_, err := parseGraphQLQuery(query, nil, 3, 4)
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("mutation", `mutation { createUser { id } }`, "mutation operations are not supported"),
+		Entry("fragment", `{ user { ...f } }`, "fragments are not supported"),
+		Entry("directive", `{ user @skip(if: true) { id } }`, "directives are not supported"),
+		Entry("empty selection", `{ user { } }`, "selection set must not be empty"),
+		Entry("unterminated", `{ user { id }`, "expected a field name"),
+		Entry("two operations", `{ a } { b }`, "only a single operation is supported"),
+		Entry("too deep", `{ a { b { c { d } } } }`, "maximum depth of 3"),
+		Entry("too many fields", `{ a b c d e }`, "maximum of 4 fields"),
+		Entry("Go escape", `{ user(name: "\x41") { id } }`, "invalid escape sequence"),
+		Entry("lone surrogate", `{ user(name: "\ud83d") { id } }`, "invalid unicode surrogate"),
+	)
+
+	It("decodes GraphQL string escapes", func() {
+		fields, err := parseGraphQLQuery(`{ user(name: "\/a\u00e9\u{1F600}\ud83d\ude00\t") { id } }`, nil, 0, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fields[0].args["name"]).To(Equal("/a\u00e9\U0001F600\U0001F600\t"))
+	})
+})
+
+var _ = Describe("graphqlEndpoint", func() {
+	var (
+		endpoint  *graphqlEndpoint
+		gotOrders *http.Request
+	)
+
+	writeData := func(w http.ResponseWriter, data string) {
+		_ = json.NewEncoder(w).Encode(ClientResponse{Data: json.RawMessage(data)})
+	}
+
+	BeforeEach(func() {
+		mux := chi.NewMux()
+		mux.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+			writeData(w, `{"id":`+chi.URLParam(r, "id")+`,"name":"ann","email":"a@x","friends":[{"name":"bob","id":2}]}`)
+		})
+		mux.Get("/orders", func(w http.ResponseWriter, r *http.Request) {
+			gotOrders = r
+			writeData(w, `[{"total":10,"state":"paid"},{"total":20,"state":"new"}]`)
+		})
+		mux.Get("/broken", func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+			_ = json.NewEncoder(w).Encode(ClientResponse{Errors: []ClientError{ClientErrUpstreamUnavailable}})
+		})
+
+		var err error
+		endpoint, err = newGraphQLEndpoint(GraphQLConfig{Fields: []GraphQLFieldConfig{
+			{Name: "user", Method: http.MethodGet, Path: "/users/{id}"},
+			{Name: "orders", Method: http.MethodGet, Path: "/orders"},
+			{Name: "broken", Method: http.MethodGet, Path: "/broken"},
+		}}, mux, zap.NewNop())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	post := func(query string, vars map[string]interface{}) (int, string) {
+		body, _ := json.Marshal(graphqlRequest{Query: query, Variables: vars})
+
+		req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(body)))
+		req.Header.Set("X-Tenant", "acme")
+
+		rec := httptest.NewRecorder()
+		endpoint.ServeHTTP(rec, req)
+
+		raw, _ := io.ReadAll(rec.Body)
+
+		return rec.Code, string(raw)
+	}
+
+	It("resolves several flows in one query and keeps only the selected fields", func() {
+		code, body := post(`query($id: ID!) {
+			me: user(id: $id) { name friends { name } }
+			orders(state: "paid") { total }
+		}`, map[string]interface{}{"id": 7})
+
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(MatchJSON(`{"data":{
+			"me":{"name":"ann","friends":[{"name":"bob"}]},
+			"orders":[{"total":10},{"total":20}]
+		}}`))
+		Expect(strings.Index(body, `"me"`)).To(BeNumerically("<", strings.Index(body, `"orders"`)))
+
+		Expect(gotOrders.URL.Query()).To(Equal(url.Values{"state": {"paid"}}))
+		Expect(gotOrders.Header.Get("X-Tenant")).To(Equal("acme"))
+	})
+
+	It("reports failed fields as errors and keeps the others", func() {
+		code, body := post(`{ user(id: 1) { id } broken { id } missing }`, nil)
+
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(MatchJSON(`{
+			"data":{"user":{"id":1},"broken":null,"missing":null},
+			"errors":[
+				{"message":"UPSTREAM_UNAVAILABLE","path":["broken"]},
+				{"message":"cannot query field \"missing\" on type \"Query\"","path":["missing"]}
+			]
+		}`))
+	})
+
+	It("reports missing path arguments", func() {
+		_, body := post(`{ user { id } }`, nil)
+		Expect(body).To(ContainSubstring("missing required arguments: id"))
+	})
+
+	It("answers GET queries", func() {
+		req := httptest.NewRequest(http.MethodGet, "/graphql?query="+url.QueryEscape(`{ user(id: 3) { id } }`), nil)
+		rec := httptest.NewRecorder()
+		endpoint.ServeHTTP(rec, req)
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(MatchJSON(`{"data":{"user":{"id":3}}}`))
+	})
+
+	It("rejects syntax errors with 400", func() {
+		code, body := post(`{ user(`, nil)
+
+		Expect(code).To(Equal(http.StatusBadRequest))
+		Expect(body).To(ContainSubstring("syntax error"))
+	})
+})
+
+var _ = Describe("registerGraphQL", func() {
+	It("dispatches fields through the router flows", func() {
+		d := &mockScatter{results: []upstreamResponse{{status: http.StatusOK, body: []byte(`{"id":1,"name":"ann"}`)}}}
+
+		r := newTestRouter([]flow{{
+			path:        "/users/{id}",
+			method:      http.MethodGet,
+			aggregation: aggregation{strategy: strategyMerge},
+		}}, d, &defaultAggregator{})
+
+		Expect(r.registerGraphQL(GraphQLConfig{
+			Path:   "/graphql",
+			Fields: []GraphQLFieldConfig{{Name: "user", Method: http.MethodGet, Path: "/users/{id}"}},
+		})).To(Succeed())
+
+		req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ user(id: 1) { name } }"}`))
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(MatchJSON(`{"data":{"user":{"name":"ann"}}}`))
+	})
+
+	It("admits each field as a request of its own", func() {
+		d := &mockScatter{results: []upstreamResponse{{status: http.StatusOK, body: []byte(`{"id":1}`)}}}
+
+		r := newTestRouter([]flow{{
+			path:        "/users/{id}",
+			method:      http.MethodGet,
+			aggregation: aggregation{strategy: strategyMerge},
+		}}, d, &defaultAggregator{})
+		r.inFlight = semaphore.NewWeighted(1)
+
+		Expect(r.registerGraphQL(GraphQLConfig{
+			Path:   "/graphql",
+			Fields: []GraphQLFieldConfig{{Name: "user", Method: http.MethodGet, Path: "/users/{id}"}},
+		})).To(Succeed())
+
+		// The GraphQL request holds the only slot, so its field is refused, not queued.
+		req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ user(id: 1) { id } }"}`))
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(MatchJSON(`{"data":{"user":null},"errors":[{"message":"OVERLOADED","path":["user"]}]}`))
+	})
+
+	It("rejects a path taken by a flow", func() {
+		r := newTestRouter([]flow{{path: "/graphql", method: http.MethodPost}}, nil, nil)
+
+		err := r.registerGraphQL(GraphQLConfig{Path: "/graphql"})
+		Expect(err).To(MatchError(ContainSubstring("conflicts with flow")))
+	})
+})
@@ -0,0 +1,267 @@
+package kono
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jmespath/go-jmespath"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const upstreamTypeGRPC = "grpc"
+
+// grpcUpstream translates the client request into a unary gRPC call described by
+// a descriptor set and hands the JSON-encoded reply to aggregation.
+type grpcUpstream struct {
+	upstreamName   string
+	fullMethod     string // "/pkg.Service/Method", as used on the wire.
+	method         protoreflect.MethodDescriptor
+	timeout        time.Duration
+	forwardHeaders []string
+	forwardParams  []string
+	selector       *jmespath.JMESPath
+
+	conns   []*grpc.ClientConn
+	next    atomic.Uint64
+	limiter concurrencyLimiter
+
+	log *zap.Logger
+}
+
+func buildGRPCUpstream(cfg UpstreamConfig, selector *jmespath.JMESPath, tlsConfig *tls.Config, log *zap.Logger) (*grpcUpstream, error) {
+	if cfg.GRPC == nil {
+		return nil, errors.New("grpc upstream requires a grpc config")
+	}
+
+	md, err := loadProtoMethod(cfg.GRPC.DescriptorSet, cfg.GRPC.Method)
+	if err != nil {
+		return nil, fmt.Errorf("load grpc method: %w", err)
+	}
+
+	creds := credentials.NewTLS(tlsConfig)
+	if cfg.GRPC.Insecure {
+		creds = insecure.NewCredentials()
+	}
+
+	conns := make([]*grpc.ClientConn, 0, len(cfg.Hosts))
+
+	for _, host := range cfg.Hosts {
+		conn, dialErr := grpc.NewClient(host, grpc.WithTransportCredentials(creds))
+		if dialErr != nil {
+			return nil, fmt.Errorf("create grpc client for %q: %w", host, dialErr)
+		}
+
+		conns = append(conns, conn)
+	}
+
+	name := cfg.Name
+	if name == "" {
+		name = makeUpstreamName(upstreamTypeGRPC, cfg.Hosts)
+	}
+
+	return &grpcUpstream{
+		upstreamName:   name,
+		fullMethod:     "/" + cfg.GRPC.Method,
+		method:         md,
+		timeout:        cfg.Timeout,
+		forwardHeaders: cfg.ForwardHeaders,
+		forwardParams:  cfg.ForwardParams,
+		selector:       selector,
+		conns:          conns,
+		limiter:        buildConcurrencyLimiter(cfg),
+		log:            log,
+	}, nil
+}
+
+func (u *grpcUpstream) name() string { return u.upstreamName }
+
+func (u *grpcUpstream) call(ctx context.Context, original *http.Request, originalBody []byte) (resp *upstreamResponse) {
+	if !acquireSlot(u.limiter) {
+		return overloadedResponse()
+	}
+
+	start := time.Now()
+	defer func() { releaseSlot(u.limiter, time.Since(start), resp) }()
+
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	in, err := u.buildInput(original, originalBody)
+	if err != nil {
+		return &upstreamResponse{err: &upstreamError{kind: upstreamInternal, err: err}}
+	}
+
+	idx := 0
+	if len(u.conns) > 1 {
+		idx = int((u.next.Add(1) - 1) % uint64(len(u.conns)))
+	}
+
+	conn := u.conns[idx]
+
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("rpc.system", "grpc"),
+		attribute.String("rpc.method", u.fullMethod),
+		attribute.String("kono.upstream.host", conn.Target()),
+	)
+
+	ctx = metadata.NewOutgoingContext(ctx, u.outgoingMetadata(ctx, original))
+
+	out := dynamicpb.NewMessage(u.method.Output())
+
+	if err = conn.Invoke(ctx, u.fullMethod, in, out); err != nil {
+		st := status.Convert(err)
+
+		u.log.Error("grpc call failed",
+			zap.String("upstream", u.upstreamName),
+			zap.String("code", st.Code().String()),
+			zap.String("message", st.Message()),
+		)
+
+		return &upstreamResponse{
+			status: grpcHTTPStatus(st.Code()),
+			err:    &upstreamError{kind: classifyGRPCCode(st.Code()), err: err},
+		}
+	}
+
+	body, err := protojson.Marshal(out)
+	if err != nil {
+		return &upstreamResponse{err: &upstreamError{kind: upstreamInternal, err: fmt.Errorf("marshal grpc response: %w", err)}}
+	}
+
+	body, uerr := projectBody(u.selector, body)
+	if uerr != nil {
+		return &upstreamResponse{err: uerr}
+	}
+
+	return &upstreamResponse{
+		status:  http.StatusOK,
+		headers: http.Header{"Content-Type": []string{"application/json"}},
+		body:    body,
+	}
+}
+
+// buildInput decodes the client JSON body into the method's input message. Forwarded
+// path params fill fields of the same JSON name that the body left unset.
+func (u *grpcUpstream) buildInput(original *http.Request, body []byte) (*dynamicpb.Message, error) {
+	fields := make(map[string]interface{})
+
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return nil, fmt.Errorf("request body must be a JSON object: %w", err)
+		}
+	}
+
+	if rctx := chi.RouteContext(original.Context()); rctx != nil && len(u.forwardParams) > 0 {
+		forwardAll := len(u.forwardParams) == 1 && u.forwardParams[0] == "*"
+
+		for i, key := range rctx.URLParams.Keys {
+			if key == "*" || (!forwardAll && !slices.Contains(u.forwardParams, key)) {
+				continue
+			}
+
+			if _, set := fields[key]; !set {
+				fields[key] = rctx.URLParams.Values[i]
+			}
+		}
+	}
+
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("encode grpc request: %w", err)
+	}
+
+	in := dynamicpb.NewMessage(u.method.Input())
+	if err = (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(raw, in); err != nil {
+		return nil, fmt.Errorf("map request to %s: %w", u.method.Input().FullName(), err)
+	}
+
+	return in, nil
+}
+
+// outgoingMetadata forwards configured client headers and the trace context.
+func (u *grpcUpstream) outgoingMetadata(ctx context.Context, original *http.Request) metadata.MD {
+	md := metadata.MD{}
+
+	for _, h := range u.forwardHeaders {
+		if _, hop := hopByHopHeaders[http.CanonicalHeaderKey(h)]; hop {
+			continue
+		}
+
+		if values := original.Header.Values(h); len(values) > 0 {
+			md.Append(strings.ToLower(h), values...)
+		}
+	}
+
+	carrier := propagation.HeaderCarrier(http.Header{})
+	tracePropagatorFromContext(ctx).Inject(ctx, carrier)
+
+	for _, k := range carrier.Keys() {
+		md.Set(strings.ToLower(k), carrier.Get(k))
+	}
+
+	return md
+}
+
+func classifyGRPCCode(code codes.Code) upstreamErrorKind {
+	switch code {
+	case codes.DeadlineExceeded:
+		return upstreamTimeout
+	case codes.Canceled:
+		return upstreamCanceled
+	case codes.Unavailable:
+		return upstreamConnection
+	default:
+		return upstreamBadStatus
+	}
+}
+
+// grpcHTTPStatus maps gRPC status codes to HTTP statuses as grpc-gateway does.
+func grpcHTTPStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.Canceled, codes.Unknown, codes.Internal, codes.DataLoss:
+		return http.StatusInternalServerError
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,135 @@
+package kono
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var _ = Describe("grpcUpstream", func() {
+	var (
+		descriptorSet string
+		addr          string
+		server        *grpc.Server
+		gotMetadata   metadata.MD
+	)
+
+	BeforeEach(func() {
+		descriptorSet = writeTestDescriptorSet()
+
+		md, err := loadProtoMethod(descriptorSet, "test.v1.Users/GetUser")
+		Expect(err).NotTo(HaveOccurred())
+
+		// Serves test.v1.Users/GetUser without generated code: id 404 yields NotFound,
+		// any other id is echoed back with a fixed name.
+		server = grpc.NewServer(grpc.UnknownServiceHandler(func(_ any, stream grpc.ServerStream) error {
+			gotMetadata, _ = metadata.FromIncomingContext(stream.Context())
+
+			in := dynamicpb.NewMessage(md.Input())
+			if err := stream.RecvMsg(in); err != nil {
+				return err
+			}
+
+			id := in.Get(md.Input().Fields().ByName("id")).Int()
+			if id == 404 {
+				return status.Error(codes.NotFound, "no such user")
+			}
+
+			out := dynamicpb.NewMessage(md.Output())
+			out.Set(md.Output().Fields().ByName("id"), protoreflect.ValueOfInt64(id))
+			out.Set(md.Output().Fields().ByName("name"), protoreflect.ValueOfString("ann"))
+
+			return stream.SendMsg(out)
+		}))
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		addr = lis.Addr().String()
+
+		go func() { _ = server.Serve(lis) }()
+	})
+
+	AfterEach(func() {
+		server.Stop()
+	})
+
+	newUpstream := func() *grpcUpstream {
+		u, err := buildGRPCUpstream(UpstreamConfig{
+			Name:           "users",
+			Hosts:          AddrList{addr},
+			Timeout:        time.Second,
+			ForwardHeaders: []string{"X-Tenant"},
+			ForwardParams:  []string{"id"},
+			GRPC: &GRPCUpstreamConfig{
+				DescriptorSet: descriptorSet,
+				Method:        "test.v1.Users/GetUser",
+				Insecure:      true,
+			},
+		}, nil, nil, zap.NewNop())
+		Expect(err).NotTo(HaveOccurred())
+
+		return u
+	}
+
+	withParam := func(req *http.Request, key, value string) *http.Request {
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add(key, value)
+
+		return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	}
+
+	It("maps path params into the request and the reply to JSON", func() {
+		req := withParam(httptest.NewRequest(http.MethodGet, "/users/7", nil), "id", "7")
+		req.Header.Set("X-Tenant", "acme")
+
+		resp := newUpstream().call(context.Background(), req, nil)
+
+		Expect(resp.err).To(BeNil())
+		Expect(resp.status).To(Equal(http.StatusOK))
+		Expect(resp.body).To(MatchJSON(`{"id":"7","name":"ann"}`))
+		Expect(gotMetadata.Get("x-tenant")).To(ConsistOf("acme"))
+	})
+
+	It("decodes the JSON request body into the input message", func() {
+		req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"id":9}`))
+
+		resp := newUpstream().call(context.Background(), req, []byte(`{"id":9}`))
+
+		Expect(resp.err).To(BeNil())
+		Expect(resp.body).To(MatchJSON(`{"id":"9","name":"ann"}`))
+	})
+
+	It("maps gRPC status codes to upstream errors", func() {
+		req := withParam(httptest.NewRequest(http.MethodGet, "/users/404", nil), "id", "404")
+
+		resp := newUpstream().call(context.Background(), req, nil)
+
+		Expect(resp.err).NotTo(BeNil())
+		Expect(resp.err.kind).To(Equal(upstreamBadStatus))
+		Expect(resp.status).To(Equal(http.StatusNotFound))
+	})
+
+	It("rejects unknown methods at build time", func() {
+		_, err := buildGRPCUpstream(UpstreamConfig{
+			Name:  "users",
+			Hosts: AddrList{addr},
+			GRPC:  &GRPCUpstreamConfig{DescriptorSet: descriptorSet, Method: "test.v1.Users/Missing"},
+		}, nil, nil, zap.NewNop())
+
+		Expect(err).To(MatchError(ContainSubstring(`has no method "Missing"`)))
+	})
+})
@@ -0,0 +1,64 @@
+package kono
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// hashRingReplicas is the number of virtual nodes per host; more nodes spread keys
+// more evenly at the cost of a larger ring.
+const hashRingReplicas = 160
+
+// hashRing maps keys to hosts by consistent hashing. Virtual nodes are derived
+// from the host address, so adding or removing a host only moves the keys that
+// hashed to that host.
+type hashRing struct {
+	points []uint32
+	hosts  []int64 // hosts[i] is the host index owning points[i].
+}
+
+func newHashRing(hosts []string) *hashRing {
+	type point struct {
+		hash uint32
+		host int64
+	}
+
+	all := make([]point, 0, len(hosts)*hashRingReplicas)
+
+	for i, host := range hosts {
+		for v := range hashRingReplicas {
+			all = append(all, point{hash: crc32.ChecksumIEEE([]byte(host + "#" + strconv.Itoa(v))), host: int64(i)})
+		}
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].hash < all[j].hash })
+
+	r := &hashRing{
+		points: make([]uint32, len(all)),
+		hosts:  make([]int64, len(all)),
+	}
+
+	for i, p := range all {
+		r.points[i], r.hosts[i] = p.hash, p.host
+	}
+
+	return r
+}
+
+// lookup returns the index of the host owning key: the first virtual node
+// clockwise from the key's hash.
+func (r *hashRing) lookup(key string) int64 {
+	if len(r.points) == 0 {
+		return 0
+	}
+
+	h := crc32.ChecksumIEEE([]byte(key))
+
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+
+	return r.hosts[i]
+}
@@ -0,0 +1,43 @@
+package kono
+
+import (
+	"strconv"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("hashRing", func() {
+	hosts := []string{"http://a:80", "http://b:80", "http://c:80", "http://d:80"}
+
+	It("maps a key to the same host every time", func() {
+		ring := newHashRing(hosts)
+		Expect(ring.lookup("user-42")).To(Equal(ring.lookup("user-42")))
+	})
+
+	It("spreads keys across all hosts", func() {
+		ring := newHashRing(hosts)
+
+		counts := make(map[int64]int)
+		for i := range 4000 {
+			counts[ring.lookup("key-"+strconv.Itoa(i))]++
+		}
+
+		Expect(counts).To(HaveLen(len(hosts)))
+		for _, c := range counts {
+			Expect(c).To(BeNumerically("~", 1000, 350))
+		}
+	})
+
+	It("only moves the keys of a removed host", func() {
+		before := newHashRing(hosts)
+		after := newHashRing(hosts[:3])
+
+		for i := range 2000 {
+			key := "key-" + strconv.Itoa(i)
+			if owner := before.lookup(key); owner != 3 {
+				Expect(after.lookup(key)).To(Equal(owner), key)
+			}
+		}
+	})
+})
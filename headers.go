@@ -0,0 +1,260 @@
+package kono
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// hopByHopHeaders are connection-scoped (RFC 9110, section 7.6.1). They are
+// dropped in both directions, together with any header the Connection header names.
+var hopByHopHeaders = map[string]struct{}{
+	"Connection":          {},
+	"Proxy-Connection":    {},
+	"Keep-Alive":          {},
+	"Proxy-Authenticate":  {},
+	"Proxy-Authorization": {},
+	"Te":                  {},
+	"Trailer":             {},
+	"Transfer-Encoding":   {},
+	"Upgrade":             {},
+}
+
+// serverOwnedHeaders are written by the gateway's own HTTP server and never
+// copied from an upstream response.
+var serverOwnedHeaders = map[string]struct{}{
+	"Content-Length": {},
+	"Date":           {},
+	"Server":         {},
+}
+
+// clientAddressHeaders describe the client or the proxies in front of the
+// gateway. They are only honored from trusted proxies; every X-Forwarded-*
+// header is treated the same way.
+var clientAddressHeaders = map[string]struct{}{
+	"Forwarded":                {},
+	"X-Real-Ip":                {},
+	"X-Client-Ip":              {},
+	"X-Original-Forwarded-For": {},
+}
+
+// removeHopByHopHeaders deletes hop-by-hop headers from h, including those named
+// by the Connection header of src.
+func removeHopByHopHeaders(h, src http.Header) {
+	for _, field := range src.Values("Connection") {
+		for _, name := range strings.Split(field, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+
+	for name := range hopByHopHeaders {
+		delete(h, name)
+	}
+}
+
+// copyResponseHeaders adds the upstream response headers to dst, skipping
+// hop-by-hop and server-owned headers.
+func copyResponseHeaders(dst, src http.Header) {
+	filtered := src.Clone()
+	removeHopByHopHeaders(filtered, src)
+
+	for k, vv := range filtered {
+		if _, skip := serverOwnedHeaders[k]; skip {
+			continue
+		}
+
+		for _, v := range vv {
+			dst.Add(k, v)
+		}
+	}
+}
+
+// removeClientAddressHeaders deletes the headers an untrusted client could use
+// to pose as someone else before the gateway sets its own forwarding headers.
+func removeClientAddressHeaders(h http.Header) {
+	for name := range h {
+		if _, ok := clientAddressHeaders[name]; ok || strings.HasPrefix(name, "X-Forwarded-") {
+			delete(h, name)
+		}
+	}
+}
+
+// extractClientIP resolves the real client IP. Forwarding headers are honored only
+// when the direct peer is a trusted proxy: X-Forwarded-For is walked from the right,
+// skipping trusted hops, then X-Real-IP is consulted; otherwise the peer address wins.
+func extractClientIP(r *http.Request, trusted []*net.IPNet) string {
+	peer := remoteHost(r)
+
+	if !isLocalPeer(r) && !isTrustedIP(net.ParseIP(peer), trusted) {
+		return peer
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+
+			ip := net.ParseIP(hop)
+			if ip == nil {
+				break
+			}
+
+			if i == 0 || !isTrustedIP(ip, trusted) {
+				return hop
+			}
+		}
+	}
+
+	if xrip := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(xrip) != nil {
+		return xrip
+	}
+
+	return peer
+}
+
+// remoteHost returns the host part of the request's RemoteAddr, or the loopback
+// address for a local peer.
+func remoteHost(r *http.Request) string {
+	if isLocalPeer(r) {
+		return "127.0.0.1"
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// isLocalPeer reports whether the request came over a unix socket. Its peer has
+// no IP address but a socket path, "@" when unnamed: it is a process on this
+// host, such as a sidecar proxy, and is trusted like a configured proxy.
+func isLocalPeer(r *http.Request) bool {
+	return strings.HasPrefix(r.RemoteAddr, "@") || strings.HasPrefix(r.RemoteAddr, "/")
+}
+
+func isTrustedIP(ip net.IP, trusted []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, cidr := range trusted {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type headerConflict uint8
+
+const (
+	headerConflictLast headerConflict = iota
+	headerConflictFirst
+	headerConflictAppend
+)
+
+// headerPolicy filters and merges the upstream headers of an aggregated response.
+// The zero value copies every header and lets the last upstream win.
+type headerPolicy struct {
+	allow    []string // canonical names or "Prefix-*" patterns; empty allows all.
+	deny     []string
+	conflict headerConflict
+}
+
+func compileHeaderPolicy(cfg *ResponseHeadersConfig) (headerPolicy, error) {
+	if cfg == nil {
+		return headerPolicy{}, nil
+	}
+
+	p := headerPolicy{
+		allow: canonicalHeaderPatterns(cfg.Allow),
+		deny:  canonicalHeaderPatterns(cfg.Deny),
+	}
+
+	switch cfg.OnConflict {
+	case "", "last":
+		p.conflict = headerConflictLast
+	case "first":
+		p.conflict = headerConflictFirst
+	case "append":
+		p.conflict = headerConflictAppend
+	default:
+		return headerPolicy{}, fmt.Errorf("unknown header conflict policy %q", cfg.OnConflict)
+	}
+
+	return p, nil
+}
+
+func canonicalHeaderPatterns(names []string) []string {
+	patterns := make([]string, 0, len(names))
+	for _, name := range names {
+		patterns = append(patterns, http.CanonicalHeaderKey(name))
+	}
+
+	return patterns
+}
+
+func matchHeader(patterns []string, name string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			return strings.HasPrefix(name, prefix)
+		}
+
+		return pattern == name
+	})
+}
+
+func (p headerPolicy) allowed(name string) bool {
+	if len(p.allow) > 0 && !matchHeader(p.allow, name) {
+		return false
+	}
+
+	return !matchHeader(p.deny, name)
+}
+
+// merge combines the headers of the successful responses, which arrive in
+// upstream configuration order, so conflicts resolve the same way every time.
+func (p headerPolicy) merge(responses []upstreamResponse) http.Header {
+	merged := make(http.Header)
+
+	for _, resp := range responses {
+		if resp.err != nil {
+			continue
+		}
+
+		for k, v := range resp.headers {
+			if !p.allowed(k) {
+				continue
+			}
+
+			switch _, seen := merged[k]; {
+			case p.conflict == headerConflictAppend:
+				merged[k] = append(merged[k], v...)
+			case p.conflict == headerConflictFirst && seen:
+			default:
+				merged[k] = slices.Clone(v)
+			}
+		}
+	}
+
+	return merged
+}
+
+// filter drops the headers the policy does not allow from h, in place.
+func (p headerPolicy) filter(h http.Header) http.Header {
+	for k := range h {
+		if !p.allowed(k) {
+			delete(h, k)
+		}
+	}
+
+	return h
+}
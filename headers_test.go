@@ -0,0 +1,64 @@
+package kono
+
+import (
+	"net"
+	"net/http"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("headers", func() {
+	Describe("extractClientIP", func() {
+		trusted := []*net.IPNet{mustParseCIDR("10.0.0.0/8")}
+
+		request := func(remoteAddr string, headers map[string]string) *http.Request {
+			req, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+			req.RemoteAddr = remoteAddr
+
+			for k, v := range headers {
+				req.Header.Set(k, v)
+			}
+
+			return req
+		}
+
+		It("ignores forwarding headers from untrusted peers", func() {
+			req := request("1.2.3.4:5555", map[string]string{"X-Forwarded-For": "10.0.0.1", "X-Real-IP": "10.0.0.2"})
+			Expect(extractClientIP(req, trusted)).To(Equal("1.2.3.4"))
+		})
+
+		It("takes the right-most untrusted hop behind trusted proxies", func() {
+			req := request("10.0.0.5:5555", map[string]string{"X-Forwarded-For": "6.6.6.6, 5.6.7.8, 10.0.0.9"})
+			Expect(extractClientIP(req, trusted)).To(Equal("5.6.7.8"))
+		})
+
+		It("falls back to X-Real-IP and then the peer", func() {
+			Expect(extractClientIP(request("10.0.0.5:5555", map[string]string{"X-Real-IP": "5.6.7.8"}), trusted)).To(Equal("5.6.7.8"))
+			Expect(extractClientIP(request("10.0.0.5:5555", nil), trusted)).To(Equal("10.0.0.5"))
+		})
+
+		It("trusts peers on a unix socket", func() {
+			Expect(extractClientIP(request("@", map[string]string{"X-Forwarded-For": "5.6.7.8"}), nil)).To(Equal("5.6.7.8"))
+			Expect(extractClientIP(request("@", nil), nil)).To(Equal("127.0.0.1"))
+		})
+	})
+
+	Describe("copyResponseHeaders", func() {
+		It("drops hop-by-hop, Connection-named and server-owned headers", func() {
+			src := http.Header{}
+			src.Set("Connection", "keep-alive, X-Internal")
+			src.Set("Keep-Alive", "timeout=5")
+			src.Set("Transfer-Encoding", "chunked")
+			src.Set("Te", "trailers")
+			src.Set("X-Internal", "secret")
+			src.Set("Server", "nginx")
+			src.Set("X-Upstream", "kept")
+
+			dst := http.Header{}
+			copyResponseHeaders(dst, src)
+
+			Expect(dst).To(Equal(http.Header{"X-Upstream": {"kept"}}))
+		})
+	})
+})
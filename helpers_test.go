@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -13,6 +15,9 @@ import (
 	. "github.com/onsi/gomega"
 	"go.uber.org/zap"
 	"golang.org/x/sync/semaphore"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
 
 	"github.com/starwalkn/kono/internal/circuitbreaker"
 	"github.com/starwalkn/kono/sdk"
@@ -99,13 +104,43 @@ func (m *mockMiddleware) Handler(next http.Handler) http.Handler {
 	})
 }
 
+// mockAggregator returns the names of the upstreams it was given as a JSON array.
+type mockAggregator struct {
+	cfg      map[string]interface{}
+	received []sdk.UpstreamResponse
+	err      error
+}
+
+func (m *mockAggregator) Init(cfg map[string]interface{}) error { m.cfg = cfg; return nil }
+func (m *mockAggregator) Name() string                          { return "mockagg" }
+func (m *mockAggregator) Aggregate(responses []sdk.UpstreamResponse, _ sdk.Aggregation) (sdk.AggregatedResponse, error) {
+	m.received = responses
+
+	if m.err != nil {
+		return sdk.AggregatedResponse{}, m.err
+	}
+
+	names := make([]string, 0, len(responses))
+	for _, r := range responses {
+		if r.Err == nil {
+			names = append(names, r.Name)
+		}
+	}
+
+	data, err := json.Marshal(names)
+
+	return sdk.AggregatedResponse{Data: data}, err
+}
+
 // ── Scatter mock ──────────────────────────────────────────────────────────────
 
 type mockScatter struct {
 	results []upstreamResponse
+	calls   int
 }
 
 func (m *mockScatter) scatter(_ *flow, _ *http.Request) []upstreamResponse {
+	m.calls++
 	return m.results
 }
 
@@ -142,6 +177,10 @@ func newTestUpstream(host string, opts ...func(*httpUpstream)) *httpUpstream {
 	return u
 }
 
+func withName(name string) func(*httpUpstream) {
+	return func(u *httpUpstream) { u.cfg.name = name }
+}
+
 func withMethod(method string) func(*httpUpstream) {
 	return func(u *httpUpstream) { u.cfg.method = method }
 }
@@ -266,3 +305,99 @@ func decodeJSONResponse(body []byte) ClientResponse {
 func decodeJSONInto(data []byte, dst any) error {
 	return json.Unmarshal(data, dst)
 }
+
+// ── Protobuf helpers ──────────────────────────────────────────────────────────
+
+// writeTestDescriptorSet writes a descriptor set with:
+//
+//	message User { int64 id = 1; string name = 2; }
+//	message Response { User data = 1; repeated string errors = 2; }
+//	message GetUserRequest { int64 id = 1; }
+//	service Users {
+//	  rpc GetUser(GetUserRequest) returns (User) { option (google.api.http) = { get: "/users/{id}" }; }
+//	  rpc CreateUser(User) returns (User) { option (google.api.http) = { post: "/users" body: "*" }; }
+//	}
+func writeTestDescriptorSet() string {
+	field := func(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(num),
+			Type:     typ.Enum(),
+			Label:    label.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+
+		return f
+	}
+
+	httpRule := func(rule *annotations.HttpRule) *descriptorpb.MethodOptions {
+		opts := &descriptorpb.MethodOptions{}
+		proto.SetExtension(opts, annotations.E_Http, rule)
+
+		return opts
+	}
+
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("test/v1/response.proto"),
+		Package: proto.String("test.v1"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("User"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64, optional, ""),
+					field("name", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+				},
+			},
+			{
+				Name: proto.String("Response"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("data", 1, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, optional, ".test.v1.User"),
+					field("errors", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, repeated, ""),
+				},
+			},
+			{
+				Name: proto.String("GetUserRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64, optional, ""),
+				},
+			},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Users"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{
+					Name:       proto.String("GetUser"),
+					InputType:  proto.String(".test.v1.GetUserRequest"),
+					OutputType: proto.String(".test.v1.User"),
+					Options: httpRule(&annotations.HttpRule{
+						Pattern: &annotations.HttpRule_Get{Get: "/users/{id}"},
+					}),
+				},
+				{
+					Name:       proto.String("CreateUser"),
+					InputType:  proto.String(".test.v1.User"),
+					OutputType: proto.String(".test.v1.User"),
+					Options: httpRule(&annotations.HttpRule{
+						Pattern: &annotations.HttpRule_Post{Post: "/users"},
+						Body:    "*",
+					}),
+				},
+			},
+		}},
+	}}}
+
+	raw, err := proto.Marshal(set)
+	Expect(err).NotTo(HaveOccurred())
+
+	path := filepath.Join(GinkgoT().TempDir(), "response.pb")
+	Expect(os.WriteFile(path, raw, 0o600)).To(Succeed())
+
+	return path
+}
@@ -0,0 +1,285 @@
+package kono
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const includeKey = "include"
+
+// readConfigFiles returns the configuration at path as one document. A directory
+// loads every *.yaml and *.yml file in it in lexical order, and top-level
+// include entries pull in further files or globs relative to the including file.
+// Mappings are merged and lists appended, so flows can be split across files; a
+// scalar set to different values in two files is an error. A single file
+// without includes is returned as read, so that line numbers in later errors
+// point into it.
+func readConfigFiles(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		data, readErr := os.ReadFile(path)
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		var doc yaml.Node
+		if yaml.Unmarshal(data, &doc) != nil || !hasIncludes(&doc) {
+			return data, nil
+		}
+	}
+
+	root, err := loadConfigPath(path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if root == nil {
+		return nil, nil
+	}
+
+	return yaml.Marshal(root)
+}
+
+// loadConfigPath returns the merged root mapping of a file or directory, or nil
+// when it holds no documents. stack holds the files being loaded, to catch
+// include cycles.
+func loadConfigPath(path string, stack []string) (*yaml.Node, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return loadConfigFile(path, stack)
+	}
+
+	var files []string
+
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, _ := filepath.Glob(filepath.Join(path, pattern))
+		files = append(files, matches...)
+	}
+
+	slices.Sort(files)
+
+	return mergeConfigFiles(files, stack)
+}
+
+func loadConfigFile(path string, stack []string) (*yaml.Node, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if slices.Contains(stack, abs) {
+		return nil, fmt.Errorf("include cycle: %s", strings.Join(append(stack, abs), " -> "))
+	}
+
+	stack = append(stack, abs)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err = yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if doc.Kind == 0 {
+		return nil, nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: top level must be a mapping", path)
+	}
+
+	patterns, err := takeIncludes(root)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var files []string
+
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+
+		matches, globErr := filepath.Glob(pattern)
+		if globErr != nil {
+			return nil, fmt.Errorf("%s: include %q: %w", path, pattern, globErr)
+		}
+
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return nil, fmt.Errorf("%s: include %q: %w", path, pattern, os.ErrNotExist)
+		}
+
+		files = append(files, matches...)
+	}
+
+	included, err := mergeConfigFiles(files, stack)
+	if err != nil {
+		return nil, err
+	}
+
+	if included == nil {
+		return root, nil
+	}
+
+	if err = mergeNodes(root, included, ""); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return root, nil
+}
+
+func mergeConfigFiles(files []string, stack []string) (*yaml.Node, error) {
+	var merged *yaml.Node
+
+	for _, file := range files {
+		node, err := loadConfigPath(file, stack)
+		if err != nil {
+			return nil, err
+		}
+
+		if node == nil {
+			continue
+		}
+
+		if merged == nil {
+			merged = node
+			continue
+		}
+
+		if err = mergeNodes(merged, node, ""); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+
+	return merged, nil
+}
+
+// takeIncludes removes the include entry from a root mapping and returns its
+// patterns. It accepts a single string or a list of them.
+func takeIncludes(root *yaml.Node) ([]string, error) {
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != includeKey {
+			continue
+		}
+
+		value := root.Content[i+1]
+		root.Content = slices.Delete(root.Content, i, i+2)
+
+		switch value.Kind {
+		case yaml.ScalarNode:
+			return []string{value.Value}, nil
+		case yaml.SequenceNode:
+			patterns := make([]string, 0, len(value.Content))
+
+			for _, item := range value.Content {
+				if item.Kind != yaml.ScalarNode {
+					return nil, errors.New("include entries must be strings")
+				}
+
+				patterns = append(patterns, item.Value)
+			}
+
+			return patterns, nil
+		default:
+			return nil, errors.New("include must be a string or a list of strings")
+		}
+	}
+
+	return nil, nil
+}
+
+// mergeNodes merges src into dst: mapping keys are merged recursively, lists
+// are appended and equal scalars are kept.
+func mergeNodes(dst, src *yaml.Node, path string) error {
+	if dst.Kind != src.Kind {
+		return fmt.Errorf("%s is defined with different types", displayPath(path))
+	}
+
+	switch dst.Kind {
+	case yaml.MappingNode:
+	next:
+		for j := 0; j+1 < len(src.Content); j += 2 {
+			key := src.Content[j].Value
+
+			for i := 0; i+1 < len(dst.Content); i += 2 {
+				if dst.Content[i].Value == key {
+					if err := mergeNodes(dst.Content[i+1], src.Content[j+1], joinPath(path, key)); err != nil {
+						return err
+					}
+
+					continue next
+				}
+			}
+
+			dst.Content = append(dst.Content, src.Content[j], src.Content[j+1])
+		}
+	case yaml.SequenceNode:
+		dst.Content = append(dst.Content, src.Content...)
+	default:
+		if dst.Value != src.Value {
+			return fmt.Errorf("%s is set to both %q and %q", displayPath(path), dst.Value, src.Value)
+		}
+	}
+
+	return nil
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "the document"
+	}
+
+	return path
+}
+
+// rejectIncludes reports include entries in documents that were not read from
+// the local filesystem, where there is nothing to resolve them against.
+func rejectIncludes(doc *yaml.Node) error {
+	if hasIncludes(doc) {
+		return errors.New("include is only supported for local configuration files")
+	}
+
+	return nil
+}
+
+// hasIncludes reports whether the root mapping of doc has an include entry.
+func hasIncludes(doc *yaml.Node) bool {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return false
+	}
+
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == includeKey {
+			return true
+		}
+	}
+
+	return false
+}
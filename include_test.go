@@ -0,0 +1,113 @@
+package kono
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
+)
+
+var _ = Describe("readConfigFiles", func() {
+	var dir string
+
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		Expect(os.MkdirAll(filepath.Dir(path), 0o755)).To(Succeed())
+		Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+
+		return path
+	}
+
+	read := func(path string) (Config, error) {
+		data, err := readConfigFiles(path)
+		if err != nil {
+			return Config{}, err
+		}
+
+		var cfg Config
+		Expect(yaml.Unmarshal(data, &cfg)).To(Succeed())
+
+		return cfg, nil
+	}
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+	})
+
+	It("merges included files and appends their flows", func() {
+		main := write("kono.yaml", `
+schema: v1
+include:
+  - teams/*.yaml
+gateway:
+  server:
+    port: 8080
+  routing:
+    flows:
+      - path: /health
+`)
+		write("teams/billing.yaml", "gateway: {routing: {flows: [{path: /billing}]}}")
+		write("teams/users.yaml", "gateway: {routing: {flows: [{path: /users}], trusted_proxies: [10.0.0.0/8]}}")
+
+		cfg, err := read(main)
+		Expect(err).NotTo(HaveOccurred())
+
+		paths := make([]string, 0, len(cfg.Gateway.Routing.Flows))
+		for _, f := range cfg.Gateway.Routing.Flows {
+			paths = append(paths, f.Path)
+		}
+
+		Expect(paths).To(Equal([]string{"/health", "/billing", "/users"}))
+		Expect(cfg.Gateway.Server.Port).To(Equal(8080))
+		Expect(cfg.Gateway.Routing.TrustedProxies).To(Equal([]string{"10.0.0.0/8"}))
+	})
+
+	It("returns a file without includes as written", func() {
+		content := `
+schema: v1
+
+gateway:
+  service:
+    name: |
+      kono
+`
+		data, err := readConfigFiles(write("kono.yaml", content))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal(content))
+	})
+
+	It("loads every yaml file of a directory in order", func() {
+		write("00-base.yml", "schema: v1\ngateway: {server: {port: 8080}}")
+		write("10-flows.yaml", "gateway: {routing: {flows: [{path: /a}]}}")
+		write("README.md", "not config")
+
+		cfg, err := read(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.Schema).To(Equal("v1"))
+		Expect(cfg.Gateway.Routing.Flows).To(HaveLen(1))
+	})
+
+	It("rejects conflicting scalars, cycles and missing files", func() {
+		write("a.yaml", "gateway: {server: {port: 8080}}")
+		write("b.yaml", "gateway: {server: {port: 9090}}")
+
+		_, err := read(dir)
+		Expect(err).To(MatchError(ContainSubstring(`gateway.server.port is set to both "8080" and "9090"`)))
+
+		loop := write("loop/a.yaml", "include: b.yaml")
+		write("loop/b.yaml", "include: a.yaml")
+
+		_, err = read(loop)
+		Expect(err).To(MatchError(ContainSubstring("include cycle")))
+
+		_, err = read(write("missing.yaml", "include: nope.yaml"))
+		Expect(err).To(MatchError(os.ErrNotExist))
+	})
+
+	It("refuses includes in documents without a base directory", func() {
+		_, err := ParseConfig([]byte("include: teams/*.yaml"))
+		Expect(err).To(MatchError(ContainSubstring("only supported for local configuration files")))
+	})
+})
@@ -0,0 +1,218 @@
+// Package accesslog writes one line per request handled by the gateway, apart
+// from the application log. Entries are formatted as JSON, in the Apache
+// combined log format, or with a text/template over Entry.
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Formats of the access log.
+const (
+	FormatJSON     = "json"
+	FormatCombined = "combined"
+	FormatTemplate = "template"
+)
+
+// combinedTimeLayout is the timestamp layout of the Apache log formats.
+const combinedTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// Entry describes a finished request.
+type Entry struct {
+	Time      time.Time // when the request was received.
+	Method    string
+	Path      string // request URI, query included.
+	Proto     string
+	Flow      string // route pattern of the flow; empty when no flow matched.
+	Status    int
+	Duration  time.Duration
+	Upstreams []Upstream
+	ClientIP  string
+	RequestID string
+	BytesIn   int64 // request body bytes read.
+	BytesOut  int64 // response body bytes written.
+	UserAgent string
+	Referer   string
+}
+
+// Upstream is the outcome of one upstream call of a request. Error is the error
+// kind of a failed call.
+type Upstream struct {
+	Name     string
+	Status   int
+	Duration time.Duration
+	Error    string
+}
+
+// Logger formats entries and writes each as one line. It is safe for concurrent
+// use.
+type Logger struct {
+	format func(*bytes.Buffer, Entry) error
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+	w   io.Writer
+}
+
+// New returns a Logger writing entries to w in format. tmpl is the template of
+// FormatTemplate and is ignored otherwise.
+func New(format, tmpl string, w io.Writer) (*Logger, error) {
+	l := &Logger{w: w}
+
+	switch format {
+	case FormatJSON, "":
+		l.format = formatJSON
+	case FormatCombined:
+		l.format = formatCombined
+	case FormatTemplate:
+		if tmpl == "" {
+			return nil, errors.New("template format requires a template")
+		}
+
+		t, err := template.New("access_log").Option("missingkey=error").Parse(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("parse template: %w", err)
+		}
+
+		l.format = func(buf *bytes.Buffer, e Entry) error { return t.Execute(buf, e) }
+	default:
+		return nil, fmt.Errorf("unsupported access log format %q", format)
+	}
+
+	return l, nil
+}
+
+// Log writes e. Entries failing to format or write are dropped and the error
+// returned.
+func (l *Logger) Log(e Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buf.Reset()
+
+	if err := l.format(&l.buf, e); err != nil {
+		return fmt.Errorf("format access log entry: %w", err)
+	}
+
+	if b := l.buf.Bytes(); len(b) == 0 || b[len(b)-1] != '\n' {
+		l.buf.WriteByte('\n')
+	}
+
+	if _, err := l.w.Write(l.buf.Bytes()); err != nil {
+		return fmt.Errorf("write access log entry: %w", err)
+	}
+
+	return nil
+}
+
+// Close closes the writer of the Logger if it is an io.Closer.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if c, ok := l.w.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+type jsonUpstream struct {
+	Name       string  `json:"name"`
+	Status     int     `json:"status,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+type jsonEntry struct {
+	Time       string         `json:"time"`
+	Method     string         `json:"method"`
+	Path       string         `json:"path"`
+	Proto      string         `json:"proto"`
+	Flow       string         `json:"flow,omitempty"`
+	Status     int            `json:"status"`
+	DurationMS float64        `json:"duration_ms"`
+	Upstreams  []jsonUpstream `json:"upstreams,omitempty"`
+	ClientIP   string         `json:"client_ip"`
+	RequestID  string         `json:"request_id,omitempty"`
+	BytesIn    int64          `json:"bytes_in"`
+	BytesOut   int64          `json:"bytes_out"`
+	UserAgent  string         `json:"user_agent,omitempty"`
+	Referer    string         `json:"referer,omitempty"`
+}
+
+func formatJSON(buf *bytes.Buffer, e Entry) error {
+	je := jsonEntry{
+		Time:       e.Time.Format(time.RFC3339Nano),
+		Method:     e.Method,
+		Path:       e.Path,
+		Proto:      e.Proto,
+		Flow:       e.Flow,
+		Status:     e.Status,
+		DurationMS: milliseconds(e.Duration),
+		ClientIP:   e.ClientIP,
+		RequestID:  e.RequestID,
+		BytesIn:    e.BytesIn,
+		BytesOut:   e.BytesOut,
+		UserAgent:  e.UserAgent,
+		Referer:    e.Referer,
+	}
+
+	for _, u := range e.Upstreams {
+		je.Upstreams = append(je.Upstreams, jsonUpstream{
+			Name:       u.Name,
+			Status:     u.Status,
+			DurationMS: milliseconds(u.Duration),
+			Error:      u.Error,
+		})
+	}
+
+	return json.NewEncoder(buf).Encode(je)
+}
+
+// formatCombined writes the Apache combined log format:
+//
+//	client - - [time] "method path proto" status bytes "referer" "user agent"
+func formatCombined(buf *bytes.Buffer, e Entry) error {
+	buf.WriteString(dash(e.ClientIP))
+	buf.WriteString(" - - [")
+	buf.WriteString(e.Time.Format(combinedTimeLayout))
+	buf.WriteString("] ")
+	buf.WriteString(strconv.Quote(e.Method + " " + e.Path + " " + e.Proto))
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.Itoa(e.Status))
+	buf.WriteByte(' ')
+
+	if e.BytesOut > 0 {
+		buf.WriteString(strconv.FormatInt(e.BytesOut, 10))
+	} else {
+		buf.WriteByte('-')
+	}
+
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.Quote(dash(e.Referer)))
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.Quote(dash(e.UserAgent)))
+
+	return nil
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / float64(time.Millisecond/time.Microsecond)
+}
@@ -0,0 +1,189 @@
+package accesslog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fileMode = 0o644
+	dirMode  = 0o755
+
+	// backupTimeLayout names rotated files; it sorts in time order.
+	backupTimeLayout = "2006-01-02T15-04-05.000"
+)
+
+// FileOptions configure a rotating log file. The file is rotated when a write
+// would take it past MaxSize bytes or once it has been open for MaxAge; zero
+// disables either limit. Rotated files are renamed to <path>.<time>, and all but
+// the newest MaxBackups of them removed (zero keeps all).
+type FileOptions struct {
+	Path       string
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+}
+
+// rotatingFile is an io.WriteCloser appending to a file it rotates by size and
+// age.
+type rotatingFile struct {
+	opts FileOptions
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+	closed bool
+}
+
+// OpenFile opens opts.Path for appending, creating it if needed.
+func OpenFile(opts FileOptions) (io.WriteCloser, error) {
+	if opts.Path == "" {
+		return nil, errors.New("access log file requires a path")
+	}
+
+	r := &rotatingFile{opts: opts}
+
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	var rotateErr error
+	if r.size > 0 && r.due(len(p)) {
+		rotateErr = r.rotate()
+		if r.f == nil {
+			return 0, rotateErr
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+
+	if err == nil {
+		err = rotateErr
+	}
+
+	return n, err
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+
+	if r.f == nil {
+		return nil
+	}
+
+	err := r.f.Close()
+	r.f = nil
+
+	return err
+}
+
+// due reports whether the file must be rotated before writing n more bytes.
+func (r *rotatingFile) due(n int) bool {
+	if r.opts.MaxSize > 0 && r.size+int64(n) > r.opts.MaxSize {
+		return true
+	}
+
+	return r.opts.MaxAge > 0 && time.Since(r.opened) >= r.opts.MaxAge
+}
+
+func (r *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(r.opts.Path), dirMode); err != nil { //nolint:gosec // access logs are read by log shippers.
+		return fmt.Errorf("create access log directory: %w", err)
+	}
+
+	f, err := os.OpenFile(r.opts.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, fileMode) //nolint:gosec // access logs are read by log shippers.
+	if err != nil {
+		return fmt.Errorf("open access log file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("stat access log file: %w", err)
+	}
+
+	r.f, r.size, r.opened = f, info.Size(), time.Now()
+
+	return nil
+}
+
+// rotate moves the current file aside and opens a new one. When that fails,
+// opts.Path is reopened for appending so logging goes on, and the next attempt
+// waits until the file has grown by another MaxSize or been open another
+// MaxAge; the error is only returned for the write that triggered it.
+func (r *rotatingFile) rotate() error {
+	err := r.f.Close()
+	r.f = nil
+
+	if err != nil {
+		err = fmt.Errorf("close access log file: %w", err)
+	} else if renameErr := os.Rename(r.opts.Path, r.opts.Path+"."+time.Now().Format(backupTimeLayout)); renameErr != nil {
+		err = fmt.Errorf("rotate access log file: %w", renameErr)
+	}
+
+	if openErr := r.open(); openErr != nil {
+		return errors.Join(err, openErr)
+	}
+
+	if err != nil {
+		r.size = 0
+		return err
+	}
+
+	r.prune()
+
+	return nil
+}
+
+// prune removes the oldest rotated files beyond MaxBackups.
+func (r *rotatingFile) prune() {
+	if r.opts.MaxBackups <= 0 {
+		return
+	}
+
+	backups, err := filepath.Glob(r.opts.Path + ".*")
+	if err != nil {
+		return
+	}
+
+	backups = slices.DeleteFunc(backups, func(name string) bool {
+		_, err := time.Parse(backupTimeLayout, strings.TrimPrefix(name, r.opts.Path+"."))
+		return err != nil
+	})
+
+	slices.Sort(backups)
+
+	for len(backups) > r.opts.MaxBackups {
+		_ = os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
@@ -0,0 +1,40 @@
+//go:build !windows && !plan9
+
+package accesslog
+
+import (
+	"fmt"
+	"io"
+	"log/syslog"
+)
+
+// DialSyslog connects to the syslog daemon at address over network ("udp",
+// "tcp", or empty for the local daemon) and sends each entry as a message of
+// informational severity with the given facility and tag.
+func DialSyslog(network, address, facility, tag string) (io.WriteCloser, error) {
+	priority, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("unsupported syslog facility %q", facility)
+	}
+
+	w, err := syslog.Dial(network, address, priority|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, fmt.Errorf("dial syslog: %w", err)
+	}
+
+	return w, nil
+}
+
+var syslogFacilities = map[string]syslog.Priority{
+	"":       syslog.LOG_LOCAL0,
+	"user":   syslog.LOG_USER,
+	"daemon": syslog.LOG_DAEMON,
+	"local0": syslog.LOG_LOCAL0,
+	"local1": syslog.LOG_LOCAL1,
+	"local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3,
+	"local4": syslog.LOG_LOCAL4,
+	"local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6,
+	"local7": syslog.LOG_LOCAL7,
+}
@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package accesslog
+
+import (
+	"errors"
+	"io"
+)
+
+// DialSyslog always fails: log/syslog is not available on this platform.
+func DialSyslog(_, _, _, _ string) (io.WriteCloser, error) {
+	return nil, errors.New("syslog output is not supported on this platform")
+}
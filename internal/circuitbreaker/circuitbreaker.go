@@ -13,25 +13,62 @@ const (
 	HalfOpen
 )
 
+// windowBuckets is the number of buckets a rolling window is divided into; the
+// window slides one bucket at a time.
+const windowBuckets = 10
+
+// Options configures a CircuitBreaker. With a zero Window the breaker opens after
+// MaxFailures consecutive failures. With a Window it opens once at least
+// MinRequests calls were made within the window and more than ErrorRate (0..1) of
+// them failed. After ResetTimeout the breaker lets HalfOpenTrials calls through
+// and closes when all of them succeed.
+type Options struct {
+	MaxFailures    int
+	ResetTimeout   time.Duration
+	Window         time.Duration
+	ErrorRate      float64
+	MinRequests    int
+	HalfOpenTrials int
+}
+
 type CircuitBreaker struct {
 	mu            sync.Mutex
 	state         State
 	failures      int
 	lastFailureAt time.Time
 
-	threshold     int
-	resetTimeout  time.Duration
-	halfOpenTrial bool
+	threshold    int
+	resetTimeout time.Duration
+
+	// Half-open trials allowed through so far and how many of them succeeded.
+	halfOpenTrials    int
+	halfOpenAllowed   int
+	halfOpenSucceeded int
+
+	window      *rollingWindow // nil in consecutive-failure mode.
+	errorRate   float64
+	minRequests int
 }
 
 func New(threshold int, resetTimeout time.Duration) *CircuitBreaker {
-	return &CircuitBreaker{
-		state:         Closed,
-		failures:      0,
-		threshold:     threshold,
-		resetTimeout:  resetTimeout,
-		halfOpenTrial: false,
+	return NewWithOptions(Options{MaxFailures: threshold, ResetTimeout: resetTimeout})
+}
+
+func NewWithOptions(opts Options) *CircuitBreaker {
+	b := &CircuitBreaker{
+		state:          Closed,
+		threshold:      opts.MaxFailures,
+		resetTimeout:   opts.ResetTimeout,
+		halfOpenTrials: max(opts.HalfOpenTrials, 1),
+		errorRate:      opts.ErrorRate,
+		minRequests:    max(opts.MinRequests, 1),
 	}
+
+	if opts.Window > 0 {
+		b.window = newRollingWindow(opts.Window)
+	}
+
+	return b
 }
 
 func (b *CircuitBreaker) Allow() bool {
@@ -42,15 +79,16 @@ func (b *CircuitBreaker) Allow() bool {
 	case Open:
 		if time.Since(b.lastFailureAt) >= b.resetTimeout {
 			b.state = HalfOpen
-			b.halfOpenTrial = false
+			b.halfOpenAllowed = 1
+			b.halfOpenSucceeded = 0
 
 			return true
 		}
 
 		return false
 	case HalfOpen:
-		if !b.halfOpenTrial {
-			b.halfOpenTrial = true
+		if b.halfOpenAllowed < b.halfOpenTrials {
+			b.halfOpenAllowed++
 
 			return true
 		}
@@ -67,13 +105,25 @@ func (b *CircuitBreaker) OnFailure() {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	b.lastFailureAt = time.Now()
+	now := time.Now()
+	b.lastFailureAt = now
 
 	switch b.state {
 	case HalfOpen:
 		b.state = Open
 		b.failures = b.threshold
 	case Closed:
+		if b.window != nil {
+			b.window.record(now, true)
+
+			if b.window.exceeds(now, b.minRequests, b.errorRate) {
+				b.state = Open
+				b.window.reset()
+			}
+
+			return
+		}
+
 		b.failures++
 
 		if b.failures >= b.threshold {
@@ -90,18 +140,88 @@ func (b *CircuitBreaker) OnSuccess() {
 
 	switch b.state {
 	case HalfOpen:
-		b.state = Closed
-		b.failures = 0
+		b.halfOpenSucceeded++
+
+		if b.halfOpenSucceeded >= b.halfOpenTrials {
+			b.state = Closed
+			b.failures = 0
+		}
 	case Closed:
 		b.failures = 0
+
+		if b.window != nil {
+			b.window.record(time.Now(), false)
+		}
 	case Open:
 		// Success while open shouldn't happen — Allow() returns false for Open state.
 	}
 }
 
+// OnNeutral records a call that ended without saying anything about the upstream,
+// such as one canceled by the client. In half-open state its trial is handed back
+// so that another call can take it.
+func (b *CircuitBreaker) OnNeutral() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == HalfOpen && b.halfOpenAllowed > b.halfOpenSucceeded {
+		b.halfOpenAllowed--
+	}
+}
+
 func (b *CircuitBreaker) State() State {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
 	return b.state
 }
+
+// rollingWindow counts calls and failures over the last window, in buckets.
+type rollingWindow struct {
+	width   time.Duration
+	buckets [windowBuckets]bucket
+}
+
+type bucket struct {
+	start    int64 // UnixNano start of the bucket period, zero when unused.
+	total    int
+	failures int
+}
+
+func newRollingWindow(window time.Duration) *rollingWindow {
+	return &rollingWindow{width: max(window/windowBuckets, time.Millisecond)}
+}
+
+func (w *rollingWindow) record(now time.Time, failed bool) {
+	start := now.Truncate(w.width).UnixNano()
+	bk := &w.buckets[(start/int64(w.width))%windowBuckets]
+
+	if bk.start != start {
+		*bk = bucket{start: start}
+	}
+
+	bk.total++
+
+	if failed {
+		bk.failures++
+	}
+}
+
+func (w *rollingWindow) exceeds(now time.Time, minRequests int, errorRate float64) bool {
+	oldest := now.Add(-w.width * windowBuckets).UnixNano()
+
+	var total, failures int
+
+	for _, bk := range w.buckets {
+		if bk.start > oldest {
+			total += bk.total
+			failures += bk.failures
+		}
+	}
+
+	return total >= minRequests && float64(failures) > errorRate*float64(total)
+}
+
+func (w *rollingWindow) reset() {
+	w.buckets = [windowBuckets]bucket{}
+}
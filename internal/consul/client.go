@@ -0,0 +1,75 @@
+// Package consul is a minimal Consul KV HTTP client with blocking queries.
+package consul
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxValueSize = 16 << 20
+
+type Client struct {
+	address string
+	token   string
+	http    *http.Client
+}
+
+// New returns a client for the agent at address, e.g. "http://127.0.0.1:8500".
+// An empty token sends anonymous requests.
+func New(address, token string) *Client {
+	return &Client{
+		address: strings.TrimSuffix(address, "/"),
+		token:   token,
+		http:    &http.Client{},
+	}
+}
+
+// Get returns the raw value of key and its modify index. With index > 0 the
+// call is a blocking query: it returns once the key changes past index or wait
+// elapses, whichever comes first.
+func (c *Client) Get(ctx context.Context, key string, index uint64, wait time.Duration) ([]byte, uint64, error) {
+	query := url.Values{"raw": {""}}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", wait.String())
+	}
+
+	target := c.address + "/v1/kv/" + strings.TrimPrefix(key, "/") + "?" + query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("create request: %w", err)
+	}
+
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("get %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("get %s: consul returned %d", key, resp.StatusCode)
+	}
+
+	value, err := io.ReadAll(io.LimitReader(resp.Body, maxValueSize))
+	if err != nil {
+		return nil, 0, fmt.Errorf("get %s: %w", key, err)
+	}
+
+	modifyIndex, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("get %s: invalid X-Consul-Index: %w", key, err)
+	}
+
+	return value, modifyIndex, nil
+}
@@ -0,0 +1,219 @@
+// Package etcd is a minimal etcd v3 client for reading and watching a single
+// key through the JSON gateway (/v3/kv/range, /v3/watch).
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const maxResponseSize = 16 << 20
+
+// ErrWatchCanceled reports a watch the server ended, for instance because the
+// revision it started from was compacted. The key must be read again.
+var ErrWatchCanceled = errors.New("watch canceled by server")
+
+type Client struct {
+	endpoint string
+	username string
+	password string
+	http     *http.Client
+}
+
+// New returns a client for endpoint, e.g. "http://127.0.0.1:2379". With a
+// username every call authenticates first.
+func New(endpoint, username, password string) *Client {
+	return &Client{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		username: username,
+		password: password,
+		http:     &http.Client{},
+	}
+}
+
+type keyValue struct {
+	Value       string `json:"value"`
+	ModRevision string `json:"mod_revision"`
+}
+
+func (kv keyValue) decode() ([]byte, int64, error) {
+	value, err := base64.StdEncoding.DecodeString(kv.Value)
+	if err != nil {
+		return nil, 0, fmt.Errorf("decode value: %w", err)
+	}
+
+	rev, err := strconv.ParseInt(kv.ModRevision, 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("decode mod_revision: %w", err)
+	}
+
+	return value, rev, nil
+}
+
+// Get returns the value of key, the revision it was last modified at and the
+// revision of the store it was read at. A watch resumes from the latter, since
+// the former may long have been compacted.
+func (c *Client) Get(ctx context.Context, key string) ([]byte, int64, int64, error) {
+	resp, err := c.post(ctx, "/v3/kv/range", map[string]any{"key": encodeKey(key)})
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("get %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Header struct {
+			Revision string `json:"revision"`
+		} `json:"header"`
+		Kvs []keyValue `json:"kvs"`
+	}
+
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&out); err != nil {
+		return nil, 0, 0, fmt.Errorf("get %s: decode response: %w", key, err)
+	}
+
+	if len(out.Kvs) == 0 {
+		return nil, 0, 0, fmt.Errorf("get %s: key not found", key)
+	}
+
+	value, modRev, err := out.Kvs[0].decode()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	storeRev := modRev
+	if out.Header.Revision != "" {
+		if storeRev, err = strconv.ParseInt(out.Header.Revision, 10, 64); err != nil {
+			return nil, 0, 0, fmt.Errorf("get %s: decode revision: %w", key, err)
+		}
+	}
+
+	return value, modRev, storeRev, nil
+}
+
+// Watch blocks until key is written at a revision after rev and returns the new
+// value with the revision it was written at. Deleting the key is reported as an
+// error, and a watch the server cancels as ErrWatchCanceled.
+func (c *Client) Watch(ctx context.Context, key string, rev int64) ([]byte, int64, error) {
+	resp, err := c.post(ctx, "/v3/watch", map[string]any{"create_request": map[string]any{
+		"key":            encodeKey(key),
+		"start_revision": strconv.FormatInt(rev+1, 10),
+	}})
+	if err != nil {
+		return nil, 0, fmt.Errorf("watch %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+
+	for {
+		var msg struct {
+			Result struct {
+				Canceled bool `json:"canceled"`
+				Events   []struct {
+					Type string   `json:"type"`
+					Kv   keyValue `json:"kv"`
+				} `json:"events"`
+			} `json:"result"`
+		}
+
+		if err = dec.Decode(&msg); err != nil {
+			return nil, 0, fmt.Errorf("watch %s: %w", key, err)
+		}
+
+		if msg.Result.Canceled {
+			return nil, 0, fmt.Errorf("watch %s: %w", key, ErrWatchCanceled)
+		}
+
+		if len(msg.Result.Events) == 0 {
+			continue
+		}
+
+		// Only the latest write matters.
+		last := msg.Result.Events[len(msg.Result.Events)-1]
+		if last.Type == "DELETE" {
+			return nil, 0, fmt.Errorf("watch %s: key deleted", key)
+		}
+
+		return last.Kv.decode()
+	}
+}
+
+func (c *Client) post(ctx context.Context, path string, body any) (*http.Response, error) {
+	var token string
+
+	if c.username != "" {
+		var err error
+		if token, err = c.authenticate(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := c.do(ctx, path, token, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("etcd returned %d", resp.StatusCode)
+	}
+
+	return resp, nil
+}
+
+func (c *Client) authenticate(ctx context.Context) (string, error) {
+	resp, err := c.do(ctx, "/v3/auth/authenticate", "", map[string]string{"name": c.username, "password": c.password})
+	if err != nil {
+		return "", fmt.Errorf("authenticate: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("authenticate: etcd returned %d", resp.StatusCode)
+	}
+
+	var out struct {
+		Token string `json:"token"`
+	}
+
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&out); err != nil {
+		return "", fmt.Errorf("authenticate: decode response: %w", err)
+	}
+
+	if out.Token == "" {
+		return "", errors.New("authenticate: empty token")
+	}
+
+	return out.Token, nil
+}
+
+func (c *Client) do(ctx context.Context, path, token string, body any) (*http.Response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("encode request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	return c.http.Do(req)
+}
+
+func encodeKey(key string) string {
+	return base64.StdEncoding.EncodeToString([]byte(key))
+}
@@ -0,0 +1,315 @@
+// Package extproc implements the wire format of the external processing API in
+// extproc.proto. Messages are encoded by hand with protowire so the gateway
+// needs no generated code; Codec plugs them into gRPC as the "proto" codec.
+package extproc
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// FullMethod is the Process method on the wire.
+const FullMethod = "/kono.extproc.v1.ExternalProcessor/Process"
+
+type Phase int32
+
+const (
+	PhaseUnspecified Phase = iota
+	PhaseRequest
+	PhaseResponse
+)
+
+type Header struct {
+	Name  string
+	Value string
+}
+
+type ProcessingRequest struct {
+	Phase     Phase
+	Method    string
+	Path      string
+	Headers   []Header
+	Body      []byte
+	Status    int32
+	Flow      string
+	RequestID string
+}
+
+type ProcessingResponse struct {
+	SetHeaders        []Header
+	RemoveHeaders     []string
+	ReplaceBody       bool
+	Body              []byte
+	ImmediateResponse *ImmediateResponse
+}
+
+type ImmediateResponse struct {
+	Status  int32
+	Headers []Header
+	Body    []byte
+}
+
+// Message is implemented by the messages of the API.
+type Message interface {
+	Marshal() []byte
+	Unmarshal(data []byte) error
+}
+
+// Codec marshals Message values for gRPC calls.
+type Codec struct{}
+
+func (Codec) Name() string { return "proto" }
+
+func (Codec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(Message)
+	if !ok {
+		return nil, fmt.Errorf("extproc: cannot marshal %T", v)
+	}
+
+	return m.Marshal(), nil
+}
+
+func (Codec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(Message)
+	if !ok {
+		return fmt.Errorf("extproc: cannot unmarshal into %T", v)
+	}
+
+	return m.Unmarshal(data)
+}
+
+func (r *ProcessingRequest) Marshal() []byte {
+	var b []byte
+
+	b = appendVarint(b, 1, uint64(r.Phase))
+	b = appendString(b, 2, r.Method)
+	b = appendString(b, 3, r.Path)
+
+	for _, h := range r.Headers {
+		b = appendMessage(b, 4, h.marshal())
+	}
+
+	b = appendBytes(b, 5, r.Body)
+	b = appendVarint(b, 6, uint64(r.Status)) //nolint:gosec // proto int32 is varint-encoded as uint64.
+	b = appendString(b, 7, r.Flow)
+	b = appendString(b, 8, r.RequestID)
+
+	return b
+}
+
+func (r *ProcessingRequest) Unmarshal(data []byte) error {
+	*r = ProcessingRequest{}
+
+	return walk(data, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			r.Phase = Phase(int32(n)) //nolint:gosec // int32 field.
+		case num == 2 && typ == protowire.BytesType:
+			r.Method = string(v)
+		case num == 3 && typ == protowire.BytesType:
+			r.Path = string(v)
+		case num == 4 && typ == protowire.BytesType:
+			var h Header
+			if err := h.unmarshal(v); err != nil {
+				return err
+			}
+
+			r.Headers = append(r.Headers, h)
+		case num == 5 && typ == protowire.BytesType:
+			r.Body = append([]byte(nil), v...)
+		case num == 6 && typ == protowire.VarintType:
+			r.Status = int32(n) //nolint:gosec // int32 field.
+		case num == 7 && typ == protowire.BytesType:
+			r.Flow = string(v)
+		case num == 8 && typ == protowire.BytesType:
+			r.RequestID = string(v)
+		}
+
+		return nil
+	})
+}
+
+func (r *ProcessingResponse) Marshal() []byte {
+	var b []byte
+
+	for _, h := range r.SetHeaders {
+		b = appendMessage(b, 1, h.marshal())
+	}
+
+	for _, name := range r.RemoveHeaders {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, name)
+	}
+
+	if r.ReplaceBody {
+		b = appendVarint(b, 3, 1)
+	}
+
+	b = appendBytes(b, 4, r.Body)
+
+	if ir := r.ImmediateResponse; ir != nil {
+		b = appendMessage(b, 5, ir.marshal())
+	}
+
+	return b
+}
+
+func (r *ProcessingResponse) Unmarshal(data []byte) error {
+	*r = ProcessingResponse{}
+
+	return walk(data, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			var h Header
+			if err := h.unmarshal(v); err != nil {
+				return err
+			}
+
+			r.SetHeaders = append(r.SetHeaders, h)
+		case num == 2 && typ == protowire.BytesType:
+			r.RemoveHeaders = append(r.RemoveHeaders, string(v))
+		case num == 3 && typ == protowire.VarintType:
+			r.ReplaceBody = n != 0
+		case num == 4 && typ == protowire.BytesType:
+			r.Body = append([]byte(nil), v...)
+		case num == 5 && typ == protowire.BytesType:
+			r.ImmediateResponse = &ImmediateResponse{}
+			return r.ImmediateResponse.unmarshal(v)
+		}
+
+		return nil
+	})
+}
+
+func (r *ImmediateResponse) marshal() []byte {
+	var b []byte
+
+	b = appendVarint(b, 1, uint64(r.Status)) //nolint:gosec // proto int32 is varint-encoded as uint64.
+
+	for _, h := range r.Headers {
+		b = appendMessage(b, 2, h.marshal())
+	}
+
+	return appendBytes(b, 3, r.Body)
+}
+
+func (r *ImmediateResponse) unmarshal(data []byte) error {
+	return walk(data, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			r.Status = int32(n) //nolint:gosec // int32 field.
+		case num == 2 && typ == protowire.BytesType:
+			var h Header
+			if err := h.unmarshal(v); err != nil {
+				return err
+			}
+
+			r.Headers = append(r.Headers, h)
+		case num == 3 && typ == protowire.BytesType:
+			r.Body = append([]byte(nil), v...)
+		}
+
+		return nil
+	})
+}
+
+func (h Header) marshal() []byte {
+	return appendString(appendString(nil, 1, h.Name), 2, h.Value)
+}
+
+func (h *Header) unmarshal(data []byte) error {
+	return walk(data, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+
+		switch num {
+		case 1:
+			h.Name = string(v)
+		case 2:
+			h.Value = string(v)
+		}
+
+		return nil
+	})
+}
+
+// walk calls field for every field in data with its raw bytes (length-delimited
+// fields) or its value (varints). Other wire types are skipped.
+func walk(data []byte, field func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+
+		data = data[n:]
+
+		var (
+			raw    []byte
+			varint uint64
+		)
+
+		switch typ {
+		case protowire.VarintType:
+			varint, n = protowire.ConsumeVarint(data)
+		case protowire.BytesType:
+			raw, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+
+		data = data[n:]
+
+		if typ != protowire.VarintType && typ != protowire.BytesType {
+			continue
+		}
+
+		if err := field(num, typ, raw, varint); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+
+	return protowire.AppendVarint(b, v)
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+
+	return protowire.AppendString(b, s)
+}
+
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+
+	return protowire.AppendBytes(b, v)
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+
+	return protowire.AppendBytes(b, msg)
+}
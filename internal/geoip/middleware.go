@@ -0,0 +1,288 @@
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/kono/internal/logger"
+	"github.com/starwalkn/kono/sdk"
+)
+
+type Middleware struct {
+	db *database
+
+	// headers maps a location field ("country", "region", "city") to the request
+	// header it is copied into. Fields mapped to "" are not forwarded.
+	headers map[string]string
+
+	log *zap.Logger
+}
+
+// databases shares the reader of a database file between middleware instances,
+// as every flow and reload initializes its own. Entries are keyed by the path,
+// size and modification time of the file, so a replaced file is read again, and
+// are dropped when the last instance using them is closed.
+var databases = struct {
+	mu    sync.Mutex
+	byKey map[databaseKey]*database
+}{byKey: make(map[databaseKey]*database)}
+
+type databaseKey struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+type database struct {
+	*reader
+
+	key  databaseKey
+	refs int
+}
+
+func openDatabase(path string) (*database, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("read database: %w", err)
+	}
+
+	key := databaseKey{path: path, size: info.Size(), modTime: info.ModTime()}
+
+	databases.mu.Lock()
+	defer databases.mu.Unlock()
+
+	if db, ok := databases.byKey[key]; ok {
+		db.refs++
+		return db, nil
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read database: %w", err)
+	}
+
+	r, err := newReader(buf)
+	if err != nil {
+		return nil, fmt.Errorf("open database %s: %w", path, err)
+	}
+
+	db := &database{reader: r, key: key, refs: 1}
+	databases.byKey[key] = db
+
+	return db, nil
+}
+
+func (db *database) release() {
+	databases.mu.Lock()
+	defer databases.mu.Unlock()
+
+	if db.refs--; db.refs == 0 && databases.byKey[db.key] == db {
+		delete(databases.byKey, db.key)
+	}
+}
+
+var defaultHeaders = map[string]string{
+	"country": "X-Geo-Country",
+	"region":  "X-Geo-Region",
+	"city":    "X-Geo-City",
+}
+
+// New returns an uninitialized GeoIP middleware.
+func New() sdk.Middleware {
+	return &Middleware{
+		log: logger.New(false),
+	}
+}
+
+func (m *Middleware) Name() string {
+	return "geoip"
+}
+
+func (m *Middleware) Init(config map[string]interface{}) error {
+	path, ok := config["database"].(string)
+	if !ok || path == "" {
+		return errors.New("missing database")
+	}
+
+	headers, err := parseHeaders(config, "headers")
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase(path)
+	if err != nil {
+		return err
+	}
+
+	if m.db != nil {
+		m.db.release()
+	}
+
+	m.db = db
+	m.headers = headers
+
+	return nil
+}
+
+// Close releases the database, which is dropped once no instance uses it.
+func (m *Middleware) Close() error {
+	if m.db != nil {
+		m.db.release()
+		m.db = nil
+	}
+
+	return nil
+}
+
+func (m *Middleware) ConfigSchema() map[string]any {
+	header := map[string]any{"type": "string", "description": "Request header to set; empty disables it."}
+
+	return map[string]any{
+		"type":     "object",
+		"required": []string{"database"},
+		"properties": map[string]any{
+			"database": map[string]any{"type": "string", "description": "Path to a MaxMind DB (.mmdb) file."},
+			"headers": map[string]any{
+				"type":                 "object",
+				"additionalProperties": false,
+				"properties": map[string]any{
+					"country": header,
+					"region":  header,
+					"city":    header,
+				},
+			},
+		},
+	}
+}
+
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Clients must not supply their own location.
+		for _, header := range m.headers {
+			r.Header.Del(header)
+		}
+
+		geo, ok := m.lookup(r)
+		if ok {
+			m.setHeaders(r, geo)
+			r = r.WithContext(sdk.WithGeo(r.Context(), geo))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// lookup resolves the client IP set by the gateway, or the peer address when the
+// middleware runs outside it. Addresses missing from the database are not an error.
+func (m *Middleware) lookup(r *http.Request) (sdk.Geo, bool) {
+	ip := sdk.ClientIPFromContext(r.Context())
+	if ip == "" {
+		ip = r.RemoteAddr
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return sdk.Geo{}, false
+	}
+
+	record, err := m.db.lookup(addr)
+	if err != nil {
+		m.log.Warn("geoip lookup failed", zap.String("ip", ip), zap.Error(err))
+		return sdk.Geo{}, false
+	}
+
+	if record == nil {
+		return sdk.Geo{}, false
+	}
+
+	return sdk.Geo{
+		Country: stringAt(record, "country", "iso_code"),
+		Region:  stringAt(record, "subdivisions", 0, "iso_code"),
+		City:    stringAt(record, "city", "names", "en"),
+	}, true
+}
+
+func (m *Middleware) setHeaders(r *http.Request, geo sdk.Geo) {
+	values := map[string]string{"country": geo.Country, "region": geo.Region, "city": geo.City}
+
+	for field, header := range m.headers {
+		if value := values[field]; value != "" {
+			r.Header.Set(header, value)
+		}
+	}
+}
+
+func parseHeaders(config map[string]interface{}, key string) (map[string]string, error) {
+	headers := make(map[string]string, len(defaultHeaders))
+	for field, header := range defaultHeaders {
+		headers[field] = header
+	}
+
+	raw, ok := config[key]
+	if !ok {
+		return headers, nil
+	}
+
+	entries, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be a map of field to header", key)
+	}
+
+	for field, v := range entries {
+		if _, known := defaultHeaders[field]; !known {
+			return nil, fmt.Errorf("%s: unknown field %q", key, field)
+		}
+
+		header, isString := v.(string)
+		if !isString {
+			return nil, fmt.Errorf("%s: header for %q must be a string", key, field)
+		}
+
+		if header == "" {
+			delete(headers, field)
+			continue
+		}
+
+		headers[field] = http.CanonicalHeaderKey(header)
+	}
+
+	return headers, nil
+}
+
+// stringAt walks a decoded record by map keys and array indexes and returns the
+// string found there, or "".
+func stringAt(v any, path ...any) string {
+	for _, step := range path {
+		switch key := step.(type) {
+		case string:
+			m, ok := v.(map[string]any)
+			if !ok {
+				return ""
+			}
+
+			v = m[key]
+		case int:
+			a, ok := v.([]any)
+			if !ok || key >= len(a) {
+				return ""
+			}
+
+			v = a[key]
+		}
+	}
+
+	s, _ := v.(string)
+
+	return s
+}
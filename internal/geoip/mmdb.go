@@ -0,0 +1,355 @@
+// Package geoip implements the built-in GeoIP middleware. The client IP is
+// resolved against a MaxMind DB (GeoIP2 or GeoLite2 City/Country) and the
+// country, region and city are copied into request headers for upstreams and
+// exposed through sdk.GeoFromContext to plugins and rate limit keys.
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+)
+
+// metadataMarker starts the metadata section at the end of a MaxMind DB file.
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+const (
+	dataSectionSeparator = 16
+	maxMetadataSize      = 128 << 10
+	maxDecodeDepth       = 32
+	// maxDecodeValues bounds the values one decode visits, as pointers let a
+	// small corrupt file describe an exponentially large value.
+	maxDecodeValues = 1 << 16
+)
+
+// Data types of the MaxMind DB format.
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// reader looks addresses up in a MaxMind DB (.mmdb) file held in memory. It
+// implements the subset of the format needed for lookups: the search tree with
+// 24, 28 and 32 bit records and every data type.
+type reader struct {
+	tree       []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint // node reached after the 96 zero bits of an IPv4-mapped address.
+}
+
+func newReader(buf []byte) (*reader, error) {
+	start := bytes.LastIndex(buf[max(0, len(buf)-maxMetadataSize):], metadataMarker)
+	if start < 0 {
+		return nil, errors.New("not a MaxMind DB: metadata marker not found")
+	}
+
+	start += max(0, len(buf)-maxMetadataSize) + len(metadataMarker)
+
+	meta, _, err := (&decoder{data: buf[start:]}).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("decode metadata: %w", err)
+	}
+
+	fields, ok := meta.(map[string]any)
+	if !ok {
+		return nil, errors.New("decode metadata: not a map")
+	}
+
+	r := &reader{
+		nodeCount:  uintField(fields, "node_count"),
+		recordSize: uintField(fields, "record_size"),
+		ipVersion:  uintField(fields, "ip_version"),
+	}
+
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", r.recordSize)
+	}
+
+	if r.nodeCount > uint(len(buf)) {
+		return nil, errors.New("search tree exceeds the file")
+	}
+
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+dataSectionSeparator > uint(start-len(metadataMarker)) {
+		return nil, errors.New("search tree exceeds the file")
+	}
+
+	r.tree = buf[:treeSize]
+	r.data = buf[treeSize+dataSectionSeparator : start-len(metadataMarker)]
+
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node = r.record(node, 0)
+		}
+
+		r.ipv4Start = node
+	}
+
+	return r, nil
+}
+
+// lookup returns the record of the network containing addr, or nil when the
+// database has none.
+func (r *reader) lookup(addr netip.Addr) (any, error) {
+	addr = addr.Unmap()
+
+	node, bits := uint(0), addr.BitLen()
+
+	switch {
+	case addr.Is4() && r.ipVersion == 6:
+		node = r.ipv4Start
+	case addr.Is6() && r.ipVersion == 4:
+		return nil, nil
+	}
+
+	ip := addr.AsSlice()
+
+	for i := 0; i < bits && node < r.nodeCount; i++ {
+		bit := uint(ip[i/8]>>(7-i%8)) & 1
+		node = r.record(node, bit)
+	}
+
+	if node <= r.nodeCount {
+		return nil, nil
+	}
+
+	offset := node - r.nodeCount - dataSectionSeparator
+	if offset >= uint(len(r.data)) {
+		return nil, errors.New("corrupt search tree: data pointer out of range")
+	}
+
+	value, _, err := (&decoder{data: r.data}).decode(offset, 0)
+
+	return value, err
+}
+
+// record reads the left (bit 0) or right (bit 1) record of node.
+func (r *reader) record(node, bit uint) uint {
+	switch r.recordSize {
+	case 24:
+		b := r.tree[node*6+bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := r.tree[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(r.tree[node*8+bit*4:]))
+	}
+}
+
+// decoder reads values of the data section. Pointers are offsets into data.
+type decoder struct {
+	data   []byte
+	values int
+}
+
+var errTruncated = errors.New("truncated data section")
+
+// decode returns the value at offset and the offset following it.
+func (d *decoder) decode(offset uint, depth int) (any, uint, error) {
+	if depth > maxDecodeDepth {
+		return nil, 0, errors.New("data nested too deeply")
+	}
+
+	if d.values++; d.values > maxDecodeValues {
+		return nil, 0, errors.New("data has too many values")
+	}
+
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == typePointer {
+		target, next, ptrErr := d.pointer(size, offset)
+		if ptrErr != nil {
+			return nil, 0, ptrErr
+		}
+
+		value, _, ptrErr := d.decode(target, depth+1)
+
+		return value, next, ptrErr
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]any, min(size, 64))
+
+		for range size {
+			key, next, keyErr := d.decode(offset, depth+1)
+			if keyErr != nil {
+				return nil, 0, keyErr
+			}
+
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+
+			value, after, valueErr := d.decode(next, depth+1)
+			if valueErr != nil {
+				return nil, 0, valueErr
+			}
+
+			m[name], offset = value, after
+		}
+
+		return m, offset, nil
+	case typeArray:
+		a := make([]any, 0, min(size, 64))
+
+		for range size {
+			value, next, itemErr := d.decode(offset, depth+1)
+			if itemErr != nil {
+				return nil, 0, itemErr
+			}
+
+			a, offset = append(a, value), next
+		}
+
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeContainer, typeEndMarker:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(d.data)) {
+		return nil, 0, errTruncated
+	}
+
+	b, next := d.data[offset:offset+size], offset+size
+
+	switch typ {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes:
+		return bytes.Clone(b), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double size")
+		}
+
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float size")
+		}
+
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		return beUint(b), next, nil
+	case typeInt32:
+		return int64(int32(uint32(beUint(b)))), next, nil //nolint:gosec // int32 is stored as its 4 bytes.
+	case typeUint128:
+		return bytes.Clone(b), next, nil
+	default:
+		return nil, 0, fmt.Errorf("unknown data type %d", typ)
+	}
+}
+
+// control decodes the control byte (and extended type and size bytes) at offset.
+func (d *decoder) control(offset uint) (typ, size, next uint, err error) {
+	if offset >= uint(len(d.data)) {
+		return 0, 0, 0, errTruncated
+	}
+
+	ctrl := d.data[offset]
+	offset++
+
+	typ = uint(ctrl >> 5)
+	if typ == typeExtended {
+		if offset >= uint(len(d.data)) {
+			return 0, 0, 0, errTruncated
+		}
+
+		typ = 7 + uint(d.data[offset])
+		offset++
+	}
+
+	size = uint(ctrl & 0x1F)
+	if typ == typePointer || size < 29 {
+		return typ, size, offset, nil
+	}
+
+	n := size - 28
+	if offset+n > uint(len(d.data)) {
+		return 0, 0, 0, errTruncated
+	}
+
+	extra := beUint(d.data[offset : offset+n])
+
+	switch size {
+	case 29:
+		size = 29 + uint(extra)
+	case 30:
+		size = 285 + uint(extra)
+	default:
+		size = 65821 + uint(extra)
+	}
+
+	return typ, size, offset + n, nil
+}
+
+// pointer resolves a pointer whose control byte carried the size bits sizeBits.
+func (d *decoder) pointer(sizeBits, offset uint) (target, next uint, err error) {
+	n := (sizeBits>>3)&0x3 + 1
+	if offset+n > uint(len(d.data)) {
+		return 0, 0, errTruncated
+	}
+
+	b := d.data[offset : offset+n]
+	vvv := sizeBits & 0x7
+
+	switch n {
+	case 1:
+		target = vvv<<8 | uint(b[0])
+	case 2:
+		target = (vvv<<16 | uint(beUint(b))) + 2048
+	case 3:
+		target = (vvv<<24 | uint(beUint(b))) + 526336
+	default:
+		target = uint(beUint(b))
+	}
+
+	return target, offset + n, nil
+}
+
+func beUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+
+	return v
+}
+
+func uintField(m map[string]any, key string) uint {
+	v, _ := m[key].(uint64)
+	return uint(v)
+}
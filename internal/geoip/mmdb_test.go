@@ -0,0 +1,90 @@
+package geoip
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// emptyDB is an IPv4 database with a single node whose records hold no data.
+func emptyDB() []byte {
+	buf := []byte{0, 0, 1, 0, 0, 1}
+	buf = append(buf, make([]byte, dataSectionSeparator)...)
+	buf = append(buf, metadataMarker...)
+
+	// {"node_count": 1, "record_size": 24, "ip_version": 4}
+	buf = append(buf, 7<<5|3)
+	buf = append(buf, 2<<5|10)
+	buf = append(buf, "node_count"...)
+	buf = append(buf, 6<<5|1, 1)
+	buf = append(buf, 2<<5|11)
+	buf = append(buf, "record_size"...)
+	buf = append(buf, 5<<5|1, 24)
+	buf = append(buf, 2<<5|10)
+	buf = append(buf, "ip_version"...)
+	buf = append(buf, 5<<5|1, 4)
+
+	return buf
+}
+
+func TestOpenDatabase_SharesReaders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "city.mmdb")
+	if err := os.WriteFile(path, emptyDB(), 0o600); err != nil {
+		t.Fatalf("write database: %v", err)
+	}
+
+	first, err := openDatabase(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	second, err := openDatabase(path)
+	if err != nil {
+		t.Fatalf("open again: %v", err)
+	}
+
+	if first != second {
+		t.Fatal("expected instances of the same file to share a reader")
+	}
+
+	first.release()
+
+	if _, ok := databases.byKey[first.key]; !ok {
+		t.Fatal("expected the reader to be kept while an instance uses it")
+	}
+
+	second.release()
+
+	if _, ok := databases.byKey[first.key]; ok {
+		t.Fatal("expected the reader to be dropped with its last instance")
+	}
+}
+
+func FuzzNewReader(f *testing.F) {
+	f.Add(emptyDB())
+	f.Add([]byte("not a database"))
+
+	addrs := []netip.Addr{netip.MustParseAddr("81.2.69.142"), netip.MustParseAddr("2001:db8::1")}
+
+	f.Fuzz(func(_ *testing.T, buf []byte) {
+		r, err := newReader(buf)
+		if err != nil {
+			return
+		}
+
+		for _, addr := range addrs {
+			_, _ = r.lookup(addr)
+		}
+	})
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add([]byte{7<<5 | 1, 2<<5 | 1, 'a', 2<<5 | 1, 'b'}) // {"a": "b"}
+	f.Add([]byte{1 << 5, 0})                              // A pointer to itself.
+	f.Add([]byte{7<<5 | 29, 0xFF})                        // A map claiming 284 entries.
+
+	f.Fuzz(func(_ *testing.T, data []byte) {
+		_, _, _ = (&decoder{data: data}).decode(0, 0)
+	})
+}
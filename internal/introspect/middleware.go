@@ -0,0 +1,290 @@
+// Package introspect implements the built-in OAuth2 token introspection
+// middleware. Opaque bearer tokens are validated against an RFC 7662
+// introspection endpoint; inactive tokens are rejected with 401 before the flow
+// dispatches, and requests that cannot be checked because the endpoint fails are
+// answered with 503. Results are cached briefly so a busy client does not cost one
+// introspection call per request.
+package introspect
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/kono/internal/logger"
+	"github.com/starwalkn/kono/sdk"
+)
+
+const (
+	defaultTimeout         = 5 * time.Second
+	defaultCacheTTL        = 30 * time.Second
+	defaultCacheMaxEntries = 10_000
+	maxResponseSize        = 1 << 20
+	authHeaderPartsCount   = 2
+)
+
+type Middleware struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+
+	cacheTTL        time.Duration
+	cacheMaxEntries int
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]cacheEntry
+
+	log *zap.Logger
+}
+
+// cacheEntry is a remembered introspection result. Inactive results are cached
+// too, so replaying a revoked token does not reach the endpoint every time.
+type cacheEntry struct {
+	active  bool
+	claims  map[string]any
+	expires time.Time
+}
+
+// New returns an uninitialized introspection middleware.
+func New() sdk.Middleware {
+	return &Middleware{
+		log: logger.New(false),
+	}
+}
+
+func (m *Middleware) Name() string {
+	return "introspection"
+}
+
+func (m *Middleware) Init(config map[string]interface{}) error {
+	endpoint, ok := config["endpoint"].(string)
+	if !ok || endpoint == "" {
+		return errors.New("missing endpoint")
+	}
+
+	if _, err := url.ParseRequestURI(endpoint); err != nil {
+		return fmt.Errorf("invalid endpoint: %w", err)
+	}
+
+	m.endpoint = endpoint
+	m.clientID, _ = config["client_id"].(string)
+	m.clientSecret, _ = config["client_secret"].(string)
+
+	timeout, err := parseDuration(config, "timeout", defaultTimeout)
+	if err != nil {
+		return err
+	}
+
+	m.client = &http.Client{Timeout: timeout}
+
+	if m.cacheTTL, err = parseDuration(config, "cache_ttl", defaultCacheTTL); err != nil {
+		return err
+	}
+
+	m.cacheMaxEntries = defaultCacheMaxEntries
+
+	// Numbers decoded from JSON arrive as float64, those from YAML as int.
+	switch n := config["cache_max_entries"].(type) {
+	case int:
+		if n > 0 {
+			m.cacheMaxEntries = n
+		}
+	case float64:
+		if n >= 1 {
+			m.cacheMaxEntries = int(n)
+		}
+	}
+
+	m.cache = make(map[[sha256.Size]byte]cacheEntry)
+
+	return nil
+}
+
+func (m *Middleware) ConfigSchema() map[string]any {
+	duration := map[string]any{"type": "string"}
+
+	return map[string]any{
+		"type":     "object",
+		"required": []string{"endpoint"},
+		"properties": map[string]any{
+			"endpoint":          map[string]any{"type": "string"},
+			"client_id":         map[string]any{"type": "string"},
+			"client_secret":     map[string]any{"type": "string"},
+			"timeout":           duration,
+			"cache_ttl":         duration,
+			"cache_max_entries": map[string]any{"type": "integer", "minimum": 1},
+		},
+	}
+}
+
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.SplitN(r.Header.Get("Authorization"), " ", authHeaderPartsCount)
+		if len(parts) != authHeaderPartsCount || !strings.EqualFold(parts[0], "Bearer") || parts[1] == "" {
+			unauthorized(w)
+			return
+		}
+
+		entry, err := m.introspect(r.Context(), parts[1])
+		if err != nil {
+			m.log.Error("token introspection failed", zap.Error(err))
+			unavailable(w)
+
+			return
+		}
+
+		if !entry.active {
+			unauthorized(w)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(sdk.WithClaims(r.Context(), entry.claims)))
+	})
+}
+
+// introspect returns the cached result for token or asks the endpoint. Failed
+// calls are not cached.
+func (m *Middleware) introspect(ctx context.Context, token string) (cacheEntry, error) {
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+
+	m.mu.Lock()
+	entry, ok := m.cache[key]
+	m.mu.Unlock()
+
+	if ok && now.Before(entry.expires) {
+		return entry, nil
+	}
+
+	claims, err := m.call(ctx, token)
+	if err != nil {
+		return cacheEntry{}, err
+	}
+
+	entry = cacheEntry{expires: now.Add(m.cacheTTL)}
+
+	if active, _ := claims["active"].(bool); active {
+		entry.active = true
+		entry.claims = claims
+
+		// Never trust a cached result past the token's own expiry.
+		if exp, isNumber := claims["exp"].(float64); isNumber {
+			if tokenExp := time.Unix(int64(exp), 0); tokenExp.Before(entry.expires) {
+				entry.expires = tokenExp
+			}
+		}
+
+		if !now.Before(entry.expires) {
+			entry.active = false
+		}
+	}
+
+	m.store(key, entry, now)
+
+	return entry, nil
+}
+
+func (m *Middleware) store(key [sha256.Size]byte, entry cacheEntry, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.cache) >= m.cacheMaxEntries {
+		for k, e := range m.cache {
+			if !now.Before(e.expires) {
+				delete(m.cache, k)
+			}
+		}
+
+		// Still full of live entries: drop the one expiring first, so that the
+		// other cached tokens keep their results.
+		if len(m.cache) >= m.cacheMaxEntries {
+			var (
+				oldest  [sha256.Size]byte
+				expires time.Time
+			)
+
+			for k, e := range m.cache {
+				if expires.IsZero() || e.expires.Before(expires) {
+					oldest, expires = k, e.expires
+				}
+			}
+
+			delete(m.cache, oldest)
+		}
+	}
+
+	m.cache[key] = entry
+}
+
+func (m *Middleware) call(ctx context.Context, token string) (map[string]any, error) {
+	form := url.Values{
+		"token":           {token},
+		"token_type_hint": {"access_token"},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("create introspection request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if m.clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(m.clientID), url.QueryEscape(m.clientSecret))
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("call introspection endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection endpoint returned %d", resp.StatusCode)
+	}
+
+	var claims map[string]any
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("decode introspection response: %w", err)
+	}
+
+	return claims, nil
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	_, _ = w.Write([]byte(`{"errors":[{"code":"UNAUTHORIZED"}]}`))
+}
+
+func unavailable(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_, _ = w.Write([]byte(`{"errors":[{"code":"INTROSPECTION_UNAVAILABLE"}]}`))
+}
+
+func parseDuration(config map[string]interface{}, key string, fallback time.Duration) (time.Duration, error) {
+	raw, ok := config[key].(string)
+	if !ok {
+		return fallback, nil
+	}
+
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+
+	return d, nil
+}
@@ -1,4 +1,4 @@
-package main
+package jwtauth
 
 import (
 	"context"
@@ -15,6 +15,7 @@ import (
 const (
 	defaultJWKSRefreshTimeout  = 5 * time.Second
 	defaultJWKSRefreshInterval = 5 * time.Minute
+	defaultJWKSMinRefresh      = 30 * time.Second
 )
 
 type jwksResolver struct {
@@ -24,6 +25,12 @@ type jwksResolver struct {
 	refreshTimeout  time.Duration
 	refreshInterval time.Duration
 	stopCh          chan struct{}
+
+	// minRefresh bounds how often an unknown kid may trigger a fetch, so
+	// tokens with made-up kids cannot hammer the JWKS endpoint.
+	minRefresh  time.Duration
+	refreshMu   sync.Mutex
+	lastRefresh time.Time
 }
 
 func (r *jwksResolver) KeyFunc(token *jwt.Token) (any, error) {
@@ -48,8 +55,8 @@ func (r *jwksResolver) KeyFunc(token *jwt.Token) (any, error) {
 		return key, nil
 	}
 
-	// Refresh JWKS and try again.
-	if err := r.refresh(r.refreshTimeout); err != nil {
+	// The key set may have rotated; refresh and try again.
+	if err := r.refreshOnMiss(); err != nil {
 		return nil, err
 	}
 
@@ -64,7 +71,27 @@ func (r *jwksResolver) KeyFunc(token *jwt.Token) (any, error) {
 	return key, nil
 }
 
+func (r *jwksResolver) refreshOnMiss() error {
+	r.refreshMu.Lock()
+	defer r.refreshMu.Unlock()
+
+	if time.Since(r.lastRefresh) < r.minRefresh {
+		return nil
+	}
+
+	return r.fetch(r.refreshTimeout)
+}
+
 func (r *jwksResolver) refresh(timeout time.Duration) error {
+	r.refreshMu.Lock()
+	defer r.refreshMu.Unlock()
+
+	return r.fetch(timeout)
+}
+
+func (r *jwksResolver) fetch(timeout time.Duration) error {
+	r.lastRefresh = time.Now()
+
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
@@ -0,0 +1,361 @@
+// Package jwtauth implements the built-in JWT authentication middleware. Tokens
+// are read from the Authorization bearer header, verified against an HMAC
+// secret, a static RSA key or a JWKS endpoint, and checked for issuer and
+// audience. Verified claims are exposed to plugins through sdk.ClaimsFromContext
+// and can be copied into request headers for upstreams.
+package jwtauth
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/kono/internal/logger"
+	"github.com/starwalkn/kono/sdk"
+)
+
+type keyResolver interface {
+	KeyFunc(token *jwt.Token) (any, error)
+}
+
+// closeable is implemented by resolvers that hold background resources.
+type closeable interface {
+	stop()
+}
+
+type Middleware struct {
+	issuer    string
+	audience  string
+	resolver  keyResolver
+	jwtConfig jwtConfig
+
+	// claimHeaders maps a claim name to the request header it is copied into.
+	claimHeaders map[string]string
+
+	log *zap.Logger
+}
+
+type jwtConfig struct {
+	alg    string
+	leeway time.Duration
+
+	hmacSecret          []byte         // For HS256.
+	rsaPublicKey        *rsa.PublicKey // For static RS256.
+	jwksURL             string         // For JWKS.
+	jwksRefreshTimeout  time.Duration
+	jwksRefreshInterval time.Duration
+	jwksMinRefresh      time.Duration
+}
+
+const (
+	defaultLeeway        = 5 * time.Second
+	authHeaderPartsCount = 2
+)
+
+// New returns an uninitialized auth middleware.
+func New() sdk.Middleware {
+	return &Middleware{
+		log: logger.New(false),
+	}
+}
+
+func (m *Middleware) Name() string {
+	return "auth"
+}
+
+func (m *Middleware) Init(config map[string]interface{}) error {
+	issuer, ok := config["issuer"].(string)
+	if !ok {
+		return errors.New("missing issuer")
+	}
+
+	audience, ok := config["audience"].(string)
+	if !ok {
+		return errors.New("missing audience")
+	}
+
+	alg, ok := config["alg"].(string)
+	if !ok || alg == "" {
+		return errors.New("missing or invalid alg")
+	}
+
+	m.issuer = issuer
+	m.audience = audience
+
+	cfg := jwtConfig{
+		alg:    alg,
+		leeway: parseDuration(config, "leeway", defaultLeeway),
+	}
+
+	hmacSecret, err := parseHMACSecret(config, "hmac_secret")
+	if err != nil {
+		return err
+	}
+
+	cfg.hmacSecret = hmacSecret
+
+	rsaPub, err := parseRSAPublicKey(config, "rsa_public_key")
+	if err != nil {
+		return err
+	}
+
+	cfg.rsaPublicKey = rsaPub
+
+	if url, urlOk := config["jwks_url"].(string); urlOk {
+		cfg.jwksURL = url
+	}
+
+	cfg.jwksRefreshTimeout = parseDuration(config, "jwks_refresh_timeout", defaultJWKSRefreshTimeout)
+	cfg.jwksRefreshInterval = parseDuration(config, "jwks_refresh_interval", defaultJWKSRefreshInterval)
+	cfg.jwksMinRefresh = parseDuration(config, "jwks_min_refresh_interval", defaultJWKSMinRefresh)
+
+	m.jwtConfig = cfg
+
+	claimHeaders, err := parseClaimHeaders(config, "claims_to_headers")
+	if err != nil {
+		return err
+	}
+
+	m.claimHeaders = claimHeaders
+
+	resolver, err := m.newKeyResolver(m.jwtConfig)
+	if err != nil {
+		return err
+	}
+
+	m.resolver = resolver
+
+	return nil
+}
+
+func (m *Middleware) ConfigSchema() map[string]any {
+	duration := map[string]any{"type": "string"}
+
+	return map[string]any{
+		"type":     "object",
+		"required": []string{"issuer", "audience", "alg"},
+		"properties": map[string]any{
+			"issuer":                    map[string]any{"type": "string"},
+			"audience":                  map[string]any{"type": "string"},
+			"alg":                       map[string]any{"enum": []string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg()}},
+			"leeway":                    duration,
+			"hmac_secret":               map[string]any{"type": "string", "description": "Base64-encoded HS256 secret."},
+			"rsa_public_key":            map[string]any{"type": "string", "description": "PEM-encoded RS256 public key."},
+			"jwks_url":                  map[string]any{"type": "string"},
+			"jwks_refresh_timeout":      duration,
+			"jwks_refresh_interval":     duration,
+			"jwks_min_refresh_interval": duration,
+			"claims_to_headers": map[string]any{
+				"type":                 "object",
+				"additionalProperties": map[string]any{"type": "string", "minLength": 1},
+			},
+		},
+	}
+}
+
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			unauthorized(w)
+			return
+		}
+
+		parts := strings.SplitN(authHeader, " ", authHeaderPartsCount)
+		if len(parts) != authHeaderPartsCount || !strings.EqualFold(parts[0], "Bearer") {
+			unauthorized(w)
+			return
+		}
+
+		token, err := jwt.ParseWithClaims(
+			parts[1],
+			&jwt.MapClaims{},
+			m.resolver.KeyFunc,
+			jwt.WithValidMethods([]string{m.jwtConfig.alg}),
+			jwt.WithLeeway(m.jwtConfig.leeway),
+		)
+		if err != nil || !token.Valid {
+			unauthorized(w)
+			return
+		}
+
+		claims, ok := token.Claims.(*jwt.MapClaims)
+		if !ok {
+			unauthorized(w)
+			return
+		}
+
+		if err = m.validateClaims(claims); err != nil {
+			unauthorized(w)
+			return
+		}
+
+		r = r.WithContext(sdk.WithClaims(r.Context(), *claims))
+		m.setClaimHeaders(r, *claims)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *Middleware) Close() error {
+	if c, ok := m.resolver.(closeable); ok {
+		c.stop()
+	}
+
+	return nil
+}
+
+// setClaimHeaders overwrites the configured headers with claim values, so a
+// client cannot supply them itself. Missing claims leave the header empty.
+func (m *Middleware) setClaimHeaders(r *http.Request, claims jwt.MapClaims) {
+	for claim, header := range m.claimHeaders {
+		r.Header.Del(header)
+
+		if value, ok := claimString(claims[claim]); ok {
+			r.Header.Set(header, value)
+		}
+	}
+}
+
+func (m *Middleware) validateClaims(claims *jwt.MapClaims) error {
+	issuer, err := claims.GetIssuer()
+	if err != nil || issuer != m.issuer {
+		return errors.New("invalid issuer")
+	}
+
+	audience, err := claims.GetAudience()
+	if err != nil || !slices.Contains(audience, m.audience) {
+		return errors.New("invalid audience")
+	}
+
+	return nil
+}
+
+func (m *Middleware) newKeyResolver(cfg jwtConfig) (keyResolver, error) {
+	switch cfg.alg {
+	case jwt.SigningMethodHS256.Alg():
+		if len(cfg.hmacSecret) == 0 {
+			return nil, errors.New("HMAC secret not configured")
+		}
+
+		return &hmacResolver{HMACSecret: cfg.hmacSecret}, nil
+
+	case jwt.SigningMethodRS256.Alg():
+		if cfg.jwksURL != "" {
+			return m.newJWKSResolver(cfg)
+		}
+
+		if cfg.rsaPublicKey != nil {
+			return &rsaResolver{RSAPublic: cfg.rsaPublicKey}, nil
+		}
+
+		return nil, errors.New("RSA public key not configured")
+
+	default:
+		return nil, fmt.Errorf("unsupported signing method: %s", cfg.alg)
+	}
+}
+
+func (m *Middleware) newJWKSResolver(cfg jwtConfig) (keyResolver, error) {
+	r := &jwksResolver{
+		url:             cfg.jwksURL,
+		keys:            make(map[string]*rsa.PublicKey),
+		mu:              sync.RWMutex{},
+		refreshTimeout:  cfg.jwksRefreshTimeout,
+		refreshInterval: cfg.jwksRefreshInterval,
+		minRefresh:      cfg.jwksMinRefresh,
+		stopCh:          make(chan struct{}),
+	}
+
+	if err := r.refresh(cfg.jwksRefreshTimeout); err != nil {
+		return nil, fmt.Errorf("initial JWKS fetch failed: %w", err)
+	}
+
+	r.start(func(err error) {
+		m.log.Error("JWKS background refresh failed", zap.Error(err))
+	})
+
+	return r, nil
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	_, _ = w.Write([]byte(`{"errors":[{"code":"UNAUTHORIZED"}]}`))
+}
+
+func parseDuration(config map[string]interface{}, key string, fallback time.Duration) time.Duration {
+	raw, ok := config[key].(string)
+	if !ok {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		return fallback
+	}
+
+	return d
+}
+
+func parseClaimHeaders(config map[string]interface{}, key string) (map[string]string, error) {
+	raw, ok := config[key]
+	if !ok {
+		return nil, nil
+	}
+
+	entries, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be a map of claim to header", key)
+	}
+
+	headers := make(map[string]string, len(entries))
+
+	for claim, v := range entries {
+		header, isString := v.(string)
+		if !isString || header == "" {
+			return nil, fmt.Errorf("%s: header for claim %q must be a non-empty string", key, claim)
+		}
+
+		headers[claim] = http.CanonicalHeaderKey(header)
+	}
+
+	return headers, nil
+}
+
+// claimString renders scalar claims as header values. Objects and arrays are
+// not forwarded; lists of strings are joined with commas.
+func claimString(v any) (string, bool) {
+	switch value := v.(type) {
+	case string:
+		return value, true
+	case bool:
+		return strconv.FormatBool(value), true
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	case []any:
+		parts := make([]string, 0, len(value))
+
+		for _, item := range value {
+			s, ok := item.(string)
+			if !ok {
+				return "", false
+			}
+
+			parts = append(parts, s)
+		}
+
+		return strings.Join(parts, ","), true
+	default:
+		return "", false
+	}
+}
@@ -24,18 +24,25 @@ const (
 	FailReasonNoMatchedFlow   FailReason = "no_matched_flow"
 	FailReasonBodyTooLarge    FailReason = "body_too_large"
 	FailReasonTooManyRequests FailReason = "too_many_requests"
+	FailReasonOverloaded      FailReason = "overloaded"
+	FailReasonQuotaExceeded   FailReason = "quota_exceeded"
 )
 
 type Metrics struct {
 	requestsTotal         otelmetric.Int64Counter
 	requestsDuration      otelmetric.Float64Histogram
 	requestsInFlight      otelmetric.Int64UpDownCounter
+	requestsQueued        otelmetric.Int64UpDownCounter
 	failedRequestsTotal   otelmetric.Int64Counter
 	upstreamLatency       otelmetric.Float64Histogram
 	upstreamRequestsTotal otelmetric.Int64Counter
 	upstreamErrorsTotal   otelmetric.Int64Counter
 	upstreamRetriesTotal  otelmetric.Int64Counter
+	upstreamHedgesTotal   otelmetric.Int64Counter
 	circuitBreakerState   otelmetric.Float64Gauge
+	pluginErrorsTotal     otelmetric.Int64Counter
+	draining              otelmetric.Int64Gauge
+	shutdownAborted       otelmetric.Int64Counter
 }
 
 func New() (*Metrics, error) {
@@ -68,6 +75,14 @@ func New() (*Metrics, error) {
 		return nil, err
 	}
 
+	m.requestsQueued, err = meter.Int64UpDownCounter(
+		"kono.requests.queued",
+		otelmetric.WithDescription("Current number of requests waiting for an in-flight slot"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
 	m.failedRequestsTotal, err = meter.Int64Counter(
 		"kono.failed_requests.total",
 		otelmetric.WithDescription("Total number of requests rejected before flow processing"),
@@ -109,6 +124,14 @@ func New() (*Metrics, error) {
 		return nil, err
 	}
 
+	m.upstreamHedgesTotal, err = meter.Int64Counter(
+		"kono.upstream.hedges.total",
+		otelmetric.WithDescription("Total number of hedged upstream requests"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
 	m.circuitBreakerState, err = meter.Float64Gauge(
 		"kono.circuit_breaker.state",
 		otelmetric.WithDescription("Circuit breaker state: 0=closed, 1=open, 2=half-open"),
@@ -117,6 +140,30 @@ func New() (*Metrics, error) {
 		return nil, err
 	}
 
+	m.pluginErrorsTotal, err = meter.Int64Counter(
+		"kono.plugin.errors.total",
+		otelmetric.WithDescription("Total number of failed plugin executions by reason: error, timeout or panic"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	m.draining, err = meter.Int64Gauge(
+		"kono.server.draining",
+		otelmetric.WithDescription("1 while the gateway drains connections for shutdown"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	m.shutdownAborted, err = meter.Int64Counter(
+		"kono.shutdown.aborted_requests.total",
+		otelmetric.WithDescription("Total number of requests cut off when the shutdown grace expired"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
 	return m, nil
 }
 
@@ -217,6 +264,47 @@ func (m *Metrics) DecRequestsInFlight() {
 	m.requestsInFlight.Add(context.Background(), -1)
 }
 
+func (m *Metrics) IncPluginErrorsTotal(plugin, phase, reason string) {
+	m.pluginErrorsTotal.Add(context.Background(), 1,
+		otelmetric.WithAttributes(
+			attribute.String("plugin", plugin),
+			attribute.String("type", phase),
+			attribute.String("reason", reason),
+		),
+	)
+}
+
+func (m *Metrics) SetDraining() {
+	m.draining.Record(context.Background(), 1)
+}
+
+func (m *Metrics) AddShutdownAbortedRequests(n int64) {
+	m.shutdownAborted.Add(context.Background(), n)
+}
+
+func (m *Metrics) IncRequestsQueued() {
+	m.requestsQueued.Add(context.Background(), 1)
+}
+
+func (m *Metrics) DecRequestsQueued() {
+	m.requestsQueued.Add(context.Background(), -1)
+}
+
+// ObserveRateLimitBuckets reports the number of keys a rate limiter tracks, as
+// returned by count at each collection.
+func (m *Metrics) ObserveRateLimitBuckets(count func() int) error {
+	_, err := otel.Meter(MeterName).Int64ObservableGauge(
+		"kono.ratelimit.buckets",
+		otelmetric.WithDescription("Current number of keys tracked by the rate limiter"),
+		otelmetric.WithInt64Callback(func(_ context.Context, o otelmetric.Int64Observer) error {
+			o.Observe(int64(count()))
+			return nil
+		}),
+	)
+
+	return err
+}
+
 func (m *Metrics) IncFailedRequestsTotal(reason FailReason) {
 	m.failedRequestsTotal.Add(context.Background(), 1,
 		otelmetric.WithAttributes(
@@ -263,6 +351,15 @@ func (m *Metrics) IncUpstreamRetriesTotal(route, upstream string) {
 	)
 }
 
+func (m *Metrics) IncUpstreamHedgesTotal(route, upstream string) {
+	m.upstreamHedgesTotal.Add(context.Background(), 1,
+		otelmetric.WithAttributes(
+			attribute.String("route", route),
+			attribute.String("upstream", upstream),
+		),
+	)
+}
+
 func (m *Metrics) SetCircuitBreakerState(upstream string, state float64) {
 	m.circuitBreakerState.Record(context.Background(), state,
 		otelmetric.WithAttributes(
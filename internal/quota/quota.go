@@ -0,0 +1,284 @@
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	PeriodDay   = "day"
+	PeriodMonth = "month"
+)
+
+type counter struct {
+	Period string `json:"period"`
+	Used   int64  `json:"used"`
+}
+
+// Quota counts requests per key over calendar days or months (UTC). Counters are
+// kept in memory and, when a path is set, written to it periodically and on Stop
+// so they survive restarts. At most maxEntries keys are counted per period;
+// further keys share one overflow counter rather than evicting counters, which
+// would hand their consumers a fresh quota.
+type Quota struct {
+	limit      int64
+	maxEntries int
+	period     string
+	path       string
+
+	mu       sync.Mutex
+	counters map[string]*counter
+	overflow counter // shared by the keys that found no room, not persisted.
+	dirty    bool
+	swept    string // period whose stale counters were last dropped.
+
+	stopCh  chan struct{}
+	stopped bool
+}
+
+func New(limit, maxEntries int, period, path string) (*Quota, error) {
+	if period != PeriodDay && period != PeriodMonth {
+		return nil, fmt.Errorf("unknown quota period %q", period)
+	}
+
+	q := &Quota{
+		limit:      int64(limit),
+		maxEntries: max(1, maxEntries),
+		period:     period,
+		path:       path,
+		counters:   make(map[string]*counter),
+		stopCh:     make(chan struct{}),
+	}
+
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// Take counts one request for key unless its quota is used up. A new key takes
+// from the overflow counter while maxEntries keys are counted. It returns the
+// requests left in the current period and when the period ends.
+func (q *Quota) Take(key string, now time.Time) (remaining int64, reset time.Time, ok bool) {
+	period, reset := q.bounds(now)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	c, found := q.counters[key]
+
+	switch {
+	case !found && !q.room(period):
+		c = &q.overflow
+		if c.Period != period {
+			*c = counter{Period: period}
+		}
+	case !found || c.Period != period:
+		c = &counter{Period: period}
+		q.counters[key] = c
+	}
+
+	if c.Used >= q.limit {
+		return 0, reset, false
+	}
+
+	c.Used++
+	q.dirty = true
+
+	return q.limit - c.Used, reset, true
+}
+
+// room reports whether a new key can be counted in period. Once the map is
+// full, counters of earlier periods are dropped, at most once per period.
+func (q *Quota) room(period string) bool {
+	if len(q.counters) < q.maxEntries {
+		return true
+	}
+
+	if q.swept == period {
+		return false
+	}
+
+	q.swept = period
+
+	for key, c := range q.counters {
+		if c.Period != period {
+			delete(q.counters, key)
+		}
+	}
+
+	return len(q.counters) < q.maxEntries
+}
+
+func (q *Quota) Limit() int64 { return q.limit }
+
+// Start flushes the counters every flushEvery, logging the writes that fail.
+func (q *Quota) Start(flushEvery time.Duration, log *zap.Logger) error {
+	if q.path == "" {
+		return nil
+	}
+
+	go func() {
+		ticker := time.NewTicker(flushEvery)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := q.flush(); err != nil {
+					log.Error("quota state flush failed", zap.Error(err))
+				}
+			case <-q.stopCh:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Stop ends periodic flushing and writes the counters one last time.
+func (q *Quota) Stop() error {
+	q.mu.Lock()
+	if q.stopped {
+		q.mu.Unlock()
+		return nil
+	}
+
+	close(q.stopCh)
+	q.stopped = true
+	q.mu.Unlock()
+
+	return q.flush()
+}
+
+// Flush writes the counters now.
+func (q *Quota) Flush() error {
+	return q.flush()
+}
+
+// Release ends periodic flushing without a final write, once another process has
+// loaded the counters and owns the state file.
+func (q *Quota) Release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.stopped {
+		close(q.stopCh)
+		q.stopped = true
+	}
+}
+
+// bounds returns the label of the period containing now and the time it ends.
+func (q *Quota) bounds(now time.Time) (string, time.Time) {
+	now = now.UTC()
+
+	if q.period == PeriodMonth {
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start.Format("2006-01"), start.AddDate(0, 1, 0)
+	}
+
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	return start.Format("2006-01-02"), start.AddDate(0, 0, 1)
+}
+
+func (q *Quota) load() error {
+	if q.path == "" {
+		return nil
+	}
+
+	raw, err := os.ReadFile(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read quota state: %w", err)
+	}
+
+	if err = json.Unmarshal(raw, &q.counters); err != nil {
+		return fmt.Errorf("decode quota state: %w", err)
+	}
+
+	// A state saved with a larger maxEntries keeps the counters of the current
+	// period first; the rest are dropped.
+	if len(q.counters) > q.maxEntries {
+		period, _ := q.bounds(time.Now())
+		q.swept = period
+
+		for key, c := range q.counters {
+			if c.Period != period {
+				delete(q.counters, key)
+			}
+		}
+
+		for key := range q.counters {
+			if len(q.counters) <= q.maxEntries {
+				break
+			}
+
+			delete(q.counters, key)
+		}
+	}
+
+	return nil
+}
+
+// flush writes the counters of the current period through a temporary file, so
+// a crash mid-write never leaves a truncated state behind.
+func (q *Quota) flush() error {
+	if q.path == "" {
+		return nil
+	}
+
+	period, _ := q.bounds(time.Now())
+
+	q.mu.Lock()
+	if !q.dirty {
+		q.mu.Unlock()
+		return nil
+	}
+
+	for key, c := range q.counters {
+		if c.Period != period {
+			delete(q.counters, key)
+		}
+	}
+
+	raw, err := json.Marshal(q.counters)
+	q.dirty = false
+	q.mu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("encode quota state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), filepath.Base(q.path)+".*")
+	if err != nil {
+		return fmt.Errorf("write quota state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(raw); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write quota state: %w", err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("write quota state: %w", err)
+	}
+
+	if err = os.Rename(tmp.Name(), q.path); err != nil {
+		return fmt.Errorf("write quota state: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package ratelimit
+
+import "time"
+
+type entry struct {
+	key    string
+	bucket bucket
+}
+
+// bucket is the per-key state of an algorithm. idle reports that the bucket is
+// back in its initial state, so cleanup can drop it without changing decisions.
+type bucket interface {
+	allow(now time.Time) bool
+	idle(now time.Time) bool
+}
+
+type fixedWindow struct {
+	limit   int
+	window  time.Duration
+	count   int
+	resetAt time.Time
+}
+
+func (b *fixedWindow) allow(now time.Time) bool {
+	if now.After(b.resetAt) {
+		b.count = 0
+		b.resetAt = now.Add(b.window)
+	}
+
+	if b.count < b.limit {
+		b.count++
+		return true
+	}
+
+	return false
+}
+
+func (b *fixedWindow) idle(now time.Time) bool {
+	return now.After(b.resetAt)
+}
+
+// slidingWindow keeps the times of the requests allowed within the last window.
+type slidingWindow struct {
+	limit  int
+	window time.Duration
+	log    []time.Time
+}
+
+func (b *slidingWindow) allow(now time.Time) bool {
+	b.evict(now)
+
+	if len(b.log) < b.limit {
+		b.log = append(b.log, now)
+		return true
+	}
+
+	return false
+}
+
+func (b *slidingWindow) idle(now time.Time) bool {
+	b.evict(now)
+	return len(b.log) == 0
+}
+
+func (b *slidingWindow) evict(now time.Time) {
+	cutoff := now.Add(-b.window)
+
+	i := 0
+	for i < len(b.log) && !b.log[i].After(cutoff) {
+		i++
+	}
+
+	b.log = b.log[i:]
+}
+
+type tokenBucket struct {
+	capacity float64
+	rate     float64 // Tokens per second.
+	tokens   float64
+	last     time.Time
+}
+
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.refill(now)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true
+	}
+
+	return false
+}
+
+func (b *tokenBucket) idle(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.capacity
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = min(b.capacity, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
@@ -1,46 +1,87 @@
 package ratelimit
 
 import (
+	"container/list"
+	"fmt"
 	"sync"
 	"time"
 )
 
 const (
-	defaultLimit  = 60
-	defaultWindow = 60 * time.Second
-	cleanupEvery  = 10 * time.Second
+	defaultLimit      = 60
+	defaultWindow     = 60 * time.Second
+	defaultMaxEntries = 100_000
+	cleanupEvery      = 10 * time.Second
 )
 
-type entry struct {
-	count   int
-	resetAt time.Time
-}
+// Algorithms selectable with the "algorithm" config key.
+const (
+	AlgorithmFixedWindow   = "fixed_window"
+	AlgorithmSlidingWindow = "sliding_window"
+	AlgorithmTokenBucket   = "token_bucket"
+)
 
 type RateLimit struct {
-	limit   int
-	window  time.Duration
-	mu      sync.Mutex
-	buckets map[string]*entry
+	limit      int
+	maxEntries int
+	newBucket  func(now time.Time) bucket
+	mu         sync.Mutex
+	buckets    map[string]*list.Element
+	recent     *list.List // Of *entry, most recently used first.
 
 	stopCh  chan struct{}
 	stopped bool
 }
 
-func New(cfg map[string]interface{}) *RateLimit {
-	window, err := time.ParseDuration(cfg["window"].(string))
+// New builds a limiter allowing "limit" requests per "window" for each key. The
+// "algorithm" key picks how the window is enforced: fixed_window (the default)
+// resets the count at window boundaries and so lets up to twice the limit through
+// around one; sliding_window counts the requests of the last window exactly;
+// token_bucket refills limit tokens per window, allowing bursts up to "burst"
+// (the limit by default). At most "max_entries" keys are tracked; the least
+// recently seen one is forgotten to make room for a new key.
+func New(cfg map[string]interface{}) (*RateLimit, error) {
+	raw, _ := cfg["window"].(string)
+
+	window, err := time.ParseDuration(raw)
 	if err != nil {
 		window = defaultWindow
 	}
 
-	return &RateLimit{
-		limit:   intFrom(cfg, "limit", defaultLimit),
-		window:  window,
-		mu:      sync.Mutex{},
-		buckets: make(map[string]*entry),
+	rl := &RateLimit{
+		limit:      intFrom(cfg, "limit", defaultLimit),
+		maxEntries: max(1, intFrom(cfg, "max_entries", defaultMaxEntries)),
+		mu:         sync.Mutex{},
+		buckets:    make(map[string]*list.Element),
+		recent:     list.New(),
 
 		stopCh:  make(chan struct{}),
 		stopped: false,
 	}
+
+	algorithm, _ := cfg["algorithm"].(string)
+
+	switch algorithm {
+	case "", AlgorithmFixedWindow:
+		rl.newBucket = func(now time.Time) bucket {
+			return &fixedWindow{limit: rl.limit, window: window, resetAt: now.Add(window)}
+		}
+	case AlgorithmSlidingWindow:
+		rl.newBucket = func(time.Time) bucket {
+			return &slidingWindow{limit: rl.limit, window: window}
+		}
+	case AlgorithmTokenBucket:
+		burst := float64(intFrom(cfg, "burst", rl.limit))
+		rate := float64(rl.limit) / window.Seconds()
+
+		rl.newBucket = func(now time.Time) bucket {
+			return &tokenBucket{capacity: burst, rate: rate, tokens: burst, last: now}
+		}
+	default:
+		return nil, fmt.Errorf("unknown rate limit algorithm %q", algorithm)
+	}
+
+	return rl, nil
 }
 
 func (rl *RateLimit) Start() error {
@@ -81,25 +122,29 @@ func (rl *RateLimit) Allow(key string) bool {
 
 	now := time.Now()
 
-	ent, ok := rl.buckets[key]
-	if !ok || now.After(ent.resetAt) {
-		// New window
-		reset := now.Add(rl.window)
-
-		rl.buckets[key] = &entry{
-			count:   1,
-			resetAt: reset,
-		}
-
-		return true
+	if el, ok := rl.buckets[key]; ok {
+		rl.recent.MoveToFront(el)
+		return el.Value.(*entry).bucket.allow(now)
 	}
 
-	if ent.count < rl.limit {
-		ent.count++
-		return true
+	if rl.recent.Len() >= rl.maxEntries {
+		oldest := rl.recent.Back()
+		rl.recent.Remove(oldest)
+		delete(rl.buckets, oldest.Value.(*entry).key)
 	}
 
-	return false
+	b := rl.newBucket(now)
+	rl.buckets[key] = rl.recent.PushFront(&entry{key: key, bucket: b})
+
+	return b.allow(now)
+}
+
+// Len returns the number of keys currently tracked.
+func (rl *RateLimit) Len() int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	return rl.recent.Len()
 }
 
 func (rl *RateLimit) cleanup() {
@@ -108,8 +153,9 @@ func (rl *RateLimit) cleanup() {
 
 	now := time.Now()
 
-	for key, ent := range rl.buckets {
-		if now.After(ent.resetAt) {
+	for key, el := range rl.buckets {
+		if el.Value.(*entry).bucket.idle(now) {
+			rl.recent.Remove(el)
 			delete(rl.buckets, key)
 		}
 	}
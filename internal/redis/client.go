@@ -0,0 +1,296 @@
+// Package redis is a minimal RESP client covering the commands kono needs.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const maxIdleConns = 16
+
+var errClosed = errors.New("redis client closed")
+
+// ReplyError is an error returned by the server, e.g. WRONGTYPE or NOAUTH.
+type ReplyError string
+
+func (e ReplyError) Error() string { return "redis: " + string(e) }
+
+type Options struct {
+	Address  string
+	Password string
+	DB       int
+	// Timeout bounds dialing and every command round trip.
+	Timeout time.Duration
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Client keeps a small pool of idle connections and dials more on demand.
+type Client struct {
+	opts Options
+
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+func New(opts Options) *Client {
+	return &Client{opts: opts}
+}
+
+// Get returns the value of key; found is false when the key does not exist.
+func (c *Client) Get(ctx context.Context, key string) (value []byte, found bool, err error) {
+	reply, err := c.do(ctx, "GET", key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if reply == nil {
+		return nil, false, nil
+	}
+
+	b, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: unexpected GET reply %T", reply)
+	}
+
+	return b, true, nil
+}
+
+// Set stores value under key, expiring it after ttl.
+func (c *Client) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := c.do(ctx, "SET", key, string(value), "PX", strconv.FormatInt(max(1, ttl.Milliseconds()), 10))
+	return err
+}
+
+// Scan returns one page of the keys matching pattern, starting at cursor; the
+// returned cursor is "0" once the iteration is complete.
+func (c *Client) Scan(ctx context.Context, cursor, pattern string) (next string, keys []string, err error) {
+	reply, err := c.do(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", "100")
+	if err != nil {
+		return "", nil, err
+	}
+
+	items, ok := reply.([]any)
+	if !ok || len(items) != 2 {
+		return "", nil, fmt.Errorf("redis: unexpected SCAN reply %T", reply)
+	}
+
+	nextCursor, ok := items[0].([]byte)
+	if !ok {
+		return "", nil, fmt.Errorf("redis: unexpected SCAN cursor %T", items[0])
+	}
+
+	page, _ := items[1].([]any)
+	for _, item := range page {
+		if key, isBulk := item.([]byte); isBulk {
+			keys = append(keys, string(key))
+		}
+	}
+
+	return string(nextCursor), keys, nil
+}
+
+// Del removes keys and returns how many existed.
+func (c *Client) Del(ctx context.Context, keys ...string) (int64, error) {
+	reply, err := c.do(ctx, append([]string{"DEL"}, keys...)...)
+	if err != nil {
+		return 0, err
+	}
+
+	n, _ := reply.(int64)
+
+	return n, nil
+}
+
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+
+	for _, cn := range c.idle {
+		_ = cn.Close()
+	}
+
+	c.idle = nil
+
+	return nil
+}
+
+func (c *Client) do(ctx context.Context, args ...string) (any, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := c.roundTrip(ctx, cn, args)
+
+	var replyErr ReplyError
+	if err != nil && !errors.As(err, &replyErr) {
+		_ = cn.Close()
+		return nil, err
+	}
+
+	c.put(cn)
+
+	return reply, err
+}
+
+func (c *Client) roundTrip(ctx context.Context, cn *conn, args []string) (any, error) {
+	deadline := time.Now().Add(c.opts.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	if err := cn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	if _, err := cn.Write(encodeCommand(args)); err != nil {
+		return nil, fmt.Errorf("redis: write: %w", err)
+	}
+
+	return readReply(cn.r)
+}
+
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, errClosed
+	}
+
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	dialer := net.Dialer{Timeout: c.opts.Timeout}
+
+	nc, err := dialer.DialContext(ctx, "tcp", c.opts.Address)
+	if err != nil {
+		return nil, fmt.Errorf("redis: dial: %w", err)
+	}
+
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc)}
+
+	if c.opts.Password != "" {
+		if _, err = c.roundTrip(ctx, cn, []string{"AUTH", c.opts.Password}); err != nil {
+			_ = nc.Close()
+			return nil, err
+		}
+	}
+
+	if c.opts.DB != 0 {
+		if _, err = c.roundTrip(ctx, cn, []string{"SELECT", strconv.Itoa(c.opts.DB)}); err != nil {
+			_ = nc.Close()
+			return nil, err
+		}
+	}
+
+	return cn, nil
+}
+
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || len(c.idle) >= maxIdleConns {
+		_ = cn.Close()
+		return
+	}
+
+	c.idle = append(c.idle, cn)
+}
+
+func encodeCommand(args []string) []byte {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+
+	return buf
+}
+
+// readReply decodes one RESP2 reply: simple strings as string, integers as int64,
+// bulk strings as []byte, nil bulk strings as nil and arrays as []any.
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("redis: read: %w", err)
+	}
+
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+
+	kind, payload := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, ReplyError(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", payload)
+		}
+
+		if n < 0 {
+			return nil, nil
+		}
+
+		buf := make([]byte, n+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, fmt.Errorf("redis: read: %w", err)
+		}
+
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed array length %q", payload)
+		}
+
+		if n < 0 {
+			return nil, nil
+		}
+
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+	}
+}
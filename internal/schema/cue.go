@@ -0,0 +1,181 @@
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// durationPattern matches time.ParseDuration strings such as "1m30s".
+const durationPattern = `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+
+// refPattern matches values with a ${...} reference.
+const refPattern = `\$\{[^}]+\}`
+
+var cueIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// CUE renders s as CUE definitions in package pkg. The root struct is the
+// #<Name> definition to vet documents against, e.g.
+// cue vet kono.yaml kono.cue -d '#Config'.
+func CUE(s *Schema, pkg string) []byte {
+	e := &cueEmitter{}
+
+	var body bytes.Buffer
+
+	for _, name := range s.Order {
+		fmt.Fprintf(&body, "#%s: %s\n\n", name, e.structBody(s.Definitions[name], 0))
+	}
+
+	fmt.Fprintf(&body, "#Duration: =~%s | int | #Ref\n\n", strconv.Quote(durationPattern))
+	fmt.Fprintf(&body, "#Ref: =~%s\n", strconv.Quote(refPattern))
+
+	var out bytes.Buffer
+
+	fmt.Fprintf(&out, "// Code generated by kono config cue. DO NOT EDIT.\n\npackage %s\n\n", pkg)
+
+	if e.usesList {
+		out.WriteString("import \"list\"\n\n")
+	}
+
+	out.Write(body.Bytes())
+
+	return out.Bytes()
+}
+
+type cueEmitter struct {
+	usesList bool
+}
+
+func (e *cueEmitter) structBody(t *Type, depth int) string {
+	if len(t.Fields) == 0 {
+		return "{...}"
+	}
+
+	indent := strings.Repeat("\t", depth+1)
+
+	var b strings.Builder
+
+	b.WriteString("{\n")
+
+	for _, f := range t.Fields {
+		marker := "?"
+		if f.Required {
+			marker = "!"
+		}
+
+		name := f.Name
+		if !cueIdentifier.MatchString(name) {
+			name = strconv.Quote(name)
+		}
+
+		fmt.Fprintf(&b, "%s%s%s: %s\n", indent, name, marker, e.field(f, depth+1))
+	}
+
+	b.WriteString(strings.Repeat("\t", depth) + "}")
+
+	return b.String()
+}
+
+func (e *cueEmitter) field(f Field, depth int) string {
+	expr := e.expr(f.Type, depth)
+	if f.Default == "" {
+		return expr
+	}
+
+	return "*" + cueLiteral(f.Type, f.Default) + " | " + expr
+}
+
+// expr describes t. Typed scalars also accept a string with a ${...}
+// reference (#Ref), which the loader resolves before decoding.
+func (e *cueEmitter) expr(t *Type, depth int) string {
+	expr := e.baseExpr(t, depth)
+	if !interpolated(t) || t.Kind == KindDuration {
+		return expr
+	}
+
+	return expr + " | #Ref"
+}
+
+func (e *cueEmitter) baseExpr(t *Type, depth int) string {
+	switch t.Kind {
+	case KindString:
+		if len(t.Enum) > 0 {
+			return enum(t.Enum)
+		}
+
+		if t.Prefix != "" {
+			return "string & =~" + strconv.Quote("^"+regexp.QuoteMeta(t.Prefix))
+		}
+
+		return "string"
+	case KindBool:
+		return "bool"
+	case KindInt, KindFloat:
+		base := "int"
+		if t.Kind == KindFloat {
+			base = "number"
+		}
+
+		if len(t.Enum) > 0 {
+			return strings.Join(t.Enum, " | ")
+		}
+
+		return base + bounds(t)
+	case KindDuration:
+		return "#Duration"
+	case KindList:
+		list := "[..." + e.expr(t.Elem, depth) + "]"
+		if t.MinSize != nil && *t.MinSize > 0 {
+			e.usesList = true
+			return fmt.Sprintf("list.MinItems(%d) & %s", *t.MinSize, list)
+		}
+
+		return list
+	case KindMap:
+		return "{[string]: " + e.expr(t.Elem, depth) + "}"
+	case KindStruct:
+		if t.Name != "" {
+			return "#" + t.Name
+		}
+
+		return e.structBody(t, depth)
+	case KindStringOrList:
+		return "string | [...string]"
+	default:
+		return "_"
+	}
+}
+
+func bounds(t *Type) string {
+	var b strings.Builder
+
+	if t.Min != nil {
+		b.WriteString(" & >=" + strconv.FormatFloat(*t.Min, 'f', -1, 64))
+	}
+
+	if t.Max != nil {
+		b.WriteString(" & <=" + strconv.FormatFloat(*t.Max, 'f', -1, 64))
+	}
+
+	return b.String()
+}
+
+func enum(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = strconv.Quote(v)
+	}
+
+	return strings.Join(quoted, " | ")
+}
+
+func cueLiteral(t *Type, value string) string {
+	switch t.Kind {
+	case KindBool, KindInt, KindFloat:
+		return value
+	default:
+		return strconv.Quote(value)
+	}
+}
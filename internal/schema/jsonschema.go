@@ -0,0 +1,182 @@
+package schema
+
+import (
+	"encoding/json"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// Components are the config schemas of named plugins, keyed by the definition
+// that selects them with its name and source fields (e.g. "MiddlewareConfig").
+// They constrain the definition's config field.
+type Components map[string]ComponentSet
+
+type ComponentSet struct {
+	Source  string                    // Value of the source field the schemas apply to.
+	Schemas map[string]map[string]any // By component name.
+}
+
+// JSONSchema renders s as a JSON Schema (draft 2020-12) document. Structs do not
+// allow unknown keys, so typos are reported by editors.
+func JSONSchema(s *Schema, id string, components Components) ([]byte, error) {
+	defs := make(map[string]any, len(s.Definitions))
+
+	for name, def := range s.Definitions {
+		obj := jsonObject(def)
+
+		if set := components[name]; len(set.Schemas) > 0 {
+			rules := make([]any, 0, len(set.Schemas))
+
+			for _, component := range slices.Sorted(maps.Keys(set.Schemas)) {
+				rules = append(rules, map[string]any{
+					"if": map[string]any{
+						"properties": map[string]any{
+							"name":   map[string]any{"const": component},
+							"source": map[string]any{"const": set.Source},
+						},
+						"required": []string{"name", "source"},
+					},
+					"then": map[string]any{
+						"properties": map[string]any{"config": set.Schemas[component]},
+					},
+				})
+			}
+
+			obj["allOf"] = rules
+		}
+
+		defs[name] = obj
+	}
+
+	doc := map[string]any{
+		"$schema": jsonSchemaDraft,
+		"$defs":   defs,
+	}
+
+	if id != "" {
+		doc["$id"] = id
+	}
+
+	maps.Copy(doc, jsonType(s.Root))
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// jsonType describes t. Typed scalars also accept a string with a ${...}
+// reference, which the loader resolves before decoding.
+func jsonType(t *Type) map[string]any {
+	out := jsonBaseType(t)
+	if !interpolated(t) {
+		return out
+	}
+
+	return map[string]any{"anyOf": []any{out, map[string]any{"type": "string", "pattern": refPattern}}}
+}
+
+func jsonBaseType(t *Type) map[string]any {
+	switch t.Kind {
+	case KindString:
+		out := map[string]any{"type": "string"}
+		if len(t.Enum) > 0 {
+			out["enum"] = t.Enum
+		}
+
+		if t.Prefix != "" {
+			out["pattern"] = "^" + regexp.QuoteMeta(t.Prefix)
+		}
+
+		return out
+	case KindBool:
+		return map[string]any{"type": "boolean"}
+	case KindInt, KindFloat:
+		out := map[string]any{"type": "integer"}
+		if t.Kind == KindFloat {
+			out["type"] = "number"
+		}
+
+		if t.Min != nil {
+			out["minimum"] = *t.Min
+		}
+
+		if t.Max != nil {
+			out["maximum"] = *t.Max
+		}
+
+		return out
+	case KindDuration:
+		return map[string]any{"type": []string{"string", "integer"}, "pattern": durationPattern}
+	case KindList:
+		out := map[string]any{"type": "array", "items": jsonType(t.Elem)}
+		if t.MinSize != nil {
+			out["minItems"] = *t.MinSize
+		}
+
+		return out
+	case KindMap:
+		return map[string]any{"type": "object", "additionalProperties": jsonType(t.Elem)}
+	case KindStruct:
+		if t.Name != "" {
+			return map[string]any{"$ref": "#/$defs/" + t.Name}
+		}
+
+		return jsonObject(t)
+	case KindStringOrList:
+		return map[string]any{"oneOf": []any{
+			map[string]any{"type": "string"},
+			map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		}}
+	default:
+		return map[string]any{}
+	}
+}
+
+func jsonObject(t *Type) map[string]any {
+	props := make(map[string]any, len(t.Fields))
+
+	var required []string
+
+	for _, f := range t.Fields {
+		prop := jsonType(f.Type)
+		if f.Default != "" {
+			prop["default"] = jsonLiteral(f.Type, f.Default)
+		}
+
+		if f.Deprecated {
+			prop["deprecated"] = true
+		}
+
+		props[f.Name] = prop
+
+		if f.Required {
+			required = append(required, f.Name)
+		}
+	}
+
+	out := map[string]any{"type": "object", "properties": props}
+	if len(t.Fields) > 0 {
+		out["additionalProperties"] = false
+	}
+
+	if len(required) > 0 {
+		out["required"] = required
+	}
+
+	return out
+}
+
+func jsonLiteral(t *Type, value string) any {
+	switch t.Kind {
+	case KindBool:
+		b, _ := strconv.ParseBool(value)
+		return b
+	case KindInt, KindFloat:
+		f, _ := strconv.ParseFloat(value, 64)
+		return f
+	default:
+		return value
+	}
+}
@@ -0,0 +1,241 @@
+// Package schema describes the configuration structs as a tree of types with
+// the constraints of their yaml, default and validate tags, and renders it as
+// CUE or JSON Schema.
+package schema
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Kind int
+
+const (
+	KindAny Kind = iota
+	KindString
+	KindBool
+	KindInt
+	KindFloat
+	KindDuration
+	KindList
+	KindMap
+	KindStruct
+	// KindStringOrList accepts a single string or a list of strings.
+	KindStringOrList
+)
+
+type Type struct {
+	Kind Kind
+	// Name is set on structs; they are rendered once as a definition and
+	// referenced by name.
+	Name   string
+	Elem   *Type // List and Map elements.
+	Fields []Field
+
+	Enum    []string
+	Min     *float64
+	Max     *float64
+	Prefix  string // Required string prefix from startswith.
+	MinSize *int   // Minimum list length.
+}
+
+type Field struct {
+	Name     string
+	Type     *Type
+	Required bool
+	Default  string
+	// Deprecated marks keys that are still accepted but should be replaced.
+	Deprecated bool
+}
+
+// Schema is a root type and every struct definition reachable from it.
+type Schema struct {
+	Root        *Type
+	Definitions map[string]*Type
+	// Order lists the definitions in the order they were first reached.
+	Order []string
+}
+
+// Alias adds name to the definition def as a deprecated copy of its field of,
+// for keys an older schema version used. It reports false if either is missing.
+func (s *Schema) Alias(def, name, of string) bool {
+	t := s.Definitions[def]
+	if t == nil {
+		return false
+	}
+
+	for _, f := range t.Fields {
+		if f.Name == of {
+			f.Name, f.Required, f.Deprecated = name, false, true
+			t.Fields = append(t.Fields, f)
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// Overrides replaces the reflected type of a named Go type, for types with a
+// custom YAML decoding.
+type Overrides map[reflect.Type]*Type
+
+// Reflect describes t, which must be a struct.
+func Reflect(t reflect.Type, overrides Overrides) *Schema {
+	s := &Schema{Definitions: make(map[string]*Type)}
+	r := &reflector{schema: s, overrides: overrides}
+	s.Root = r.typeOf(t)
+
+	return s
+}
+
+type reflector struct {
+	schema    *Schema
+	overrides Overrides
+}
+
+var durationType = reflect.TypeFor[time.Duration]()
+
+func (r *reflector) typeOf(t reflect.Type) *Type {
+	if o, ok := r.overrides[t]; ok {
+		return o
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == durationType {
+		return &Type{Kind: KindDuration}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Type{Kind: KindString}
+	case reflect.Bool:
+		return &Type{Kind: KindBool}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Type{Kind: KindInt}
+	case reflect.Float32, reflect.Float64:
+		return &Type{Kind: KindFloat}
+	case reflect.Slice, reflect.Array:
+		return &Type{Kind: KindList, Elem: r.typeOf(t.Elem())}
+	case reflect.Map:
+		return &Type{Kind: KindMap, Elem: r.typeOf(t.Elem())}
+	case reflect.Struct:
+		return r.structOf(t)
+	default:
+		return &Type{Kind: KindAny}
+	}
+}
+
+func (r *reflector) structOf(t reflect.Type) *Type {
+	name := t.Name()
+	if def, ok := r.schema.Definitions[name]; ok && name != "" {
+		return &Type{Kind: KindStruct, Name: def.Name}
+	}
+
+	def := &Type{Kind: KindStruct, Name: name}
+	if name != "" {
+		r.schema.Definitions[name] = def
+		r.schema.Order = append(r.schema.Order, name)
+	}
+
+	for i := range t.NumField() {
+		sf := t.Field(i)
+
+		key, _, _ := strings.Cut(sf.Tag.Get("yaml"), ",")
+		if key == "" || key == "-" || !sf.IsExported() {
+			continue
+		}
+
+		// Copy, so constraints of this field do not leak into shared structs.
+		ft := *r.typeOf(sf.Type)
+		field := Field{Name: key, Type: &ft, Default: sf.Tag.Get("default")}
+
+		applyValidate(&field, sf.Tag.Get("validate"))
+
+		def.Fields = append(def.Fields, field)
+	}
+
+	if name == "" {
+		return def
+	}
+
+	return &Type{Kind: KindStruct, Name: name}
+}
+
+// interpolated reports whether t is a scalar whose values a plain string would
+// not satisfy, so a ${...} reference has to be allowed explicitly.
+func interpolated(t *Type) bool {
+	switch t.Kind {
+	case KindBool, KindInt, KindFloat, KindDuration:
+		return true
+	case KindString:
+		return len(t.Enum) > 0 || t.Prefix != ""
+	default:
+		return false
+	}
+}
+
+// applyValidate translates the validate rules that hold unconditionally.
+// Conditional rules (required_if, excluded_with, ...) leave the field optional.
+// Rules after dive apply to list elements.
+func applyValidate(f *Field, tag string) {
+	if tag == "" {
+		return
+	}
+
+	target := f.Type
+
+	for rule := range strings.SplitSeq(tag, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+
+		switch name {
+		case "dive":
+			if target.Elem == nil {
+				return
+			}
+
+			elem := *target.Elem
+			target.Elem = &elem
+			target = &elem
+		case "required":
+			if target == f.Type {
+				f.Required = true
+			}
+		case "oneof":
+			target.Enum = strings.Fields(param)
+		case "startswith":
+			target.Prefix = param
+		case "min", "gte":
+			setBound(target, param, &target.Min)
+		case "max", "lte":
+			setBound(target, param, &target.Max)
+		default:
+		}
+	}
+}
+
+// setBound records numeric bounds; on lists min is a length. Duration bounds
+// such as min=1s cannot be expressed and are dropped.
+func setBound(t *Type, param string, dst **float64) {
+	v, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return
+	}
+
+	switch t.Kind {
+	case KindInt, KindFloat:
+		*dst = &v
+	case KindList:
+		if dst == &t.Min {
+			n := int(v)
+			t.MinSize = &n
+		}
+	default:
+	}
+}
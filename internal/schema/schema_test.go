@@ -0,0 +1,91 @@
+package schema
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Port    int           `yaml:"port" validate:"min=1,max=65535"`
+	Debug   bool          `yaml:"debug"`
+	Level   string        `yaml:"level" validate:"oneof=debug info"`
+	Name    string        `yaml:"name"`
+	Timeout time.Duration `yaml:"timeout"`
+	Deny    []string      `yaml:"header_deny"`
+}
+
+func testSchema(t *testing.T) map[string]any {
+	t.Helper()
+
+	s := Reflect(reflect.TypeFor[testConfig](), nil)
+	if !s.Alias("testConfig", "header_blacklist", "header_deny") {
+		t.Fatal("expected the alias to be added")
+	}
+
+	data, err := JSONSchema(s, "", nil)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+
+	var doc map[string]any
+	if err = json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	return doc["$defs"].(map[string]any)["testConfig"].(map[string]any)["properties"].(map[string]any)
+}
+
+func TestJSONSchema_AllowsReferencesInTypedScalars(t *testing.T) {
+	props := testSchema(t)
+
+	for _, name := range []string{"port", "debug", "level", "timeout"} {
+		alternatives, ok := props[name].(map[string]any)["anyOf"].([]any)
+		if !ok || len(alternatives) != 2 {
+			t.Fatalf("%s: expected a value or a reference, got %v", name, props[name])
+		}
+
+		ref := alternatives[1].(map[string]any)
+		if ref["type"] != "string" || ref["pattern"] != refPattern {
+			t.Fatalf("%s: expected a ${...} string, got %v", name, ref)
+		}
+	}
+
+	// Plain strings take references as they are.
+	if props["name"].(map[string]any)["type"] != "string" {
+		t.Fatalf("expected name to stay a plain string, got %v", props["name"])
+	}
+}
+
+func TestSchema_Alias(t *testing.T) {
+	props := testSchema(t)
+
+	alias, ok := props["header_blacklist"].(map[string]any)
+	if !ok || alias["deprecated"] != true || alias["type"] != "array" {
+		t.Fatalf("expected a deprecated copy of header_deny, got %v", props["header_blacklist"])
+	}
+
+	if s := Reflect(reflect.TypeFor[testConfig](), nil); s.Alias("testConfig", "old", "missing") {
+		t.Fatal("expected an alias of a missing field to be refused")
+	}
+}
+
+func TestCUE_AllowsReferencesInTypedScalars(t *testing.T) {
+	out := string(CUE(Reflect(reflect.TypeFor[testConfig](), nil), "kono"))
+
+	for _, want := range []string{
+		"port?: int & >=1 & <=65535 | #Ref\n",
+		"debug?: bool | #Ref\n",
+		`level?: "debug" | "info" | #Ref` + "\n",
+		"name?: string\n",
+		"timeout?: #Duration\n",
+		"| int | #Ref\n",
+		"#Ref: =~",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// An upgraded kono process inherits the listening sockets as extra files from
+// fd 3 on. listenFDsEnv lists their "network:address" keys in fd order and
+// readyFDEnv names the pipe the new process writes to once it is listening.
+const (
+	listenFDsEnv = "KONO_LISTEN_FDS"
+	readyFDEnv   = "KONO_READY_FD"
+
+	firstInheritedFD = 3
+)
+
+// boundListener is a bound socket with the network and configured address it was
+// bound for, which key it on handoff.
+type boundListener struct {
+	net.Listener
+	network string
+	address string
+}
+
+func (l boundListener) key() string { return l.network + ":" + l.address }
+
+// inheritedFiles holds the sockets handed over by the previous process until a
+// listener with the same key claims them.
+var inheritedFiles = struct {
+	once  sync.Once
+	mu    sync.Mutex
+	files map[string]*os.File
+}{}
+
+func loadInherited() {
+	inheritedFiles.files = make(map[string]*os.File)
+
+	keys := os.Getenv(listenFDsEnv)
+	if keys == "" {
+		return
+	}
+
+	for i, key := range strings.Split(keys, ",") {
+		fd := uintptr(firstInheritedFD + i)
+		inheritedFiles.files[key] = os.NewFile(fd, key)
+	}
+
+	_ = os.Unsetenv(listenFDsEnv)
+}
+
+// takeInherited returns the socket inherited for network and address, if any.
+func takeInherited(network, address string) (net.Listener, bool, error) {
+	inheritedFiles.once.Do(loadInherited)
+
+	inheritedFiles.mu.Lock()
+	defer inheritedFiles.mu.Unlock()
+
+	key := network + ":" + address
+
+	f, ok := inheritedFiles.files[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	delete(inheritedFiles.files, key)
+	defer f.Close()
+
+	lis, err := net.FileListener(f)
+	if err != nil {
+		return nil, true, fmt.Errorf("inherit %s: %w", key, err)
+	}
+
+	return lis, true, nil
+}
+
+// closeUnclaimed closes inherited sockets no listener asked for, such as ones
+// removed from the configuration before the upgrade.
+func closeUnclaimed() {
+	inheritedFiles.once.Do(loadInherited)
+
+	inheritedFiles.mu.Lock()
+	defer inheritedFiles.mu.Unlock()
+
+	for key, f := range inheritedFiles.files {
+		_ = f.Close()
+		delete(inheritedFiles.files, key)
+	}
+}
+
+// NotifyReady tells the process that started this one through Upgrade that its
+// listeners are bound. It does nothing when the process was not started by an
+// upgrade.
+func NotifyReady() error {
+	raw := os.Getenv(readyFDEnv)
+	if raw == "" {
+		return nil
+	}
+
+	_ = os.Unsetenv(readyFDEnv)
+
+	fd, err := strconv.Atoi(raw)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", readyFDEnv, raw, err)
+	}
+
+	pipe := os.NewFile(uintptr(fd), "ready")
+	defer pipe.Close()
+
+	_, err = pipe.Write([]byte("ready"))
+
+	return err
+}
+
+// Upgrade starts the current executable with the same arguments and hands it the
+// bound sockets, so it accepts connections on them next to this process. It
+// returns once the new process reports that it is listening; the caller then
+// drains this process as for a normal shutdown. The quota counters are flushed for
+// the new process to load, and only it writes them once it is listening. The new process is killed when
+// it exits or ctx expires before that.
+func (s *Server) Upgrade(ctx context.Context) error {
+	s.mu.Lock()
+	listeners := append([]boundListener(nil), s.bound...)
+	s.mu.Unlock()
+
+	if len(listeners) == 0 {
+		return errors.New("server is not listening")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("resolve executable: %w", err)
+	}
+
+	files := make([]*os.File, 0, len(listeners)+1)
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+
+	keys := make([]string, 0, len(listeners))
+
+	for _, l := range listeners {
+		filer, ok := l.Listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("listener %s cannot be handed over", l.key())
+		}
+
+		f, fileErr := filer.File()
+		if fileErr != nil {
+			return fmt.Errorf("dup listener %s: %w", l.key(), fileErr)
+		}
+
+		files = append(files, f)
+		keys = append(keys, l.key())
+	}
+
+	s.mu.Lock()
+	router := s.router
+	s.mu.Unlock()
+
+	if err = router.FlushQuota(); err != nil {
+		return fmt.Errorf("flush quota state: %w", err)
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("create ready pipe: %w", err)
+	}
+	defer readyR.Close()
+
+	files = append(files, readyW)
+
+	cmd := exec.Command(exe, os.Args[1:]...) //nolint:gosec // re-executes this binary.
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(upgradeEnviron(),
+		listenFDsEnv+"="+strings.Join(keys, ","),
+		readyFDEnv+"="+strconv.Itoa(firstInheritedFD+len(keys)),
+	)
+
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("start new process: %w", err)
+	}
+
+	// Only the child may hold the write end, so the read sees EOF if it exits.
+	_ = readyW.Close()
+	files = files[:len(files)-1]
+
+	ready := make(chan error, 1)
+	go func() {
+		msg, readErr := io.ReadAll(readyR)
+		if readErr == nil && len(msg) == 0 {
+			readErr = errors.New("new process exited before listening")
+		}
+
+		ready <- readErr
+	}()
+
+	select {
+	case err = <-ready:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+
+		return fmt.Errorf("upgrade: %w", err)
+	}
+
+	// The new process loaded the quota counters and flushes them from now on.
+	router.ReleaseQuota()
+
+	// The new process serves the same socket files, which must outlive us.
+	for _, l := range listeners {
+		if ul, ok := l.Listener.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+
+	go func() { _ = cmd.Wait() }()
+
+	return nil
+}
+
+func upgradeEnviron() []string {
+	env := os.Environ()
+	out := env[:0]
+
+	for _, kv := range env {
+		if strings.HasPrefix(kv, listenFDsEnv+"=") || strings.HasPrefix(kv, readyFDEnv+"=") {
+			continue
+		}
+
+		out = append(out, kv)
+	}
+
+	return out
+}
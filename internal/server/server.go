@@ -2,64 +2,388 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 
 	"github.com/starwalkn/kono"
 	"github.com/starwalkn/kono/internal/otelcommon"
 )
 
+// reloadDrainTimeout is how long a replaced router keeps serving the requests it
+// already accepted before it is closed.
+const reloadDrainTimeout = 30 * time.Second
+
+// providerFlushTimeout bounds closing a router and flushing observability
+// providers, which still runs when the shutdown grace has expired.
+const providerFlushTimeout = 5 * time.Second
+
 type Server struct {
-	http      *http.Server
+	http     []httpListener // the main listener first, then server.listeners and admin.
+	grpc     *grpc.Server   // nil unless the transcoding listener is enabled.
+	grpcAddr string
+	version  string
+	log      *zap.Logger
+
+	// Handlers are swapped as a whole on Reload; listeners keep pointing here.
+	handler *swapHandler
+	routes  *swapHandler // the bare router, for the gRPC transcoder.
+	admin   *swapHandler // nil unless the admin listener is enabled.
+
+	drain    *drainState
+	shutdown kono.ShutdownConfig
+
+	reloadMu  sync.Mutex // one reload at a time, each building on the last router.
+	mu        sync.Mutex
 	router    *kono.Router
 	providers []otelcommon.Provider
-	log       *zap.Logger
+	retiring  map[*time.Timer]func() // replaced routers waiting to be closed.
+	bound     []boundListener        // sockets bound by Listen, for Upgrade.
+}
+
+// swapHandler serves through whichever handler was stored last.
+type swapHandler struct {
+	h atomic.Pointer[http.Handler]
+}
+
+func newSwapHandler(h http.Handler) *swapHandler {
+	s := &swapHandler{}
+	s.store(h)
+
+	return s
+}
+
+func (s *swapHandler) store(h http.Handler) { s.h.Store(&h) }
+
+func (s *swapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*s.h.Load()).ServeHTTP(w, r)
+}
+
+// drainState counts the requests in flight on the gateway listeners across
+// reloads and tells the health endpoint that shutdown has started.
+type drainState struct {
+	draining atomic.Bool
+	active   atomic.Int64
+}
+
+func (d *drainState) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.active.Add(1)
+		defer d.active.Add(-1)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// httpListener is an HTTP server with the socket it binds: a TCP address or,
+// for network "unix", a socket path.
+type httpListener struct {
+	server  *http.Server
+	network string
+	address string
 }
 
 func New(ctx context.Context, cfg kono.GatewayConfig, version string, log *zap.Logger) (*Server, error) {
-	bundle, err := bootstrapRouter(ctx, cfg, version, log)
+	bundle, err := bootstrapRouter(ctx, cfg, version, nil, log)
 	if err != nil {
 		return nil, fmt.Errorf("bootstrap router: %w", err)
 	}
 
-	handler := buildHandler(bundle)
+	drain := &drainState{}
+	handler := newSwapHandler(buildHandler(bundle, drain))
+	routes := newSwapHandler(bundle.Router)
+	tracked := drain.track(handler)
 
-	return &Server{
-		http: &http.Server{
-			Addr:         fmt.Sprintf(":%d", cfg.Server.Port),
-			Handler:      handler,
-			ReadTimeout:  cfg.Server.Timeout,
-			WriteTimeout: cfg.Server.Timeout,
-		},
+	primaryAddr := fmt.Sprintf(":%d", cfg.Server.Port)
+	if cfg.Server.Socket != "" {
+		primaryAddr = ""
+	}
+
+	primary, err := newHTTPListener(kono.DefaultListener, primaryAddr, cfg.Server.Socket, cfg.Server.TLS, tracked, cfg.Server)
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &Server{
+		http:      []httpListener{primary},
+		version:   version,
+		handler:   handler,
+		routes:    routes,
+		drain:     drain,
+		shutdown:  cfg.Server.Shutdown,
 		router:    bundle.Router,
 		providers: []otelcommon.Provider{bundle.MeterProvider, bundle.TracerProvider},
 		log:       log,
-	}, nil
+	}
+
+	for _, l := range cfg.Server.Listeners {
+		hl, listenerErr := newHTTPListener(l.Name, l.Address, l.Socket, l.TLS, tracked, cfg.Server)
+		if listenerErr != nil {
+			return nil, listenerErr
+		}
+
+		srv.http = append(srv.http, hl)
+	}
+
+	if cfg.Server.Admin.Enabled {
+		srv.admin = newSwapHandler(kono.NewAdminHandler(bundle.Router, cfg.Server.Admin.Token, log.Named("admin")))
+
+		hl, listenerErr := newHTTPListener("admin", cfg.Server.Admin.Address, "", kono.TLSConfig{}, srv.admin, cfg.Server)
+		if listenerErr != nil {
+			return nil, listenerErr
+		}
+
+		srv.http = append(srv.http, hl)
+	}
+
+	if cfg.Server.GRPC.Enabled {
+		grpcHandler, grpcErr := kono.NewGRPCHandler(cfg.Server.GRPC, routes, log.Named("grpc"))
+		if grpcErr != nil {
+			return nil, fmt.Errorf("build grpc transcoder: %w", grpcErr)
+		}
+
+		srv.grpc = grpc.NewServer(grpc.UnknownServiceHandler(grpcHandler))
+		srv.grpcAddr = fmt.Sprintf(":%d", cfg.Server.GRPC.Port)
+	}
+
+	return srv, nil
 }
 
+// DryRun builds everything New builds (router, plugins and middlewares, TLS
+// certificates, the gRPC transcoder) and releases it again without binding any
+// listener.
+func DryRun(ctx context.Context, cfg kono.GatewayConfig, version string, log *zap.Logger) error {
+	srv, err := New(ctx, cfg, version, log)
+	if err != nil {
+		return err
+	}
+
+	return closeBundle(ctx, srv.router, srv.providers)
+}
+
+// newHTTPListener builds the server for one listener, bound to socket when set and
+// to addr otherwise. Requests it accepts carry the listener name so the router only
+// matches flows bound to it.
+func newHTTPListener(name, addr, socket string, tlsCfg kono.TLSConfig, handler http.Handler, cfg kono.ServerConfig) (httpListener, error) {
+	tlsConfig, err := buildTLSConfig(tlsCfg)
+	if err != nil {
+		return httpListener{}, fmt.Errorf("build tls config for listener %q: %w", name, err)
+	}
+
+	hl := httpListener{
+		server: &http.Server{
+			Addr:              addr,
+			Handler:           handler,
+			ReadTimeout:       cfg.Timeout,
+			WriteTimeout:      cfg.Timeout,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+			TLSConfig:         tlsConfig,
+			BaseContext: func(net.Listener) context.Context {
+				return kono.WithListener(context.Background(), name)
+			},
+		},
+		network: "tcp",
+		address: addr,
+	}
+
+	if socket != "" {
+		hl.network, hl.address = "unix", socket
+	}
+
+	return hl, nil
+}
+
+// listen binds the listener socket, or takes it over from the process that
+// started this one through Upgrade. A stale unix socket left by an unclean exit
+// is removed first; the socket file is unlinked again when the server closes.
+func (l httpListener) listen() (net.Listener, error) {
+	return listen(l.network, l.address)
+}
+
+func listen(network, address string) (net.Listener, error) {
+	if lis, ok, err := takeInherited(network, address); ok {
+		return lis, err
+	}
+
+	if network == "unix" {
+		if info, err := os.Stat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if err = os.Remove(address); err != nil {
+				return nil, fmt.Errorf("remove stale socket: %w", err)
+			}
+		}
+	}
+
+	return net.Listen(network, address)
+}
+
+func (l httpListener) serve(lis net.Listener) error {
+	if l.server.TLSConfig != nil {
+		return l.server.ServeTLS(lis, "", "")
+	}
+
+	return l.server.Serve(lis)
+}
+
+// Listen binds the gRPC transcoding listener, if enabled, and every HTTP
+// listener. Sockets inherited from an upgrading process are reused.
+func (s *Server) Listen() error {
+	bound := make([]boundListener, 0, len(s.http)+1)
+
+	for _, hl := range s.http {
+		lis, err := hl.listen()
+		if err != nil {
+			closeBound(bound)
+			return fmt.Errorf("listen %s %s: %w", hl.network, hl.address, err)
+		}
+
+		bound = append(bound, boundListener{Listener: lis, network: hl.network, address: hl.address})
+	}
+
+	if s.grpc != nil {
+		lis, err := listen("tcp", s.grpcAddr)
+		if err != nil {
+			closeBound(bound)
+			return fmt.Errorf("grpc listen: %w", err)
+		}
+
+		bound = append(bound, boundListener{Listener: lis, network: "tcp", address: s.grpcAddr})
+	}
+
+	closeUnclaimed()
+
+	s.mu.Lock()
+	s.bound = bound
+	s.mu.Unlock()
+
+	return nil
+}
+
+func closeBound(bound []boundListener) {
+	for _, l := range bound {
+		_ = l.Close()
+	}
+}
+
+// Start serves the listeners bound by Listen, binding them first if needed.
+// It blocks until one of the HTTP listeners stops and returns its error.
 func (s *Server) Start() error {
-	return s.http.ListenAndServe()
+	s.mu.Lock()
+	bound := s.bound
+	s.mu.Unlock()
+
+	if bound == nil {
+		if err := s.Listen(); err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		bound = s.bound
+		s.mu.Unlock()
+	}
+
+	if s.grpc != nil {
+		lis := bound[len(bound)-1]
+
+		go func() {
+			if serveErr := s.grpc.Serve(lis); serveErr != nil {
+				s.log.Error("grpc server stopped", zap.Error(serveErr))
+			}
+		}()
+	}
+
+	errCh := make(chan error, len(s.http))
+
+	for i, hl := range s.http {
+		go func() { errCh <- hl.serve(bound[i]) }()
+	}
+
+	return <-errCh
 }
 
-// Stop drains the HTTP server, closes the router (middleware Closers), then
-// flushes observability providers. Order matters: HTTP first so no in-flight
-// request writes to a provider that is already shutting down.
-func (s *Server) Stop(ctx context.Context) error {
-	var errs []error
+// Reload builds a router from cfg and swaps it in for new requests. The previous
+// router drains for reloadDrainTimeout and is then closed with its providers;
+// the new one takes over its quota counters and rate limit buckets.
+// Routing, service and observability settings and the admin token are reloaded,
+// so that a rotated token takes effect; listener addresses and TLS keep their
+// startup values.
+func (s *Server) Reload(ctx context.Context, cfg kono.GatewayConfig) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	s.mu.Lock()
+	current := s.router
+	s.mu.Unlock()
 
-	if err := s.http.Shutdown(ctx); err != nil {
-		errs = append(errs, fmt.Errorf("http shutdown: %w", err))
+	bundle, err := bootstrapRouter(ctx, cfg, s.version, current, s.log)
+	if err != nil {
+		return fmt.Errorf("bootstrap router: %w", err)
 	}
 
-	if err := s.router.Close(); err != nil {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldRouter, oldProviders := s.router, s.providers
+	s.router = bundle.Router
+	s.providers = []otelcommon.Provider{bundle.MeterProvider, bundle.TracerProvider}
+
+	if s.drain.draining.Load() {
+		bundle.Router.Drain()
+	}
+
+	s.handler.store(buildHandler(bundle, s.drain))
+	s.routes.store(bundle.Router)
+
+	if s.admin != nil {
+		s.admin.store(kono.NewAdminHandler(bundle.Router, cfg.Server.Admin.Token, s.log.Named("admin")))
+	}
+
+	retire := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), providerFlushTimeout)
+		defer cancel()
+
+		if closeErr := closeBundle(ctx, oldRouter, oldProviders); closeErr != nil {
+			s.log.Error("close replaced router", zap.Error(closeErr))
+		}
+	}
+
+	if s.retiring == nil {
+		s.retiring = make(map[*time.Timer]func())
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(reloadDrainTimeout, func() {
+		s.mu.Lock()
+		delete(s.retiring, timer)
+		s.mu.Unlock()
+
+		retire()
+	})
+	s.retiring[timer] = retire
+
+	s.log.Info("router reloaded")
+
+	return nil
+}
+
+func closeBundle(ctx context.Context, router *kono.Router, providers []otelcommon.Provider) error {
+	var errs []error
+
+	if err := router.Shutdown(ctx); err != nil {
 		errs = append(errs, fmt.Errorf("router close: %w", err))
 	}
 
-	for _, p := range s.providers {
+	for _, p := range providers {
 		if err := p.Shutdown(ctx); err != nil {
 			errs = append(errs, fmt.Errorf("provider shutdown: %w", err))
 		}
@@ -68,13 +392,162 @@ func (s *Server) Stop(ctx context.Context) error {
 	return errors.Join(errs...)
 }
 
-func bootstrapRouter(ctx context.Context, cfg kono.GatewayConfig, version string, log *zap.Logger) (kono.RouterBundle, error) {
+// Stop drains the gateway for shutdown. The health endpoint reports draining
+// right away; after shutdown.drain_delay the listeners stop accepting
+// connections and in-flight requests get until ctx expires to finish, after which
+// their connections are closed. The router (plugin and middleware Closers) and the
+// observability providers are closed last, so no in-flight request writes to a
+// provider that is already shutting down.
+func (s *Server) Stop(ctx context.Context) error {
+	start := time.Now()
+
+	// Reload reads draining under mu, so a router swapped in from here on is
+	// marked too.
+	s.mu.Lock()
+	s.drain.draining.Store(true)
+	s.router.Drain()
+	s.mu.Unlock()
+
+	s.log.Info("draining connections",
+		zap.Int64("in_flight", s.drain.active.Load()),
+		zap.Duration("drain_delay", s.shutdown.DrainDelay),
+	)
+
+	if s.shutdown.DrainDelay > 0 {
+		select {
+		case <-time.After(s.shutdown.DrainDelay):
+		case <-ctx.Done():
+		}
+	}
+
+	var (
+		errs   []error
+		errsMu sync.Mutex
+		wg     sync.WaitGroup
+	)
+
+	// Listeners stop accepting together and share the grace, rather than each
+	// waiting for the one before it to drain.
+	for _, hl := range s.http {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if err := hl.server.Shutdown(ctx); err != nil && ctx.Err() == nil {
+				errsMu.Lock()
+				errs = append(errs, fmt.Errorf("http shutdown %s: %w", hl.address, err))
+				errsMu.Unlock()
+			}
+		}()
+	}
+
+	if s.grpc != nil {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			stopped := make(chan struct{})
+			go func() {
+				s.grpc.GracefulStop()
+				close(stopped)
+			}()
+
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				s.grpc.Stop()
+				<-stopped
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		aborted := s.drain.active.Load()
+
+		s.mu.Lock()
+		router := s.router
+		s.mu.Unlock()
+
+		s.log.Warn("shutdown grace expired, closing connections", zap.Int64("in_flight", aborted))
+		router.AbortRequests(aborted)
+
+		for _, hl := range s.http {
+			_ = hl.server.Close()
+		}
+
+		errs = append(errs, fmt.Errorf("shutdown grace expired with %d requests in flight", aborted))
+	} else {
+		s.log.Info("connections drained", zap.Duration("took", time.Since(start)))
+	}
+
+	flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), providerFlushTimeout)
+	defer cancel()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Replaced routers have no requests left once the listeners are drained.
+	for timer, retire := range s.retiring {
+		if timer.Stop() {
+			retire()
+		}
+	}
+
+	if err := closeBundle(flushCtx, s.router, s.providers); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// ShutdownTimeout is the longest Stop should be given: the drain delay followed
+// by the grace for in-flight requests.
+func (s *Server) ShutdownTimeout() time.Duration {
+	return s.shutdown.DrainDelay + s.shutdown.Grace
+}
+
+// buildTLSConfig loads the listener certificates; crypto/tls serves the one whose
+// names match the client's SNI hostname. It returns nil when TLS is disabled.
+func buildTLSConfig(cfg kono.TLSConfig) (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil //nolint:nilnil // plaintext listener
+	}
+
+	certs := make([]tls.Certificate, 0, len(cfg.Certificates))
+
+	for _, c := range cfg.Certificates {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load certificate %q: %w", c.CertFile, err)
+		}
+
+		certs = append(certs, cert)
+	}
+
+	minVersion := uint16(tls.VersionTLS12)
+	if cfg.MinVersion == "1.3" {
+		minVersion = tls.VersionTLS13
+	}
+
+	return &tls.Config{
+		Certificates: certs,
+		MinVersion:   minVersion,
+	}, nil
+}
+
+func bootstrapRouter(ctx context.Context, cfg kono.GatewayConfig, version string, prev *kono.Router, log *zap.Logger) (kono.RouterBundle, error) {
 	bundle, err := kono.NewRouter(ctx, kono.RoutingConfigSet{
 		Routing:        cfg.Routing,
 		Service:        cfg.Service,
 		ServiceVersion: version,
 		Metrics:        cfg.Server.Metrics,
 		Tracing:        cfg.Server.Tracing,
+		AccessLog:      cfg.Server.AccessLog,
+		Previous:       prev,
 	}, log.Named("router"))
 	if err != nil {
 		return kono.RouterBundle{}, err
@@ -83,10 +556,17 @@ func bootstrapRouter(ctx context.Context, cfg kono.GatewayConfig, version string
 	return bundle, nil
 }
 
-func buildHandler(bundle kono.RouterBundle) http.Handler {
+func buildHandler(bundle kono.RouterBundle, drain *drainState) http.Handler {
 	mux := http.NewServeMux()
 
 	mux.Handle("GET /__health", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if drain.draining.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("DRAINING"))
+
+			return
+		}
+
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("OK"))
 	}))
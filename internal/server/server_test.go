@@ -0,0 +1,557 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/kono"
+)
+
+func unixClient(socket string) *http.Client {
+	return &http.Client{
+		Timeout: time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+}
+
+func TestServer_UnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "kono.sock")
+
+	// Leave a socket file behind, as a process that did not exit cleanly would.
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("bind stale socket: %v", err)
+	}
+
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	if _, err = os.Stat(socket); err != nil {
+		t.Fatalf("expected a stale socket file: %v", err)
+	}
+
+	srv, err := New(context.Background(), kono.GatewayConfig{
+		Service: kono.ServiceConfig{Name: "kono-test"},
+		Server:  kono.ServerConfig{Socket: socket, Timeout: time.Second},
+	}, "test", zap.NewNop())
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+
+	if err = srv.Listen(); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Start() }()
+
+	resp, err := unixClient(socket).Get("http://kono/__health")
+	if err != nil {
+		t.Fatalf("request over socket: %v", err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || string(body) != "OK" {
+		t.Fatalf("expected 200 OK, got %d %q", resp.StatusCode, body)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err = srv.Stop(ctx); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+
+	if err = <-done; !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("expected the server to close, got %v", err)
+	}
+
+	if _, err = os.Stat(socket); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the socket file to be removed, got %v", err)
+	}
+}
+
+func TestServer_UnixSocketProxiesFlows(t *testing.T) {
+	var forwardedFor string
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwardedFor = r.Header.Get("X-Forwarded-For")
+		_, _ = w.Write([]byte(`{"id":1}`))
+	}))
+	defer backend.Close()
+
+	socket := filepath.Join(t.TempDir(), "kono.sock")
+
+	srv, err := New(context.Background(), kono.GatewayConfig{
+		Service: kono.ServiceConfig{Name: "kono-test"},
+		Server:  kono.ServerConfig{Socket: socket, Timeout: time.Second},
+		Routing: kono.RoutingConfig{
+			Flows: []kono.FlowConfig{{
+				Path:              "/items",
+				Method:            http.MethodGet,
+				ParallelUpstreams: 1,
+				Aggregation:       &kono.AggregationConfig{Strategy: "array"},
+				Upstreams: []kono.UpstreamConfig{{
+					Name:    "items",
+					Hosts:   kono.AddrList{backend.URL},
+					Path:    "/items",
+					Method:  http.MethodGet,
+					Timeout: time.Second,
+				}},
+			}},
+		},
+	}, "test", zap.NewNop())
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+
+	if err = srv.Listen(); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	go func() { _ = srv.Start() }()
+
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_ = srv.Stop(ctx)
+	}()
+
+	req, _ := http.NewRequest(http.MethodGet, "http://kono/items", nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+
+	resp, err := unixClient(socket).Do(req)
+	if err != nil {
+		t.Fatalf("request over socket: %v", err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d %q", resp.StatusCode, body)
+	}
+
+	// The peer on the socket is a local proxy, so the client it reports is kept.
+	if forwardedFor != "203.0.113.7, 127.0.0.1" {
+		t.Fatalf("expected the forwarded client to be kept, got %q", forwardedFor)
+	}
+}
+
+// serveStream serves a flow over a unix socket with a 200ms server.timeout and
+// returns what a client reads from it. The backend streams a JSON array in four
+// chunks over 600ms.
+func serveStream(t *testing.T, flow kono.FlowConfig) (string, error) {
+	t.Helper()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		for i := range 4 {
+			time.Sleep(150 * time.Millisecond)
+
+			sep := ","
+			if i == 0 {
+				sep = "["
+			}
+
+			_, _ = fmt.Fprintf(w, `%s"chunk %d"`, sep, i)
+			w.(http.Flusher).Flush()
+		}
+
+		_, _ = w.Write([]byte("]"))
+	}))
+	defer backend.Close()
+
+	flow.Path = "/stream"
+	flow.Method = http.MethodGet
+	flow.ParallelUpstreams = 1
+	flow.Upstreams = []kono.UpstreamConfig{{
+		Name:    "stream",
+		Hosts:   kono.AddrList{backend.URL},
+		Path:    "/",
+		Method:  http.MethodGet,
+		Timeout: 5 * time.Second,
+	}}
+
+	socket := filepath.Join(t.TempDir(), "kono.sock")
+
+	srv, err := New(context.Background(), kono.GatewayConfig{
+		Service: kono.ServiceConfig{Name: "kono-test"},
+		Server:  kono.ServerConfig{Socket: socket, Timeout: 200 * time.Millisecond},
+		Routing: kono.RoutingConfig{Flows: []kono.FlowConfig{flow}},
+	}, "test", zap.NewNop())
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+
+	if err = srv.Listen(); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	go func() { _ = srv.Start() }()
+
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_ = srv.Stop(ctx)
+	}()
+
+	client := unixClient(socket)
+	client.Timeout = 5 * time.Second
+
+	resp, err := client.Get("http://kono/stream")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+
+	return string(body), err
+}
+
+func TestServer_FlowTimeout(t *testing.T) {
+	const full = `["chunk 0","chunk 1","chunk 2","chunk 3"]`
+
+	t.Run("outlives server timeout", func(t *testing.T) {
+		body, err := serveStream(t, kono.FlowConfig{
+			Timeout:     5 * time.Second,
+			Aggregation: &kono.AggregationConfig{Strategy: "array"},
+		})
+		if err != nil || !strings.Contains(body, "chunk 3") {
+			t.Fatalf("expected the response to complete, got %q: %v", body, err)
+		}
+	})
+
+	t.Run("buffered flows keep server timeout", func(t *testing.T) {
+		if body, err := serveStream(t, kono.FlowConfig{
+			Aggregation: &kono.AggregationConfig{Strategy: "array"},
+		}); err == nil && strings.Contains(body, "chunk 3") {
+			t.Fatalf("expected server.timeout to cut the response, got %q", body)
+		}
+	})
+
+	t.Run("streams past server timeout", func(t *testing.T) {
+		body, err := serveStream(t, kono.FlowConfig{Passthrough: true, Timeout: 5 * time.Second})
+		if err != nil || body != full {
+			t.Fatalf("expected %q, got %q: %v", full, body, err)
+		}
+	})
+
+	t.Run("cuts streams at the flow timeout", func(t *testing.T) {
+		if body, _ := serveStream(t, kono.FlowConfig{Passthrough: true, Timeout: 400 * time.Millisecond}); body == full {
+			t.Fatal("expected the flow timeout to cut the stream")
+		}
+	})
+}
+
+// itemsRouting routes GET /items to backend.
+func itemsRouting(backend string) kono.RoutingConfig {
+	return kono.RoutingConfig{
+		Flows: []kono.FlowConfig{{
+			Path:              "/items",
+			Method:            http.MethodGet,
+			ParallelUpstreams: 1,
+			Aggregation:       &kono.AggregationConfig{Strategy: "array"},
+			Upstreams: []kono.UpstreamConfig{{
+				Name:    "items",
+				Hosts:   kono.AddrList{backend},
+				Path:    "/items",
+				Method:  http.MethodGet,
+				Timeout: 5 * time.Second,
+			}},
+		}},
+	}
+}
+
+func TestServer_ReloadDuringDrain(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"id":1}`))
+	}))
+	defer backend.Close()
+
+	socket := filepath.Join(t.TempDir(), "kono.sock")
+	cfg := kono.GatewayConfig{
+		Service: kono.ServiceConfig{Name: "kono-test"},
+		Server: kono.ServerConfig{
+			Socket:   socket,
+			Timeout:  time.Second,
+			Shutdown: kono.ShutdownConfig{DrainDelay: 500 * time.Millisecond},
+		},
+		Routing: itemsRouting(backend.URL),
+	}
+
+	srv, err := New(context.Background(), cfg, "test", zap.NewNop())
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+
+	if err = srv.Listen(); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	go func() { _ = srv.Start() }()
+
+	stopped := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		stopped <- srv.Stop(ctx)
+	}()
+
+	client := unixClient(socket)
+
+	// Wait for the drain to start.
+	for {
+		resp, healthErr := client.Get("http://kono/__health")
+		if healthErr != nil {
+			t.Fatalf("health: %v", healthErr)
+		}
+
+		_ = resp.Body.Close()
+
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			break
+		}
+	}
+
+	if err = srv.Reload(context.Background(), cfg); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+
+	resp, err := client.Get("http://kono/items")
+	if err != nil {
+		t.Fatalf("request during drain: %v", err)
+	}
+
+	_ = resp.Body.Close()
+
+	// The reloaded router drains too, so it asks clients to reconnect elsewhere.
+	if resp.StatusCode != http.StatusOK || !resp.Close {
+		t.Fatalf("expected 200 with Connection: close, got %d, close=%v", resp.StatusCode, resp.Close)
+	}
+
+	if err = <-stopped; err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+}
+
+func TestServer_StopShutsListenersDownTogether(t *testing.T) {
+	release := make(chan struct{})
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		<-release
+		_, _ = w.Write([]byte(`{"id":1}`))
+	}))
+	defer backend.Close()
+
+	dir := t.TempDir()
+	main, extra := filepath.Join(dir, "main.sock"), filepath.Join(dir, "extra.sock")
+
+	srv, err := New(context.Background(), kono.GatewayConfig{
+		Service: kono.ServiceConfig{Name: "kono-test"},
+		Server: kono.ServerConfig{
+			Socket:    main,
+			Timeout:   5 * time.Second,
+			Listeners: []kono.ListenerConfig{{Name: "extra", Socket: extra}},
+		},
+		Routing: itemsRouting(backend.URL),
+	}, "test", zap.NewNop())
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+
+	if err = srv.Listen(); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	go func() { _ = srv.Start() }()
+
+	// Hold a request open on the main listener.
+	inFlight := make(chan error, 1)
+	client := unixClient(main)
+	client.Timeout = 5 * time.Second
+
+	go func() {
+		resp, getErr := client.Get("http://kono/items")
+		if getErr == nil {
+			_ = resp.Body.Close()
+		}
+
+		inFlight <- getErr
+	}()
+
+	for srv.drain.active.Load() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stopped := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		stopped <- srv.Stop(ctx)
+	}()
+
+	// The extra listener stops accepting while the main one still drains.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, dialErr := net.Dial("unix", extra)
+		if dialErr != nil {
+			break
+		}
+
+		_ = conn.Close()
+
+		if time.Now().After(deadline) {
+			t.Fatal("expected the extra listener to stop accepting during the drain")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(release)
+
+	if err = <-inFlight; err != nil {
+		t.Fatalf("in-flight request: %v", err)
+	}
+
+	if err = <-stopped; err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+}
+
+func TestServer_HandoffLeavesQuotaToSuccessor(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"id":1}`))
+	}))
+	defer backend.Close()
+
+	dir := t.TempDir()
+	state := filepath.Join(dir, "quota.json")
+
+	start := func(name string) (*Server, *http.Client) {
+		socket := filepath.Join(dir, name+".sock")
+
+		routing := itemsRouting(backend.URL)
+		routing.Quota = kono.QuotaConfig{
+			Enabled:       true,
+			Limit:         100,
+			Period:        "day",
+			StatePath:     state,
+			FlushInterval: time.Hour,
+			MaxEntries:    10,
+		}
+
+		srv, err := New(context.Background(), kono.GatewayConfig{
+			Service: kono.ServiceConfig{Name: "kono-test"},
+			Server:  kono.ServerConfig{Socket: socket, Timeout: time.Second},
+			Routing: routing,
+		}, "test", zap.NewNop())
+		if err != nil {
+			t.Fatalf("new server: %v", err)
+		}
+
+		if err = srv.Listen(); err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+
+		go func() { _ = srv.Start() }()
+
+		return srv, unixClient(socket)
+	}
+
+	get := func(client *http.Client, n int) string {
+		var remaining string
+
+		for range n {
+			resp, err := client.Get("http://kono/items")
+			if err != nil {
+				t.Fatalf("request: %v", err)
+			}
+
+			_ = resp.Body.Close()
+			remaining = resp.Header.Get("X-Quota-Remaining")
+		}
+
+		return remaining
+	}
+
+	stop := func(srv *Server) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := srv.Stop(ctx); err != nil {
+			t.Fatalf("stop: %v", err)
+		}
+	}
+
+	old, oldClient := start("old")
+	get(oldClient, 2)
+
+	// What Upgrade does around starting the successor.
+	if err := old.router.FlushQuota(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	successor, successorClient := start("new")
+	old.router.ReleaseQuota()
+
+	if remaining := get(successorClient, 1); remaining != "97" {
+		t.Fatalf("expected the successor to continue the counts, got %q remaining", remaining)
+	}
+
+	// The old process keeps counting while it drains, but its counts are not
+	// written over the successor's.
+	get(oldClient, 3)
+
+	stop(successor)
+	stop(old)
+
+	restarted, restartedClient := start("restarted")
+	defer stop(restarted)
+
+	if remaining := get(restartedClient, 1); remaining != "96" {
+		t.Fatalf("expected the successor's counts to be kept, got %q remaining", remaining)
+	}
+}
+
+func TestListen_KeepsNonSocketFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kono.sock")
+
+	if err := os.WriteFile(path, []byte("data"), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	if lis, err := listen("unix", path); err == nil {
+		_ = lis.Close()
+		t.Fatal("expected binding over a regular file to fail")
+	}
+
+	if data, err := os.ReadFile(path); err != nil || string(data) != "data" {
+		t.Fatalf("expected the file to be kept, got %q, %v", data, err)
+	}
+}
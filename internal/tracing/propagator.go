@@ -1,13 +1,93 @@
 package tracing
 
 import (
+	"context"
+	"crypto/rand"
+	"fmt"
+
+	"go.opentelemetry.io/contrib/propagators/b3"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Trace context formats written on outgoing requests.
+const (
+	FormatTraceContext = "tracecontext" // W3C traceparent and tracestate, plus baggage.
+	FormatB3           = "b3"           // Single b3 header.
+	FormatB3Multi      = "b3multi"      // X-B3-TraceId, X-B3-SpanId and X-B3-Sampled.
 )
 
+// InstallPropagator sets the global propagator. It reads W3C trace context and
+// both B3 encodings from incoming requests, traceparent winning when several are
+// present, and writes W3C trace context and baggage.
 func InstallPropagator() {
-	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
-		propagation.TraceContext{},
-		propagation.Baggage{},
-	))
+	otel.SetTextMapPropagator(splitPropagator{
+		inject: propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}),
+		// Later propagators override the context found by earlier ones.
+		extract: propagation.NewCompositeTextMapPropagator(b3.New(), propagation.TraceContext{}, propagation.Baggage{}),
+	})
+}
+
+// NewPropagator returns a propagator writing the given formats. Without formats
+// it writes nothing.
+func NewPropagator(formats []string) (propagation.TextMapPropagator, error) {
+	props := make([]propagation.TextMapPropagator, 0, len(formats))
+
+	for _, format := range formats {
+		switch format {
+		case FormatTraceContext:
+			props = append(props, propagation.TraceContext{}, propagation.Baggage{})
+		case FormatB3:
+			props = append(props, b3.New(b3.WithInjectEncoding(b3.B3SingleHeader)))
+		case FormatB3Multi:
+			props = append(props, b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)))
+		default:
+			return nil, fmt.Errorf("unsupported trace context format %q", format)
+		}
+	}
+
+	return propagation.NewCompositeTextMapPropagator(props...), nil
+}
+
+// EnsureSpanContext returns ctx with a new root span context when it carries no
+// valid one, as happens for requests without trace headers while tracing is
+// disabled. Upstream calls of the request then share a trace ID. The context is
+// not sampled, so upstreams do not record every such request; they may still
+// decide to sample it themselves.
+func EnsureSpanContext(ctx context.Context) context.Context {
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+
+	var (
+		traceID trace.TraceID
+		spanID  trace.SpanID
+	)
+
+	_, _ = rand.Read(traceID[:])
+	_, _ = rand.Read(spanID[:])
+
+	return trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID,
+		SpanID:  spanID,
+	}))
+}
+
+// splitPropagator extracts and injects with different propagators.
+type splitPropagator struct {
+	inject  propagation.TextMapPropagator
+	extract propagation.TextMapPropagator
+}
+
+func (p splitPropagator) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	p.inject.Inject(ctx, carrier)
+}
+
+func (p splitPropagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return p.extract.Extract(ctx, carrier)
+}
+
+func (p splitPropagator) Fields() []string {
+	return p.inject.Fields()
 }
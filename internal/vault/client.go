@@ -0,0 +1,128 @@
+// Package vault is a minimal HashiCorp Vault HTTP client for reading secrets.
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout  = 5 * time.Second
+	maxResponseSize = 1 << 20
+)
+
+type Options struct {
+	Address   string
+	Token     string
+	Namespace string // Vault Enterprise namespace; empty for the root namespace.
+	Timeout   time.Duration
+}
+
+// OptionsFromEnv reads the standard Vault client variables: VAULT_ADDR,
+// VAULT_TOKEN (or the file named by VAULT_TOKEN_FILE) and VAULT_NAMESPACE.
+func OptionsFromEnv() (Options, error) {
+	opts := Options{
+		Address:   os.Getenv("VAULT_ADDR"),
+		Token:     os.Getenv("VAULT_TOKEN"),
+		Namespace: os.Getenv("VAULT_NAMESPACE"),
+	}
+
+	if opts.Address == "" {
+		return Options{}, errors.New("VAULT_ADDR is not set")
+	}
+
+	if path := os.Getenv("VAULT_TOKEN_FILE"); opts.Token == "" && path != "" {
+		token, err := os.ReadFile(path)
+		if err != nil {
+			return Options{}, fmt.Errorf("read VAULT_TOKEN_FILE: %w", err)
+		}
+
+		opts.Token = strings.TrimSpace(string(token))
+	}
+
+	if opts.Token == "" {
+		return Options{}, errors.New("VAULT_TOKEN is not set")
+	}
+
+	return opts, nil
+}
+
+type Client struct {
+	opts Options
+	http *http.Client
+}
+
+func New(opts Options) *Client {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+
+	opts.Address = strings.TrimSuffix(opts.Address, "/")
+
+	return &Client{opts: opts, http: &http.Client{Timeout: opts.Timeout}}
+}
+
+// Read returns the data stored at path, e.g. "secret/data/kono". KV version 2
+// responses are unwrapped, so both engine versions yield the secret's fields.
+func (c *Client) Read(ctx context.Context, path string) (map[string]any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.opts.Address+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+
+	req.Header.Set("X-Vault-Token", c.opts.Token)
+	if c.opts.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.opts.Namespace)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("read %s: %w", path, responseError(resp.StatusCode, body))
+	}
+
+	var secret struct {
+		Data map[string]any `json:"data"`
+	}
+
+	if err = json.Unmarshal(body, &secret); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+
+	// KV v2 nests the fields next to the version metadata.
+	if inner, ok := secret.Data["data"].(map[string]any); ok {
+		if _, versioned := secret.Data["metadata"]; versioned {
+			return inner, nil
+		}
+	}
+
+	return secret.Data, nil
+}
+
+func responseError(status int, body []byte) error {
+	var payload struct {
+		Errors []string `json:"errors"`
+	}
+
+	if json.Unmarshal(body, &payload) == nil && len(payload.Errors) > 0 {
+		return fmt.Errorf("vault returned %d: %s", status, strings.Join(payload.Errors, "; "))
+	}
+
+	return fmt.Errorf("vault returned %d", status)
+}
@@ -0,0 +1,138 @@
+package kono
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// interpolator replaces references in every scalar value of the document:
+// ${NAME} reads an environment variable, ${NAME:-fallback} falls back when it is
+// unset or empty, ${<scheme>:<ref>} asks a SecretProvider (${file:/path},
+// ${vault:secret/data/app#key}), and $${ escapes a literal "${". Interpolation
+// happens after parsing, so a secret can never inject YAML structure. Mapping
+// keys are left untouched.
+type interpolator struct {
+	ctx     context.Context
+	secrets secretDigests
+	// resolved collects the scalar values that contained a reference.
+	resolved map[string]struct{}
+	refs     int
+}
+
+func (in *interpolator) node(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			if err := in.node(child); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := in.node(node.Content[i]); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "${") {
+			return nil
+		}
+
+		refs := in.refs
+
+		value, err := in.interpolate(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+
+		if in.resolved != nil && in.refs > refs && value != "" {
+			in.resolved[value] = struct{}{}
+		}
+
+		node.Value = value
+
+		// Plain scalars are resolved again, so "${PORT}" can fill an int field.
+		if node.Style == 0 {
+			node.Tag = ""
+		}
+	case yaml.AliasNode:
+	}
+
+	return nil
+}
+
+func (in *interpolator) interpolate(s string) (string, error) {
+	var b strings.Builder
+
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+
+		if start > 0 && s[start-1] == '$' {
+			b.WriteString(s[:start-1])
+			b.WriteString("${")
+			s = s[start+2:]
+
+			continue
+		}
+
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated reference in %q", s)
+		}
+
+		value, err := in.resolve(s[start+2 : start+end])
+		if err != nil {
+			return "", err
+		}
+
+		in.refs++
+
+		b.WriteString(s[:start])
+		b.WriteString(value)
+		s = s[start+end+1:]
+	}
+}
+
+func (in *interpolator) resolve(ref string) (string, error) {
+	if scheme, rest, ok := strings.Cut(ref, ":"); ok && !strings.HasPrefix(rest, "-") {
+		p, registered := lookupSecretProvider(scheme)
+		if !registered {
+			return "", fmt.Errorf("secret provider %q is not registered", scheme)
+		}
+
+		value, err := p.Resolve(in.ctx, rest)
+		if err != nil {
+			return "", fmt.Errorf("resolve ${%s}: %w", ref, err)
+		}
+
+		if in.secrets != nil {
+			in.secrets[ref] = sha256.Sum256([]byte(value))
+		}
+
+		return value, nil
+	}
+
+	name, fallback, hasFallback := strings.Cut(ref, ":-")
+	if name == "" {
+		return "", fmt.Errorf("empty reference ${%s}", ref)
+	}
+
+	if value := os.Getenv(name); value != "" {
+		return value, nil
+	}
+
+	if hasFallback {
+		return fallback, nil
+	}
+
+	return "", fmt.Errorf("environment variable %s is not set", name)
+}
@@ -0,0 +1,403 @@
+package kono
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dop251/goja"
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/kono/sdk"
+)
+
+// jsVM is a goja runtime that has run the script. ctx is that of the call in
+// progress, for kono.call.
+type jsVM struct {
+	rt  *goja.Runtime
+	ctx context.Context
+}
+
+// jsPlugin runs one entry point of a JavaScript script in-process.
+type jsPlugin struct {
+	name        string
+	script      string
+	fn          string
+	phase       sdk.PluginType
+	pool        *scriptPool[*jsVM]
+	maxBodySize int64
+	timeout     time.Duration
+	log         *zap.Logger
+}
+
+func newJSPlugins(cfg PluginConfig, deps pluginDeps, log *zap.Logger) ([]sdk.Plugin, error) {
+	sc := cfg.JS
+	name := scriptName(cfg.Name, sc)
+
+	src, err := loadScript(sc)
+	if err != nil {
+		return nil, err
+	}
+
+	prog, err := goja.Compile(name, string(src), false)
+	if err != nil {
+		return nil, fmt.Errorf("parse script: %w", err)
+	}
+
+	caller, err := newScriptCaller(sc.Upstreams, deps, log.With(zap.String("plugin", cfg.Name)))
+	if err != nil {
+		return nil, err
+	}
+
+	newVM := func() (*jsVM, error) {
+		vm := &jsVM{rt: goja.New(), ctx: context.Background()}
+		registerJSCall(vm, caller)
+
+		if _, err := vm.rt.RunProgram(prog); err != nil {
+			return nil, fmt.Errorf("run script %s: %w", name, err)
+		}
+
+		return vm, nil
+	}
+
+	// Runtimes hold no resources besides memory.
+	pool := newScriptPool(sc.PoolSize, caller, newVM, func(*jsVM) {})
+
+	// Run the script once up front, so errors in its top level fail the start and
+	// the defined entry points pick the phases.
+	vm, err := pool.get()
+	if err != nil {
+		caller.close()
+		return nil, err
+	}
+	defer pool.put(vm)
+
+	plugins := make([]sdk.Plugin, 0, len(scriptEntryPoints))
+
+	for _, entry := range scriptEntryPoints {
+		if _, ok := goja.AssertFunction(vm.rt.Get(entry.fn)); !ok {
+			continue
+		}
+
+		plugins = append(plugins, &jsPlugin{
+			name:        cfg.Name,
+			script:      name,
+			fn:          entry.fn,
+			phase:       entry.phase,
+			pool:        pool,
+			maxBodySize: sc.MaxBodySize,
+			timeout:     sc.Timeout,
+			log:         log.With(zap.String("plugin", cfg.Name)),
+		})
+	}
+
+	if len(plugins) == 0 {
+		caller.close()
+		return nil, fmt.Errorf("script %s defines neither %s nor %s", name, scriptOnRequest, scriptOnResponse)
+	}
+
+	return plugins, nil
+}
+
+func (p *jsPlugin) Info() sdk.PluginInfo {
+	return sdk.PluginInfo{
+		Name:        p.name,
+		Description: p.fn + " of JavaScript script " + p.script,
+	}
+}
+
+// Init does nothing: JavaScript plugins are configured through PluginConfig.JS.
+func (p *jsPlugin) Init(map[string]interface{}) error { return nil }
+
+func (p *jsPlugin) Type() sdk.PluginType { return p.phase }
+
+func (p *jsPlugin) Close() error {
+	p.pool.close()
+	return nil
+}
+
+// Execute calls the entry point with an object describing the request (method,
+// path, query, headers, body) or the response (status, headers, body), as the
+// Lua plugin does. Changes the script makes to the object are applied; a
+// returned object with a status answers the client right away instead.
+func (p *jsPlugin) Execute(kctx sdk.Context) error {
+	vm, err := p.pool.get()
+	if err != nil {
+		return err
+	}
+
+	req := kctx.Request()
+
+	ctx, cancel := req.Context(), context.CancelFunc(func() {})
+	if p.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+	}
+	defer cancel()
+
+	vm.ctx = withScriptRequest(ctx, req)
+
+	// The runtime checks for an interrupt between instructions, so a runaway
+	// script stops.
+	stop := context.AfterFunc(ctx, func() { vm.rt.Interrupt(errPluginTimeout) })
+
+	var (
+		arg     map[string]any
+		applyFn func(map[string]any) error
+	)
+
+	if p.phase == sdk.PluginTypeResponse {
+		arg, applyFn, err = jsResponse(kctx.Response())
+	} else {
+		arg, applyFn, err = p.jsRequest(req)
+	}
+
+	var ret goja.Value
+
+	if err == nil {
+		fn, _ := goja.AssertFunction(vm.rt.Get(p.fn))
+		ret, err = fn(goja.Undefined(), vm.rt.ToValue(arg))
+	}
+
+	// A runtime left mid-execution by a failed call, or with an interrupt that may
+	// still be pending, is not reused.
+	if stop() && err == nil {
+		vm.ctx = context.Background()
+		p.pool.put(vm)
+	}
+
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && req.Context().Err() == nil {
+			return fmt.Errorf("js %s: %w after %s", p.fn, errPluginTimeout, p.timeout)
+		}
+
+		return fmt.Errorf("js %s: %w", p.fn, err)
+	}
+
+	if abort, ok := jsAbort(ret); ok {
+		if !validStatus(abort.Status) {
+			return fmt.Errorf("js %s: returned invalid status %d", p.fn, abort.Status)
+		}
+
+		return abort
+	}
+
+	return applyFn(arg)
+}
+
+func (p *jsPlugin) jsRequest(req *http.Request) (map[string]any, func(map[string]any) error, error) {
+	body, ok, err := readScriptBody(req, p.maxBodySize, p.log)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	obj := map[string]any{
+		"method":  req.Method,
+		"path":    req.URL.Path,
+		"query":   req.URL.RawQuery,
+		"headers": jsHeaders(req.Header),
+	}
+
+	if ok {
+		obj["body"] = string(body)
+	}
+
+	return obj, func(obj map[string]any) error {
+		applyJSHeaders(obj["headers"], req.Header)
+
+		if changed, isString := obj["body"].(string); isString && (!ok || changed != string(body)) {
+			req.Body = io.NopCloser(bytes.NewReader([]byte(changed)))
+			req.ContentLength = int64(len(changed))
+		}
+
+		if path, ok := obj["path"].(string); ok {
+			req.URL.Path, req.URL.RawPath = path, ""
+		}
+
+		if query, ok := obj["query"].(string); ok {
+			req.URL.RawQuery = query
+		}
+
+		return nil
+	}, nil
+}
+
+func jsResponse(resp *http.Response) (map[string]any, func(map[string]any) error, error) {
+	if resp == nil {
+		return nil, nil, errors.New("no response to process")
+	}
+
+	body, err := readScriptResponseBody(resp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	obj := map[string]any{
+		"status":  resp.StatusCode,
+		"headers": jsHeaders(resp.Header),
+		"body":    string(body),
+	}
+
+	return obj, func(obj map[string]any) error {
+		applyJSHeaders(obj["headers"], resp.Header)
+
+		if status, ok := jsInt(obj["status"]); ok {
+			if !validStatus(status) {
+				return fmt.Errorf("script set invalid status %d", status)
+			}
+
+			resp.StatusCode = status
+		}
+
+		if changed, ok := obj["body"].(string); ok && changed != string(body) {
+			resp.Body = io.NopCloser(bytes.NewReader([]byte(changed)))
+			resp.ContentLength = int64(len(changed))
+		}
+
+		return nil
+	}, nil
+}
+
+// jsHeaders exposes the first value of each header, keyed by canonical name.
+func jsHeaders(h http.Header) map[string]any {
+	m := make(map[string]any, len(h))
+
+	for name := range h {
+		m[name] = h.Get(name)
+	}
+
+	return m
+}
+
+// applyJSHeaders removes the headers the script deleted or set to undefined or
+// null, and sets those it added or changed. Untouched headers keep all their
+// values.
+func applyJSHeaders(v any, h http.Header) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return
+	}
+
+	for name := range h {
+		if m[name] == nil {
+			h.Del(name)
+		}
+	}
+
+	for name, v := range m {
+		if v == nil {
+			continue
+		}
+
+		if value := fmt.Sprint(v); h.Get(name) != value {
+			h.Set(name, value)
+		}
+	}
+}
+
+// jsAbort turns a returned {status, headers, body} object into an
+// sdk.AbortError.
+func jsAbort(v goja.Value) (*sdk.AbortError, bool) {
+	if v == nil || goja.IsUndefined(v) || goja.IsNull(v) {
+		return nil, false
+	}
+
+	m, ok := v.Export().(map[string]any)
+	if !ok {
+		return nil, false
+	}
+
+	status, ok := jsInt(m["status"])
+	if !ok {
+		return nil, false
+	}
+
+	abort := &sdk.AbortError{Status: status, Header: make(http.Header)}
+
+	if headers, isMap := m["headers"].(map[string]any); isMap {
+		for k, v := range headers {
+			abort.Header.Set(k, fmt.Sprint(v))
+		}
+	}
+
+	if body, isString := m["body"].(string); isString {
+		abort.Body = []byte(body)
+	}
+
+	return abort, true
+}
+
+// jsInt reads a number the runtime exported as an integer or a float.
+func jsInt(v any) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int64:
+		return int(n), true
+	case float64:
+		return int(n), true
+	default:
+		return 0, false
+	}
+}
+
+// registerJSCall exposes kono.call(name, opts) to the script of vm. It returns
+// an object with status, headers and body, and throws the error kind when the
+// call fails.
+func registerJSCall(vm *jsVM, c *scriptCaller) {
+	mod := vm.rt.NewObject()
+	_ = mod.Set("call", func(call goja.FunctionCall) goja.Value {
+		name := call.Argument(0).String()
+		opts, _ := call.Argument(1).Export().(map[string]any)
+
+		var sc scriptCall
+
+		if method, isString := opts["method"].(string); isString {
+			sc.method = method
+		}
+
+		switch query := opts["query"].(type) {
+		case string:
+			sc.query = &query
+		case map[string]any:
+			values := url.Values{}
+			for k, v := range query {
+				values.Set(k, fmt.Sprint(v))
+			}
+
+			raw := values.Encode()
+			sc.query = &raw
+		}
+
+		if headers, isMap := opts["headers"].(map[string]any); isMap {
+			sc.headers = make(map[string]string, len(headers))
+			for k, v := range headers {
+				sc.headers[k] = fmt.Sprint(v)
+			}
+		}
+
+		if body, isString := opts["body"].(string); isString {
+			sc.body = []byte(body)
+		}
+
+		resp, err := c.call(vm.ctx, name, sc)
+		if err != nil {
+			panic(vm.rt.NewTypeError(err.Error()))
+		}
+
+		if resp.err != nil {
+			panic(vm.rt.NewGoError(errors.New(resp.err.Error())))
+		}
+
+		return vm.rt.ToValue(map[string]any{
+			"status":  resp.status,
+			"headers": jsHeaders(resp.headers),
+			"body":    string(resp.body),
+		})
+	})
+	_ = vm.rt.Set("kono", mod)
+}
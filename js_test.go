@@ -0,0 +1,269 @@
+package kono
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/kono/sdk"
+)
+
+var _ = Describe("jsPlugin", func() {
+	newPlugins := func(script string, upstreams ...UpstreamConfig) ([]sdk.Plugin, error) {
+		sc := &ScriptConfig{
+			Source:      scriptSourceInline,
+			Script:      script,
+			PoolSize:    2,
+			MaxBodySize: 16,
+			Timeout:     50 * time.Millisecond,
+			Upstreams:   upstreams,
+		}
+
+		plugins, err := newJSPlugins(PluginConfig{Name: "script", Source: sourceJS, JS: sc}, pluginDeps{metrics: testMetrics}, zap.NewNop())
+		if err == nil {
+			DeferCleanup(func() { _ = plugins[0].(sdk.Closer).Close() })
+		}
+
+		return plugins, err
+	}
+
+	It("creates a plugin per defined entry point", func() {
+		plugins, err := newPlugins("function on_response(resp) {}\nfunction on_request(req) {}")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plugins).To(HaveLen(2))
+		Expect(plugins[0].Type()).To(Equal(sdk.PluginTypeRequest))
+		Expect(plugins[1].Type()).To(Equal(sdk.PluginTypeResponse))
+
+		_, err = newPlugins("var x = 1;")
+		Expect(err).To(MatchError(ContainSubstring("defines neither")))
+
+		_, err = newPlugins("function on_request(req {")
+		Expect(err).To(MatchError(ContainSubstring("parse script")))
+
+		_, err = newPlugins("throw new Error('boom')")
+		Expect(err).To(MatchError(ContainSubstring("run script script (inline)")))
+	})
+
+	It("applies changes to the request", func() {
+		plugins, err := newPlugins(`
+function on_request(req) {
+  req.headers["X-Tenant"] = "acme";
+  delete req.headers["X-Internal"];
+  req.path = "/v2" + req.path;
+  req.query = req.query + "&b=2";
+  if (req.body === undefined) {
+    req.headers["X-Body"] = "skipped";
+  } else if (req.body !== "") {
+    req.body = req.body.toUpperCase();
+  }
+}`)
+		Expect(err).NotTo(HaveOccurred())
+
+		req := httptest.NewRequest(http.MethodPost, "/items?a=1", bytes.NewReader([]byte("hello")))
+		req.Header.Set("X-Internal", "secret")
+		req.Header.Add("Accept", "a")
+		req.Header.Add("Accept", "b")
+		kctx := newContext(req)
+
+		// Runtimes are reused between calls.
+		for range 3 {
+			Expect(plugins[0].Execute(kctx)).To(Succeed())
+		}
+
+		Expect(req.Header.Get("X-Tenant")).To(Equal("acme"))
+		Expect(req.Header.Get("X-Internal")).To(BeEmpty())
+		Expect(req.Header.Values("Accept")).To(Equal([]string{"a", "b"}))
+		Expect(req.URL.Path).To(Equal("/v2/v2/v2/items"))
+		Expect(req.URL.RawQuery).To(Equal("a=1&b=2&b=2&b=2"))
+
+		body, _ := io.ReadAll(req.Body)
+		Expect(string(body)).To(Equal("HELLO"))
+		Expect(req.ContentLength).To(Equal(int64(5)))
+
+		large := bytes.Repeat([]byte("x"), 32)
+		req = httptest.NewRequest(http.MethodPost, "/items", bytes.NewReader(large))
+		Expect(plugins[0].Execute(newContext(req))).To(Succeed())
+
+		body, _ = io.ReadAll(req.Body)
+		Expect(body).To(Equal(large))
+		Expect(req.Header.Get("X-Body")).To(Equal("skipped"))
+	})
+
+	It("applies changes to the response", func() {
+		plugins, err := newPlugins(`
+function on_response(resp) {
+  resp.status = 202;
+  resp.body = resp.body.toUpperCase();
+  resp.headers["X-Scripted"] = "yes";
+}`)
+		Expect(err).NotTo(HaveOccurred())
+
+		kctx := newContext(httptest.NewRequest(http.MethodGet, "/items", nil))
+		kctx.SetResponse(&http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(bytes.NewReader([]byte("ok")))})
+
+		Expect(plugins[0].Execute(kctx)).To(Succeed())
+
+		body, _ := io.ReadAll(kctx.Response().Body)
+		Expect(string(body)).To(Equal("OK"))
+		Expect(kctx.Response().StatusCode).To(Equal(http.StatusAccepted))
+		Expect(kctx.Response().Header.Get("X-Scripted")).To(Equal("yes"))
+	})
+
+	It("aborts with a returned response and reports script errors", func() {
+		plugins, err := newPlugins(`
+function on_request(req) {
+  if (req.headers["X-Block"]) {
+    return {status: 403, headers: {"X-Reason": "blocked"}, body: "denied"};
+  }
+  if (req.headers["X-Fail"]) {
+    throw new Error("boom");
+  }
+  if (req.headers["X-Bogus"]) {
+    return {status: 1000};
+  }
+}`)
+		Expect(err).NotTo(HaveOccurred())
+
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set("X-Block", "1")
+
+		var abort *sdk.AbortError
+		Expect(errors.As(plugins[0].Execute(newContext(req)), &abort)).To(BeTrue())
+		Expect(abort.Status).To(Equal(http.StatusForbidden))
+		Expect(abort.Header.Get("X-Reason")).To(Equal("blocked"))
+		Expect(string(abort.Body)).To(Equal("denied"))
+
+		req = httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set("X-Fail", "1")
+		Expect(plugins[0].Execute(newContext(req))).To(MatchError(ContainSubstring("boom")))
+
+		req = httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set("X-Bogus", "1")
+		Expect(plugins[0].Execute(newContext(req))).To(MatchError(ContainSubstring("invalid status 1000")))
+
+		Expect(plugins[0].Execute(newContext(httptest.NewRequest(http.MethodGet, "/items", nil)))).To(Succeed())
+	})
+
+	It("forwards the body a script rewrites to the upstreams", func() {
+		var received []byte
+
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received, _ = io.ReadAll(r.Body)
+			_, _ = w.Write([]byte(`{}`))
+		}))
+		DeferCleanup(backend.Close)
+
+		bundle, err := NewRouter(context.Background(), RoutingConfigSet{
+			Service: ServiceConfig{Name: "kono-test"},
+			Routing: RoutingConfig{
+				Flows: []FlowConfig{{
+					Path:              "/items",
+					Method:            http.MethodPost,
+					ParallelUpstreams: 1,
+					Aggregation:       &AggregationConfig{Strategy: "array"},
+					Plugins: []PluginConfig{{
+						Name:   "rewrite",
+						Source: sourceJS,
+						JS: &ScriptConfig{
+							Source:      scriptSourceInline,
+							Script:      `function on_request(req) { req.body = req.body.toUpperCase(); }`,
+							MaxBodySize: 1024,
+						},
+					}},
+					Upstreams: []UpstreamConfig{{
+						Name:    "items",
+						Hosts:   AddrList{backend.URL},
+						Path:    "/items",
+						Method:  http.MethodPost,
+						Timeout: time.Second,
+					}},
+				}},
+			},
+		}, zap.NewNop())
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(func() { _ = bundle.Router.Shutdown(context.Background()) })
+
+		rec := httptest.NewRecorder()
+		bundle.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/items", strings.NewReader("item")))
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(string(received)).To(Equal("ITEM"))
+	})
+
+	It("interrupts a script that overruns its timeout", func() {
+		plugins, err := newPlugins(`
+function on_request(req) {
+  if (req.headers["X-Loop"]) {
+    for (;;) {}
+  }
+}`)
+		Expect(err).NotTo(HaveOccurred())
+
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set("X-Loop", "1")
+
+		start := time.Now()
+		Expect(plugins[0].Execute(newContext(req))).To(MatchError(errPluginTimeout))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+
+		Expect(plugins[0].Execute(newContext(httptest.NewRequest(http.MethodGet, "/items", nil)))).To(Succeed())
+	})
+
+	It("calls auxiliary upstreams", func() {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-User") != "ann" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			_, _ = w.Write([]byte(`{"tier":"` + r.URL.Query().Get("scope") + `"}`))
+		}))
+		DeferCleanup(backend.Close)
+
+		plugins, err := newPlugins(`
+function on_request(req) {
+  var resp;
+  try {
+    resp = kono.call("authz", {headers: {"X-User": req.headers["X-User"] || ""}, query: {scope: "gold"}});
+  } catch (e) {
+    return {status: 502, body: e.message};
+  }
+  if (resp.status !== 200) {
+    return {status: 403};
+  }
+  req.headers["X-Tier"] = JSON.parse(resp.body).tier;
+}`, UpstreamConfig{
+			Name:           "authz",
+			Hosts:          AddrList{backend.URL},
+			Path:           "/decide",
+			Method:         http.MethodGet,
+			Timeout:        time.Second,
+			ForwardHeaders: []string{"X-User"},
+			ForwardQueries: []string{"scope"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set("X-User", "ann")
+		Expect(plugins[0].Execute(newContext(req))).To(Succeed())
+		Expect(req.Header.Get("X-Tier")).To(Equal("gold"))
+
+		var abort *sdk.AbortError
+		Expect(errors.As(plugins[0].Execute(newContext(httptest.NewRequest(http.MethodGet, "/items", nil))), &abort)).To(BeTrue())
+		Expect(abort.Status).To(Equal(http.StatusForbidden))
+
+		backend.Close()
+		Expect(errors.As(plugins[0].Execute(newContext(httptest.NewRequest(http.MethodGet, "/items", nil))), &abort)).To(BeTrue())
+		Expect(abort.Status).To(Equal(http.StatusBadGateway))
+		Expect(string(abort.Body)).To(Equal("connection"))
+	})
+})
@@ -0,0 +1,155 @@
+package kono
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/starwalkn/kono/internal/consul"
+	"github.com/starwalkn/kono/internal/etcd"
+)
+
+// consulWatchWait is how long a Consul blocking query may wait for a change.
+const consulWatchWait = 5 * time.Minute
+
+// newKVSource parses consul://host:port/<key> and etcd://[user:pass@]host:port/<key>.
+// The +https variants connect over TLS. The key is the path without its leading
+// slash, so etcd://host:2379//kono/config reads "/kono/config".
+func newKVSource(location string) (ConfigSource, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("parse config location: %w", err)
+	}
+
+	store, transport, _ := strings.Cut(u.Scheme, "+")
+	if transport == "" {
+		transport = "http"
+	}
+
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, fmt.Errorf("config location %q must be %s://<host>/<key>", location, u.Scheme)
+	}
+
+	address := transport + "://" + u.Host
+
+	if store == "consul" {
+		return &consulSource{key: key, client: consul.New(address, os.Getenv("CONSUL_HTTP_TOKEN"))}, nil
+	}
+
+	password, _ := u.User.Password()
+
+	return &etcdSource{key: key, client: etcd.New(address, u.User.Username(), password)}, nil
+}
+
+type consulSource struct {
+	key    string
+	client *consul.Client
+}
+
+func (s *consulSource) Fetch(ctx context.Context, prev string) ([]byte, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, configFetchTimeout)
+	defer cancel()
+
+	return s.get(ctx, prev, 0)
+}
+
+func (s *consulSource) Watch(ctx context.Context, prev string) ([]byte, string, error) {
+	index, _ := strconv.ParseUint(strings.TrimPrefix(prev, "consul:"), 10, 64)
+
+	return s.get(ctx, prev, index)
+}
+
+func (s *consulSource) get(ctx context.Context, prev string, index uint64) ([]byte, string, error) {
+	data, modifyIndex, err := s.client.Get(ctx, s.key, index, consulWatchWait)
+	if err != nil {
+		return nil, "", err
+	}
+
+	version := "consul:" + strconv.FormatUint(modifyIndex, 10)
+	if version == prev {
+		return nil, version, nil
+	}
+
+	return data, version, nil
+}
+
+func (*consulSource) Remote() bool { return true }
+
+type etcdSource struct {
+	key    string
+	client *etcd.Client
+}
+
+// Fetch reads the key. Its version is "etcd:<mod revision>@<store revision>":
+// the mod revision tells whether the value changed, the store revision is where
+// the next watch starts.
+func (s *etcdSource) Fetch(ctx context.Context, prev string) ([]byte, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, configFetchTimeout)
+	defer cancel()
+
+	data, modRev, storeRev, err := s.client.Get(ctx, s.key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	version := etcdVersion(modRev, storeRev)
+	if prevMod, _, ok := parseEtcdVersion(prev); ok && prevMod == modRev {
+		return nil, version, nil
+	}
+
+	return data, version, nil
+}
+
+// Watch waits for the next write after the store revision of prev. When the
+// server cancels the watch, as it does once that revision is compacted, the key
+// is read again so that a write in between is not missed.
+func (s *etcdSource) Watch(ctx context.Context, prev string) ([]byte, string, error) {
+	_, storeRev, ok := parseEtcdVersion(prev)
+	if !ok {
+		return s.Fetch(ctx, prev)
+	}
+
+	data, rev, err := s.client.Watch(ctx, s.key, storeRev)
+	if errors.Is(err, etcd.ErrWatchCanceled) {
+		return s.Fetch(ctx, prev)
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, etcdVersion(rev, rev), nil
+}
+
+func etcdVersion(modRev, storeRev int64) string {
+	return "etcd:" + strconv.FormatInt(modRev, 10) + "@" + strconv.FormatInt(storeRev, 10)
+}
+
+func parseEtcdVersion(version string) (modRev, storeRev int64, ok bool) {
+	rest, found := strings.CutPrefix(version, "etcd:")
+	if !found {
+		return 0, 0, false
+	}
+
+	mod, store, _ := strings.Cut(rest, "@")
+
+	modRev, err := strconv.ParseInt(mod, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	storeRev, err = strconv.ParseInt(store, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return modRev, storeRev, true
+}
+
+func (*etcdSource) Remote() bool { return true }
@@ -0,0 +1,165 @@
+package kono
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("KV config sources", func() {
+	It("parses consul and etcd locations", func() {
+		src, err := NewConfigSource("consul://127.0.0.1:8500/kono/config")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(src).To(BeAssignableToTypeOf(&consulSource{}))
+		Expect(src.(*consulSource).key).To(Equal("kono/config"))
+
+		src, err = NewConfigSource("etcd+https://root:pw@etcd:2379//kono/config")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(src).To(BeAssignableToTypeOf(&etcdSource{}))
+		Expect(src.(*etcdSource).key).To(Equal("/kono/config"))
+
+		_, err = NewConfigSource("consul://127.0.0.1:8500")
+		Expect(err).To(MatchError(ContainSubstring("consul://<host>/<key>")))
+	})
+
+	It("watches a consul key with blocking queries", func() {
+		var queries []string
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Path).To(Equal("/v1/kv/kono/config"))
+			Expect(r.Header.Get("X-Consul-Token")).To(Equal("acl-token"))
+
+			queries = append(queries, r.URL.Query().Get("index"))
+
+			// The first blocking query times out unchanged, the second sees a write.
+			index, body := "7", "schema: v1\n"
+			if len(queries) == 3 {
+				index, body = "9", "schema: v1\ndebug: true\n"
+			}
+
+			w.Header().Set("X-Consul-Index", index)
+			_, _ = w.Write([]byte(body))
+		}))
+		DeferCleanup(srv.Close)
+
+		GinkgoT().Setenv("CONSUL_HTTP_TOKEN", "acl-token")
+
+		src, err := NewConfigSource("consul://" + strings.TrimPrefix(srv.URL, "http://") + "/kono/config")
+		Expect(err).NotTo(HaveOccurred())
+
+		ws := src.(WatchableSource)
+
+		data, version, err := ws.Fetch(context.Background(), "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("schema: v1\n"))
+		Expect(version).To(Equal("consul:7"))
+
+		data, version, err = ws.Watch(context.Background(), version)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(BeNil())
+		Expect(version).To(Equal("consul:7"))
+
+		data, version, err = ws.Watch(context.Background(), version)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("schema: v1\ndebug: true\n"))
+		Expect(version).To(Equal("consul:9"))
+
+		Expect(queries).To(Equal([]string{"", "7", "7"}))
+	})
+
+	It("reads and watches an etcd key", func() {
+		encode := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+		var authenticated int
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body map[string]any
+			Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+
+			switch r.URL.Path {
+			case "/v3/auth/authenticate":
+				authenticated++
+				Expect(body).To(Equal(map[string]any{"name": "root", "password": "pw"}))
+				_, _ = w.Write([]byte(`{"token":"t0k3n"}`))
+
+				return
+			case "/v3/kv/range":
+				Expect(r.Header.Get("Authorization")).To(Equal("t0k3n"))
+				Expect(body["key"]).To(Equal(encode("/kono/config")))
+
+				fmt.Fprintf(w, `{"header":{"revision":"20"},"kvs":[{"value":%q,"mod_revision":"12"}]}`, encode("schema: v1\n"))
+			case "/v3/watch":
+				req, _ := body["create_request"].(map[string]any)
+				Expect(req["start_revision"]).To(Equal("21"))
+
+				fmt.Fprintln(w, `{"result":{"created":true}}`)
+				w.(http.Flusher).Flush()
+				fmt.Fprintf(w, `{"result":{"events":[{"kv":{"value":%q,"mod_revision":"15"}}]}}`+"\n", encode("schema: v1\ndebug: true\n"))
+			}
+		}))
+		DeferCleanup(srv.Close)
+
+		src, err := NewConfigSource("etcd://root:pw@" + strings.TrimPrefix(srv.URL, "http://") + "//kono/config")
+		Expect(err).NotTo(HaveOccurred())
+
+		ws := src.(WatchableSource)
+
+		data, version, err := ws.Fetch(context.Background(), "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("schema: v1\n"))
+		Expect(version).To(Equal("etcd:12@20"))
+
+		data, version, err = ws.Watch(context.Background(), version)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("schema: v1\ndebug: true\n"))
+		Expect(version).To(Equal("etcd:15@15"))
+
+		Expect(authenticated).To(Equal(2))
+	})
+
+	It("reads an etcd key again when its watch is compacted", func() {
+		encode := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+		var reads int
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/v3/kv/range":
+				reads++
+
+				// The key is written while the watch is down.
+				mod, body := "12", "schema: v1\n"
+				if reads > 1 {
+					mod, body = "30", "schema: v1\ndebug: true\n"
+				}
+
+				fmt.Fprintf(w, `{"header":{"revision":"40"},"kvs":[{"value":%q,"mod_revision":%q}]}`, encode(body), mod)
+			case "/v3/watch":
+				fmt.Fprintln(w, `{"result":{"canceled":true,"compact_revision":"35"}}`)
+			}
+		}))
+		DeferCleanup(srv.Close)
+
+		src, err := NewConfigSource("etcd://" + strings.TrimPrefix(srv.URL, "http://") + "//kono/config")
+		Expect(err).NotTo(HaveOccurred())
+
+		ws := src.(WatchableSource)
+
+		data, version, err := ws.Watch(context.Background(), "etcd:12@20")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(BeNil())
+		Expect(version).To(Equal("etcd:12@40"))
+
+		data, version, err = ws.Watch(context.Background(), version)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("schema: v1\ndebug: true\n"))
+		Expect(version).To(Equal("etcd:30@40"))
+	})
+})
@@ -0,0 +1,149 @@
+package kono
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+var errUpstreamOverloaded = errors.New("upstream concurrency limit reached")
+
+// concurrencyLimiter bounds the in-flight calls to an upstream. release reports
+// how long the call took, zero when it is not a meaningful sample (streams), and
+// whether the upstream signalled overload.
+type concurrencyLimiter interface {
+	tryAcquire() bool
+	release(latency time.Duration, overloaded bool)
+}
+
+// buildConcurrencyLimiter returns nil when the upstream is not limited.
+func buildConcurrencyLimiter(cfg UpstreamConfig) concurrencyLimiter {
+	if cfg.AdaptiveConcurrency != nil {
+		return newAdaptiveLimiter(*cfg.AdaptiveConcurrency)
+	}
+
+	if cfg.MaxConcurrent > 0 {
+		return &fixedLimiter{sem: semaphore.NewWeighted(int64(cfg.MaxConcurrent))}
+	}
+
+	return nil
+}
+
+func acquireSlot(l concurrencyLimiter) bool {
+	return l == nil || l.tryAcquire()
+}
+
+func releaseSlot(l concurrencyLimiter, latency time.Duration, resp *upstreamResponse) {
+	if l != nil {
+		l.release(latency, isOverloadSignal(resp))
+	}
+}
+
+// isOverloadSignal reports responses that indicate the upstream is saturated.
+func isOverloadSignal(resp *upstreamResponse) bool {
+	if resp == nil {
+		return false
+	}
+
+	if resp.err != nil && (resp.err.kind == upstreamTimeout || resp.err.kind == upstreamConnection) {
+		return true
+	}
+
+	return resp.status == http.StatusTooManyRequests || resp.status == http.StatusServiceUnavailable
+}
+
+func overloadedResponse() *upstreamResponse {
+	return &upstreamResponse{err: &upstreamError{kind: upstreamOverloaded, err: errUpstreamOverloaded}}
+}
+
+// fixedLimiter is a bulkhead of max_concurrent slots.
+type fixedLimiter struct {
+	sem *semaphore.Weighted
+}
+
+func (l *fixedLimiter) tryAcquire() bool { return l.sem.TryAcquire(1) }
+
+func (l *fixedLimiter) release(time.Duration, bool) { l.sem.Release(1) }
+
+// adaptiveMinRTTSamples is how many samples the no-load latency estimate lives
+// for before it is measured afresh, so it follows lasting backend changes.
+const adaptiveMinRTTSamples = 250
+
+// adaptiveLimiter discovers the concurrency an upstream sustains with AIMD: the
+// limit grows by one per limit-sized batch of healthy calls and shrinks by
+// Backoff when a call is slower than LatencyTolerance times the lowest latency
+// seen recently, or when the upstream signals overload.
+type adaptiveLimiter struct {
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+
+	minLimit  float64
+	maxLimit  float64
+	tolerance float64
+	backoff   float64
+
+	minRTT  time.Duration
+	samples int
+}
+
+func newAdaptiveLimiter(cfg AdaptiveConcurrencyConfig) *adaptiveLimiter {
+	return &adaptiveLimiter{
+		limit:     float64(cfg.InitialLimit),
+		minLimit:  float64(cfg.MinLimit),
+		maxLimit:  float64(cfg.MaxLimit),
+		tolerance: cfg.LatencyTolerance,
+		backoff:   cfg.Backoff,
+	}
+}
+
+func (l *adaptiveLimiter) tryAcquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if float64(l.inflight) >= l.limit {
+		return false
+	}
+
+	l.inflight++
+
+	return true
+}
+
+func (l *adaptiveLimiter) release(latency time.Duration, overloaded bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Only calls made while the limit was nearly used say anything about it.
+	saturated := float64(l.inflight) >= l.limit/2
+	l.inflight--
+
+	if latency > 0 {
+		l.samples++
+
+		if l.minRTT == 0 || latency < l.minRTT || l.samples > adaptiveMinRTTSamples {
+			l.minRTT = latency
+			l.samples = 0
+		}
+	}
+
+	slow := latency > 0 && float64(latency) > l.tolerance*float64(l.minRTT)
+
+	switch {
+	case overloaded || slow:
+		l.limit = max(l.minLimit, l.limit*l.backoff)
+	case saturated:
+		l.limit = min(l.maxLimit, l.limit+1/l.limit)
+	}
+}
+
+// currentLimit returns the limit rounded down, as enforced by tryAcquire.
+func (l *adaptiveLimiter) currentLimit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return int(l.limit)
+}
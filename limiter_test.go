@@ -0,0 +1,100 @@
+package kono
+
+import (
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("adaptiveLimiter", func() {
+	newLimiter := func(initial int) *adaptiveLimiter {
+		return newAdaptiveLimiter(AdaptiveConcurrencyConfig{
+			InitialLimit:     initial,
+			MinLimit:         1,
+			MaxLimit:         50,
+			LatencyTolerance: 2,
+			Backoff:          0.5,
+		})
+	}
+
+	// fill takes every slot and releases them all with the given outcome.
+	fill := func(l *adaptiveLimiter, latency time.Duration, overloaded bool) {
+		n := 0
+		for l.tryAcquire() {
+			n++
+		}
+
+		for range n {
+			l.release(latency, overloaded)
+		}
+	}
+
+	It("rejects calls beyond the current limit", func() {
+		l := newLimiter(2)
+
+		Expect(l.tryAcquire()).To(BeTrue())
+		Expect(l.tryAcquire()).To(BeTrue())
+		Expect(l.tryAcquire()).To(BeFalse())
+
+		l.release(time.Millisecond, false)
+		Expect(l.tryAcquire()).To(BeTrue())
+	})
+
+	It("grows the limit while the upstream stays fast under load", func() {
+		l := newLimiter(4)
+
+		for range 20 {
+			fill(l, 10*time.Millisecond, false)
+		}
+
+		Expect(l.currentLimit()).To(BeNumerically(">", 4))
+	})
+
+	It("backs off when latency rises above the tolerance", func() {
+		l := newLimiter(10)
+		fill(l, 10*time.Millisecond, false)
+
+		Expect(l.tryAcquire()).To(BeTrue())
+		l.release(50*time.Millisecond, false)
+
+		Expect(l.currentLimit()).To(Equal(5))
+	})
+
+	It("backs off on overload signals and never drops below the minimum", func() {
+		l := newLimiter(8)
+
+		for range 10 {
+			Expect(l.tryAcquire()).To(BeTrue())
+			l.release(0, true)
+		}
+
+		Expect(l.currentLimit()).To(Equal(1))
+	})
+
+	It("does not grow while mostly idle", func() {
+		l := newLimiter(10)
+
+		for range 50 {
+			Expect(l.tryAcquire()).To(BeTrue())
+			l.release(10*time.Millisecond, false)
+		}
+
+		Expect(l.currentLimit()).To(Equal(10))
+	})
+})
+
+var _ = Describe("isOverloadSignal", func() {
+	DescribeTable("classifies upstream responses",
+		func(resp *upstreamResponse, want bool) {
+			Expect(isOverloadSignal(resp)).To(Equal(want))
+		},
+		Entry("success", &upstreamResponse{status: http.StatusOK}, false),
+		Entry("client error", &upstreamResponse{status: http.StatusNotFound}, false),
+		Entry("too many requests", &upstreamResponse{status: http.StatusTooManyRequests}, true),
+		Entry("unavailable", &upstreamResponse{status: http.StatusServiceUnavailable}, true),
+		Entry("timeout", &upstreamResponse{err: &upstreamError{kind: upstreamTimeout}}, true),
+		Entry("canceled", &upstreamResponse{err: &upstreamError{kind: upstreamCanceled}}, false),
+	)
+})
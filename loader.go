@@ -2,43 +2,186 @@ package kono
 
 import (
 	"fmt"
+	"maps"
+	"net"
 	"slices"
 	"strings"
+	"sync"
 
 	"go.uber.org/zap"
 
+	"github.com/starwalkn/kono/internal/geoip"
+	"github.com/starwalkn/kono/internal/introspect"
+	"github.com/starwalkn/kono/internal/jwtauth"
+	"github.com/starwalkn/kono/internal/metric"
 	"github.com/starwalkn/kono/sdk"
 )
 
 const (
-	sourceBuiltin = "builtin"
-	sourceFile    = "file"
+	sourceBuiltin  = "builtin"
+	sourceFile     = "file"
+	sourceRegistry = "registry"
+	sourceGRPC     = "grpc"
+	sourceLua      = "lua"
+	sourceJS       = "js"
 )
 
+// BuiltinPluginsPath and BuiltinMiddlewaresPath hold the shared objects of
+// plugins and middlewares configured with source "builtin".
 const (
-	builtinPluginsPath     = "/usr/local/lib/kono/plugins/"
-	builtinMiddlewaresPath = "/usr/local/lib/kono/middlewares/"
+	BuiltinPluginsPath     = "/usr/local/lib/kono/plugins/"
+	BuiltinMiddlewaresPath = "/usr/local/lib/kono/middlewares/"
 )
 
 const extSo = ".so"
 
-func initPlugins(cfgs []PluginConfig, log *zap.Logger) ([]sdk.Plugin, error) {
+// linkedMiddlewares are compiled into the gateway. A builtin middleware with
+// one of these names is created in-process instead of loaded from a .so.
+var linkedMiddlewares = map[string]func() sdk.Middleware{
+	"auth":          jwtauth.New,
+	"introspection": introspect.New,
+	"geoip":         geoip.New,
+}
+
+// ConfigSchemas returns the config schemas of the linked middlewares and the
+// registered plugins and aggregators that implement sdk.ConfigSchemaProvider,
+// keyed by kind ("middleware", "plugin", "aggregator") and then by name. Plugins
+// loaded from .so files are not known until the gateway starts.
+func ConfigSchemas() map[string]map[string]map[string]any {
+	middlewares := make(map[string]map[string]any)
+
+	for name, factory := range linkedMiddlewares {
+		if p, ok := factory().(sdk.ConfigSchemaProvider); ok {
+			middlewares[name] = p.ConfigSchema()
+		}
+	}
+
+	return map[string]map[string]map[string]any{
+		"middleware": middlewares,
+		"plugin":     pluginRegistry.schemas(),
+		"aggregator": aggregatorRegistry.schemas(),
+	}
+}
+
+// registry holds the in-process factories added with RegisterPlugin,
+// RegisterMiddleware and RegisterAggregator.
+type registry[T any] struct {
+	mu        sync.RWMutex
+	factories map[string]func() T
+}
+
+func newRegistry[T any]() *registry[T] {
+	return &registry[T]{factories: make(map[string]func() T)}
+}
+
+func (r *registry[T]) register(name string, factory func() T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.factories[name] = factory
+}
+
+func (r *registry[T]) lookup(name string) (func() T, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	factory, ok := r.factories[name]
+
+	return factory, ok
+}
+
+// schemas calls the factories on a copy, so a factory that registers another
+// component does not deadlock on the registry.
+func (r *registry[T]) schemas() map[string]map[string]any {
+	r.mu.RLock()
+	factories := maps.Clone(r.factories)
+	r.mu.RUnlock()
+
+	out := make(map[string]map[string]any)
+
+	for name, factory := range factories {
+		if p, ok := any(factory()).(sdk.ConfigSchemaProvider); ok {
+			out[name] = p.ConfigSchema()
+		}
+	}
+
+	return out
+}
+
+var (
+	pluginRegistry     = newRegistry[sdk.Plugin]()
+	middlewareRegistry = newRegistry[sdk.Middleware]()
+	aggregatorRegistry = newRegistry[sdk.Aggregator]()
+)
+
+// RegisterPlugin makes an in-process plugin available to flows as
+// source "registry", for applications that embed the gateway and compile their
+// plugins in instead of loading .so files. It must be called before the
+// gateway builds its router; registering the same name twice replaces the
+// previous factory. Each configured plugin gets its own instance.
+func RegisterPlugin(name string, factory func() sdk.Plugin) {
+	pluginRegistry.register(name, factory)
+}
+
+// RegisterMiddleware makes an in-process middleware available to flows as
+// source "registry". It follows the rules of RegisterPlugin.
+func RegisterMiddleware(name string, factory func() sdk.Middleware) {
+	middlewareRegistry.register(name, factory)
+}
+
+// pluginDeps holds what plugins calling upstreams themselves need from the flow.
+type pluginDeps struct {
+	trustedProxies []*net.IPNet
+	metrics        *metric.Metrics
+}
+
+// initPlugins loads and initializes the plugins in the order given, which is
+// the order they run in. Each config gets its own instance.
+func initPlugins(cfgs []PluginConfig, deps pluginDeps, log *zap.Logger) ([]sdk.Plugin, error) {
 	plugins := make([]sdk.Plugin, 0, len(cfgs))
 
 	for _, cfg := range cfgs {
-		if slices.ContainsFunc(plugins, func(p sdk.Plugin) bool {
-			return p.Info().Name == cfg.Name
-		}) {
+		if cfg.Source == sourceGRPC {
+			phases, err := newExtProcPlugins(cfg, log)
+			if err != nil {
+				return nil, fmt.Errorf("cannot create external processor %q: %w", cfg.Name, err)
+			}
+
+			log.Info("plugin initialized", zap.String("name", cfg.Name), zap.String("address", cfg.GRPC.Address))
+
+			for _, p := range phases {
+				plugins = append(plugins, withPluginPolicy(p, cfg))
+			}
+
 			continue
 		}
 
-		soPath, err := resolveSoPath(cfg.Source, cfg.Name, cfg.Path, builtinPluginsPath)
-		if err != nil {
-			return nil, fmt.Errorf("resolve .so path: %w", err)
+		if cfg.Source == sourceLua || cfg.Source == sourceJS {
+			newScript, sc := newLuaPlugins, cfg.Lua
+			if cfg.Source == sourceJS {
+				newScript, sc = newJSPlugins, cfg.JS
+			}
+
+			phases, err := newScript(cfg, deps, log)
+			if err != nil {
+				return nil, fmt.Errorf("cannot create %s plugin %q: %w", cfg.Source, cfg.Name, err)
+			}
+
+			log.Info("plugin initialized", zap.String("name", cfg.Name), zap.String("script", scriptName(cfg.Name, sc)))
+
+			for _, p := range phases {
+				plugins = append(plugins, withPluginPolicy(p, cfg))
+			}
+
+			continue
 		}
 
-		plugin, err := loadPlugin(soPath, cfg.Config, log)
+		plugin, err := newPlugin(cfg, log)
 		if err != nil {
+			if cfg.Source == sourceRegistry {
+				return nil, fmt.Errorf("cannot create registered plugin %q: %w", cfg.Name, err)
+			}
+
 			if cfg.Source == sourceBuiltin {
 				return nil, fmt.Errorf("cannot load builtin plugin %q: %w", cfg.Name, err)
 			}
@@ -48,12 +191,43 @@ func initPlugins(cfgs []PluginConfig, log *zap.Logger) ([]sdk.Plugin, error) {
 
 		log.Info("plugin initialized", zap.String("name", plugin.Info().Name))
 
-		plugins = append(plugins, plugin)
+		plugins = append(plugins, withPluginPolicy(plugin, cfg))
 	}
 
 	return plugins, nil
 }
 
+func newPlugin(cfg PluginConfig, log *zap.Logger) (sdk.Plugin, error) {
+	if cfg.Source == sourceRegistry {
+		factory, ok := pluginRegistry.lookup(cfg.Name)
+		if !ok {
+			return nil, fmt.Errorf("plugin %q is not registered", cfg.Name)
+		}
+
+		return initPlugin(factory(), cfg.Name, cfg.Config)
+	}
+
+	soPath, err := resolveSoPath(cfg.Source, cfg.Name, cfg.Path, BuiltinPluginsPath)
+	if err != nil {
+		return nil, fmt.Errorf("resolve .so path: %w", err)
+	}
+
+	return loadPlugin(soPath, cfg.Config, cfg.Verify, log)
+}
+
+// pluginNames lists the names of the plugins of one type in execution order.
+func pluginNames(plugins []sdk.Plugin, typ sdk.PluginType) []string {
+	var names []string
+
+	for _, p := range plugins {
+		if p.Type() == typ {
+			names = append(names, p.Info().Name)
+		}
+	}
+
+	return names
+}
+
 func initMiddlewares(cfgs []MiddlewareConfig, log *zap.Logger) ([]sdk.Middleware, error) {
 	middlewares := make([]sdk.Middleware, 0, len(cfgs))
 
@@ -64,13 +238,12 @@ func initMiddlewares(cfgs []MiddlewareConfig, log *zap.Logger) ([]sdk.Middleware
 			continue
 		}
 
-		soPath, err := resolveSoPath(cfg.Source, cfg.Name, cfg.Path, builtinMiddlewaresPath)
+		middleware, err := newMiddleware(cfg, log)
 		if err != nil {
-			return nil, fmt.Errorf("resolve .so path: %w", err)
-		}
+			if cfg.Source == sourceRegistry {
+				return nil, fmt.Errorf("cannot create registered middleware %q: %w", cfg.Name, err)
+			}
 
-		middleware, err := loadMiddleware(soPath, cfg.Config, log)
-		if err != nil {
 			if cfg.Source == sourceBuiltin {
 				return nil, fmt.Errorf("cannot load builtin middleware %q: %w", cfg.Name, err)
 			}
@@ -80,12 +253,44 @@ func initMiddlewares(cfgs []MiddlewareConfig, log *zap.Logger) ([]sdk.Middleware
 
 		log.Info("middleware initialized", zap.String("name", middleware.Name()))
 
+		middleware, err = withMiddlewareConditions(middleware, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("middleware %q: %w", cfg.Name, err)
+		}
+
 		middlewares = append(middlewares, middleware)
 	}
 
 	return middlewares, nil
 }
 
+func newMiddleware(cfg MiddlewareConfig, log *zap.Logger) (sdk.Middleware, error) {
+	if factory, ok := linkedMiddlewares[cfg.Name]; ok && cfg.Source == sourceBuiltin {
+		return initMiddleware(factory(), cfg.Config)
+	}
+
+	if cfg.Source == sourceRegistry {
+		factory, ok := middlewareRegistry.lookup(cfg.Name)
+		if !ok {
+			return nil, fmt.Errorf("middleware %q is not registered", cfg.Name)
+		}
+
+		mw := factory()
+		if mw == nil {
+			return nil, fmt.Errorf("middleware factory for %q returned nil", cfg.Name)
+		}
+
+		return initMiddleware(mw, cfg.Config)
+	}
+
+	soPath, err := resolveSoPath(cfg.Source, cfg.Name, cfg.Path, BuiltinMiddlewaresPath)
+	if err != nil {
+		return nil, fmt.Errorf("resolve .so path: %w", err)
+	}
+
+	return loadMiddleware(soPath, cfg.Config, cfg.Verify, log)
+}
+
 func resolveSoPath(source, name, filePath, builtinPath string) (string, error) {
 	switch source {
 	case sourceBuiltin:
@@ -102,26 +307,35 @@ func resolveSoPath(source, name, filePath, builtinPath string) (string, error) {
 	}
 }
 
-func loadPlugin(path string, cfg map[string]interface{}, log *zap.Logger) (sdk.Plugin, error) {
-	factory, err := loadSymbol[func() sdk.Plugin](path, "NewPlugin", log)
+func loadPlugin(path string, cfg map[string]interface{}, verify VerifyConfig, log *zap.Logger) (sdk.Plugin, error) {
+	factory, err := loadSymbol[func() sdk.Plugin](path, "NewPlugin", verify, log)
 	if err != nil {
 		return nil, fmt.Errorf("load plugin symbol: %w", err)
 	}
 
-	p := factory()
+	return initPlugin(factory(), path, cfg)
+}
+
+// initPlugin initializes a plugin created by the factory of origin, a .so path
+// or a registered name.
+func initPlugin(p sdk.Plugin, origin string, cfg map[string]interface{}) (sdk.Plugin, error) {
 	if p == nil {
-		return nil, fmt.Errorf("plugin factory for path %q returned nil", path)
+		return nil, fmt.Errorf("plugin factory for %q returned nil", origin)
 	}
 
-	if err = p.Init(cfg); err != nil {
+	if err := validateConfig(p, cfg); err != nil {
+		return nil, fmt.Errorf("invalid config for plugin %s: %w", p.Info().Name, err)
+	}
+
+	if err := p.Init(cfg); err != nil {
 		return nil, fmt.Errorf("init plugin %s: %w", p.Info().Name, err)
 	}
 
 	return p, nil
 }
 
-func loadMiddleware(path string, cfg map[string]interface{}, log *zap.Logger) (sdk.Middleware, error) {
-	factory, err := loadSymbol[func() sdk.Middleware](path, "NewMiddleware", log)
+func loadMiddleware(path string, cfg map[string]interface{}, verify VerifyConfig, log *zap.Logger) (sdk.Middleware, error) {
+	factory, err := loadSymbol[func() sdk.Middleware](path, "NewMiddleware", verify, log)
 	if err != nil {
 		return nil, fmt.Errorf("load middleware symbol: %w", err)
 	}
@@ -131,9 +345,78 @@ func loadMiddleware(path string, cfg map[string]interface{}, log *zap.Logger) (s
 		return nil, fmt.Errorf("middleware factory for path %q returned nil", path)
 	}
 
-	if err = mw.Init(cfg); err != nil {
+	return initMiddleware(mw, cfg)
+}
+
+func initMiddleware(mw sdk.Middleware, cfg map[string]interface{}) (sdk.Middleware, error) {
+	if err := validateConfig(mw, cfg); err != nil {
+		return nil, fmt.Errorf("invalid config for middleware %s: %w", mw.Name(), err)
+	}
+
+	if err := mw.Init(cfg); err != nil {
 		return nil, fmt.Errorf("init middleware %s: %w", mw.Name(), err)
 	}
 
 	return mw, nil
 }
+
+// RegisterAggregator makes an in-process aggregator available to flows
+// configured with the "custom" strategy and source "registry".
+// It must be called before the gateway builds its router; registering
+// the same name twice replaces the previous factory.
+func RegisterAggregator(name string, factory func() sdk.Aggregator) {
+	aggregatorRegistry.register(name, factory)
+}
+
+func initAggregator(cfg AggregatorConfig, log *zap.Logger) (sdk.Aggregator, error) {
+	var factory func() sdk.Aggregator
+
+	switch cfg.Source {
+	case sourceRegistry:
+		f, ok := aggregatorRegistry.lookup(cfg.Name)
+		if !ok {
+			return nil, fmt.Errorf("aggregator %q is not registered", cfg.Name)
+		}
+
+		factory = f
+	case sourceFile:
+		soPath, err := resolveSoPath(cfg.Source, cfg.Name, cfg.Path, "")
+		if err != nil {
+			return nil, fmt.Errorf("resolve .so path: %w", err)
+		}
+
+		factory, err = loadSymbol[func() sdk.Aggregator](soPath, "NewAggregator", cfg.Verify, log)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load aggregator %q from path %q: %w", cfg.Name, cfg.Path, err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid aggregator source %q", cfg.Source)
+	}
+
+	a := factory()
+	if a == nil {
+		return nil, fmt.Errorf("aggregator factory for %q returned nil", cfg.Name)
+	}
+
+	if err := validateConfig(a, cfg.Config); err != nil {
+		return nil, fmt.Errorf("invalid config for aggregator %s: %w", a.Name(), err)
+	}
+
+	if err := a.Init(cfg.Config); err != nil {
+		return nil, fmt.Errorf("init aggregator %s: %w", a.Name(), err)
+	}
+
+	log.Info("aggregator initialized", zap.String("name", a.Name()))
+
+	return a, nil
+}
+
+// validateConfig runs the config check of components implementing
+// sdk.ConfigValidator.
+func validateConfig(component any, cfg map[string]interface{}) error {
+	if v, ok := component.(sdk.ConfigValidator); ok {
+		return v.ValidateConfig(cfg)
+	}
+
+	return nil
+}
@@ -0,0 +1,352 @@
+package kono
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/kono/sdk"
+)
+
+// luaPlugin runs one entry point of a Lua script in-process.
+type luaPlugin struct {
+	name        string
+	script      string
+	fn          string
+	phase       sdk.PluginType
+	pool        *scriptPool[*lua.LState]
+	maxBodySize int64
+	timeout     time.Duration
+	log         *zap.Logger
+}
+
+// luaLibs are the standard libraries open to scripts. os, io, package, debug and
+// coroutine are left out, so that a script can neither reach the host nor stop
+// the gateway.
+var luaLibs = []struct {
+	name string
+	open lua.LGFunction
+}{
+	{lua.BaseLibName, lua.OpenBase},
+	{lua.TabLibName, lua.OpenTable},
+	{lua.StringLibName, lua.OpenString},
+	{lua.MathLibName, lua.OpenMath},
+}
+
+// luaLoaders are the base functions that load code from files, strings or
+// modules.
+var luaLoaders = []string{"dofile", "loadfile", "load", "loadstring", "require", "module"}
+
+func newLuaState() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+
+	for _, lib := range luaLibs {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	for _, name := range luaLoaders {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	return L
+}
+
+func newLuaPlugins(cfg PluginConfig, deps pluginDeps, log *zap.Logger) ([]sdk.Plugin, error) {
+	sc := cfg.Lua
+	name := scriptName(cfg.Name, sc)
+
+	src, err := loadScript(sc)
+	if err != nil {
+		return nil, err
+	}
+
+	chunk, err := parse.Parse(bytes.NewReader(src), name)
+	if err != nil {
+		return nil, fmt.Errorf("parse script: %w", err)
+	}
+
+	proto, err := lua.Compile(chunk, name)
+	if err != nil {
+		return nil, fmt.Errorf("compile script: %w", err)
+	}
+
+	caller, err := newScriptCaller(sc.Upstreams, deps, log.With(zap.String("plugin", cfg.Name)))
+	if err != nil {
+		return nil, err
+	}
+
+	newState := func() (*lua.LState, error) {
+		L := newLuaState()
+		registerLuaCall(L, caller)
+
+		L.Push(L.NewFunctionFromProto(proto))
+
+		if err := L.PCall(0, lua.MultRet, nil); err != nil {
+			L.Close()
+			return nil, fmt.Errorf("run script %s: %w", name, err)
+		}
+
+		L.SetTop(0)
+
+		return L, nil
+	}
+
+	pool := newScriptPool(sc.PoolSize, caller, newState, (*lua.LState).Close)
+
+	// Run the script once up front, so errors in its top level fail the start and
+	// the defined entry points pick the phases.
+	L, err := pool.get()
+	if err != nil {
+		caller.close()
+		return nil, err
+	}
+	defer pool.put(L)
+
+	plugins := make([]sdk.Plugin, 0, len(scriptEntryPoints))
+
+	for _, entry := range scriptEntryPoints {
+		if L.GetGlobal(entry.fn).Type() != lua.LTFunction {
+			continue
+		}
+
+		plugins = append(plugins, &luaPlugin{
+			name:        cfg.Name,
+			script:      name,
+			fn:          entry.fn,
+			phase:       entry.phase,
+			pool:        pool,
+			maxBodySize: sc.MaxBodySize,
+			timeout:     sc.Timeout,
+			log:         log.With(zap.String("plugin", cfg.Name)),
+		})
+	}
+
+	if len(plugins) == 0 {
+		caller.close()
+		return nil, fmt.Errorf("script %s defines neither %s nor %s", name, scriptOnRequest, scriptOnResponse)
+	}
+
+	return plugins, nil
+}
+
+func (p *luaPlugin) Info() sdk.PluginInfo {
+	return sdk.PluginInfo{
+		Name:        p.name,
+		Description: p.fn + " of Lua script " + p.script,
+	}
+}
+
+// Init does nothing: Lua plugins are configured through PluginConfig.Lua.
+func (p *luaPlugin) Init(map[string]interface{}) error { return nil }
+
+func (p *luaPlugin) Type() sdk.PluginType { return p.phase }
+
+func (p *luaPlugin) Close() error {
+	p.pool.close()
+	return nil
+}
+
+// Execute calls the entry point with a table describing the request (method,
+// path, query, headers, body) or the response (status, headers, body). Changes the
+// script makes to the table are applied; a returned table with a status answers
+// the client right away instead.
+func (p *luaPlugin) Execute(kctx sdk.Context) error {
+	L, err := p.pool.get()
+	if err != nil {
+		return err
+	}
+
+	req := kctx.Request()
+
+	ctx, cancel := req.Context(), context.CancelFunc(func() {})
+	if p.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+	}
+	defer cancel()
+
+	// The interpreter checks ctx between instructions, so a runaway script stops.
+	L.SetContext(withScriptRequest(ctx, req))
+
+	var (
+		arg     *lua.LTable
+		applyFn func(*lua.LTable) error
+	)
+
+	if p.phase == sdk.PluginTypeResponse {
+		arg, applyFn, err = luaResponse(L, kctx.Response())
+	} else {
+		arg, applyFn, err = p.luaRequest(L, req)
+	}
+
+	if err == nil {
+		err = L.CallByParam(lua.P{Fn: L.GetGlobal(p.fn), NRet: 1, Protect: true}, arg)
+	}
+
+	if err != nil {
+		// A failed call may leave the interpreter mid-execution; do not reuse it.
+		L.Close()
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && req.Context().Err() == nil {
+			return fmt.Errorf("lua %s: %w after %s", p.fn, errPluginTimeout, p.timeout)
+		}
+
+		return fmt.Errorf("lua %s: %w", p.fn, err)
+	}
+
+	ret := L.Get(-1)
+	L.Pop(1)
+	L.RemoveContext()
+	p.pool.put(L)
+
+	if abort, ok := luaAbort(ret); ok {
+		if !validStatus(abort.Status) {
+			return fmt.Errorf("lua %s: returned invalid status %d", p.fn, abort.Status)
+		}
+
+		return abort
+	}
+
+	return applyFn(arg)
+}
+
+func (p *luaPlugin) luaRequest(L *lua.LState, req *http.Request) (*lua.LTable, func(*lua.LTable) error, error) {
+	body, ok, err := readScriptBody(req, p.maxBodySize, p.log)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t := L.NewTable()
+	t.RawSetString("method", lua.LString(req.Method))
+	t.RawSetString("path", lua.LString(req.URL.Path))
+	t.RawSetString("query", lua.LString(req.URL.RawQuery))
+	t.RawSetString("headers", luaHeaders(L, req.Header))
+
+	if ok {
+		t.RawSetString("body", lua.LString(body))
+	}
+
+	return t, func(t *lua.LTable) error {
+		applyLuaHeaders(t.RawGetString("headers"), req.Header)
+
+		if changed, isString := t.RawGetString("body").(lua.LString); isString && (!ok || string(changed) != string(body)) {
+			req.Body = io.NopCloser(bytes.NewReader([]byte(changed)))
+			req.ContentLength = int64(len(changed))
+		}
+
+		if path, ok := t.RawGetString("path").(lua.LString); ok {
+			req.URL.Path, req.URL.RawPath = string(path), ""
+		}
+
+		if query, ok := t.RawGetString("query").(lua.LString); ok {
+			req.URL.RawQuery = string(query)
+		}
+
+		return nil
+	}, nil
+}
+
+func luaResponse(L *lua.LState, resp *http.Response) (*lua.LTable, func(*lua.LTable) error, error) {
+	if resp == nil {
+		return nil, nil, errors.New("no response to process")
+	}
+
+	body, err := readScriptResponseBody(resp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t := L.NewTable()
+	t.RawSetString("status", lua.LNumber(resp.StatusCode))
+	t.RawSetString("headers", luaHeaders(L, resp.Header))
+	t.RawSetString("body", lua.LString(body))
+
+	return t, func(t *lua.LTable) error {
+		applyLuaHeaders(t.RawGetString("headers"), resp.Header)
+
+		if status, ok := t.RawGetString("status").(lua.LNumber); ok {
+			if !validStatus(int(status)) {
+				return fmt.Errorf("script set invalid status %d", int(status))
+			}
+
+			resp.StatusCode = int(status)
+		}
+
+		if changed, ok := t.RawGetString("body").(lua.LString); ok && string(changed) != string(body) {
+			resp.Body = io.NopCloser(bytes.NewReader([]byte(changed)))
+			resp.ContentLength = int64(len(changed))
+		}
+
+		return nil
+	}, nil
+}
+
+// luaHeaders exposes the first value of each header, keyed by canonical name.
+func luaHeaders(L *lua.LState, h http.Header) *lua.LTable {
+	t := L.CreateTable(0, len(h))
+
+	for name := range h {
+		t.RawSetString(name, lua.LString(h.Get(name)))
+	}
+
+	return t
+}
+
+// applyLuaHeaders removes the headers the script dropped from the table and sets
+// those it added or changed. Untouched headers keep all their values.
+func applyLuaHeaders(v lua.LValue, h http.Header) {
+	t, ok := v.(*lua.LTable)
+	if !ok {
+		return
+	}
+
+	for name := range h {
+		if t.RawGetString(name) == lua.LNil {
+			h.Del(name)
+		}
+	}
+
+	t.ForEach(func(k, v lua.LValue) {
+		name, value := k.String(), v.String()
+		if v == lua.LNil || h.Get(name) == value {
+			return
+		}
+
+		h.Set(name, value)
+	})
+}
+
+// luaAbort turns a returned {status=..., headers=..., body=...} table into an
+// sdk.AbortError.
+func luaAbort(v lua.LValue) (*sdk.AbortError, bool) {
+	t, ok := v.(*lua.LTable)
+	if !ok {
+		return nil, false
+	}
+
+	status, ok := t.RawGetString("status").(lua.LNumber)
+	if !ok {
+		return nil, false
+	}
+
+	abort := &sdk.AbortError{Status: int(status), Header: make(http.Header)}
+
+	if headers, isTable := t.RawGetString("headers").(*lua.LTable); isTable {
+		headers.ForEach(func(k, v lua.LValue) { abort.Header.Set(k.String(), v.String()) })
+	}
+
+	if body, isString := t.RawGetString("body").(lua.LString); isString {
+		abort.Body = []byte(body)
+	}
+
+	return abort, true
+}
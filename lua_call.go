@@ -0,0 +1,68 @@
+package kono
+
+import (
+	"net/url"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// registerLuaCall exposes kono.call(name, opts) to the scripts of L. It returns
+// a table with status, headers and body, or nil and the error kind when the call
+// fails.
+func registerLuaCall(L *lua.LState, c *scriptCaller) {
+	mod := L.NewTable()
+	mod.RawSetString("call", L.NewFunction(func(L *lua.LState) int { return luaCall(L, c) }))
+	L.SetGlobal("kono", mod)
+}
+
+func luaCall(L *lua.LState, c *scriptCaller) int {
+	name := L.CheckString(1)
+	opts := L.OptTable(2, L.NewTable())
+
+	var sc scriptCall
+
+	if method, isString := opts.RawGetString("method").(lua.LString); isString {
+		sc.method = string(method)
+	}
+
+	switch query := opts.RawGetString("query").(type) {
+	case lua.LString:
+		raw := string(query)
+		sc.query = &raw
+	case *lua.LTable:
+		values := url.Values{}
+		query.ForEach(func(k, v lua.LValue) { values.Set(k.String(), v.String()) })
+		raw := values.Encode()
+		sc.query = &raw
+	}
+
+	if headers, isTable := opts.RawGetString("headers").(*lua.LTable); isTable {
+		sc.headers = make(map[string]string)
+		headers.ForEach(func(k, v lua.LValue) { sc.headers[k.String()] = v.String() })
+	}
+
+	if b, isString := opts.RawGetString("body").(lua.LString); isString {
+		sc.body = []byte(b)
+	}
+
+	resp, err := c.call(L.Context(), name, sc)
+	if err != nil {
+		L.RaiseError("%s", err.Error())
+		return 0
+	}
+
+	if resp.err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(resp.err.Error()))
+
+		return 2 //nolint:mnd // nil and the error.
+	}
+
+	t := L.NewTable()
+	t.RawSetString("status", lua.LNumber(resp.status))
+	t.RawSetString("headers", luaHeaders(L, resp.headers))
+	t.RawSetString("body", lua.LString(resp.body))
+	L.Push(t)
+
+	return 1
+}
@@ -0,0 +1,337 @@
+package kono
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/kono/sdk"
+)
+
+var _ = Describe("luaPlugin", func() {
+	newPlugins := func(script string) ([]sdk.Plugin, error) {
+		path := filepath.Join(GinkgoT().TempDir(), "flow.lua")
+		Expect(os.WriteFile(path, []byte(script), 0o600)).To(Succeed())
+
+		lc := &ScriptConfig{Script: path, PoolSize: 2, MaxBodySize: 16, Timeout: 50 * time.Millisecond}
+
+		plugins, err := newLuaPlugins(PluginConfig{Name: "script", Source: sourceLua, Lua: lc}, pluginDeps{metrics: testMetrics}, zap.NewNop())
+		if err == nil {
+			DeferCleanup(func() { _ = plugins[0].(sdk.Closer).Close() })
+		}
+
+		return plugins, err
+	}
+
+	It("runs inline scripts", func() {
+		plugins, err := newLuaPlugins(PluginConfig{Name: "tag", Source: sourceLua, Lua: &ScriptConfig{
+			Source: scriptSourceInline,
+			Script: `function on_request(req) req.headers["X-Tag"] = "inline" end`,
+		}}, pluginDeps{}, zap.NewNop())
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(func() { _ = plugins[0].(sdk.Closer).Close() })
+
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		Expect(plugins[0].Execute(newContext(req))).To(Succeed())
+		Expect(req.Header.Get("X-Tag")).To(Equal("inline"))
+
+		_, err = newLuaPlugins(PluginConfig{Name: "broken", Source: sourceLua, Lua: &ScriptConfig{
+			Source: scriptSourceInline,
+			Script: "function on_request(",
+		}}, pluginDeps{}, zap.NewNop())
+		Expect(err).To(MatchError(ContainSubstring("broken (inline)")))
+	})
+
+	It("keeps host access out of reach of scripts", func() {
+		plugins, err := newPlugins(`
+function on_request(req)
+  req.headers["X-Libs"] = type(os) .. " " .. type(io) .. " " .. type(require) .. " " .. type(dofile) .. " " .. type(load)
+  req.headers["X-Upper"] = string.upper(table.concat({"a", "b"})) .. math.floor(1.5)
+end`)
+		Expect(err).NotTo(HaveOccurred())
+
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		Expect(plugins[0].Execute(newContext(req))).To(Succeed())
+		Expect(req.Header.Get("X-Libs")).To(Equal("nil nil nil nil nil"))
+		Expect(req.Header.Get("X-Upper")).To(Equal("AB1"))
+	})
+
+	It("creates a plugin per defined entry point", func() {
+		plugins, err := newPlugins("function on_response(resp) end\nfunction on_request(req) end")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plugins).To(HaveLen(2))
+		Expect(plugins[0].Type()).To(Equal(sdk.PluginTypeRequest))
+		Expect(plugins[1].Type()).To(Equal(sdk.PluginTypeResponse))
+
+		_, err = newPlugins("local x = 1")
+		Expect(err).To(MatchError(ContainSubstring("defines neither")))
+
+		_, err = newPlugins("function on_request(req")
+		Expect(err).To(MatchError(ContainSubstring("parse script")))
+	})
+
+	It("applies changes to the request", func() {
+		plugins, err := newPlugins(`
+function on_request(req)
+  req.headers["X-Tenant"] = "acme"
+  req.headers["X-Internal"] = nil
+  req.path = "/v2" .. req.path
+  req.query = req.query .. "&b=2"
+end`)
+		Expect(err).NotTo(HaveOccurred())
+
+		req := httptest.NewRequest(http.MethodGet, "/items?a=1", nil)
+		req.Header.Set("X-Internal", "secret")
+		req.Header.Add("Accept", "a")
+		req.Header.Add("Accept", "b")
+		kctx := newContext(req)
+
+		// Interpreters are reused between calls.
+		for range 3 {
+			Expect(plugins[0].Execute(kctx)).To(Succeed())
+		}
+
+		Expect(req.Header.Get("X-Tenant")).To(Equal("acme"))
+		Expect(req.Header.Get("X-Internal")).To(BeEmpty())
+		Expect(req.Header.Values("Accept")).To(Equal([]string{"a", "b"}))
+		Expect(req.URL.Path).To(Equal("/v2/v2/v2/items"))
+		Expect(req.URL.RawQuery).To(Equal("a=1&b=2&b=2&b=2"))
+	})
+
+	It("passes the request body and applies the returned one", func() {
+		plugins, err := newPlugins(`
+function on_request(req)
+  if req.body == nil then
+    req.headers["X-Body"] = "skipped"
+    return
+  end
+  req.body = string.upper(req.body)
+end`)
+		Expect(err).NotTo(HaveOccurred())
+
+		req := httptest.NewRequest(http.MethodPost, "/items", bytes.NewReader([]byte("hello")))
+		Expect(plugins[0].Execute(newContext(req))).To(Succeed())
+
+		body, _ := io.ReadAll(req.Body)
+		Expect(string(body)).To(Equal("HELLO"))
+		Expect(req.ContentLength).To(Equal(int64(5)))
+
+		large := bytes.Repeat([]byte("x"), 32)
+		req = httptest.NewRequest(http.MethodPost, "/items", bytes.NewReader(large))
+		Expect(plugins[0].Execute(newContext(req))).To(Succeed())
+
+		body, _ = io.ReadAll(req.Body)
+		Expect(body).To(Equal(large))
+		Expect(req.Header.Get("X-Body")).To(Equal("skipped"))
+	})
+
+	It("applies changes to the response", func() {
+		plugins, err := newPlugins(`
+function on_response(resp)
+  resp.status = 202
+  resp.body = string.upper(resp.body)
+  resp.headers["X-Scripted"] = "yes"
+end`)
+		Expect(err).NotTo(HaveOccurred())
+
+		kctx := newContext(httptest.NewRequest(http.MethodGet, "/items", nil))
+		kctx.SetResponse(&http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(bytes.NewReader([]byte("ok")))})
+
+		Expect(plugins[0].Execute(kctx)).To(Succeed())
+
+		body, _ := io.ReadAll(kctx.Response().Body)
+		Expect(string(body)).To(Equal("OK"))
+		Expect(kctx.Response().StatusCode).To(Equal(http.StatusAccepted))
+		Expect(kctx.Response().Header.Get("X-Scripted")).To(Equal("yes"))
+	})
+
+	It("aborts with a returned response and reports script errors", func() {
+		plugins, err := newPlugins(`
+function on_request(req)
+  if req.headers["X-Block"] then
+    return {status = 403, headers = {["X-Reason"] = "blocked"}, body = "denied"}
+  end
+  if req.headers["X-Fail"] then
+    error("boom")
+  end
+  if req.headers["X-Bogus"] then
+    return {status = 1000}
+  end
+end`)
+		Expect(err).NotTo(HaveOccurred())
+
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set("X-Block", "1")
+
+		var abort *sdk.AbortError
+		Expect(errors.As(plugins[0].Execute(newContext(req)), &abort)).To(BeTrue())
+		Expect(abort.Status).To(Equal(http.StatusForbidden))
+		Expect(abort.Header.Get("X-Reason")).To(Equal("blocked"))
+		Expect(string(abort.Body)).To(Equal("denied"))
+
+		req = httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set("X-Fail", "1")
+		Expect(plugins[0].Execute(newContext(req))).To(MatchError(ContainSubstring("boom")))
+
+		req = httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set("X-Bogus", "1")
+		Expect(plugins[0].Execute(newContext(req))).To(MatchError(ContainSubstring("invalid status 1000")))
+
+		Expect(plugins[0].Execute(newContext(httptest.NewRequest(http.MethodGet, "/items", nil)))).To(Succeed())
+	})
+
+	It("interrupts a script that overruns its timeout", func() {
+		plugins, err := newPlugins(`
+function on_request(req)
+  if req.headers["X-Loop"] then
+    while true do end
+  end
+end`)
+		Expect(err).NotTo(HaveOccurred())
+
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set("X-Loop", "1")
+
+		start := time.Now()
+		Expect(plugins[0].Execute(newContext(req))).To(MatchError(errPluginTimeout))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+
+		Expect(plugins[0].Execute(newContext(httptest.NewRequest(http.MethodGet, "/items", nil)))).To(Succeed())
+	})
+
+	It("continues without the script when failing open", func() {
+		plugins, err := newPlugins("function on_request(req) error('boom') end")
+		Expect(err).NotTo(HaveOccurred())
+
+		p := withPluginPolicy(plugins[0], PluginConfig{OnError: onErrorContinue})
+
+		r := newTestRouter([]flow{{
+			path:        "/test/lua",
+			method:      http.MethodGet,
+			aggregation: aggregation{strategy: strategyArray},
+			plugins:     []sdk.Plugin{p},
+		}}, &mockScatter{results: []upstreamResponse{{status: http.StatusOK, body: []byte(`{}`)}}}, &defaultAggregator{})
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test/lua", nil))
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+	})
+
+	It("calls auxiliary upstreams", func() {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-User") != "ann" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"allowed":true,"tier":"` + r.URL.Query().Get("scope") + `"}`))
+		}))
+		DeferCleanup(backend.Close)
+
+		path := filepath.Join(GinkgoT().TempDir(), "auth.lua")
+		Expect(os.WriteFile(path, []byte(`
+function on_request(req)
+  local resp, err = kono.call("authz", {headers = {["X-User"] = req.headers["X-User"]}, query = {scope = "gold"}})
+  if resp == nil then
+    return {status = 502, body = err}
+  end
+  if resp.status ~= 200 then
+    return {status = 403}
+  end
+  req.headers["X-Decision"] = resp.body
+end`), 0o600)).To(Succeed())
+
+		plugins, err := newLuaPlugins(PluginConfig{Name: "authz", Source: sourceLua, Lua: &ScriptConfig{
+			Script: path,
+			Upstreams: []UpstreamConfig{{
+				Name:           "authz",
+				Hosts:          AddrList{backend.URL},
+				Path:           "/decide",
+				Method:         http.MethodGet,
+				Timeout:        time.Second,
+				ForwardHeaders: []string{"X-User"},
+				ForwardQueries: []string{"scope"},
+			}},
+		}}, pluginDeps{metrics: testMetrics}, zap.NewNop())
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(func() { _ = plugins[0].(sdk.Closer).Close() })
+
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set("X-User", "ann")
+		Expect(plugins[0].Execute(newContext(req))).To(Succeed())
+		Expect(req.Header.Get("X-Decision")).To(MatchJSON(`{"allowed":true,"tier":"gold"}`))
+
+		var abort *sdk.AbortError
+		Expect(errors.As(plugins[0].Execute(newContext(httptest.NewRequest(http.MethodGet, "/items", nil))), &abort)).To(BeTrue())
+		Expect(abort.Status).To(Equal(http.StatusForbidden))
+
+		backend.Close()
+		Expect(errors.As(plugins[0].Execute(newContext(httptest.NewRequest(http.MethodGet, "/items", nil))), &abort)).To(BeTrue())
+		Expect(abort.Status).To(Equal(http.StatusBadGateway))
+		Expect(string(abort.Body)).To(Equal("connection"))
+	})
+
+	It("forwards the body a script rewrites to the upstreams", func() {
+		var received []byte
+
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received, _ = io.ReadAll(r.Body)
+			_, _ = w.Write([]byte(`{}`))
+		}))
+		DeferCleanup(backend.Close)
+
+		// With a timeout the script runs on a copy of the request, whose changes are
+		// applied when it returns in time.
+		for _, timeout := range []time.Duration{0, time.Second} {
+			bundle, err := NewRouter(context.Background(), RoutingConfigSet{
+				Service: ServiceConfig{Name: "kono-test"},
+				Routing: RoutingConfig{
+					Flows: []FlowConfig{{
+						Path:              "/items",
+						Method:            http.MethodPost,
+						ParallelUpstreams: 1,
+						Aggregation:       &AggregationConfig{Strategy: "array"},
+						Plugins: []PluginConfig{{
+							Name:    "rewrite",
+							Source:  sourceLua,
+							Timeout: timeout,
+							Lua: &ScriptConfig{
+								Source:      scriptSourceInline,
+								Script:      `function on_request(req) req.body = string.upper(req.body) end`,
+								MaxBodySize: 1024,
+							},
+						}},
+						Upstreams: []UpstreamConfig{{
+							Name:    "items",
+							Hosts:   AddrList{backend.URL},
+							Path:    "/items",
+							Method:  http.MethodPost,
+							Timeout: time.Second,
+						}},
+					}},
+				},
+			}, zap.NewNop())
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(func() { _ = bundle.Router.Shutdown(context.Background()) })
+
+			rec := httptest.NewRecorder()
+			bundle.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/items", strings.NewReader("item")))
+
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(string(received)).To(Equal("ITEM"))
+		}
+	})
+})
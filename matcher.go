@@ -0,0 +1,282 @@
+package kono
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/starwalkn/kono/sdk"
+)
+
+type headerMatchKind uint8
+
+const (
+	headerMatchExact headerMatchKind = iota
+	headerMatchPrefix
+	headerMatchRegex
+)
+
+// headerMatcher is a compiled HeaderMatcherConfig.
+type headerMatcher struct {
+	name  string
+	kind  headerMatchKind
+	value string
+	re    *regexp.Regexp
+}
+
+// matches reports whether any value of the header satisfies the matcher.
+// A missing header never matches.
+func (m headerMatcher) matches(h http.Header) bool {
+	for _, v := range h.Values(m.name) {
+		switch m.kind {
+		case headerMatchExact:
+			if v == m.value {
+				return true
+			}
+		case headerMatchPrefix:
+			if strings.HasPrefix(v, m.value) {
+				return true
+			}
+		case headerMatchRegex:
+			if m.re.MatchString(v) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// matches reports whether the request satisfies the flow's listener, SNI and header matchers.
+func (f *flow) matches(req *http.Request) bool {
+	return f.matchListener(listenerFromContext(req.Context())) &&
+		f.matchServerName(req.TLS) &&
+		f.matchHeaders(req.Header)
+}
+
+func (f *flow) matchListener(name string) bool {
+	if len(f.listeners) == 0 {
+		return name == DefaultListener
+	}
+
+	return slices.Contains(f.listeners, name)
+}
+
+// matchServerName reports whether the TLS server name matches one of the flow's
+// hostnames. Flows without hostnames match any connection, TLS or not.
+func (f *flow) matchServerName(state *tls.ConnectionState) bool {
+	if len(f.serverNames) == 0 {
+		return true
+	}
+
+	if state == nil || state.ServerName == "" {
+		return false
+	}
+
+	name := strings.ToLower(state.ServerName)
+
+	for _, pattern := range f.serverNames {
+		if wildcard, ok := strings.CutPrefix(pattern, "*."); ok {
+			label, rest, found := strings.Cut(name, ".")
+			if found && label != "" && rest == wildcard {
+				return true
+			}
+
+			continue
+		}
+
+		if name == pattern {
+			return true
+		}
+	}
+
+	return false
+}
+
+func compileServerNames(names []string) ([]string, error) {
+	compiled := make([]string, 0, len(names))
+
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+		if name == "" || strings.Contains(strings.TrimPrefix(name, "*."), "*") {
+			return nil, fmt.Errorf("invalid sni hostname %q", name)
+		}
+
+		compiled = append(compiled, name)
+	}
+
+	return compiled, nil
+}
+
+// matchHeaders reports whether every matcher of the flow is satisfied.
+func (f *flow) matchHeaders(h http.Header) bool {
+	for _, m := range f.headerMatchers {
+		if !m.matches(h) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func compileHeaderMatchers(cfgs []HeaderMatcherConfig) ([]headerMatcher, error) {
+	matchers := make([]headerMatcher, 0, len(cfgs))
+
+	for _, cfg := range cfgs {
+		m, err := compileHeaderMatcher(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("header %q: %w", cfg.Name, err)
+		}
+
+		matchers = append(matchers, m)
+	}
+
+	return matchers, nil
+}
+
+func compileHeaderMatcher(cfg HeaderMatcherConfig) (headerMatcher, error) {
+	m := headerMatcher{name: http.CanonicalHeaderKey(cfg.Name)}
+
+	set := 0
+
+	if cfg.Exact != "" {
+		m.kind, m.value = headerMatchExact, cfg.Exact
+		set++
+	}
+
+	if cfg.Prefix != "" {
+		m.kind, m.value = headerMatchPrefix, cfg.Prefix
+		set++
+	}
+
+	if cfg.Regex != "" {
+		re, err := regexp.Compile(cfg.Regex)
+		if err != nil {
+			return headerMatcher{}, fmt.Errorf("invalid regex: %w", err)
+		}
+
+		m.kind, m.re = headerMatchRegex, re
+		set++
+	}
+
+	if set != 1 {
+		return headerMatcher{}, errors.New("exactly one of exact, prefix or regex must be set")
+	}
+
+	return m, nil
+}
+
+// requestCondition is a compiled RequestMatchConfig.
+type requestCondition struct {
+	pathPrefixes []string
+	methods      []string
+	headers      []headerMatcher
+}
+
+func compileRequestCondition(cfg *RequestMatchConfig) (*requestCondition, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	headers, err := compileHeaderMatchers(cfg.Headers)
+	if err != nil {
+		return nil, err
+	}
+
+	methods := make([]string, len(cfg.Methods))
+	for i, m := range cfg.Methods {
+		methods[i] = strings.ToUpper(m)
+	}
+
+	return &requestCondition{pathPrefixes: cfg.PathPrefixes, methods: methods, headers: headers}, nil
+}
+
+func (c *requestCondition) matches(req *http.Request) bool {
+	// Match the path the router routes on, so an encoded path cannot select a
+	// flow while slipping past the condition.
+	path := req.URL.RawPath
+	if path == "" {
+		path = req.URL.Path
+	}
+
+	if len(c.pathPrefixes) > 0 && !slices.ContainsFunc(c.pathPrefixes, func(p string) bool {
+		return hasPathPrefix(path, p)
+	}) {
+		return false
+	}
+
+	if len(c.methods) > 0 && !slices.Contains(c.methods, req.Method) {
+		return false
+	}
+
+	for _, m := range c.headers {
+		if !m.matches(req.Header) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasPathPrefix reports whether path is prefix or lies under it: "/public"
+// matches "/public" and "/public/docs", not "/publicity".
+func hasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// conditionalMiddleware runs a middleware only for the requests selected by the
+// when and unless conditions of its MiddlewareConfig.
+type conditionalMiddleware struct {
+	sdk.Middleware
+
+	when, unless *requestCondition
+}
+
+func withMiddlewareConditions(mw sdk.Middleware, cfg MiddlewareConfig) (sdk.Middleware, error) {
+	when, err := compileRequestCondition(cfg.When)
+	if err != nil {
+		return nil, fmt.Errorf("when: %w", err)
+	}
+
+	unless, err := compileRequestCondition(cfg.Unless)
+	if err != nil {
+		return nil, fmt.Errorf("unless: %w", err)
+	}
+
+	if when == nil && unless == nil {
+		return mw, nil
+	}
+
+	return conditionalMiddleware{Middleware: mw, when: when, unless: unless}, nil
+}
+
+func (m conditionalMiddleware) Handler(next http.Handler) http.Handler {
+	wrapped := m.Middleware.Handler(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if m.applies(req) {
+			wrapped.ServeHTTP(w, req)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+func (m conditionalMiddleware) applies(req *http.Request) bool {
+	return (m.when == nil || m.when.matches(req)) && (m.unless == nil || !m.unless.matches(req))
+}
+
+func (m conditionalMiddleware) Shutdown(ctx context.Context) error {
+	return closeComponent(ctx, m.Middleware)
+}
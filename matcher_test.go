@@ -0,0 +1,71 @@
+package kono
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("headerMatcher", func() {
+	DescribeTable("matches",
+		func(cfg HeaderMatcherConfig, value string, expected bool) {
+			m, err := compileHeaderMatcher(cfg)
+			Expect(err).NotTo(HaveOccurred())
+
+			h := http.Header{}
+			if value != "" {
+				h.Set("X-Api-Version", value)
+			}
+
+			Expect(m.matches(h)).To(Equal(expected))
+		},
+		Entry("exact hit", HeaderMatcherConfig{Name: "x-api-version", Exact: "2"}, "2", true),
+		Entry("exact miss", HeaderMatcherConfig{Name: "X-API-Version", Exact: "2"}, "20", false),
+		Entry("prefix hit", HeaderMatcherConfig{Name: "X-API-Version", Prefix: "2."}, "2.1", true),
+		Entry("regex hit", HeaderMatcherConfig{Name: "X-API-Version", Regex: `^[23]$`}, "3", true),
+		Entry("missing header", HeaderMatcherConfig{Name: "X-API-Version", Exact: "2"}, "", false),
+	)
+
+	It("requires exactly one match kind", func() {
+		_, err := compileHeaderMatcher(HeaderMatcherConfig{Name: "X-API-Version", Exact: "2", Prefix: "2"})
+		Expect(err).To(MatchError(ContainSubstring("exactly one of")))
+
+		_, err = compileHeaderMatcher(HeaderMatcherConfig{Name: "X-API-Version"})
+		Expect(err).To(MatchError(ContainSubstring("exactly one of")))
+	})
+
+	It("rejects an invalid regex", func() {
+		_, err := compileHeaderMatcher(HeaderMatcherConfig{Name: "X-API-Version", Regex: "("})
+		Expect(err).To(MatchError(ContainSubstring("invalid regex")))
+	})
+})
+
+var _ = Describe("compileServerNames", func() {
+	It("normalizes hostnames", func() {
+		names, err := compileServerNames([]string{"API.Example.com.", "*.example.org"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names).To(Equal([]string{"api.example.com", "*.example.org"}))
+	})
+
+	It("rejects wildcards outside the leading label", func() {
+		_, err := compileServerNames([]string{"api.*.example.com"})
+		Expect(err).To(MatchError(ContainSubstring("invalid sni hostname")))
+	})
+})
+
+var _ = Describe("requestCondition", func() {
+	DescribeTable("matches path prefixes on segment boundaries",
+		func(prefix, path string, expected bool) {
+			c, err := compileRequestCondition(&RequestMatchConfig{PathPrefixes: []string{prefix}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.matches(httptest.NewRequest(http.MethodGet, path, nil))).To(Equal(expected))
+		},
+		Entry("the prefix itself", "/public", "/public", true),
+		Entry("a path under it", "/public", "/public/docs", true),
+		Entry("a longer segment", "/public", "/publicity", false),
+		Entry("a prefix ending in a slash", "/public/", "/public/docs", true),
+		Entry("an encoded path", "/public", "/%70ublic/docs", false),
+	)
+})
@@ -0,0 +1,174 @@
+package kono
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	schemaV1      = "v1"
+	schemaCurrent = "v2"
+)
+
+// v2Renames lists the keys v2 renamed, per configuration struct. v1 used
+// header_blacklist for the request headers a policy drops, next to the
+// allow/deny pairs everywhere else.
+var v2Renames = map[reflect.Type]map[string]string{
+	reflect.TypeFor[PolicyConfig](): {"header_blacklist": "header_deny"},
+}
+
+// LegacyConfigKeys returns the v1 keys that v2 renamed, per configuration
+// struct name, mapped to their current names.
+func LegacyConfigKeys() map[string]map[string]string {
+	out := make(map[string]map[string]string, len(v2Renames))
+	for t, renames := range v2Renames {
+		out[t.Name()] = maps.Clone(renames)
+	}
+
+	return out
+}
+
+// MigrateConfig rewrites a v1 document to the current schema, keeping comments
+// and ${...} references. Included files without a schema key are migrated as
+// fragments. It reports false, and returns data unchanged, when there was
+// nothing to rewrite.
+func MigrateConfig(data []byte) ([]byte, bool, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, false, fmt.Errorf("cannot parse configuration file: %w", err)
+	}
+
+	if doc.Kind != yaml.DocumentNode || doc.Content[0].Kind != yaml.MappingNode {
+		return data, false, nil
+	}
+
+	var (
+		migrated bool
+		err      error
+	)
+
+	if mappingValue(doc.Content[0], "schema") == nil {
+		migrated = renameKeys(doc.Content[0], reflect.TypeFor[Config](), nil) > 0
+	} else {
+		migrated, err = migrateNode(&doc)
+	}
+
+	if err != nil || !migrated {
+		return data, false, err
+	}
+
+	var buf bytes.Buffer
+
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+
+	if err = enc.Encode(&doc); err != nil {
+		return nil, false, fmt.Errorf("encode configuration: %w", err)
+	}
+
+	return buf.Bytes(), true, nil
+}
+
+// migrateNode upgrades a v1 document in place. Current documents are checked
+// for keys that only exist in v1, which would otherwise be ignored silently.
+func migrateNode(doc *yaml.Node) (bool, error) {
+	if doc.Kind != yaml.DocumentNode || doc.Content[0].Kind != yaml.MappingNode {
+		return false, nil
+	}
+
+	root := doc.Content[0]
+
+	schema := mappingValue(root, "schema")
+	if schema == nil {
+		return false, nil
+	}
+
+	switch schema.Value {
+	case schemaV1:
+		renameKeys(root, reflect.TypeFor[Config](), nil)
+		schema.Value = schemaCurrent
+
+		return true, nil
+	case schemaCurrent:
+		var stale []string
+
+		renameKeys(root, reflect.TypeFor[Config](), &stale)
+
+		if len(stale) > 0 {
+			return false, fmt.Errorf("v1 keys in a %s config: %s; run kono config migrate", schemaCurrent, strings.Join(stale, ", "))
+		}
+
+		return false, nil
+	default:
+		return false, nil
+	}
+}
+
+// renameKeys walks node along the struct type t, applies v2Renames and returns
+// how many keys matched. With stale set, keys are reported instead of renamed.
+func renameKeys(node *yaml.Node, t reflect.Type, stale *[]string) int {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	n := 0
+
+	switch {
+	case node.Kind == yaml.SequenceNode && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array):
+		for _, item := range node.Content {
+			n += renameKeys(item, t.Elem(), stale)
+		}
+
+		return n
+	case node.Kind != yaml.MappingNode || t.Kind() != reflect.Struct:
+		return 0
+	}
+
+	renames := v2Renames[t]
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+
+		if to, ok := renames[key.Value]; ok {
+			n++
+
+			if stale != nil {
+				*stale = append(*stale, fmt.Sprintf("%s (now %s)", key.Value, to))
+			} else {
+				key.Value = to
+			}
+		}
+
+		if field, ok := fieldByYAMLKey(t, key.Value); ok {
+			n += renameKeys(node.Content[i+1], field.Type, stale)
+		}
+	}
+
+	return n
+}
+
+func fieldByYAMLKey(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if name == key {
+			return t.Field(i), true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package kono
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MigrateConfig", func() {
+	const v1 = `schema: v1
+gateway:
+  routing:
+    groups:
+      - prefix: /api
+        # Dropped before proxying.
+        policy:
+          header_blacklist: [X-Internal]
+    flows:
+      - path: /users
+        upstreams:
+          - name: users
+            hosts: ${USERS_HOST}
+            policy: {header_blacklist: [X-Debug]}
+        middlewares:
+          - name: custom
+            config: {policy: {header_blacklist: untouched}}
+`
+
+	It("renames v1 keys along the config structs only", func() {
+		out, migrated, err := MigrateConfig([]byte(v1))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(migrated).To(BeTrue())
+
+		Expect(string(out)).To(HavePrefix("schema: v2\n"))
+		Expect(string(out)).To(ContainSubstring("# Dropped before proxying.\n        policy:\n          header_deny: [X-Internal]"))
+		Expect(string(out)).To(ContainSubstring("policy: {header_deny: [X-Debug]}"))
+		Expect(string(out)).To(ContainSubstring("hosts: ${USERS_HOST}"))
+		Expect(string(out)).To(ContainSubstring("config: {policy: {header_blacklist: untouched}}"))
+	})
+
+	It("migrates fragments without a schema key and leaves current files alone", func() {
+		out, migrated, err := MigrateConfig([]byte("gateway: {routing: {flows: [{upstreams: [{policy: {header_blacklist: [A]}}]}]}}\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(migrated).To(BeTrue())
+		Expect(string(out)).To(ContainSubstring("header_deny: [A]"))
+
+		_, migrated, err = MigrateConfig([]byte("schema: v2\ndebug: true\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(migrated).To(BeFalse())
+	})
+
+	It("rejects v1 keys in a v2 config", func() {
+		_, err := ParseConfig([]byte("schema: v2\ngateway: {routing: {flows: [{upstreams: [{policy: {header_blacklist: [A]}}]}]}}\n"))
+		Expect(err).To(MatchError(ContainSubstring("header_blacklist (now header_deny)")))
+	})
+})
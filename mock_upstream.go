@@ -0,0 +1,155 @@
+package kono
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/jmespath/go-jmespath"
+)
+
+const upstreamTypeMock = "mock"
+
+// cannedUpstream implements upstream type mock: every call is answered with a
+// canned response. Latency, errors and the select expression apply as they would
+// for a real backend, so flows behave the same once the mock is swapped for hosts.
+type cannedUpstream struct {
+	upstreamName string
+	timeout      time.Duration
+	selector     *jmespath.JMESPath
+
+	status  int
+	headers http.Header
+	body    []byte
+
+	latency     time.Duration
+	jitter      time.Duration
+	errorRate   float64
+	errorStatus int
+}
+
+func buildMockUpstream(cfg UpstreamConfig, ucfg upstreamConfig) (*cannedUpstream, error) {
+	if cfg.Mock == nil {
+		return nil, errors.New("mock upstream requires a mock config")
+	}
+
+	mock := cfg.Mock
+
+	body, err := mockBody(*mock)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(http.Header, len(mock.Headers)+1)
+	for k, v := range mock.Headers {
+		headers.Set(k, v)
+	}
+
+	if headers.Get("Content-Type") == "" && len(body) > 0 {
+		headers.Set("Content-Type", "application/json")
+	}
+
+	return &cannedUpstream{
+		upstreamName: ucfg.name,
+		timeout:      cfg.Timeout,
+		selector:     ucfg.selector,
+		status:       mock.Status,
+		headers:      headers,
+		body:         body,
+		latency:      mock.Latency,
+		jitter:       mock.Jitter,
+		errorRate:    mock.ErrorRate,
+		errorStatus:  mock.ErrorStatus,
+	}, nil
+}
+
+// mockBody returns the configured body: a string as is, any other value as JSON.
+func mockBody(cfg MockUpstreamConfig) ([]byte, error) {
+	if cfg.BodyFile != "" {
+		body, err := os.ReadFile(cfg.BodyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read mock body: %w", err)
+		}
+
+		return body, nil
+	}
+
+	switch body := cfg.Body.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []byte(body), nil
+	default:
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("encode mock body: %w", err)
+		}
+
+		return encoded, nil
+	}
+}
+
+func (u *cannedUpstream) name() string { return u.upstreamName }
+
+func (u *cannedUpstream) call(ctx context.Context, _ *http.Request, _ []byte) *upstreamResponse {
+	if u.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, u.timeout)
+		defer cancel()
+	}
+
+	if delay := u.delay(); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			kind := upstreamCanceled
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				kind = upstreamTimeout
+			}
+
+			return &upstreamResponse{err: &upstreamError{kind: kind, err: ctx.Err()}}
+		}
+	}
+
+	if u.errorRate > 0 && rand.Float64() < u.errorRate { //nolint:gosec // Error injection needs no crypto randomness.
+		return &upstreamResponse{
+			status: u.errorStatus,
+			err:    &upstreamError{kind: upstreamBadStatus, err: fmt.Errorf("mock upstream returned %d", u.errorStatus)},
+		}
+	}
+
+	if u.status >= http.StatusInternalServerError {
+		return &upstreamResponse{
+			status: u.status,
+			err:    &upstreamError{kind: upstreamBadStatus, err: fmt.Errorf("mock upstream returned %d", u.status)},
+		}
+	}
+
+	body, uerr := projectBody(u.selector, u.body)
+	if uerr != nil {
+		return &upstreamResponse{status: u.status, err: uerr}
+	}
+
+	return &upstreamResponse{
+		status:  u.status,
+		headers: u.headers.Clone(),
+		body:    body,
+	}
+}
+
+func (u *cannedUpstream) delay() time.Duration {
+	if u.jitter <= 0 {
+		return u.latency
+	}
+
+	return u.latency + rand.N(u.jitter) //nolint:gosec // Jitter needs no crypto randomness.
+}
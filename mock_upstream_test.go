@@ -0,0 +1,109 @@
+package kono
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("cannedUpstream", func() {
+	build := func(cfg UpstreamConfig) upstream {
+		cfg.Name, cfg.Type = "users", upstreamTypeMock
+		if cfg.Timeout == 0 {
+			cfg.Timeout = time.Second
+		}
+
+		if cfg.Mock.Status == 0 {
+			cfg.Mock.Status = http.StatusOK
+		}
+
+		u, err := buildUpstream(cfg, nil, nil, zap.NewNop())
+		Expect(err).NotTo(HaveOccurred())
+
+		return u
+	}
+
+	call := func(u upstream) *upstreamResponse {
+		return u.call(context.Background(), httptest.NewRequest(http.MethodGet, "/users", nil), nil)
+	}
+
+	It("answers with the configured response", func() {
+		resp := call(build(UpstreamConfig{Mock: &MockUpstreamConfig{
+			Status:  http.StatusCreated,
+			Headers: map[string]string{"X-Mock": "yes"},
+			Body:    map[string]any{"id": 1, "name": "ann"},
+		}}))
+
+		Expect(resp.err).To(BeNil())
+		Expect(resp.status).To(Equal(http.StatusCreated))
+		Expect(resp.headers.Get("X-Mock")).To(Equal("yes"))
+		Expect(resp.headers.Get("Content-Type")).To(Equal("application/json"))
+		Expect(resp.body).To(MatchJSON(`{"id":1,"name":"ann"}`))
+	})
+
+	It("sends strings and files verbatim and applies select", func() {
+		resp := call(build(UpstreamConfig{Mock: &MockUpstreamConfig{Body: `{"data":{"id":7}}`}, Select: "data.id"}))
+		Expect(string(resp.body)).To(Equal("7"))
+
+		path := filepath.Join(GinkgoT().TempDir(), "users.json")
+		Expect(os.WriteFile(path, []byte(`[1,2]`), 0o600)).To(Succeed())
+
+		resp = call(build(UpstreamConfig{Mock: &MockUpstreamConfig{BodyFile: path}}))
+		Expect(string(resp.body)).To(Equal(`[1,2]`))
+	})
+
+	It("injects latency bounded by the timeout", func() {
+		u := build(UpstreamConfig{Timeout: 20 * time.Millisecond, Mock: &MockUpstreamConfig{Latency: time.Second}})
+
+		resp := call(u)
+		Expect(resp.err).NotTo(BeNil())
+		Expect(resp.err.kind).To(Equal(upstreamTimeout))
+
+		start := time.Now()
+		resp = call(build(UpstreamConfig{Mock: &MockUpstreamConfig{Latency: 10 * time.Millisecond}}))
+		Expect(resp.err).To(BeNil())
+		Expect(time.Since(start)).To(BeNumerically(">=", 10*time.Millisecond))
+	})
+
+	It("injects errors", func() {
+		resp := call(build(UpstreamConfig{Mock: &MockUpstreamConfig{ErrorRate: 1, ErrorStatus: http.StatusBadGateway}}))
+		Expect(resp.err).NotTo(BeNil())
+		Expect(resp.err.kind).To(Equal(upstreamBadStatus))
+		Expect(resp.status).To(Equal(http.StatusBadGateway))
+
+		resp = call(build(UpstreamConfig{Mock: &MockUpstreamConfig{Status: http.StatusInternalServerError}}))
+		Expect(resp.err).NotTo(BeNil())
+		Expect(resp.status).To(Equal(http.StatusInternalServerError))
+	})
+
+	It("needs no hosts in the configuration", func() {
+		cfg, err := ParseConfig([]byte(`
+schema: v1
+gateway:
+  server: {port: 8080}
+  routing:
+    flows:
+      - path: /users
+        method: GET
+        aggregation: {strategy: array}
+        upstreams:
+          - name: users
+            type: mock
+            mock:
+              body: {id: 1}
+`))
+		Expect(err).NotTo(HaveOccurred())
+
+		mock := cfg.Gateway.Routing.Flows[0].Upstreams[0].Mock
+		Expect(mock.Status).To(Equal(http.StatusOK))
+		Expect(mock.ErrorStatus).To(Equal(http.StatusServiceUnavailable))
+		Expect(mock.Body).To(Equal(map[string]any{"id": 1}))
+	})
+})
@@ -0,0 +1,441 @@
+package kono
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"mime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+type responseFormat uint8
+
+const (
+	formatJSON responseFormat = iota
+	formatXML
+	formatMsgpack
+	formatProtobuf
+)
+
+func (f responseFormat) String() string {
+	switch f {
+	case formatJSON:
+		return "json"
+	case formatXML:
+		return "xml"
+	case formatMsgpack:
+		return "msgpack"
+	case formatProtobuf:
+		return "protobuf"
+	default:
+		return "unknown"
+	}
+}
+
+func (f responseFormat) contentType() string {
+	switch f {
+	case formatXML:
+		return "application/xml; charset=utf-8"
+	case formatMsgpack:
+		return "application/msgpack"
+	case formatProtobuf:
+		return "application/x-protobuf"
+	case formatJSON:
+		return "application/json; charset=utf-8"
+	default:
+		return "application/json; charset=utf-8"
+	}
+}
+
+// mediaFormats maps the media types understood in Accept headers to response formats.
+var mediaFormats = map[string]responseFormat{
+	"application/json":        formatJSON,
+	"application/xml":         formatXML,
+	"text/xml":                formatXML,
+	"application/msgpack":     formatMsgpack,
+	"application/x-msgpack":   formatMsgpack,
+	"application/vnd.msgpack": formatMsgpack,
+
+	"application/x-protobuf":          formatProtobuf,
+	"application/protobuf":            formatProtobuf,
+	"application/vnd.google.protobuf": formatProtobuf,
+}
+
+type negotiation struct {
+	formats []responseFormat
+	def     responseFormat
+
+	// message describes the protobuf response; set only when formatProtobuf is allowed.
+	message protoreflect.MessageDescriptor
+}
+
+func compileNegotiation(cfg NegotiationConfig) (negotiation, error) {
+	neg := negotiation{formats: []responseFormat{formatJSON}, def: formatJSON}
+
+	if cfg.Default != "" {
+		def, err := compileResponseFormat(cfg.Default)
+		if err != nil {
+			return negotiation{}, err
+		}
+
+		neg.def = def
+	}
+
+	if len(cfg.Formats) == 0 {
+		if neg.def != formatJSON {
+			return negotiation{}, fmt.Errorf("default format %q requires it to be listed in formats", cfg.Default)
+		}
+
+		return neg, nil
+	}
+
+	neg.formats = neg.formats[:0]
+
+	for _, name := range cfg.Formats {
+		format, err := compileResponseFormat(name)
+		if err != nil {
+			return negotiation{}, err
+		}
+
+		if !slices.Contains(neg.formats, format) {
+			neg.formats = append(neg.formats, format)
+		}
+	}
+
+	if !slices.Contains(neg.formats, neg.def) {
+		return negotiation{}, fmt.Errorf("default format %q is not listed in formats", neg.def)
+	}
+
+	if slices.Contains(neg.formats, formatProtobuf) {
+		if cfg.Protobuf == nil {
+			return negotiation{}, errors.New("protobuf format requires a protobuf descriptor config")
+		}
+
+		md, err := loadProtoMessage(cfg.Protobuf.DescriptorSet, cfg.Protobuf.Message)
+		if err != nil {
+			return negotiation{}, fmt.Errorf("load protobuf message: %w", err)
+		}
+
+		neg.message = md
+	}
+
+	return neg, nil
+}
+
+func compileResponseFormat(s string) (responseFormat, error) {
+	switch s {
+	case "json":
+		return formatJSON, nil
+	case "xml":
+		return formatXML, nil
+	case "msgpack":
+		return formatMsgpack, nil
+	case "protobuf":
+		return formatProtobuf, nil
+	default:
+		return 0, fmt.Errorf("unknown response format: %q", s)
+	}
+}
+
+// negotiable reports whether the flow can answer in more than one format.
+func (n negotiation) negotiable() bool {
+	return len(n.formats) > 1
+}
+
+// choose picks the allowed format with the highest quality in the Accept header.
+// Ties keep the order of the header; wildcards resolve to the default format.
+func (n negotiation) choose(accept string) responseFormat {
+	if accept == "" || !n.negotiable() {
+		return n.def
+	}
+
+	best, bestQ := n.def, 0.0
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		if q <= bestQ {
+			continue
+		}
+
+		format, ok := mediaFormats[mediaType]
+		switch {
+		case ok && slices.Contains(n.formats, format):
+		case mediaType == "*/*" || mediaType == "application/*":
+			format = n.def
+		default:
+			continue
+		}
+
+		best, bestQ = format, q
+	}
+
+	return best
+}
+
+// encode converts a JSON response body into the requested format.
+func (n negotiation) encode(body []byte, format responseFormat) ([]byte, error) {
+	if format == formatProtobuf {
+		return encodeProtobuf(body, n.message)
+	}
+
+	return encodeBody(body, format)
+}
+
+// encodeProtobuf fills a dynamic message of type md from the JSON body.
+func encodeProtobuf(body []byte, md protoreflect.MessageDescriptor) ([]byte, error) {
+	if md == nil {
+		return nil, errors.New("no protobuf message configured")
+	}
+
+	msg := dynamicpb.NewMessage(md)
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(body, msg); err != nil {
+		return nil, fmt.Errorf("map json body to %s: %w", md.FullName(), err)
+	}
+
+	return proto.Marshal(msg)
+}
+
+// encodeBody converts a JSON response body into a self-describing format.
+func encodeBody(body []byte, format responseFormat) ([]byte, error) {
+	if format == formatJSON {
+		return body, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("decode json body: %w", err)
+	}
+
+	switch format {
+	case formatXML:
+		return encodeXML(v)
+	case formatMsgpack:
+		return msgpack.Marshal(normalizeNumbers(v))
+	case formatJSON, formatProtobuf:
+		return nil, fmt.Errorf("format %q is not self-describing", format)
+	default:
+		return nil, fmt.Errorf("unsupported response format %q", format)
+	}
+}
+
+// normalizeNumbers replaces json.Number values with int64 or float64 so that
+// binary encoders keep them numeric.
+func normalizeNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+
+		f, _ := t.Float64()
+
+		return f
+	case map[string]interface{}:
+		for k, val := range t {
+			t[k] = normalizeNumbers(val)
+		}
+
+		return t
+	case []interface{}:
+		for i, val := range t {
+			t[i] = normalizeNumbers(val)
+		}
+
+		return t
+	default:
+		return v
+	}
+}
+
+// ── XML encoding ──────────────────────────────────────────────────────────────
+
+const (
+	xmlRootElement = "response"
+	xmlItemElement = "item"
+)
+
+// encodeXML renders a decoded JSON value as XML: objects become child elements
+// named after their keys (sorted), array elements become <item> elements and
+// null values become empty elements.
+func encodeXML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteString(xml.Header)
+
+	enc := xml.NewEncoder(&buf)
+	if err := writeXMLElement(enc, xmlRootElement, v); err != nil {
+		return nil, err
+	}
+
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeXMLElement(enc *xml.Encoder, name string, v interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: xmlName(name)}}
+
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if err := writeXMLElement(enc, k, t[k]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range t {
+			if err := writeXMLElement(enc, xmlItemElement, item); err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		if err := enc.EncodeToken(xml.CharData(fmt.Sprint(t))); err != nil {
+			return err
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+// xmlName turns a JSON key into a valid XML element name by replacing
+// disallowed characters with '_' and prefixing names that cannot start an element.
+func xmlName(key string) string {
+	if key == "" {
+		return "_"
+	}
+
+	var b strings.Builder
+
+	for i, r := range key {
+		valid := r == '_' || r == '-' || r == '.' ||
+			(r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+
+		if !valid {
+			r = '_'
+		}
+
+		if i == 0 && (r == '-' || r == '.' || (r >= '0' && r <= '9')) {
+			b.WriteByte('_')
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// ── XML decoding ──────────────────────────────────────────────────────────────
+
+// decodeXML converts an XML document into JSON. The root element is unwrapped;
+// elements holding only text become strings, other elements become objects whose
+// keys are child names ("@name" for attributes, "#text" for mixed-in text), and
+// repeated children become arrays. XML carries no types, so all leaves are strings.
+func decodeXML(body []byte) ([]byte, error) {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		if start, ok := tok.(xml.StartElement); ok {
+			v, err := decodeXMLElement(dec, start)
+			if err != nil {
+				return nil, err
+			}
+
+			return json.Marshal(v)
+		}
+	}
+}
+
+func decodeXMLElement(dec *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	var (
+		obj  = make(map[string]interface{})
+		text strings.Builder
+	)
+
+	for _, attr := range start.Attr {
+		obj["@"+attr.Name.Local] = attr.Value
+	}
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			child, err := decodeXMLElement(dec, t)
+			if err != nil {
+				return nil, err
+			}
+
+			name := t.Name.Local
+
+			switch existing := obj[name].(type) {
+			case nil:
+				obj[name] = child
+			case []interface{}:
+				obj[name] = append(existing, child)
+			default:
+				obj[name] = []interface{}{existing, child}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			content := strings.TrimSpace(text.String())
+
+			if len(obj) == 0 {
+				return content, nil
+			}
+
+			if content != "" {
+				obj["#text"] = content
+			}
+
+			return obj, nil
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package kono
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var _ = Describe("negotiation", func() {
+	Describe("compileNegotiation", func() {
+		It("serves only JSON by default", func() {
+			neg, err := compileNegotiation(NegotiationConfig{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(neg.negotiable()).To(BeFalse())
+			Expect(neg.choose("application/xml")).To(Equal(formatJSON))
+		})
+
+		It("rejects a default that is not allowed", func() {
+			_, err := compileNegotiation(NegotiationConfig{Formats: []string{"json", "xml"}, Default: "msgpack"})
+			Expect(err).To(MatchError(ContainSubstring("not listed in formats")))
+		})
+	})
+
+	DescribeTable("choose",
+		func(accept string, want responseFormat) {
+			neg, err := compileNegotiation(NegotiationConfig{Formats: []string{"json", "xml", "msgpack"}, Default: "json"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(neg.choose(accept)).To(Equal(want))
+		},
+		Entry("missing header uses default", "", formatJSON),
+		Entry("exact media type", "application/xml", formatXML),
+		Entry("alias media type", "application/x-msgpack", formatMsgpack),
+		Entry("highest quality wins", "application/json;q=0.5, text/xml;q=0.9", formatXML),
+		Entry("wildcard uses default", "*/*", formatJSON),
+		Entry("unknown type uses default", "text/html", formatJSON),
+	)
+
+	It("ignores formats outside the allowlist", func() {
+		neg, err := compileNegotiation(NegotiationConfig{Formats: []string{"json", "xml"}, Default: "xml"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(neg.choose("application/msgpack")).To(Equal(formatXML))
+	})
+
+	Describe("encodeBody", func() {
+		body := []byte(`{"data":{"id":7,"tags":["a","b"],"none":null},"meta":{"request_id":"r1"}}`)
+
+		It("renders XML with sorted elements and array items", func() {
+			out, err := encodeBody(body, formatXML)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(out)).To(HaveSuffix(
+				`<response><data><id>7</id><none></none><tags><item>a</item><item>b</item></tags></data>` +
+					`<meta><request_id>r1</request_id></meta></response>`,
+			))
+		})
+
+		It("renders MessagePack with numeric values", func() {
+			out, err := encodeBody(body, formatMsgpack)
+			Expect(err).NotTo(HaveOccurred())
+
+			var got map[string]interface{}
+			Expect(msgpack.Unmarshal(out, &got)).To(Succeed())
+			Expect(got["data"]).To(HaveKeyWithValue("id", BeNumerically("==", 7)))
+		})
+	})
+
+	Describe("protobuf", func() {
+		It("maps the JSON response onto the configured message", func() {
+			neg, err := compileNegotiation(NegotiationConfig{
+				Formats:  []string{"json", "protobuf"},
+				Default:  "json",
+				Protobuf: &ProtobufConfig{DescriptorSet: writeTestDescriptorSet(), Message: "test.v1.Response"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(neg.choose("application/x-protobuf")).To(Equal(formatProtobuf))
+
+			out, err := neg.encode([]byte(`{"data":{"id":7,"name":"ann","extra":true},"meta":{"request_id":"r1"}}`), formatProtobuf)
+			Expect(err).NotTo(HaveOccurred())
+
+			msg := dynamicpb.NewMessage(neg.message)
+			Expect(proto.Unmarshal(out, msg)).To(Succeed())
+
+			user := msg.Get(neg.message.Fields().ByName("data")).Message()
+			Expect(user.Get(user.Descriptor().Fields().ByName("id")).Int()).To(BeEquivalentTo(7))
+			Expect(user.Get(user.Descriptor().Fields().ByName("name")).String()).To(Equal("ann"))
+		})
+
+		It("requires a descriptor config", func() {
+			_, err := compileNegotiation(NegotiationConfig{Formats: []string{"protobuf"}, Default: "protobuf"})
+			Expect(err).To(MatchError(ContainSubstring("requires a protobuf descriptor config")))
+		})
+
+		It("fails on an unknown message", func() {
+			_, err := compileNegotiation(NegotiationConfig{
+				Formats:  []string{"protobuf"},
+				Default:  "protobuf",
+				Protobuf: &ProtobufConfig{DescriptorSet: writeTestDescriptorSet(), Message: "test.v1.Missing"},
+			})
+			Expect(err).To(MatchError(ContainSubstring(`find message "test.v1.Missing"`)))
+		})
+	})
+
+	DescribeTable("xmlName",
+		func(key, want string) {
+			Expect(xmlName(key)).To(Equal(want))
+		},
+		Entry("keeps valid names", "user_id", "user_id"),
+		Entry("prefixes leading digits", "1st", "_1st"),
+		Entry("replaces invalid characters", "a b/c", "a_b_c"),
+	)
+
+	Describe("decodeXML", func() {
+		It("unwraps the root and turns repeated elements into arrays", func() {
+			out, err := decodeXML([]byte(`<?xml version="1.0"?>
+				<user id="7">
+					<name>ann</name>
+					<role>admin</role>
+					<role>dev</role>
+					<address><city>Oslo</city></address>
+					<note lang="en">hi</note>
+					<empty/>
+				</user>`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out).To(MatchJSON(`{
+				"@id":"7",
+				"name":"ann",
+				"role":["admin","dev"],
+				"address":{"city":"Oslo"},
+				"note":{"@lang":"en","#text":"hi"},
+				"empty":""
+			}`))
+		})
+
+		It("fails on malformed documents", func() {
+			_, err := decodeXML([]byte(`<user><name>ann</user>`))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,97 @@
+package kono
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// outlierDetector ejects hosts of a multi-host upstream whose error rate or mean
+// latency over the last interval exceeds its thresholds. Unlike the circuit
+// breaker, which guards the upstream as a whole, it acts per host: an ejected host
+// is skipped by host selection until its ejection expires.
+type outlierDetector struct {
+	interval          time.Duration
+	minRequests       int64
+	maxErrorRate      float64
+	maxLatency        time.Duration
+	ejectionDuration  time.Duration
+	maxEjectedPercent int
+
+	mu          sync.Mutex
+	windowStart time.Time
+	stats       []hostStats
+}
+
+type hostStats struct {
+	requests int64
+	errors   int64
+	latency  time.Duration
+}
+
+func newOutlierDetector(cfg OutlierDetectionConfig, hosts int) *outlierDetector {
+	return &outlierDetector{
+		interval:          cfg.Interval,
+		minRequests:       int64(cfg.MinRequests),
+		maxErrorRate:      cfg.MaxErrorRate,
+		maxLatency:        cfg.MaxLatency,
+		ejectionDuration:  cfg.EjectionDuration,
+		maxEjectedPercent: cfg.MaxEjectedPercent,
+		windowStart:       time.Now(),
+		stats:             make([]hostStats, hosts),
+	}
+}
+
+// record adds the outcome of a call to host and reports whether the host should
+// now be ejected. ejected holds the UnixNano ejection deadlines of all hosts and is
+// used to keep at most maxEjectedPercent of them out of rotation.
+func (d *outlierDetector) record(host int64, failed bool, latency time.Duration, ejected []int64, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if now.Sub(d.windowStart) >= d.interval {
+		clear(d.stats)
+		d.windowStart = now
+	}
+
+	s := &d.stats[host]
+	s.requests++
+	s.latency += latency
+
+	if failed {
+		s.errors++
+	}
+
+	if s.requests < d.minRequests || !d.exceeds(s) {
+		return false
+	}
+
+	if !d.canEject(ejected, now) {
+		return false
+	}
+
+	// Start the host over so it is judged on fresh traffic once it returns.
+	*s = hostStats{}
+
+	return true
+}
+
+func (d *outlierDetector) exceeds(s *hostStats) bool {
+	if d.maxErrorRate > 0 && float64(s.errors)/float64(s.requests) > d.maxErrorRate {
+		return true
+	}
+
+	return d.maxLatency > 0 && s.latency/time.Duration(s.requests) > d.maxLatency
+}
+
+func (d *outlierDetector) canEject(ejected []int64, now time.Time) bool {
+	var count int
+
+	for i := range ejected {
+		if atomic.LoadInt64(&ejected[i]) > now.UnixNano() {
+			count++
+		}
+	}
+
+	return (count+1)*100 <= d.maxEjectedPercent*len(ejected)
+}
@@ -0,0 +1,142 @@
+package kono
+
+import (
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("outlierDetector", func() {
+	newDetector := func(cfg OutlierDetectionConfig) *outlierDetector {
+		cfg.Enabled = true
+		if cfg.Interval == 0 {
+			cfg.Interval = time.Minute
+		}
+		if cfg.EjectionDuration == 0 {
+			cfg.EjectionDuration = time.Minute
+		}
+		if cfg.MaxEjectedPercent == 0 {
+			cfg.MaxEjectedPercent = 50
+		}
+
+		return buildOutlierDetector(cfg, 4)
+	}
+
+	It("ejects a host once its error rate exceeds the threshold", func() {
+		d := newDetector(OutlierDetectionConfig{MinRequests: 4, MaxErrorRate: 0.5})
+		ejected := make([]int64, 4)
+		now := time.Now()
+
+		Expect(d.record(0, true, 0, ejected, now)).To(BeFalse())
+		Expect(d.record(0, true, 0, ejected, now)).To(BeFalse())
+		Expect(d.record(0, false, 0, ejected, now)).To(BeFalse())
+		Expect(d.record(0, true, 0, ejected, now)).To(BeTrue())
+	})
+
+	It("ejects a host whose mean latency exceeds the threshold", func() {
+		d := newDetector(OutlierDetectionConfig{MinRequests: 2, MaxLatency: 100 * time.Millisecond})
+		ejected := make([]int64, 4)
+		now := time.Now()
+
+		Expect(d.record(2, false, 50*time.Millisecond, ejected, now)).To(BeFalse())
+		Expect(d.record(2, false, 60*time.Millisecond, ejected, now)).To(BeFalse())
+		Expect(d.record(2, false, 300*time.Millisecond, ejected, now)).To(BeTrue())
+	})
+
+	It("forgets results from earlier intervals", func() {
+		d := newDetector(OutlierDetectionConfig{Interval: time.Second, MinRequests: 2, MaxErrorRate: 0.5})
+		ejected := make([]int64, 4)
+		now := time.Now()
+
+		Expect(d.record(1, true, 0, ejected, now)).To(BeFalse())
+		Expect(d.record(1, true, 0, ejected, now.Add(2*time.Second))).To(BeFalse())
+	})
+
+	It("never ejects more than the allowed share of hosts", func() {
+		d := newDetector(OutlierDetectionConfig{MinRequests: 1, MaxErrorRate: 0.1})
+		ejected := make([]int64, 4)
+		now := time.Now()
+
+		for host := range int64(4) {
+			if d.record(host, true, 0, ejected, now) {
+				ejected[host] = now.Add(time.Minute).UnixNano()
+			}
+		}
+
+		Expect(ejected[0]).NotTo(BeZero())
+		Expect(ejected[1]).NotTo(BeZero())
+		Expect(ejected[2]).To(BeZero())
+		Expect(ejected[3]).To(BeZero())
+	})
+
+	It("is disabled for single-host upstreams", func() {
+		Expect(buildOutlierDetector(OutlierDetectionConfig{Enabled: true}, 1)).To(BeNil())
+	})
+
+	It("takes an ejected host out of rotation", func() {
+		hosts := []string{"a", "b", "c", "d"}
+		up := &httpUpstream{
+			cfg:      upstreamConfig{hosts: hosts, lbMode: lbModeRoundRobin},
+			state:    buildUpstreamState(hosts),
+			outliers: newDetector(OutlierDetectionConfig{MinRequests: 2, MaxErrorRate: 0.5}),
+			metrics:  testMetrics,
+			log:      zap.NewNop(),
+		}
+
+		for range 2 {
+			up.recordHostResult(up.hostSet(), 0, &upstreamResponse{status: http.StatusBadGateway}, 0, zap.NewNop())
+		}
+
+		Expect(up.selectHost(up.hostSet(), zap.NewNop())).To(Equal(int64(1)))
+	})
+
+	It("keeps an ejected host out while earlier requests to it finish", func() {
+		hosts := []string{"a", "b", "c", "d"}
+		up := &httpUpstream{
+			cfg:      upstreamConfig{hosts: hosts, lbMode: lbModeRoundRobin, hostCooldown: time.Second},
+			state:    buildUpstreamState(hosts),
+			outliers: newDetector(OutlierDetectionConfig{MinRequests: 2, MaxLatency: 100 * time.Millisecond}),
+			metrics:  testMetrics,
+			log:      zap.NewNop(),
+		}
+
+		for range 2 {
+			up.recordHostResult(up.hostSet(), 0, &upstreamResponse{status: http.StatusOK}, time.Second, zap.NewNop())
+		}
+
+		ejectedUntil := up.state.ejectedUntil[0]
+		Expect(ejectedUntil).To(BeNumerically(">", time.Now().Add(30*time.Second).UnixNano()))
+
+		// A slow request still in flight when the host was ejected succeeds.
+		up.recordHostResult(up.hostSet(), 0, &upstreamResponse{status: http.StatusOK}, 0, zap.NewNop())
+		up.recordHostResult(up.hostSet(), 0, &upstreamResponse{status: http.StatusBadGateway}, 0, zap.NewNop())
+		up.recordHostResult(up.hostSet(), 0, &upstreamResponse{status: http.StatusOK}, 0, zap.NewNop())
+
+		Expect(up.state.ejectedUntil[0]).To(Equal(ejectedUntil))
+		Expect(up.selectHost(up.hostSet(), zap.NewNop())).To(Equal(int64(1)))
+	})
+
+	It("ignores canceled attempts", func() {
+		hosts := []string{"a", "b", "c", "d"}
+		up := &httpUpstream{
+			cfg:      upstreamConfig{hosts: hosts, lbMode: lbModeRoundRobin, hostCooldown: time.Minute},
+			state:    buildUpstreamState(hosts),
+			outliers: newDetector(OutlierDetectionConfig{MinRequests: 2, MaxErrorRate: 0.5}),
+			metrics:  testMetrics,
+			log:      zap.NewNop(),
+		}
+
+		up.recordHostResult(up.hostSet(), 0, &upstreamResponse{status: http.StatusBadGateway}, 0, zap.NewNop())
+		Expect(up.state.unhealthyUntil[0]).NotTo(BeZero())
+
+		for range 4 {
+			up.recordHostResult(up.hostSet(), 0, &upstreamResponse{err: &upstreamError{kind: upstreamCanceled}}, 0, zap.NewNop())
+		}
+
+		Expect(up.state.unhealthyUntil[0]).NotTo(BeZero())
+		Expect(up.outliers.stats[0].requests).To(Equal(int64(1)))
+	})
+})
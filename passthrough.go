@@ -2,9 +2,12 @@ package kono
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"sync"
 	"time"
 
 	"go.opentelemetry.io/otel"
@@ -51,6 +54,92 @@ func (tw *trackingWriter) Flush() {
 	}
 }
 
+// latencyWriter delays flushes by up to interval so that large bodies are sent in
+// bigger chunks instead of being flushed after every upstream read.
+type latencyWriter struct {
+	dst      *trackingWriter
+	interval time.Duration
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	pending bool
+}
+
+func (lw *latencyWriter) Header() http.Header {
+	return lw.dst.Header()
+}
+
+func (lw *latencyWriter) WriteHeader(code int) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	lw.dst.WriteHeader(code)
+}
+
+func (lw *latencyWriter) Write(b []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	return lw.dst.Write(b)
+}
+
+// Flush schedules a flush of everything written so far unless one is already pending.
+// Event streams are flushed immediately so that events are never held back.
+func (lw *latencyWriter) Flush() {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	if isEventStream(lw.dst.Header()) {
+		lw.dst.Flush()
+		lw.pending = false
+
+		return
+	}
+
+	if lw.pending {
+		return
+	}
+
+	lw.pending = true
+
+	if lw.timer == nil {
+		lw.timer = time.AfterFunc(lw.interval, lw.delayedFlush)
+	} else {
+		lw.timer.Reset(lw.interval)
+	}
+}
+
+func (lw *latencyWriter) delayedFlush() {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	if !lw.pending {
+		return
+	}
+
+	lw.dst.Flush()
+	lw.pending = false
+}
+
+// stop cancels a pending delayed flush; the server flushes the rest when the handler returns.
+func (lw *latencyWriter) stop() {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	lw.pending = false
+
+	if lw.timer != nil {
+		lw.timer.Stop()
+	}
+}
+
+// isEventStream reports whether the response headers announce Server-Sent Events.
+func isEventStream(h http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+
+	return err == nil && mediaType == "text/event-stream"
+}
+
 // handlePassthrough streams a request directly to the single upstream without buffering,
 // enabling SSE and chunked transfer. Request plugins still run.
 func (r *Router) handlePassthrough(w http.ResponseWriter, req *http.Request, f *flow, log *zap.Logger) {
@@ -102,13 +191,36 @@ func (r *Router) handlePassthrough(w http.ResponseWriter, req *http.Request, f *
 
 	tw := &trackingWriter{ResponseWriter: w}
 
-	err := proxy.proxy(ctx, tw, kctx.Request())
+	var dst http.ResponseWriter = tw
+
+	if f.flushInterval > 0 {
+		lw := &latencyWriter{dst: tw, interval: f.flushInterval}
+		defer lw.stop()
+
+		dst = lw
+	}
+
+	start := time.Now()
+	err := proxy.proxy(ctx, dst, kctx.Request())
+
+	if rec := accessRecordFromContext(ctx); rec != nil {
+		var uerr *upstreamError
+		if err != nil {
+			uerr = &upstreamError{kind: upstreamConnection, err: err}
+		}
+
+		rec.addUpstream(u.name(), tw.statusCode, time.Since(start), uerr)
+	}
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "passthrough upstream error")
 
 		if !tw.written {
-			WriteError(w, ClientErrUpstreamUnavailable, http.StatusBadGateway)
+			if errors.Is(err, errUpstreamOverloaded) {
+				WriteError(w, ClientErrUpstreamOverloaded, http.StatusServiceUnavailable)
+			} else {
+				WriteError(w, ClientErrUpstreamUnavailable, http.StatusBadGateway)
+			}
 		}
 
 		log.Error("passthrough upstream error", zap.Error(err))
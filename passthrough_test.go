@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -207,4 +209,39 @@ var _ = Describe("Passthrough", func() {
 			Expect(rec.Code).To(Equal(http.StatusCreated))
 		})
 	})
+
+	Context("with a flush interval", func() {
+		It("coalesces flushes of consecutive reads", func() {
+			rec := &flushCountingRecorder{ResponseRecorder: httptest.NewRecorder()}
+			lw := &latencyWriter{dst: &trackingWriter{ResponseWriter: rec}, interval: 50 * time.Millisecond}
+			defer lw.stop()
+
+			Expect(streamCopy(lw, strings.NewReader(strings.Repeat("x", 5*streamBuffer)))).To(Succeed())
+			Expect(rec.Body.Len()).To(Equal(5 * streamBuffer))
+
+			Eventually(rec.count.Load).Should(BeEquivalentTo(1))
+			Consistently(rec.count.Load, 100*time.Millisecond).Should(BeEquivalentTo(1))
+		})
+
+		It("flushes event streams immediately", func() {
+			rec := &flushCountingRecorder{ResponseRecorder: httptest.NewRecorder()}
+			lw := &latencyWriter{dst: &trackingWriter{ResponseWriter: rec}, interval: time.Hour}
+			defer lw.stop()
+
+			lw.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+
+			Expect(streamCopy(lw, strings.NewReader("data: a\n\n"))).To(Succeed())
+			Expect(rec.count.Load()).To(BeEquivalentTo(1))
+		})
+	})
 })
+
+type flushCountingRecorder struct {
+	*httptest.ResponseRecorder
+	count atomic.Int32
+}
+
+func (r *flushCountingRecorder) Flush() {
+	r.count.Add(1)
+	r.ResponseRecorder.Flush()
+}
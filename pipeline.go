@@ -0,0 +1,103 @@
+package kono
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// upstreamRefPrefix starts a template that references the response of an earlier
+// upstream of a sequential flow: {upstream.<name>.<dotted.json.path>}.
+const upstreamRefPrefix = "upstream."
+
+// pipelineResults holds the bodies of the upstreams that already succeeded in a
+// sequential flow, keyed by upstream name.
+type pipelineResults map[string][]byte
+
+// expandUpstreamRefs substitutes {upstream.<name>.<path>} templates with values taken
+// from earlier responses. Unlike path params, an unresolved reference is an error:
+// calling the upstream with a half-built URL would only hide the real failure.
+func expandUpstreamRefs(path string, results pipelineResults) (string, error) {
+	var expandErr error
+
+	expanded := pathParamRegexp.ReplaceAllStringFunc(path, func(match string) string {
+		ref, ok := strings.CutPrefix(match[1:len(match)-1], upstreamRefPrefix)
+		if !ok || expandErr != nil {
+			return match
+		}
+
+		value, err := results.lookup(ref)
+		if err != nil {
+			expandErr = fmt.Errorf("cannot resolve %s: %w", match, err)
+			return match
+		}
+
+		return url.PathEscape(value)
+	})
+
+	return expanded, expandErr
+}
+
+// lookup resolves "<name>.<dotted.path>" against the stored JSON bodies.
+// Array elements are addressed by their index, e.g. "users.items.0.id".
+func (p pipelineResults) lookup(ref string) (string, error) {
+	name, path, _ := strings.Cut(ref, ".")
+
+	body, ok := p[name]
+	if !ok {
+		return "", fmt.Errorf("no successful response from upstream %q", name)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return "", fmt.Errorf("upstream %q body is not JSON: %w", name, err)
+	}
+
+	if path != "" {
+		for _, key := range strings.Split(path, ".") {
+			next, found := descend(value, key)
+			if !found {
+				return "", fmt.Errorf("field %q not found in upstream %q response", path, name)
+			}
+
+			value = next
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+
+		return string(b), nil
+	}
+}
+
+func descend(value interface{}, key string) (interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		child, ok := v[key]
+		return child, ok
+	case []interface{}:
+		idx, err := strconv.Atoi(key)
+		if err != nil || idx < 0 || idx >= len(v) {
+			return nil, false
+		}
+
+		return v[idx], true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,33 @@
+package kono
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("pipeline", func() {
+	results := pipelineResults{
+		"users": []byte(`{"id":42,"name":"Ann Lee","items":[{"sku":"a-1"}],"active":true}`),
+	}
+
+	DescribeTable("expandUpstreamRefs",
+		func(path, expected string) {
+			Expect(expandUpstreamRefs(path, results)).To(Equal(expected))
+		},
+		Entry("number", "/orders/{upstream.users.id}", "/orders/42"),
+		Entry("escaped string", "/search/{upstream.users.name}", "/search/Ann%20Lee"),
+		Entry("array index", "/sku/{upstream.users.items.0.sku}", "/sku/a-1"),
+		Entry("boolean", "/flag/{upstream.users.active}", "/flag/true"),
+		Entry("path params untouched", "/users/{id}", "/users/{id}"),
+	)
+
+	It("fails on a missing field", func() {
+		_, err := expandUpstreamRefs("/x/{upstream.users.missing}", results)
+		Expect(err).To(MatchError(ContainSubstring(`field "missing" not found`)))
+	})
+
+	It("fails on an upstream without a successful response", func() {
+		_, err := expandUpstreamRefs("/x/{upstream.orders.id}", results)
+		Expect(err).To(MatchError(ContainSubstring("no successful response")))
+	})
+})
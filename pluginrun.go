@@ -0,0 +1,232 @@
+package kono
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/kono/sdk"
+)
+
+const onErrorContinue = "continue"
+
+// Plugin failure reasons, as reported in logs and kono.plugin.errors.total.
+const (
+	pluginFailureError   = "error"
+	pluginFailureTimeout = "timeout"
+	pluginFailurePanic   = "panic"
+)
+
+// errPluginTimeout is returned for an Execute call that overran its timeout.
+var errPluginTimeout = errors.New("plugin execution timed out")
+
+// pluginPanicError is returned for an Execute call that panicked.
+type pluginPanicError struct {
+	value any
+	stack []byte
+}
+
+func (e *pluginPanicError) Error() string {
+	return fmt.Sprintf("plugin panicked: %v", e.value)
+}
+
+// policyPlugin carries the per-plugin execution settings of PluginConfig.
+type policyPlugin struct {
+	sdk.Plugin
+
+	continueOnError bool
+	timeout         time.Duration // zero lets Execute run unbounded.
+}
+
+func withPluginPolicy(p sdk.Plugin, cfg PluginConfig) sdk.Plugin {
+	if cfg.OnError != onErrorContinue && cfg.Timeout <= 0 {
+		return p
+	}
+
+	return policyPlugin{Plugin: p, continueOnError: cfg.OnError == onErrorContinue, timeout: cfg.Timeout}
+}
+
+func (p policyPlugin) Shutdown(ctx context.Context) error {
+	return closeComponent(ctx, p.Plugin)
+}
+
+// runPlugin executes p, turning a panic into a pluginPanicError. With a timeout
+// Execute runs on a copy of the request and response headers; the copy replaces
+// kctx when Execute returns in time. An overrunning Execute is abandoned: its
+// request context is canceled and its later changes are never applied. The copy
+// reads its own buffer of the request body, so an abandoned Execute cannot
+// consume the body the flow forwards.
+func runPlugin(p sdk.Plugin, kctx sdk.Context) error {
+	pp, ok := p.(policyPlugin)
+	if !ok || pp.timeout <= 0 {
+		return executeRecovered(p, kctx)
+	}
+
+	req := kctx.Request()
+	parent := req.Context()
+
+	ctx, cancel := context.WithTimeout(parent, pp.timeout)
+	defer cancel()
+
+	clone := req.Clone(ctx)
+
+	if pp.Type() == sdk.PluginTypeRequest || pp.Type() == sdk.PluginTypeUpstream {
+		if err := isolateBody(req, clone); err != nil {
+			return err
+		}
+	}
+
+	isolated := &konoContext{req: clone, resp: cloneResponseHeader(kctx.Response())}
+
+	kc, _ := kctx.(*konoContext)
+	if kc != nil {
+		isolated.upstream, isolated.upstreams = kc.upstream, kc.upstreams
+
+		if kc.aggregated != nil {
+			isolated.aggregated = &sdk.AggregatedResponse{
+				Data:   bytes.Clone(kc.aggregated.Data),
+				Header: kc.aggregated.Header.Clone(),
+			}
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- executeRecovered(pp.Plugin, isolated) }()
+
+	select {
+	case err := <-done:
+		kctx.SetRequest(isolated.req.WithContext(parent))
+		kctx.SetResponse(isolated.resp)
+
+		if kc != nil && kc.aggregated != nil {
+			*kc.aggregated = *isolated.aggregated
+		}
+
+		return err
+	case <-ctx.Done():
+		if parent.Err() != nil {
+			return parent.Err()
+		}
+
+		return fmt.Errorf("%w after %s", errPluginTimeout, pp.timeout)
+	}
+}
+
+// isolateBody buffers the body of req, up to maxBodySize+1 bytes, and gives req
+// and clone a reader each. The rest of a longer body stays with req; dispatch
+// refuses it anyway.
+func isolateBody(req, clone *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+
+	head, err := io.ReadAll(io.LimitReader(req.Body, maxBodySize+1))
+	if err != nil {
+		return fmt.Errorf("read request body: %w", err)
+	}
+
+	clone.Body = io.NopCloser(bytes.NewReader(head))
+
+	if len(head) <= maxBodySize {
+		_ = req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(head))
+
+		return nil
+	}
+
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), req.Body), req.Body}
+
+	return nil
+}
+
+func executeRecovered(p sdk.Plugin, kctx sdk.Context) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &pluginPanicError{value: v, stack: debug.Stack()}
+		}
+	}()
+
+	return p.Execute(kctx)
+}
+
+func cloneResponseHeader(resp *http.Response) *http.Response {
+	if resp == nil {
+		return nil
+	}
+
+	clone := *resp
+	clone.Header = resp.Header.Clone()
+
+	return &clone
+}
+
+func pluginFailureReason(err error) string {
+	var panicErr *pluginPanicError
+
+	switch {
+	case errors.Is(err, errPluginTimeout):
+		return pluginFailureTimeout
+	case errors.As(err, &panicErr):
+		return pluginFailurePanic
+	default:
+		return pluginFailureError
+	}
+}
+
+// upstreamHook runs the PluginTypeUpstream plugins on an outgoing upstream request.
+type upstreamHook func(req *http.Request) (*http.Request, error)
+
+// upstreamPlugins returns the hook running the flow's upstream plugins for the
+// named upstream, or nil when the flow has none. Plugins with on_error continue
+// are skipped when they fail; any other failure, an sdk.AbortError included,
+// fails the upstream call.
+func (d *defaultScatter) upstreamPlugins(f *flow, upstreamName string, log *zap.Logger) upstreamHook {
+	if !slices.ContainsFunc(f.plugins, func(p sdk.Plugin) bool { return p.Type() == sdk.PluginTypeUpstream }) {
+		return nil
+	}
+
+	return func(req *http.Request) (*http.Request, error) {
+		kctx := &konoContext{req: req, upstream: upstreamName}
+
+		for _, p := range f.plugins {
+			if p.Type() != sdk.PluginTypeUpstream {
+				continue
+			}
+
+			err := runPlugin(p, kctx)
+			if err == nil {
+				continue
+			}
+
+			reason := pluginFailureReason(err)
+			d.metrics.IncPluginErrorsTotal(p.Info().Name, sdk.PluginTypeUpstream.String(), reason)
+
+			if pp, ok := p.(policyPlugin); ok && pp.continueOnError {
+				log.Warn("plugin execution failed, continuing",
+					zap.String("type", sdk.PluginTypeUpstream.String()),
+					zap.String("name", p.Info().Name),
+					zap.String("upstream", upstreamName),
+					zap.String("reason", reason),
+					zap.Error(err),
+				)
+
+				continue
+			}
+
+			return nil, fmt.Errorf("upstream plugin %s: %w", p.Info().Name, err)
+		}
+
+		return kctx.req, nil
+	}
+}
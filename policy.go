@@ -13,6 +13,9 @@ type upstreamPolicy struct {
 
 	// On-failure behaviour
 	retry retryPolicy
+
+	// hedgeDelay is how long to wait for a response before hedging; zero disables it.
+	hedgeDelay time.Duration
 }
 
 // retryPolicy specifies retry behavior for an upstream, including max retries, which statuses trigger retries,
@@ -26,6 +29,21 @@ type retryPolicy struct {
 type lbMode string
 
 const (
-	lbModeRoundRobin lbMode = "round_robin"
-	lbModeLeastConns lbMode = "least_conns"
+	lbModeRoundRobin     lbMode = "round_robin"
+	lbModeLeastConns     lbMode = "least_conns"
+	lbModeConsistentHash lbMode = "consistent_hash"
 )
+
+// hashKeySource is where consistent_hash balancing reads the request key from.
+type hashKeySource string
+
+const (
+	hashKeyIP     hashKeySource = "ip"
+	hashKeyHeader hashKeySource = "header"
+	hashKeyCookie hashKeySource = "cookie"
+)
+
+type hashKey struct {
+	source hashKeySource
+	name   string
+}
@@ -0,0 +1,88 @@
+package kono
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// loadDescriptorFiles reads a serialized FileDescriptorSet, as produced by
+// `protoc --include_imports --descriptor_set_out`.
+func loadDescriptorFiles(path string) (*protoregistry.Files, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read descriptor set: %w", err)
+	}
+
+	var set descriptorpb.FileDescriptorSet
+	if err = proto.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("parse descriptor set: %w", err)
+	}
+
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("build descriptor registry: %w", err)
+	}
+
+	return files, nil
+}
+
+// loadProtoMessage finds the named message in a descriptor set.
+func loadProtoMessage(path, name string) (protoreflect.MessageDescriptor, error) {
+	files, err := loadDescriptorFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("find message %q: %w", name, err)
+	}
+
+	md, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a message", name)
+	}
+
+	return md, nil
+}
+
+// loadProtoMethod finds a method given as "pkg.Service/Method" in a descriptor set.
+func loadProtoMethod(path, fullMethod string) (protoreflect.MethodDescriptor, error) {
+	files, err := loadDescriptorFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	service, method, ok := strings.Cut(fullMethod, "/")
+	if !ok || service == "" || method == "" {
+		return nil, fmt.Errorf("method %q must have the form pkg.Service/Method", fullMethod)
+	}
+
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("find service %q: %w", service, err)
+	}
+
+	sd, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a service", service)
+	}
+
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return nil, fmt.Errorf("service %q has no method %q", service, method)
+	}
+
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, fmt.Errorf("method %q is streaming; only unary methods are supported", fullMethod)
+	}
+
+	return md, nil
+}
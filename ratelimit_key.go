@@ -0,0 +1,82 @@
+package kono
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/starwalkn/kono/sdk"
+)
+
+const (
+	rateLimitKeyIP     = "ip"
+	rateLimitKeyHeader = "header"
+	rateLimitKeyQuery  = "query"
+	rateLimitKeyClaim  = "claim"
+	rateLimitKeyGeo    = "geo"
+)
+
+// rateLimitKey derives the bucket a request is counted against.
+type rateLimitKey []RateLimitKeyConfig
+
+// afterMiddlewares reports whether the key reads the authenticated caller or its
+// location, which are only known once the flow middlewares have run.
+func (k rateLimitKey) afterMiddlewares() bool {
+	for _, part := range k {
+		if part.Source == rateLimitKeyClaim || part.Source == rateLimitKeyGeo {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (k rateLimitKey) resolve(req *http.Request, clientIP string) string {
+	if len(k) == 0 {
+		return clientIP
+	}
+
+	parts := make([]string, len(k))
+
+	for i, part := range k {
+		value := ""
+
+		switch part.Source {
+		case rateLimitKeyHeader:
+			value = req.Header.Get(part.Name)
+		case rateLimitKeyQuery:
+			value = req.URL.Query().Get(part.Name)
+		case rateLimitKeyClaim:
+			if claim, ok := sdk.ClaimsFromContext(req.Context())[part.Name]; ok && claim != nil {
+				value = fmt.Sprint(claim)
+			}
+		case rateLimitKeyGeo:
+			value = geoField(req, part.Name)
+		}
+
+		if value == "" {
+			parts[i] = rateLimitKeyIP + "=" + clientIP
+			continue
+		}
+
+		parts[i] = part.Source + ":" + part.Name + "=" + value
+	}
+
+	return strings.Join(parts, "|")
+}
+
+// geoField returns the named field of the location set by a geolocation middleware.
+func geoField(req *http.Request, name string) string {
+	geo, _ := sdk.GeoFromContext(req.Context())
+
+	switch name {
+	case "country":
+		return geo.Country
+	case "region":
+		return geo.Region
+	case "city":
+		return geo.City
+	default:
+		return ""
+	}
+}
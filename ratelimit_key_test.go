@@ -0,0 +1,87 @@
+package kono
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/starwalkn/kono/internal/ratelimit"
+	"github.com/starwalkn/kono/sdk"
+)
+
+var _ = Describe("rateLimitKey", func() {
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/orders?tenant=acme", nil)
+		req.Header.Set("X-Api-Key", "k-1")
+
+		ctx := sdk.WithClaims(req.Context(), map[string]any{"sub": "user-7"})
+
+		return req.WithContext(sdk.WithGeo(ctx, sdk.Geo{Country: "DE", Region: "BE"}))
+	}
+
+	DescribeTable("resolves the bucket of a request",
+		func(key rateLimitKey, want string) {
+			Expect(key.resolve(newRequest(), "10.0.0.1")).To(Equal(want))
+		},
+		Entry("client IP by default", rateLimitKey(nil), "10.0.0.1"),
+		Entry("header", rateLimitKey{{Source: "header", Name: "X-Api-Key"}}, "header:X-Api-Key=k-1"),
+		Entry("query", rateLimitKey{{Source: "query", Name: "tenant"}}, "query:tenant=acme"),
+		Entry("claim", rateLimitKey{{Source: "claim", Name: "sub"}}, "claim:sub=user-7"),
+		Entry("geo", rateLimitKey{{Source: "geo", Name: "country"}}, "geo:country=DE"),
+		Entry("missing value falls back to the client IP",
+			rateLimitKey{{Source: "header", Name: "X-Other"}}, "ip=10.0.0.1"),
+		Entry("composite",
+			rateLimitKey{{Source: "query", Name: "tenant"}, {Source: "claim", Name: "sub"}},
+			"query:tenant=acme|claim:sub=user-7"),
+	)
+
+	It("reports whether it reads values set by middlewares", func() {
+		Expect(rateLimitKey{{Source: "header", Name: "X-Api-Key"}}.afterMiddlewares()).To(BeFalse())
+		Expect(rateLimitKey{{Source: "ip"}, {Source: "claim", Name: "sub"}}.afterMiddlewares()).To(BeTrue())
+		Expect(rateLimitKey{{Source: "geo", Name: "region"}}.afterMiddlewares()).To(BeTrue())
+	})
+})
+
+// claimsMiddleware stands in for an authentication middleware.
+type claimsMiddleware struct{}
+
+func (m *claimsMiddleware) Init(_ map[string]interface{}) error { return nil }
+func (m *claimsMiddleware) Name() string                        { return "claims" }
+func (m *claimsMiddleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := map[string]any{"sub": r.Header.Get("X-Test-Sub")}
+		next.ServeHTTP(w, r.WithContext(sdk.WithClaims(r.Context(), claims)))
+	})
+}
+
+var _ = Describe("Router rate limiting by claim", func() {
+	It("counts each authenticated subject separately", func() {
+		r := newTestRouter([]flow{{
+			path:        "/test/limited",
+			method:      http.MethodGet,
+			middlewares: []sdk.Middleware{&claimsMiddleware{}},
+		}}, &mockScatter{results: []upstreamResponse{{status: http.StatusOK, body: []byte(`"OK"`)}}}, &defaultAggregator{})
+
+		rl, err := ratelimit.New(map[string]interface{}{"limit": 1, "window": "1m"})
+		Expect(err).NotTo(HaveOccurred())
+
+		r.rateLimiter = rl
+		r.rateLimitKey = rateLimitKey{{Source: "claim", Name: "sub"}}
+
+		serve := func(sub string) int {
+			req := httptest.NewRequest(http.MethodGet, "/test/limited", nil)
+			req.Header.Set("X-Test-Sub", sub)
+
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			return rec.Code
+		}
+
+		Expect(serve("alice")).To(Equal(http.StatusOK))
+		Expect(serve("alice")).To(Equal(http.StatusTooManyRequests))
+		Expect(serve("bob")).To(Equal(http.StatusOK))
+	})
+})
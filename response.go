@@ -25,9 +25,12 @@ func (err ClientError) String() string {
 
 const (
 	ClientErrRateLimitExceeded    ClientError = "RATE_LIMIT_EXCEEDED"
+	ClientErrQuotaExceeded        ClientError = "QUOTA_EXCEEDED"
 	ClientErrPayloadTooLarge      ClientError = "PAYLOAD_TOO_LARGE"
 	ClientErrUpstreamBodyTooLarge ClientError = "UPSTREAM_BODY_TOO_LARGE"
 	ClientErrUpstreamUnavailable  ClientError = "UPSTREAM_UNAVAILABLE"
+	ClientErrUpstreamOverloaded   ClientError = "UPSTREAM_OVERLOADED"
+	ClientErrOverloaded           ClientError = "OVERLOADED"
 	ClientErrUpstreamError        ClientError = "UPSTREAM_ERROR"
 	ClientErrUpstreamMalformed    ClientError = "UPSTREAM_MALFORMED"
 	ClientErrInternal             ClientError = "INTERNAL"
@@ -7,14 +7,17 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
 	"net"
 	"net/http"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -25,27 +28,16 @@ import (
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"golang.org/x/sync/semaphore"
 
+	"github.com/starwalkn/kono/internal/accesslog"
 	"github.com/starwalkn/kono/internal/metric"
+	"github.com/starwalkn/kono/internal/quota"
 	"github.com/starwalkn/kono/internal/ratelimit"
 	"github.com/starwalkn/kono/internal/tracing"
 	"github.com/starwalkn/kono/sdk"
 )
 
-var hopByHopHeaders = map[string]struct{}{
-	"Content-Length":      {},
-	"Transfer-Encoding":   {},
-	"Connection":          {},
-	"Trailer":             {},
-	"Date":                {},
-	"Server":              {},
-	"Keep-Alive":          {},
-	"Proxy-Authenticate":  {},
-	"Proxy-Authorization": {},
-	"TE":                  {},
-	"Upgrade":             {},
-}
-
 var fingerprintIgnoredHeaders = map[string]struct{}{
 	"User-Agent":        {},
 	"Cookie":            {},
@@ -63,32 +55,91 @@ var fingerprintIgnoredHeaders = map[string]struct{}{
 }
 
 type Router struct {
-	chiRouter  *chi.Mux
-	scatter    scatter
-	aggregator aggregator
-	flows      []flow
-
-	log         *zap.Logger
-	metrics     *metric.Metrics
-	rateLimiter *ratelimit.RateLimit
+	chiRouter   *chi.Mux
+	scatter     scatter
+	aggregator  aggregator
+	flows       []flow
+	regexRoutes []route
+
+	log          *zap.Logger
+	metrics      *metric.Metrics
+	rateLimiter  *ratelimit.RateLimit
+	rateLimitKey rateLimitKey
+	quota        *quota.Quota
+	quotaKey     rateLimitKey
+
+	// trustedProxies may supply the client address in forwarding headers.
+	trustedProxies []*net.IPNet
+
+	// accessLog writes a line per request; nil disables it. It is shared with the
+	// successor while accessLogConfig stays the same.
+	accessLog       *accesslog.Logger
+	accessLogConfig AccessLogConfig
+
+	// routing is the configuration the router was built from, for the admin API.
+	routing RoutingConfig
+
+	inFlight     *semaphore.Weighted // nil unless load_shedding.max_in_flight is set.
+	retryAfter   string
+	queueTimeout time.Duration
+	maxQueued    int64
+	queued       atomic.Int64
+
+	// successor is the router that replaced this one on reload. It may have
+	// taken over the quota, rate limiter and access log, which are then left
+	// running.
+	successor atomic.Pointer[Router]
+
+	// draining is set once shutdown starts; responses then ask clients to close
+	// their connection so they reconnect to another instance.
+	draining atomic.Bool
 }
 
 // ServeHTTP handles incoming HTTP requests through the full router pipeline:
-//  1. Rate limiting — rejects requests exceeding the configured limit.
-//  2. Flow matching — chi router finds the flow by method and path (404 if none).
-//  3. Middleware execution — per-flow middlewares wrap the handler.
-//  4. Request plugins — run before upstream scatter; may modify the request.
-//  5. Upstream scatter — fan-out to all configured upstreams.
-//  6. Response aggregation — merge/array/namespace strategies with bestEffort support.
-//  7. Response plugins — run after aggregation; may modify headers or body.
-//  8. Response writing — status, headers, and JSON body sent to the client.
+//  1. Load shedding — queues or rejects (503) requests beyond the in-flight cap.
+//  2. Rate limiting and quotas — reject requests exceeding the configured limits;
+//     keys on caller claims are checked after the flow middlewares instead.
+//  3. Flow matching — chi router finds the flow by method and path (404 if none).
+//  4. Middleware execution — per-flow middlewares wrap the handler.
+//  5. Request plugins — run before upstream scatter; may modify the request.
+//  6. Upstream scatter — fan-out to all configured upstreams.
+//  7. Response aggregation — merge/array/namespace strategies with bestEffort support.
+//  8. Response plugins — run after aggregation; may modify headers or body.
+//  9. Response writing — status, headers, and JSON body sent to the client.
 //
 // Status codes: 200 on full success, 206 on partial (bestEffort), 502/500 on failure.
 // Every response carries an X-Request-ID header and a JSON body with data/errors fields.
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if r.accessLog != nil {
+		start := time.Now()
+		rec := &accessRecord{}
+		aw := &accessWriter{ResponseWriter: w}
+
+		req = req.WithContext(withAccessRecord(req.Context(), rec))
+
+		var body *countingBody
+		if req.Body != nil && req.Body != http.NoBody {
+			body = &countingBody{ReadCloser: req.Body}
+			req.Body = body
+		}
+
+		defer r.logAccess(req, aw, body, rec, start)
+
+		w = aw
+	}
+
+	if !r.admitRequest(w, req) {
+		return
+	}
+	defer r.finishRequest()
+
 	r.metrics.IncRequestsInFlight()
 	defer r.metrics.DecRequestsInFlight()
 
+	if r.draining.Load() {
+		w.Header().Set("Connection", "close")
+	}
+
 	tracer := otel.Tracer(tracing.TracerName)
 
 	ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
@@ -101,27 +152,107 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	)
 	defer span.End()
 
-	clientIP := extractClientIP(req)
-	ctx = withClientIP(ctx, clientIP)
+	ctx = withClientIP(ctx, extractClientIP(req, r.trustedProxies))
 	req = req.WithContext(ctx)
 
-	if !r.allowRequest(w, clientIP) {
-		span.SetAttributes(attribute.Int("http.status_code", http.StatusTooManyRequests))
-		span.SetStatus(codes.Error, "rate limited")
+	if !r.rateLimitKey.afterMiddlewares() && !r.allowRequest(w, req) {
+		return
+	}
 
+	if !r.quotaKey.afterMiddlewares() && !r.allowQuota(w, req) {
 		return
 	}
 
 	r.chiRouter.ServeHTTP(w, req)
 }
 
+// Drain marks the router as draining for shutdown. It keeps serving requests.
+func (r *Router) Drain() {
+	if !r.draining.Swap(true) {
+		r.metrics.SetDraining()
+	}
+}
+
+// AbortRequests records n requests cut off by a forced shutdown.
+func (r *Router) AbortRequests(n int64) {
+	r.metrics.AddShutdownAbortedRequests(n)
+}
+
+// FlushQuota writes the quota counters to their state file, so a process taking
+// over loads the latest counts.
+func (r *Router) FlushQuota() error {
+	if r.quota == nil {
+		return nil
+	}
+
+	return r.quota.Flush()
+}
+
+// ReleaseQuota stops the router writing the quota state file, once a process
+// that took over owns it. Requests are still counted in memory.
+func (r *Router) ReleaseQuota() {
+	if r.quota != nil {
+		r.quota.Release()
+	}
+}
+
+// Close shuts the router down without a deadline. See Shutdown.
 func (r *Router) Close() error {
+	return r.Shutdown(context.Background())
+}
+
+// Shutdown releases the resources of the router: plugins and middlewares
+// implementing sdk.Shutdowner or sdk.Closer, caches and upstreams. Failures are
+// logged and do not stop the remaining components from being closed.
+func (r *Router) Shutdown(ctx context.Context) error {
+	next := r.successor.Load()
+
+	if r.quota != nil && (next == nil || next.quota != r.quota) {
+		if err := r.quota.Stop(); err != nil {
+			r.log.Error("quota state flush failed", zap.Error(err))
+		}
+	}
+
+	if r.rateLimiter != nil && (next == nil || next.rateLimiter != r.rateLimiter) {
+		_ = r.rateLimiter.Stop()
+	}
+
+	if r.accessLog != nil && (next == nil || next.accessLog != r.accessLog) {
+		if err := r.accessLog.Close(); err != nil {
+			r.log.Error("access log close failed", zap.Error(err))
+		}
+	}
+
 	for i := range r.flows {
+		for _, p := range r.flows[i].plugins {
+			if err := closeComponent(ctx, p); err != nil {
+				r.log.Error("plugin close failed",
+					zap.String("name", p.Info().Name),
+					zap.Error(err),
+				)
+			}
+		}
+
 		for _, mw := range r.flows[i].middlewares {
-			if c, ok := mw.(sdk.Closer); ok {
+			if err := closeComponent(ctx, mw); err != nil {
+				r.log.Error("middleware close failed",
+					zap.String("name", mw.Name()),
+					zap.Error(err),
+				)
+			}
+		}
+
+		if cache := r.flows[i].cache; cache != nil {
+			if err := cache.Close(); err != nil {
+				r.log.Error("cache close failed", zap.Error(err))
+			}
+		}
+
+		for _, up := range r.flows[i].upstreams {
+			if c, ok := up.(sdk.Closer); ok {
 				if err := c.Close(); err != nil {
-					r.log.Error("middleware close failed",
-						zap.String("name", mw.Name()),
+					r.log.Error("upstream close failed",
+						zap.String("name", up.name()),
 						zap.Error(err),
 					)
 				}
@@ -132,23 +263,226 @@ func (r *Router) Close() error {
 	return nil
 }
 
-func (r *Router) allowRequest(w http.ResponseWriter, clientIP string) bool {
+// closeComponent releases a plugin or middleware, preferring sdk.Shutdowner.
+func closeComponent(ctx context.Context, v any) error {
+	switch c := v.(type) {
+	case sdk.Shutdowner:
+		return c.Shutdown(ctx)
+	case sdk.Closer:
+		return c.Close()
+	default:
+		return nil
+	}
+}
+
+// admitRequest takes an in-flight slot, waiting up to the queue timeout for one
+// when the gateway is at capacity, and sheds the request before any work is spent
+// on it otherwise.
+func (r *Router) admitRequest(w http.ResponseWriter, req *http.Request) bool {
+	if r.inFlight == nil || r.inFlight.TryAcquire(1) {
+		return true
+	}
+
+	if r.waitForSlot(req.Context()) {
+		return true
+	}
+
+	r.rejectOverloaded(w)
+
+	return false
+}
+
+func (r *Router) rejectOverloaded(w http.ResponseWriter) {
+	r.metrics.IncFailedRequestsTotal(metric.FailReasonOverloaded)
+	w.Header().Set("Retry-After", r.retryAfter)
+	WriteError(w, ClientErrOverloaded, http.StatusServiceUnavailable)
+}
+
+// serveSubrequest serves a request the gateway makes on behalf of a client
+// request, such as a GraphQL field, through the same admission, rate limit and
+// quota checks as ServeHTTP. The client request already holds an in-flight
+// slot, so a subrequest never queues for one: it could wait on its own parent.
+func (r *Router) serveSubrequest(w http.ResponseWriter, req *http.Request) {
+	if r.inFlight != nil {
+		if !r.inFlight.TryAcquire(1) {
+			r.rejectOverloaded(w)
+			return
+		}
+		defer r.finishRequest()
+	}
+
+	if !r.rateLimitKey.afterMiddlewares() && !r.allowRequest(w, req) {
+		return
+	}
+
+	if !r.quotaKey.afterMiddlewares() && !r.allowQuota(w, req) {
+		return
+	}
+
+	r.chiRouter.ServeHTTP(w, req)
+}
+
+func (r *Router) waitForSlot(ctx context.Context) bool {
+	if r.queueTimeout <= 0 {
+		return false
+	}
+
+	defer r.queued.Add(-1)
+	if n := r.queued.Add(1); r.maxQueued > 0 && n > r.maxQueued {
+		return false
+	}
+
+	r.metrics.IncRequestsQueued()
+	defer r.metrics.DecRequestsQueued()
+
+	ctx, cancel := context.WithTimeout(ctx, r.queueTimeout)
+	defer cancel()
+
+	return r.inFlight.Acquire(ctx, 1) == nil
+}
+
+func (r *Router) finishRequest() {
+	if r.inFlight != nil {
+		r.inFlight.Release(1)
+	}
+}
+
+func (r *Router) allowRequest(w http.ResponseWriter, req *http.Request) bool {
 	if r.rateLimiter == nil {
 		return true
 	}
 
-	if r.rateLimiter.Allow(clientIP) {
+	if r.rateLimiter.Allow(r.rateLimitKey.resolve(req, clientIPFromContext(req.Context()))) {
 		return true
 	}
 
+	span := trace.SpanFromContext(req.Context())
+	span.SetAttributes(attribute.Int("http.status_code", http.StatusTooManyRequests))
+	span.SetStatus(codes.Error, "rate limited")
+
 	r.metrics.IncFailedRequestsTotal(metric.FailReasonTooManyRequests)
 	WriteError(w, ClientErrRateLimitExceeded, http.StatusTooManyRequests)
 
 	return false
 }
 
+// allowQuota counts the request against the consumer's quota and reports what is
+// left in the X-Quota-* headers.
+func (r *Router) allowQuota(w http.ResponseWriter, req *http.Request) bool {
+	if r.quota == nil {
+		return true
+	}
+
+	key := r.quotaKey.resolve(req, clientIPFromContext(req.Context()))
+	remaining, reset, ok := r.quota.Take(key, time.Now())
+
+	w.Header().Set("X-Quota-Limit", strconv.FormatInt(r.quota.Limit(), 10))
+	w.Header().Set("X-Quota-Remaining", strconv.FormatInt(remaining, 10))
+	w.Header().Set("X-Quota-Reset", strconv.FormatInt(reset.Unix(), 10))
+
+	if ok {
+		return true
+	}
+
+	span := trace.SpanFromContext(req.Context())
+	span.SetAttributes(attribute.Int("http.status_code", http.StatusTooManyRequests))
+	span.SetStatus(codes.Error, "quota exceeded")
+
+	r.metrics.IncFailedRequestsTotal(metric.FailReasonQuotaExceeded)
+	WriteError(w, ClientErrQuotaExceeded, http.StatusTooManyRequests)
+
+	return false
+}
+
+// route is a compiled flow with its middlewares already applied.
+type route struct {
+	flow    *flow
+	handler http.Handler
+}
+
+// selectRoute returns a handler serving the first route whose SNI and header matchers pass,
+// or fallback when none of them does.
+func selectRoute(routes []route, fallback http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		for _, rt := range routes {
+			if rt.flow.matches(req) {
+				rt.handler.ServeHTTP(w, req)
+				return
+			}
+		}
+
+		fallback(w, req)
+	}
+}
+
+// regexFallback returns a handler that tries regex flows in configuration order
+// and calls fallback when none of them matches. Named capture groups are appended
+// to chi's route params so upstreams and plugins read them like template params.
+func (r *Router) regexFallback(fallback http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if r.regexPreflight(w, req) {
+			return
+		}
+
+		for _, rt := range r.regexRoutes {
+			if rt.flow.method != req.Method || !rt.flow.matches(req) {
+				continue
+			}
+
+			match := rt.flow.pathRegex.FindStringSubmatch(req.URL.Path)
+			if match == nil {
+				continue
+			}
+
+			rctx := chi.RouteContext(req.Context())
+			if rctx == nil {
+				rctx = chi.NewRouteContext()
+				req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+			}
+
+			for i, name := range rt.flow.pathRegex.SubexpNames() {
+				if name != "" {
+					rctx.URLParams.Add(name, match[i])
+				}
+			}
+
+			rt.handler.ServeHTTP(w, req)
+
+			return
+		}
+
+		fallback(w, req)
+	}
+}
+
+// withFlowTimeout moves the connection deadlines to timeout from now and cancels
+// the request context when it passes. Writers that cannot set deadlines, such as
+// test recorders, only get the context deadline.
+func withFlowTimeout(timeout time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		deadline := time.Now().Add(timeout)
+
+		rc := http.NewResponseController(w)
+		_ = rc.SetReadDeadline(deadline)
+		_ = rc.SetWriteDeadline(deadline)
+
+		ctx, cancel := context.WithDeadline(req.Context(), deadline)
+		defer cancel()
+
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
 func (r *Router) newFlowHandler(f *flow) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if r.rateLimitKey.afterMiddlewares() && !r.allowRequest(w, req) {
+			return
+		}
+
+		if r.quotaKey.afterMiddlewares() && !r.allowQuota(w, req) {
+			return
+		}
+
 		start := time.Now()
 		defer r.metrics.UpdateRequestsDuration(f.path, f.method, start)
 
@@ -161,8 +495,16 @@ func (r *Router) newFlowHandler(f *flow) http.Handler {
 		ctx := req.Context()
 		ctx = withRoute(withRequestID(withFingerprint(ctx, fingerprint), requestID), f.path)
 
+		if f.propagator != nil {
+			ctx = withTracePropagator(tracing.EnsureSpanContext(ctx), f.propagator)
+		}
+
 		req = req.WithContext(ctx)
 
+		if rec := accessRecordFromContext(ctx); rec != nil {
+			rec.setFlow(f.path, requestID)
+		}
+
 		span.SetAttributes(
 			attribute.String("kono.request.id", requestID),
 			attribute.String("kono.request.fingerprint", fingerprint),
@@ -184,10 +526,47 @@ func (r *Router) newFlowHandler(f *flow) http.Handler {
 			return
 		}
 
-		upstreamResponses := r.scatter.scatter(f, req)
+		// Request plugins may replace the request or its body; the rest of the flow
+		// serves what they left.
+		req = kctx.Request()
+
+		var (
+			cacheKey string
+			fallback *cachedResponse // stale entry to serve if the upstreams fail.
+		)
+
+		if f.cache != nil && f.cache.cacheable(req) {
+			now := time.Now()
+			cacheKey = f.cache.key(req)
+
+			entry, fresh := f.cache.lookup(req.Context(), cacheKey, now)
+
+			switch {
+			case entry != nil && fresh:
+				w.Header().Set("X-Cache", "HIT")
+				r.writeFlowResponse(w, req, f, entry.response(requestID, fingerprint), log)
+
+				return
+			case entry != nil && f.cache.revalidatable(entry, now):
+				if f.cache.startRefresh(cacheKey) {
+					go r.revalidate(f, req, cacheKey, log)
+				}
+
+				w.Header().Set("X-Cache", "STALE")
+				r.writeFlowResponse(w, req, f, entry.response(requestID, fingerprint), log)
+
+				return
+			case entry != nil && f.cache.usableOnError(entry, now):
+				fallback = entry
+			}
+
+			w.Header().Set("X-Cache", "MISS")
+		}
+
+		upstreamResponses := r.dispatch(f, req)
 		if upstreamResponses == nil {
 			r.log.Error("request body too large", zap.Int("max_body_size", maxBodySize))
-			WriteError(w, ClientErrPayloadTooLarge, http.StatusRequestEntityTooLarge)
+			r.writeFlowError(w, req, f, ClientErrPayloadTooLarge, http.StatusRequestEntityTooLarge, log)
 
 			return
 		}
@@ -209,9 +588,25 @@ func (r *Router) newFlowHandler(f *flow) http.Handler {
 			)
 		}
 
-		httpResp := r.buildResponse(req.Context(), upstreamResponses, f, log)
+		setUpstreams(kctx, f, upstreamResponses)
+
+		aggregated := r.aggregator.aggregate(f.upstreams, upstreamResponses, f.aggregation, log.Named("aggregated"))
+		if !r.executeAggregatePlugins(w, kctx, f, &aggregated, log) {
+			return
+		}
+
+		httpResp := r.buildResponse(req.Context(), aggregated, f, log)
 		defer func() { _ = httpResp.Body.Close() }()
 
+		if fallback != nil && httpResp.StatusCode >= http.StatusInternalServerError {
+			log.Warn("serving stale cached response", zap.Int("status", httpResp.StatusCode))
+
+			w.Header().Set("X-Cache", "STALE")
+			r.writeFlowResponse(w, req, f, fallback.response(requestID, fingerprint), log)
+
+			return
+		}
+
 		kctx.SetResponse(httpResp)
 
 		if !r.executePlugins(sdk.PluginTypeResponse, w, kctx, f, log) {
@@ -219,22 +614,198 @@ func (r *Router) newFlowHandler(f *flow) http.Handler {
 		}
 
 		finalResp := kctx.Response() //nolint:bodyclose // synthetic response, closed by defer above
-		if finalResp.Body != nil {
+
+		if cacheKey != "" && finalResp.Body != nil {
 			bodyBytes, _ := io.ReadAll(finalResp.Body)
-			finalResp.ContentLength = int64(len(bodyBytes))
+			f.cache.put(req.Context(), cacheKey, finalResp, bodyBytes, time.Now())
 			finalResp.Body = io.NopCloser(bytes.NewReader(bodyBytes))
 		}
 
-		w.Header().Set("Content-Length", strconv.Itoa(int(finalResp.ContentLength)))
+		r.writeFlowResponse(w, req, f, finalResp, log)
+	})
+}
+
+// revalidate refreshes a stale cache entry in the background. The request keeps
+// its values but is detached from the client, which has already been answered.
+func (r *Router) revalidate(f *flow, original *http.Request, key string, log *zap.Logger) {
+	defer f.cache.finishRefresh(key)
+
+	req := original.Clone(context.WithoutCancel(original.Context()))
+
+	upstreamResponses := r.scatter.scatter(f, req)
+	if upstreamResponses == nil {
+		return
+	}
+
+	kctx := newContext(req)
+	setUpstreams(kctx, f, upstreamResponses)
+
+	aggregated := r.aggregator.aggregate(f.upstreams, upstreamResponses, f.aggregation, log.Named("aggregated"))
+	if !r.executeAggregatePlugins(discardResponseWriter{}, kctx, f, &aggregated, log) {
+		return
+	}
+
+	httpResp := r.buildResponse(req.Context(), aggregated, f, log)
+	defer func() { _ = httpResp.Body.Close() }()
+
+	kctx.SetResponse(httpResp)
+
+	if !r.executePlugins(sdk.PluginTypeResponse, discardResponseWriter{}, kctx, f, log) {
+		return
+	}
+
+	finalResp := kctx.Response() //nolint:bodyclose // synthetic response, closed by defer above
+	if finalResp.Body == nil {
+		return
+	}
+
+	body, _ := io.ReadAll(finalResp.Body)
+	f.cache.put(req.Context(), key, finalResp, body, time.Now())
+}
+
+// writeFlowResponse encodes and compresses the final flow response for the client
+// and writes it.
+func (r *Router) writeFlowResponse(w http.ResponseWriter, req *http.Request, f *flow, resp *http.Response, log *zap.Logger) {
+	if resp.Body != nil {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+
+		if !r.negotiateResponse(w, req, resp, bodyBytes, f, log) {
+			return
+		}
+
+		r.compressResponse(w, req, resp, f, log)
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(int(resp.ContentLength)))
+
+	r.metrics.IncRequestsTotal(f.path, req.Method, resp.StatusCode)
+
+	span := trace.SpanFromContext(req.Context())
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+
+	r.copyResponse(w, resp)
+}
+
+// negotiateResponse re-encodes a JSON response body in the format chosen from the
+// Accept header and updates Content-Type and Content-Length accordingly.
+// On an encoding failure it writes a 500 to w and returns false.
+func (r *Router) negotiateResponse(w http.ResponseWriter, req *http.Request, resp *http.Response, body []byte, f *flow, log *zap.Logger) bool {
+	if f.negotiation.negotiable() {
+		w.Header().Add("Vary", "Accept")
+	}
+
+	format := f.negotiation.choose(req.Header.Get("Accept"))
+
+	if format != formatJSON && strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		encoded, err := f.negotiation.encode(body, format)
+		if err != nil {
+			log.Error("cannot encode response", zap.String("format", format.String()), zap.Error(err))
+			WriteError(w, ClientErrInternal, http.StatusInternalServerError)
 
-		r.metrics.IncRequestsTotal(f.path, req.Method, finalResp.StatusCode)
-		span.SetAttributes(attribute.Int("http.status_code", finalResp.StatusCode))
-		if finalResp.StatusCode >= http.StatusInternalServerError {
-			span.SetStatus(codes.Error, http.StatusText(finalResp.StatusCode))
+			return false
 		}
 
-		r.copyResponse(w, finalResp)
-	})
+		body = encoded
+		resp.Header.Set("Content-Type", format.contentType())
+	}
+
+	resp.ContentLength = int64(len(body))
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	return true
+}
+
+// compressResponse compresses the buffered response body when the flow enables
+// compression, the client accepts a configured encoding and the body qualifies.
+// Compression failures are logged and the body is sent uncompressed.
+func (r *Router) compressResponse(w http.ResponseWriter, req *http.Request, resp *http.Response, f *flow, log *zap.Logger) {
+	c := f.compression
+	if c == nil || resp.Header.Get("Content-Encoding") != "" {
+		return
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	encoding := c.chooseEncoding(req.Header.Get("Accept-Encoding"))
+	if encoding == "" || !c.compressible(resp.Header.Get("Content-Type"), int(resp.ContentLength)) {
+		return
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+
+	compressed, err := compressBody(body, encoding)
+	if err != nil {
+		log.Warn("cannot compress response", zap.String("encoding", encoding), zap.Error(err))
+
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+
+		return
+	}
+
+	resp.Header.Set("Content-Encoding", encoding)
+	resp.ContentLength = int64(len(compressed))
+	resp.Body = io.NopCloser(bytes.NewReader(compressed))
+}
+
+// writeAbort answers the client with the response a plugin aborted the flow with.
+func (r *Router) writeAbort(w http.ResponseWriter, req *http.Request, f *flow, abort *sdk.AbortError) {
+	for name, values := range abort.Header {
+		w.Header()[http.CanonicalHeaderKey(name)] = values
+	}
+
+	status := abort.Status
+	if status == 0 {
+		status = http.StatusForbidden
+	}
+
+	// WriteHeader panics on codes outside 100-999, and the client cannot read a
+	// reply with a code that is not a valid HTTP status.
+	if !validStatus(status) {
+		status = http.StatusBadGateway
+	}
+
+	r.metrics.IncRequestsTotal(f.path, req.Method, status)
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(abort.Body)))
+	w.WriteHeader(status)
+	_, _ = w.Write(abort.Body)
+}
+
+// validStatus reports whether status is a code a response can carry.
+func validStatus(status int) bool {
+	return status >= 100 && status <= 599
+}
+
+// writeFlowError writes an error ClientResponse in the format negotiated for the flow,
+// falling back to JSON when the error body cannot be encoded.
+func (r *Router) writeFlowError(w http.ResponseWriter, req *http.Request, f *flow, code ClientError, status int, log *zap.Logger) {
+	format := f.negotiation.choose(req.Header.Get("Accept"))
+	if format == formatJSON {
+		WriteError(w, code, status)
+		return
+	}
+
+	body, err := f.negotiation.encode(mustMarshal(ClientResponse{
+		Errors: []ClientError{code},
+		Meta:   ResponseMeta{RequestID: requestIDFromContext(req.Context())},
+	}), format)
+	if err != nil {
+		log.Error("cannot encode error response", zap.String("format", format.String()), zap.Error(err))
+		WriteError(w, code, status)
+
+		return
+	}
+
+	if f.negotiation.negotiable() {
+		w.Header().Add("Vary", "Accept")
+	}
+
+	w.Header().Set("Content-Type", format.contentType())
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
 }
 
 // executePlugins runs all plugins of the given type in order.
@@ -253,7 +824,9 @@ func (r *Router) executePlugins(pluginType sdk.PluginType, w http.ResponseWriter
 			zap.String("name", p.Info().Name),
 		)
 
-		ctx, span := tracer.Start(kctx.Request().Context(), "kono.plugin",
+		parent := kctx.Request().Context()
+
+		ctx, span := tracer.Start(parent, "kono.plugin",
 			trace.WithAttributes(
 				attribute.String("kono.plugin.name", p.Info().Name),
 				attribute.String("kono.plugin.type", pluginType.String()),
@@ -261,30 +834,103 @@ func (r *Router) executePlugins(pluginType sdk.PluginType, w http.ResponseWriter
 		)
 
 		kctx.SetRequest(kctx.Request().WithContext(ctx))
-		err := p.Execute(kctx)
-		span.End()
+		err := runPlugin(p, kctx)
 
-		if err != nil {
-			span.RecordError(err)
-			span.SetStatus(codes.Error, "plugin execution failed")
+		// Later plugins and the upstreams must not run under the ended plugin span;
+		// a context the plugin set itself is kept.
+		if kctx.Request().Context() == ctx {
+			kctx.SetRequest(kctx.Request().WithContext(parent))
+		}
+
+		var abort *sdk.AbortError
+		if errors.As(err, &abort) {
+			span.SetAttributes(attribute.Int("http.status_code", abort.Status))
+			span.End()
 
-			log.Error("plugin execution failed",
+			log.Debug("plugin aborted the request",
 				zap.String("type", pluginType.String()),
 				zap.String("name", p.Info().Name),
-				zap.Error(err),
+				zap.Int("status", abort.Status),
 			)
-			WriteError(w, ClientErrInternal, http.StatusInternalServerError)
+			r.writeAbort(w, kctx.Request(), f, abort)
 
 			return false
 		}
+
+		if err == nil {
+			span.End()
+			continue
+		}
+
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "plugin execution failed")
+		span.End()
+
+		reason := pluginFailureReason(err)
+		r.metrics.IncPluginErrorsTotal(p.Info().Name, pluginType.String(), reason)
+
+		fields := []zap.Field{
+			zap.String("type", pluginType.String()),
+			zap.String("name", p.Info().Name),
+			zap.String("reason", reason),
+			zap.Error(err),
+		}
+
+		var panicErr *pluginPanicError
+		if errors.As(err, &panicErr) {
+			fields = append(fields, zap.ByteString("stack", panicErr.stack))
+		}
+
+		if pp, ok := p.(policyPlugin); ok && pp.continueOnError {
+			log.Warn("plugin execution failed, continuing", fields...)
+			continue
+		}
+
+		log.Error("plugin execution failed", fields...)
+		r.writeFlowError(w, kctx.Request(), f, ClientErrInternal, http.StatusInternalServerError, log)
+
+		return false
 	}
 
 	return true
 }
 
-func (r *Router) buildResponse(ctx context.Context, upstreamResponses []upstreamResponse, f *flow, log *zap.Logger) *http.Response {
-	aggregated := r.aggregator.aggregate(f.upstreams, upstreamResponses, f.aggregation, log.Named("aggregated"))
+// executeAggregatePlugins runs the PluginTypeAggregate plugins on the aggregator
+// output and stores their changes back into aggregated.
+func (r *Router) executeAggregatePlugins(w http.ResponseWriter, kctx sdk.Context, f *flow, aggregated *aggregatedResponse, log *zap.Logger) bool {
+	if !slices.ContainsFunc(f.plugins, func(p sdk.Plugin) bool { return p.Type() == sdk.PluginTypeAggregate }) {
+		return true
+	}
 
+	kc, ok := kctx.(*konoContext)
+	if !ok {
+		return true
+	}
+
+	kc.aggregated = &sdk.AggregatedResponse{Data: aggregated.data, Header: aggregated.headers}
+	defer func() { kc.aggregated = nil }()
+
+	if kc.aggregated.Header == nil {
+		kc.aggregated.Header = make(http.Header)
+	}
+
+	if !r.executePlugins(sdk.PluginTypeAggregate, w, kctx, f, log) {
+		return false
+	}
+
+	aggregated.data, aggregated.headers = kc.aggregated.Data, kc.aggregated.Header
+
+	return true
+}
+
+// setUpstreams exposes the upstream results to plugins through sdk.ResponseContext.
+func setUpstreams(kctx sdk.Context, f *flow, responses []upstreamResponse) {
+	if kc, ok := kctx.(*konoContext); ok {
+		kc.upstreams = sdkUpstreamResponses(f.upstreams, responses)
+	}
+}
+
+func (r *Router) buildResponse(ctx context.Context, aggregated aggregatedResponse, f *flow, log *zap.Logger) *http.Response {
 	headers := aggregated.headers
 	if headers == nil {
 		headers = make(http.Header)
@@ -349,15 +995,7 @@ func (r *Router) buildResponseBody(aggregated aggregatedResponse, requestID stri
 }
 
 func (r *Router) copyResponse(w http.ResponseWriter, resp *http.Response) {
-	for k, vv := range resp.Header {
-		if _, skip := hopByHopHeaders[k]; skip {
-			continue
-		}
-
-		for _, v := range vv {
-			w.Header().Add(k, v)
-		}
-	}
+	copyResponseHeaders(w.Header(), resp.Header)
 
 	w.WriteHeader(resp.StatusCode)
 
@@ -389,7 +1027,7 @@ func (r *Router) statusFromErrors(errors []ClientError, partial bool) int {
 	}
 
 	switch selected {
-	case ClientErrRateLimitExceeded:
+	case ClientErrRateLimitExceeded, ClientErrQuotaExceeded:
 		return http.StatusTooManyRequests
 	case ClientErrPayloadTooLarge:
 		return http.StatusRequestEntityTooLarge
@@ -397,6 +1035,8 @@ func (r *Router) statusFromErrors(errors []ClientError, partial bool) int {
 		return http.StatusBadGateway
 	case ClientErrValueConflict:
 		return http.StatusConflict
+	case ClientErrUpstreamOverloaded, ClientErrOverloaded:
+		return http.StatusServiceUnavailable
 	case ClientErrAborted:
 		// Client disconnected before the upstream responded; there is nothing
 		// meaningful to send back, but we still need a status for logging.
@@ -419,26 +1059,6 @@ func mustMarshal(v any) []byte {
 	return b
 }
 
-// extractClientIP resolves the real client IP following the chain:
-// X-Forwarded-For → X-Real-IP → RemoteAddr.
-func extractClientIP(r *http.Request) string {
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		parts := strings.Split(xff, ",")
-		return strings.TrimSpace(parts[0])
-	}
-
-	if xrip := r.Header.Get("X-Real-IP"); xrip != "" {
-		return xrip
-	}
-
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err == nil {
-		return host
-	}
-
-	return r.RemoteAddr
-}
-
 var globalEntropy = ulid.Monotonic(rand.Reader, math.MaxInt64)
 
 func getOrCreateRequestID(r *http.Request) string {
@@ -499,13 +1119,14 @@ const (
 // Higher value = more specific HTTP status code returned.
 func errorPriority(e ClientError) int {
 	switch e {
-	case ClientErrRateLimitExceeded:
+	case ClientErrRateLimitExceeded, ClientErrQuotaExceeded:
 		return errPriorityRateLimit
 	case ClientErrPayloadTooLarge, ClientErrUpstreamBodyTooLarge:
 		return errPriorityPayloadSize
 	case ClientErrValueConflict:
 		return errPriorityConflict
-	case ClientErrUpstreamUnavailable, ClientErrUpstreamError, ClientErrUpstreamMalformed, ClientErrAborted:
+	case ClientErrUpstreamUnavailable, ClientErrUpstreamOverloaded, ClientErrUpstreamError, ClientErrUpstreamMalformed,
+		ClientErrAborted, ClientErrOverloaded:
 		return errPriorityUpstream
 	case ClientErrInternal:
 		return errPriorityInternal
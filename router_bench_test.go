@@ -0,0 +1,69 @@
+package kono
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/kono/internal/metric"
+)
+
+// benchFlowsCount approximates a large production config.
+const benchFlowsCount = 500
+
+func newBenchRouter(b *testing.B) *Router {
+	b.Helper()
+
+	metrics, err := metric.New()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	flows := make([]flow, 0, benchFlowsCount)
+	for i := range benchFlowsCount {
+		flows = append(flows, flow{
+			path:   fmt.Sprintf("/api/v1/service%d/users/{id}/orders/{orderID}", i),
+			method: http.MethodGet,
+		})
+	}
+
+	r := &Router{
+		chiRouter:  chi.NewMux(),
+		aggregator: &defaultAggregator{},
+		flows:      flows,
+		log:        zap.NewNop(),
+		metrics:    metrics,
+	}
+	r.registerFlows()
+
+	return r
+}
+
+// BenchmarkRouteMatch measures flow lookup alone. Matching walks chi's radix tree,
+// so the cost depends on the path length rather than on the number of flows.
+func BenchmarkRouteMatch(b *testing.B) {
+	r := newBenchRouter(b)
+	rctx := chi.NewRouteContext()
+
+	paths := []string{
+		"/api/v1/service0/users/42/orders/7",
+		fmt.Sprintf("/api/v1/service%d/users/42/orders/7", benchFlowsCount-1),
+	}
+
+	for _, path := range paths {
+		b.Run(path, func(b *testing.B) {
+			b.ReportAllocs()
+
+			for b.Loop() {
+				rctx.Reset()
+
+				if !r.chiRouter.Match(rctx, http.MethodGet, path) {
+					b.Fatal("no flow matched")
+				}
+			}
+		})
+	}
+}
@@ -1,17 +1,44 @@
 package kono
 
 import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
 	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/sync/semaphore"
 
+	"github.com/starwalkn/kono/internal/quota"
 	"github.com/starwalkn/kono/sdk"
 )
 
+// failingPlugin is a request plugin whose Execute always fails.
+type failingPlugin struct{ mockPlugin }
+
+func (failingPlugin) Execute(sdk.Context) error { return errors.New("enrichment backend down") }
+
+// deadlineScatter records the deadline of the request it fans out.
+type deadlineScatter struct {
+	deadline    time.Time
+	hasDeadline bool
+}
+
+func (s *deadlineScatter) scatter(_ *flow, req *http.Request) []upstreamResponse {
+	s.deadline, s.hasDeadline = req.Context().Deadline()
+
+	return []upstreamResponse{{status: http.StatusOK, headers: http.Header{}, body: []byte(`"OK"`)}}
+}
+
 var _ = Describe("Router", func() {
 	Describe("ServeHTTP", func() {
 		Context("with a successful flow", func() {
@@ -48,6 +75,124 @@ var _ = Describe("Router", func() {
 			})
 		})
 
+		Context("with content negotiation", func() {
+			It("encodes the response in the format asked for by Accept", func() {
+				d := &mockScatter{
+					results: []upstreamResponse{{status: http.StatusOK, body: []byte(`{"id":1}`)}},
+				}
+
+				r := newTestRouter([]flow{{
+					path:        "/test/negotiation",
+					method:      http.MethodGet,
+					aggregation: aggregation{strategy: strategyArray},
+					negotiation: negotiation{formats: []responseFormat{formatJSON, formatXML}, def: formatJSON},
+				}}, d, &defaultAggregator{})
+
+				req := httptest.NewRequest(http.MethodGet, "/test/negotiation", nil)
+				req.Header.Set("Accept", "application/xml")
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+
+				res := rec.Result()
+				defer res.Body.Close()
+				body, _ := io.ReadAll(res.Body)
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(res.Header.Get("Content-Type")).To(ContainSubstring("application/xml"))
+				Expect(res.Header.Get("Vary")).To(Equal("Accept"))
+				Expect(res.Header.Get("Content-Length")).To(Equal(strconv.Itoa(len(body))))
+				Expect(string(body)).To(ContainSubstring("<data><id>1</id></data>"))
+			})
+		})
+
+		Context("with a MessagePack-only flow", func() {
+			var r *Router
+
+			msgpackOnly := negotiation{formats: []responseFormat{formatMsgpack}, def: formatMsgpack}
+
+			decode := func(res *http.Response) map[string]interface{} {
+				body, _ := io.ReadAll(res.Body)
+
+				var resp map[string]interface{}
+				Expect(msgpack.Unmarshal(body, &resp)).To(Succeed())
+
+				return resp
+			}
+
+			It("encodes the client response regardless of Accept", func() {
+				r = newTestRouter([]flow{{
+					path:        "/test/msgpack",
+					method:      http.MethodGet,
+					aggregation: aggregation{strategy: strategyArray},
+					negotiation: msgpackOnly,
+				}}, &mockScatter{results: []upstreamResponse{{status: http.StatusOK, body: []byte(`{"id":1}`)}}}, &defaultAggregator{})
+
+				req := httptest.NewRequest(http.MethodGet, "/test/msgpack", nil)
+				req.Header.Set("Accept", "application/json")
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+
+				res := rec.Result()
+				defer res.Body.Close()
+
+				Expect(res.Header.Get("Content-Type")).To(Equal("application/msgpack"))
+				Expect(decode(res)).To(HaveKeyWithValue("meta", HaveKey("request_id")))
+			})
+
+			It("encodes gateway errors as well", func() {
+				r = newTestRouter([]flow{{
+					path:        "/test/msgpack",
+					method:      http.MethodPost,
+					aggregation: aggregation{strategy: strategyArray},
+					negotiation: msgpackOnly,
+				}}, &mockScatter{results: nil}, &defaultAggregator{})
+
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/test/msgpack", nil))
+
+				res := rec.Result()
+				defer res.Body.Close()
+
+				Expect(res.StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+				Expect(res.Header.Get("Content-Type")).To(Equal("application/msgpack"))
+				Expect(decode(res)).To(HaveKeyWithValue("errors", ConsistOf(string(ClientErrPayloadTooLarge))))
+			})
+		})
+
+		Context("with compression", func() {
+			It("gzips responses above the minimum size", func() {
+				d := &mockScatter{
+					results: []upstreamResponse{{status: http.StatusOK, body: []byte(`{"id":1}`)}},
+				}
+
+				r := newTestRouter([]flow{{
+					path:        "/test/compressed",
+					method:      http.MethodGet,
+					aggregation: aggregation{strategy: strategyArray},
+					compression: &compression{
+						encodings:    []string{encodingGzip},
+						contentTypes: []string{"application/json"},
+					},
+				}}, d, &defaultAggregator{})
+
+				req := httptest.NewRequest(http.MethodGet, "/test/compressed", nil)
+				req.Header.Set("Accept-Encoding", "gzip")
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+
+				res := rec.Result()
+				defer res.Body.Close()
+
+				Expect(res.Header.Get("Content-Encoding")).To(Equal("gzip"))
+				Expect(res.Header.Values("Vary")).To(ContainElement("Accept-Encoding"))
+
+				gr, err := gzip.NewReader(res.Body)
+				Expect(err).NotTo(HaveOccurred())
+				body, _ := io.ReadAll(gr)
+				jsonEqual(`{"id":1}`, decodeJSONResponse(body).Data)
+			})
+		})
+
 		Context("with a partial response", func() {
 			It("returns 206 and includes errors", func() {
 				d := &mockScatter{
@@ -217,6 +362,347 @@ var _ = Describe("Router", func() {
 			})
 		})
 
+		Context("with path params", func() {
+			It("exposes captured params to plugins", func() {
+				var captured map[string]string
+
+				plugin := &mockPlugin{
+					name: "params",
+					typ:  sdk.PluginTypeRequest,
+					fn: func(ctx sdk.Context) {
+						captured = ctx.PathParams()
+						captured["id"] = ctx.PathParam("id")
+					},
+				}
+
+				d := &mockScatter{
+					results: []upstreamResponse{
+						{status: http.StatusOK, body: []byte(`"OK"`)},
+					},
+				}
+
+				r := newTestRouter([]flow{{
+					path:    "/users/{id}/orders/{orderID}",
+					method:  http.MethodGet,
+					plugins: []sdk.Plugin{plugin},
+				}}, d, &defaultAggregator{})
+
+				req := httptest.NewRequest(http.MethodGet, "/users/42/orders/7", nil)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+
+				Expect(rec.Code).To(Equal(http.StatusOK))
+				Expect(captured).To(Equal(map[string]string{"id": "42", "orderID": "7"}))
+			})
+		})
+
+		Context("with a regex flow", func() {
+			newRegexRouter := func(plugin sdk.Plugin) *Router {
+				d := &mockScatter{
+					results: []upstreamResponse{
+						{status: http.StatusOK, body: []byte(`"OK"`)},
+					},
+				}
+
+				return newTestRouter([]flow{{
+					path:      `/legacy/(?P<year>\d{4})/(?P<slug>[a-z-]+)\.html`,
+					method:    http.MethodGet,
+					pathRegex: regexp.MustCompile(`^(?:/legacy/(?P<year>\d{4})/(?P<slug>[a-z-]+)\.html)$`),
+					plugins:   []sdk.Plugin{plugin},
+				}}, d, &defaultAggregator{})
+			}
+
+			It("matches the whole path and exposes named groups", func() {
+				var captured map[string]string
+
+				r := newRegexRouter(&mockPlugin{
+					name: "params",
+					typ:  sdk.PluginTypeRequest,
+					fn:   func(ctx sdk.Context) { captured = ctx.PathParams() },
+				})
+
+				req := httptest.NewRequest(http.MethodGet, "/legacy/2019/hello-world.html", nil)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+
+				Expect(rec.Code).To(Equal(http.StatusOK))
+				Expect(captured).To(Equal(map[string]string{"year": "2019", "slug": "hello-world"}))
+			})
+
+			It("returns 404 for a partial match", func() {
+				r := newRegexRouter(&mockPlugin{typ: sdk.PluginTypeRequest, fn: func(sdk.Context) {}})
+
+				req := httptest.NewRequest(http.MethodGet, "/legacy/2019/hello-world.html/extra", nil)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+
+				Expect(rec.Code).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		Context("with wildcard and exact flows on the same prefix", func() {
+			It("prefers the exact flow and falls back to the wildcard", func() {
+				d := &mockScatter{
+					results: []upstreamResponse{
+						{status: http.StatusOK, body: []byte(`"OK"`)},
+					},
+				}
+
+				var matched []string
+
+				tag := func(name string) sdk.Plugin {
+					return &mockPlugin{
+						name: name,
+						typ:  sdk.PluginTypeRequest,
+						fn: func(ctx sdk.Context) {
+							matched = append(matched, name+":"+ctx.PathParam("*"))
+						},
+					}
+				}
+
+				r := newTestRouter([]flow{
+					{path: "/api/v1/*", method: http.MethodGet, plugins: []sdk.Plugin{tag("wildcard")}},
+					{path: "/api/v1/users", method: http.MethodGet, plugins: []sdk.Plugin{tag("exact")}},
+				}, d, &defaultAggregator{})
+
+				for _, path := range []string{"/api/v1/users", "/api/v1/orders/7"} {
+					rec := httptest.NewRecorder()
+					r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+					Expect(rec.Code).To(Equal(http.StatusOK))
+				}
+
+				Expect(matched).To(Equal([]string{"exact:", "wildcard:orders/7"}))
+			})
+		})
+
+		Context("with header-predicate flows on the same path", func() {
+			It("dispatches by header and falls back to the unconditioned flow", func() {
+				v2, err := compileHeaderMatchers([]HeaderMatcherConfig{{Name: "X-API-Version", Exact: "2"}})
+				Expect(err).NotTo(HaveOccurred())
+
+				var matched []string
+
+				tag := func(name string) sdk.Plugin {
+					return &mockPlugin{
+						name: name,
+						typ:  sdk.PluginTypeRequest,
+						fn:   func(sdk.Context) { matched = append(matched, name) },
+					}
+				}
+
+				d := &mockScatter{
+					results: []upstreamResponse{
+						{status: http.StatusOK, body: []byte(`"OK"`)},
+					},
+				}
+
+				r := newTestRouter([]flow{
+					{path: "/users", method: http.MethodGet, headerMatchers: v2, plugins: []sdk.Plugin{tag("v2")}},
+					{path: "/users", method: http.MethodGet, plugins: []sdk.Plugin{tag("v1")}},
+				}, d, &defaultAggregator{})
+
+				req := httptest.NewRequest(http.MethodGet, "/users", nil)
+				req.Header.Set("X-API-Version", "2")
+				r.ServeHTTP(httptest.NewRecorder(), req)
+				r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+
+				Expect(matched).To(Equal([]string{"v2", "v1"}))
+			})
+
+			It("returns 404 when no flow's headers match", func() {
+				v2, err := compileHeaderMatchers([]HeaderMatcherConfig{{Name: "X-API-Version", Exact: "2"}})
+				Expect(err).NotTo(HaveOccurred())
+
+				r := newTestRouter([]flow{
+					{path: "/users", method: http.MethodGet, headerMatchers: v2},
+				}, &mockScatter{}, &defaultAggregator{})
+
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users", nil))
+
+				Expect(rec.Code).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		Context("with SNI matchers", func() {
+			It("selects the flow set by TLS server name", func() {
+				var matched []string
+
+				tag := func(name string) sdk.Plugin {
+					return &mockPlugin{
+						name: name,
+						typ:  sdk.PluginTypeRequest,
+						fn:   func(sdk.Context) { matched = append(matched, name) },
+					}
+				}
+
+				d := &mockScatter{results: []upstreamResponse{{status: http.StatusOK, body: []byte(`"OK"`)}}}
+
+				r := newTestRouter([]flow{
+					{path: "/users", method: http.MethodGet, serverNames: []string{"api.example.com"}, plugins: []sdk.Plugin{tag("api")}},
+					{path: "/users", method: http.MethodGet, serverNames: []string{"*.internal.example.com"}, plugins: []sdk.Plugin{tag("internal")}},
+				}, d, &defaultAggregator{})
+
+				serve := func(serverName string) int {
+					req := httptest.NewRequest(http.MethodGet, "/users", nil)
+					if serverName != "" {
+						req.TLS = &tls.ConnectionState{ServerName: serverName}
+					}
+
+					rec := httptest.NewRecorder()
+					r.ServeHTTP(rec, req)
+
+					return rec.Code
+				}
+
+				Expect(serve("API.example.com")).To(Equal(http.StatusOK))
+				Expect(serve("admin.internal.example.com")).To(Equal(http.StatusOK))
+				Expect(serve("internal.example.com")).To(Equal(http.StatusNotFound))
+				Expect(serve("")).To(Equal(http.StatusNotFound))
+				Expect(matched).To(Equal([]string{"api", "internal"}))
+			})
+		})
+
+		Context("with listeners", func() {
+			It("serves flows only on the listeners they are bound to", func() {
+				d := &mockScatter{results: []upstreamResponse{{status: http.StatusOK, body: []byte(`"OK"`)}}}
+
+				r := newTestRouter([]flow{
+					{path: "/users", method: http.MethodGet},
+					{path: "/stats", method: http.MethodGet, listeners: []string{"admin"}},
+				}, d, &defaultAggregator{})
+
+				serve := func(listener, path string) int {
+					req := httptest.NewRequest(http.MethodGet, path, nil)
+					if listener != "" {
+						req = req.WithContext(WithListener(req.Context(), listener))
+					}
+
+					rec := httptest.NewRecorder()
+					r.ServeHTTP(rec, req)
+
+					return rec.Code
+				}
+
+				Expect(serve("", "/users")).To(Equal(http.StatusOK))
+				Expect(serve("", "/stats")).To(Equal(http.StatusNotFound))
+				Expect(serve("admin", "/stats")).To(Equal(http.StatusOK))
+				Expect(serve("admin", "/users")).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		Context("with load shedding", func() {
+			It("rejects requests beyond the in-flight cap with Retry-After", func() {
+				r := newTestRouter([]flow{{
+					path:   "/test/shed",
+					method: http.MethodGet,
+				}}, &mockScatter{results: []upstreamResponse{{status: http.StatusOK, body: []byte(`"OK"`)}}}, &defaultAggregator{})
+
+				r.inFlight = semaphore.NewWeighted(1)
+				r.retryAfter = "2"
+				Expect(r.inFlight.TryAcquire(1)).To(BeTrue())
+
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test/shed", nil))
+
+				Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+				Expect(rec.Header().Get("Retry-After")).To(Equal("2"))
+				Expect(rec.Body.String()).To(ContainSubstring(string(ClientErrOverloaded)))
+
+				r.inFlight.Release(1)
+
+				rec = httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test/shed", nil))
+				Expect(rec.Code).To(Equal(http.StatusOK))
+			})
+		})
+
+		Context("with a load shedding queue", func() {
+			var r *Router
+
+			BeforeEach(func() {
+				r = newTestRouter([]flow{{
+					path:   "/test/queue",
+					method: http.MethodGet,
+				}}, &mockScatter{results: []upstreamResponse{{status: http.StatusOK, body: []byte(`"OK"`)}}}, &defaultAggregator{})
+
+				r.inFlight = semaphore.NewWeighted(1)
+				r.retryAfter = "1"
+				r.queueTimeout = time.Second
+				Expect(r.inFlight.TryAcquire(1)).To(BeTrue())
+			})
+
+			It("serves a queued request once a slot frees up", func() {
+				done := make(chan int)
+				go func() {
+					rec := httptest.NewRecorder()
+					r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test/queue", nil))
+					done <- rec.Code
+				}()
+
+				Eventually(r.queued.Load).Should(Equal(int64(1)))
+				r.inFlight.Release(1)
+
+				Eventually(done).Should(Receive(Equal(http.StatusOK)))
+			})
+
+			It("sheds a request once the queue timeout passes", func() {
+				r.queueTimeout = 20 * time.Millisecond
+
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test/queue", nil))
+
+				Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+				Expect(r.queued.Load()).To(BeZero())
+			})
+
+			It("sheds immediately when the queue is full", func() {
+				r.maxQueued = 1
+				r.queued.Store(1)
+
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test/queue", nil))
+
+				Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+				Expect(r.queued.Load()).To(Equal(int64(1)))
+			})
+		})
+
+		Context("with a quota", func() {
+			It("reports the remaining quota and rejects once it is used up", func() {
+				r := newTestRouter([]flow{{
+					path:   "/test/quota",
+					method: http.MethodGet,
+				}}, &mockScatter{results: []upstreamResponse{{status: http.StatusOK, body: []byte(`"OK"`)}}}, &defaultAggregator{})
+
+				q, err := quota.New(1, 10, quota.PeriodDay, "")
+				Expect(err).NotTo(HaveOccurred())
+
+				r.quota = q
+				r.quotaKey = rateLimitKey{{Source: "header", Name: "X-Api-Key"}}
+
+				serve := func() *httptest.ResponseRecorder {
+					req := httptest.NewRequest(http.MethodGet, "/test/quota", nil)
+					req.Header.Set("X-Api-Key", "k-1")
+
+					rec := httptest.NewRecorder()
+					r.ServeHTTP(rec, req)
+
+					return rec
+				}
+
+				rec := serve()
+				Expect(rec.Code).To(Equal(http.StatusOK))
+				Expect(rec.Header().Get("X-Quota-Limit")).To(Equal("1"))
+				Expect(rec.Header().Get("X-Quota-Remaining")).To(Equal("0"))
+				Expect(rec.Header().Get("X-Quota-Reset")).NotTo(BeEmpty())
+
+				rec = serve()
+				Expect(rec.Code).To(Equal(http.StatusTooManyRequests))
+				Expect(rec.Body.String()).To(ContainSubstring(string(ClientErrQuotaExceeded)))
+			})
+		})
+
 		Context("with middleware", func() {
 			It("runs middleware before the handler", func() {
 				d := &mockScatter{
@@ -241,6 +727,245 @@ var _ = Describe("Router", func() {
 				Expect(res.StatusCode).To(Equal(http.StatusOK))
 				Expect(res.Header.Get("X-Middleware")).To(Equal("ok"))
 			})
+
+			It("skips middleware for requests excluded by its conditions", func() {
+				mw, err := withMiddlewareConditions(&mockMiddleware{}, MiddlewareConfig{
+					When:   &RequestMatchConfig{Methods: []string{"get"}},
+					Unless: &RequestMatchConfig{PathPrefixes: []string{"/public"}},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				d := &mockScatter{results: []upstreamResponse{{status: http.StatusOK, body: []byte(`"OK"`)}}}
+
+				var flows []flow
+				for _, route := range []struct{ method, path string }{
+					{http.MethodGet, "/public/docs"},
+					{http.MethodGet, "/publicity"},
+					{http.MethodGet, "/users"},
+					{http.MethodPost, "/users"},
+				} {
+					flows = append(flows, flow{
+						path:        route.path,
+						method:      route.method,
+						aggregation: aggregation{strategy: strategyArray},
+						middlewares: []sdk.Middleware{mw},
+					})
+				}
+
+				r := newTestRouter(flows, d, &defaultAggregator{})
+
+				applied := func(method, path string) string {
+					rec := httptest.NewRecorder()
+					r.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+
+					return rec.Header().Get("X-Middleware")
+				}
+
+				Expect(applied(http.MethodGet, "/public/docs")).To(BeEmpty())
+				Expect(applied(http.MethodGet, "/publicity")).To(Equal("ok"))
+				Expect(applied(http.MethodGet, "/users")).To(Equal("ok"))
+				Expect(applied(http.MethodPost, "/users")).To(BeEmpty())
+			})
+		})
+
+		Context("plugin errors", func() {
+			newRouter := func(p sdk.Plugin) *Router {
+				return newTestRouter([]flow{{
+					path:        "/test/plugin-error",
+					method:      http.MethodGet,
+					aggregation: aggregation{strategy: strategyArray},
+					plugins:     []sdk.Plugin{p},
+				}}, &mockScatter{results: []upstreamResponse{{status: http.StatusOK, headers: http.Header{}, body: []byte(`"OK"`)}}}, &defaultAggregator{})
+			}
+
+			serve := func(r *Router) int {
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test/plugin-error", nil))
+
+				return rec.Code
+			}
+
+			It("fail the request by default", func() {
+				Expect(serve(newRouter(&failingPlugin{}))).To(Equal(http.StatusInternalServerError))
+			})
+
+			It("are skipped with on_error continue", func() {
+				Expect(serve(newRouter(withPluginPolicy(&failingPlugin{}, PluginConfig{OnError: onErrorContinue})))).To(Equal(http.StatusOK))
+			})
+
+			It("include recovered panics", func() {
+				p := &mockPlugin{name: "boom", fn: func(sdk.Context) { panic("nil map") }}
+
+				Expect(serve(newRouter(p))).To(Equal(http.StatusInternalServerError))
+				Expect(pluginFailureReason(runPlugin(p, newContext(httptest.NewRequest(http.MethodGet, "/", nil))))).
+					To(Equal(pluginFailurePanic))
+			})
+
+			It("include overrunning the timeout", func() {
+				release := make(chan struct{})
+				defer close(release)
+
+				p := withPluginPolicy(&mockPlugin{name: "slow", fn: func(sdk.Context) { <-release }},
+					PluginConfig{Timeout: 20 * time.Millisecond})
+
+				err := runPlugin(p, newContext(httptest.NewRequest(http.MethodGet, "/", nil)))
+				Expect(pluginFailureReason(err)).To(Equal(pluginFailureTimeout))
+				Expect(serve(newRouter(p))).To(Equal(http.StatusInternalServerError))
+			})
+
+			It("apply the changes of a plugin that finished in time", func() {
+				p := withPluginPolicy(&mockPlugin{name: "tag", fn: func(ctx sdk.Context) {
+					ctx.Request().Header.Set("X-Tag", "yes")
+				}}, PluginConfig{Timeout: time.Second})
+
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				kctx := newContext(req)
+
+				Expect(runPlugin(p, kctx)).To(Succeed())
+				Expect(kctx.Request().Header.Get("X-Tag")).To(Equal("yes"))
+				Expect(kctx.Request().Context().Err()).NotTo(HaveOccurred())
+			})
+
+			It("leave the request body to the flow when overrunning the timeout", func() {
+				release, read := make(chan struct{}), make(chan []byte, 1)
+
+				p := withPluginPolicy(&mockPlugin{name: "slow", fn: func(ctx sdk.Context) {
+					<-release
+					body, _ := io.ReadAll(ctx.Request().Body)
+					read <- body
+				}}, PluginConfig{Timeout: 20 * time.Millisecond})
+
+				kctx := newContext(httptest.NewRequest(http.MethodPost, "/", strings.NewReader("item")))
+				Expect(pluginFailureReason(runPlugin(p, kctx))).To(Equal(pluginFailureTimeout))
+
+				close(release)
+				Expect(string(<-read)).To(Equal("item"))
+
+				body, err := io.ReadAll(kctx.Request().Body)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(body)).To(Equal("item"))
+			})
+		})
+
+		Context("aggregate plugins", func() {
+			It("rewrite the aggregated data before it is encoded", func() {
+				p := &mockPlugin{name: "wrap", typ: sdk.PluginTypeAggregate, fn: func(ctx sdk.Context) {
+					agg := ctx.(sdk.AggregateContext).Aggregated()
+					agg.Data = []byte(`{"items":` + string(agg.Data) + `}`)
+					agg.Header.Set("X-Wrapped", "yes")
+				}}
+
+				r := newTestRouter([]flow{{
+					path:        "/test/aggregate",
+					method:      http.MethodGet,
+					aggregation: aggregation{strategy: strategyArray},
+					plugins:     []sdk.Plugin{p},
+				}}, &mockScatter{results: []upstreamResponse{{status: http.StatusOK, headers: http.Header{}, body: []byte(`"OK"`)}}}, &defaultAggregator{})
+
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test/aggregate", nil))
+
+				Expect(rec.Code).To(Equal(http.StatusOK))
+				Expect(rec.Header().Get("X-Wrapped")).To(Equal("yes"))
+				Expect(rec.Body.String()).To(ContainSubstring(`"data":{"items":"OK"}`))
+			})
+		})
+
+		Context("response plugins", func() {
+			It("see the individual upstream responses", func() {
+				var seen []sdk.UpstreamResponse
+
+				p := &mockPlugin{name: "inspect", typ: sdk.PluginTypeResponse, fn: func(ctx sdk.Context) {
+					seen = ctx.(sdk.ResponseContext).Upstreams()
+				}}
+
+				r := newTestRouter([]flow{{
+					path:   "/test/upstreams",
+					method: http.MethodGet,
+					upstreams: []upstream{
+						newTestUpstream("http://a", withName("a")),
+						newTestUpstream("http://b", withName("b")),
+					},
+					aggregation: aggregation{strategy: strategyArray, bestEffort: true},
+					plugins:     []sdk.Plugin{p},
+				}}, &mockScatter{results: []upstreamResponse{
+					{status: http.StatusOK, headers: http.Header{}, body: []byte(`"A"`), latency: time.Millisecond},
+					{err: &upstreamError{kind: upstreamTimeout, err: context.DeadlineExceeded}},
+				}}, &defaultAggregator{})
+
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test/upstreams", nil))
+
+				Expect(seen).To(HaveLen(2))
+				Expect(seen[0].Name).To(Equal("a"))
+				Expect(seen[0].Status).To(Equal(http.StatusOK))
+				Expect(string(seen[0].Body)).To(Equal(`"A"`))
+				Expect(seen[0].Latency).To(Equal(time.Millisecond))
+				Expect(seen[1].Name).To(Equal("b"))
+				Expect(seen[1].Err).To(HaveOccurred())
+			})
+		})
+
+		Context("draining", func() {
+			It("asks clients to close the connection", func() {
+				r := newTestRouter([]flow{{
+					path:        "/test/drain",
+					method:      http.MethodGet,
+					aggregation: aggregation{strategy: strategyArray},
+				}}, &mockScatter{results: []upstreamResponse{{status: http.StatusOK, headers: http.Header{}, body: []byte(`"OK"`)}}}, &defaultAggregator{})
+
+				r.Drain()
+
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test/drain", nil))
+
+				Expect(rec.Code).To(Equal(http.StatusOK))
+				Expect(rec.Header().Get("Connection")).To(Equal("close"))
+			})
+		})
+
+		Context("flow timeout", func() {
+			It("sets the request deadline", func() {
+				scatter := &deadlineScatter{}
+
+				r := newTestRouter([]flow{{
+					path:        "/test/timeout",
+					method:      http.MethodGet,
+					timeout:     time.Minute,
+					aggregation: aggregation{strategy: strategyArray},
+				}}, scatter, &defaultAggregator{})
+
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test/timeout", nil))
+
+				Expect(rec.Code).To(Equal(http.StatusOK))
+				Expect(scatter.hasDeadline).To(BeTrue())
+				Expect(scatter.deadline).To(BeTemporally("~", time.Now().Add(time.Minute), time.Second))
+			})
+		})
+	})
+
+	Describe("Shutdown", func() {
+		It("closes plugins and middlewares with the shutdown context", func() {
+			shutdowner := &shutdownPlugin{mockPlugin: mockPlugin{name: "pool"}}
+			wrapped := withPluginPolicy(&shutdownPlugin{mockPlugin: mockPlugin{name: "producer"}}, PluginConfig{OnError: onErrorContinue})
+			closer := &closerMiddleware{}
+
+			r := newTestRouter([]flow{{
+				path:        "/test/shutdown",
+				method:      http.MethodGet,
+				plugins:     []sdk.Plugin{shutdowner, wrapped},
+				middlewares: []sdk.Middleware{closer},
+			}}, &mockScatter{}, &defaultAggregator{})
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			Expect(r.Shutdown(ctx)).To(Succeed())
+
+			Expect(shutdowner.ctx).To(Equal(ctx))
+			Expect(wrapped.(policyPlugin).Plugin.(*shutdownPlugin).ctx).To(Equal(ctx))
+			Expect(closer.closed).To(BeTrue())
 		})
 	})
 
@@ -258,3 +983,25 @@ var _ = Describe("Router", func() {
 		})
 	})
 })
+
+type shutdownPlugin struct {
+	mockPlugin
+
+	ctx context.Context //nolint:containedctx // records the context Shutdown was called with.
+}
+
+func (p *shutdownPlugin) Shutdown(ctx context.Context) error {
+	p.ctx = ctx
+	return nil
+}
+
+type closerMiddleware struct {
+	mockMiddleware
+
+	closed bool
+}
+
+func (m *closerMiddleware) Close() error {
+	m.closed = true
+	return nil
+}
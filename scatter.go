@@ -1,6 +1,8 @@
 package kono
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -19,6 +21,8 @@ import (
 
 const maxBodySize = 5 << 20 // 5 MB
 
+var errRaceLost = errors.New("another upstream responded first")
+
 type scatter interface {
 	scatter(f *flow, original *http.Request) []upstreamResponse
 }
@@ -56,6 +60,14 @@ func (d *defaultScatter) scatter(f *flow, original *http.Request) []upstreamResp
 		return nil
 	}
 
+	if f.sequential {
+		return d.scatterSequential(f, original, body, log)
+	}
+
+	if f.aggregation.strategy == strategyFirst {
+		return d.scatterFirst(f, original, body, log)
+	}
+
 	results := make([]upstreamResponse, len(f.upstreams))
 
 	wg := wgPool.Get().(*sync.WaitGroup)
@@ -74,6 +86,66 @@ func (d *defaultScatter) scatter(f *flow, original *http.Request) []upstreamResp
 	return results
 }
 
+// scatterSequential calls upstreams one after another in configuration order.
+// Each call sees the bodies of the upstreams that already succeeded, so its path
+// may reference them through {upstream.<name>.<field>} templates.
+func (d *defaultScatter) scatterSequential(f *flow, original *http.Request, body []byte, log *zap.Logger) []upstreamResponse {
+	results := make([]upstreamResponse, len(f.upstreams))
+	bodies := make(pipelineResults, len(f.upstreams))
+
+	stageReq := original.WithContext(withPipelineResults(original.Context(), bodies))
+
+	for i, u := range f.upstreams {
+		results[i] = d.callUpstream(f, u, stageReq, body, log)
+
+		if results[i].err == nil {
+			bodies[u.name()] = results[i].body
+		}
+	}
+
+	return results
+}
+
+// scatterFirst races all upstreams and returns as soon as one succeeds, canceling
+// the calls still in flight. Upstreams that had not finished by then are reported
+// as canceled; when every upstream fails, all their errors are returned.
+func (d *defaultScatter) scatterFirst(f *flow, original *http.Request, body []byte, log *zap.Logger) []upstreamResponse {
+	ctx, cancel := context.WithCancel(original.Context())
+	defer cancel()
+
+	raceReq := original.WithContext(ctx)
+
+	type result struct {
+		idx  int
+		resp upstreamResponse
+	}
+
+	// Buffered so that losers finishing after the winner never block.
+	done := make(chan result, len(f.upstreams))
+
+	for i, u := range f.upstreams {
+		go func() {
+			done <- result{idx: i, resp: d.callUpstream(f, u, raceReq, body, log)}
+		}()
+	}
+
+	results := make([]upstreamResponse, len(f.upstreams))
+	for i := range results {
+		results[i] = upstreamResponse{err: &upstreamError{kind: upstreamCanceled, err: errRaceLost}}
+	}
+
+	for range f.upstreams {
+		r := <-done
+		results[r.idx] = r.resp
+
+		if r.resp.err == nil {
+			return results
+		}
+	}
+
+	return results
+}
+
 // readBody consumes and closes original.Body, enforcing maxBodySize.
 // Returns (body, true) on success or (nil, false) on read error or oversized body.
 func (d *defaultScatter) readBody(req *http.Request, log *zap.Logger) ([]byte, bool) {
@@ -137,6 +209,10 @@ func (d *defaultScatter) callUpstream(
 
 	d.metrics.IncUpstreamRequestsTotal(f.path, u.name())
 
+	if hook := d.upstreamPlugins(f, u.name(), log); hook != nil {
+		ctx = withUpstreamHook(ctx, hook)
+	}
+
 	resp := u.call(ctx, original, body)
 	if resp.err != nil {
 		d.metrics.IncUpstreamErrorsTotal(f.path, u.name(), string(resp.err.kind))
@@ -153,5 +229,9 @@ func (d *defaultScatter) callUpstream(
 
 	d.metrics.UpdateUpstreamLatency(f.path, u.name(), start)
 
+	resp.latency = time.Since(start)
+
+	recordUpstream(ctx, u.name(), resp)
+
 	return *resp
 }
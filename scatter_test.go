@@ -15,6 +15,7 @@ import (
 	. "github.com/onsi/gomega"
 
 	"github.com/starwalkn/kono/internal/circuitbreaker"
+	"github.com/starwalkn/kono/sdk"
 )
 
 const defaultParallelUpstreams = 10
@@ -49,6 +50,186 @@ var _ = Describe("Scatter", func() {
 		})
 	})
 
+	Describe("upstream plugins", func() {
+		It("mutate each outgoing request", func() {
+			var mu sync.Mutex
+			seen := map[string]string{}
+
+			handler := func(name string) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					mu.Lock()
+					seen[name] = r.Header.Get("X-Upstream")
+					mu.Unlock()
+
+					_, _ = w.Write([]byte("{}"))
+				}
+			}
+
+			serverA := httptest.NewServer(handler("a"))
+			defer serverA.Close()
+
+			serverB := httptest.NewServer(handler("b"))
+			defer serverB.Close()
+
+			f := newTestFlow([]upstream{
+				newTestUpstream(serverA.URL, withName("a")),
+				newTestUpstream(serverB.URL, withName("b")),
+			}, defaultParallelUpstreams)
+
+			f.plugins = []sdk.Plugin{&mockPlugin{name: "tag", typ: sdk.PluginTypeUpstream, fn: func(ctx sdk.Context) {
+				uctx := ctx.(sdk.UpstreamContext)
+				uctx.Request().Header.Set("X-Upstream", uctx.Upstream())
+			}}}
+
+			results := newTestScatter().scatter(f, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			Expect(results[0].err).To(BeNil())
+			Expect(results[1].err).To(BeNil())
+			Expect(seen).To(Equal(map[string]string{"a": "a", "b": "b"}))
+		})
+
+		It("fail only the upstream they fail on", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("{}"))
+			}))
+			defer server.Close()
+
+			f := newTestFlow([]upstream{
+				newTestUpstream(server.URL, withName("a")),
+				newTestUpstream(server.URL, withName("b")),
+			}, defaultParallelUpstreams)
+
+			f.plugins = []sdk.Plugin{&mockPlugin{name: "guard", typ: sdk.PluginTypeUpstream, fn: func(ctx sdk.Context) {
+				if ctx.(sdk.UpstreamContext).Upstream() == "b" {
+					panic("refusing b")
+				}
+			}}}
+
+			results := newTestScatter().scatter(f, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			Expect(results[0].err).To(BeNil())
+			Expect(results[1].err).NotTo(BeNil())
+			Expect(results[1].err.kind).To(Equal(upstreamInternal))
+		})
+	})
+
+	Describe("sequential flows", func() {
+		It("calls upstreams in order and substitutes earlier responses", func() {
+			users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte(`{"user":{"id":42}}`))
+			}))
+			defer users.Close()
+
+			var ordersPath string
+
+			orders := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ordersPath = r.URL.Path
+				_, _ = w.Write([]byte(`[]`))
+			}))
+			defer orders.Close()
+
+			f := newTestFlow([]upstream{
+				newTestUpstream(users.URL, withName("users")),
+				newTestUpstream(orders.URL, withName("orders"), withPath("/users/{upstream.users.user.id}/orders")),
+			}, defaultParallelUpstreams)
+			f.sequential = true
+
+			results := newTestScatter().scatter(f, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			Expect(results[0].err).To(BeNil())
+			Expect(results[1].err).To(BeNil())
+			Expect(ordersPath).To(Equal("/users/42/orders"))
+		})
+
+		It("fails a stage whose reference cannot be resolved", func() {
+			users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			}))
+			defer users.Close()
+
+			var called bool
+
+			orders := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+				called = true
+			}))
+			defer orders.Close()
+
+			f := newTestFlow([]upstream{
+				newTestUpstream(users.URL, withName("users")),
+				newTestUpstream(orders.URL, withName("orders"), withPath("/users/{upstream.users.user.id}/orders")),
+			}, defaultParallelUpstreams)
+			f.sequential = true
+
+			results := newTestScatter().scatter(f, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			Expect(results[1].err).NotTo(BeNil())
+			Expect(results[1].err.kind).To(Equal(upstreamInternal))
+			Expect(called).To(BeFalse())
+		})
+	})
+
+	Describe("first strategy", func() {
+		It("returns the fastest success and cancels the rest", func() {
+			slowCanceled := make(chan struct{})
+
+			slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+					close(slowCanceled)
+				case <-time.After(2 * time.Second):
+					_, _ = w.Write([]byte("slow"))
+				}
+			}))
+			defer slow.Close()
+
+			fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("fast"))
+			}))
+			defer fast.Close()
+
+			f := newTestFlow([]upstream{
+				newTestUpstream(slow.URL, withTimeout(3*time.Second)),
+				newTestUpstream(fast.URL),
+			}, defaultParallelUpstreams)
+			f.aggregation.strategy = strategyFirst
+
+			start := time.Now()
+			results := newTestScatter().scatter(f, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+			Expect(results[1].err).To(BeNil())
+			Expect(string(results[1].body)).To(Equal("fast"))
+			Expect(results[0].err).NotTo(BeNil())
+			Expect(results[0].err.kind).To(Equal(upstreamCanceled))
+			Eventually(slowCanceled).Should(BeClosed())
+		})
+
+		It("waits for a success after early failures", func() {
+			failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			}))
+			defer failing.Close()
+
+			ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				time.Sleep(50 * time.Millisecond)
+				_, _ = w.Write([]byte("ok"))
+			}))
+			defer ok.Close()
+
+			f := newTestFlow([]upstream{
+				newTestUpstream(failing.URL),
+				newTestUpstream(ok.URL),
+			}, defaultParallelUpstreams)
+			f.aggregation.strategy = strategyFirst
+
+			results := newTestScatter().scatter(f, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			Expect(results[0].err).NotTo(BeNil())
+			Expect(results[0].err.kind).To(Equal(upstreamBadStatus))
+			Expect(results[1].err).To(BeNil())
+		})
+	})
+
 	Describe("forwarding request data", func() {
 		It("forwards POST body to upstream", func() {
 			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,262 @@
+package kono
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/kono/internal/metric"
+	"github.com/starwalkn/kono/sdk"
+)
+
+// Script entry points, shared by the Lua and JavaScript engines. A script
+// defines either or both; each becomes a plugin of the matching phase.
+const (
+	scriptOnRequest  = "on_request"
+	scriptOnResponse = "on_response"
+)
+
+// scriptSourceInline marks a script given in the configuration instead of a file.
+const scriptSourceInline = "inline"
+
+// scriptEntryPoints pairs the entry points with their phase, in the order the
+// plugins are created.
+var scriptEntryPoints = []struct {
+	fn    string
+	phase sdk.PluginType
+}{
+	{scriptOnRequest, sdk.PluginTypeRequest},
+	{scriptOnResponse, sdk.PluginTypeResponse},
+}
+
+// loadScript returns the code of the script sc.
+func loadScript(sc *ScriptConfig) ([]byte, error) {
+	if sc.Source == scriptSourceInline {
+		return []byte(sc.Script), nil
+	}
+
+	src, err := os.ReadFile(sc.Script)
+	if err != nil {
+		return nil, fmt.Errorf("read script: %w", err)
+	}
+
+	return src, nil
+}
+
+// scriptName names the script in errors and logs: its path, or the plugin name
+// for inline scripts.
+func scriptName(plugin string, sc *ScriptConfig) string {
+	if sc.Source == scriptSourceInline {
+		return plugin + " (inline)"
+	}
+
+	return sc.Script
+}
+
+// scriptPool keeps interpreters that have run the script, so a call only pays for
+// the function it invokes. It is shared by the plugins of both phases.
+type scriptPool[T any] struct {
+	size    int
+	newVM   func() (T, error)
+	closeVM func(T)
+	caller  *scriptCaller
+
+	mu     sync.Mutex
+	idle   []T
+	closed bool
+}
+
+func newScriptPool[T any](size int, caller *scriptCaller, newVM func() (T, error), closeVM func(T)) *scriptPool[T] {
+	if size == 0 {
+		size = runtime.GOMAXPROCS(0)
+	}
+
+	return &scriptPool[T]{size: size, newVM: newVM, closeVM: closeVM, caller: caller}
+}
+
+// get returns an idle interpreter, or a new one when all are busy.
+func (p *scriptPool[T]) get() (T, error) {
+	p.mu.Lock()
+
+	if n := len(p.idle); n > 0 {
+		vm := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+
+		return vm, nil
+	}
+
+	p.mu.Unlock()
+
+	return p.newVM()
+}
+
+// put keeps vm for the next call, or closes it when the pool is full or closed.
+func (p *scriptPool[T]) put(vm T) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed || len(p.idle) >= p.size {
+		p.closeVM(vm)
+		return
+	}
+
+	p.idle = append(p.idle, vm)
+}
+
+func (p *scriptPool[T]) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+
+	p.caller.close()
+
+	for _, vm := range p.idle {
+		p.closeVM(vm)
+	}
+
+	p.idle, p.closed = nil, true
+}
+
+// readScriptBody reads the request body for a script and puts it back for the
+// upstreams. A body over maxBodySize is left unread and reported as not ok.
+func readScriptBody(req *http.Request, maxBodySize int64, log *zap.Logger) ([]byte, bool, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true, nil
+	}
+
+	head, err := io.ReadAll(io.LimitReader(req.Body, maxBodySize+1))
+	if err != nil {
+		return nil, false, fmt.Errorf("read request body: %w", err)
+	}
+
+	if int64(len(head)) > maxBodySize {
+		log.Debug("request body exceeds max_body_size, not passed to the script", zap.Int64("max_body_size", maxBodySize))
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(head), req.Body), req.Body}
+
+		return nil, false, nil
+	}
+
+	_ = req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(head))
+
+	return head, true, nil
+}
+
+// readScriptResponseBody reads the response body for a script and puts it back.
+func readScriptResponseBody(resp *http.Response) ([]byte, error) {
+	if resp.Body == nil {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response body: %w", err)
+	}
+
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
+
+// scriptCall is what a script passes to kono.call: changes to the copy of the
+// client request sent to the upstream.
+type scriptCall struct {
+	method  string
+	query   *string
+	headers map[string]string
+	body    []byte
+}
+
+// scriptCaller performs the auxiliary upstream calls of kono.call. They go
+// through the same upstream implementation as flow upstreams, so timeouts,
+// retries, circuit breakers and metrics apply.
+type scriptCaller struct {
+	upstreams map[string]upstream
+	metrics   *metric.Metrics
+	log       *zap.Logger
+}
+
+func newScriptCaller(cfgs []UpstreamConfig, deps pluginDeps, log *zap.Logger) (*scriptCaller, error) {
+	upstreams, err := initUpstreams(cfgs, deps.trustedProxies, deps.metrics, log)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &scriptCaller{upstreams: make(map[string]upstream, len(upstreams)), metrics: deps.metrics, log: log}
+
+	for i, u := range upstreams {
+		c.upstreams[cfgs[i].Name] = u
+	}
+
+	return c, nil
+}
+
+// call sends the client request of ctx, changed by sc, to the upstream name. A
+// failed call is returned as the upstream error; errors in the arguments are
+// returned as is.
+func (c *scriptCaller) call(ctx context.Context, name string, sc scriptCall) (*upstreamResponse, error) {
+	u, ok := c.upstreams[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown upstream %s", name)
+	}
+
+	client := scriptRequestFromContext(ctx)
+	if client == nil {
+		return nil, errors.New("kono.call is only available while handling a request")
+	}
+
+	// The call sees the client request with the script's changes; the upstream
+	// config picks what of it is forwarded, as for flow upstreams.
+	original := client.Clone(ctx)
+
+	if sc.method != "" {
+		original.Method = sc.method
+	}
+
+	if sc.query != nil {
+		original.URL.RawQuery = *sc.query
+	}
+
+	for k, v := range sc.headers {
+		original.Header.Set(k, v)
+	}
+
+	route := routeFromContext(ctx)
+	start := time.Now()
+
+	c.metrics.IncUpstreamRequestsTotal(route, name)
+
+	resp := u.call(ctx, original, sc.body)
+
+	c.metrics.UpdateUpstreamLatency(route, name, start)
+
+	if resp.err != nil {
+		c.metrics.IncUpstreamErrorsTotal(route, name, string(resp.err.kind))
+		c.log.Error("script upstream call failed", zap.String("name", name), zap.Error(resp.err.Unwrap()))
+	}
+
+	return resp, nil
+}
+
+func (c *scriptCaller) close() {
+	for _, u := range c.upstreams {
+		_ = closeComponent(context.Background(), u)
+	}
+}
@@ -0,0 +1,41 @@
+package sdk
+
+import (
+	"net/http"
+	"time"
+)
+
+// UpstreamResponse is the outcome of a single upstream call handed to an Aggregator.
+type UpstreamResponse struct {
+	// Name is the configured upstream name.
+	Name   string
+	Status int
+	Header http.Header
+	Body   []byte
+	// Latency is the time spent on the call, including queueing and retries.
+	Latency time.Duration
+	// Err is non-nil when the upstream call failed; Body is then empty.
+	Err error
+}
+
+// Aggregation describes the flow settings an Aggregator runs under.
+type Aggregation struct {
+	// BestEffort reports whether failed upstreams may be skipped. When false,
+	// the gateway answers with an error before the Aggregator is invoked.
+	BestEffort bool
+}
+
+// AggregatedResponse is the response body and headers produced by an Aggregator.
+type AggregatedResponse struct {
+	Data   []byte
+	Header http.Header
+}
+
+// Aggregator combines upstream responses for flows using the "custom" aggregation strategy.
+// Aggregators are either registered in-process or loaded as Go shared objects (.so)
+// exporting a NewAggregator function.
+type Aggregator interface {
+	Name() string
+	Init(cfg map[string]interface{}) error
+	Aggregate(responses []UpstreamResponse, agg Aggregation) (AggregatedResponse, error)
+}
@@ -0,0 +1,18 @@
+package sdk
+
+import "context"
+
+type claimsKey struct{}
+
+// WithClaims returns a copy of ctx carrying the verified claims of the caller.
+// Authentication middlewares call it so the gateway and later middlewares can key
+// on who the caller is without parsing credentials again.
+func WithClaims(ctx context.Context, claims map[string]any) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims stored by WithClaims, or nil.
+func ClaimsFromContext(ctx context.Context) map[string]any {
+	claims, _ := ctx.Value(claimsKey{}).(map[string]any)
+	return claims
+}
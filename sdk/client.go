@@ -0,0 +1,40 @@
+package sdk
+
+import "context"
+
+type (
+	clientIPKey struct{}
+	geoKey      struct{}
+)
+
+// Geo is the location of the client resolved from its IP address. Fields the
+// database has no value for are empty.
+type Geo struct {
+	Country string // ISO 3166-1 alpha-2 code.
+	Region  string // ISO 3166-2 subdivision code, without the country prefix.
+	City    string // English city name.
+}
+
+// WithClientIP returns a copy of ctx carrying the client IP. The gateway sets it
+// from the connection and trusted forwarding headers before middlewares run.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// ClientIPFromContext returns the client IP stored by WithClientIP, or "".
+func ClientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+// WithGeo returns a copy of ctx carrying the client location. Geolocation
+// middlewares call it so that plugins and rate limit keys can use it.
+func WithGeo(ctx context.Context, geo Geo) context.Context {
+	return context.WithValue(ctx, geoKey{}, geo)
+}
+
+// GeoFromContext returns the location stored by WithGeo and whether one was set.
+func GeoFromContext(ctx context.Context) (Geo, bool) {
+	geo, ok := ctx.Value(geoKey{}).(Geo)
+	return geo, ok
+}
@@ -9,4 +9,38 @@ type Context interface {
 	Response() *http.Response
 	SetRequest(req *http.Request)
 	SetResponse(resp *http.Response)
+
+	// PathParam returns the value captured for the named segment of the flow
+	// path template (e.g. "id" for /users/{id}), or "" if it was not captured.
+	PathParam(name string) string
+	// PathParams returns a copy of all captured path parameters.
+	PathParams() map[string]string
+}
+
+// UpstreamContext is the Context of PluginTypeUpstream plugins. Request is the
+// request about to be sent to the upstream; SetRequest replaces it.
+type UpstreamContext interface {
+	Context
+
+	// Upstream returns the configured name of the upstream being called.
+	Upstream() string
+}
+
+// ResponseContext is the Context of PluginTypeResponse plugins. It exposes
+// the individual upstream results the response was aggregated from.
+type ResponseContext interface {
+	Context
+
+	// Upstreams returns one entry per upstream of the flow, in configuration
+	// order. Entries must not be modified. It is nil for cached responses.
+	Upstreams() []UpstreamResponse
+}
+
+// AggregateContext is the Context of PluginTypeAggregate plugins. Changes to
+// the Data and Header of Aggregated end up in the client response; Data is
+// nil when the flow failed and only errors are returned.
+type AggregateContext interface {
+	Context
+
+	Aggregated() *AggregatedResponse
 }
@@ -1,6 +1,9 @@
 package sdk
 
-import "net/http"
+import (
+	"context"
+	"net/http"
+)
 
 // Middleware wraps an HTTP handler and executes within the request lifecycle.
 // Middlewares are applied per-flow in the order they are defined.
@@ -10,6 +13,32 @@ type Middleware interface {
 	Handler(next http.Handler) http.Handler
 }
 
+// Closer is implemented by middlewares and plugins that hold resources. Kono
+// calls Close when the router they belong to is shut down or replaced by a
+// hot reload.
 type Closer interface {
 	Close() error
 }
+
+// Shutdowner is the context-aware form of Closer and takes precedence over it.
+// ctx expires when the gateway stops waiting; implementations should flush
+// what they can by then (producers, pools, caches) and return ctx.Err()
+// otherwise.
+type Shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// ConfigSchemaProvider is implemented by middlewares, plugins and aggregators that
+// describe their config map as a JSON Schema object. `kono config schema`
+// includes it for the components compiled into the gateway.
+type ConfigSchemaProvider interface {
+	ConfigSchema() map[string]any
+}
+
+// ConfigValidator is implemented by middlewares, plugins and aggregators that
+// check their config map, e.g. for unknown keys or values of the wrong type.
+// The gateway calls ValidateConfig before Init while building the router, so
+// mistakes fail startup and `kono validate --dry-run`.
+type ConfigValidator interface {
+	ValidateConfig(cfg map[string]interface{}) error
+}
@@ -1,5 +1,10 @@
 package sdk
 
+import (
+	"fmt"
+	"net/http"
+)
+
 // PluginType defines when a plugin executes in the request lifecycle.
 type PluginType int
 
@@ -8,6 +13,13 @@ const (
 	PluginTypeRequest PluginType = iota
 	// PluginTypeResponse runs after aggregation. Can modify response headers and body.
 	PluginTypeResponse PluginType = iota
+	// PluginTypeUpstream runs before every call to each HTTP upstream, retries and
+	// hedges included. Its Context is an UpstreamContext whose Request is the
+	// outgoing upstream request. A failure fails that upstream call only.
+	PluginTypeUpstream PluginType = iota
+	// PluginTypeAggregate runs after aggregation, before the response envelope is
+	// encoded. Its Context is an AggregateContext.
+	PluginTypeAggregate PluginType = iota
 )
 
 func (pt PluginType) String() string {
@@ -16,6 +28,10 @@ func (pt PluginType) String() string {
 		return "request"
 	case PluginTypeResponse:
 		return "response"
+	case PluginTypeUpstream:
+		return "upstream"
+	case PluginTypeAggregate:
+		return "aggregate"
 	default:
 		return "unknown"
 	}
@@ -38,3 +54,16 @@ type Plugin interface {
 	Type() PluginType
 	Execute(ctx Context) error
 }
+
+// AbortError ends the flow when returned from Execute: the client is answered
+// with Status, Header and Body instead of an internal error, and later plugins
+// and the upstreams are skipped. A zero Status answers 403 Forbidden.
+type AbortError struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+func (e *AbortError) Error() string {
+	return fmt.Sprintf("plugin aborted the request with status %d", e.Status)
+}
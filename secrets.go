@@ -0,0 +1,139 @@
+package kono
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/starwalkn/kono/internal/vault"
+)
+
+const secretResolveTimeout = 10 * time.Second
+
+// SecretProvider resolves ${<scheme>:<ref>} references in the configuration.
+// Values are re-resolved every secrets.refresh_interval, and a change reloads
+// the router.
+type SecretProvider interface {
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+var secretProviders = struct {
+	mu        sync.RWMutex
+	providers map[string]SecretProvider
+}{providers: map[string]SecretProvider{
+	"file":  fileSecrets{},
+	"vault": &vaultSecrets{},
+}}
+
+// RegisterSecretProvider makes a secret provider available to configuration
+// references under scheme. It must be called before the configuration is loaded;
+// registering the same scheme twice replaces the previous provider.
+func RegisterSecretProvider(scheme string, p SecretProvider) {
+	secretProviders.mu.Lock()
+	defer secretProviders.mu.Unlock()
+
+	secretProviders.providers[scheme] = p
+}
+
+func lookupSecretProvider(scheme string) (SecretProvider, bool) {
+	secretProviders.mu.RLock()
+	defer secretProviders.mu.RUnlock()
+
+	p, ok := secretProviders.providers[scheme]
+
+	return p, ok
+}
+
+// fileSecrets reads ${file:/path}, dropping the trailing newline.
+type fileSecrets struct{}
+
+func (fileSecrets) Resolve(_ context.Context, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read secret file: %w", err)
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// vaultSecrets reads ${vault:<path>#<field>}, e.g. ${vault:secret/data/kono#jwt_key}.
+// The client is configured from VAULT_ADDR and VAULT_TOKEN on first use; until
+// they are valid, every load tries again.
+type vaultSecrets struct {
+	mu     sync.Mutex
+	client *vault.Client
+}
+
+func (v *vaultSecrets) Resolve(ctx context.Context, ref string) (string, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || field == "" {
+		return "", fmt.Errorf("vault reference %q must be <path>#<field>", ref)
+	}
+
+	client, err := v.vaultClient()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := client.Read(ctx, path)
+	if err != nil {
+		return "", err
+	}
+
+	switch value := data[field].(type) {
+	case string:
+		return value, nil
+	case nil:
+		return "", fmt.Errorf("vault secret %s has no field %q", path, field)
+	default:
+		return fmt.Sprint(value), nil
+	}
+}
+
+func (v *vaultSecrets) vaultClient() (*vault.Client, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.client == nil {
+		opts, err := vault.OptionsFromEnv()
+		if err != nil {
+			return nil, err
+		}
+
+		v.client = vault.New(opts)
+	}
+
+	return v.client, nil
+}
+
+// secretDigests remembers a digest of every provider reference a configuration
+// resolved, to detect rotation without keeping the values around twice.
+type secretDigests map[string][sha256.Size]byte
+
+// SecretsChanged resolves the provider references of the configuration again and
+// reports whether any value differs from the one it was loaded with.
+func (c Config) SecretsChanged(ctx context.Context) (bool, error) {
+	for ref, digest := range c.secrets {
+		scheme, rest, _ := strings.Cut(ref, ":")
+
+		p, ok := lookupSecretProvider(scheme)
+		if !ok {
+			return false, fmt.Errorf("secret provider %q is not registered", scheme)
+		}
+
+		value, err := p.Resolve(ctx, rest)
+		if err != nil {
+			return false, fmt.Errorf("resolve ${%s}: %w", ref, err)
+		}
+
+		if sha256.Sum256([]byte(value)) != digest {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,131 @@
+package kono
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
+)
+
+// mapSecrets is a SecretProvider backed by a map that tests can rotate.
+type mapSecrets struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (m *mapSecrets) Resolve(_ context.Context, ref string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.values[ref], nil
+}
+
+func (m *mapSecrets) set(ref, value string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.values[ref] = value
+}
+
+var _ = Describe("secrets", func() {
+	Describe("vault provider", func() {
+		var vault *httptest.Server
+
+		BeforeEach(func() {
+			vault = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("X-Vault-Token") != "root" {
+					w.WriteHeader(http.StatusForbidden)
+					_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+
+					return
+				}
+
+				switch r.URL.Path {
+				case "/v1/secret/data/kono":
+					_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+						"data":     map[string]any{"jwt_key": "s3cret", "port": 8080},
+						"metadata": map[string]any{"version": 3},
+					}})
+				case "/v1/kv/kono":
+					_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"password": "v1-secret"}})
+				default:
+					w.WriteHeader(http.StatusNotFound)
+					_, _ = w.Write([]byte(`{"errors":[]}`))
+				}
+			}))
+			DeferCleanup(vault.Close)
+
+			GinkgoT().Setenv("VAULT_ADDR", vault.URL)
+			GinkgoT().Setenv("VAULT_TOKEN", "root")
+		})
+
+		It("reads fields from KV v2 and v1 engines", func() {
+			p := &vaultSecrets{}
+
+			Expect(p.Resolve(context.Background(), "secret/data/kono#jwt_key")).To(Equal("s3cret"))
+			Expect(p.Resolve(context.Background(), "secret/data/kono#port")).To(Equal("8080"))
+			Expect(p.Resolve(context.Background(), "kv/kono#password")).To(Equal("v1-secret"))
+		})
+
+		It("reports missing fields, paths and bad references", func() {
+			p := &vaultSecrets{}
+
+			_, err := p.Resolve(context.Background(), "secret/data/kono#missing")
+			Expect(err).To(MatchError(ContainSubstring(`no field "missing"`)))
+
+			_, err = p.Resolve(context.Background(), "secret/data/other#key")
+			Expect(err).To(MatchError(ContainSubstring("404")))
+
+			_, err = p.Resolve(context.Background(), "secret/data/kono")
+			Expect(err).To(MatchError(ContainSubstring("<path>#<field>")))
+		})
+
+		It("requires VAULT_ADDR and retries once it is set", func() {
+			addr := os.Getenv("VAULT_ADDR")
+			GinkgoT().Setenv("VAULT_ADDR", "")
+
+			p := &vaultSecrets{}
+
+			_, err := p.Resolve(context.Background(), "secret/data/kono#jwt_key")
+			Expect(err).To(MatchError(ContainSubstring("VAULT_ADDR")))
+
+			GinkgoT().Setenv("VAULT_ADDR", addr)
+			Expect(p.Resolve(context.Background(), "secret/data/kono#jwt_key")).To(Equal("s3cret"))
+		})
+	})
+
+	Describe("rotation", func() {
+		It("detects changed provider values", func() {
+			provider := &mapSecrets{values: map[string]string{"api-key": "v1"}}
+			RegisterSecretProvider("test", provider)
+
+			var doc yaml.Node
+			Expect(yaml.Unmarshal([]byte("key: ${test:api-key}"), &doc)).To(Succeed())
+
+			in := &interpolator{ctx: context.Background(), secrets: make(secretDigests)}
+			Expect(in.node(&doc)).To(Succeed())
+
+			cfg := Config{secrets: in.secrets}
+			Expect(cfg.secrets).To(HaveKeyWithValue("test:api-key", sha256.Sum256([]byte("v1"))))
+
+			Expect(cfg.SecretsChanged(context.Background())).To(BeFalse())
+
+			provider.set("api-key", "v2")
+			Expect(cfg.SecretsChanged(context.Background())).To(BeTrue())
+		})
+
+		It("rejects unknown schemes", func() {
+			in := &interpolator{ctx: context.Background()}
+
+			_, err := in.interpolate("${nope:thing}")
+			Expect(err).To(MatchError(ContainSubstring(`"nope" is not registered`)))
+		})
+	})
+})
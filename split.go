@@ -0,0 +1,97 @@
+package kono
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/rand/v2"
+	"net/http"
+)
+
+// hostSplit divides the traffic of an upstream between weighted host groups.
+type hostSplit struct {
+	groups []hostGroup
+	total  int
+	key    hashKey
+}
+
+type hostGroup struct {
+	name   string
+	weight int
+	set    *hostSet
+}
+
+func newHostSplit(groups []HostGroupConfig, key hashKey, build func([]string) *hostSet) (*hostSplit, error) {
+	split := &hostSplit{key: key}
+	seen := make(map[string]struct{}, len(groups))
+
+	for _, g := range groups {
+		if _, dup := seen[g.Name]; dup {
+			return nil, fmt.Errorf("host group %q is declared more than once", g.Name)
+		}
+
+		seen[g.Name] = struct{}{}
+
+		split.groups = append(split.groups, hostGroup{name: g.Name, weight: g.Weight, set: build(g.Hosts)})
+		split.total += g.Weight
+	}
+
+	if split.total == 0 {
+		return nil, errors.New("host groups need a positive total weight")
+	}
+
+	return split, nil
+}
+
+// pick returns the host set of the group the request falls into. Requests with a
+// split key always land in the same group for unchanged weights.
+func (s *hostSplit) pick(req *http.Request) *hostSet {
+	var n int
+
+	if key := requestKey(req, s.key); key != "" {
+		n = int(crc32.ChecksumIEEE([]byte(key)) % uint32(s.total)) //nolint:gosec // total is positive
+	} else {
+		n = rand.IntN(s.total) //nolint:gosec // traffic splitting needs no cryptographic randomness
+	}
+
+	for _, g := range s.groups {
+		if n < g.weight {
+			return g.set
+		}
+
+		n -= g.weight
+	}
+
+	return s.groups[len(s.groups)-1].set
+}
+
+// withWeights returns a copy of the split using weights; groups it does not list
+// get weight zero. The host sets, and with them balancing state, are shared.
+func (s *hostSplit) withWeights(weights map[string]int) (*hostSplit, error) {
+	next := &hostSplit{key: s.key, groups: make([]hostGroup, len(s.groups))}
+	known := make(map[string]struct{}, len(s.groups))
+
+	for i, g := range s.groups {
+		known[g.name] = struct{}{}
+
+		g.weight = weights[g.name]
+		if g.weight < 0 || g.weight > 100 {
+			return nil, fmt.Errorf("weight of group %q must be between 0 and 100", g.name)
+		}
+
+		next.groups[i] = g
+		next.total += g.weight
+	}
+
+	for name := range weights {
+		if _, ok := known[name]; !ok {
+			return nil, fmt.Errorf("unknown host group %q", name)
+		}
+	}
+
+	if next.total == 0 {
+		return nil, errors.New("host groups need a positive total weight")
+	}
+
+	return next, nil
+}
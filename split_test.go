@@ -0,0 +1,105 @@
+package kono
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("hostSplit", func() {
+	newSplit := func(key hashKey, groups ...HostGroupConfig) *hostSplit {
+		up := newTestUpstream("")
+
+		split, err := newHostSplit(groups, key, up.newHostSet)
+		Expect(err).NotTo(HaveOccurred())
+
+		return split
+	}
+
+	stable := HostGroupConfig{Name: "stable", Weight: 95, Hosts: []string{"http://stable"}}
+	canary := HostGroupConfig{Name: "canary", Weight: 5, Hosts: []string{"http://canary"}}
+
+	It("splits traffic by weight", func() {
+		split := newSplit(hashKey{}, stable, canary)
+
+		counts := make(map[string]int)
+		for range 10000 {
+			counts[split.pick(httptest.NewRequest(http.MethodGet, "/", nil)).hosts[0]]++
+		}
+
+		Expect(counts["http://canary"]).To(BeNumerically("~", 500, 150))
+	})
+
+	It("keeps a client in one group with a split key", func() {
+		split := newSplit(hashKey{source: hashKeyHeader, name: "X-User"}, stable, canary)
+
+		for i := range 50 {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-User", "user-"+strconv.Itoa(i))
+
+			first := split.pick(req)
+			for range 5 {
+				Expect(split.pick(req)).To(BeIdenticalTo(first))
+			}
+		}
+	})
+
+	It("never picks a group without weight", func() {
+		split := newSplit(hashKey{}, HostGroupConfig{Name: "blue", Weight: 100, Hosts: []string{"http://blue"}},
+			HostGroupConfig{Name: "green", Weight: 0, Hosts: []string{"http://green"}})
+
+		for range 100 {
+			Expect(split.pick(httptest.NewRequest(http.MethodGet, "/", nil)).hosts).To(Equal([]string{"http://blue"}))
+		}
+	})
+
+	It("rejects duplicate groups and a zero total weight", func() {
+		up := newTestUpstream("")
+
+		_, err := newHostSplit([]HostGroupConfig{stable, stable}, hashKey{}, up.newHostSet)
+		Expect(err).To(MatchError(ContainSubstring("declared more than once")))
+
+		_, err = newHostSplit([]HostGroupConfig{{Name: "a", Hosts: []string{"http://a"}}}, hashKey{}, up.newHostSet)
+		Expect(err).To(MatchError(ContainSubstring("positive total weight")))
+	})
+
+	It("routes upstream calls to the picked group", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(`{"group":"canary"}`))
+		}))
+		defer server.Close()
+
+		up := newTestUpstream("")
+		split, err := newHostSplit([]HostGroupConfig{
+			{Name: "stable", Weight: 0, Hosts: []string{"http://127.0.0.1:1"}},
+			{Name: "canary", Weight: 100, Hosts: []string{server.URL}},
+		}, hashKey{}, up.newHostSet)
+		Expect(err).NotTo(HaveOccurred())
+		up.split.Store(split)
+
+		resp := up.call(context.Background(), httptest.NewRequest(http.MethodGet, "/", nil), nil)
+		Expect(resp.err).To(BeNil())
+		Expect(string(resp.body)).To(Equal(`{"group":"canary"}`))
+	})
+})
+
+var _ = Describe("buildUpstream with groups", func() {
+	It("builds a split upstream", func() {
+		up, err := buildUpstream(UpstreamConfig{
+			Name:     "users",
+			Groups:   []HostGroupConfig{{Name: "stable", Weight: 90, Hosts: []string{"http://a"}}, {Name: "canary", Weight: 10, Hosts: []string{"http://b"}}},
+			SplitKey: &HashKeyConfig{Source: "cookie", Name: "sid"},
+		}, nil, testMetrics, zap.NewNop())
+		Expect(err).NotTo(HaveOccurred())
+
+		split := up.(*httpUpstream).split.Load()
+		Expect(split).NotTo(BeNil())
+		Expect(split.key).To(Equal(hashKey{source: hashKeyCookie, name: "sid"}))
+		Expect(split.total).To(Equal(100))
+	})
+})
@@ -1,18 +1,40 @@
 package kono
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"plugin"
+	"sync"
 
 	"go.uber.org/zap"
 )
 
-func loadSymbol[T any](path, symbol string, log *zap.Logger) (T, error) {
+// sigSuffix names the detached Ed25519 signature of a shared object: the
+// signature of plugins/foo.so is read from plugins/foo.so.sig.
+const sigSuffix = ".sig"
+
+func loadSymbol[T any](path, symbol string, verify VerifyConfig, log *zap.Logger) (T, error) {
 	var zero T
 
 	log = log.With(zap.String("path", path))
 
+	verified, err := verifyObject(path, verify)
+	if err != nil {
+		return zero, fmt.Errorf("verify plugin: %w", err)
+	}
+
+	if verified != "" {
+		path = verified
+	}
+
 	p, err := plugin.Open(path)
 	if err != nil {
 		return zero, fmt.Errorf("open plugin: %w", err)
@@ -34,3 +56,114 @@ func loadSymbol[T any](path, symbol string, log *zap.Logger) (T, error) {
 
 	return pl, nil
 }
+
+// verifyObject checks the shared object at path against the pinned digest and
+// signing key before it is opened. The file is read once for both checks and the
+// bytes that passed are written to a private directory; verifyObject returns the
+// path of that copy, so a file swapped in after the check is never opened. It
+// returns "" when nothing is pinned.
+func verifyObject(path string, verify VerifyConfig) (string, error) {
+	if verify.SHA256 == "" && verify.PublicKey == "" {
+		return "", nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read shared object: %w", err)
+	}
+
+	if verify.SHA256 != "" {
+		want, decodeErr := hex.DecodeString(verify.SHA256)
+		if decodeErr != nil {
+			return "", fmt.Errorf("decode sha256: %w", decodeErr)
+		}
+
+		got := sha256.Sum256(data)
+		if subtle.ConstantTimeCompare(got[:], want) != 1 {
+			return "", fmt.Errorf("sha256 mismatch: got %x", got)
+		}
+	}
+
+	if verify.PublicKey != "" {
+		key, decodeErr := base64.StdEncoding.DecodeString(verify.PublicKey)
+		if decodeErr != nil || len(key) != ed25519.PublicKeySize {
+			return "", errors.New("public_key must be a base64 Ed25519 public key")
+		}
+
+		sig, readErr := readSignature(path + sigSuffix)
+		if readErr != nil {
+			return "", readErr
+		}
+
+		if !ed25519.Verify(key, data, sig) {
+			return "", errors.New("signature does not match public_key")
+		}
+	}
+
+	return privateCopy(path, data)
+}
+
+// verifiedCopies holds the verified shared objects, in a directory only this
+// user can access. Copies are kept for the life of the process and reused by
+// digest: plugin.Open refuses to load the same plugin from a second path, as a
+// reload would otherwise do.
+var verifiedCopies = struct {
+	mu    sync.Mutex
+	dir   string
+	paths map[[sha256.Size]byte]string
+}{paths: make(map[[sha256.Size]byte]string)}
+
+// privateCopy writes data to the private directory under the name of path and
+// returns the copy's path.
+func privateCopy(path string, data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+
+	verifiedCopies.mu.Lock()
+	defer verifiedCopies.mu.Unlock()
+
+	if dst, ok := verifiedCopies.paths[sum]; ok {
+		return dst, nil
+	}
+
+	if verifiedCopies.dir == "" {
+		dir, err := os.MkdirTemp("", "kono-plugins-")
+		if err != nil {
+			return "", fmt.Errorf("copy verified object: %w", err)
+		}
+
+		if err = os.Chmod(dir, 0o700); err != nil {
+			return "", fmt.Errorf("copy verified object: %w", err)
+		}
+
+		verifiedCopies.dir = dir
+	}
+
+	dst := filepath.Join(verifiedCopies.dir, hex.EncodeToString(sum[:8])+"-"+filepath.Base(path))
+	if err := os.WriteFile(dst, data, 0o500); err != nil {
+		return "", fmt.Errorf("copy verified object: %w", err)
+	}
+
+	verifiedCopies.paths[sum] = dst
+
+	return dst, nil
+}
+
+// readSignature reads a detached signature, either the raw 64 bytes or their
+// base64 encoding.
+func readSignature(path string) ([]byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read signature: %w", err)
+	}
+
+	if len(raw) == ed25519.SignatureSize {
+		return raw, nil
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(raw)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("signature %s is not a raw or base64 Ed25519 signature", path)
+	}
+
+	return sig, nil
+}
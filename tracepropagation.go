@@ -0,0 +1,28 @@
+package kono
+
+import (
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/starwalkn/kono/internal/tracing"
+)
+
+var defaultTracePropagationFormats = []string{tracing.FormatTraceContext}
+
+// compileTracePropagation returns the propagator writing the trace headers of a
+// flow's upstream requests. A disabled config writes none.
+func compileTracePropagation(cfg *TracePropagationConfig) (propagation.TextMapPropagator, error) {
+	if cfg == nil {
+		return tracing.NewPropagator(defaultTracePropagationFormats)
+	}
+
+	if cfg.Disabled {
+		return tracing.NewPropagator(nil)
+	}
+
+	formats := cfg.Formats
+	if len(formats) == 0 {
+		formats = defaultTracePropagationFormats
+	}
+
+	return tracing.NewPropagator(formats)
+}
@@ -0,0 +1,105 @@
+package kono
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("trace propagation", func() {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	var (
+		backend  *httptest.Server
+		received http.Header
+	)
+
+	BeforeEach(func() {
+		backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r.Header.Clone()
+			_, _ = w.Write([]byte(`{}`))
+		}))
+		DeferCleanup(backend.Close)
+	})
+
+	// serve sends req through a flow whose upstream is the backend, with the given
+	// routing and flow settings.
+	serve := func(routing, flow *TracePropagationConfig, req *http.Request) {
+		cfg := RoutingConfigSet{
+			Service: ServiceConfig{Name: "kono-test"},
+			Routing: RoutingConfig{
+				Flows: []FlowConfig{{
+					Path:              "/items",
+					Method:            http.MethodGet,
+					TracePropagation:  flow,
+					ParallelUpstreams: 1,
+					Aggregation:       &AggregationConfig{Strategy: "array"},
+					Upstreams: []UpstreamConfig{{
+						Name:    "items",
+						Hosts:   AddrList{backend.URL},
+						Path:    "/items",
+						Method:  http.MethodGet,
+						Timeout: time.Second,
+					}},
+				}},
+			},
+		}
+
+		if routing != nil {
+			cfg.Routing.TracePropagation = *routing
+		}
+
+		bundle, err := NewRouter(context.Background(), cfg, zap.NewNop())
+		Expect(err).NotTo(HaveOccurred())
+
+		rec := httptest.NewRecorder()
+		bundle.Router.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+	}
+
+	It("continues an incoming B3 trace as traceparent", func() {
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set("b3", traceID+"-00f067aa0ba902b7-1")
+
+		serve(nil, nil, req)
+
+		Expect(received.Get("Traceparent")).To(HavePrefix("00-" + traceID + "-"))
+		Expect(received.Get("b3")).To(BeEmpty())
+	})
+
+	It("starts a trace for requests without one", func() {
+		serve(nil, nil, httptest.NewRequest(http.MethodGet, "/items", nil))
+
+		parts := strings.Split(received.Get("Traceparent"), "-")
+		Expect(parts).To(HaveLen(4))
+		Expect(parts[1]).NotTo(Equal(strings.Repeat("0", 32)))
+		Expect(parts[3]).To(Equal("00"), "a started trace is not sampled")
+	})
+
+	It("writes the formats of the flow", func() {
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set("Traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+
+		serve(&TracePropagationConfig{Disabled: true}, &TracePropagationConfig{Formats: []string{"b3multi"}}, req)
+
+		Expect(received.Get("X-B3-Traceid")).To(Equal(traceID))
+		Expect(received.Get("X-B3-Sampled")).To(Equal("1"))
+		Expect(received.Get("Traceparent")).To(BeEmpty())
+	})
+
+	It("sends no trace headers when disabled", func() {
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set("Traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+
+		serve(&TracePropagationConfig{Disabled: true}, nil, req)
+
+		Expect(received.Get("Traceparent")).To(BeEmpty())
+		Expect(received.Get("b3")).To(BeEmpty())
+	})
+})
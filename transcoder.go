@@ -0,0 +1,372 @@
+package kono
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// httpRuleVarPattern matches path template variables such as {id}, {user.id} or {name=projects/*}.
+var httpRuleVarPattern = regexp.MustCompile(`\{([a-zA-Z0-9_.]+)(?:=[^}]*)?\}`)
+
+// transcodeRule describes how one gRPC method maps onto an HTTP request.
+type transcodeRule struct {
+	method     protoreflect.MethodDescriptor
+	httpMethod string
+	path       string // google.api.http path template.
+	body       string // "*", a top-level field name, or "" for no body.
+}
+
+type grpcTranscoder struct {
+	rules   map[string]transcodeRule // keyed by "/pkg.Service/Method".
+	handler http.Handler
+	log     *zap.Logger
+}
+
+// NewGRPCHandler returns a gRPC stream handler that transcodes unary calls into HTTP
+// requests served by handler, typically the gateway Router. Methods are taken from
+// the descriptor set and routed by their google.api.http annotations; methods
+// without an annotation are answered with Unimplemented.
+//
+// The handler is meant to be installed with grpc.UnknownServiceHandler.
+func NewGRPCHandler(cfg GRPCServerConfig, handler http.Handler, log *zap.Logger) (grpc.StreamHandler, error) {
+	files, err := loadDescriptorFiles(cfg.DescriptorSet)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &grpcTranscoder{
+		rules:   make(map[string]transcodeRule),
+		handler: handler,
+		log:     log,
+	}
+
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		services := fd.Services()
+		for i := range services.Len() {
+			methods := services.Get(i).Methods()
+			for j := range methods.Len() {
+				if rule, ok := compileTranscodeRule(methods.Get(j)); ok {
+					t.rules["/"+string(services.Get(i).FullName())+"/"+string(rule.method.Name())] = rule
+				}
+			}
+		}
+
+		return true
+	})
+
+	if len(t.rules) == 0 {
+		return nil, errors.New("descriptor set has no unary methods with google.api.http annotations")
+	}
+
+	return t.handle, nil
+}
+
+func compileTranscodeRule(md protoreflect.MethodDescriptor) (transcodeRule, bool) {
+	if md.IsStreamingClient() || md.IsStreamingServer() || md.Options() == nil {
+		return transcodeRule{}, false
+	}
+
+	rule, ok := proto.GetExtension(md.Options(), annotations.E_Http).(*annotations.HttpRule)
+	if !ok || rule == nil {
+		return transcodeRule{}, false
+	}
+
+	tr := transcodeRule{method: md, body: rule.GetBody()}
+
+	switch p := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		tr.httpMethod, tr.path = http.MethodGet, p.Get
+	case *annotations.HttpRule_Post:
+		tr.httpMethod, tr.path = http.MethodPost, p.Post
+	case *annotations.HttpRule_Put:
+		tr.httpMethod, tr.path = http.MethodPut, p.Put
+	case *annotations.HttpRule_Patch:
+		tr.httpMethod, tr.path = http.MethodPatch, p.Patch
+	case *annotations.HttpRule_Delete:
+		tr.httpMethod, tr.path = http.MethodDelete, p.Delete
+	case *annotations.HttpRule_Custom:
+		tr.httpMethod, tr.path = p.Custom.GetKind(), p.Custom.GetPath()
+	default:
+		return transcodeRule{}, false
+	}
+
+	return tr, tr.path != ""
+}
+
+func (t *grpcTranscoder) handle(_ any, stream grpc.ServerStream) error {
+	fullMethod, ok := grpc.MethodFromServerStream(stream)
+	if !ok {
+		return status.Error(codes.Internal, "cannot determine method")
+	}
+
+	rule, ok := t.rules[fullMethod]
+	if !ok {
+		return status.Errorf(codes.Unimplemented, "method %s is not transcoded", fullMethod)
+	}
+
+	in := dynamicpb.NewMessage(rule.method.Input())
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+
+	req, err := t.buildRequest(stream.Context(), rule, in)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	rec := newBufferedResponseWriter()
+	t.handler.ServeHTTP(rec, req)
+
+	out, meta, err := t.buildReply(rule, rec)
+
+	header := metadata.MD{}
+	if requestID := rec.header.Get("X-Request-ID"); requestID != "" {
+		header.Set("x-request-id", requestID)
+	} else if meta.RequestID != "" {
+		header.Set("x-request-id", meta.RequestID)
+	}
+
+	if meta.Partial {
+		header.Set("x-kono-partial", "true")
+	}
+
+	if header.Len() > 0 {
+		_ = stream.SetHeader(header)
+	}
+
+	if err != nil {
+		t.log.Warn("grpc transcoding failed",
+			zap.String("method", fullMethod),
+			zap.Int("status", rec.status),
+			zap.Error(err),
+		)
+
+		return err
+	}
+
+	return stream.SendMsg(out)
+}
+
+// buildRequest renders the HTTP request for a decoded gRPC input message.
+// Fields bound in the path are removed from the body and query string.
+func (t *grpcTranscoder) buildRequest(ctx context.Context, rule transcodeRule, in proto.Message) (*http.Request, error) {
+	raw, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(in)
+	if err != nil {
+		return nil, fmt.Errorf("encode input message: %w", err)
+	}
+
+	fields := make(map[string]interface{})
+	if err = json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("decode input message: %w", err)
+	}
+
+	var missing []string
+
+	path := httpRuleVarPattern.ReplaceAllStringFunc(rule.path, func(m string) string {
+		name := httpRuleVarPattern.FindStringSubmatch(m)[1]
+
+		v, ok := takeField(fields, name)
+		if !ok {
+			missing = append(missing, name)
+			return ""
+		}
+
+		return url.PathEscape(fmt.Sprint(v))
+	})
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("path fields not set: %s", strings.Join(missing, ", "))
+	}
+
+	var body []byte
+
+	switch rule.body {
+	case "":
+		query := url.Values{}
+		for k, v := range fields {
+			addQueryField(query, k, v)
+		}
+
+		if len(query) > 0 {
+			path += "?" + query.Encode()
+		}
+	case "*":
+		body, err = json.Marshal(fields)
+	default:
+		body, err = json.Marshal(fields[rule.body])
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("encode request body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, rule.httpMethod, path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for k, values := range md {
+			if strings.HasPrefix(k, ":") || strings.HasPrefix(k, "grpc-") || k == "content-type" {
+				continue
+			}
+
+			for _, v := range values {
+				req.Header.Add(k, v)
+			}
+		}
+	}
+
+	if len(body) > 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	return req, nil
+}
+
+// addQueryField adds v to query as google.api.http maps fields without a body:
+// a repeated field once per element, a nested message as dotted keys
+// ("filter.status").
+func addQueryField(query url.Values, key string, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+	case map[string]interface{}:
+		for k, nested := range v {
+			addQueryField(query, key+"."+k, nested)
+		}
+	case []interface{}:
+		for _, elem := range v {
+			addQueryField(query, key, elem)
+		}
+	case float64:
+		query.Add(key, strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		query.Add(key, fmt.Sprint(v))
+	}
+}
+
+// buildReply maps the gateway response onto the method's output message: the data of
+// a ClientResponse envelope for aggregated flows, or the raw body otherwise.
+func (t *grpcTranscoder) buildReply(rule transcodeRule, rec *bufferedResponseWriter) (proto.Message, ResponseMeta, error) {
+	payload := rec.body.Bytes()
+
+	var envelope ClientResponse
+	if json.Unmarshal(payload, &envelope) == nil && (envelope.Data != nil || envelope.Errors != nil) {
+		payload = envelope.Data
+	}
+
+	if rec.status >= http.StatusBadRequest {
+		msg := http.StatusText(rec.status)
+		if len(envelope.Errors) > 0 {
+			errs := make([]string, len(envelope.Errors))
+			for i, e := range envelope.Errors {
+				errs[i] = e.String()
+			}
+
+			msg = strings.Join(errs, ", ")
+		}
+
+		return nil, envelope.Meta, status.Error(httpGRPCCode(rec.status), msg)
+	}
+
+	out := dynamicpb.NewMessage(rule.method.Output())
+
+	if len(payload) == 0 || string(payload) == "null" {
+		return out, envelope.Meta, nil
+	}
+
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(payload, out); err != nil {
+		return nil, envelope.Meta, status.Errorf(codes.Internal, "map response to %s: %v", rule.method.Output().FullName(), err)
+	}
+
+	return out, envelope.Meta, nil
+}
+
+// takeField removes and returns a value addressed by a dotted field path.
+func takeField(fields map[string]interface{}, name string) (interface{}, bool) {
+	parts := strings.Split(name, ".")
+
+	for _, part := range parts[:len(parts)-1] {
+		nested, ok := fields[part].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		fields = nested
+	}
+
+	last := parts[len(parts)-1]
+
+	v, ok := fields[last]
+	if ok {
+		delete(fields, last)
+	}
+
+	return v, ok
+}
+
+// httpGRPCCode maps HTTP statuses to gRPC codes following the gRPC HTTP mapping.
+func httpGRPCCode(status int) codes.Code {
+	switch status {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusRequestEntityTooLarge:
+		return codes.ResourceExhausted
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	default:
+		return codes.Unknown
+	}
+}
+
+// bufferedResponseWriter collects a response in memory for transcoding.
+type bufferedResponseWriter struct {
+	header http.Header
+	body   bytes.Buffer
+	status int
+}
+
+func newBufferedResponseWriter() *bufferedResponseWriter {
+	return &bufferedResponseWriter{header: make(http.Header), status: http.StatusOK}
+}
+
+func (w *bufferedResponseWriter) Header() http.Header { return w.header }
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) { return w.body.Write(b) }
+
+func (w *bufferedResponseWriter) WriteHeader(status int) { w.status = status }
@@ -0,0 +1,168 @@
+package kono
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+var _ = Describe("NewGRPCHandler", func() {
+	var (
+		descriptorSet string
+		conn          *grpc.ClientConn
+		server        *grpc.Server
+		gotRequest    *http.Request
+		gotBody       []byte
+		respond       func(w http.ResponseWriter)
+	)
+
+	BeforeEach(func() {
+		descriptorSet = writeTestDescriptorSet()
+		gotRequest, gotBody = nil, nil
+
+		respond = func(w http.ResponseWriter) {
+			w.Header().Set("X-Request-ID", "req-1")
+			_ = json.NewEncoder(w).Encode(ClientResponse{Data: json.RawMessage(`{"id":7,"name":"ann","extra":true}`)})
+		}
+
+		backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotRequest = r
+			gotBody, _ = io.ReadAll(r.Body)
+			respond(w)
+		})
+
+		h, err := NewGRPCHandler(GRPCServerConfig{DescriptorSet: descriptorSet}, backend, zap.NewNop())
+		Expect(err).NotTo(HaveOccurred())
+
+		server = grpc.NewServer(grpc.UnknownServiceHandler(h))
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		go func() { _ = server.Serve(lis) }()
+
+		conn, err = grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		_ = conn.Close()
+		server.Stop()
+	})
+
+	invoke := func(method string, fill func(in *dynamicpb.Message)) (*dynamicpb.Message, metadata.MD, error) {
+		md, err := loadProtoMethod(descriptorSet, "test.v1.Users/"+method)
+		Expect(err).NotTo(HaveOccurred())
+
+		in := dynamicpb.NewMessage(md.Input())
+		fill(in)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-tenant", "acme")
+
+		var header metadata.MD
+
+		out := dynamicpb.NewMessage(md.Output())
+		err = conn.Invoke(ctx, "/test.v1.Users/"+method, in, out, grpc.Header(&header))
+
+		return out, header, err
+	}
+
+	setField := func(m *dynamicpb.Message, name string, v protoreflect.Value) {
+		m.Set(m.Descriptor().Fields().ByName(protoreflect.Name(name)), v)
+	}
+
+	It("binds path fields and maps the data envelope onto the reply", func() {
+		out, header, err := invoke("GetUser", func(in *dynamicpb.Message) {
+			setField(in, "id", protoreflect.ValueOfInt64(7))
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(gotRequest.Method).To(Equal(http.MethodGet))
+		Expect(gotRequest.URL.Path).To(Equal("/users/7"))
+		Expect(gotRequest.URL.RawQuery).To(BeEmpty())
+		Expect(gotRequest.Header.Get("X-Tenant")).To(Equal("acme"))
+		Expect(gotBody).To(BeEmpty())
+
+		Expect(out.Get(out.Descriptor().Fields().ByName("id")).Int()).To(Equal(int64(7)))
+		Expect(out.Get(out.Descriptor().Fields().ByName("name")).String()).To(Equal("ann"))
+		Expect(header.Get("x-request-id")).To(ConsistOf("req-1"))
+	})
+
+	It("sends the whole message as the JSON body for body \"*\"", func() {
+		_, _, err := invoke("CreateUser", func(in *dynamicpb.Message) {
+			setField(in, "id", protoreflect.ValueOfInt64(7))
+			setField(in, "name", protoreflect.ValueOfString("ann"))
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(gotRequest.Method).To(Equal(http.MethodPost))
+		Expect(gotRequest.URL.Path).To(Equal("/users"))
+		Expect(gotRequest.Header.Get("Content-Type")).To(Equal("application/json"))
+		Expect(gotBody).To(MatchJSON(`{"id":"7","name":"ann"}`))
+	})
+
+	It("rejects calls that leave a path field unset", func() {
+		_, _, err := invoke("GetUser", func(*dynamicpb.Message) {})
+
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		Expect(gotRequest).To(BeNil())
+	})
+
+	It("maps gateway error responses to gRPC status codes", func() {
+		respond = func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(ClientResponse{Errors: []ClientError{ClientErrUpstreamUnavailable}})
+		}
+
+		_, _, err := invoke("GetUser", func(in *dynamicpb.Message) {
+			setField(in, "id", protoreflect.ValueOfInt64(7))
+		})
+
+		st := status.Convert(err)
+		Expect(st.Code()).To(Equal(codes.Unavailable))
+		Expect(st.Message()).To(Equal("UPSTREAM_UNAVAILABLE"))
+	})
+
+	It("answers methods without an http annotation with Unimplemented", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		err := conn.Invoke(ctx, "/test.v1.Users/DeleteUser", &emptypb.Empty{}, &emptypb.Empty{})
+		Expect(status.Code(err)).To(Equal(codes.Unimplemented))
+	})
+})
+
+var _ = Describe("addQueryField", func() {
+	It("repeats list elements and flattens nested messages", func() {
+		query := url.Values{}
+
+		addQueryField(query, "ids", []interface{}{"1", "2"})
+		addQueryField(query, "filter", map[string]interface{}{"status": "active", "range": map[string]interface{}{"min": float64(1000000)}})
+		addQueryField(query, "unset", nil)
+
+		Expect(query).To(Equal(url.Values{
+			"ids":              {"1", "2"},
+			"filter.status":    {"active"},
+			"filter.range.min": {"1000000"},
+		}))
+	})
+})
@@ -3,12 +3,14 @@ package kono
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"math"
 	"net"
 	"net/http"
+	"net/url"
 	"regexp"
 	"slices"
 	"strconv"
@@ -17,7 +19,7 @@ import (
 	"time"
 
 	"github.com/go-chi/chi/v5"
-	"go.opentelemetry.io/otel"
+	"github.com/jmespath/go-jmespath"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/propagation"
@@ -37,6 +39,7 @@ type upstreamResponse struct {
 	status  int
 	headers http.Header
 	body    []byte
+	latency time.Duration
 	err     *upstreamError
 }
 
@@ -58,6 +61,8 @@ const (
 	upstreamReadError    upstreamErrorKind = "read_error"
 	upstreamBodyTooLarge upstreamErrorKind = "body_too_large"
 	upstreamCircuitOpen  upstreamErrorKind = "circuit_open"
+	upstreamOverloaded   upstreamErrorKind = "overloaded"
+	upstreamMalformed    upstreamErrorKind = "malformed"
 	upstreamInternal     upstreamErrorKind = "internal"
 )
 
@@ -65,6 +70,11 @@ type httpUpstream struct {
 	cfg            upstreamConfig
 	state          upstreamState
 	circuitBreaker *circuitbreaker.CircuitBreaker
+	limiter        concurrencyLimiter // nil unless concurrency is limited.
+	outliers       *outlierDetector
+	discovery      *hostDiscovery
+	discovered     atomic.Pointer[hostSet]
+	split          atomic.Pointer[hostSplit]
 	metrics        *metric.Metrics
 	log            *zap.Logger
 	client         *http.Client
@@ -86,28 +96,114 @@ type upstreamConfig struct {
 
 	lbMode lbMode
 	policy upstreamPolicy
+
+	// hostCooldown is how long a failed host is skipped; zero disables skipping.
+	hostCooldown time.Duration
+
+	// outlierDetection builds the outlier detector of each discovered host set.
+	outlierDetection OutlierDetectionConfig
+
+	// hashKey and ring are set for consistent_hash balancing.
+	hashKey hashKey
+	ring    *hashRing
+
+	// responseFormat is formatJSON or formatXML; XML bodies are decoded to JSON.
+	responseFormat responseFormat
+
+	// selector projects the response body before aggregation; nil keeps it as is.
+	selector *jmespath.JMESPath
+}
+
+// hostSet is the host list of an upstream together with the state indexed by it.
+// Discovered upstreams swap in a new hostSet whenever their hosts change, so a
+// call keeps using the set it selected its host from.
+type hostSet struct {
+	hosts    []string
+	state    *upstreamState
+	ring     *hashRing
+	outliers *outlierDetector
 }
 
 type upstreamState struct {
 	currentHostIdx    int64
 	activeConnections []int64
+	// unhealthyUntil holds per host the UnixNano time until which it cools down
+	// after a failure, and ejectedUntil the time until which the outlier detector
+	// keeps it out. A host is skipped while either lies ahead.
+	unhealthyUntil []int64
+	ejectedUntil   []int64
 }
 
 func (u *httpUpstream) name() string { return u.cfg.name }
 
+// hostSetFor returns the hosts of the group the request is split into, or those
+// of hostSet for upstreams without groups.
+func (u *httpUpstream) hostSetFor(req *http.Request) *hostSet {
+	if split := u.split.Load(); split != nil {
+		return split.pick(req)
+	}
+
+	return u.hostSet()
+}
+
+// hostSet returns the hosts discovered last, or the configured ones.
+func (u *httpUpstream) hostSet() *hostSet {
+	if hs := u.discovered.Load(); hs != nil {
+		return hs
+	}
+
+	return &hostSet{hosts: u.cfg.hosts, state: &u.state, ring: u.cfg.ring, outliers: u.outliers}
+}
+
+// setHosts replaces the discovered hosts of the upstream. Balancing and health
+// state start over for the new set.
+func (u *httpUpstream) setHosts(hosts []string) {
+	u.discovered.Store(u.newHostSet(hosts))
+}
+
+func (u *httpUpstream) newHostSet(hosts []string) *hostSet {
+	state := buildUpstreamState(hosts)
+	hs := &hostSet{
+		hosts:    hosts,
+		state:    &state,
+		outliers: buildOutlierDetector(u.cfg.outlierDetection, len(hosts)),
+	}
+
+	if u.cfg.lbMode == lbModeConsistentHash {
+		hs.ring = newHashRing(hosts)
+	}
+
+	return hs
+}
+
+// Close stops host discovery.
+func (u *httpUpstream) Close() error {
+	if u.discovery != nil {
+		u.discovery.stop()
+	}
+
+	return nil
+}
+
 // call executes the request with retries and circuit breaker protection,
 // then validates the final response against the upstream's own policy.
-func (u *httpUpstream) call(ctx context.Context, original *http.Request, originalBody []byte) *upstreamResponse {
+func (u *httpUpstream) call(ctx context.Context, original *http.Request, originalBody []byte) (resp *upstreamResponse) {
 	log := u.log.With(
 		zap.String("upstream", u.cfg.name),
 		zap.String("request_id", requestIDFromContext(original.Context())),
 	)
 
-	resp := u.callWithRetry(ctx, original, originalBody, log)
+	if !acquireSlot(u.limiter) {
+		log.Warn("upstream concurrency limit reached")
+		return overloadedResponse()
+	}
+
+	start := time.Now()
+	defer func() { releaseSlot(u.limiter, time.Since(start), resp) }()
 
-	u.updateCircuitBreaker(resp, log)
+	resp = u.callWithRetry(ctx, original, originalBody, log)
 
-	// Policy is applied after the circuit breaker update intentionally:
+	// Policy is applied after the circuit breaker updates of the attempts intentionally:
 	// a misconfigured allowedStatuses or requireBody should not cause the breaker to open.
 	u.applyPolicy(ctx, resp)
 
@@ -140,7 +236,8 @@ func (u *httpUpstream) callWithRetry(ctx context.Context, original *http.Request
 			}
 		}
 
-		resp = u.doCall(ctx, original, originalBody, log)
+		resp = u.hedgedCall(ctx, original, originalBody, log)
+		u.updateCircuitBreaker(resp, log)
 
 		if resp.err == nil && !slices.Contains(retry.retryOnStatuses, resp.status) {
 			break
@@ -162,15 +259,88 @@ func (u *httpUpstream) callWithRetry(ctx context.Context, original *http.Request
 	return resp
 }
 
+// hedgedCall performs doCall and, when the policy enables hedging and the request
+// is idempotent, starts a second attempt if the first is still running after
+// hedgeDelay. The hedge avoids the host of the first attempt, which consistent_hash
+// would otherwise pick again. The first successful response wins and the other
+// attempt is cancelled; otherwise the last failure is returned.
+func (u *httpUpstream) hedgedCall(ctx context.Context, original *http.Request, originalBody []byte, log *zap.Logger) *upstreamResponse {
+	delay := u.cfg.policy.hedgeDelay
+	if delay <= 0 || !isIdempotent(u.requestMethod(original)) {
+		return u.doCall(ctx, original, originalBody, nil, log)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var first atomic.Int64
+	first.Store(-1)
+
+	results := make(chan *upstreamResponse, 2)
+	attempt := func() { results <- u.doCall(ctx, original, originalBody, &first, log) }
+
+	go attempt()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	pending := 1
+
+	var resp *upstreamResponse
+
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			log.Debug("hedging upstream request", zap.Duration("delay", delay))
+			u.metrics.IncUpstreamHedgesTotal(routeFromContext(ctx), u.cfg.name)
+
+			pending++
+
+			go attempt()
+		case resp = <-results:
+			if resp.err == nil {
+				return resp
+			}
+
+			pending--
+		}
+	}
+
+	return resp
+}
+
+func (u *httpUpstream) requestMethod(original *http.Request) string {
+	if u.cfg.method != "" {
+		return u.cfg.method
+	}
+
+	return original.Method
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// updateCircuitBreaker records the outcome of an attempt the breaker let through.
+// Canceled and overloaded attempts say nothing about the upstream and count as
+// neither a success nor a failure.
 func (u *httpUpstream) updateCircuitBreaker(resp *upstreamResponse, log *zap.Logger) {
 	if u.circuitBreaker == nil {
 		return
 	}
 
-	if resp.err != nil && u.isBreakerFailure(resp.err) {
+	switch {
+	case resp.err != nil && u.isBreakerFailure(resp.err):
 		log.Error("upstream request failed, recording circuit breaker failure")
 		u.circuitBreaker.OnFailure()
-	} else {
+	case resp.err != nil && isBreakerNeutral(resp.err):
+		u.circuitBreaker.OnNeutral()
+	default:
 		u.circuitBreaker.OnSuccess()
 	}
 
@@ -204,18 +374,37 @@ func (u *httpUpstream) applyPolicy(ctx context.Context, resp *upstreamResponse)
 	}
 }
 
-func (u *httpUpstream) doCall(ctx context.Context, original *http.Request, originalBody []byte, log *zap.Logger) *upstreamResponse {
+// doCall sends one attempt to a selected host. With first set, the attempt records
+// its host there when it is the first one, and moves off that host otherwise.
+func (u *httpUpstream) doCall(
+	ctx context.Context,
+	original *http.Request,
+	originalBody []byte,
+	first *atomic.Int64,
+	log *zap.Logger,
+) (resp *upstreamResponse) {
 	ctx, cancel := context.WithTimeout(ctx, u.cfg.timeout)
 	defer cancel()
 
-	selectedHost := u.selectHost(log)
+	hs := u.hostSetFor(original)
+	if len(hs.hosts) == 0 {
+		return &upstreamResponse{err: &upstreamError{kind: upstreamConnection, err: errNoHosts}}
+	}
+
+	selectedHost := u.selectHostFor(hs, original, log)
+	if first != nil && !first.CompareAndSwap(-1, selectedHost) && first.Load() == selectedHost {
+		selectedHost = u.skipUnhealthy(hs, (selectedHost+1)%int64(len(hs.hosts)))
+	}
+
+	start := time.Now()
+	defer func() { u.recordHostResult(hs, selectedHost, resp, time.Since(start), log) }()
 
 	if u.cfg.lbMode == lbModeLeastConns {
-		atomic.AddInt64(&u.state.activeConnections[selectedHost], 1)
-		defer atomic.AddInt64(&u.state.activeConnections[selectedHost], -1)
+		atomic.AddInt64(&hs.state.activeConnections[selectedHost], 1)
+		defer atomic.AddInt64(&hs.state.activeConnections[selectedHost], -1)
 	}
 
-	req, err := u.newRequest(ctx, original, originalBody, u.cfg.hosts[selectedHost])
+	req, err := u.newRequest(ctx, original, originalBody, hs.hosts[selectedHost])
 	if err != nil {
 		return &upstreamResponse{err: &upstreamError{kind: upstreamInternal, err: err}}
 	}
@@ -225,10 +414,16 @@ func (u *httpUpstream) doCall(ctx context.Context, original *http.Request, origi
 		attribute.String("http.method", req.Method),
 		attribute.String("http.url", req.URL.String()),
 		attribute.String("server.address", req.URL.Host),
-		attribute.String("kono.upstream.host", u.cfg.hosts[selectedHost]),
+		attribute.String("kono.upstream.host", hs.hosts[selectedHost]),
 	)
 
-	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	tracePropagatorFromContext(ctx).Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	if hook := upstreamHookFromContext(ctx); hook != nil {
+		if req, err = hook(req); err != nil {
+			return &upstreamResponse{err: &upstreamError{kind: upstreamInternal, err: err}}
+		}
+	}
 
 	httpResp, err := u.client.Do(req)
 	if err != nil {
@@ -253,6 +448,10 @@ func (u *httpUpstream) doCall(ctx context.Context, original *http.Request, origi
 	}
 
 	body, uerr := u.readBody(ctx, httpResp.Body, log)
+	if uerr == nil {
+		body, uerr = u.project(body)
+	}
+
 	if uerr != nil {
 		span.RecordError(uerr.Unwrap())
 		span.SetStatus(codes.Error, uerr.Error())
@@ -260,9 +459,15 @@ func (u *httpUpstream) doCall(ctx context.Context, original *http.Request, origi
 		return &upstreamResponse{status: httpResp.StatusCode, err: uerr}
 	}
 
+	headers := u.filterHeaders(httpResp.Header)
+	if u.cfg.responseFormat == formatXML {
+		headers.Set("Content-Type", "application/json")
+		headers.Del("Content-Length")
+	}
+
 	return &upstreamResponse{
 		status:  httpResp.StatusCode,
-		headers: u.filterHeaders(httpResp.Header),
+		headers: headers,
 		body:    body,
 	}
 }
@@ -294,11 +499,46 @@ func (u *httpUpstream) readBody(ctx context.Context, body io.ReadCloser, log *za
 	return data, nil
 }
 
-func (u *httpUpstream) filterHeaders(headers http.Header) http.Header {
-	if len(u.cfg.policy.headerBlacklist) == 0 {
-		return headers.Clone()
+// project decodes XML bodies when configured, then applies the select expression
+// to the JSON body. Empty bodies are passed through so that require_body keeps its
+// meaning.
+func (u *httpUpstream) project(body []byte) ([]byte, *upstreamError) {
+	if u.cfg.responseFormat == formatXML && len(body) > 0 {
+		decoded, err := decodeXML(body)
+		if err != nil {
+			return nil, &upstreamError{kind: upstreamMalformed, err: fmt.Errorf("decode xml body: %w", err)}
+		}
+
+		body = decoded
+	}
+
+	return projectBody(u.cfg.selector, body)
+}
+
+func projectBody(selector *jmespath.JMESPath, body []byte) ([]byte, *upstreamError) {
+	if selector == nil || len(body) == 0 {
+		return body, nil
+	}
+
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, &upstreamError{kind: upstreamMalformed, err: fmt.Errorf("select requires a JSON body: %w", err)}
+	}
+
+	selected, err := selector.Search(data)
+	if err != nil {
+		return nil, &upstreamError{kind: upstreamMalformed, err: fmt.Errorf("apply select expression: %w", err)}
+	}
+
+	projected, err := json.Marshal(selected)
+	if err != nil {
+		return nil, &upstreamError{kind: upstreamInternal, err: fmt.Errorf("marshal selected value: %w", err)}
 	}
 
+	return projected, nil
+}
+
+func (u *httpUpstream) filterHeaders(headers http.Header) http.Header {
 	filtered := make(http.Header, len(headers))
 	for header, values := range headers {
 		if _, blocked := u.cfg.policy.headerBlacklist[header]; !blocked {
@@ -306,6 +546,8 @@ func (u *httpUpstream) filterHeaders(headers http.Header) http.Header {
 		}
 	}
 
+	removeHopByHopHeaders(filtered, headers)
+
 	return filtered
 }
 
@@ -328,15 +570,34 @@ func (u *httpUpstream) isBreakerFailure(uerr *upstreamError) bool {
 	switch uerr.kind {
 	case upstreamTimeout, upstreamConnection, upstreamBadStatus:
 		return true
-	case upstreamCanceled, upstreamReadError, upstreamBodyTooLarge, upstreamCircuitOpen, upstreamInternal:
+	case upstreamCanceled, upstreamReadError, upstreamBodyTooLarge, upstreamCircuitOpen, upstreamOverloaded,
+		upstreamMalformed, upstreamInternal:
+		return false
+	default:
 		return false
+	}
+}
+
+func isBreakerNeutral(uerr *upstreamError) bool {
+	switch uerr.kind {
+	case upstreamCanceled, upstreamCircuitOpen, upstreamOverloaded:
+		return true
 	default:
 		return false
 	}
 }
 
 func (u *httpUpstream) newRequest(ctx context.Context, original *http.Request, originalBody []byte, targetHost string) (*http.Request, error) {
-	path := expandPathParams(u.cfg.path, original)
+	path, err := expandUpstreamRefs(u.cfg.path, pipelineResultsFromContext(original.Context()))
+	if err != nil {
+		return nil, err
+	}
+
+	path, err = expandPathParams(path, original)
+	if err != nil {
+		return nil, err
+	}
+
 	path = strings.TrimPrefix(path, "/")
 
 	var hostPath string
@@ -369,50 +630,222 @@ func (u *httpUpstream) newRequest(ctx context.Context, original *http.Request, o
 	return target, nil
 }
 
+var errNoHosts = errors.New("no upstream hosts available")
+
 var pathParamRegexp = regexp.MustCompile(`\{([^}]+)\}`)
 
-func expandPathParams(path string, req *http.Request) string {
-	return pathParamRegexp.ReplaceAllStringFunc(path, func(match string) string {
-		name := match[1 : len(match)-1]
+// Upstream path templates may also reference the client request outside of its
+// path params: {query.<name>} and {header.<Name>}.
+const (
+	queryParamPrefix  = "query."
+	headerParamPrefix = "header."
+)
+
+var errDotSegmentParam = errors.New("path param expands to a dot segment")
+
+// expandPathParams substitutes path params, query params and headers of the client
+// request into path. Query and header values are escaped for the part of the path
+// they land in; missing ones expand to nothing, unknown path params are kept. A
+// value of "." or ".." in the path is refused, as escaping does not stop the
+// upstream from resolving it against the segments before it.
+func expandPathParams(path string, req *http.Request) (string, error) {
+	queryStart := strings.IndexByte(path, '?')
+
+	var sb strings.Builder
+
+	last := 0
+
+	for _, loc := range pathParamRegexp.FindAllStringSubmatchIndex(path, -1) {
+		sb.WriteString(path[last:loc[0]])
+		last = loc[1]
+
+		name := path[loc[2]:loc[3]]
+		inQuery := queryStart >= 0 && loc[0] > queryStart
+
+		if value, ok := requestParam(req, name); ok {
+			if inQuery {
+				sb.WriteString(url.QueryEscape(value))
+				continue
+			}
+
+			if isDotSegment(value) {
+				return "", fmt.Errorf("%w: {%s}", errDotSegmentParam, name)
+			}
+
+			sb.WriteString(url.PathEscape(value))
+
+			continue
+		}
 
 		if value := chi.URLParam(req, name); value != "" {
-			return value
+			if !inQuery && isDotSegment(value) {
+				return "", fmt.Errorf("%w: {%s}", errDotSegmentParam, name)
+			}
+
+			sb.WriteString(value)
+
+			continue
 		}
 
-		return match
-	})
+		sb.WriteString(path[loc[0]:loc[1]])
+	}
+
+	sb.WriteString(path[last:])
+
+	return sb.String(), nil
+}
+
+func isDotSegment(value string) bool {
+	return value == "." || value == ".."
+}
+
+// requestParam resolves {query.*} and {header.*} templates; ok is false for names
+// in neither namespace.
+func requestParam(req *http.Request, name string) (string, bool) {
+	if key, ok := strings.CutPrefix(name, queryParamPrefix); ok {
+		return req.URL.Query().Get(key), true
+	}
+
+	if key, ok := strings.CutPrefix(name, headerParamPrefix); ok {
+		return req.Header.Get(key), true
+	}
+
+	return "", false
 }
 
-func (u *httpUpstream) selectHost(log *zap.Logger) int64 {
-	if len(u.cfg.hosts) == 1 {
+func (u *httpUpstream) selectHost(hs *hostSet, log *zap.Logger) int64 {
+	if len(hs.hosts) == 1 {
 		return 0
 	}
 
 	var selected int64
 
 	switch u.cfg.lbMode {
-	case lbModeRoundRobin:
-		idx := atomic.AddInt64(&u.state.currentHostIdx, 1)
-		selected = idx % int64(len(u.cfg.hosts))
+	case lbModeRoundRobin, lbModeConsistentHash, "":
+		idx := atomic.AddInt64(&hs.state.currentHostIdx, 1) - 1
+		selected = idx % int64(len(hs.hosts))
 	case lbModeLeastConns:
 		var minConns int64 = math.MaxInt64
 
-		for i := range u.cfg.hosts {
-			if c := atomic.LoadInt64(&u.state.activeConnections[i]); c < minConns {
+		for i := range hs.hosts {
+			if c := atomic.LoadInt64(&hs.state.activeConnections[i]); c < minConns {
 				minConns = c
 				selected = int64(i)
 			}
 		}
 	}
 
+	selected = u.skipUnhealthy(hs, selected)
+
 	log.Debug("host selected",
-		zap.String("host", u.cfg.hosts[selected]),
+		zap.String("host", hs.hosts[selected]),
 		zap.String("upstream", u.cfg.name),
 	)
 
 	return selected
 }
 
+// selectHostFor picks the host owning the request's hash key in consistent_hash
+// mode and defers to selectHost otherwise, or when the request carries no key.
+func (u *httpUpstream) selectHostFor(hs *hostSet, original *http.Request, log *zap.Logger) int64 {
+	if u.cfg.lbMode != lbModeConsistentHash || hs.ring == nil || len(hs.hosts) == 1 {
+		return u.selectHost(hs, log)
+	}
+
+	key := requestKey(original, u.cfg.hashKey)
+	if key == "" {
+		return u.selectHost(hs, log)
+	}
+
+	return u.skipUnhealthy(hs, hs.ring.lookup(key))
+}
+
+// requestKey reads the value of a hash or split key from the request.
+func requestKey(req *http.Request, key hashKey) string {
+	switch key.source {
+	case hashKeyIP:
+		if ip := clientIPFromContext(req.Context()); ip != "" {
+			return ip
+		}
+
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			return req.RemoteAddr
+		}
+
+		return host
+	case hashKeyHeader:
+		return req.Header.Get(key.name)
+	case hashKeyCookie:
+		if c, err := req.Cookie(key.name); err == nil {
+			return c.Value
+		}
+	}
+
+	return ""
+}
+
+// skipUnhealthy returns the first host, starting at selected, that is neither
+// cooling down after a failure nor ejected. When all hosts are, selected is kept.
+func (u *httpUpstream) skipUnhealthy(hs *hostSet, selected int64) int64 {
+	if len(hs.state.unhealthyUntil) == 0 {
+		return selected
+	}
+
+	now := time.Now().UnixNano()
+	n := int64(len(hs.hosts))
+
+	for i := range n {
+		idx := (selected + i) % n
+		if atomic.LoadInt64(&hs.state.unhealthyUntil[idx]) <= now && atomic.LoadInt64(&hs.state.ejectedUntil[idx]) <= now {
+			return idx
+		}
+	}
+
+	return selected
+}
+
+// recordHostResult starts the cooldown of a host after a connection error, timeout
+// or 5xx response and clears it after a success. It also feeds the outlier
+// detector, which may eject the host for longer; a success ends the cooldown but
+// not an ejection. Canceled attempts say nothing about the host and are ignored.
+func (u *httpUpstream) recordHostResult(hs *hostSet, host int64, resp *upstreamResponse, latency time.Duration, log *zap.Logger) {
+	if len(hs.state.unhealthyUntil) == 0 || resp == nil || (resp.err != nil && resp.err.kind == upstreamCanceled) {
+		return
+	}
+
+	failed := resp.status >= http.StatusInternalServerError ||
+		(resp.err != nil && (resp.err.kind == upstreamConnection || resp.err.kind == upstreamTimeout))
+
+	if hs.outliers != nil {
+		now := time.Now()
+		if hs.outliers.record(host, failed, latency, hs.state.ejectedUntil, now) {
+			atomic.StoreInt64(&hs.state.ejectedUntil[host], now.Add(hs.outliers.ejectionDuration).UnixNano())
+
+			log.Warn("upstream host ejected as outlier",
+				zap.String("host", hs.hosts[host]),
+				zap.Duration("ejection", hs.outliers.ejectionDuration),
+			)
+		}
+	}
+
+	if u.cfg.hostCooldown <= 0 {
+		return
+	}
+
+	if !failed {
+		atomic.StoreInt64(&hs.state.unhealthyUntil[host], 0)
+		return
+	}
+
+	atomic.StoreInt64(&hs.state.unhealthyUntil[host], time.Now().Add(u.cfg.hostCooldown).UnixNano())
+
+	log.Warn("upstream host marked unhealthy",
+		zap.String("host", hs.hosts[host]),
+		zap.Duration("cooldown", u.cfg.hostCooldown),
+	)
+}
+
 func (u *httpUpstream) resolveQueries(target, original *http.Request) {
 	targetQ := target.URL.Query()
 	originalQ := original.URL.Query()
@@ -432,6 +865,10 @@ func (u *httpUpstream) resolveQueries(target, original *http.Request) {
 		if param == "*" {
 			if rctx := chi.RouteContext(original.Context()); rctx != nil {
 				for i, key := range rctx.URLParams.Keys {
+					if key == "*" {
+						continue // wildcard remainder belongs to the path, not the query.
+					}
+
 					targetQ.Set(key, rctx.URLParams.Values[i])
 				}
 			}
@@ -467,15 +904,16 @@ func (u *httpUpstream) resolveHeaders(target, original *http.Request) error {
 	}
 
 	target.Header.Set("Content-Type", original.Header.Get("Content-Type"))
+	removeHopByHopHeaders(target.Header, original.Header)
 
-	clientIP := clientIPFromContext(original.Context())
-	if clientIP == "" {
-		clientIP = extractClientIP(original)
+	// Trust is decided by the direct peer, never by what the client claims to be.
+	peer := remoteHost(original)
+	if net.ParseIP(peer) == nil {
+		peer = clientIPFromContext(original.Context())
 	}
 
-	parsedClientIP := net.ParseIP(clientIP)
-	if parsedClientIP == nil {
-		return fmt.Errorf("cannot parse client IP %q", clientIP)
+	if net.ParseIP(peer) == nil {
+		return fmt.Errorf("cannot parse client IP %q", peer)
 	}
 
 	isTLS := original.TLS != nil
@@ -486,10 +924,11 @@ func (u *httpUpstream) resolveHeaders(target, original *http.Request) error {
 		proto = "https"
 	}
 
-	if !u.isTrustedProxy(parsedClientIP) {
-		u.setUntrustedForwardingHeaders(original, target, clientIP, proto, port)
+	if !isLocalPeer(original) && !isTrustedIP(net.ParseIP(peer), u.cfg.trustedProxies) {
+		removeClientAddressHeaders(target.Header)
+		u.setUntrustedForwardingHeaders(original, target, peer, proto, port)
 	} else {
-		u.appendTrustedForwardingHeaders(original, target, clientIP, proto, port)
+		u.appendTrustedForwardingHeaders(original, target, peer, proto, port)
 	}
 
 	return nil
@@ -547,16 +986,6 @@ func (u *httpUpstream) appendTrustedForwardingHeaders(original, target *http.Req
 	}
 }
 
-func (u *httpUpstream) isTrustedProxy(ip net.IP) bool {
-	for _, cidr := range u.cfg.trustedProxies {
-		if cidr.Contains(ip) {
-			return true
-		}
-	}
-
-	return false
-}
-
 func (u *httpUpstream) resolvePort(host string, isTLS bool) string {
 	_, port, err := net.SplitHostPort(host)
 	if err != nil {
@@ -585,10 +1014,24 @@ func isValidPort(p string) bool {
 
 // proxy implements proxyCapable for streaming passthrough flows.
 func (u *httpUpstream) proxy(ctx context.Context, w http.ResponseWriter, original *http.Request) error {
-	selectedHost := u.selectHost(u.log)
-	host := u.cfg.hosts[selectedHost]
+	if !acquireSlot(u.limiter) {
+		return errUpstreamOverloaded
+	}
+	// Stream durations say nothing about backend load, so no latency is reported.
+	defer releaseSlot(u.limiter, 0, nil)
+
+	hs := u.hostSetFor(original)
+	if len(hs.hosts) == 0 {
+		return errNoHosts
+	}
+
+	host := hs.hosts[u.selectHost(hs, u.log)]
+
+	path, err := expandPathParams(u.cfg.path, original)
+	if err != nil {
+		return err
+	}
 
-	path := expandPathParams(u.cfg.path, original)
 	path = strings.TrimPrefix(path, "/")
 
 	hostPath := host
@@ -624,7 +1067,7 @@ func (u *httpUpstream) proxy(ctx context.Context, w http.ResponseWriter, origina
 		attribute.String("kono.upstream.host", host),
 	)
 
-	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	tracePropagatorFromContext(ctx).Inject(ctx, propagation.HeaderCarrier(req.Header))
 
 	resp, err := u.streamClient.Do(req)
 	if err != nil {
@@ -640,17 +1083,19 @@ func (u *httpUpstream) proxy(ctx context.Context, w http.ResponseWriter, origina
 		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
 	}
 
-	for k, vv := range resp.Header {
-		if _, skip := hopByHopHeaders[k]; skip {
-			continue
-		}
+	copyResponseHeaders(w.Header(), resp.Header)
 
-		for _, v := range vv {
-			w.Header().Add(k, v)
-		}
+	// A known length is kept so that download clients can report progress. Event streams
+	// are open-ended and additionally ask reverse proxies in front of kono not to buffer.
+	w.Header().Del("Content-Length")
+
+	switch {
+	case isEventStream(resp.Header):
+		w.Header().Set("X-Accel-Buffering", "no")
+	case resp.ContentLength >= 0:
+		w.Header().Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
 	}
 
-	w.Header().Del("Content-Length")
 	w.WriteHeader(resp.StatusCode)
 
 	if err = streamCopy(w, resp.Body); err != nil {
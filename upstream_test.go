@@ -3,6 +3,7 @@ package kono
 import (
 	"context"
 	"crypto/tls"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/jmespath/go-jmespath"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"go.uber.org/zap"
@@ -66,6 +68,49 @@ var _ = Describe("httpUpstream", func() {
 				Expect(up.resolveHeaders(target, orig)).To(Succeed())
 				Expect(target.Header.Get("X-Forwarded-For")).To(Equal("1.2.3.4"))
 			})
+
+			It("does not trust a client that claims a trusted address", func() {
+				orig = requestWithClientIP(http.MethodGet, "http://example.com/test", "1.2.3.4:12345", "10.0.0.1")
+				orig.Header.Set("X-Forwarded-For", "10.0.0.1")
+				target, _ = http.NewRequest(orig.Method, orig.URL.String(), nil)
+
+				Expect(up.resolveHeaders(target, orig)).To(Succeed())
+				Expect(target.Header.Get("X-Forwarded-For")).To(Equal("1.2.3.4"))
+			})
+
+			It("drops client-supplied address headers forwarded by a wildcard", func() {
+				up = newTestUpstream("", withForwardHeaders("*"), withTrustedProxies(mustParseCIDR("10.0.0.0/8")))
+				orig, _ = http.NewRequest(http.MethodGet, "http://example.com/test", nil)
+				orig.RemoteAddr = "1.2.3.4:12345"
+				orig.Header.Set("X-Real-IP", "10.0.0.1")
+				orig.Header.Set("X-Forwarded-Prefix", "/admin")
+				orig.Header.Set("X-Custom", "kept")
+				target, _ = http.NewRequest(orig.Method, orig.URL.String(), nil)
+
+				Expect(up.resolveHeaders(target, orig)).To(Succeed())
+				Expect(target.Header.Get("X-Real-IP")).To(BeEmpty())
+				Expect(target.Header.Get("X-Forwarded-Prefix")).To(BeEmpty())
+				Expect(target.Header.Get("X-Custom")).To(Equal("kept"))
+			})
+		})
+
+		It("strips hop-by-hop headers from forwarded requests", func() {
+			up = newTestUpstream("", withForwardHeaders("*"))
+			orig, _ = http.NewRequest(http.MethodGet, "http://example.com/test", nil)
+			orig.RemoteAddr = "1.2.3.4:12345"
+			orig.Header.Set("Connection", "X-Hop")
+			orig.Header.Set("X-Hop", "1")
+			orig.Header.Set("Upgrade", "h2c")
+			orig.Header.Set("Proxy-Authorization", "Basic Zm9v")
+			orig.Header.Set("X-Custom", "kept")
+			target, _ = http.NewRequest(orig.Method, orig.URL.String(), nil)
+
+			Expect(up.resolveHeaders(target, orig)).To(Succeed())
+
+			for _, h := range []string{"Connection", "X-Hop", "Upgrade", "Proxy-Authorization"} {
+				Expect(target.Header.Get(h)).To(BeEmpty(), h)
+			}
+			Expect(target.Header.Get("X-Custom")).To(Equal("kept"))
 		})
 
 		Context("when proxy is trusted", func() {
@@ -225,13 +270,49 @@ var _ = Describe("httpUpstream", func() {
 			req, _ := http.NewRequest(http.MethodGet, "/items/123", nil)
 			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
 
-			Expect(expandPathParams("/items/{id}", req)).To(Equal("/items/123"))
+			path, err := expandPathParams("/items/{id}", req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(path).To(Equal("/items/123"))
 		})
 
 		It("preserves unknown params verbatim", func() {
 			req, _ := http.NewRequest(http.MethodGet, "/items/123", nil)
 
-			Expect(expandPathParams("/items/{unknown}", req)).To(Equal("/items/{unknown}"))
+			path, err := expandPathParams("/items/{unknown}", req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(path).To(Equal("/items/{unknown}"))
+		})
+
+		It("substitutes client query params and headers", func() {
+			req, _ := http.NewRequest(http.MethodGet, "/items?page=2&q=a%26b", nil)
+			req.Header.Set("X-Tenant", "acme corp")
+
+			path, err := expandPathParams("/{header.X-Tenant}/items?page={query.page}&q={query.q}", req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(path).To(Equal("/acme%20corp/items?page=2&q=a%26b"))
+		})
+
+		It("expands missing query params and headers to nothing", func() {
+			req, _ := http.NewRequest(http.MethodGet, "/items", nil)
+
+			path, err := expandPathParams("/items?page={query.page}&t={header.X-Tenant}", req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(path).To(Equal("/items?page=&t="))
+		})
+
+		It("refuses values that expand to a dot segment", func() {
+			req, _ := http.NewRequest(http.MethodGet, "/items?dir=..", nil)
+			req.Header.Set("X-Tenant", "..")
+
+			_, err := expandPathParams("/tenants/{header.X-Tenant}/items", req)
+			Expect(err).To(MatchError(errDotSegmentParam))
+
+			_, err = expandPathParams("/items/{query.dir}", req)
+			Expect(err).To(MatchError(errDotSegmentParam))
+
+			path, err := expandPathParams("/items?dir={query.dir}", req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(path).To(Equal("/items?dir=.."))
 		})
 	})
 
@@ -258,6 +339,7 @@ var _ = Describe("httpUpstream", func() {
 		Entry("read error does not count", upstreamReadError, false),
 		Entry("internal does not count", upstreamInternal, false),
 		Entry("circuit open does not count", upstreamCircuitOpen, false),
+		Entry("malformed does not count", upstreamMalformed, false),
 	)
 
 	It("treats nil error as non-failure for breaker", func() {
@@ -274,7 +356,7 @@ var _ = Describe("httpUpstream", func() {
 			}
 
 			for range 5 {
-				Expect(up.selectHost(zap.NewNop())).To(Equal(int64(0)))
+				Expect(up.selectHost(up.hostSet(), zap.NewNop())).To(Equal(int64(0)))
 			}
 		})
 
@@ -290,7 +372,7 @@ var _ = Describe("httpUpstream", func() {
 
 			seen := make(map[int64]int)
 			for range 6 {
-				seen[up.selectHost(zap.NewNop())]++
+				seen[up.selectHost(up.hostSet(), zap.NewNop())]++
 			}
 
 			for _, count := range seen {
@@ -298,6 +380,123 @@ var _ = Describe("httpUpstream", func() {
 			}
 		})
 
+		It("skips hosts cooling down after a failure", func() {
+			up := &httpUpstream{
+				cfg: upstreamConfig{
+					hosts:        []string{"a", "b", "c"},
+					lbMode:       lbModeRoundRobin,
+					hostCooldown: time.Minute,
+				},
+				state:   buildUpstreamState([]string{"a", "b", "c"}),
+				metrics: testMetrics,
+				log:     zap.NewNop(),
+			}
+
+			up.recordHostResult(up.hostSet(), 1, &upstreamResponse{err: &upstreamError{kind: upstreamConnection}}, 0, zap.NewNop())
+
+			var picked []int64
+			for range 3 {
+				picked = append(picked, up.selectHost(up.hostSet(), zap.NewNop()))
+			}
+
+			Expect(picked).To(Equal([]int64{0, 2, 2}))
+
+			up.recordHostResult(up.hostSet(), 1, &upstreamResponse{status: http.StatusOK}, 0, zap.NewNop())
+			Expect(up.selectHost(up.hostSet(), zap.NewNop())).To(Equal(int64(0)))
+			Expect(up.selectHost(up.hostSet(), zap.NewNop())).To(Equal(int64(1)))
+		})
+
+		It("keeps the selected host when every host is cooling down", func() {
+			up := &httpUpstream{
+				cfg: upstreamConfig{
+					hosts:        []string{"a", "b"},
+					hostCooldown: time.Minute,
+				},
+				state:   buildUpstreamState([]string{"a", "b"}),
+				metrics: testMetrics,
+				log:     zap.NewNop(),
+			}
+
+			up.recordHostResult(up.hostSet(), 0, &upstreamResponse{status: http.StatusBadGateway}, 0, zap.NewNop())
+			up.recordHostResult(up.hostSet(), 1, &upstreamResponse{err: &upstreamError{kind: upstreamTimeout}}, 0, zap.NewNop())
+
+			Expect(up.selectHost(up.hostSet(), zap.NewNop())).To(Equal(int64(0)))
+			Expect(up.selectHost(up.hostSet(), zap.NewNop())).To(Equal(int64(1)))
+		})
+
+		It("retries on the next healthy host", func() {
+			var hits []string
+
+			failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				hits = append(hits, "failing")
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer failing.Close()
+
+			healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				hits = append(hits, "healthy")
+				_, _ = w.Write([]byte(`{}`))
+			}))
+			defer healthy.Close()
+
+			up := newTestUpstream(failing.URL,
+				withHosts(failing.URL, healthy.URL),
+				withLBMode(lbModeRoundRobin, 2),
+				withPolicy(upstreamPolicy{retry: retryPolicy{maxRetries: 1}}),
+			)
+			up.cfg.hostCooldown = time.Minute
+			up.state.unhealthyUntil = make([]int64, 2)
+			up.state.ejectedUntil = make([]int64, 2)
+
+			for range 2 {
+				resp := up.call(context.Background(), httptest.NewRequest(http.MethodGet, "/", nil), nil)
+				Expect(resp.err).To(BeNil())
+			}
+
+			Expect(hits).To(Equal([]string{"failing", "healthy", "healthy"}))
+		})
+
+		It("pins requests with the same hash key to one host", func() {
+			hosts := []string{"a", "b", "c"}
+			up := &httpUpstream{
+				cfg: upstreamConfig{
+					hosts:   hosts,
+					lbMode:  lbModeConsistentHash,
+					hashKey: hashKey{source: hashKeyHeader, name: "X-Session"},
+					ring:    newHashRing(hosts),
+				},
+				state:   buildUpstreamState(hosts),
+				metrics: testMetrics,
+				log:     zap.NewNop(),
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Session", "s-1")
+
+			first := up.selectHostFor(up.hostSet(), req, zap.NewNop())
+			for range 5 {
+				Expect(up.selectHostFor(up.hostSet(), req, zap.NewNop())).To(Equal(first))
+			}
+
+			Expect(up.selectHostFor(up.hostSet(), httptest.NewRequest(http.MethodGet, "/", nil), zap.NewNop())).To(Equal(int64(0)))
+			Expect(up.selectHostFor(up.hostSet(), httptest.NewRequest(http.MethodGet, "/", nil), zap.NewNop())).To(Equal(int64(1)))
+		})
+
+		DescribeTable("reads the hash key from the request",
+			func(key hashKey, expected string) {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.RemoteAddr = "10.0.0.9:5555"
+				req.Header.Set("X-Session", "hdr")
+				req.AddCookie(&http.Cookie{Name: "sid", Value: "cookie"})
+
+				Expect(requestKey(req, key)).To(Equal(expected))
+			},
+			Entry("client ip", hashKey{source: hashKeyIP}, "10.0.0.9"),
+			Entry("header", hashKey{source: hashKeyHeader, name: "X-Session"}, "hdr"),
+			Entry("cookie", hashKey{source: hashKeyCookie, name: "sid"}, "cookie"),
+			Entry("missing cookie", hashKey{source: hashKeyCookie, name: "other"}, ""),
+		)
+
 		It("prefers idle host in least-connections mode", func() {
 			up := &httpUpstream{
 				cfg: upstreamConfig{
@@ -311,7 +510,7 @@ var _ = Describe("httpUpstream", func() {
 				log:     zap.NewNop(),
 			}
 
-			Expect(up.selectHost(zap.NewNop())).To(Equal(int64(1)))
+			Expect(up.selectHost(up.hostSet(), zap.NewNop())).To(Equal(int64(1)))
 		})
 	})
 
@@ -387,6 +586,107 @@ var _ = Describe("httpUpstream", func() {
 			Expect(calls.Load()).To(Equal(int32(2)))
 		})
 
+		It("opens the circuit breaker on the error rate within the window", func() {
+			var calls atomic.Int32
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				// Every other call fails: a 50% error rate but never two failures in a row.
+				if calls.Add(1)%2 == 0 {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				_, _ = w.Write([]byte(`{}`))
+			}))
+			defer server.Close()
+
+			cb := circuitbreaker.NewWithOptions(circuitbreaker.Options{
+				ResetTimeout: time.Minute,
+				Window:       time.Minute,
+				ErrorRate:    0.4,
+				MinRequests:  6,
+			})
+			up := newTestUpstream(server.URL, withCircuitBreaker(cb))
+
+			for range 10 {
+				up.call(context.Background(), httptest.NewRequest(http.MethodGet, "/", nil), nil)
+			}
+
+			Expect(calls.Load()).To(Equal(int32(6)))
+			Expect(cb.State()).To(Equal(circuitbreaker.Open))
+		})
+
+		It("closes the circuit breaker only after all half-open trials succeed", func() {
+			cb := circuitbreaker.NewWithOptions(circuitbreaker.Options{
+				MaxFailures:    1,
+				ResetTimeout:   10 * time.Millisecond,
+				HalfOpenTrials: 2,
+			})
+
+			cb.OnFailure()
+			time.Sleep(20 * time.Millisecond)
+
+			Expect(cb.Allow()).To(BeTrue())
+			Expect(cb.Allow()).To(BeTrue())
+			Expect(cb.Allow()).To(BeFalse())
+
+			cb.OnSuccess()
+			Expect(cb.State()).To(Equal(circuitbreaker.HalfOpen))
+
+			cb.OnSuccess()
+			Expect(cb.State()).To(Equal(circuitbreaker.Closed))
+		})
+
+		It("hands a canceled half-open trial back to the circuit breaker", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			}))
+			defer server.Close()
+
+			cb := circuitbreaker.New(1, 10*time.Millisecond)
+			up := newTestUpstream(server.URL, withCircuitBreaker(cb))
+
+			cb.OnFailure()
+			time.Sleep(20 * time.Millisecond)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(20*time.Millisecond, cancel)
+
+			resp := up.call(ctx, httptest.NewRequest(http.MethodGet, "/", nil), nil)
+
+			Expect(resp.err.kind).To(Equal(upstreamCanceled))
+			Expect(cb.State()).To(Equal(circuitbreaker.HalfOpen))
+			Expect(cb.Allow()).To(BeTrue())
+		})
+
+		It("fails fast once max_concurrent calls are in flight", func() {
+			arrived := make(chan struct{})
+			release := make(chan struct{})
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				arrived <- struct{}{}
+				<-release
+				_, _ = w.Write([]byte(`{}`))
+			}))
+			defer server.Close()
+
+			up := newTestUpstream(server.URL, withTimeout(time.Second))
+			up.limiter = buildConcurrencyLimiter(UpstreamConfig{MaxConcurrent: 1})
+
+			done := make(chan *upstreamResponse)
+			go func() { done <- up.call(context.Background(), httptest.NewRequest(http.MethodGet, "/", nil), nil) }()
+			<-arrived
+
+			resp := up.call(context.Background(), httptest.NewRequest(http.MethodGet, "/", nil), nil)
+			Expect(resp.err).ToNot(BeNil())
+			Expect(resp.err.kind).To(Equal(upstreamOverloaded))
+
+			close(release)
+			Expect((<-done).err).To(BeNil())
+
+			go func() { <-arrived }()
+			Expect(up.call(context.Background(), httptest.NewRequest(http.MethodGet, "/", nil), nil).err).To(BeNil())
+		})
+
 		It("recovers after circuit breaker reset timeout", func() {
 			resetTimeout := 50 * time.Millisecond
 			cb := circuitbreaker.New(1, resetTimeout)
@@ -419,4 +719,188 @@ var _ = Describe("httpUpstream", func() {
 			Expect(r3.err).To(BeNil())
 		})
 	})
+
+	Describe("proxy", func() {
+		It("keeps a known upstream Content-Length", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Length", "11")
+				_, _ = w.Write([]byte("binary-blob"))
+			}))
+			defer server.Close()
+
+			up := newTestUpstream(server.URL)
+			up.streamClient = http.DefaultClient
+
+			rec := httptest.NewRecorder()
+			Expect(up.proxy(context.Background(), rec, httptest.NewRequest(http.MethodGet, "/", nil))).To(Succeed())
+
+			Expect(rec.Header().Get("Content-Length")).To(Equal("11"))
+			Expect(rec.Body.String()).To(Equal("binary-blob"))
+		})
+
+		It("disables proxy buffering for event streams", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				_, _ = w.Write([]byte("data: hello\n\n"))
+			}))
+			defer server.Close()
+
+			up := newTestUpstream(server.URL)
+			up.streamClient = http.DefaultClient
+
+			rec := httptest.NewRecorder()
+			Expect(up.proxy(context.Background(), rec, httptest.NewRequest(http.MethodGet, "/", nil))).To(Succeed())
+
+			Expect(rec.Header().Get("X-Accel-Buffering")).To(Equal("no"))
+			Expect(rec.Header().Get("Content-Length")).To(BeEmpty())
+			Expect(rec.Body.String()).To(Equal("data: hello\n\n"))
+		})
+	})
+
+	Describe("project", func() {
+		It("applies the select expression to the JSON body", func() {
+			up := &httpUpstream{cfg: upstreamConfig{selector: jmespath.MustCompile("data.items[*].{id: id}")}}
+
+			body, uerr := up.project([]byte(`{"data":{"items":[{"id":1,"name":"a"},{"id":2,"name":"b"}]}}`))
+
+			Expect(uerr).To(BeNil())
+			Expect(body).To(MatchJSON(`[{"id":1},{"id":2}]`))
+		})
+
+		It("keeps the body untouched without a selector", func() {
+			up := &httpUpstream{}
+
+			body, uerr := up.project([]byte(`not json`))
+
+			Expect(uerr).To(BeNil())
+			Expect(string(body)).To(Equal("not json"))
+		})
+
+		It("decodes XML bodies before selecting", func() {
+			up := &httpUpstream{cfg: upstreamConfig{
+				responseFormat: formatXML,
+				selector:       jmespath.MustCompile("item[*].id"),
+			}}
+
+			body, uerr := up.project([]byte(`<items><item><id>1</id></item><item><id>2</id></item></items>`))
+
+			Expect(uerr).To(BeNil())
+			Expect(body).To(MatchJSON(`["1","2"]`))
+		})
+
+		It("reports a malformed error for invalid XML", func() {
+			up := &httpUpstream{cfg: upstreamConfig{responseFormat: formatXML}}
+
+			_, uerr := up.project([]byte(`{"id":1}`))
+
+			Expect(uerr).ToNot(BeNil())
+			Expect(uerr.kind).To(Equal(upstreamMalformed))
+		})
+
+		It("reports a malformed error for a non-JSON body", func() {
+			up := &httpUpstream{cfg: upstreamConfig{selector: jmespath.MustCompile("data")}}
+
+			_, uerr := up.project([]byte(`<html></html>`))
+
+			Expect(uerr).ToNot(BeNil())
+			Expect(uerr.kind).To(Equal(upstreamMalformed))
+		})
+	})
+
+	Describe("hedgedCall", func() {
+		var (
+			slow, fast *httptest.Server
+			slowHits   atomic.Int32
+			fastHits   atomic.Int32
+		)
+
+		BeforeEach(func() {
+			slowHits.Store(0)
+			fastHits.Store(0)
+
+			slow = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				slowHits.Add(1)
+				select {
+				case <-time.After(300 * time.Millisecond):
+					_, _ = w.Write([]byte(`{"from":"slow"}`))
+				case <-r.Context().Done():
+				}
+			}))
+			fast = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				fastHits.Add(1)
+				_, _ = w.Write([]byte(`{"from":"fast"}`))
+			}))
+		})
+
+		AfterEach(func() {
+			slow.Close()
+			fast.Close()
+		})
+
+		newHedged := func(opts ...func(*httpUpstream)) *httpUpstream {
+			opts = append([]func(*httpUpstream){
+				withHosts(slow.URL, fast.URL),
+				withLBMode(lbModeRoundRobin, 2),
+				withPolicy(upstreamPolicy{hedgeDelay: 20 * time.Millisecond}),
+			}, opts...)
+
+			return newTestUpstream(slow.URL, opts...)
+		}
+
+		It("answers from the hedge when the first host is slow", func() {
+			up := newHedged()
+
+			start := time.Now()
+			resp := up.call(context.Background(), httptest.NewRequest(http.MethodGet, "/", nil), nil)
+
+			Expect(resp.err).To(BeNil())
+			Expect(string(resp.body)).To(Equal(`{"from":"fast"}`))
+			Expect(time.Since(start)).To(BeNumerically("<", 200*time.Millisecond))
+			Expect(slowHits.Load()).To(Equal(int32(1)))
+		})
+
+		It("hedges on another host than consistent_hash picked", func() {
+			hosts := []string{slow.URL, fast.URL}
+			ring := newHashRing(hosts)
+
+			up := newHedged(func(u *httpUpstream) {
+				u.cfg.lbMode = lbModeConsistentHash
+				u.cfg.hashKey = hashKey{source: hashKeyHeader, name: "X-Session"}
+				u.cfg.ring = ring
+			})
+
+			key := "s-0"
+			for i := 1; ring.lookup(key) != 0; i++ {
+				key = fmt.Sprintf("s-%d", i)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Session", key)
+
+			resp := up.call(context.Background(), req, nil)
+
+			Expect(resp.err).To(BeNil())
+			Expect(string(resp.body)).To(Equal(`{"from":"fast"}`))
+			Expect(slowHits.Load()).To(Equal(int32(1)))
+		})
+
+		It("does not hedge fast responses", func() {
+			up := newHedged(withHosts(fast.URL, slow.URL))
+
+			resp := up.call(context.Background(), httptest.NewRequest(http.MethodGet, "/", nil), nil)
+
+			Expect(resp.err).To(BeNil())
+			Consistently(slowHits.Load, 50*time.Millisecond).Should(BeZero())
+		})
+
+		It("does not hedge non-idempotent requests", func() {
+			up := newHedged(withMethod(http.MethodPost))
+
+			resp := up.call(context.Background(), httptest.NewRequest(http.MethodPost, "/", nil), nil)
+
+			Expect(resp.err).To(BeNil())
+			Expect(string(resp.body)).To(Equal(`{"from":"slow"}`))
+			Expect(fastHits.Load()).To(BeZero())
+		})
+	})
 })
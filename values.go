@@ -1,23 +1,35 @@
 package kono
 
-import "context"
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/starwalkn/kono/sdk"
+)
 
 type contextKey uint8
 
 const (
-	contextKeyClientIP contextKey = iota
-	contextKeyRequestID
+	contextKeyRequestID contextKey = iota
 	contextKeyRoute
 	contextKeyFingerprint
+	contextKeyPipelineResults
+	contextKeyListener
+	contextKeyUpstreamHook
+	contextKeyScriptRequest
+	contextKeyTracePropagator
+	contextKeyAccessRecord
 )
 
 func withClientIP(ctx context.Context, ip string) context.Context {
-	return context.WithValue(ctx, contextKeyClientIP, ip)
+	return sdk.WithClientIP(ctx, ip)
 }
 
 func clientIPFromContext(ctx context.Context) string {
-	ip, _ := ctx.Value(contextKeyClientIP).(string)
-	return ip
+	return sdk.ClientIPFromContext(ctx)
 }
 
 func withRequestID(ctx context.Context, id string) context.Context {
@@ -46,3 +58,72 @@ func fingerprintFromContext(ctx context.Context) string {
 	fingerprint, _ := ctx.Value(contextKeyFingerprint).(string)
 	return fingerprint
 }
+
+func withPipelineResults(ctx context.Context, results pipelineResults) context.Context {
+	return context.WithValue(ctx, contextKeyPipelineResults, results)
+}
+
+func pipelineResultsFromContext(ctx context.Context) pipelineResults {
+	results, _ := ctx.Value(contextKeyPipelineResults).(pipelineResults)
+	return results
+}
+
+// WithListener marks requests accepted by the named listener so that flows bound
+// to other listeners do not match them. Servers set it from http.Server.BaseContext.
+func WithListener(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, contextKeyListener, name)
+}
+
+// listenerFromContext returns the listener name, DefaultListener when unset.
+func listenerFromContext(ctx context.Context) string {
+	if name, ok := ctx.Value(contextKeyListener).(string); ok {
+		return name
+	}
+
+	return DefaultListener
+}
+
+func withUpstreamHook(ctx context.Context, hook upstreamHook) context.Context {
+	return context.WithValue(ctx, contextKeyUpstreamHook, hook)
+}
+
+func upstreamHookFromContext(ctx context.Context) upstreamHook {
+	hook, _ := ctx.Value(contextKeyUpstreamHook).(upstreamHook)
+	return hook
+}
+
+// withScriptRequest keeps the client request in the context of a script call, for
+// the auxiliary requests of kono.call.
+func withScriptRequest(ctx context.Context, req *http.Request) context.Context {
+	return context.WithValue(ctx, contextKeyScriptRequest, req)
+}
+
+func scriptRequestFromContext(ctx context.Context) *http.Request {
+	req, _ := ctx.Value(contextKeyScriptRequest).(*http.Request)
+	return req
+}
+
+// withTracePropagator sets the propagator writing the trace headers of upstream
+// requests made for ctx.
+func withTracePropagator(ctx context.Context, p propagation.TextMapPropagator) context.Context {
+	return context.WithValue(ctx, contextKeyTracePropagator, p)
+}
+
+// tracePropagatorFromContext returns the propagator of the flow handling ctx, or
+// the global one outside a flow.
+func tracePropagatorFromContext(ctx context.Context) propagation.TextMapPropagator {
+	if p, ok := ctx.Value(contextKeyTracePropagator).(propagation.TextMapPropagator); ok {
+		return p
+	}
+
+	return otel.GetTextMapPropagator()
+}
+
+func withAccessRecord(ctx context.Context, rec *accessRecord) context.Context {
+	return context.WithValue(ctx, contextKeyAccessRecord, rec)
+}
+
+func accessRecordFromContext(ctx context.Context) *accessRecord {
+	rec, _ := ctx.Value(contextKeyAccessRecord).(*accessRecord)
+	return rec
+}